@@ -17,10 +17,17 @@ package abi
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"runtime"
+	"sort"
+	"strings"
 
 	pb "github.com/google/go-sev-guest/proto/sevsnp"
 	"github.com/google/logger"
@@ -66,17 +73,42 @@ const (
 	// SignatureSize is the field size of SIGNATURE in an SEV-SNP attestation report.
 	SignatureSize = 512
 
-	policyOffset          = 0x08
-	policySMTBit          = 16
-	policyReserved1bit    = 17
-	policyMigrateMABit    = 18
-	policyDebugBit        = 19
-	policySingleSocketBit = 20
-
-	maxPlatformInfoBit = 1
+	policyOffset                  = 0x08
+	policySMTBit                  = 16
+	policyReserved1bit            = 17
+	policyMigrateMABit            = 18
+	policyDebugBit                = 19
+	policySingleSocketBit         = 20
+	policyCXLAllowBit             = 21
+	policyMemAES256XTSBit         = 22
+	policyRAPLDisBit              = 23
+	policyCiphertextHidingDRAMBit = 24
+	policyMaxDefinedBit           = policyCiphertextHidingDRAMBit
+	// policyMaxDefinedBitV2 is the last policy bit that firmware producing version 2 reports defines.
+	policyMaxDefinedBitV2 = policySingleSocketBit
+
+	platformInfoSMTBit              = 0
+	platformInfoTSMEBit             = 1
+	platformInfoECCBit              = 2
+	platformInfoRAPLDisabledBit     = 3
+	platformInfoCiphertextHidingBit = 4
+
+	maxPlatformInfoBit = platformInfoCiphertextHidingBit
+
+	guestFieldGuestPolicyBit = 0
+	guestFieldImageIDBit     = 1
+	guestFieldFamilyIDBit    = 2
+	guestFieldMeasurementBit = 3
+	guestFieldGuestSVNBit    = 4
+	guestFieldTCBVersionBit  = 5
+
+	maxGuestFieldSelectBit = guestFieldTCBVersionBit
+
+	maxVmpl = 3
 
 	signatureOffset = 0x2A0
 	ecdsaRSsize     = 72 // From the ECDSA-P384-SHA384 format in SEV SNP API specification.
+	p384ScalarSize  = 48
 
 	// From the ECDSA public key format in SEV SNP API specification.
 	ecdsaQXoffset = 0x04
@@ -90,6 +122,8 @@ const (
 
 	// CertTableEntrySize is the ABI size of the certificate table entry struct.
 	CertTableEntrySize = 24
+	// certTablePageSize is the granularity of the extended guest request's certificate data pages.
+	certTablePageSize = 0x1000
 
 	// GUIDSize is the byte length of a GUID's binary representation.
 	GUIDSize = 16
@@ -125,12 +159,77 @@ const (
 	sevFamily           = 0xF
 	milanExtendedModel  = 0
 	genoaExtendedModel  = 1
+	// Bergamo and Siena are models A0h-AFh, and share Genoa's KDS certificate hierarchy.
+	bergamoSienaExtendedModel = 0xA
+	// Family 1Ah products.
+	turinExtendedFamily = 0xB
+	turinExtendedModel  = 0
+	// Turin dense parts are models 10h-1Fh.
+	turinDenseExtendedModel = 1
 
 	// ExpectedReportVersion is set by the SNP API specification
 	// https://www.amd.com/system/files/TechDocs/56860.pdf
 	ExpectedReportVersion = 2
+	// ReportVersion3 is the report version that introduces the CPUID_FAM_ID, CPUID_MOD_ID, and
+	// CPUID_STEP fields in previously reserved bytes.
+	ReportVersion3 = 3
+	// LatestReportVersion is the newest report version whose layout this package knows.
+	LatestReportVersion = ReportVersion3
+)
+
+var (
+	// ErrReportSize is returned when a report is not exactly ReportSize bytes long.
+	ErrReportSize = errors.New("unexpected report size")
+	// ErrReportVersion is returned when a report's VERSION is not one this package understands.
+	ErrReportVersion = errors.New("unsupported report version")
+	// ErrReportPolicy is returned when a report's POLICY is malformed.
+	ErrReportPolicy = errors.New("malformed guest policy")
+	// ErrReportSignerInfo is returned when a report's SIGNER_INFO is malformed.
+	ErrReportSignerInfo = errors.New("malformed signer info")
+	// ErrReportVmpl is returned when a report's VMPL is greater than 3.
+	ErrReportVmpl = errors.New("vmpl out of range")
+	// ErrReportSignatureAlgo is returned when a report's SIGNATURE_ALGO is not ECDSA P-384 with
+	// SHA-384.
+	ErrReportSignatureAlgo = errors.New("unsupported signature algorithm")
+	// ErrNoCpuid is returned when the CPUID instruction is not available, e.g., on non-amd64
+	// architectures.
+	ErrNoCpuid = errors.New("cpuid is not supported")
+	// ErrReportReserved is returned when a reserved region of a report is not zero.
+	ErrReportReserved = errors.New("reserved field is not zero")
+	// ErrReportDataTooLong is returned when user data does not fit in a report's REPORT_DATA.
+	ErrReportDataTooLong = errors.New("user data is longer than REPORT_DATA")
 )
 
+// ErrUnsupportedSignatureAlgo is returned when a report's SIGNATURE_ALGO is not an algorithm this
+// package can verify. It matches ErrReportSignatureAlgo with errors.Is.
+type ErrUnsupportedSignatureAlgo struct {
+	// Algo is the report's SIGNATURE_ALGO value.
+	Algo uint32
+}
+
+func (e *ErrUnsupportedSignatureAlgo) Error() string {
+	return fmt.Sprintf("%v: report signature algorithm is %d. Expected %d", ErrReportSignatureAlgo, e.Algo,
+		SignEcdsaP384Sha384)
+}
+
+// Unwrap returns ErrReportSignatureAlgo.
+func (e *ErrUnsupportedSignatureAlgo) Unwrap() error {
+	return ErrReportSignatureAlgo
+}
+
+// ParseOptions configures how strictly attestation reports are parsed and validated.
+type ParseOptions struct {
+	// AllowUnknownVersion accepts report versions newer than LatestReportVersion. Since newer
+	// versions may define fields in bytes that are reserved in known versions, those bytes are not
+	// required to be zero. The report size and signature layout are still enforced.
+	AllowUnknownVersion bool
+}
+
+// unknownVersion returns true if version is newer than LatestReportVersion and the options allow it.
+func (o *ParseOptions) unknownVersion(version uint32) bool {
+	return o != nil && o.AllowUnknownVersion && version > LatestReportVersion
+}
+
 // CertTableHeaderEntry defines an entry of the beginning of an extended attestation report which
 // points to a specific key's certificate.
 type CertTableHeaderEntry struct {
@@ -179,6 +278,15 @@ type SnpPlatformInfo struct {
 	// TSMEEnabled represents if the platform that produced the attestation report has transparent
 	// secure memory encryption (TSME) enabled.
 	TSMEEnabled bool
+	// ECCEnabled represents if the platform that produced the attestation report is using
+	// error-correcting code memory.
+	ECCEnabled bool
+	// RAPLDisabled represents if the platform that produced the attestation report has the running
+	// average power limit (RAPL) interface disabled.
+	RAPLDisabled bool
+	// CiphertextHidingEnabled represents if the platform that produced the attestation report has
+	// ciphertext hiding enabled.
+	CiphertextHidingEnabled bool
 }
 
 // SnpPolicy represents the bitmask guest policy that governs the VM's behavior from launch.
@@ -195,6 +303,14 @@ type SnpPolicy struct {
 	Debug bool
 	// SingleSocket is true if the guest may only be active on a single socket.
 	SingleSocket bool
+	// CXLAllow is true if CXL memory may be populated with guest memory.
+	CXLAllow bool
+	// MemAES256XTS is true if the guest requires AES-256-XTS memory encryption.
+	MemAES256XTS bool
+	// RAPLDis is true if the guest requires Running Average Power Limit (RAPL) to be disabled.
+	RAPLDis bool
+	// CiphertextHidingDRAM is true if the guest requires ciphertext hiding in DRAM.
+	CiphertextHidingDRAM bool
 }
 
 // ParseSnpPolicy interprets the SEV SNP API's guest policy bitmask into an SnpPolicy struct type.
@@ -203,7 +319,7 @@ func ParseSnpPolicy(guestPolicy uint64) (SnpPolicy, error) {
 	if guestPolicy&uint64(1<<policyReserved1bit) == 0 {
 		return result, fmt.Errorf("policy[%d] is reserved, must be 1, got 0", policyReserved1bit)
 	}
-	if err := mbz64(guestPolicy, "policy", 63, 21); err != nil {
+	if err := mbz64(guestPolicy, "policy", 63, policyMaxDefinedBit+1); err != nil {
 		return result, err
 	}
 	result.ABIMinor = uint8(guestPolicy & 0xff)
@@ -212,9 +328,61 @@ func ParseSnpPolicy(guestPolicy uint64) (SnpPolicy, error) {
 	result.MigrateMA = (guestPolicy & (1 << policyMigrateMABit)) != 0
 	result.Debug = (guestPolicy & (1 << policyDebugBit)) != 0
 	result.SingleSocket = (guestPolicy & (1 << policySingleSocketBit)) != 0
+	result.CXLAllow = (guestPolicy & (1 << policyCXLAllowBit)) != 0
+	result.MemAES256XTS = (guestPolicy & (1 << policyMemAES256XTSBit)) != 0
+	result.RAPLDis = (guestPolicy & (1 << policyRAPLDisBit)) != 0
+	result.CiphertextHidingDRAM = (guestPolicy & (1 << policyCiphertextHidingDRAMBit)) != 0
 	return result, nil
 }
 
+// reportPolicyMbz checks that a report's guest policy does not use bits that the firmware producing
+// that report version does not define.
+func reportPolicyMbz(guestPolicy uint64, version uint32) error {
+	if version == ExpectedReportVersion {
+		return mbz64(guestPolicy, "policy", 63, policyMaxDefinedBitV2+1)
+	}
+	return nil
+}
+
+// SatisfiedBy returns an error naming the first component of the actual policy that does not meet
+// the minimum policy p. The ABI version in p is a floor. The MigrateMA, Debug, and SMT capabilities
+// are ceilings: actual may only allow them if p does. SingleSocket, CXLAllow, MemAES256XTS,
+// RAPLDis, and CiphertextHidingDRAM are requirements: actual must have them if p does.
+func (p SnpPolicy) SatisfiedBy(actual SnpPolicy) error {
+	minimum := uint16(p.ABIMajor)<<8 | uint16(p.ABIMinor)
+	got := uint16(actual.ABIMajor)<<8 | uint16(actual.ABIMinor)
+	if minimum > got {
+		return fmt.Errorf(
+			"required policy ABI version (%d.%d) is greater than the report's ABI version (%d.%d)",
+			p.ABIMajor, p.ABIMinor, actual.ABIMajor, actual.ABIMinor)
+	}
+	if !p.MigrateMA && actual.MigrateMA {
+		return errors.New("found unauthorized migration agent capability")
+	}
+	if !p.Debug && actual.Debug {
+		return errors.New("found unauthorized debug capability")
+	}
+	if !p.SMT && actual.SMT {
+		return errors.New("found unauthorized symmetric multithreading (SMT) capability")
+	}
+	if p.SingleSocket && !actual.SingleSocket {
+		return errors.New("required single socket restriction not present")
+	}
+	if p.CXLAllow && !actual.CXLAllow {
+		return errors.New("required CXL allowance not present")
+	}
+	if p.MemAES256XTS && !actual.MemAES256XTS {
+		return errors.New("required AES-256-XTS memory encryption not present")
+	}
+	if p.RAPLDis && !actual.RAPLDis {
+		return errors.New("required RAPL disablement not present")
+	}
+	if p.CiphertextHidingDRAM && !actual.CiphertextHidingDRAM {
+		return errors.New("required DRAM ciphertext hiding not present")
+	}
+	return nil
+}
+
 // SnpPolicyToBytes translates a structural representation of a valid SNP policy to its ABI format.
 func SnpPolicyToBytes(policy SnpPolicy) uint64 {
 	result := uint64(policy.ABIMinor) | uint64(policy.ABIMajor)<<8 | uint64(1<<policyReserved1bit)
@@ -230,15 +398,115 @@ func SnpPolicyToBytes(policy SnpPolicy) uint64 {
 	if policy.SingleSocket {
 		result |= uint64(1 << policySingleSocketBit)
 	}
+	if policy.CXLAllow {
+		result |= uint64(1 << policyCXLAllowBit)
+	}
+	if policy.MemAES256XTS {
+		result |= uint64(1 << policyMemAES256XTSBit)
+	}
+	if policy.RAPLDis {
+		result |= uint64(1 << policyRAPLDisBit)
+	}
+	if policy.CiphertextHidingDRAM {
+		result |= uint64(1 << policyCiphertextHidingDRAMBit)
+	}
+	return result
+}
+
+// GuestFieldSelect represents the GUEST_FIELD_SELECT bitmask of MSG_KEY_REQ, which selects the
+// guest-provided information that is mixed into a derived key.
+type GuestFieldSelect struct {
+	// GuestPolicy selects the guest policy.
+	GuestPolicy bool
+	// ImageID selects the image ID provided at launch.
+	ImageID bool
+	// FamilyID selects the family ID provided at launch.
+	FamilyID bool
+	// Measurement selects the measurement of the guest at launch.
+	Measurement bool
+	// GuestSVN selects the guest SVN given in the key request.
+	GuestSVN bool
+	// TCBVersion selects the TCB version given in the key request.
+	TCBVersion bool
+}
+
+// ParseGuestFieldSelect interprets the SEV SNP API's GUEST_FIELD_SELECT bitmask into a
+// GuestFieldSelect, or errors if reserved bits are set.
+func ParseGuestFieldSelect(guestFieldSelect uint64) (GuestFieldSelect, error) {
+	if err := mbz64(guestFieldSelect, "guest_field_select", 63, maxGuestFieldSelectBit+1); err != nil {
+		return GuestFieldSelect{}, err
+	}
+	return GuestFieldSelect{
+		GuestPolicy: (guestFieldSelect & (1 << guestFieldGuestPolicyBit)) != 0,
+		ImageID:     (guestFieldSelect & (1 << guestFieldImageIDBit)) != 0,
+		FamilyID:    (guestFieldSelect & (1 << guestFieldFamilyIDBit)) != 0,
+		Measurement: (guestFieldSelect & (1 << guestFieldMeasurementBit)) != 0,
+		GuestSVN:    (guestFieldSelect & (1 << guestFieldGuestSVNBit)) != 0,
+		TCBVersion:  (guestFieldSelect & (1 << guestFieldTCBVersionBit)) != 0,
+	}, nil
+}
+
+// GuestFieldSelectToBytes translates a structural representation of a guest field selection to
+// its ABI format.
+func GuestFieldSelectToBytes(g GuestFieldSelect) uint64 {
+	var result uint64
+	if g.GuestPolicy {
+		result |= uint64(1 << guestFieldGuestPolicyBit)
+	}
+	if g.ImageID {
+		result |= uint64(1 << guestFieldImageIDBit)
+	}
+	if g.FamilyID {
+		result |= uint64(1 << guestFieldFamilyIDBit)
+	}
+	if g.Measurement {
+		result |= uint64(1 << guestFieldMeasurementBit)
+	}
+	if g.GuestSVN {
+		result |= uint64(1 << guestFieldGuestSVNBit)
+	}
+	if g.TCBVersion {
+		result |= uint64(1 << guestFieldTCBVersionBit)
+	}
 	return result
 }
 
+// String returns the selected fields separated by "|", or "none" if no field is selected.
+func (g GuestFieldSelect) String() string {
+	var fields []string
+	if g.GuestPolicy {
+		fields = append(fields, "GuestPolicy")
+	}
+	if g.ImageID {
+		fields = append(fields, "ImageID")
+	}
+	if g.FamilyID {
+		fields = append(fields, "FamilyID")
+	}
+	if g.Measurement {
+		fields = append(fields, "Measurement")
+	}
+	if g.GuestSVN {
+		fields = append(fields, "GuestSVN")
+	}
+	if g.TCBVersion {
+		fields = append(fields, "TCBVersion")
+	}
+	if len(fields) == 0 {
+		return "none"
+	}
+	return strings.Join(fields, "|")
+}
+
 // ParseSnpPlatformInfo returns an interpretation of the given platform info, or an error for
 // unrecognized bits.
 func ParseSnpPlatformInfo(platformInfo uint64) (SnpPlatformInfo, error) {
 	result := SnpPlatformInfo{
-		SMTEnabled:  (platformInfo & (1 << 0)) != 0,
-		TSMEEnabled: (platformInfo & (1 << 1)) != 0,
+		SMTEnabled:              (platformInfo & (1 << platformInfoSMTBit)) != 0,
+		TSMEEnabled:             (platformInfo & (1 << platformInfoTSMEBit)) != 0,
+		ECCEnabled:              (platformInfo & (1 << platformInfoECCBit)) != 0,
+		RAPLDisabled:            (platformInfo & (1 << platformInfoRAPLDisabledBit)) != 0,
+		CiphertextHidingEnabled: (platformInfo & (1 << platformInfoCiphertextHidingBit)) != 0,
 	}
 	reserved := platformInfo & ^uint64((1<<(maxPlatformInfoBit+1))-1)
 	if reserved != 0 {
@@ -247,6 +515,28 @@ func ParseSnpPlatformInfo(platformInfo uint64) (SnpPlatformInfo, error) {
 	return result, nil
 }
 
+// SnpPlatformInfoToBytes translates a structural representation of platform info to its ABI
+// format.
+func SnpPlatformInfoToBytes(info SnpPlatformInfo) uint64 {
+	var result uint64
+	if info.SMTEnabled {
+		result |= uint64(1 << platformInfoSMTBit)
+	}
+	if info.TSMEEnabled {
+		result |= uint64(1 << platformInfoTSMEBit)
+	}
+	if info.ECCEnabled {
+		result |= uint64(1 << platformInfoECCBit)
+	}
+	if info.RAPLDisabled {
+		result |= uint64(1 << platformInfoRAPLDisabledBit)
+	}
+	if info.CiphertextHidingEnabled {
+		result |= uint64(1 << platformInfoCiphertextHidingBit)
+	}
+	return result
+}
+
 // ParseAskCert returns a struct representation of the AMD certificate format from a byte array.
 func ParseAskCert(data []byte) (*AskCert, int, error) {
 	var cert AskCert
@@ -319,25 +609,103 @@ func mbz64(data uint64, base string, hi, lo int) error {
 	return nil
 }
 
-// ReportToSignatureDER returns the signature component of an attestation report in DER format for
-// use in x509 verification.
-func ReportToSignatureDER(report []byte) ([]byte, error) {
+// checkP384Scalar returns an error if v is not a valid ECDSA P-384 signature component, i.e.,
+// in the range [1, N) for N the order of the curve.
+func checkP384Scalar(name string, v *big.Int) error {
+	if v == nil || v.Sign() <= 0 {
+		return fmt.Errorf("signature component %s must be positive", name)
+	}
+	if v.Cmp(elliptic.P384().Params().N) >= 0 {
+		return fmt.Errorf("signature component %s is not less than the P-384 order", name)
+	}
+	return nil
+}
+
+// amdRSToBigInt interprets a 72-byte little endian signature component whose bytes beyond the
+// P-384 scalar size must be zero.
+func amdRSToBigInt(name string, component []byte) (*big.Int, error) {
+	if err := mbz(component, p384ScalarSize, ecdsaRSsize); err != nil {
+		return nil, fmt.Errorf("signature component %s padding: %v", name, err)
+	}
+	v := AmdBigInt(component)
+	if err := checkP384Scalar(name, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// SignatureRS returns the R and S components of an ECDSA P-384 signature in the AMD
+// little endian format, or an error if the padding is not zero or a component is out of range.
+func SignatureRS(signature []byte) (r, s *big.Int, err error) {
+	if len(signature) < EcdsaP384Sha384SignatureSize {
+		return nil, nil, fmt.Errorf("signature size is %d bytes. Expected at least %d bytes", len(signature),
+			EcdsaP384Sha384SignatureSize)
+	}
+	if r, err = amdRSToBigInt("R", ecdsaGetR(signature)); err != nil {
+		return nil, nil, err
+	}
+	if s, err = amdRSToBigInt("S", ecdsaGetS(signature)); err != nil {
+		return nil, nil, err
+	}
+	return r, s, nil
+}
+
+// ReportSignatureRS returns the R and S components of an attestation report's ECDSA P-384
+// signature.
+func ReportSignatureRS(report []byte) (r, s *big.Int, err error) {
 	if len(report) != ReportSize {
-		return nil, fmt.Errorf("incorrect report size: %x, want %x", len(report), ReportSize)
+		return nil, nil, fmt.Errorf("incorrect report size: %x, want %x", len(report), ReportSize)
 	}
-	algo := SignatureAlgo(report)
-	if algo != SignEcdsaP384Sha384 {
-		return nil, fmt.Errorf("unknown signature algorithm: %d", algo)
+	if algo := SignatureAlgo(report); algo != SignEcdsaP384Sha384 {
+		return nil, nil, &ErrUnsupportedSignatureAlgo{Algo: algo}
+	}
+	return SignatureRS(report[signatureOffset:ReportSize])
+}
+
+// RSToSignatureDER returns the ASN.1 DER encoding of the ECDSA signature (r, s).
+func RSToSignatureDER(r, s *big.Int) ([]byte, error) {
+	if err := checkP384Scalar("R", r); err != nil {
+		return nil, err
+	}
+	if err := checkP384Scalar("S", s); err != nil {
+		return nil, err
 	}
-	signature := report[signatureOffset:ReportSize]
 	var b cryptobyte.Builder
 	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
-		b.AddASN1BigInt(AmdBigInt(ecdsaGetR(signature)))
-		b.AddASN1BigInt(AmdBigInt(ecdsaGetS(signature)))
+		b.AddASN1BigInt(r)
+		b.AddASN1BigInt(s)
 	})
 	return b.Bytes()
 }
 
+// SignatureDERToRS returns the R and S components of an ASN.1 DER encoded ECDSA P-384 signature.
+func SignatureDERToRS(der []byte) (r, s *big.Int, err error) {
+	r, s = new(big.Int), new(big.Int)
+	input := cryptobyte.String(der)
+	var inner cryptobyte.String
+	if !input.ReadASN1(&inner, asn1.SEQUENCE) || !input.Empty() ||
+		!inner.ReadASN1Integer(r) || !inner.ReadASN1Integer(s) || !inner.Empty() {
+		return nil, nil, fmt.Errorf("malformed ECDSA signature DER")
+	}
+	if err := checkP384Scalar("R", r); err != nil {
+		return nil, nil, err
+	}
+	if err := checkP384Scalar("S", s); err != nil {
+		return nil, nil, err
+	}
+	return r, s, nil
+}
+
+// ReportToSignatureDER returns the signature component of an attestation report in DER format for
+// use in x509 verification.
+func ReportToSignatureDER(report []byte) ([]byte, error) {
+	r, s, err := ReportSignatureRS(report)
+	if err != nil {
+		return nil, err
+	}
+	return RSToSignatureDER(r, s)
+}
+
 func ecdsaGetR(signature []byte) []byte {
 	return signature[0x0:0x48]
 }
@@ -346,6 +714,45 @@ func ecdsaGetS(signature []byte) []byte {
 	return signature[0x48:0x90]
 }
 
+// ChipIDString returns the hexadecimal encoding of a CHIP_ID field as used in KDS VCEK
+// certificate URLs.
+func ChipIDString(chipID []byte) string {
+	return hex.EncodeToString(chipID)
+}
+
+// IsChipIDMasked returns true if the CHIP_ID field is all zeros, which is how firmware reports
+// the chip identity when the host enables MaskChipId.
+func IsChipIDMasked(chipID []byte) bool {
+	for _, b := range chipID {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// PadReportData returns the REPORT_DATA field value for the given user data of at most
+// ReportDataSize bytes, right-padded with zeros. Longer data results in ErrReportDataTooLong.
+func PadReportData(data []byte) ([ReportDataSize]byte, error) {
+	var result [ReportDataSize]byte
+	if len(data) > ReportDataSize {
+		return result, fmt.Errorf("%w: user data is %d bytes. Expected at most %d bytes",
+			ErrReportDataTooLong, len(data), ReportDataSize)
+	}
+	copy(result[:], data)
+	return result, nil
+}
+
+// TrimReportData returns reportData without its trailing zero bytes, e.g., to display user data
+// that PadReportData padded. User data that itself ends in zeros is trimmed as well.
+func TrimReportData(reportData []byte) []byte {
+	end := len(reportData)
+	for end > 0 && reportData[end-1] == 0 {
+		end--
+	}
+	return reportData[:end]
+}
+
 func clone(b []byte) []byte {
 	result := make([]byte, len(b))
 	copy(result, b)
@@ -356,8 +763,12 @@ func signatureAlgoSlice(report []byte) []byte {
 	return report[0x34:0x38]
 }
 
-// SignatureAlgo returns the SignatureAlgo field of a raw SEV-SNP attestation report.
+// SignatureAlgo returns the SignatureAlgo field of a raw SEV-SNP attestation report, or 0 (an
+// invalid algorithm) if the report is too small to contain the field.
 func SignatureAlgo(report []byte) uint32 {
+	if len(report) < 0x38 {
+		return 0
+	}
 	return binary.LittleEndian.Uint32(signatureAlgoSlice(report))
 }
 
@@ -433,6 +844,20 @@ func ComposeSignerInfo(signerInfo SignerInfo) uint32 {
 	return result
 }
 
+// checkSignerInfoParts returns an error if the report's decomposed signer info fields are set, but
+// disagree with its signer_info field.
+func checkSignerInfoParts(r *pb.Report, info SignerInfo) error {
+	if r.SigningKey == 0 && !r.MaskChipKey && !r.AuthorKeyEn {
+		return nil
+	}
+	if r.SigningKey != uint32(info.SigningKey) || r.MaskChipKey != info.MaskChipKey ||
+		r.AuthorKeyEn != info.AuthorKeyEn {
+		return fmt.Errorf("report signer_info 0x%x disagrees with signing_key %d, mask_chip_key %v, author_key_en %v",
+			r.SignerInfo, r.SigningKey, r.MaskChipKey, r.AuthorKeyEn)
+	}
+	return nil
+}
+
 // ReportSignerInfo returns the signer info component of a SEV-SNP raw report.
 func ReportSignerInfo(data []byte) (uint32, error) {
 	if len(data) < 0x4C {
@@ -441,11 +866,65 @@ func ReportSignerInfo(data []byte) (uint32, error) {
 	return binary.LittleEndian.Uint32(data[0x48:0x4C]), nil
 }
 
+// ReportReportedTcb returns the REPORTED_TCB component of a SEV-SNP raw report.
+func ReportReportedTcb(data []byte) (uint64, error) {
+	if len(data) < 0x188 {
+		return 0, fmt.Errorf("report too small: %d", len(data))
+	}
+	return binary.LittleEndian.Uint64(data[0x180:0x188]), nil
+}
+
+// ReportChipID returns the CHIP_ID component of a SEV-SNP raw report.
+func ReportChipID(data []byte) ([]byte, error) {
+	if len(data) < 0x1E0 {
+		return nil, fmt.Errorf("report too small: %d", len(data))
+	}
+	return clone(data[0x1A0:0x1E0]), nil
+}
+
+// reportReservedMbz returns an error if any reserved region of the report in data is not zero.
+// Which regions are reserved depends on the report version.
+// Reports of an unknown version only have their signature padding checked.
+func reportReservedMbz(data []byte, version uint32, opts *ParseOptions) error {
+	mbzLo := 0x188
+	if version >= ReportVersion3 {
+		mbzLo = 0x18B
+	}
+	ranges := [][2]int{
+		{0x4C, 0x50},
+		{mbzLo, 0x1A0},
+		{0x1EB, 0x1EC},
+		{0x1EF, 0x1F0},
+		{0x1F8, signatureOffset},
+	}
+	if opts.unknownVersion(version) {
+		ranges = nil
+	}
+	if SignatureAlgo(data) == SignEcdsaP384Sha384 {
+		ranges = append(ranges, [2]int{signatureOffset + EcdsaP384Sha384SignatureSize, ReportSize})
+	}
+	for _, r := range ranges {
+		if err := mbz(data, r[0], r[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ReportToProto creates a pb.Report from the little-endian AMD SEV-SNP attestation report byte
 // array in SEV SNP ABI format for ATTESTATION_REPORT.
 func ReportToProto(data []uint8) (*pb.Report, error) {
-	if len(data) < ReportSize {
-		return nil, fmt.Errorf("array size is 0x%x, an SEV-SNP attestation report size is 0x%x", len(data), ReportSize)
+	return ReportToProtoWithOptions(data, nil)
+}
+
+// ReportToProtoWithOptions creates a pb.Report from the little-endian AMD SEV-SNP attestation
+// report byte layout, parsed according to opts. A nil opts behaves like ReportToProto. If the report
+// has an unknown version that opts allows, the proto's Raw field holds the full report so that
+// ReportToAbiBytes reproduces the signed bytes.
+func ReportToProtoWithOptions(data []uint8, opts *ParseOptions) (*pb.Report, error) {
+	if len(data) != ReportSize {
+		return nil, fmt.Errorf("%w: array size is 0x%x, an SEV-SNP attestation report size is 0x%x",
+			ErrReportSize, len(data), ReportSize)
 	}
 
 	r := &pb.Report{}
@@ -456,6 +935,9 @@ func ReportToProto(data []uint8) (*pb.Report, error) {
 	if _, err := ParseSnpPolicy(r.Policy); err != nil {
 		return nil, fmt.Errorf("malformed guest policy: %v", err)
 	}
+	if err := reportPolicyMbz(r.Policy, r.Version); err != nil {
+		return nil, fmt.Errorf("malformed guest policy: %v", err)
+	}
 	r.FamilyId = clone(data[0x10:0x20])
 	r.ImageId = clone(data[0x20:0x30])
 	r.Vmpl = binary.LittleEndian.Uint32(data[0x30:0x34])
@@ -468,9 +950,15 @@ func ReportToProto(data []uint8) (*pb.Report, error) {
 		return nil, err
 	}
 	r.SignerInfo = ComposeSignerInfo(signerInfo)
-	if err := mbz(data, 0x4C, 0x50); err != nil {
+	r.SigningKey = uint32(signerInfo.SigningKey)
+	r.MaskChipKey = signerInfo.MaskChipKey
+	r.AuthorKeyEn = signerInfo.AuthorKeyEn
+	if err := reportReservedMbz(data, r.Version, opts); err != nil {
 		return nil, err
 	}
+	if opts.unknownVersion(r.Version) {
+		r.Raw = clone(data)
+	}
 	r.ReportData = clone(data[0x50:0x90])
 	r.Measurement = clone(data[0x90:0xC0])
 	r.HostData = clone(data[0xC0:0xE0])
@@ -479,32 +967,20 @@ func ReportToProto(data []uint8) (*pb.Report, error) {
 	r.ReportId = clone(data[0x140:0x160])
 	r.ReportIdMa = clone(data[0x160:0x180])
 	r.ReportedTcb = binary.LittleEndian.Uint64(data[0x180:0x188])
-	if err := mbz(data, 0x188, 0x1A0); err != nil {
-		return nil, err
+	if r.Version >= ReportVersion3 {
+		r.CpuidFamId = uint32(data[0x188])
+		r.CpuidModId = uint32(data[0x189])
+		r.CpuidStep = uint32(data[0x18A])
 	}
 	r.ChipId = clone(data[0x1A0:0x1E0])
 	r.CommittedTcb = binary.LittleEndian.Uint64(data[0x1E0:0x1E8])
 	r.CurrentBuild = uint32(data[0x1E8])
 	r.CurrentMinor = uint32(data[0x1E9])
 	r.CurrentMajor = uint32(data[0x1EA])
-	if err := mbz(data, 0x1EB, 0x1EC); err != nil {
-		return nil, err
-	}
 	r.CommittedBuild = uint32(data[0x1EC])
 	r.CommittedMinor = uint32(data[0x1ED])
 	r.CommittedMajor = uint32(data[0x1EE])
-	if err := mbz(data, 0x1EF, 0x1F0); err != nil {
-		return nil, err
-	}
 	r.LaunchTcb = binary.LittleEndian.Uint64(data[0x1F0:0x1F8])
-	if err := mbz(data, 0x1F8, signatureOffset); err != nil {
-		return nil, err
-	}
-	if r.SignatureAlgo == SignEcdsaP384Sha384 {
-		if err := mbz(data, signatureOffset+EcdsaP384Sha384SignatureSize, ReportSize); err != nil {
-			return nil, err
-		}
-	}
 	r.Signature = clone(data[signatureOffset:ReportSize])
 	return r, nil
 }
@@ -568,20 +1044,47 @@ func checkReportSizes(r *pb.Report) error {
 }
 
 // ValidateReportFormat returns an error if the provided buffer violates structural expectations of
-// attestation report data.
+// attestation report data. The returned error wraps one of the ErrReport* values to identify which
+// expectation failed.
 func ValidateReportFormat(r []byte) error {
-	if len(r) < ReportSize {
-		return fmt.Errorf("report size is %d bytes. Expected %d bytes", len(r), ReportSize)
+	return ValidateReportFormatWithOptions(r, nil)
+}
+
+// ValidateReportFormatWithOptions is like ValidateReportFormat, but versions newer than
+// LatestReportVersion are accepted if opts allows them.
+func ValidateReportFormatWithOptions(r []byte, opts *ParseOptions) error {
+	if len(r) != ReportSize {
+		return fmt.Errorf("%w: report size is %d bytes. Expected %d bytes", ErrReportSize, len(r), ReportSize)
 	}
 
 	version := binary.LittleEndian.Uint32(r[0x00:0x04])
-	if version != ExpectedReportVersion {
-		return fmt.Errorf("report version is: %d. Expected %d", version, ExpectedReportVersion)
+	if version != ExpectedReportVersion && version != ReportVersion3 && !opts.unknownVersion(version) {
+		return fmt.Errorf("%w: report version is: %d. Expected %d or %d", ErrReportVersion, version,
+			ExpectedReportVersion, ReportVersion3)
 	}
 
 	policy := binary.LittleEndian.Uint64(r[0x08:0x10])
 	if _, err := ParseSnpPolicy(policy); err != nil {
-		return fmt.Errorf("malformed guest policy: %v", err)
+		return fmt.Errorf("%w: %v", ErrReportPolicy, err)
+	}
+	if err := reportPolicyMbz(policy, version); err != nil {
+		return fmt.Errorf("%w: %v", ErrReportPolicy, err)
+	}
+
+	if vmpl := binary.LittleEndian.Uint32(r[0x30:0x34]); vmpl > maxVmpl {
+		return fmt.Errorf("%w: report vmpl is %d. Expected 0-%d", ErrReportVmpl, vmpl, maxVmpl)
+	}
+
+	if algo := SignatureAlgo(r); algo != SignEcdsaP384Sha384 {
+		return &ErrUnsupportedSignatureAlgo{Algo: algo}
+	}
+
+	if _, err := ParseSignerInfo(binary.LittleEndian.Uint32(r[0x48:0x4C])); err != nil {
+		return fmt.Errorf("%w: %v", ErrReportSignerInfo, err)
+	}
+
+	if err := reportReservedMbz(r, version, opts); err != nil {
+		return fmt.Errorf("%w: %v", ErrReportReserved, err)
 	}
 	return nil
 }
@@ -596,6 +1099,17 @@ func ReportToAbiBytes(r *pb.Report) ([]byte, error) {
 	}
 	// Zero-initialized array fills all the reserved fields with the required zeros.
 	data := make([]byte, ReportSize)
+	if len(r.Raw) != 0 {
+		if r.Version <= LatestReportVersion {
+			return nil, fmt.Errorf("raw report bytes are only kept for report versions newer than %d, got version %d",
+				LatestReportVersion, r.Version)
+		}
+		if len(r.Raw) != ReportSize {
+			return nil, fmt.Errorf("raw report length is %d, expect %d", len(r.Raw), ReportSize)
+		}
+		// Unknown fields in the reserved bytes are kept. Known fields below take precedence.
+		copy(data, r.Raw[:signatureOffset])
+	}
 
 	binary.LittleEndian.PutUint32(data[0x00:0x04], r.Version)
 	binary.LittleEndian.PutUint32(data[0x04:0x08], r.GuestSvn)
@@ -607,7 +1121,11 @@ func ReportToAbiBytes(r *pb.Report) ([]byte, error) {
 	binary.LittleEndian.PutUint64(data[0x38:0x40], r.CurrentTcb)
 	binary.LittleEndian.PutUint64(data[0x40:0x48], r.PlatformInfo)
 
-	if _, err := ParseSignerInfo(r.SignerInfo); err != nil {
+	signerInfo, err := ParseSignerInfo(r.SignerInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSignerInfoParts(r, signerInfo); err != nil {
 		return nil, err
 	}
 	binary.LittleEndian.PutUint32(data[0x48:0x4C], r.SignerInfo)
@@ -619,6 +1137,23 @@ func ReportToAbiBytes(r *pb.Report) ([]byte, error) {
 	copy(data[0x140:0x160], r.ReportId[:])
 	copy(data[0x160:0x180], r.ReportIdMa[:])
 	binary.LittleEndian.PutUint64(data[0x180:0x188], r.ReportedTcb)
+	if r.Version >= ReportVersion3 {
+		if r.CpuidFamId >= (1 << 8) {
+			return nil, fmt.Errorf("cpuid_fam_id field must fit in a byte, got %d", r.CpuidFamId)
+		}
+		if r.CpuidModId >= (1 << 8) {
+			return nil, fmt.Errorf("cpuid_mod_id field must fit in a byte, got %d", r.CpuidModId)
+		}
+		if r.CpuidStep >= (1 << 8) {
+			return nil, fmt.Errorf("cpuid_step field must fit in a byte, got %d", r.CpuidStep)
+		}
+		data[0x188] = byte(r.CpuidFamId)
+		data[0x189] = byte(r.CpuidModId)
+		data[0x18A] = byte(r.CpuidStep)
+	} else if r.CpuidFamId != 0 || r.CpuidModId != 0 || r.CpuidStep != 0 {
+		return nil, fmt.Errorf("report version %d does not have CPUID fields, but got family 0x%x, model 0x%x, stepping 0x%x",
+			r.Version, r.CpuidFamId, r.CpuidModId, r.CpuidStep)
+	}
 	copy(data[0x1A0:0x1E0], r.ChipId[:])
 	binary.LittleEndian.PutUint64(data[0x1E0:0x1E8], r.CommittedTcb)
 	if r.CurrentBuild >= (1 << 8) {
@@ -651,13 +1186,27 @@ func ReportToAbiBytes(r *pb.Report) ([]byte, error) {
 	return data, nil
 }
 
-// SignedComponent returns the bytes of the SnpAttestationReport that are signed by the AMD-SP.
+// SignedComponent returns the bytes of the SnpAttestationReport that are signed by the AMD-SP, or
+// nil if the report is too small to contain them.
 func SignedComponent(report []byte) []byte {
+	if len(report) < signatureOffset {
+		return nil
+	}
 	// Table 21 of https://www.amd.com/system/files/TechDocs/56860.pdf shows the signature is over
 	// all bytes prior to the signature in the report.
 	return report[0:signatureOffset]
 }
 
+// SignedComponentDigest returns the SHA-384 digest of the bytes of the SnpAttestationReport that
+// are signed by the AMD-SP, i.e., the digest that the report's ECDSA P-384 signature covers.
+func SignedComponentDigest(report []byte) ([sha512.Size384]byte, error) {
+	if len(report) != ReportSize {
+		return [sha512.Size384]byte{}, fmt.Errorf("%w: report size is %d bytes. Expected %d bytes",
+			ErrReportSize, len(report), ReportSize)
+	}
+	return sha512.Sum384(SignedComponent(report)), nil
+}
+
 func reverse(d []byte) []byte {
 	for i := 0; i < len(d)/2; i++ {
 		swapIndex := len(d) - i - 1
@@ -688,6 +1237,19 @@ func EcdsaPublicKeyToBytes(key *ecdsa.PublicKey) ([]byte, error) {
 	return result, nil
 }
 
+// EcdsaPublicKeyDigest returns the SHA-384 digest of the AMD SEV ABI format of the ECDSA P-384
+// public key, i.e., the value the firmware reports as ID_KEY_DIGEST or AUTHOR_KEY_DIGEST for it.
+func EcdsaPublicKeyDigest(key *ecdsa.PublicKey) ([IDKeyDigestSize]byte, error) {
+	if key == nil || key.Curve == nil {
+		return [IDKeyDigestSize]byte{}, fmt.Errorf("ecdsa public key is nil")
+	}
+	pubkey, err := EcdsaPublicKeyToBytes(key)
+	if err != nil {
+		return [IDKeyDigestSize]byte{}, err
+	}
+	return sha512.Sum384(pubkey), nil
+}
+
 // AmdBigInt returns a given AMD format little endian big integer as a big.Int.
 func AmdBigInt(b []byte) *big.Int {
 	return new(big.Int).SetBytes(reverse(clone(b)))
@@ -699,12 +1261,28 @@ func SetSignature(r, s *big.Int, report []byte) error {
 	if len(report) != ReportSize {
 		return fmt.Errorf("unexpected report size: %x, want %x", len(report), ReportSize)
 	}
+	if err := checkP384Scalar("R", r); err != nil {
+		return err
+	}
+	if err := checkP384Scalar("S", s); err != nil {
+		return err
+	}
 	signature := report[signatureOffset:ReportSize]
 	copy(ecdsaGetR(signature), bigIntToAMDRS(r))
 	copy(ecdsaGetS(signature), bigIntToAMDRS(s))
 	return nil
 }
 
+// SetSignatureDER sets the signature component of the SnpAttestationReport from an ASN.1 DER
+// encoded ECDSA P-384 signature, such as the output of ecdsa.SignASN1. Useful for testing.
+func SetSignatureDER(der []byte, report []byte) error {
+	r, s, err := SignatureDERToRS(der)
+	if err != nil {
+		return err
+	}
+	return SetSignature(r, s, report)
+}
+
 // Unmarshal populates a CertTableHeaderEntry from its ABI representation.
 func (h *CertTableHeaderEntry) Unmarshal(data []byte) error {
 	if len(data) < CertTableEntrySize {
@@ -758,13 +1336,44 @@ func ParseSnpCertTableHeader(certs []byte) ([]CertTableHeaderEntry, error) {
 	// Double-check that each offset is after the header.
 	for i, entry := range entries {
 		if entry.Offset < uint32(index) {
-			return nil, fmt.Errorf("cert table entry %d has invalid offset into header (size %d): %d",
-				i, entry.Offset, index)
+			return nil, fmt.Errorf("cert table entry %d has invalid offset into header (size %d): %d, guid=%s",
+				i, index, entry.Offset, entry.GUID)
 		}
 	}
 	return entries, nil
 }
 
+// checkCertTableRanges returns an error if any cert table entry's byte range runs past the end of
+// the certificate data block of the given size, or if any two entries' byte ranges overlap.
+func checkCertTableRanges(entries []CertTableHeaderEntry, size int) error {
+	for i, entry := range entries {
+		// Widen to avoid uint32 overflow.
+		if uint64(entry.Offset)+uint64(entry.Length) > uint64(size) {
+			return fmt.Errorf("cert table entry %d specifies a byte range outside the certificate data block (size %d): offset=%d, length=%d, guid=%s",
+				i, size, entry.Offset, entry.Length, entry.GUID)
+		}
+	}
+	for i, left := range entries {
+		if left.Length == 0 {
+			continue
+		}
+		for j := i + 1; j < len(entries); j++ {
+			right := entries[j]
+			if right.Length == 0 {
+				continue
+			}
+			// Widen to avoid uint32 overflow.
+			leftEnd := uint64(left.Offset) + uint64(left.Length)
+			rightEnd := uint64(right.Offset) + uint64(right.Length)
+			if uint64(left.Offset) < rightEnd && uint64(right.Offset) < leftEnd {
+				return fmt.Errorf("cert table entries %d (offset=%d, length=%d) and %d (offset=%d, length=%d) overlap, guids=%s and %s",
+					i, left.Offset, left.Length, j, right.Offset, right.Length, left.GUID, right.GUID)
+			}
+		}
+	}
+	return nil
+}
+
 // Unmarshal populates the certTable with the (GUID, Blob) pairs represented in the given bytes.
 // The format of the bytes is specified by the SEV SNP API for extended guest requests.
 func (c *CertTable) Unmarshal(certs []byte) error {
@@ -772,12 +1381,12 @@ func (c *CertTable) Unmarshal(certs []byte) error {
 	if err != nil {
 		return err
 	}
-	for i, entry := range certTableHeader {
+	if err := checkCertTableRanges(certTableHeader, len(certs)); err != nil {
+		return err
+	}
+	for _, entry := range certTableHeader {
 		var next CertTableEntry
 		copy(next.GUID[:], entry.GUID[:])
-		if entry.Offset+entry.Length > uint32(len(certs)) {
-			return fmt.Errorf("cert table entry %d specifies a byte range outside the certificate data block (size %d): offset=%d, length%d", i, len(certs), entry.Offset, entry.Length)
-		}
 		next.RawCert = make([]byte, entry.Length)
 		copy(next.RawCert, certs[entry.Offset:entry.Offset+entry.Length])
 		c.Entries = append(c.Entries, next)
@@ -800,30 +1409,77 @@ func (c *CertTable) GetByGUIDString(guid string) ([]byte, error) {
 	return nil, fmt.Errorf("cert not found for GUID %s", guid)
 }
 
-// CertsFromProto returns the CertTable represented in the given certificate chain.
+// CertsFromProto returns the CertTable represented in the given certificate chain, and panics if
+// the chain does not represent one.
+//
+// Deprecated: Use CertTableFromProto, which returns an error instead.
 func CertsFromProto(chain *pb.CertificateChain) *CertTable {
-	c := &CertTable{}
-	if len(chain.GetArkCert()) != 0 {
-		c.Entries = append(c.Entries,
-			CertTableEntry{GUID: uuid.MustParse(ArkGUID), RawCert: chain.GetArkCert()})
+	c, err := CertTableFromProto(chain)
+	if err != nil {
+		panic(err)
 	}
-	if len(chain.GetAskCert()) != 0 {
-		c.Entries = append(c.Entries,
-			CertTableEntry{GUID: uuid.MustParse(AskGUID), RawCert: chain.GetAskCert()})
+	return c
+}
+
+// CertTableFromProto returns the CertTable represented in the given certificate chain. Its entries
+// are in the chain's EntryOrder, so that the table that Proto represents marshals to the same
+// bytes. Entries that EntryOrder does not list follow: the ARK, ASK, VCEK, and VLEK, then the
+// extras sorted by GUID, since map iteration order is random. An Extras key that is not a GUID, or
+// an EntryOrder GUID that names no certificate of the chain, is an error.
+func CertTableFromProto(chain *pb.CertificateChain) (*CertTable, error) {
+	known := map[string][]byte{
+		ArkGUID:  chain.GetArkCert(),
+		AskGUID:  chain.GetAskCert(),
+		VcekGUID: chain.GetVcekCert(),
+		VlekGUID: chain.GetVlekCert(),
+	}
+	// cert returns the certificate that the chain has for guid, if any.
+	cert := func(guid string) ([]byte, bool) {
+		if cert, ok := known[guid]; ok {
+			return cert, len(cert) != 0
+		}
+		cert, ok := chain.GetExtras()[guid]
+		return cert, ok
 	}
-	if len(chain.GetVcekCert()) != 0 {
-		c.Entries = append(c.Entries,
-			CertTableEntry{GUID: uuid.MustParse(VcekGUID), RawCert: chain.GetVcekCert()})
+	c := &CertTable{}
+	seen := make(map[string]bool)
+	add := func(guid string, cert []byte) error {
+		g, err := uuid.Parse(guid)
+		if err != nil {
+			return fmt.Errorf("certificate chain entry %q is not a GUID: %v", guid, err)
+		}
+		seen[guid] = true
+		c.Entries = append(c.Entries, CertTableEntry{GUID: g, RawCert: cert})
+		return nil
 	}
-	if len(chain.GetVlekCert()) != 0 {
-		c.Entries = append(c.Entries,
-			CertTableEntry{GUID: uuid.MustParse(VlekGUID), RawCert: chain.GetVlekCert()})
+	for _, guid := range chain.GetEntryOrder() {
+		if seen[guid] {
+			continue
+		}
+		cert, ok := cert(guid)
+		if !ok {
+			return nil, fmt.Errorf("certificate chain entry order lists %s, which has no certificate", guid)
+		}
+		if err := add(guid, cert); err != nil {
+			return nil, err
+		}
 	}
-	for guid, cert := range chain.GetExtras() {
-		c.Entries = append(c.Entries,
-			CertTableEntry{GUID: uuid.MustParse(guid), RawCert: cert})
+	var extras []string
+	for guid := range chain.GetExtras() {
+		extras = append(extras, guid)
 	}
-	return c
+	sort.Strings(extras)
+	for _, guid := range append([]string{ArkGUID, AskGUID, VcekGUID, VlekGUID}, extras...) {
+		if seen[guid] {
+			continue
+		}
+		if cert, ok := cert(guid); ok {
+			if err := add(guid, cert); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
 }
 
 // Marshal returns the CertTable in its GUID table ABI format.
@@ -848,6 +1504,45 @@ func (c *CertTable) Marshal() []byte {
 	return output
 }
 
+// MarshalPages returns the CertTable in the layout of the data pages that the host fills for an
+// extended guest request: the GUID table with its zero terminator entry, then the certificates from
+// the next 4KB page boundary on, all zero-padded to length bytes. A zero length uses the fewest
+// pages that fit the table. Errors if length is not a multiple of 4KB or the table does not fit.
+func (c *CertTable) MarshalPages(length uint32) ([]byte, error) {
+	if length%certTablePageSize != 0 {
+		return nil, fmt.Errorf("cert table buffer length %d is not a multiple of %d", length, certTablePageSize)
+	}
+	// Widen to avoid uint32 overflow.
+	headerSize := uint64(len(c.Entries)+1) * CertTableEntrySize
+	dataOffset := (headerSize + certTablePageSize - 1) / certTablePageSize * certTablePageSize
+	size := dataOffset
+	for _, entry := range c.Entries {
+		size += uint64(len(entry.RawCert))
+	}
+	pagesSize := (size + certTablePageSize - 1) / certTablePageSize * certTablePageSize
+	if length == 0 {
+		if pagesSize > math.MaxUint32 {
+			return nil, fmt.Errorf("cert table size %d does not fit in a uint32 length", size)
+		}
+		length = uint32(pagesSize)
+	}
+	if size > uint64(length) {
+		return nil, fmt.Errorf("cert table size %d exceeds the buffer length %d", size, length)
+	}
+	output := make([]byte, length)
+	cursor := uint32(dataOffset)
+	for i, entry := range c.Entries {
+		size := uint32(len(entry.RawCert))
+		h := &CertTableHeaderEntry{GUID: entry.GUID, Offset: cursor, Length: size}
+		copy(output[cursor:cursor+size], entry.RawCert)
+		if err := h.Write(output[i*CertTableEntrySize:]); err != nil {
+			return nil, err
+		}
+		cursor += size
+	}
+	return output, nil
+}
+
 // Proto returns the certificate chain represented in an extended guest request's
 // data pages. The GHCB specification allows any number of entries in the pages,
 // so missing certificates aren't an error. If certificates are missing, you can
@@ -858,7 +1553,12 @@ func (c *CertTable) Proto() *pb.CertificateChain {
 	askGUID := uuid.MustParse(AskGUID)
 	arkGUID := uuid.MustParse(ArkGUID)
 	result := &pb.CertificateChain{Extras: make(map[string][]byte)}
+	seen := make(map[uuid.UUID]bool)
 	for _, entry := range c.Entries {
+		if !seen[entry.GUID] {
+			seen[entry.GUID] = true
+			result.EntryOrder = append(result.EntryOrder, entry.GUID.String())
+		}
 		switch {
 		case entry.GUID == vcekGUID:
 			result.VcekCert = entry.RawCert
@@ -889,6 +1589,9 @@ func (c *CertTable) Proto() *pb.CertificateChain {
 // See assembly implementations in cpuid_*.s
 var cpuid func(op uint32) (eax, ebx, ecx, edx uint32)
 
+// hasCpuid is true when cpuid executes the CPUID instruction rather than returning zeros.
+var hasCpuid bool
+
 // SevProductFromCpuid1Eax returns the SevProduct that is represented by cpuid(1).eax.
 func SevProductFromCpuid1Eax(eax uint32) *pb.SevProduct {
 	// 31:28 reserved
@@ -905,16 +1608,25 @@ func SevProductFromCpuid1Eax(eax uint32) *pb.SevProduct {
 	// section "Determining the Product Name".
 	var productName pb.SevProduct_SevProductName
 	// Product information specified by processor programming reference publications.
-	if extendedFamily == sevExtendedFamily && family == sevFamily {
+	switch {
+	case extendedFamily == sevExtendedFamily && family == sevFamily:
 		switch extendedModel {
 		case milanExtendedModel:
 			productName = pb.SevProduct_SEV_PRODUCT_MILAN
-		case genoaExtendedModel:
+		case genoaExtendedModel, bergamoSienaExtendedModel:
 			productName = pb.SevProduct_SEV_PRODUCT_GENOA
 		default:
 			productName = pb.SevProduct_SEV_PRODUCT_UNKNOWN
 			stepping = 0 // Reveal nothing.
 		}
+	case extendedFamily == turinExtendedFamily && family == sevFamily:
+		switch extendedModel {
+		case turinExtendedModel, turinDenseExtendedModel:
+			productName = pb.SevProduct_SEV_PRODUCT_TURIN
+		default:
+			productName = pb.SevProduct_SEV_PRODUCT_UNKNOWN
+			stepping = 0 // Reveal nothing.
+		}
 	}
 	return &pb.SevProduct{
 		Name:            productName,
@@ -929,23 +1641,29 @@ func MaskedCpuid1EaxFromSevProduct(product *pb.SevProduct) uint32 {
 	if product.MachineStepping != nil {
 		stepping = product.MachineStepping.Value & 0xf
 	}
-	extendedFamily := uint32(sevExtendedFamily) << extendedFamilyShift
 	family := uint32(sevFamily) << familyShift
 
-	var extendedModel uint32
+	var extendedFamily, extendedModel uint32
 	switch product.Name {
 	case pb.SevProduct_SEV_PRODUCT_MILAN:
+		extendedFamily = sevExtendedFamily
 		extendedModel = milanExtendedModel
 	case pb.SevProduct_SEV_PRODUCT_GENOA:
+		extendedFamily = sevExtendedFamily
 		extendedModel = genoaExtendedModel
+	case pb.SevProduct_SEV_PRODUCT_TURIN:
+		extendedFamily = turinExtendedFamily
+		extendedModel = turinExtendedModel
 	default:
 		return 0
 	}
-	return extendedFamily | family | stepping | (extendedModel << extendedModelShift)
+	return (extendedFamily << extendedFamilyShift) | family | stepping | (extendedModel << extendedModelShift)
 }
 
 // SevProduct returns the SEV product enum for the CPU that runs this
-// function. Ought to be called from the client, not the verifier.
+// function. Ought to be called from the client, not the verifier. Returns
+// SEV_PRODUCT_UNKNOWN when CPUID is not available. Use SevProductOrError to
+// distinguish that case.
 func SevProduct() *pb.SevProduct {
 	// CPUID[EAX=1] is the processor info. The only bits we care about are in
 	// the eax result.
@@ -953,6 +1671,15 @@ func SevProduct() *pb.SevProduct {
 	return SevProductFromCpuid1Eax(eax & CpuidProductMask)
 }
 
+// SevProductOrError returns the SEV product enum for the CPU that runs this
+// function, or ErrNoCpuid if the CPUID instruction is not available to this build.
+func SevProductOrError() (*pb.SevProduct, error) {
+	if !hasCpuid {
+		return nil, fmt.Errorf("%w on %s", ErrNoCpuid, runtime.GOARCH)
+	}
+	return SevProduct(), nil
+}
+
 // MakeExtraPlatformInfo returns the representation of platform info needed on top of what an
 // attestation report provides in order to interpret it with the help of the AMD KDS.
 func MakeExtraPlatformInfo() *ExtraPlatformInfo {
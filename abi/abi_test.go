@@ -16,8 +16,15 @@ package abi
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
+	"math/big"
 	"math/rand"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -25,6 +32,7 @@ import (
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
 	"github.com/google/uuid"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
@@ -160,18 +168,635 @@ func TestReportMbz(t *testing.T) {
 	}
 }
 
+func TestValidateReportFormat(t *testing.T) {
+	reportProto := &spb.Report{}
+	if err := prototext.Unmarshal([]byte(emptyReport), reportProto); err != nil {
+		t.Fatalf("test failure: %v", err)
+	}
+	good, err := ReportToAbiBytes(reportProto)
+	if err != nil {
+		t.Fatalf("ReportToAbiBytes(%v) errored unexpectedly: %v", reportProto, err)
+	}
+	if err := ValidateReportFormat(good); err != nil {
+		t.Fatalf("ValidateReportFormat(good report) = %v. Want nil", err)
+	}
+	tcs := []struct {
+		name    string
+		modify  func(raw []byte) []byte
+		wantErr error
+	}{
+		{
+			name:    "short",
+			modify:  func(raw []byte) []byte { return raw[:ReportSize-1] },
+			wantErr: ErrReportSize,
+		},
+		{
+			name:    "long",
+			modify:  func(raw []byte) []byte { return append(raw, 0) },
+			wantErr: ErrReportSize,
+		},
+		{
+			name:    "version",
+			modify:  func(raw []byte) []byte { raw[0x00] = 4; return raw },
+			wantErr: ErrReportVersion,
+		},
+		{
+			name:    "policy",
+			modify:  func(raw []byte) []byte { raw[0x0A] = 0; return raw },
+			wantErr: ErrReportPolicy,
+		},
+		{
+			name:    "vmpl",
+			modify:  func(raw []byte) []byte { raw[0x30] = 4; return raw },
+			wantErr: ErrReportVmpl,
+		},
+		{
+			name:    "signature algo",
+			modify:  func(raw []byte) []byte { raw[0x34] = 2; return raw },
+			wantErr: ErrReportSignatureAlgo,
+		},
+		{
+			name:    "signer info",
+			modify:  func(raw []byte) []byte { raw[0x48] = 2 << 2; return raw },
+			wantErr: ErrReportSignerInfo,
+		},
+		{
+			name:    "reserved after signer info",
+			modify:  func(raw []byte) []byte { raw[0x4C] = 1; return raw },
+			wantErr: ErrReportReserved,
+		},
+		{
+			name:    "v2 cpuid bytes",
+			modify:  func(raw []byte) []byte { raw[0x188] = 0x19; return raw },
+			wantErr: ErrReportReserved,
+		},
+		{
+			name:    "v3 reserved after cpuid",
+			modify:  func(raw []byte) []byte { raw[0x00] = ReportVersion3; raw[0x18B] = 1; return raw },
+			wantErr: ErrReportReserved,
+		},
+		{
+			name:    "signature padding",
+			modify:  func(raw []byte) []byte { raw[ReportSize-1] = 1; return raw },
+			wantErr: ErrReportReserved,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := tc.modify(clone(good))
+			if err := ValidateReportFormat(raw); !errors.Is(err, tc.wantErr) {
+				t.Errorf("ValidateReportFormat() = %v. Want %v", err, tc.wantErr)
+			}
+		})
+	}
+	v3 := clone(good)
+	v3[0x00] = ReportVersion3
+	v3[0x188] = 0x19
+	if err := ValidateReportFormat(v3); err != nil {
+		t.Errorf("ValidateReportFormat(v3 report with cpuid) = %v. Want nil", err)
+	}
+}
+
+func sampleRawReport(t *testing.T, modify func(raw []byte)) []byte {
+	t.Helper()
+	reportProto := &spb.Report{}
+	if err := prototext.Unmarshal([]byte(emptyReport), reportProto); err != nil {
+		t.Fatalf("test failure: %v", err)
+	}
+	raw, err := ReportToAbiBytes(reportProto)
+	if err != nil {
+		t.Fatalf("ReportToAbiBytes(%v) errored unexpectedly: %v", reportProto, err)
+	}
+	modify(raw)
+	return raw
+}
+
+func TestReportAbiRoundTrip(t *testing.T) {
+	fill := func(raw []byte, lo, hi int, seed byte) {
+		for i := lo; i < hi; i++ {
+			raw[i] = seed + byte(i)
+		}
+	}
+	tcs := []struct {
+		name   string
+		modify func(raw []byte)
+	}{
+		{name: "empty", modify: func([]byte) {}},
+		{
+			name: "vmpl",
+			modify: func(raw []byte) {
+				raw[0x30] = 2
+			},
+		},
+		{
+			name: "author key",
+			modify: func(raw []byte) {
+				raw[0x48] = 1 // AUTHOR_KEY_EN
+				fill(raw, 0xE0, 0x110, 0x11)
+				fill(raw, 0x110, 0x140, 0x22)
+			},
+		},
+		{
+			name: "all fields",
+			modify: func(raw []byte) {
+				raw[0x04] = 7                 // GUEST_SVN
+				raw[0x09] = 0x0d              // POLICY ABI_MAJOR
+				raw[0x0A] = 0x1f              // POLICY SMT, MigrateMA, Debug, SingleSocket
+				fill(raw, 0x10, 0x30, 0x30)   // FAMILY_ID, IMAGE_ID
+				raw[0x30] = 3                 // VMPL
+				fill(raw, 0x38, 0x40, 0x40)   // CURRENT_TCB
+				raw[0x40] = 0x1f              // PLATFORM_INFO
+				raw[0x48] = 1<<2 | 3          // SIGNER_INFO VLEK, MaskChipKey, AuthorKeyEn
+				fill(raw, 0x50, 0x188, 0x50)  // REPORT_DATA through REPORTED_TCB
+				fill(raw, 0x1A0, 0x1EB, 0x60) // CHIP_ID through CURRENT_VERSION
+				fill(raw, 0x1EC, 0x1EF, 0x70) // COMMITTED_VERSION
+				fill(raw, 0x1F0, 0x1F8, 0x80) // LAUNCH_TCB
+				fill(raw, signatureOffset, signatureOffset+EcdsaP384Sha384SignatureSize, 0x90)
+			},
+		},
+		{
+			name: "version 3",
+			modify: func(raw []byte) {
+				raw[0x00] = ReportVersion3
+				raw[0x30] = 1
+				raw[0x188] = 0x19
+				raw[0x189] = 0x11
+				raw[0x18A] = 0x01
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := sampleRawReport(t, tc.modify)
+			reportProto, err := ReportToProto(raw)
+			if err != nil {
+				t.Fatalf("ReportToProto(%x) = _, %v. Want nil", raw, err)
+			}
+			got, err := ReportToAbiBytes(reportProto)
+			if err != nil {
+				t.Fatalf("ReportToAbiBytes(%v) = _, %v. Want nil", reportProto, err)
+			}
+			if !bytes.Equal(got, raw) {
+				t.Errorf("ReportToAbiBytes(ReportToProto(%x)) = %x. Want identity", raw, got)
+			}
+		})
+	}
+}
+
+func TestReportFirmwareVersionFields(t *testing.T) {
+	raw := sampleRawReport(t, func(raw []byte) {
+		raw[0x1E8] = 21 // CURRENT_BUILD
+		raw[0x1E9] = 55 // CURRENT_MINOR
+		raw[0x1EA] = 1  // CURRENT_MAJOR
+		raw[0x1EC] = 20 // COMMITTED_BUILD
+		raw[0x1ED] = 54 // COMMITTED_MINOR
+		raw[0x1EE] = 2  // COMMITTED_MAJOR
+	})
+	report, err := ReportToProto(raw)
+	if err != nil {
+		t.Fatalf("ReportToProto(%x) = _, %v. Want nil", raw, err)
+	}
+	got := []uint32{report.GetCurrentBuild(), report.GetCurrentMinor(), report.GetCurrentMajor(),
+		report.GetCommittedBuild(), report.GetCommittedMinor(), report.GetCommittedMajor()}
+	want := []uint32{21, 55, 1, 20, 54, 2}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReportToProto(%x) firmware versions (build, minor, major; current then committed) diff (-want +got):\n%s", raw, diff)
+	}
+	rawAgain, err := ReportToAbiBytes(report)
+	if err != nil {
+		t.Fatalf("ReportToAbiBytes(%v) = _, %v. Want nil", report, err)
+	}
+	if !bytes.Equal(rawAgain[0x1E8:0x1F0], raw[0x1E8:0x1F0]) {
+		t.Errorf("ReportToAbiBytes(%v) firmware versions = %x. Want %x", report, rawAgain[0x1E8:0x1F0], raw[0x1E8:0x1F0])
+	}
+	formatted := FormatReport(report)
+	for _, line := range []string{"current_version: 1.55.21\n", "committed_version: 2.54.20\n"} {
+		if !strings.Contains(formatted, line) {
+			t.Errorf("FormatReport(%v) = %q. Want it to contain %q", report, formatted, line)
+		}
+	}
+}
+
+func TestParsersRejectBadSizes(t *testing.T) {
+	for _, size := range []int{0, 0x30, ReportSize - 1, ReportSize + 1, 5 << 20} {
+		data := make([]byte, size)
+		if _, err := ReportToProto(data); !errors.Is(err, ErrReportSize) {
+			t.Errorf("ReportToProto(%d bytes) = _, %v. Want %v", size, err, ErrReportSize)
+		}
+		if _, _, err := ReportSignatureRS(data); err == nil {
+			t.Errorf("ReportSignatureRS(%d bytes) = _, _, nil. Want an error", size)
+		}
+	}
+	if got := SignatureAlgo(make([]byte, 0x37)); got != 0 {
+		t.Errorf("SignatureAlgo(0x37 bytes) = %d, want 0", got)
+	}
+	if got := SignedComponent(make([]byte, signatureOffset-1)); got != nil {
+		t.Errorf("SignedComponent(0x%x bytes) = %v, want nil", signatureOffset-1, got)
+	}
+	// An entry whose offset+length overflows uint32 must not pass the range check.
+	certs := make([]byte, 0x100)
+	overflow := CertTableHeaderEntry{Offset: 0xFFFFFFF0, Length: 0x20}
+	if err := overflow.Write(certs); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(CertTable).Unmarshal(certs); err == nil {
+		t.Error("CertTable.Unmarshal(overflowing entry) = nil. Want an error")
+	}
+}
+
+func TestReportUnknownVersion(t *testing.T) {
+	raw := sampleRawReport(t, func(raw []byte) {
+		raw[0x00] = LatestReportVersion + 1
+		// Pretend the new version defines fields in bytes that are reserved in known versions.
+		raw[0x4C] = 0x01
+		raw[0x190] = 0x02
+		raw[0x1F8] = 0x03
+	})
+	if _, err := ReportToProto(raw); err == nil {
+		t.Error("ReportToProto(unknown version) = _, nil. Expected an error")
+	}
+	if err := ValidateReportFormat(raw); !errors.Is(err, ErrReportVersion) {
+		t.Errorf("ValidateReportFormat(unknown version) = %v, want %v", err, ErrReportVersion)
+	}
+	opts := &ParseOptions{AllowUnknownVersion: true}
+	if err := ValidateReportFormatWithOptions(raw, opts); err != nil {
+		t.Errorf("ValidateReportFormatWithOptions(unknown version) = %v. Want nil", err)
+	}
+	report, err := ReportToProtoWithOptions(raw, opts)
+	if err != nil {
+		t.Fatalf("ReportToProtoWithOptions(unknown version) = _, %v. Want nil", err)
+	}
+	if report.GetVersion() != LatestReportVersion+1 {
+		t.Errorf("report version is %d, want %d", report.GetVersion(), LatestReportVersion+1)
+	}
+	if !bytes.Equal(report.GetRaw(), raw) {
+		t.Error("ReportToProtoWithOptions(unknown version) did not keep the raw report")
+	}
+	got, err := ReportToAbiBytes(report)
+	if err != nil {
+		t.Fatalf("ReportToAbiBytes(%v) = _, %v. Want nil", report, err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Errorf("ReportToAbiBytes(ReportToProtoWithOptions(%v)) = %v, want the original bytes", raw, got)
+	}
+
+	known, err := ReportToProtoWithOptions(sampleRawReport(t, func([]byte) {}), opts)
+	if err != nil {
+		t.Fatalf("ReportToProtoWithOptions(known version) = _, %v. Want nil", err)
+	}
+	if len(known.GetRaw()) != 0 {
+		t.Error("ReportToProtoWithOptions(known version) kept the raw report. Want none")
+	}
+	known.Raw = raw
+	if _, err := ReportToAbiBytes(known); err == nil {
+		t.Error("ReportToAbiBytes(known version with raw bytes) = _, nil. Expected an error")
+	}
+
+	badSignature := sampleRawReport(t, func(raw []byte) {
+		raw[0x00] = LatestReportVersion + 1
+		raw[signatureOffset+EcdsaP384Sha384SignatureSize] = 1
+	})
+	if err := ValidateReportFormatWithOptions(badSignature, opts); !errors.Is(err, ErrReportReserved) {
+		t.Errorf("ValidateReportFormatWithOptions(signature padding) = %v, want %v", err, ErrReportReserved)
+	}
+	if err := ValidateReportFormatWithOptions(raw[:ReportSize-1], opts); !errors.Is(err, ErrReportSize) {
+		t.Errorf("ValidateReportFormatWithOptions(short) = %v, want %v", err, ErrReportSize)
+	}
+}
+
+func TestReportToAbiBytesErrors(t *testing.T) {
+	good, err := ReportToProto(sampleRawReport(t, func([]byte) {}))
+	if err != nil {
+		t.Fatalf("test failure: %v", err)
+	}
+	tcs := []struct {
+		name    string
+		modify  func(r *spb.Report)
+		wantErr string
+	}{
+		{
+			name:    "long report_data",
+			modify:  func(r *spb.Report) { r.ReportData = make([]byte, ReportDataSize+1) },
+			wantErr: "report_data length is 65, expect 64",
+		},
+		{
+			name:    "short measurement",
+			modify:  func(r *spb.Report) { r.Measurement = make([]byte, MeasurementSize-1) },
+			wantErr: "measurement length is 47, expect 48",
+		},
+		{
+			name:    "missing chip_id",
+			modify:  func(r *spb.Report) { r.ChipId = nil },
+			wantErr: "chip_id length is 0, expect 64",
+		},
+		{
+			name:    "long signature",
+			modify:  func(r *spb.Report) { r.Signature = make([]byte, SignatureSize+1) },
+			wantErr: "signature length is 513, expect 512",
+		},
+		{
+			name:    "wide current_build",
+			modify:  func(r *spb.Report) { r.CurrentBuild = 0x100 },
+			wantErr: "current_build field must fit in a byte",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := proto.Clone(good).(*spb.Report)
+			tc.modify(r)
+			if _, err := ReportToAbiBytes(r); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ReportToAbiBytes() = _, %v. Want error %q", err, tc.wantErr)
+			}
+		})
+	}
+	if _, err := ReportToAbiBytes(nil); err == nil {
+		t.Error("ReportToAbiBytes(nil) = _, nil. Want error")
+	}
+}
+
+func TestUnsupportedSignatureAlgo(t *testing.T) {
+	raw := sampleRawReport(t, func(raw []byte) { raw[0x34] = 2 })
+	err := ValidateReportFormat(raw)
+	var algoErr *ErrUnsupportedSignatureAlgo
+	if !errors.As(err, &algoErr) || algoErr.Algo != 2 {
+		t.Errorf("ValidateReportFormat(algo 2) = %v. Want *ErrUnsupportedSignatureAlgo{Algo: 2}", err)
+	}
+	if !errors.Is(err, ErrReportSignatureAlgo) {
+		t.Errorf("ValidateReportFormat(algo 2) = %v. Want %v", err, ErrReportSignatureAlgo)
+	}
+	if want := "unsupported signature algorithm: report signature algorithm is 2. Expected 1"; err == nil || err.Error() != want {
+		t.Errorf("ValidateReportFormat(algo 2) = %v. Want %q", err, want)
+	}
+	if _, _, err := ReportSignatureRS(raw); !errors.As(err, &algoErr) || algoErr.Algo != 2 {
+		t.Errorf("ReportSignatureRS(algo 2) = _, _, %v. Want *ErrUnsupportedSignatureAlgo{Algo: 2}", err)
+	}
+}
+
+func TestSignatureHelpers(t *testing.T) {
+	key := testIdKey(0x5eb)
+	digest := sha512.Sum384([]byte("report"))
+	der, err := ecdsa.SignASN1(crand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := sampleRawReport(t, func([]byte) {})
+	if err := SetSignatureDER(der, report); err != nil {
+		t.Fatalf("SetSignatureDER(%x, _) = %v. Want nil", der, err)
+	}
+	r, s, err := ReportSignatureRS(report)
+	if err != nil {
+		t.Fatalf("ReportSignatureRS() = _, _, %v. Want nil", err)
+	}
+	if !ecdsa.Verify(&key.PublicKey, digest[:], r, s) {
+		t.Error("ReportSignatureRS() components do not verify")
+	}
+	gotDER, err := ReportToSignatureDER(report)
+	if err != nil {
+		t.Fatalf("ReportToSignatureDER() = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(gotDER, der) {
+		t.Errorf("ReportToSignatureDER() = %x. Want %x", gotDER, der)
+	}
+	gotR, gotS, err := SignatureDERToRS(gotDER)
+	if err != nil || gotR.Cmp(r) != 0 || gotS.Cmp(s) != 0 {
+		t.Errorf("SignatureDERToRS(%x) = %v, %v, %v. Want %v, %v, nil", gotDER, gotR, gotS, err, r, s)
+	}
+
+	order := elliptic.P384().Params().N
+	rsErrs := []struct {
+		name    string
+		modify  func(signature []byte)
+		wantErr string
+	}{
+		{
+			name:    "R padding",
+			modify:  func(signature []byte) { signature[0x30] = 1 },
+			wantErr: "signature component R padding",
+		},
+		{
+			name:    "S padding",
+			modify:  func(signature []byte) { signature[0x8F] = 1 },
+			wantErr: "signature component S padding",
+		},
+		{
+			name:    "zero R",
+			modify:  func(signature []byte) { copy(signature[0:0x48], make([]byte, 0x48)) },
+			wantErr: "signature component R must be positive",
+		},
+		{
+			name:    "S is order",
+			modify:  func(signature []byte) { copy(signature[0x48:0x90], bigIntToAMDRS(order)) },
+			wantErr: "signature component S is not less than the P-384 order",
+		},
+	}
+	for _, tc := range rsErrs {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := clone(report)
+			tc.modify(raw[signatureOffset:])
+			if _, _, err := ReportSignatureRS(raw); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ReportSignatureRS() = _, _, %v. Want error %q", err, tc.wantErr)
+			}
+			if _, err := ReportToSignatureDER(raw); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ReportToSignatureDER() = _, %v. Want error %q", err, tc.wantErr)
+			}
+		})
+	}
+	if err := SetSignature(order, s, clone(report)); err == nil {
+		t.Error("SetSignature(N, s) = nil. Want error")
+	}
+	if _, err := RSToSignatureDER(r, big.NewInt(0)); err == nil {
+		t.Error("RSToSignatureDER(r, 0) = _, nil. Want error")
+	}
+	if _, _, err := SignatureDERToRS(append(clone(der), 0)); err == nil {
+		t.Error("SignatureDERToRS(trailing data) = _, _, nil. Want error")
+	}
+}
+
+func TestReportVersion3Cpuid(t *testing.T) {
+	reportProto := &spb.Report{}
+	if err := prototext.Unmarshal([]byte(emptyReport), reportProto); err != nil {
+		t.Fatalf("test failure: %v", err)
+	}
+	reportProto.Version = ReportVersion3
+	reportProto.CpuidFamId = 0x19
+	reportProto.CpuidModId = 0x11
+	reportProto.CpuidStep = 0x1
+	raw, err := ReportToAbiBytes(reportProto)
+	if err != nil {
+		t.Fatalf("ReportToAbiBytes(%v) errored unexpectedly: %v", reportProto, err)
+	}
+	if err := ValidateReportFormat(raw); err != nil {
+		t.Errorf("ValidateReportFormat(v3 report) = %v. Want nil", err)
+	}
+	got, err := ReportToProto(raw)
+	if err != nil {
+		t.Fatalf("ReportToProto(%v) errored unexpectedly: %v", raw, err)
+	}
+	if diff := cmp.Diff(got, reportProto, protocmp.Transform()); diff != "" {
+		t.Errorf("ReportToProto(ReportToAbiBytes(%v)) diff (-got, +want): %s", reportProto, diff)
+	}
+
+	raw[0x18B] = 0xcc
+	wantErr := "mbz range [0x18b:0x1a0] not all zero: cc"
+	if _, err := ReportToProto(raw); err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("ReportToProto(v3 report with reserved byte set) = _, %v. Want error %q", err, wantErr)
+	}
+
+	reportProto.Version = ExpectedReportVersion
+	wantErr = "report version 2 does not have CPUID fields"
+	if _, err := ReportToAbiBytes(reportProto); err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("ReportToAbiBytes(v2 report with CPUID fields) = _, %v. Want error %q", err, wantErr)
+	}
+}
+
+func TestSignerInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   uint32
+		want    SignerInfo
+		wantErr string
+	}{
+		{
+			name: "VCEK",
+			want: SignerInfo{SigningKey: VcekReportSigner},
+		},
+		{
+			name:  "VLEK",
+			input: 4,
+			want:  SignerInfo{SigningKey: VlekReportSigner},
+		},
+		{
+			name:  "None",
+			input: 0x1c,
+			want:  SignerInfo{SigningKey: NoneReportSigner},
+		},
+		{
+			name:  "flags",
+			input: 7,
+			want:  SignerInfo{SigningKey: VlekReportSigner, MaskChipKey: true, AuthorKeyEn: true},
+		},
+		{
+			name:    "reserved signing key",
+			input:   8,
+			wantErr: "signing_key values 2-6 are reserved. Got UNKNOWN(2)",
+		},
+		{
+			name:    "reserved bits",
+			input:   0x20,
+			wantErr: "mbz range data[0x48:0x4C][0x5:0x1f] not all zero: 20",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSignerInfo(tc.input)
+			if (err == nil && tc.wantErr != "") || (err != nil && (tc.wantErr == "" || !strings.Contains(err.Error(), tc.wantErr))) {
+				t.Fatalf("ParseSignerInfo(%x) = %v, %v. Want error %q", tc.input, got, err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tc.want {
+				t.Errorf("ParseSignerInfo(%x) = %v, want %v", tc.input, got, tc.want)
+			}
+			if back := ComposeSignerInfo(got); back != tc.input {
+				t.Errorf("ComposeSignerInfo(%v) = %x, want %x", got, back, tc.input)
+			}
+		})
+	}
+}
+
+func TestSignerInfoParts(t *testing.T) {
+	reportProto := &spb.Report{}
+	if err := prototext.Unmarshal([]byte(emptyReport), reportProto); err != nil {
+		t.Fatalf("test failure: %v", err)
+	}
+	reportProto.SignerInfo = ComposeSignerInfo(SignerInfo{SigningKey: VlekReportSigner, AuthorKeyEn: true})
+	raw, err := ReportToAbiBytes(reportProto)
+	if err != nil {
+		t.Fatalf("ReportToAbiBytes(%v) errored unexpectedly: %v", reportProto, err)
+	}
+	got, err := ReportToProto(raw)
+	if err != nil {
+		t.Fatalf("ReportToProto(%v) errored unexpectedly: %v", raw, err)
+	}
+	if got.GetSigningKey() != uint32(VlekReportSigner) || got.GetMaskChipKey() || !got.GetAuthorKeyEn() {
+		t.Errorf("ReportToProto(%v) signer info parts = %d, %v, %v. Want %d, false, true", raw,
+			got.GetSigningKey(), got.GetMaskChipKey(), got.GetAuthorKeyEn(), VlekReportSigner)
+	}
+	if _, err := ReportToAbiBytes(got); err != nil {
+		t.Errorf("ReportToAbiBytes(%v) errored unexpectedly: %v", got, err)
+	}
+	got.SigningKey = uint32(VcekReportSigner)
+	wantErr := "report signer_info 0x5 disagrees with signing_key 0, mask_chip_key false, author_key_en true"
+	if _, err := ReportToAbiBytes(got); err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("ReportToAbiBytes(%v) = _, %v. Want error %q", got, err, wantErr)
+	}
+}
+
+func TestChipID(t *testing.T) {
+	masked := make([]byte, ChipIDSize)
+	if !IsChipIDMasked(masked) {
+		t.Errorf("IsChipIDMasked(%v) = false, want true", masked)
+	}
+	if got, want := ChipIDString(masked), strings.Repeat("00", ChipIDSize); got != want {
+		t.Errorf("ChipIDString(%v) = %q, want %q", masked, got, want)
+	}
+	chipID := make([]byte, ChipIDSize)
+	chipID[0] = 0xfe
+	chipID[ChipIDSize-1] = 0xc0
+	if IsChipIDMasked(chipID) {
+		t.Errorf("IsChipIDMasked(%v) = true, want false", chipID)
+	}
+	if got, want := ChipIDString(chipID), "fe"+strings.Repeat("00", ChipIDSize-2)+"c0"; got != want {
+		t.Errorf("ChipIDString(%v) = %q, want %q", chipID, got, want)
+	}
+}
+
+func TestReportData(t *testing.T) {
+	nonce := bytes.Repeat([]byte{0xaa}, 32)
+	got, err := PadReportData(nonce)
+	if err != nil {
+		t.Fatalf("PadReportData(%v) = _, %v. Want nil", nonce, err)
+	}
+	var want [ReportDataSize]byte
+	copy(want[:], nonce)
+	if got != want {
+		t.Errorf("PadReportData(%v) = %v, want %v", nonce, got, want)
+	}
+	if trimmed := TrimReportData(got[:]); !bytes.Equal(trimmed, nonce) {
+		t.Errorf("TrimReportData(%v) = %v, want %v", got, trimmed, nonce)
+	}
+	if got, err := PadReportData(nil); err != nil || got != [ReportDataSize]byte{} {
+		t.Errorf("PadReportData(nil) = %v, %v. Want zeros, nil", got, err)
+	}
+	if trimmed := TrimReportData(make([]byte, ReportDataSize)); len(trimmed) != 0 {
+		t.Errorf("TrimReportData(zeros) = %v, want empty", trimmed)
+	}
+	full := bytes.Repeat([]byte{0x01}, ReportDataSize)
+	if got, err := PadReportData(full); err != nil || !bytes.Equal(got[:], full) {
+		t.Errorf("PadReportData(%v) = %v, %v. Want %v, nil", full, got, err, full)
+	}
+	if _, err := PadReportData(make([]byte, ReportDataSize+1)); !errors.Is(err, ErrReportDataTooLong) {
+		t.Errorf("PadReportData(65 bytes) = _, %v. Want %v", err, ErrReportDataTooLong)
+	}
+}
+
 func TestSnpPolicySection(t *testing.T) {
 	entropySize := 128
 	entropy := make([]uint8, entropySize)
 	rand.Read(entropy)
 	for tc := 0; tc < entropySize/3; tc++ {
 		policy := SnpPolicy{
-			ABIMinor:     entropy[tc*3],
-			ABIMajor:     entropy[tc*3+1],
-			SMT:          (entropy[tc*3+2] & 1) != 0,
-			MigrateMA:    (entropy[tc*3+2] & 2) != 0,
-			Debug:        (entropy[tc*3+2] & 4) != 0,
-			SingleSocket: (entropy[tc*3+2] & 8) != 0,
+			ABIMinor:             entropy[tc*3],
+			ABIMajor:             entropy[tc*3+1],
+			SMT:                  (entropy[tc*3+2] & 1) != 0,
+			MigrateMA:            (entropy[tc*3+2] & 2) != 0,
+			Debug:                (entropy[tc*3+2] & 4) != 0,
+			SingleSocket:         (entropy[tc*3+2] & 8) != 0,
+			CXLAllow:             (entropy[tc*3+2] & 16) != 0,
+			MemAES256XTS:         (entropy[tc*3+2] & 32) != 0,
+			RAPLDis:              (entropy[tc*3+2] & 64) != 0,
+			CiphertextHidingDRAM: (entropy[tc*3+2] & 128) != 0,
 		}
 
 		got, err := ParseSnpPolicy(SnpPolicyToBytes(policy))
@@ -184,6 +809,214 @@ func TestSnpPolicySection(t *testing.T) {
 	}
 }
 
+func TestSnpPolicySatisfiedBy(t *testing.T) {
+	tcs := []struct {
+		name     string
+		required SnpPolicy
+		actual   SnpPolicy
+		wantErr  string
+	}{
+		{name: "equal"},
+		{
+			name:     "newer ABI",
+			required: SnpPolicy{ABIMajor: 1, ABIMinor: 51},
+			actual:   SnpPolicy{ABIMajor: 2, ABIMinor: 0},
+		},
+		{
+			name:     "older ABI minor",
+			required: SnpPolicy{ABIMajor: 1, ABIMinor: 51},
+			actual:   SnpPolicy{ABIMajor: 1, ABIMinor: 50},
+			wantErr:  "required policy ABI version (1.51) is greater than the report's ABI version (1.50)",
+		},
+		{
+			name:     "allowed capabilities unused",
+			required: SnpPolicy{SMT: true, MigrateMA: true, Debug: true},
+		},
+		{
+			name:    "debug",
+			actual:  SnpPolicy{Debug: true},
+			wantErr: "found unauthorized debug capability",
+		},
+		{
+			name:    "smt",
+			actual:  SnpPolicy{SMT: true},
+			wantErr: "found unauthorized symmetric multithreading (SMT) capability",
+		},
+		{
+			name:    "migrate ma",
+			actual:  SnpPolicy{MigrateMA: true},
+			wantErr: "found unauthorized migration agent capability",
+		},
+		{
+			name:     "single socket",
+			required: SnpPolicy{SingleSocket: true},
+			wantErr:  "required single socket restriction not present",
+		},
+		{
+			name:   "single socket not required",
+			actual: SnpPolicy{SingleSocket: true},
+		},
+		{
+			name:     "cxl allow",
+			required: SnpPolicy{CXLAllow: true},
+			wantErr:  "required CXL allowance not present",
+		},
+		{
+			name:     "aes-256-xts",
+			required: SnpPolicy{MemAES256XTS: true},
+			wantErr:  "required AES-256-XTS memory encryption not present",
+		},
+		{
+			name:     "rapl disabled",
+			required: SnpPolicy{RAPLDis: true},
+			wantErr:  "required RAPL disablement not present",
+		},
+		{
+			name:     "ciphertext hiding",
+			required: SnpPolicy{CiphertextHidingDRAM: true},
+			wantErr:  "required DRAM ciphertext hiding not present",
+		},
+		{
+			name:     "new requirements met",
+			required: SnpPolicy{CXLAllow: true, MemAES256XTS: true, RAPLDis: true, CiphertextHidingDRAM: true},
+			actual:   SnpPolicy{CXLAllow: true, MemAES256XTS: true, RAPLDis: true, CiphertextHidingDRAM: true},
+		},
+		{
+			name:   "new requirements not required",
+			actual: SnpPolicy{CXLAllow: true, MemAES256XTS: true, RAPLDis: true, CiphertextHidingDRAM: true},
+		},
+		{
+			name:     "first violation",
+			required: SnpPolicy{ABIMajor: 1},
+			actual:   SnpPolicy{Debug: true},
+			wantErr:  "required policy ABI version (1.0) is greater than the report's ABI version (0.0)",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.required.SatisfiedBy(tc.actual)
+			if (err == nil && tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+				t.Errorf("%v.SatisfiedBy(%v) = %v. Want error %q", tc.required, tc.actual, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestGuestFieldSelect(t *testing.T) {
+	tests := []struct {
+		input      uint64
+		want       GuestFieldSelect
+		wantString string
+	}{
+		{input: 0, want: GuestFieldSelect{}, wantString: "none"},
+		{input: 1, want: GuestFieldSelect{GuestPolicy: true}, wantString: "GuestPolicy"},
+		{input: 1 << 1, want: GuestFieldSelect{ImageID: true}, wantString: "ImageID"},
+		{input: 1 << 2, want: GuestFieldSelect{FamilyID: true}, wantString: "FamilyID"},
+		{input: 1 << 3, want: GuestFieldSelect{Measurement: true}, wantString: "Measurement"},
+		{input: 1 << 4, want: GuestFieldSelect{GuestSVN: true}, wantString: "GuestSVN"},
+		{input: 1 << 5, want: GuestFieldSelect{TCBVersion: true}, wantString: "TCBVersion"},
+		{
+			input: 0x3f,
+			want: GuestFieldSelect{
+				GuestPolicy: true,
+				ImageID:     true,
+				FamilyID:    true,
+				Measurement: true,
+				GuestSVN:    true,
+				TCBVersion:  true,
+			},
+			wantString: "GuestPolicy|ImageID|FamilyID|Measurement|GuestSVN|TCBVersion",
+		},
+	}
+	for _, tc := range tests {
+		got, err := ParseGuestFieldSelect(tc.input)
+		if err != nil {
+			t.Errorf("ParseGuestFieldSelect(%x) = _, %v. Want nil", tc.input, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseGuestFieldSelect(%x) = %+v, want %+v", tc.input, got, tc.want)
+		}
+		if back := GuestFieldSelectToBytes(got); back != tc.input {
+			t.Errorf("GuestFieldSelectToBytes(%+v) = %x, want %x", got, back, tc.input)
+		}
+		if s := got.String(); s != tc.wantString {
+			t.Errorf("%+v.String() = %q, want %q", got, s, tc.wantString)
+		}
+	}
+	for _, input := range []uint64{1 << 6, 1 << 63} {
+		wantErr := "mbz range guest_field_select[0x6:0x3f] not all zero"
+		if _, err := ParseGuestFieldSelect(input); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("ParseGuestFieldSelect(%x) = _, %v. Want error %q", input, err, wantErr)
+		}
+	}
+}
+
+func TestParseSnpPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   uint64
+		wantErr string
+	}{
+		{
+			name:    "reserved bit 17 unset",
+			input:   0,
+			wantErr: "policy[17] is reserved, must be 1, got 0",
+		},
+		{
+			name:    "bit 25 set",
+			input:   1<<policyReserved1bit | 1<<25,
+			wantErr: "mbz range policy[0x19:0x3f] not all zero",
+		},
+		{
+			name:    "bit 63 set",
+			input:   1<<policyReserved1bit | 1<<63,
+			wantErr: "mbz range policy[0x19:0x3f] not all zero",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseSnpPolicy(tc.input); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ParseSnpPolicy(%x) = _, %v. Want error %q", tc.input, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReportPolicyBitsByVersion(t *testing.T) {
+	// CXL_ALLOW, MEM_AES_256_XTS, and RAPL_DIS are in byte 0x0A, CIPHERTEXT_HIDING_DRAM in byte 0x0B.
+	newBits := func(raw []byte) {
+		raw[0x0A] |= 0xe0
+		raw[0x0B] |= 0x01
+	}
+	v2 := sampleRawReport(t, newBits)
+	if _, err := ReportToProto(v2); err == nil || !strings.Contains(err.Error(), "mbz range policy[0x15:0x3f]") {
+		t.Errorf("ReportToProto(v2 report with policy bits 21-24) = _, %v. Want a policy mbz error", err)
+	}
+	if err := ValidateReportFormat(v2); !errors.Is(err, ErrReportPolicy) {
+		t.Errorf("ValidateReportFormat(v2 report with policy bits 21-24) = %v. Want %v", err, ErrReportPolicy)
+	}
+
+	v3 := sampleRawReport(t, func(raw []byte) {
+		newBits(raw)
+		raw[0x00] = ReportVersion3
+	})
+	r, err := ReportToProto(v3)
+	if err != nil {
+		t.Fatalf("ReportToProto(v3 report with policy bits 21-24) = _, %v. Want nil", err)
+	}
+	if err := ValidateReportFormat(v3); err != nil {
+		t.Errorf("ValidateReportFormat(v3 report with policy bits 21-24) = %v. Want nil", err)
+	}
+	policy, err := ParseSnpPolicy(r.GetPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.CXLAllow || !policy.MemAES256XTS || !policy.RAPLDis || !policy.CiphertextHidingDRAM {
+		t.Errorf("ParseSnpPolicy(0x%x) = %+v. Want all new policy bits set", r.GetPolicy(), policy)
+	}
+}
+
 func TestSnpPlatformInfo(t *testing.T) {
 	tests := []struct {
 		input   uint64
@@ -198,8 +1031,42 @@ func TestSnpPlatformInfo(t *testing.T) {
 			want:  SnpPlatformInfo{TSMEEnabled: true, SMTEnabled: true},
 		},
 		{
-			input:   4,
-			wantErr: "unrecognized platform info bit(s): 0x4",
+			input: 1,
+			want:  SnpPlatformInfo{SMTEnabled: true},
+		},
+		{
+			input: 2,
+			want:  SnpPlatformInfo{TSMEEnabled: true},
+		},
+		{
+			input: 4,
+			want:  SnpPlatformInfo{ECCEnabled: true},
+		},
+		{
+			input: 8,
+			want:  SnpPlatformInfo{RAPLDisabled: true},
+		},
+		{
+			input: 0x10,
+			want:  SnpPlatformInfo{CiphertextHidingEnabled: true},
+		},
+		{
+			input: 0x1f,
+			want: SnpPlatformInfo{
+				SMTEnabled:              true,
+				TSMEEnabled:             true,
+				ECCEnabled:              true,
+				RAPLDisabled:            true,
+				CiphertextHidingEnabled: true,
+			},
+		},
+		{
+			input:   0x20,
+			wantErr: "unrecognized platform info bit(s): 0x20",
+		},
+		{
+			input:   1 << 63,
+			wantErr: "unrecognized platform info bit(s): 0x8000000000000000",
 		},
 	}
 	for _, tc := range tests {
@@ -212,10 +1079,18 @@ func TestSnpPlatformInfo(t *testing.T) {
 		if err == nil && tc.want != got {
 			t.Errorf("ParseSnpPlatformInfo(%x) = %v, want %v", tc.input, got, tc.want)
 		}
+		if err == nil {
+			if back := SnpPlatformInfoToBytes(got); back != tc.input {
+				t.Errorf("SnpPlatformInfoToBytes(%v) = %x, want %x", got, back, tc.input)
+			}
+		}
 	}
 }
 
 func TestCpuid(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skipf("cpuid is not implemented on %s", runtime.GOARCH)
+	}
 	a, b, c, d := cpuid(1)
 	if (a | b | c | d) == 0 {
 		t.Errorf("cpuid(1) = 0, 0, 0, 0")
@@ -311,6 +1186,279 @@ func TestCertTableProto(t *testing.T) {
 	}
 }
 
+func TestCertTableProtoRoundTrip(t *testing.T) {
+	chain := &spb.CertificateChain{
+		ArkCert:  []byte("ark"),
+		AskCert:  []byte("ask"),
+		VcekCert: []byte("vcek"),
+		Extras: map[string][]byte{
+			"00000000-0000-c0de-0000-000000000002": []byte("extra2"),
+			"00000000-0000-c0de-0000-000000000001": []byte("extra1"),
+			"00000000-0000-c0de-0000-000000000003": []byte("extra3"),
+		},
+	}
+	want := certTableFromProto(t, chain).Marshal()
+	for i := 0; i < 10; i++ {
+		if got := certTableFromProto(t, chain).Marshal(); !bytes.Equal(got, want) {
+			t.Fatalf("CertTableFromProto(%v).Marshal() = %v, want %v", chain, got, want)
+		}
+		parsed := new(CertTable)
+		if err := parsed.Unmarshal(want); err != nil {
+			t.Fatalf("Unmarshal(%v) = %v, want nil", want, err)
+		}
+		if diff := cmp.Diff(parsed.Proto(), chain, protocmp.Transform(),
+			protocmp.IgnoreFields(&spb.CertificateChain{}, "entry_order")); diff != "" {
+			t.Errorf("Unmarshal(CertTableFromProto(%v).Marshal()).Proto() diff (-got, +want): %s", chain, diff)
+		}
+	}
+}
+
+func TestCertTableMarshalPages(t *testing.T) {
+	table := &CertTable{Entries: []CertTableEntry{
+		{GUID: uuid.MustParse(ArkGUID), RawCert: bytes.Repeat([]byte{1}, 0x800)},
+		{GUID: uuid.MustParse(AskGUID), RawCert: bytes.Repeat([]byte{2}, 0x800)},
+		{GUID: uuid.MustParse(VcekGUID), RawCert: []byte("vcek")},
+	}}
+	got, err := table.MarshalPages(0)
+	if err != nil {
+		t.Fatalf("MarshalPages(0) = _, %v. Want nil", err)
+	}
+	// One header page, then 0x1004 bytes of certificates.
+	if len(got) != 0x3000 {
+		t.Errorf("MarshalPages(0) length = 0x%x. Want 0x3000", len(got))
+	}
+	headers, err := ParseSnpCertTableHeader(got)
+	if err != nil {
+		t.Fatalf("ParseSnpCertTableHeader(MarshalPages(0)) = _, %v. Want nil", err)
+	}
+	wantOffsets := []uint32{0x1000, 0x1800, 0x2000}
+	for i, h := range headers {
+		if h.Offset != wantOffsets[i] || int(h.Length) != len(table.Entries[i].RawCert) {
+			t.Errorf("MarshalPages(0) entry %d is at offset 0x%x length %d. Want offset 0x%x length %d",
+				i, h.Offset, h.Length, wantOffsets[i], len(table.Entries[i].RawCert))
+		}
+	}
+	if findNonZero(got, 4*CertTableEntrySize, 0x1000) != 0x1000 {
+		t.Error("MarshalPages(0) has non-zero bytes between the GUID table and the data area")
+	}
+	parsed := new(CertTable)
+	if err := parsed.Unmarshal(got); err != nil {
+		t.Fatalf("Unmarshal(MarshalPages(0)) = %v. Want nil", err)
+	}
+	if diff := cmp.Diff(parsed, table); diff != "" {
+		t.Errorf("Unmarshal(MarshalPages(0)) round trip diff (-got, +want): %s", diff)
+	}
+
+	got, err = table.MarshalPages(0x4000)
+	if err != nil {
+		t.Fatalf("MarshalPages(0x4000) = _, %v. Want nil", err)
+	}
+	if len(got) != 0x4000 {
+		t.Errorf("MarshalPages(0x4000) length = 0x%x. Want 0x4000", len(got))
+	}
+	empty, err := (&CertTable{}).MarshalPages(0)
+	if err != nil || len(empty) != 0x1000 || findNonZero(empty, 0, len(empty)) != len(empty) {
+		t.Errorf("empty CertTable MarshalPages(0) = %d bytes, %v. Want a zero page, nil", len(empty), err)
+	}
+
+	errTests := []struct {
+		name    string
+		length  uint32
+		wantErr string
+	}{
+		{name: "too small", length: 0x2000, wantErr: "cert table size 8196 exceeds the buffer length 8192"},
+		{name: "unaligned", length: 0x3001, wantErr: "cert table buffer length 12289 is not a multiple of 4096"},
+	}
+	for _, tc := range errTests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := table.MarshalPages(tc.length); err == nil || err.Error() != tc.wantErr {
+				t.Errorf("MarshalPages(0x%x) = _, %v. Want error %q", tc.length, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func certTableFromProto(t *testing.T, chain *spb.CertificateChain) *CertTable {
+	t.Helper()
+	table, err := CertTableFromProto(chain)
+	if err != nil {
+		t.Fatalf("CertTableFromProto(%v) = _, %v, want nil", chain, err)
+	}
+	return table
+}
+
+func TestCertTableEntryOrder(t *testing.T) {
+	// Vendor entries interleave with the known ones and are out of GUID order to check that the
+	// table's order is kept.
+	entry := func(guid string, cert string) CertTableEntry {
+		return CertTableEntry{GUID: uuid.MustParse(guid), RawCert: []byte(cert)}
+	}
+	table := &CertTable{Entries: []CertTableEntry{
+		entry(VcekGUID, "vcek"),
+		entry("00000000-0000-c0de-0000-00000000000f", "launch endorsement"),
+		entry(ArkGUID, "ark"),
+		entry("00000000-0000-c0de-0000-000000000001", "event log"),
+		entry(AskGUID, "ask"),
+	}}
+	want := table.Marshal()
+
+	parsed := new(CertTable)
+	if err := parsed.Unmarshal(want); err != nil {
+		t.Fatalf("Unmarshal(%v) = %v, want nil", want, err)
+	}
+	p := parsed.Proto()
+	wantOrder := []string{VcekGUID, "00000000-0000-c0de-0000-00000000000f", ArkGUID,
+		"00000000-0000-c0de-0000-000000000001", AskGUID}
+	if diff := cmp.Diff(p.GetEntryOrder(), wantOrder); diff != "" {
+		t.Errorf("Proto().EntryOrder diff (-got, +want): %s", diff)
+	}
+	if got := certTableFromProto(t, p).Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("CertTableFromProto(Proto()).Marshal() = %v, want %v", got, want)
+	}
+
+	// The order survives serializing the proto.
+	out, err := proto.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reread := &spb.CertificateChain{}
+	if err := proto.Unmarshal(out, reread); err != nil {
+		t.Fatal(err)
+	}
+	if got := certTableFromProto(t, reread).Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("CertTableFromProto(proto.Unmarshal(proto.Marshal(Proto()))).Marshal() = %v, want %v", got, want)
+	}
+
+	// A chain without an order, e.g., from an older producer, gets the known entries first.
+	p.EntryOrder = nil
+	reordered := []CertTableEntry{table.Entries[2], table.Entries[4], table.Entries[0], table.Entries[3], table.Entries[1]}
+	if got, want := certTableFromProto(t, p).Marshal(), (&CertTable{Entries: reordered}).Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("CertTableFromProto(no EntryOrder).Marshal() = %v, want %v", got, want)
+	}
+}
+
+func TestCertTableFromProtoErrors(t *testing.T) {
+	tcs := []struct {
+		name  string
+		chain *spb.CertificateChain
+		want  string
+	}{
+		{
+			name: "malformed extras key",
+			chain: &spb.CertificateChain{
+				ArkCert: []byte("ark"),
+				Extras:  map[string][]byte{"not-a-guid": []byte("vendor")},
+			},
+			want: `entry "not-a-guid" is not a GUID`,
+		},
+		{
+			name: "malformed entry order",
+			chain: &spb.CertificateChain{
+				Extras:     map[string][]byte{"not-a-guid": []byte("vendor")},
+				EntryOrder: []string{"not-a-guid"},
+			},
+			want: `entry "not-a-guid" is not a GUID`,
+		},
+		{
+			name: "entry order without its extra",
+			chain: &spb.CertificateChain{
+				ArkCert:    []byte("ark"),
+				EntryOrder: []string{ArkGUID, "00000000-0000-c0de-0000-000000000001"},
+			},
+			want: "lists 00000000-0000-c0de-0000-000000000001, which has no certificate",
+		},
+		{
+			name: "entry order without its VCEK",
+			chain: &spb.CertificateChain{
+				ArkCert:    []byte("ark"),
+				EntryOrder: []string{VcekGUID, ArkGUID},
+			},
+			want: "lists " + VcekGUID + ", which has no certificate",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CertTableFromProto(tc.chain); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("CertTableFromProto(%v) = _, %v, want an error containing %q", tc.chain, err, tc.want)
+			}
+			defer func() {
+				if recover() == nil {
+					t.Errorf("CertsFromProto(%v) did not panic", tc.chain)
+				}
+			}()
+			CertsFromProto(tc.chain)
+		})
+	}
+}
+
+func TestCertTableUnmarshalErrors(t *testing.T) {
+	header := func(entries ...CertTableHeaderEntry) []byte {
+		// All entries and the NULL terminator, followed by 16 bytes of certificate data.
+		result := make([]byte, (len(entries)+1)*CertTableEntrySize+16)
+		for i := range entries {
+			if err := (&entries[i]).Write(result[i*CertTableEntrySize:]); err != nil {
+				t.Fatalf("could not write header %d: %v", i, err)
+			}
+		}
+		return result
+	}
+	ark := uuid.MustParse(ArkGUID)
+	ask := uuid.MustParse(AskGUID)
+	tcs := []struct {
+		name    string
+		table   []byte
+		wantErr string
+	}{
+		{
+			name:    "no terminator",
+			table:   header(CertTableHeaderEntry{GUID: ark, Offset: 48, Length: 4})[:CertTableEntrySize+4],
+			wantErr: "cert table index 24 entry unmarshalling error: data too small: 4, want 24",
+		},
+		{
+			name:    "offset into header",
+			table:   header(CertTableHeaderEntry{GUID: ark, Offset: 8, Length: 4}),
+			wantErr: "cert table entry 0 has invalid offset into header (size 48): 8, guid=" + ArkGUID,
+		},
+		{
+			name:    "past end",
+			table:   header(CertTableHeaderEntry{GUID: ark, Offset: 48, Length: 17}),
+			wantErr: "cert table entry 0 specifies a byte range outside the certificate data block (size 64): offset=48, length=17, guid=" + ArkGUID,
+		},
+		{
+			name:    "length overflow",
+			table:   header(CertTableHeaderEntry{GUID: ark, Offset: 48, Length: 0xffffffff}),
+			wantErr: "cert table entry 0 specifies a byte range outside the certificate data block (size 64)",
+		},
+		{
+			name: "overlap",
+			table: header(CertTableHeaderEntry{GUID: ark, Offset: 72, Length: 8},
+				CertTableHeaderEntry{GUID: ask, Offset: 76, Length: 12}),
+			wantErr: "cert table entries 0 (offset=72, length=8) and 1 (offset=76, length=12) overlap, guids=" + ArkGUID + " and " + AskGUID,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			c := new(CertTable)
+			if err := c.Unmarshal(tc.table); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("c.Unmarshal(%s) = %v, want error %q", hex.Dump(tc.table), err, tc.wantErr)
+			}
+		})
+	}
+	// Adjacent entries are fine.
+	c := new(CertTable)
+	table := header(CertTableHeaderEntry{GUID: ark, Offset: 72, Length: 4},
+		CertTableHeaderEntry{GUID: ask, Offset: 76, Length: 12})
+	if err := c.Unmarshal(table); err != nil {
+		t.Errorf("c.Unmarshal(%s) = %v, want nil", hex.Dump(table), err)
+	}
+	if got, err := c.GetByGUIDString(AskGUID); err != nil || len(got) != 12 {
+		t.Errorf("c.GetByGUIDString(%q) = %v, %v. Want 12 bytes", AskGUID, got, err)
+	}
+	if _, err := c.GetByGUIDString(VcekGUID); err == nil {
+		t.Errorf("c.GetByGUIDString(%q) = _, nil. Want error", VcekGUID)
+	}
+}
+
 func TestSevProduct(t *testing.T) {
 	oldCpuid := cpuid
 	defer func() { cpuid = oldCpuid }()
@@ -343,11 +1491,42 @@ func TestSevProduct(t *testing.T) {
 				MachineStepping: &wrapperspb.UInt32Value{Value: 2}},
 		},
 		{
-			eax: 0x0b010f0,
+			// Bergamo and Siena
+			eax: 0x00aa0f01,
+			want: &spb.SevProduct{
+				Name:            spb.SevProduct_SEV_PRODUCT_GENOA,
+				MachineStepping: &wrapperspb.UInt32Value{Value: 1}},
+		},
+		{
+			eax: 0x00b00f21,
+			want: &spb.SevProduct{
+				Name:            spb.SevProduct_SEV_PRODUCT_TURIN,
+				MachineStepping: &wrapperspb.UInt32Value{Value: 1}},
+		},
+		{
+			eax: 0x00b10f10,
+			want: &spb.SevProduct{
+				Name:            spb.SevProduct_SEV_PRODUCT_TURIN,
+				MachineStepping: &wrapperspb.UInt32Value{Value: 0}},
+		},
+		{
+			eax: 0x00b20f13,
 			want: &spb.SevProduct{
 				Name:            spb.SevProduct_SEV_PRODUCT_UNKNOWN,
 				MachineStepping: &wrapperspb.UInt32Value{Value: 0}},
 		},
+		{
+			eax: 0x00a20f12,
+			want: &spb.SevProduct{
+				Name:            spb.SevProduct_SEV_PRODUCT_UNKNOWN,
+				MachineStepping: &wrapperspb.UInt32Value{Value: 0}},
+		},
+		{
+			eax: 0x00800f12,
+			want: &spb.SevProduct{
+				Name:            spb.SevProduct_SEV_PRODUCT_UNKNOWN,
+				MachineStepping: &wrapperspb.UInt32Value{Value: 2}},
+		},
 	}
 	for _, tc := range tcs {
 		cpuid = func(uint32) (uint32, uint32, uint32, uint32) { return tc.eax, 0, 0, 0 }
@@ -362,6 +1541,43 @@ func TestSevProduct(t *testing.T) {
 	}
 }
 
+func TestSevProductOrError(t *testing.T) {
+	oldCpuid, oldHasCpuid := cpuid, hasCpuid
+	defer func() { cpuid, hasCpuid = oldCpuid, oldHasCpuid }()
+	cpuid = func(uint32) (uint32, uint32, uint32, uint32) { return 0x00b00f21, 0, 0, 0 }
+
+	hasCpuid = false
+	if _, err := SevProductOrError(); !errors.Is(err, ErrNoCpuid) {
+		t.Errorf("SevProductOrError() = _, %v without cpuid. Want %v", err, ErrNoCpuid)
+	}
+	hasCpuid = true
+	got, err := SevProductOrError()
+	if err != nil {
+		t.Fatalf("SevProductOrError() = _, %v. Want nil", err)
+	}
+	if got.Name != spb.SevProduct_SEV_PRODUCT_TURIN {
+		t.Errorf("SevProductOrError() = %v. Want %v", got.Name, spb.SevProduct_SEV_PRODUCT_TURIN)
+	}
+}
+
+func TestMaskedCpuid1EaxFromSevProduct(t *testing.T) {
+	for _, name := range []spb.SevProduct_SevProductName{
+		spb.SevProduct_SEV_PRODUCT_MILAN,
+		spb.SevProduct_SEV_PRODUCT_GENOA,
+		spb.SevProduct_SEV_PRODUCT_TURIN,
+	} {
+		want := &spb.SevProduct{Name: name, MachineStepping: &wrapperspb.UInt32Value{Value: 1}}
+		eax := MaskedCpuid1EaxFromSevProduct(want)
+		got := SevProductFromCpuid1Eax(eax)
+		if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+			t.Errorf("SevProductFromCpuid1Eax(MaskedCpuid1EaxFromSevProduct(%v)) diff (-got, +want): %s", want, diff)
+		}
+	}
+	if got := MaskedCpuid1EaxFromSevProduct(&spb.SevProduct{}); got != 0 {
+		t.Errorf("MaskedCpuid1EaxFromSevProduct(unknown) = 0x%x. Want 0", got)
+	}
+}
+
 func TestExtendedPlatformCertTableConservation(t *testing.T) {
 	// If VCEK is in the cert table, then the product info isn't added to the cert table.
 	table := testRawCertTable(t).table
@@ -401,6 +1617,7 @@ func TestExtendedPlatformCertTable(t *testing.T) {
 		{name: "Genoa-B2 cruft", pname: spb.SevProduct_SEV_PRODUCT_GENOA, eax: 0x00a10f12, stepping: 2},
 		{name: "Milan-B1 cruft", pname: spb.SevProduct_SEV_PRODUCT_MILAN, eax: 0x00a00f11, stepping: 1},
 		{name: "Milan-B0", pname: spb.SevProduct_SEV_PRODUCT_MILAN, eax: 0x00a00f00, stepping: 0},
+		{name: "Turin-C1", pname: spb.SevProduct_SEV_PRODUCT_TURIN, eax: 0x00b00f21, stepping: 1},
 	}
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
@@ -440,3 +1657,26 @@ func TestExtendedPlatformCertTable(t *testing.T) {
 		})
 	}
 }
+
+func TestEcdsaPublicKeyDigest(t *testing.T) {
+	// The P-384 base point is the public key for private key 1. The expected digest is SHA-384 over
+	// the 0x404-byte key layout: CURVE=2, QX and QY as 72-byte little-endian values, then zeros.
+	p384 := elliptic.P384()
+	key := &ecdsa.PublicKey{Curve: p384, X: p384.Params().Gx, Y: p384.Params().Gy}
+	want, _ := hex.DecodeString("01ce77b697886b46c6e363a9d02bbee2506e4b21ca4b3085f35f20e4cf66ac780e01e38aad53f973cbcea56e59a7b382")
+	got, err := EcdsaPublicKeyDigest(key)
+	if err != nil {
+		t.Fatalf("EcdsaPublicKeyDigest(G) = %v. Want nil", err)
+	}
+	if !bytes.Equal(got[:], want) {
+		t.Errorf("EcdsaPublicKeyDigest(G) = %x. Want %x", got, want)
+	}
+
+	p256 := elliptic.P256()
+	if _, err := EcdsaPublicKeyDigest(&ecdsa.PublicKey{Curve: p256, X: p256.Params().Gx, Y: p256.Params().Gy}); err == nil {
+		t.Error("EcdsaPublicKeyDigest(P-256 key) = nil. Want an error")
+	}
+	if _, err := EcdsaPublicKeyDigest(nil); err == nil {
+		t.Error("EcdsaPublicKeyDigest(nil) = nil. Want an error")
+	}
+}
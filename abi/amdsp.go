@@ -100,75 +100,139 @@ const (
 	// restoreRequired = 37
 )
 
+// InvalidKey is the code for when the requested key is invalid, not present, or not allowed, e.g.,
+// a key rooted in the VLEK on a platform without one installed.
+const InvalidKey SevFirmwareStatus = 39
+
 // GuestRequestInvalidLength is set by the ccp driver and not the AMD-SP when an guest extended
 // request provides too few pages for the firmware to populate with data.
 const GuestRequestInvalidLength SevFirmwareStatus = 0x100000000
 
+// GuestRequestBusy is set by the ccp driver and not the AMD-SP when the host is throttling guest
+// requests and the request may be sent again later.
+const GuestRequestBusy SevFirmwareStatus = 0x200000000
+
+// GuestRequestNoFirmwareCall is set by the sev-guest driver, and not the AMD-SP, when it fails a
+// guest request without sending it to the firmware, e.g., because it disabled its VMPCK. It is
+// Linux's SEV_RET_NO_FW_CALL.
+const GuestRequestNoFirmwareCall SevFirmwareStatus = -1
+
+// GuestRequestNoFirmwareCallFwErr is GuestRequestNoFirmwareCall as the __u64 firmware error that
+// the sev-guest driver writes back to a guest request.
+const GuestRequestNoFirmwareCallFwErr uint64 = 1<<64 - 1
+
+var sevFirmwareStatusNames = map[SevFirmwareStatus]string{
+	Success:                    "SUCCESS",
+	InvalidPlatformState:       "INVALID_PLATFORM_STATE",
+	InvalidGuestState:          "INVALID_GUEST_STATE",
+	3:                          "INVALID_CONFIG",
+	InvalidLength:              "INVALID_LENGTH",
+	5:                          "ALREADY_OWNED",
+	6:                          "INVALID_CERTIFICATE",
+	PolicyFailure:              "POLICY_FAILURE",
+	Inactive:                   "INACTIVE",
+	InvalidAddress:             "INVALID_ADDRESS",
+	10:                         "BAD_SIGNATURE",
+	11:                         "BAD_MEASUREMENT",
+	12:                         "ASID_OWNED",
+	13:                         "INVALID_ASID",
+	14:                         "WBINVD_REQUIRED",
+	15:                         "DF_FLUSH_REQUIRED",
+	16:                         "INVALID_GUEST",
+	InvalidCommand:             "INVALID_COMMAND",
+	18:                         "ACTIVE",
+	HwErrorPlatform:            "HWERROR_PLATFORM",
+	HwErrorUnsafe:              "HWERROR_UNSAFE",
+	Unsupported:                "UNSUPPORTED",
+	InvalidParam:               "INVALID_PARAM",
+	ResourceLimit:              "RESOURCE_LIMIT",
+	SecureDataInvalid:          "SECURE_DATA_INVALID",
+	InvalidPageSize:            "INVALID_PAGE_SIZE",
+	InvalidPageState:           "INVALID_PAGE_STATE",
+	InvalidMdataEntry:          "INVALID_MDATA_ENTRY",
+	InvalidPageOwner:           "INVALID_PAGE_OWNER",
+	AeadOflow:                  "AEAD_OFLOW",
+	31:                         "RB_MODE_EXITED",
+	32:                         "RMP_INIT_REQUIRED",
+	33:                         "BAD_SVN",
+	34:                         "BAD_VERSION",
+	35:                         "SHUTDOWN_REQUIRED",
+	36:                         "UPDATE_FAILED",
+	37:                         "RESTORE_REQUIRED",
+	38:                         "RMP_INITIALIZATION_FAILED",
+	InvalidKey:                 "INVALID_KEY",
+	GuestRequestInvalidLength:  "GUEST_REQUEST_INVALID_LENGTH",
+	GuestRequestBusy:           "GUEST_REQUEST_BUSY",
+	GuestRequestNoFirmwareCall: "NO_FW_CALL",
+}
+
+// String returns the SEV API specification's name for the status code.
+func (s SevFirmwareStatus) String() string {
+	if name, ok := sevFirmwareStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("SevFirmwareStatus(0x%x)", uint64(s))
+}
+
 // SevFirmwareErr is an error that interprets firmware status codes from the AMD secure processor.
+// Use errors.As to recover the Status from an error returned by a device command.
 type SevFirmwareErr struct {
 	Status SevFirmwareStatus
 }
 
-func (e *SevFirmwareErr) Error() string {
-	if e.Status == Success {
-		return "success"
-	}
-	if e.Status == InvalidPlatformState {
+func (e *SevFirmwareErr) description() string {
+	switch e.Status {
+	case InvalidPlatformState:
 		return "platform state is invalid for this command"
-	}
-	if e.Status == InvalidGuestState {
+	case InvalidGuestState:
 		return "guest state is invalid for this command"
-	}
-	if e.Status == InvalidLength {
+	case InvalidLength:
 		return "memory buffer is too small (library bug, please report)"
-	}
-	if e.Status == PolicyFailure {
+	case PolicyFailure:
 		return "request is not allowed by guest policy"
-	}
-	if e.Status == Inactive {
+	case Inactive:
 		return "guest is inactive"
-	}
-	if e.Status == InvalidAddress {
+	case InvalidAddress:
 		return "address provided is invalid (library bug, please report)"
-	}
-	if e.Status == InvalidCommand {
+	case InvalidCommand:
 		return "invalid command (library bug, please report)"
-	}
-	if e.Status == HwErrorPlatform {
+	case HwErrorPlatform:
 		return "hardware condition has occurred affecting the platform (report to sysadmin)"
-	}
-	if e.Status == HwErrorUnsafe {
+	case HwErrorUnsafe:
 		return "hardware condition has occurred affecting the platform. Buffers unsafe (report to sysadmin)"
-	}
-	if e.Status == Unsupported {
+	case Unsupported:
 		return "unsupported feature"
-	}
-	if e.Status == InvalidParam {
+	case InvalidParam:
 		return "invalid parameter (library bug, please report)"
-	}
-	if e.Status == ResourceLimit {
-		return "SEV firmware has run out of recources necessary to complete the command"
-	}
-	if e.Status == SecureDataInvalid {
+	case ResourceLimit:
+		return "SEV firmware has run out of resources necessary to complete the command"
+	case SecureDataInvalid:
 		return "part-specific SEV data failed integrity checks (report to sysadmin)"
-	}
-	if e.Status == InvalidPageSize {
+	case InvalidPageSize:
 		return "RMP: invalid page size"
-	}
-	if e.Status == InvalidPageState {
+	case InvalidPageState:
 		return "RMP: invalid page state"
-	}
-	if e.Status == InvalidMdataEntry {
+	case InvalidMdataEntry:
 		return "RMP: invalid recorded metadata"
-	}
-	if e.Status == InvalidPageOwner {
+	case InvalidPageOwner:
 		return "RMP: ASID mismatch between accessors"
-	}
-	if e.Status == AeadOflow {
+	case AeadOflow:
 		return "AMD-SP firmware memory would be over capacity for AEAD use"
-	}
-	if e.Status == GuestRequestInvalidLength {
+	case InvalidKey:
+		return "requested key is invalid, not installed, or not allowed"
+	case GuestRequestInvalidLength:
 		return "too few extended guest request data pages"
+	case GuestRequestBusy:
+		return "host is throttling guest requests"
+	case GuestRequestNoFirmwareCall:
+		return "the sev-guest driver did not send the request to the firmware"
+	}
+	return "unexpected firmware status (see SEV API spec)"
+}
+
+func (e *SevFirmwareErr) Error() string {
+	if e.Status == Success {
+		return "success"
 	}
-	return fmt.Sprintf("unexpected firmware status (see SEV API spec): %x", uint64(e.Status))
+	return fmt.Sprintf("%s (0x%x): %s", e.Status, uint64(e.Status), e.description())
 }
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abi
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSevFirmwareStatusString(t *testing.T) {
+	tcs := []struct {
+		status SevFirmwareStatus
+		want   string
+	}{
+		{status: Success, want: "SUCCESS"},
+		{status: InvalidParam, want: "INVALID_PARAM"},
+		{status: ResourceLimit, want: "RESOURCE_LIMIT"},
+		{status: 10, want: "BAD_SIGNATURE"},
+		{status: 39, want: "INVALID_KEY"},
+		{status: GuestRequestInvalidLength, want: "GUEST_REQUEST_INVALID_LENGTH"},
+		{status: GuestRequestBusy, want: "GUEST_REQUEST_BUSY"},
+		{status: 30, want: "SevFirmwareStatus(0x1e)"},
+		{status: 0x1234, want: "SevFirmwareStatus(0x1234)"},
+	}
+	for _, tc := range tcs {
+		if got := tc.status.String(); got != tc.want {
+			t.Errorf("SevFirmwareStatus(%d).String() = %q, want %q", int64(tc.status), got, tc.want)
+		}
+	}
+	// Every named code besides the reserved 0x1E up to the last spec-defined code has a name.
+	for s := Success; s <= 39; s++ {
+		if _, ok := sevFirmwareStatusNames[s]; !ok && s != 30 {
+			t.Errorf("SevFirmwareStatus(0x%x) has no name", int64(s))
+		}
+	}
+}
+
+func TestSevFirmwareErr(t *testing.T) {
+	err := fmt.Errorf("ioctl failed: %w", &SevFirmwareErr{Status: InvalidParam})
+	want := "ioctl failed: INVALID_PARAM (0x16): invalid parameter (library bug, please report)"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	var fwErr *SevFirmwareErr
+	if !errors.As(err, &fwErr) {
+		t.Fatalf("errors.As(%v, *SevFirmwareErr) = false, want true", err)
+	}
+	if fwErr.Status != InvalidParam {
+		t.Errorf("errors.As(%v, *SevFirmwareErr) status = %v, want %v", err, fwErr.Status, InvalidParam)
+	}
+	if got := (&SevFirmwareErr{Status: Success}).Error(); got != "success" {
+		t.Errorf("Success Error() = %q, want \"success\"", got)
+	}
+}
@@ -7,4 +7,5 @@ func asmCpuid(op uint32) (eax, ebx, ecx, edx uint32)
 
 func init() {
 	cpuid = asmCpuid
+	hasCpuid = true
 }
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abi
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DefaultDiffIgnoredFields are the report fields that DiffReports ignores when not given an
+// explicit list. They change with every report fetched, even from the same VM. The raw field holds
+// the signature of reports with an unknown version.
+var DefaultDiffIgnoredFields = []string{"signature", "report_id", "report_data", "raw"}
+
+// FieldDiff represents a report field whose value differs between two reports.
+type FieldDiff struct {
+	// Field is the proto name of the report field, e.g., "measurement".
+	Field string
+	// A is the field's value in the first report. Byte fields are rendered in hex.
+	A string
+	// B is the field's value in the second report. Byte fields are rendered in hex.
+	B string
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %s != %s", d.Field, d.A, d.B)
+}
+
+func renderField(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	v := m.Get(fd)
+	if fd.Kind() == protoreflect.BytesKind {
+		return hex.EncodeToString(v.Bytes())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// DiffReports returns the fields that differ between reports a and b in the order the Report proto
+// declares them. Fields named in ignore are not compared. If ignore is nil,
+// DefaultDiffIgnoredFields is used instead.
+func DiffReports(a, b *pb.Report, ignore []string) []FieldDiff {
+	if ignore == nil {
+		ignore = DefaultDiffIgnoredFields
+	}
+	ignored := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		ignored[name] = true
+	}
+	ma := a.ProtoReflect()
+	mb := b.ProtoReflect()
+	var result []FieldDiff
+	fields := ma.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if ignored[string(fd.Name())] {
+			continue
+		}
+		va := renderField(ma, fd)
+		vb := renderField(mb, fd)
+		if va != vb {
+			result = append(result, FieldDiff{Field: string(fd.Name()), A: va, B: vb})
+		}
+	}
+	return result
+}
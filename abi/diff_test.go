@@ -0,0 +1,99 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abi
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDiffReports(t *testing.T) {
+	base := &pb.Report{
+		Version:     ReportVersion3,
+		GuestSvn:    1,
+		Measurement: []byte{0x01, 0x02},
+		ReportData:  []byte{0x03},
+		ReportId:    []byte{0x04},
+		Signature:   []byte{0x05},
+		ReportedTcb: 0x10,
+	}
+	changed := proto.Clone(base).(*pb.Report)
+	changed.GuestSvn = 2
+	changed.Measurement = []byte{0x01, 0xff}
+	changed.ReportData = []byte{0x33}
+	changed.ReportId = []byte{0x44}
+	changed.Signature = []byte{0x55}
+
+	tcs := []struct {
+		name   string
+		a, b   *pb.Report
+		ignore []string
+		want   []FieldDiff
+	}{
+		{
+			name: "same",
+			a:    base,
+			b:    proto.Clone(base).(*pb.Report),
+		},
+		{
+			name: "volatile fields only",
+			a:    base,
+			b: func() *pb.Report {
+				r := proto.Clone(base).(*pb.Report)
+				r.ReportData = []byte{0x33}
+				r.ReportId = []byte{0x44}
+				r.Signature = []byte{0x55}
+				return r
+			}(),
+		},
+		{
+			name: "default ignore",
+			a:    base,
+			b:    changed,
+			want: []FieldDiff{
+				{Field: "guest_svn", A: "1", B: "2"},
+				{Field: "measurement", A: "0102", B: "01ff"},
+			},
+		},
+		{
+			name:   "explicit ignore",
+			a:      base,
+			b:      changed,
+			ignore: []string{"guest_svn", "signature"},
+			want: []FieldDiff{
+				{Field: "report_data", A: "03", B: "33"},
+				{Field: "measurement", A: "0102", B: "01ff"},
+				{Field: "report_id", A: "04", B: "44"},
+			},
+		},
+		{
+			name: "nil report",
+			a:    &pb.Report{GuestSvn: 3},
+			b:    nil,
+			want: []FieldDiff{{Field: "guest_svn", A: "3", B: "0"}},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := DiffReports(tc.a, tc.b, tc.ignore)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("DiffReports() returned unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+// formatTcb renders a TCB_VERSION with its security patch levels. The reserved SPLs only appear if
+// they are non-zero. This must agree with kds.TCBParts.String, which cannot be used here without an
+// import cycle.
+func formatTcb(tcb uint64) string {
+	var parts []string
+	parts = append(parts, fmt.Sprintf("blSpl=%d", byte(tcb)), fmt.Sprintf("teeSpl=%d", byte(tcb>>8)))
+	for i := 2; i < 6; i++ {
+		if spl := byte(tcb >> (8 * i)); spl != 0 {
+			parts = append(parts, fmt.Sprintf("spl%d=%d", i+2, spl))
+		}
+	}
+	parts = append(parts, fmt.Sprintf("snpSpl=%d", byte(tcb>>48)), fmt.Sprintf("ucodeSpl=%d", byte(tcb>>56)))
+	return fmt.Sprintf("0x%016x (%s)", tcb, strings.Join(parts, " "))
+}
+
+func formatPolicy(policy uint64) string {
+	p, err := ParseSnpPolicy(policy)
+	if err != nil {
+		return fmt.Sprintf("0x%x (invalid: %v)", policy, err)
+	}
+	return fmt.Sprintf("0x%x (abiMajor=%d abiMinor=%d smt=%t migrateMA=%t debug=%t singleSocket=%t "+
+		"cxlAllow=%t memAES256XTS=%t raplDis=%t ciphertextHidingDRAM=%t)",
+		policy, p.ABIMajor, p.ABIMinor, p.SMT, p.MigrateMA, p.Debug, p.SingleSocket,
+		p.CXLAllow, p.MemAES256XTS, p.RAPLDis, p.CiphertextHidingDRAM)
+}
+
+func formatPlatformInfo(platformInfo uint64) string {
+	info, err := ParseSnpPlatformInfo(platformInfo)
+	if err != nil {
+		return fmt.Sprintf("0x%x (invalid: %v)", platformInfo, err)
+	}
+	return fmt.Sprintf("0x%x (smtEnabled=%t tsmeEnabled=%t eccEnabled=%t raplDisabled=%t ciphertextHidingEnabled=%t)",
+		platformInfo, info.SMTEnabled, info.TSMEEnabled, info.ECCEnabled, info.RAPLDisabled,
+		info.CiphertextHidingEnabled)
+}
+
+func formatSignerInfo(signerInfo uint32) string {
+	info, err := ParseSignerInfo(signerInfo)
+	if err != nil {
+		return fmt.Sprintf("0x%x (invalid: %v)", signerInfo, err)
+	}
+	return fmt.Sprintf("0x%x (signingKey=%v maskChipKey=%t authorKeyEn=%t)", signerInfo, info.SigningKey,
+		info.MaskChipKey, info.AuthorKeyEn)
+}
+
+func formatBytes(b []byte) string {
+	if len(b) == 0 {
+		return "(empty)"
+	}
+	return hex.EncodeToString(b)
+}
+
+func formatSignatureAlgo(algo uint32) string {
+	if algo == SignEcdsaP384Sha384 {
+		return fmt.Sprintf("%d (ECDSA P-384 with SHA-384)", algo)
+	}
+	return fmt.Sprintf("%d (unknown)", algo)
+}
+
+// FormatReport renders every field of an attestation report on its own line as "name: value",
+// using the proto field names in ABI order. Byte fields are rendered in full as hex, or as
+// "(empty)" if unset. The policy, platform info, signer info, and TCB versions are followed by their
+// decoded components. The output is stable for a given report, so it is suitable for golden tests.
+func FormatReport(r *pb.Report) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "version: %d\n", r.GetVersion())
+	fmt.Fprintf(&b, "guest_svn: %d\n", r.GetGuestSvn())
+	fmt.Fprintf(&b, "policy: %s\n", formatPolicy(r.GetPolicy()))
+	fmt.Fprintf(&b, "family_id: %s\n", formatBytes(r.GetFamilyId()))
+	fmt.Fprintf(&b, "image_id: %s\n", formatBytes(r.GetImageId()))
+	fmt.Fprintf(&b, "vmpl: %d\n", r.GetVmpl())
+	fmt.Fprintf(&b, "signature_algo: %s\n", formatSignatureAlgo(r.GetSignatureAlgo()))
+	fmt.Fprintf(&b, "current_tcb: %s\n", formatTcb(r.GetCurrentTcb()))
+	fmt.Fprintf(&b, "platform_info: %s\n", formatPlatformInfo(r.GetPlatformInfo()))
+	fmt.Fprintf(&b, "signer_info: %s\n", formatSignerInfo(r.GetSignerInfo()))
+	fmt.Fprintf(&b, "report_data: %s\n", formatBytes(r.GetReportData()))
+	fmt.Fprintf(&b, "measurement: %s\n", formatBytes(r.GetMeasurement()))
+	fmt.Fprintf(&b, "host_data: %s\n", formatBytes(r.GetHostData()))
+	fmt.Fprintf(&b, "id_key_digest: %s\n", formatBytes(r.GetIdKeyDigest()))
+	fmt.Fprintf(&b, "author_key_digest: %s\n", formatBytes(r.GetAuthorKeyDigest()))
+	fmt.Fprintf(&b, "report_id: %s\n", formatBytes(r.GetReportId()))
+	fmt.Fprintf(&b, "report_id_ma: %s\n", formatBytes(r.GetReportIdMa()))
+	fmt.Fprintf(&b, "reported_tcb: %s\n", formatTcb(r.GetReportedTcb()))
+	if r.GetVersion() >= ReportVersion3 {
+		fmt.Fprintf(&b, "cpuid_fam_id: 0x%02x\n", r.GetCpuidFamId())
+		fmt.Fprintf(&b, "cpuid_mod_id: 0x%02x\n", r.GetCpuidModId())
+		fmt.Fprintf(&b, "cpuid_step: 0x%02x\n", r.GetCpuidStep())
+	}
+	fmt.Fprintf(&b, "chip_id: %s\n", formatBytes(r.GetChipId()))
+	fmt.Fprintf(&b, "committed_tcb: %s\n", formatTcb(r.GetCommittedTcb()))
+	fmt.Fprintf(&b, "current_version: %d.%d.%d\n", r.GetCurrentMajor(), r.GetCurrentMinor(), r.GetCurrentBuild())
+	fmt.Fprintf(&b, "committed_version: %d.%d.%d\n", r.GetCommittedMajor(), r.GetCommittedMinor(), r.GetCommittedBuild())
+	fmt.Fprintf(&b, "launch_tcb: %s\n", formatTcb(r.GetLaunchTcb()))
+	fmt.Fprintf(&b, "signature: %s\n", formatBytes(r.GetSignature()))
+	return b.String()
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+func formatTestReport() *pb.Report {
+	return &pb.Report{
+		Version:         ReportVersion3,
+		GuestSvn:        4,
+		Policy:          0x3001f,
+		FamilyId:        bytes.Repeat([]byte{0x01}, FamilyIDSize),
+		ImageId:         bytes.Repeat([]byte{0x02}, ImageIDSize),
+		Vmpl:            1,
+		SignatureAlgo:   SignEcdsaP384Sha384,
+		CurrentTcb:      0xd315000000000304,
+		PlatformInfo:    0x3,
+		SignerInfo:      0x5,
+		ReportData:      bytes.Repeat([]byte{0x03}, ReportDataSize),
+		Measurement:     bytes.Repeat([]byte{0x04}, MeasurementSize),
+		HostData:        bytes.Repeat([]byte{0x05}, HostDataSize),
+		IdKeyDigest:     bytes.Repeat([]byte{0x06}, IDKeyDigestSize),
+		AuthorKeyDigest: bytes.Repeat([]byte{0x07}, AuthorKeyDigestSize),
+		ReportId:        bytes.Repeat([]byte{0x08}, ReportIDSize),
+		ReportIdMa:      bytes.Repeat([]byte{0x09}, ReportIDMASize),
+		ReportedTcb:     0xd315000000000304,
+		CpuidFamId:      0x19,
+		CpuidModId:      0x11,
+		CpuidStep:       0x01,
+		ChipId:          bytes.Repeat([]byte{0x0a}, ChipIDSize),
+		CommittedTcb:    0xd315000000020304,
+		CurrentBuild:    3,
+		CurrentMinor:    55,
+		CurrentMajor:    1,
+		CommittedBuild:  2,
+		CommittedMinor:  55,
+		CommittedMajor:  1,
+		LaunchTcb:       0xd315000000000304,
+		Signature:       bytes.Repeat([]byte{0x0b}, 4),
+	}
+}
+
+func TestFormatReport(t *testing.T) {
+	tcs := []struct {
+		name   string
+		report *pb.Report
+		want   string
+	}{
+		{
+			name:   "all fields",
+			report: formatTestReport(),
+			want: `version: 3
+guest_svn: 4
+policy: 0x3001f (abiMajor=0 abiMinor=31 smt=true migrateMA=false debug=false singleSocket=false cxlAllow=false memAES256XTS=false raplDis=false ciphertextHidingDRAM=false)
+family_id: 01010101010101010101010101010101
+image_id: 02020202020202020202020202020202
+vmpl: 1
+signature_algo: 1 (ECDSA P-384 with SHA-384)
+current_tcb: 0xd315000000000304 (blSpl=4 teeSpl=3 snpSpl=21 ucodeSpl=211)
+platform_info: 0x3 (smtEnabled=true tsmeEnabled=true eccEnabled=false raplDisabled=false ciphertextHidingEnabled=false)
+signer_info: 0x5 (signingKey=VLEK maskChipKey=false authorKeyEn=true)
+report_data: 03030303030303030303030303030303030303030303030303030303030303030303030303030303030303030303030303030303030303030303030303030303
+measurement: 040404040404040404040404040404040404040404040404040404040404040404040404040404040404040404040404
+host_data: 0505050505050505050505050505050505050505050505050505050505050505
+id_key_digest: 060606060606060606060606060606060606060606060606060606060606060606060606060606060606060606060606
+author_key_digest: 070707070707070707070707070707070707070707070707070707070707070707070707070707070707070707070707
+report_id: 0808080808080808080808080808080808080808080808080808080808080808
+report_id_ma: 0909090909090909090909090909090909090909090909090909090909090909
+reported_tcb: 0xd315000000000304 (blSpl=4 teeSpl=3 snpSpl=21 ucodeSpl=211)
+cpuid_fam_id: 0x19
+cpuid_mod_id: 0x11
+cpuid_step: 0x01
+chip_id: 0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a
+committed_tcb: 0xd315000000020304 (blSpl=4 teeSpl=3 spl4=2 snpSpl=21 ucodeSpl=211)
+current_version: 1.55.3
+committed_version: 1.55.2
+launch_tcb: 0xd315000000000304 (blSpl=4 teeSpl=3 snpSpl=21 ucodeSpl=211)
+signature: 0b0b0b0b
+`,
+		},
+		{
+			name:   "undecodable fields",
+			report: &pb.Report{Policy: 1, PlatformInfo: 1 << 40, SignerInfo: 0xff, SignatureAlgo: 2},
+			want: `version: 0
+guest_svn: 0
+policy: 0x1 (invalid: policy[17] is reserved, must be 1, got 0)
+family_id: (empty)
+image_id: (empty)
+vmpl: 0
+signature_algo: 2 (unknown)
+current_tcb: 0x0000000000000000 (blSpl=0 teeSpl=0 snpSpl=0 ucodeSpl=0)
+platform_info: 0x10000000000 (invalid: unrecognized platform info bit(s): 0x10000000000)
+signer_info: 0xff (invalid: mbz range data[0x48:0x4C][0x5:0x1f] not all zero: ff)
+report_data: (empty)
+measurement: (empty)
+host_data: (empty)
+id_key_digest: (empty)
+author_key_digest: (empty)
+report_id: (empty)
+report_id_ma: (empty)
+reported_tcb: 0x0000000000000000 (blSpl=0 teeSpl=0 snpSpl=0 ucodeSpl=0)
+chip_id: (empty)
+committed_tcb: 0x0000000000000000 (blSpl=0 teeSpl=0 snpSpl=0 ucodeSpl=0)
+current_version: 0.0.0
+committed_version: 0.0.0
+launch_tcb: 0x0000000000000000 (blSpl=0 teeSpl=0 snpSpl=0 ucodeSpl=0)
+signature: (empty)
+`,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, FormatReport(tc.report)); diff != "" {
+				t.Errorf("FormatReport() returned unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abi_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-sev-guest/abi"
+	test "github.com/google/go-sev-guest/testing"
+)
+
+var (
+	mockOnce   sync.Once
+	mockReport []byte
+	mockCerts  []byte
+	mockErr    error
+)
+
+func makeMockSignedReport() ([]byte, []byte, error) {
+	signer, err := test.DefaultTestOnlyCertChain(test.GetProductName(), time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return nil, nil, err
+	}
+	raw := make([]byte, abi.ReportSize)
+	raw[0x00] = abi.ReportVersion3
+	binary.LittleEndian.PutUint64(raw[0x08:0x10], abi.SnpPolicyToBytes(abi.SnpPolicy{SMT: true}))
+	binary.LittleEndian.PutUint32(raw[0x34:0x38], abi.SignEcdsaP384Sha384)
+	for i := 0x50; i < 0x90; i++ {
+		raw[i] = byte(i) // REPORT_DATA
+	}
+	r, s, err := signer.Sign(abi.SignedComponent(raw))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := abi.SetSignature(r, s, raw); err != nil {
+		return nil, nil, err
+	}
+	certs, err := signer.CertTableBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, certs, nil
+}
+
+// mockSignedReport returns a report signed by the mock signer and its certificate table as seeds
+// for the fuzzers to start from valid structures.
+func mockSignedReport(f *testing.F) ([]byte, []byte) {
+	f.Helper()
+	mockOnce.Do(func() { mockReport, mockCerts, mockErr = makeMockSignedReport() })
+	if mockErr != nil {
+		f.Fatal(mockErr)
+	}
+	return mockReport, mockCerts
+}
+
+func FuzzReportToProto(f *testing.F) {
+	raw, certs := mockSignedReport(f)
+	f.Add(raw)
+	f.Add(raw[:abi.ReportSize-1])
+	f.Add(append(append([]byte{}, raw...), certs...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		formatErr := abi.ValidateReportFormat(data)
+		report, err := abi.ReportToProto(data)
+		if err != nil {
+			if formatErr == nil {
+				t.Fatalf("ValidateReportFormat(%x) = nil, but ReportToProto errored: %v", data, err)
+			}
+			return
+		}
+		got, err := abi.ReportToAbiBytes(report)
+		if err != nil {
+			t.Fatalf("ReportToAbiBytes(ReportToProto(%x)) = _, %v. Want nil", data, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("ReportToAbiBytes(ReportToProto(%x)) = %x. Want identity", data, got)
+		}
+	})
+}
+
+func FuzzCertTableUnmarshal(f *testing.F) {
+	_, certs := mockSignedReport(f)
+	f.Add(certs)
+	f.Add(certs[:abi.CertTableEntrySize])
+	f.Add(make([]byte, abi.CertTableEntrySize))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		table := new(abi.CertTable)
+		if err := table.Unmarshal(data); err != nil {
+			return
+		}
+		again := new(abi.CertTable)
+		if err := again.Unmarshal(table.Marshal()); err != nil {
+			t.Fatalf("CertTable.Unmarshal(CertTable.Marshal()) = %v. Want nil", err)
+		}
+		if diff := cmp.Diff(table.Entries, again.Entries); diff != "" {
+			t.Fatalf("CertTable.Marshal() round trip changed entries (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func FuzzReportSignature(f *testing.F) {
+	raw, _ := mockSignedReport(f)
+	f.Add(raw)
+	f.Add(raw[:abi.ReportSize-1])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		der, err := abi.ReportToSignatureDER(data)
+		if err != nil {
+			return
+		}
+		r, s, err := abi.ReportSignatureRS(data)
+		if err != nil {
+			t.Fatalf("ReportSignatureRS(%x) = _, _, %v, but ReportToSignatureDER succeeded", data, err)
+		}
+		gotR, gotS, err := abi.SignatureDERToRS(der)
+		if err != nil {
+			t.Fatalf("SignatureDERToRS(ReportToSignatureDER(%x)) = _, _, %v. Want nil", data, err)
+		}
+		if gotR.Cmp(r) != 0 || gotS.Cmp(s) != 0 {
+			t.Fatalf("SignatureDERToRS(ReportToSignatureDER(%x)) = %v, %v. Want %v, %v", data, gotR, gotS, r, s)
+		}
+	})
+}
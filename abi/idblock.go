@@ -0,0 +1,198 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abi
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// IdBlockSize is the byte size of the ID block structure given to SNP_LAUNCH_FINISH.
+	IdBlockSize = 0x60
+	// IdBlockVersion is the only ID block structure version defined by the SNP API specification.
+	IdBlockVersion = 1
+	// IdAuthInfoSize is the byte size of the ID authentication information structure given to
+	// SNP_LAUNCH_FINISH.
+	IdAuthInfoSize = 0x1000
+
+	idAuthIDKeyAlgoOffset     = 0x00
+	idAuthAuthorKeyAlgoOffset = 0x04
+	idAuthIDBlockSigOffset    = 0x40
+	idAuthIDKeyOffset         = 0x240
+	idAuthIDKeySigOffset      = 0x680
+	idAuthAuthorKeyOffset     = 0x880
+)
+
+// IdBlock represents the SNP API's ID_BLOCK structure that the guest owner may provide at launch
+// to attest to the expected launch digest and guest identity.
+type IdBlock struct {
+	// LaunchDigest is the expected launch digest of the guest.
+	LaunchDigest [MeasurementSize]byte
+	// FamilyID is the family ID of the guest, provided by the guest owner.
+	FamilyID [FamilyIDSize]byte
+	// ImageID is the image ID of the guest, provided by the guest owner.
+	ImageID [ImageIDSize]byte
+	// Version is the version of the ID block format. Must be IdBlockVersion.
+	Version uint32
+	// GuestSvn is the security version number of the guest.
+	GuestSvn uint32
+	// Policy is the guest policy the guest must be launched with.
+	Policy uint64
+}
+
+// Marshal returns the ABI format of the ID block.
+func (b *IdBlock) Marshal() []byte {
+	data := make([]byte, IdBlockSize)
+	copy(data[0x00:0x30], b.LaunchDigest[:])
+	copy(data[0x30:0x40], b.FamilyID[:])
+	copy(data[0x40:0x50], b.ImageID[:])
+	binary.LittleEndian.PutUint32(data[0x50:0x54], b.Version)
+	binary.LittleEndian.PutUint32(data[0x54:0x58], b.GuestSvn)
+	binary.LittleEndian.PutUint64(data[0x58:0x60], b.Policy)
+	return data
+}
+
+// Unmarshal populates the ID block from its ABI format, or errors if the data is malformed.
+func (b *IdBlock) Unmarshal(data []byte) error {
+	if len(data) != IdBlockSize {
+		return fmt.Errorf("ID block size is %d bytes. Expected %d bytes", len(data), IdBlockSize)
+	}
+	version := binary.LittleEndian.Uint32(data[0x50:0x54])
+	if version != IdBlockVersion {
+		return fmt.Errorf("ID block version is %d. Expected %d", version, IdBlockVersion)
+	}
+	policy := binary.LittleEndian.Uint64(data[0x58:0x60])
+	if _, err := ParseSnpPolicy(policy); err != nil {
+		return fmt.Errorf("malformed ID block guest policy: %v", err)
+	}
+	copy(b.LaunchDigest[:], data[0x00:0x30])
+	copy(b.FamilyID[:], data[0x30:0x40])
+	copy(b.ImageID[:], data[0x40:0x50])
+	b.Version = version
+	b.GuestSvn = binary.LittleEndian.Uint32(data[0x54:0x58])
+	b.Policy = policy
+	return nil
+}
+
+// IdAuthInfo represents the SNP API's ID_AUTH_INFO structure that authenticates an ID block with
+// the ID key, and optionally the ID key with the author key.
+type IdAuthInfo struct {
+	// IDKeyAlgo is the signature algorithm of the ID key.
+	IDKeyAlgo uint32
+	// AuthorKeyAlgo is the signature algorithm of the author key.
+	AuthorKeyAlgo uint32
+	// IDBlockSig is the signature of the ID block by the ID key.
+	IDBlockSig [SignatureSize]byte
+	// IDKey is the public ID key in the AMD SEV ABI format.
+	IDKey [EcsdaPublicKeySize]byte
+	// IDKeySig is the signature of IDKey by the author key.
+	IDKeySig [SignatureSize]byte
+	// AuthorKey is the public author key in the AMD SEV ABI format.
+	AuthorKey [EcsdaPublicKeySize]byte
+}
+
+// Marshal returns the ABI format of the ID authentication information.
+func (a *IdAuthInfo) Marshal() []byte {
+	data := make([]byte, IdAuthInfoSize)
+	binary.LittleEndian.PutUint32(data[idAuthIDKeyAlgoOffset:idAuthIDKeyAlgoOffset+4], a.IDKeyAlgo)
+	binary.LittleEndian.PutUint32(data[idAuthAuthorKeyAlgoOffset:idAuthAuthorKeyAlgoOffset+4], a.AuthorKeyAlgo)
+	copy(data[idAuthIDBlockSigOffset:idAuthIDBlockSigOffset+SignatureSize], a.IDBlockSig[:])
+	copy(data[idAuthIDKeyOffset:idAuthIDKeyOffset+EcsdaPublicKeySize], a.IDKey[:])
+	copy(data[idAuthIDKeySigOffset:idAuthIDKeySigOffset+SignatureSize], a.IDKeySig[:])
+	copy(data[idAuthAuthorKeyOffset:idAuthAuthorKeyOffset+EcsdaPublicKeySize], a.AuthorKey[:])
+	return data
+}
+
+// Unmarshal populates the ID authentication information from its ABI format, or errors if the
+// data is malformed.
+func (a *IdAuthInfo) Unmarshal(data []byte) error {
+	if len(data) != IdAuthInfoSize {
+		return fmt.Errorf("ID auth info size is %d bytes. Expected %d bytes", len(data), IdAuthInfoSize)
+	}
+	if err := mbz(data, 0x08, idAuthIDBlockSigOffset); err != nil {
+		return err
+	}
+	if err := mbz(data, idAuthIDKeyOffset+EcsdaPublicKeySize, idAuthIDKeySigOffset); err != nil {
+		return err
+	}
+	if err := mbz(data, idAuthAuthorKeyOffset+EcsdaPublicKeySize, IdAuthInfoSize); err != nil {
+		return err
+	}
+	a.IDKeyAlgo = binary.LittleEndian.Uint32(data[idAuthIDKeyAlgoOffset : idAuthIDKeyAlgoOffset+4])
+	a.AuthorKeyAlgo = binary.LittleEndian.Uint32(data[idAuthAuthorKeyAlgoOffset : idAuthAuthorKeyAlgoOffset+4])
+	copy(a.IDBlockSig[:], data[idAuthIDBlockSigOffset:idAuthIDBlockSigOffset+SignatureSize])
+	copy(a.IDKey[:], data[idAuthIDKeyOffset:idAuthIDKeyOffset+EcsdaPublicKeySize])
+	copy(a.IDKeySig[:], data[idAuthIDKeySigOffset:idAuthIDKeySigOffset+SignatureSize])
+	copy(a.AuthorKey[:], data[idAuthAuthorKeyOffset:idAuthAuthorKeyOffset+EcsdaPublicKeySize])
+	return nil
+}
+
+// IDKeyDigest returns the SHA-384 digest of the ID key as it would appear in an attestation
+// report's ID_KEY_DIGEST field.
+func (a *IdAuthInfo) IDKeyDigest() [IDKeyDigestSize]byte {
+	return sha512.Sum384(a.IDKey[:])
+}
+
+// AuthorKeyDigest returns the SHA-384 digest of the author key as it would appear in an attestation
+// report's AUTHOR_KEY_DIGEST field.
+func (a *IdAuthInfo) AuthorKeyDigest() [AuthorKeyDigestSize]byte {
+	return sha512.Sum384(a.AuthorKey[:])
+}
+
+func signAmdFormat(rand io.Reader, key *ecdsa.PrivateKey, data []byte, out []byte) error {
+	digest := sha512.Sum384(data)
+	r, s, err := ecdsa.Sign(rand, key, digest[:])
+	if err != nil {
+		return err
+	}
+	copy(ecdsaGetR(out), bigIntToAMDRS(r))
+	copy(ecdsaGetS(out), bigIntToAMDRS(s))
+	return nil
+}
+
+// SignIdBlock returns the ID authentication information for the given ID block signed by idKey.
+// If authorKey is non-nil, it also signs the ID key with the author key. Both keys must be ECDSA
+// P-384 keys.
+func SignIdBlock(rand io.Reader, block *IdBlock, idKey, authorKey *ecdsa.PrivateKey) (*IdAuthInfo, error) {
+	if idKey == nil {
+		return nil, fmt.Errorf("ID key is nil")
+	}
+	result := &IdAuthInfo{IDKeyAlgo: SignEcdsaP384Sha384}
+	idPub, err := EcdsaPublicKeyToBytes(idKey.Public().(*ecdsa.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("bad ID key: %v", err)
+	}
+	copy(result.IDKey[:], idPub)
+	if err := signAmdFormat(rand, idKey, block.Marshal(), result.IDBlockSig[:]); err != nil {
+		return nil, fmt.Errorf("could not sign ID block: %v", err)
+	}
+	if authorKey == nil {
+		return result, nil
+	}
+	authorPub, err := EcdsaPublicKeyToBytes(authorKey.Public().(*ecdsa.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("bad author key: %v", err)
+	}
+	result.AuthorKeyAlgo = SignEcdsaP384Sha384
+	copy(result.AuthorKey[:], authorPub)
+	if err := signAmdFormat(rand, authorKey, result.IDKey[:], result.IDKeySig[:]); err != nil {
+		return nil, fmt.Errorf("could not sign ID key: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import "sync"
+
+// CertCache keeps the certificate table of the host's last extended report, so that following
+// extended reports with the cache in their Options only need a plain report, which is a faster
+// guest request. The table is refetched when a report's REPORTED_TCB differs from that of the
+// report it came with, since the host then has a new VCEK. The zero value is an empty cache. A
+// CertCache is safe for concurrent use.
+type CertCache struct {
+	mu          sync.Mutex
+	certs       []byte
+	reportedTcb uint64
+	valid       bool
+}
+
+// Invalidate drops the cached certificates, so that the next extended report fetches the host's
+// current ones.
+func (c *CertCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs = nil
+	c.valid = false
+}
+
+// get returns a copy of the cached certificate table and the REPORTED_TCB it was fetched with.
+func (c *CertCache) get() ([]byte, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.valid {
+		return nil, 0, false
+	}
+	return append([]byte(nil), c.certs...), c.reportedTcb, true
+}
+
+func (c *CertCache) put(certs []byte, reportedTcb uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs = append([]byte(nil), certs...)
+	c.reportedTcb = reportedTcb
+	c.valid = true
+}
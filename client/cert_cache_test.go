@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	test "github.com/google/go-sev-guest/testing"
+)
+
+// countingDevice counts the commands sent to the mock device.
+type countingDevice struct {
+	*test.Device
+	commands map[uintptr]int
+}
+
+func (d *countingDevice) Ioctl(command uintptr, req any) (uintptr, error) {
+	d.commands[command]++
+	return d.Device.Ioctl(command, req)
+}
+
+func TestCertCache(t *testing.T) {
+	base, input := throttledDevice(t, 0)
+	d := &countingDevice{Device: base, commands: map[uintptr]int{}}
+	cache := &CertCache{}
+	opts := &Options{CertCache: cache}
+	getCerts := func(wantReports, wantExtended int) []byte {
+		t.Helper()
+		d.commands = map[uintptr]int{}
+		_, certs, err := getRawExtendedReportAtVmpl(context.Background(), d, input, 0, opts)
+		if err != nil {
+			t.Fatalf("getRawExtendedReportAtVmpl(_, d, _, 0, cached) = _, _, %v. Want nil", err)
+		}
+		if d.commands[labi.IocSnpGetReport] != wantReports || d.commands[labi.IocSnpGetExtendedReport] != wantExtended {
+			t.Errorf("getRawExtendedReportAtVmpl(_, d, _, 0, cached) sent %d reports and %d extended reports. Want %d and %d",
+				d.commands[labi.IocSnpGetReport], d.commands[labi.IocSnpGetExtendedReport], wantReports, wantExtended)
+		}
+		return certs
+	}
+	first := getCerts(0, 1)
+	if cached := getCerts(1, 0); !bytes.Equal(cached, first) {
+		t.Errorf("cached certificates = %x. Want %x", cached, first)
+	}
+
+	// A new REPORTED_TCB refreshes the certificates.
+	rsp := base.ReportDataRsp[hex.EncodeToString(input[:])].(*test.GetReportResponse)
+	original := rsp.Resp.Data[0x180]
+	t.Cleanup(func() { rsp.Resp.Data[0x180] = original })
+	rsp.Resp.Data[0x180]++
+	getCerts(1, 1)
+	getCerts(1, 0)
+
+	cache.Invalidate()
+	getCerts(0, 1)
+
+	opts = nil
+	getCerts(0, 1)
+	getCerts(0, 1)
+}
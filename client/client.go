@@ -15,8 +15,15 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"flag"
 	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/google/go-sev-guest/abi"
 	labi "github.com/google/go-sev-guest/client/linuxabi"
@@ -24,6 +31,87 @@ import (
 	"github.com/pkg/errors"
 )
 
+// FirmwareErr is the error that every device command returns when the AMD-SP firmware or the host
+// rejects the command with a status code. Use errors.As to recover the Status, e.g., to tell
+// abi.InvalidParam from abi.ResourceLimit or abi.GuestRequestBusy.
+type FirmwareErr = abi.SevFirmwareErr
+
+// ErrUnsupportedPlatform is returned by the device and quote provider stubs on operating systems
+// without an SEV-SNP guest interface, so that code that only needs this package's types still
+// builds there.
+var ErrUnsupportedPlatform = errors.New("SEV-SNP guest commands require Linux's sev-guest device or configfs-tsm")
+
+// ErrUnexpectedTSMProvider is returned when a configfs-tsm report comes from a provider other than
+// the sev-guest driver, e.g., on a TDX guest.
+var ErrUnexpectedTSMProvider = errors.New("configfs-tsm report provider is not sev_guest")
+
+// ErrPrivilegeLevelBelowFloor is returned when a configfs-tsm report is requested at a more
+// privileged level than the kernel's privlevel_floor allows, e.g., VMPL0 for an OS running at VMPL2
+// under an SVSM.
+var ErrPrivilegeLevelBelowFloor = errors.New("requested privilege level is below the configfs-tsm privlevel_floor")
+
+// ErrDeviceClosed is returned, wrapped, for a command on or a Close of a device that is not open.
+var ErrDeviceClosed = errors.New("SEV guest device is not open")
+
+// ErrVMPCKDisabled is returned, wrapped, when the sev-guest driver refuses guest requests because
+// it can no longer use its VM communication key (VMPCK): the key's message sequence numbers ran
+// out, or a failed request left them out of step with the firmware's, and the driver disabled the
+// key rather than reuse a sequence number. Retrying cannot succeed. The guest must switch to
+// another VMPCK, e.g., with the sev-guest module's vmpck_id parameter, or be restarted.
+var ErrVMPCKDisabled = errors.New("the sev-guest driver disabled its VM communication key (VMPCK), so guest requests fail until the guest switches VMPCKs or restarts")
+
+// vmpckErr is the driver's refusal of a guest request after it disabled the VMPCK. It unwraps to
+// the errno the driver returned, or to the firmware's error.
+type vmpckErr struct {
+	err error
+}
+
+func (e *vmpckErr) Error() string {
+	return fmt.Sprintf("%v: %v", ErrVMPCKDisabled, e.err)
+}
+
+func (e *vmpckErr) Is(target error) bool {
+	return target == ErrVMPCKDisabled
+}
+
+func (e *vmpckErr) Unwrap() error {
+	return e.err
+}
+
+// asVmpckErr recognizes the VMPCK failures of a command that failed with err and the firmware
+// status: AEAD_OFLOW when the firmware's message sequence numbers run out, after which the driver
+// disables the key, and the driver's own refusals without a firmware call, EIO when its sequence
+// number check fails and ENOTTY for every request once the key is disabled. Kernels before 6.x
+// refuse with ENOTTY before they write back the firmware error, so a bare ENOTTY counts too once
+// the device knows the kernel's guest request layout, since until then it may be the rejection of
+// a layout. Any other errno, e.g., a bare EIO, is not one.
+func asVmpckErr(err error, status abi.SevFirmwareStatus, layoutKnown bool) error {
+	if status == abi.AeadOflow {
+		return &vmpckErr{err: &FirmwareErr{Status: status}}
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return nil
+	}
+	switch {
+	case status == abi.GuestRequestNoFirmwareCall && (errno == syscall.EIO || errno == syscall.ENOTTY):
+	case status == abi.Success && errno == syscall.ENOTTY && layoutKnown:
+	default:
+		return nil
+	}
+	return &vmpckErr{err: err}
+}
+
+// layoutKnown returns whether d knows the kernel's guest request layout. Only a device that probes
+// for it, e.g., a LinuxDevice before its first accepted command, may not.
+func layoutKnown(d Device) bool {
+	r, ok := d.(interface{ ABIRevision() labi.ABIRevision })
+	return !ok || r.ABIRevision() != labi.ABIRevisionUnknown
+}
+
+// nonceReader is the source of GetQuoteWithNonce's nonces.
+var nonceReader io.Reader = rand.Reader
+
 var sevGuestPath = flag.String("sev_guest_device_path", "default",
 	"Path to SEV guest device. If \"default\", uses platform default or a fake if testing.")
 
@@ -64,19 +152,87 @@ type QuoteProvider interface {
 	Product() *pb.SevProduct
 }
 
+// ContextDevice is a Device whose commands can be abandoned while they wait to be issued, e.g., on
+// self-throttling. A command that the driver has accepted cannot be interrupted.
+type ContextDevice interface {
+	Device
+	// IoctlContext is like Ioctl, but returns ctx.Err() if ctx is done before the command is issued.
+	IoctlContext(ctx context.Context, command uintptr, argument any) (uintptr, error)
+}
+
+// ContextQuoteProvider is a QuoteProvider whose quote requests can be abandoned between the
+// commands they issue.
+type ContextQuoteProvider interface {
+	QuoteProvider
+	// GetRawQuoteContext is like GetRawQuote, but returns an error wrapping ctx.Err() if ctx is done
+	// before the quote is complete.
+	GetRawQuoteContext(ctx context.Context, reportData [64]byte) ([]uint8, error)
+}
+
 // UseDefaultSevGuest returns true iff -sev_guest_device_path=default.
 func UseDefaultSevGuest() bool {
 	return *sevGuestPath == "default"
 }
 
-func message(d Device, command uintptr, req *labi.SnpUserGuestRequest) error {
-	result, err := d.Ioctl(command, req)
+// ioctlContext issues the command to d unless ctx is done first.
+func ioctlContext(ctx context.Context, d Device, command uintptr, req any) (uintptr, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if cd, ok := d.(ContextDevice); ok {
+		return cd.IoctlContext(ctx, command, req)
+	}
+	return d.Ioctl(command, req)
+}
+
+// message sends the request to d, resending it while the host throttles it as opts' RetryPolicy
+// allows. Each attempt and retry decision is traced to opts' Logger, if any.
+func message(ctx context.Context, d Device, command uintptr, req *labi.SnpUserGuestRequest, opts *Options) error {
+	opts = commandOptions(d, opts)
+	retry := opts.retryPolicy()
+	log := opts.logger()
+	err := messageOnce(ctx, d, command, req, log)
+	for attempt := 0; attempt < retry.MaxRetries && isThrottled(err); attempt++ {
+		delay := retry.backoff(attempt)
+		if log != nil {
+			log.Warnf("%s: throttled by the host, retry %d of %d in %v", commandName(command), attempt+1, retry.MaxRetries, delay)
+		}
+		if ctxErr := sleepContext(ctx, delay); ctxErr != nil {
+			return fmt.Errorf("%w while backing off from a throttled request: %v", ctxErr, err)
+		}
+		req.FwErr = 0
+		err = messageOnce(ctx, d, command, req, log)
+	}
+	if retry.MaxRetries > 0 && isThrottled(err) {
+		if log != nil {
+			log.Warnf("%s: still throttled after %d retries, giving up", commandName(command), retry.MaxRetries)
+		}
+		return fmt.Errorf("giving up after %d retries: %w", retry.MaxRetries, err)
+	}
+	return err
+}
+
+func messageOnce(ctx context.Context, d Device, command uintptr, req *labi.SnpUserGuestRequest, log Logger) error {
+	var start time.Time
+	if log != nil {
+		log.Debugf("%s: issuing", commandName(command))
+		start = time.Now()
+	}
+	result, err := ioctlContext(ctx, d, command, req)
+	if log != nil {
+		log.Debugf("%s: done in %v: fw_err=%v es_result=%d err=%s", commandName(command), time.Since(start),
+			abi.SevFirmwareStatus(req.FwErr), result, traceErr(err))
+	}
 	if err != nil {
+		status := abi.SevFirmwareStatus(req.FwErr)
+		if vmpck := asVmpckErr(err, status, layoutKnown(d)); vmpck != nil {
+			return vmpck
+		}
 		// The ioctl could have failed with a firmware error that
 		// indicates a problem certificate length. We need to
 		// communicate that specifically.
-		if req.FwErr != 0 {
-			return &abi.SevFirmwareErr{Status: abi.SevFirmwareStatus(req.FwErr)}
+		if req.FwErr != 0 && status != abi.GuestRequestNoFirmwareCall {
+			return &FirmwareErr{Status: status}
 		}
 		return err
 	}
@@ -91,18 +247,47 @@ func message(d Device, command uintptr, req *labi.SnpUserGuestRequest) error {
 //
 // Deprecated: Use LeveledQuoteProvider.
 func GetRawReportAtVmpl(d Device, reportData [64]byte, vmpl int) ([]byte, error) {
-	var snpReportRsp labi.SnpReportRespABI
+	return getRawReportAtVmpl(context.Background(), d, reportData, vmpl, nil)
+}
+
+func getRawReportAtVmpl(ctx context.Context, d Device, reportData [64]byte, vmpl int, opts *Options) ([]byte, error) {
+	return getRawReportInto(ctx, nil, d, reportData, vmpl, opts)
+}
+
+// reportRespPool holds report response pages, so that frequent reports do not each allocate one.
+var reportRespPool = sync.Pool{New: func() any { return new(labi.SnpReportRespABI) }}
+
+func getReportResp() *labi.SnpReportRespABI {
+	return reportRespPool.Get().(*labi.SnpReportRespABI)
+}
+
+func putReportResp(rsp *labi.SnpReportRespABI) {
+	*rsp = labi.SnpReportRespABI{}
+	reportRespPool.Put(rsp)
+}
+
+// GetRawReportInto is like GetRawReportAtVmpl, but appends the report to dst and returns the
+// extended slice. With abi.ReportSize bytes of spare capacity in dst, the report is not copied
+// into a newly allocated buffer.
+func GetRawReportInto(dst []byte, d Device, reportData [64]byte, vmpl int) ([]byte, error) {
+	return getRawReportInto(context.Background(), dst, d, reportData, vmpl, nil)
+}
+
+func getRawReportInto(ctx context.Context, dst []byte, d Device, reportData [64]byte, vmpl int, opts *Options) ([]byte, error) {
+	req, err := labi.NewSnpReportReq(reportData[:], vmpl, labi.KeySelDefault)
+	if err != nil {
+		return nil, err
+	}
+	snpReportRsp := getReportResp()
+	defer putReportResp(snpReportRsp)
 	userGuestReq := labi.SnpUserGuestRequest{
-		ReqData: &labi.SnpReportReqABI{
-			ReportData: reportData,
-			Vmpl:       uint32(vmpl),
-		},
-		RespData: &snpReportRsp,
+		ReqData:  req,
+		RespData: snpReportRsp,
 	}
-	if err := message(d, labi.IocSnpGetReport, &userGuestReq); err != nil {
+	if err := message(ctx, d, labi.IocSnpGetReport, &userGuestReq, opts); err != nil {
 		return nil, err
 	}
-	return snpReportRsp.Data[:abi.ReportSize], nil
+	return append(dst, snpReportRsp.Data[:abi.ReportSize]...), nil
 }
 
 // GetRawReport requests for an attestation report at VMPL0 that incorporates the given user data.
@@ -130,63 +315,198 @@ func GetReport(d Device, reportData [64]byte) (*pb.Report, error) {
 	return GetReportAtVmpl(d, reportData, 0)
 }
 
+// GetReportContext is like GetReport, but abandons the request with an error wrapping ctx.Err() if
+// ctx is done before the command is issued.
+func GetReportContext(ctx context.Context, d Device, reportData [64]byte) (*pb.Report, error) {
+	return GetReportWithOptions(ctx, d, reportData, nil)
+}
+
+// GetReportWithOptions is like GetReportContext, but with the given options instead of the defaults.
+func GetReportWithOptions(ctx context.Context, d Device, reportData [64]byte, opts *Options) (*pb.Report, error) {
+	data, err := getRawReportAtVmpl(ctx, d, reportData, 0, opts)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("report request abandoned before it completed: %w", ctxErr)
+		}
+		return nil, err
+	}
+	return abi.ReportToProto(data)
+}
+
 // getExtendedReportIn issues a GetExtendedReport command to the sev-guest driver with reportData
-// input and certs as a destination for certificate data. If certs is empty, this function returns
-// the expected size of certs as its second result value. If certs is non-empty, this function
-// returns the signed attestation report containing reportData and the certificate chain for the
-// report's endorsement key.
-func getExtendedReportIn(d Device, reportData [64]byte, vmpl int, certs []byte) ([]byte, uint32, error) {
-	var snpReportRsp labi.SnpReportRespABI
+// input and certs as a destination for certificate data. If certs is too small, this function
+// returns a nil report and the required size of certs as its second result value. Otherwise, this
+// function returns the signed attestation report containing reportData and the certificate chain
+// for the report's endorsement key.
+func getExtendedReportIn(ctx context.Context, d Device, reportData [64]byte, vmpl int, certs []byte, opts *Options) ([]byte, uint32, error) {
+	req, err := labi.NewSnpReportReq(reportData[:], vmpl, labi.KeySelDefault)
+	if err != nil {
+		return nil, 0, err
+	}
+	snpReportRsp := getReportResp()
+	defer putReportResp(snpReportRsp)
 	snpExtReportReq := labi.SnpExtendedReportReq{
-		Data: labi.SnpReportReqABI{
-			ReportData: reportData,
-			Vmpl:       uint32(vmpl),
-		},
+		Data:        *req,
 		Certs:       certs,
 		CertsLength: uint32(len(certs)),
 	}
 	userGuestReq := labi.SnpUserGuestRequest{
 		ReqData:  &snpExtReportReq,
-		RespData: &snpReportRsp,
+		RespData: snpReportRsp,
 	}
-	// Query the length required for certs.
-	if err := message(d, labi.IocSnpGetExtendedReport, &userGuestReq); err != nil {
-		var fwErr *abi.SevFirmwareErr
+	if err := message(ctx, d, labi.IocSnpGetExtendedReport, &userGuestReq, opts); err != nil {
+		var fwErr *FirmwareErr
 		if errors.As(err, &fwErr) && fwErr.Status == abi.GuestRequestInvalidLength {
 			return nil, snpExtReportReq.CertsLength, nil
 		}
 		return nil, 0, err
 	}
-	return snpReportRsp.Data[:abi.ReportSize], snpExtReportReq.CertsLength, nil
+	return append([]byte(nil), snpReportRsp.Data[:abi.ReportSize]...), snpExtReportReq.CertsLength, nil
 }
 
-// queryCertificateLength requests the required memory size in bytes to represent all certificates
-// returned by an extended guest request.
-func queryCertificateLength(d Device, vmpl int) (uint32, error) {
-	_, length, err := getExtendedReportIn(d, [64]byte{}, vmpl, []byte{})
-	if err != nil {
-		return 0, err
-	}
-	return length, nil
-}
+const (
+	// defaultCertsLength is the size of the certificate buffer first offered to the host. It holds
+	// the usual VCEK, ASK, and ARK certificates.
+	defaultCertsLength = 4 * 0x1000
+	// maxCertsResizes bounds how often the certificate buffer grows for a host that keeps
+	// increasing its requirement.
+	maxCertsResizes = 3
+)
 
 // GetRawExtendedReportAtVmpl requests for an attestation report that incorporates the given user
-// data at the given VMPL, and additional key certificate information.
+// data at the given VMPL, and additional key certificate information. The report is the untouched
+// 1184-byte report, and the certificate table is trimmed to its reported length, or nil if empty.
 //
 // Deprecated: Use LeveledQuoteProvider.
 func GetRawExtendedReportAtVmpl(d Device, reportData [64]byte, vmpl int) ([]byte, []byte, error) {
-	length, err := queryCertificateLength(d, vmpl)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error querying certificate length: %v", err)
+	return getRawExtendedReportAtVmpl(context.Background(), d, reportData, vmpl, nil)
+}
+
+// getRawExtendedReportAtVmpl is GetRawExtendedReportAtVmpl that gives up with an error wrapping
+// ctx.Err() and the step it was on if ctx is done before the report is complete. With a CertCache
+// in opts, a cached certificate table is returned with a plain report for the same REPORTED_TCB.
+func getRawExtendedReportAtVmpl(ctx context.Context, d Device, reportData [64]byte, vmpl int, opts *Options) ([]byte, []byte, error) {
+	opts = commandOptions(d, opts)
+	cache := opts.certCache()
+	if cache == nil {
+		return getRawExtendedReportUncached(ctx, d, reportData, vmpl, opts)
 	}
-	certs := make([]byte, length)
-	report, _, err := getExtendedReportIn(d, reportData, vmpl, certs)
+	if certs, reportedTcb, ok := cache.get(); ok {
+		report, err := getRawReportAtVmpl(ctx, d, reportData, vmpl, opts)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, nil, fmt.Errorf("report with cached certificates abandoned before it was sent: %w", ctxErr)
+			}
+			return nil, nil, err
+		}
+		if got, err := abi.ReportReportedTcb(report); err == nil && got == reportedTcb {
+			return report, certs, nil
+		}
+		// The host's TCB changed, so its certificates likely did too.
+		cache.Invalidate()
+	}
+	report, certs, err := getRawExtendedReportUncached(ctx, d, reportData, vmpl, opts)
 	if err != nil {
 		return nil, nil, err
 	}
+	if reportedTcb, err := abi.ReportReportedTcb(report); err == nil {
+		cache.put(certs, reportedTcb)
+	}
 	return report, certs, nil
 }
 
+// getRawExtendedReportUncached sends the extended report request. The certificate buffer starts at
+// defaultCertsLength and grows to the size the host requires. If the host has no certificates,
+// the certificate table is empty.
+func getRawExtendedReportUncached(ctx context.Context, d Device, reportData [64]byte, vmpl int, opts *Options) ([]byte, []byte, error) {
+	length := uint32(defaultCertsLength)
+	for resizes := 0; ; resizes++ {
+		certs, release := getCertsBuffer(length)
+		report, required, err := getExtendedReportIn(ctx, d, reportData, vmpl, certs, opts)
+		if report != nil {
+			// Only the table outlives the pooled buffer.
+			certs = append([]byte(nil), trimCertTable(certs, required)...)
+		}
+		release()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil && resizes == 0 {
+				return nil, nil, fmt.Errorf("extended report abandoned before it was sent: %w", ctxErr)
+			} else if ctxErr != nil {
+				return nil, nil, fmt.Errorf("extended report abandoned after the host required a %d byte certificate buffer: %w", length, ctxErr)
+			}
+			return nil, nil, err
+		}
+		if report != nil {
+			if len(certs) == 0 {
+				return report, nil, nil
+			}
+			return report, certs, nil
+		}
+		if required == 0 {
+			report, err := getRawReportAtVmpl(ctx, d, reportData, vmpl, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return report, nil, nil
+		}
+		if required <= length {
+			return nil, nil, fmt.Errorf("host rejected a %d byte certificate buffer, but requires %d bytes", length, required)
+		}
+		if resizes == maxCertsResizes {
+			return nil, nil, fmt.Errorf("host still requires a larger certificate buffer (%d bytes) after %d resizes", required, resizes)
+		}
+		length = required
+	}
+}
+
+// certsBufferPool holds certificate buffers of defaultCertsLength, the size that suffices for the
+// usual hosts, so that frequent extended reports do not each allocate one.
+var certsBufferPool = sync.Pool{New: func() any {
+	buffer := make([]byte, defaultCertsLength)
+	return &buffer
+}}
+
+// getCertsBuffer returns a zeroed certificate buffer of the given length, and a function that
+// releases it once the buffer is no longer used.
+func getCertsBuffer(length uint32) ([]byte, func()) {
+	if length != defaultCertsLength {
+		return make([]byte, length), func() {}
+	}
+	buffer := certsBufferPool.Get().(*[]byte)
+	return *buffer, func() {
+		for i := range *buffer {
+			(*buffer)[i] = 0
+		}
+		certsBufferPool.Put(buffer)
+	}
+}
+
+// trimCertTable returns certs without the unused part of its buffer. The table ends at the length
+// the driver reported, if shorter than the buffer, and no later than its last entry's data. An
+// empty table is nil. A table that does not parse is left for its consumer to reject.
+func trimCertTable(certs []byte, reported uint32) []byte {
+	if reported > 0 && int(reported) < len(certs) {
+		certs = certs[:reported]
+	}
+	entries, err := abi.ParseSnpCertTableHeader(certs)
+	if err != nil {
+		return certs
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	end := uint64(len(entries)+1) * abi.CertTableEntrySize
+	for _, entry := range entries {
+		if entryEnd := uint64(entry.Offset) + uint64(entry.Length); entryEnd > end {
+			end = entryEnd
+		}
+	}
+	if end > uint64(len(certs)) {
+		return certs
+	}
+	return certs[:end]
+}
+
 // GetRawExtendedReport requests for an attestation report that incorporates the given user data,
 // and additional key certificate information.
 //
@@ -199,7 +519,22 @@ func GetRawExtendedReport(d Device, reportData [64]byte) ([]byte, []byte, error)
 // protobuf representation of an attestation report with cached
 // certificate chain.
 func GetQuoteProto(qp QuoteProvider, reportData [64]byte) (*pb.Attestation, error) {
-	reportcerts, err := qp.GetRawQuote(reportData)
+	return GetQuoteContext(context.Background(), qp, reportData)
+}
+
+// GetQuoteContext is like GetQuoteProto, but abandons the request with an error wrapping ctx.Err()
+// if ctx is done before the quote is complete. Providers that do not implement ContextQuoteProvider
+// are only checked before the quote is requested.
+func GetQuoteContext(ctx context.Context, qp QuoteProvider, reportData [64]byte) (*pb.Attestation, error) {
+	var reportcerts []byte
+	var err error
+	if cqp, ok := qp.(ContextQuoteProvider); ok {
+		reportcerts, err = cqp.GetRawQuoteContext(ctx, reportData)
+	} else if err = ctx.Err(); err != nil {
+		return nil, fmt.Errorf("quote request abandoned before it was sent: %w", err)
+	} else {
+		reportcerts, err = qp.GetRawQuote(reportData)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -209,6 +544,9 @@ func GetQuoteProto(qp QuoteProvider, reportData [64]byte) (*pb.Attestation, erro
 	}
 	// TODO(Issue#109): Remove when Product is removed.
 	attestation.Product = qp.Product()
+	if err := applyKDSFallback(ctx, attestation, quoteProviderOptions(qp)); err != nil {
+		return nil, err
+	}
 	return attestation, nil
 }
 
@@ -225,14 +563,163 @@ func GetQuoteProtoAtLevel(qp LeveledQuoteProvider, reportData [64]byte, vmpl uin
 		return nil, err
 	}
 	attestation.Product = qp.Product()
+	if err := applyKDSFallback(context.Background(), attestation, quoteProviderOptions(qp)); err != nil {
+		return nil, err
+	}
 	return attestation, nil
 }
 
+// GetQuoteProtoWithUserData is like GetQuoteProto, but takes user data of at most
+// abi.ReportDataSize bytes that is padded with zeros to form REPORT_DATA.
+func GetQuoteProtoWithUserData(qp QuoteProvider, userData []byte) (*pb.Attestation, error) {
+	reportData, err := abi.PadReportData(userData)
+	if err != nil {
+		return nil, err
+	}
+	return GetQuoteProto(qp, reportData)
+}
+
+// GetQuoteProtoAtLevelWithUserData is like GetQuoteProtoAtLevel, but takes user data of at most
+// abi.ReportDataSize bytes that is padded with zeros to form REPORT_DATA.
+func GetQuoteProtoAtLevelWithUserData(qp LeveledQuoteProvider, userData []byte, vmpl uint) (*pb.Attestation, error) {
+	reportData, err := abi.PadReportData(userData)
+	if err != nil {
+		return nil, err
+	}
+	return GetQuoteProtoAtLevel(qp, reportData, vmpl)
+}
+
+// DeviceQuoteProvider implements QuoteProvider and LeveledQuoteProvider with an open Device, so that
+// GetQuoteProto can collect an attestation from, e.g., a device at a non-default path or a mock.
+type DeviceQuoteProvider struct {
+	// Device is the open device to send commands to. The provider does not close it.
+	Device Device
+	// Options configures the device commands. If nil, uses the defaults.
+	Options *Options
+}
+
+// IsSupported returns whether the provider has a device.
+func (p *DeviceQuoteProvider) IsSupported() bool {
+	return p.Device != nil
+}
+
+func (p *DeviceQuoteProvider) getRawQuoteAtLevel(ctx context.Context, reportData [64]byte, level uint) ([]uint8, error) {
+	if p.Device == nil {
+		return nil, errors.New("quote provider has no device")
+	}
+	report, certs, err := getRawExtendedReportAtVmpl(ctx, p.Device, reportData, int(level), p.Options)
+	if err != nil {
+		return nil, err
+	}
+	// Mix the platform info of the device's product in with the certificates.
+	extended, err := abi.ExtendPlatformCertTable(certs, &abi.ExtraPlatformInfo{
+		Size:      abi.ExtraPlatformInfoV0Size,
+		Cpuid1Eax: abi.MaskedCpuid1EaxFromSevProduct(p.Device.Product()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate table: %v", err)
+	}
+	return append(report, extended...), nil
+}
+
+// GetRawQuoteAtLevel returns byte format attestation plus certificate table from the device. If
+// the host has no certificates, the table only holds the platform info.
+func (p *DeviceQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(context.Background(), reportData, level)
+}
+
+// GetRawQuote returns byte format attestation plus certificate table from the device at VMPL0.
+func (p *DeviceQuoteProvider) GetRawQuote(reportData [64]byte) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(context.Background(), reportData, 0)
+}
+
+// GetRawQuoteContext is like GetRawQuote, but abandons the quote if ctx is done before its device
+// commands are issued.
+func (p *DeviceQuoteProvider) GetRawQuoteContext(ctx context.Context, reportData [64]byte) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(ctx, reportData, 0)
+}
+
+// Product returns the device's AMD SEV product information.
+//
+// Deprecated: Use abi.ExtraPlatformInfoGUID in the raw quote certificate table.
+func (p *DeviceQuoteProvider) Product() *pb.SevProduct {
+	return p.Device.Product()
+}
+
+func (p *DeviceQuoteProvider) kdsOptions() *Options {
+	return commandOptions(p.Device, p.Options)
+}
+
+// GetQuoteWithNonce returns an attestation from the device over a fresh random nonce, along with
+// the nonce to send to the verifier. It fails rather than use a partially random nonce, and
+// checks that the returned report contains the nonce as its REPORT_DATA.
+func GetQuoteWithNonce(d Device) (nonce [64]byte, attestation *pb.Attestation, err error) {
+	if _, err := io.ReadFull(nonceReader, nonce[:]); err != nil {
+		return [64]byte{}, nil, fmt.Errorf("could not generate a random nonce: %v", err)
+	}
+	attestation, err = GetQuoteProto(&DeviceQuoteProvider{Device: d}, nonce)
+	if err != nil {
+		return [64]byte{}, nil, err
+	}
+	if got := attestation.GetReport().GetReportData(); !bytes.Equal(got, nonce[:]) {
+		return [64]byte{}, nil, fmt.Errorf("report_data %x is not the requested nonce %x", got, nonce)
+	}
+	return nonce, attestation, nil
+}
+
 // GetExtendedReportAtVmpl gets an extended attestation report at the given VMPL into a structured type.
 //
 // Deprecated: Use GetQuoteProtoAtLevel
 func GetExtendedReportAtVmpl(d Device, reportData [64]byte, vmpl int) (*pb.Attestation, error) {
-	reportBytes, certBytes, err := GetRawExtendedReportAtVmpl(d, reportData, vmpl)
+	return getExtendedReportAtVmpl(context.Background(), d, reportData, vmpl, nil)
+}
+
+func getExtendedReportAtVmpl(ctx context.Context, d Device, reportData [64]byte, vmpl int, opts *Options) (*pb.Attestation, error) {
+	opts = commandOptions(d, opts)
+	extended, err := GetParsedExtendedReport(ctx, d, reportData, vmpl, opts)
+	if err != nil {
+		return nil, err
+	}
+	attestation := &pb.Attestation{
+		Report:           extended.Report,
+		CertificateChain: extended.CertificateChain,
+		Product:          d.Product(),
+	}
+	if err := applyKDSFallback(ctx, attestation, opts); err != nil {
+		return nil, err
+	}
+	return attestation, nil
+}
+
+// ExtendedReport is an attestation report with the host's parsed certificate table.
+type ExtendedReport struct {
+	// Report is the attestation report.
+	Report *pb.Report
+	// Certs is the host's certificate table, with its entries in the host's order.
+	Certs *abi.CertTable
+	// CertificateChain names the table's VCEK, VLEK, ASK, and ARK certificates, and has any others
+	// in its Extras by GUID.
+	CertificateChain *pb.CertificateChain
+	rawReport        []byte
+	rawCerts         []byte
+}
+
+// RawReport returns the untouched attestation report, e.g., to forward it verbatim.
+func (r *ExtendedReport) RawReport() []byte {
+	return r.rawReport
+}
+
+// RawCertTable returns the untouched certificate table as GetRawExtendedReportAtVmpl does, or nil
+// if the host has no certificates.
+func (r *ExtendedReport) RawCertTable() []byte {
+	return r.rawCerts
+}
+
+// GetParsedExtendedReport gets an extended attestation report at the given VMPL with its
+// certificate table parsed, so that callers need not understand the table's format. A malformed
+// table from the host is an error that names the offending entry's GUID and byte range.
+func GetParsedExtendedReport(ctx context.Context, d Device, reportData [64]byte, vmpl int, opts *Options) (*ExtendedReport, error) {
+	reportBytes, certBytes, err := getRawExtendedReportAtVmpl(ctx, d, reportData, vmpl, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -244,12 +731,14 @@ func GetExtendedReportAtVmpl(d Device, reportData [64]byte, vmpl int) (*pb.Attes
 
 	certs := new(abi.CertTable)
 	if err := certs.Unmarshal(certBytes); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("host certificate table is malformed: %w", err)
 	}
-	return &pb.Attestation{
+	return &ExtendedReport{
 		Report:           report,
+		Certs:            certs,
 		CertificateChain: certs.Proto(),
-		Product:          d.Product(),
+		rawReport:        reportBytes,
+		rawCerts:         certBytes,
 	}, nil
 }
 
@@ -260,6 +749,18 @@ func GetExtendedReport(d Device, reportData [64]byte) (*pb.Attestation, error) {
 	return GetExtendedReportAtVmpl(d, reportData, 0)
 }
 
+// GetExtendedReportContext is like GetExtendedReport, but abandons the request with an error
+// wrapping ctx.Err() if ctx is done before its commands are issued.
+func GetExtendedReportContext(ctx context.Context, d Device, reportData [64]byte) (*pb.Attestation, error) {
+	return GetExtendedReportWithOptions(ctx, d, reportData, nil)
+}
+
+// GetExtendedReportWithOptions is like GetExtendedReportContext, but with the given options instead
+// of the defaults.
+func GetExtendedReportWithOptions(ctx context.Context, d Device, reportData [64]byte, opts *Options) (*pb.Attestation, error) {
+	return getExtendedReportAtVmpl(ctx, d, reportData, 0, opts)
+}
+
 // GuestFieldSelect represents which guest-provided information will be mixed into a derived key.
 type GuestFieldSelect struct {
 	TCBVersion  bool
@@ -275,50 +776,77 @@ type GuestFieldSelect struct {
 type SnpDerivedKeyReq struct {
 	// UseVCEK determines if the derived key will be based on VCEK or VMRK. This is opposite from the
 	// ABI's ROOT_KEY_SELECT to avoid accidentally making an unsafe choice in a multitenant
-	// environment.
-	UseVCEK          bool
+	// environment. With KeySel, the key may be based on the VLEK instead of the VCEK.
+	UseVCEK bool
+	// KeySel is one of the linuxabi KeySel* constants to choose between the VCEK and VLEK when
+	// UseVCEK is true. The default is the VLEK if one is installed, else the VCEK. Firmware before
+	// SEV SNP API 1.56 ignores it.
+	KeySel           uint32
 	GuestFieldSelect GuestFieldSelect
 	// Vmpl to mix into the key. Must be greater than or equal to current Vmpl.
 	Vmpl uint32
-	// GuestSVN to mix into the key. Must be less than or equal to GuestSVN at launch.
+	// GuestSVN to mix into the key. Must be less than or equal to GuestSVN at launch. Only mixed in
+	// if GuestFieldSelect.GuestSVN is true.
 	GuestSVN uint32
-	// TCBVersion to mix into the key. Must be less than or equal to the CommittedTcb.
+	// TCBVersion to mix into the key. Must be less than or equal to the CommittedTcb. Only mixed in
+	// if GuestFieldSelect.TCBVersion is true.
 	TCBVersion uint64
 }
 
-// ABI returns the SNP ABI-specified uint64 bitmask of guest field selection.
-func (g GuestFieldSelect) ABI() uint64 {
-	var value uint64
-	if g.TCBVersion {
-		value |= uint64(1 << 5)
+// rootKeySelect returns the request's ABI ROOT_KEY_SELECT value, or an error if the request has
+// fields that the firmware would reject or ignore.
+func (r *SnpDerivedKeyReq) rootKeySelect() (uint32, error) {
+	if r.Vmpl > labi.MaxVmpl {
+		return 0, fmt.Errorf("derived key vmpl is %d. Expected 0-%d", r.Vmpl, labi.MaxVmpl)
 	}
-	if g.GuestSVN {
-		value |= uint64(1 << 4)
+	if r.KeySel > labi.KeySelVLEK {
+		return 0, fmt.Errorf("derived key key_sel is %d. Expected 0-%d", r.KeySel, labi.KeySelVLEK)
 	}
-	if g.Measurement {
-		value |= uint64(1 << 3)
+	if r.GuestSVN != 0 && !r.GuestFieldSelect.GuestSVN {
+		return 0, fmt.Errorf("derived key guest_svn %d is not mixed into the key without GuestFieldSelect.GuestSVN", r.GuestSVN)
 	}
-	if g.FamilyID {
-		value |= uint64(1 << 2)
+	if r.TCBVersion != 0 && !r.GuestFieldSelect.TCBVersion {
+		return 0, fmt.Errorf("derived key tcb_version 0x%x is not mixed into the key without GuestFieldSelect.TCBVersion", r.TCBVersion)
 	}
-	if g.ImageID {
-		value |= uint64(1 << 1)
+	if !r.UseVCEK {
+		if r.KeySel != labi.KeySelDefault {
+			return 0, fmt.Errorf("derived key key_sel %d needs UseVCEK, since a VMRK-based key has no VCEK or VLEK to select", r.KeySel)
+		}
+		return labi.RootKeySelectVMRK, nil
 	}
-	if g.GuestPolicy {
-		value |= uint64(1 << 0)
+	return r.KeySel << labi.RootKeySelectKeySelShift, nil
+}
+
+// ABI returns the SNP ABI-specified uint64 bitmask of guest field selection.
+func (g GuestFieldSelect) ABI() uint64 {
+	return abi.GuestFieldSelectToBytes(abi.GuestFieldSelect{
+		GuestPolicy: g.GuestPolicy,
+		ImageID:     g.ImageID,
+		FamilyID:    g.FamilyID,
+		Measurement: g.Measurement,
+		GuestSVN:    g.GuestSVN,
+		TCBVersion:  g.TCBVersion,
+	})
+}
+
+// GetDerivedKey returns the 32 bytes of key material that the AMD security processor derives from
+// the given parameters. A firmware failure, such as INVALID_PARAM for an undefined guest field
+// selection, is returned as a *FirmwareErr. Security limitations of this command are
+// described in LIMITATIONS.md.
+func GetDerivedKey(d Device, request *SnpDerivedKeyReq) ([]byte, error) {
+	response, err := getDerivedKey(d, request)
+	if err != nil {
+		return nil, err
 	}
-	return value
+	return response.Data[:], nil
 }
 
-// GetDerivedKeyAcknowledgingItsLimitations returns 32 bytes of key material that the AMD security
-// processor derives from the given parameters. Security limitations of this command are described
-// more in the project README.
-func GetDerivedKeyAcknowledgingItsLimitations(d Device, request *SnpDerivedKeyReq) (*labi.SnpDerivedKeyRespABI, error) {
-	response := &labi.SnpDerivedKeyRespABI{}
-	rootKeySelect := uint32(1)
-	if request.UseVCEK {
-		rootKeySelect = 0
+func getDerivedKey(d Device, request *SnpDerivedKeyReq) (*labi.SnpDerivedKeyRespABI, error) {
+	rootKeySelect, err := request.rootKeySelect()
+	if err != nil {
+		return nil, err
 	}
+	response := &labi.SnpDerivedKeyRespABI{}
 	guestRequest := &labi.SnpUserGuestRequest{
 		ReqData: &labi.SnpDerivedKeyReqABI{
 			RootKeySelect:    rootKeySelect,
@@ -329,8 +857,26 @@ func GetDerivedKeyAcknowledgingItsLimitations(d Device, request *SnpDerivedKeyRe
 		},
 		RespData: response,
 	}
-	if err := message(d, labi.IocSnpGetDerivedKey, guestRequest); err != nil {
-		return nil, fmt.Errorf("error getting derived key: %v", err)
+	err = message(context.Background(), d, labi.IocSnpGetDerivedKey, guestRequest, nil)
+	// The MSG_KEY_RSP status is the firmware's verdict even if the driver also reports an error.
+	if response.Status != uint32(abi.Success) {
+		err = &FirmwareErr{Status: abi.SevFirmwareStatus(response.Status)}
+	}
+	if err != nil {
+		// Never leave partial key material behind on failure.
+		response.Data = [32]byte{}
+		var fwErr *FirmwareErr
+		if errors.As(err, &fwErr) && fwErr.Status == abi.InvalidKey && request.UseVCEK && request.KeySel == labi.KeySelVLEK {
+			return nil, fmt.Errorf("error getting derived key: the platform has no VLEK installed, so select the VCEK instead: %w", err)
+		}
+		return nil, fmt.Errorf("error getting derived key: %w", err)
 	}
 	return response, nil
 }
+
+// GetDerivedKeyAcknowledgingItsLimitations returns 32 bytes of key material that the AMD security
+// processor derives from the given parameters. Security limitations of this command are described
+// more in the project README.
+func GetDerivedKeyAcknowledgingItsLimitations(d Device, request *SnpDerivedKeyReq) (*labi.SnpDerivedKeyRespABI, error) {
+	return getDerivedKey(d, request)
+}
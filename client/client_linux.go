@@ -12,21 +12,27 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build linux || freebsd || openbsd || netbsd
+//go:build linux
 
 // Package client provides an interface to the AMD SEV-SNP guest device commands.
 package client
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
+	"unsafe"
 
+	"github.com/google/go-configfs-tsm/configfs/configfsi"
 	"github.com/google/go-configfs-tsm/configfs/linuxtsm"
 	"github.com/google/go-configfs-tsm/report"
 	"github.com/google/go-sev-guest/abi"
 	labi "github.com/google/go-sev-guest/client/linuxabi"
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
 
@@ -43,76 +49,143 @@ var (
 	burstMax         = flag.Int("self_throttle_burst", 1, "Rate-limit library-initiated device commands to this many commands per duration")
 )
 
-// LinuxDevice implements the Device interface with Linux ioctls.
+// LinuxDevice implements the Device interface with Linux ioctls. It is safe for concurrent use:
+// commands are issued one at a time, each waiting for the device after those already in flight.
+// Commands after Close, and a second Close, fail with ErrDeviceClosed.
 type LinuxDevice struct {
+	// mu serializes commands and guards the file descriptor, open state, and self-throttle state.
+	mu      sync.Mutex
 	fd      int
+	open    bool
 	lastCmd time.Time
 	burst   int
+	// revision is the guest request layout that the kernel accepted, once a command has found it.
+	revision labi.ABIRevision
+	// options holds the self-throttle and the Options for commands whose calls pass none. It is set
+	// when the device is opened.
+	options *deviceOptions
 }
 
-// Open opens the SEV-SNP guest device from a given path
+// Open opens the SEV-SNP guest device from a given path. It fails if the device is already open.
 func (d *LinuxDevice) Open(path string) error {
-	fd, err := unix.Open(path, unix.O_RDWR, 0)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.open {
+		return errors.New("SEV guest device is already open")
+	}
+	fd, err := openDeviceFile(path)
 	if err != nil {
-		d.fd = -1
 		return fmt.Errorf("could not open AMD SEV guest device at %s (see %s): %v", path, installURL, err)
 	}
 	d.fd = fd
+	d.open = true
+	if d.options == nil {
+		d.options = linuxDeviceOptions(nil)
+	}
 	return nil
 }
 
-// OpenDevice opens the SEV-SNP guest device.
-func OpenDevice() (*LinuxDevice, error) {
-	result := &LinuxDevice{}
-	path := *sevGuestPath
-	if UseDefaultSevGuest() {
-		path = defaultSevGuestDevicePath
+func openDeviceFile(path string) (int, error) {
+	return unix.Open(path, unix.O_RDWR, 0)
+}
+
+// OpenDevice opens the SEV-SNP guest device. Without WithDevicePath, it opens the
+// --sev_guest_device_path if that is not "default", or else probes the path in the
+// SEV_GUEST_DEVICE_PATH environment variable, if set, followed by /dev/sev-guest. The
+// WithRetryPolicy and WithLogger options configure the calls that send the device commands without
+// Options of their own. The self-throttle is read from the flags once, here, unless WithSelfThrottle
+// sets it.
+func OpenDevice(opts ...DeviceOption) (*LinuxDevice, error) {
+	o := linuxDeviceOptions(opts)
+	fd, err := o.openFirst(defaultSevGuestDevicePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open AMD SEV guest device (see %s): %v", installURL, err)
 	}
-	if err := result.Open(path); err != nil {
-		return nil, err
+	return &LinuxDevice{fd: fd, open: true, options: o}, nil
+}
+
+// linuxDeviceOptions returns the device options that opts set, with the self-throttle read from
+// the flags unless WithSelfThrottle sets it.
+func linuxDeviceOptions(opts []DeviceOption) *deviceOptions {
+	o := newDeviceOptions(opts)
+	if o.open == nil {
+		o.open = openDeviceFile
+	}
+	if !o.throttleSet {
+		o.throttleDuration, o.throttleBurst = *throttleDuration, *burstMax
+	}
+	if o.throttleBurst < 1 {
+		o.throttleBurst = 1
 	}
-	return result, nil
+	return o
+}
+
+func (d *LinuxDevice) commandOptions() *Options {
+	return d.options.commands
 }
 
 // Close closes the SEV-SNP guest device.
 func (d *LinuxDevice) Close() error {
-	if d.fd == -1 { // Not open
-		return nil
-	}
-	if err := unix.Close(d.fd); err != nil {
-		return err
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.open {
+		return fmt.Errorf("could not close: %w", ErrDeviceClosed)
 	}
-	// Prevent double-close.
+	// The descriptor is released even if close reports an error, so it must not be closed again.
+	d.open = false
+	fd := d.fd
 	d.fd = -1
-	return nil
+	return unix.Close(fd)
 }
 
 // Ioctl sends a command with its wrapped request and response values to the Linux device.
 func (d *LinuxDevice) Ioctl(command uintptr, req any) (uintptr, error) {
+	return d.IoctlContext(context.Background(), command, req)
+}
+
+// IoctlContext is like Ioctl, but returns ctx.Err() if ctx is done while the command waits out the
+// self-throttle. A command waiting for another goroutine's command to finish cannot be abandoned.
+func (d *LinuxDevice) IoctlContext(ctx context.Context, command uintptr, req any) (uintptr, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.open {
+		return 0, fmt.Errorf("could not send %s: %w", commandName(command), ErrDeviceClosed)
+	}
 	// TODO(Issue #40): Remove the workaround to the ENOTTY lockout when throttled
 	// in Linux 6.1 by throttling ourselves first.
 	if d.burst == 0 {
 		sinceLast := time.Since(d.lastCmd)
 		// Self-throttle for tests without guest OS throttle detection
-		if sinceLast < *throttleDuration {
-			time.Sleep(*throttleDuration - sinceLast)
+		if sinceLast < d.options.throttleDuration {
+			if err := sleepContext(ctx, d.options.throttleDuration-sinceLast); err != nil {
+				return 0, err
+			}
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	switch sreq := req.(type) {
 	case *labi.SnpUserGuestRequest:
-		abi := sreq.ABI()
-		result, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(d.fd), command, uintptr(abi.Pointer()))
-		abi.Finish(sreq)
-		d.burst = (d.burst + 1) % *burstMax
+		result, errno := d.guestRequest(command, sreq)
+		d.burst = (d.burst + 1) % d.options.throttleBurst
 		if d.burst == 0 {
 			d.lastCmd = time.Now()
 		}
 
 		// TODO(Issue #5): remove the work around for the kernel bug that writes
 		// uninitialized memory back on non-EIO.
-		if errno != unix.EIO {
+		// EAGAIN carries the VMM's busy status when the host throttles the request, and the driver
+		// marks the requests it refuses without a firmware call, e.g., with ENOTTY once it disabled
+		// the VMPCK.
+		if errno != unix.EIO && errno != unix.EAGAIN && sreq.FwErr != abi.GuestRequestNoFirmwareCallFwErr {
 			sreq.FwErr = 0
 		}
+		if errno != 0 && d.revision == labi.ABIRevisionUnknown {
+			if log := d.options.commands.logger(); log != nil {
+				log.Warnf("%s: the kernel rejected every known sev-guest ioctl layout (%v) with %v", commandName(command), labi.ABIRevisions, errno)
+			}
+		}
 		if errno != 0 {
 			return 0, errno
 		}
@@ -121,6 +194,57 @@ func (d *LinuxDevice) Ioctl(command uintptr, req any) (uintptr, error) {
 	return 0, fmt.Errorf("unexpected request value: %v", req)
 }
 
+// ioctlSyscall issues an ioctl with a pointer argument. Tests replace it to emulate kernels.
+var ioctlSyscall = func(fd int, command uintptr, arg unsafe.Pointer) (uintptr, unix.Errno) {
+	result, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), command, uintptr(arg))
+	return result, errno
+}
+
+// layoutRejected returns whether the kernel may have refused a command for its guest request
+// layout rather than for anything the request asks. The command number encodes the layout's size,
+// so a kernel with another layout does not know the command and fails with ENOTTY. Any other
+// errno, e.g., EINVAL for a bad VMPL, is the kernel's answer to the request.
+func layoutRejected(errno unix.Errno) bool {
+	return errno == unix.ENOTTY
+}
+
+// guestRequest issues the request in the kernel's guest request layout. Until a command finds the
+// layout, each known revision is tried in turn while the kernel rejects the layout. The request
+// keeps the first attempt's firmware error and response unless a later layout is accepted, so a
+// command the kernel refused, e.g., once it disabled the VMPCK, fails as it did in the first layout.
+func (d *LinuxDevice) guestRequest(command uintptr, req *labi.SnpUserGuestRequest) (uintptr, unix.Errno) {
+	revisions := labi.ABIRevisions
+	if d.revision != labi.ABIRevisionUnknown {
+		revisions = []labi.ABIRevision{d.revision}
+	}
+	var firstResult uintptr
+	var firstErrno unix.Errno
+	for i, revision := range revisions {
+		conv := req.ABIForRevision(revision)
+		result, errno := ioctlSyscall(d.fd, revision.Command(command), conv.Pointer())
+		if i == 0 {
+			conv.Finish(req)
+			firstResult, firstErrno = result, errno
+		}
+		if !layoutRejected(errno) {
+			if i != 0 {
+				conv.Finish(req)
+			}
+			d.revision = revision
+			return result, errno
+		}
+	}
+	return firstResult, firstErrno
+}
+
+// ABIRevision returns the guest request layout that the kernel accepted, or
+// labi.ABIRevisionUnknown before a command has found it.
+func (d *LinuxDevice) ABIRevision() labi.ABIRevision {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.revision
+}
+
 // Product returns the current CPU's associated AMD SEV product information.
 func (d *LinuxDevice) Product() *spb.SevProduct {
 	return abi.SevProduct()
@@ -128,7 +252,10 @@ func (d *LinuxDevice) Product() *spb.SevProduct {
 
 // LinuxIoctlQuoteProvider implements the QuoteProvider interface to fetch
 // attestation quote via the deprecated /dev/sev-guest ioctl.
-type LinuxIoctlQuoteProvider struct{}
+type LinuxIoctlQuoteProvider struct {
+	// Options configures the device commands. If nil, uses the defaults.
+	Options *Options
+}
 
 // IsSupported checks if TSM client can be created to use /dev/sev-guest ioctl.
 func (p *LinuxIoctlQuoteProvider) IsSupported() bool {
@@ -142,20 +269,26 @@ func (p *LinuxIoctlQuoteProvider) IsSupported() bool {
 
 // GetRawQuoteAtLevel returns byte format attestation plus certificate table via /dev/sev-guest ioctl.
 func (p *LinuxIoctlQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(context.Background(), reportData, level)
+}
+
+func (p *LinuxIoctlQuoteProvider) getRawQuoteAtLevel(ctx context.Context, reportData [64]byte, level uint) ([]uint8, error) {
 	d, err := OpenDevice()
 	if err != nil {
 		return nil, err
 	}
 	defer d.Close()
-	// If there are no certificates, then just return the raw report.
-	length, err := queryCertificateLength(d, int(level))
+	report, certs, err := getRawExtendedReportAtVmpl(ctx, d, reportData, int(level), p.Options)
 	if err != nil {
-		return GetRawReportAtVmpl(d, reportData, int(level))
-	}
-	certs := make([]byte, length)
-	report, _, err := getExtendedReportIn(d, reportData, int(level), certs)
-	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// If the host cannot provide certificates, then just return the raw report.
+		report, err := getRawReportAtVmpl(ctx, d, reportData, int(level), p.Options)
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			return nil, fmt.Errorf("quote abandoned after the extended report failed: %w", ctxErr)
+		}
+		return report, err
 	}
 	// Mix the platform info in with the auxblob.
 	extended, err := abi.ExtendedPlatformCertTable(certs)
@@ -170,6 +303,12 @@ func (p *LinuxIoctlQuoteProvider) GetRawQuote(reportData [64]byte) ([]uint8, err
 	return p.GetRawQuoteAtLevel(reportData, 0)
 }
 
+// GetRawQuoteContext is like GetRawQuote, but abandons the quote if ctx is done before its device
+// commands are issued.
+func (p *LinuxIoctlQuoteProvider) GetRawQuoteContext(ctx context.Context, reportData [64]byte) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(ctx, reportData, 0)
+}
+
 // Product returns the current CPU's associated AMD SEV product information.
 //
 // Deprecated: Use ExtraPlatformInfoGUID from the cert table.
@@ -177,29 +316,54 @@ func (*LinuxIoctlQuoteProvider) Product() *spb.SevProduct {
 	return abi.SevProduct()
 }
 
+func (p *LinuxIoctlQuoteProvider) kdsOptions() *Options {
+	return p.Options
+}
+
 // LinuxConfigFsQuoteProvider implements the QuoteProvider interface to fetch
 // attestation quote via ConfigFS.
-type LinuxConfigFsQuoteProvider struct{}
+type LinuxConfigFsQuoteProvider struct {
+	// client is the configfs client to use. If nil, uses the system's configfs-tsm.
+	client configfsi.Client
+}
+
+// sevGuestTSMProvider is the configfs-tsm provider name of the Linux sev-guest driver.
+const sevGuestTSMProvider = "sev_guest"
+
+func (p *LinuxConfigFsQuoteProvider) getClient() (configfsi.Client, error) {
+	if p.client != nil {
+		return p.client, nil
+	}
+	return linuxtsm.MakeClient()
+}
 
 // IsSupported checks if TSM client can be created to use ConfigFS system.
 func (p *LinuxConfigFsQuoteProvider) IsSupported() bool {
-	_, err := linuxtsm.MakeClient()
+	_, err := p.getClient()
 	return err == nil
 }
 
-// GetRawQuoteAtLevel returns byte format attestation plus certificate table via ConfigFS.
-func (p *LinuxConfigFsQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]uint8, error) {
-	req := &report.Request{
-		InBlob:     reportData[:],
-		GetAuxBlob: true,
-		Privilege: &report.Privilege{
-			Level: level,
-		},
+// getQuote creates a configfs-tsm report entry for req and returns the report followed by its
+// certificate table. The report package detects concurrent writers to the entry by its generation.
+func (p *LinuxConfigFsQuoteProvider) getQuote(req *report.Request) ([]uint8, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+	r, err := report.Create(client, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := getReportAboveFloor(r)
+	if destroyErr := r.Destroy(); err == nil && destroyErr != nil {
+		return nil, destroyErr
 	}
-	resp, err := linuxtsm.GetReport(req)
 	if err != nil {
 		return nil, err
 	}
+	if provider := strings.TrimSpace(resp.Provider); provider != sevGuestTSMProvider {
+		return nil, fmt.Errorf("%w: got %q", ErrUnexpectedTSMProvider, provider)
+	}
 	// Mix the platform info in with the auxblob.
 	extended, err := abi.ExtendedPlatformCertTable(resp.AuxBlob)
 	if err != nil {
@@ -208,22 +372,41 @@ func (p *LinuxConfigFsQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, lev
 	return append(resp.OutBlob, extended...), nil
 }
 
+// getReportAboveFloor returns the report entry's response after checking that its requested
+// privilege level, if any, is allowed by the entry's privlevel_floor.
+func getReportAboveFloor(r *report.OpenReport) (*report.Response, error) {
+	if r.Privilege != nil {
+		floor, err := r.PrivilegeLevelFloor()
+		if err != nil {
+			return nil, err
+		}
+		if r.Privilege.Level < floor {
+			return nil, fmt.Errorf("%w: requested %d, floor %d", ErrPrivilegeLevelBelowFloor, r.Privilege.Level, floor)
+		}
+	}
+	return r.Get()
+}
+
+// GetRawQuoteAtLevel returns byte format attestation plus certificate table via ConfigFS.
+func (p *LinuxConfigFsQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]uint8, error) {
+	if level > labi.MaxVmpl {
+		return nil, fmt.Errorf("privilege level is %d. Expected 0-%d", level, labi.MaxVmpl)
+	}
+	return p.getQuote(&report.Request{
+		InBlob:     reportData[:],
+		GetAuxBlob: true,
+		Privilege: &report.Privilege{
+			Level: level,
+		},
+	})
+}
+
 // GetRawQuote returns byte format attestation plus certificate table via ConfigFS.
 func (p *LinuxConfigFsQuoteProvider) GetRawQuote(reportData [64]byte) ([]uint8, error) {
-	req := &report.Request{
+	return p.getQuote(&report.Request{
 		InBlob:     reportData[:],
 		GetAuxBlob: true,
-	}
-	resp, err := linuxtsm.GetReport(req)
-	if err != nil {
-		return nil, err
-	}
-	// Mix the platform info in with the auxblob.
-	extended, err := abi.ExtendedPlatformCertTable(resp.AuxBlob)
-	if err != nil {
-		return nil, fmt.Errorf("invalid certificate table: %v", err)
-	}
-	return append(resp.OutBlob, extended...), nil
+	})
 }
 
 // Product returns the current CPU's associated AMD SEV product information.
@@ -233,20 +416,40 @@ func (*LinuxConfigFsQuoteProvider) Product() *spb.SevProduct {
 	return abi.SevProduct()
 }
 
-// GetQuoteProvider returns a supported SEV-SNP QuoteProvider.
-func GetQuoteProvider() (QuoteProvider, error) {
-	preferred := &LinuxConfigFsQuoteProvider{}
-	if !preferred.IsSupported() {
-		return &LinuxIoctlQuoteProvider{}, nil
+// linuxQuoteProvider is implemented by both Linux quote providers.
+type linuxQuoteProvider interface {
+	QuoteProvider
+	LeveledQuoteProvider
+}
+
+// LinuxQuoteProviders returns the Linux quote providers in their default order of preference:
+// configfs-tsm, then the /dev/sev-guest ioctl. Reorder them for a FallbackQuoteProvider that
+// prefers the ioctl.
+func LinuxQuoteProviders() []QuoteProvider {
+	return []QuoteProvider{&LinuxConfigFsQuoteProvider{}, &LinuxIoctlQuoteProvider{}}
+}
+
+// getLinuxQuoteProvider returns a FallbackQuoteProvider of the LinuxQuoteProviders if the kernel
+// supports configfs-tsm reports or the /dev/sev-guest device can be opened.
+func getLinuxQuoteProvider() (linuxQuoteProvider, error) {
+	if !(&LinuxConfigFsQuoteProvider{}).IsSupported() {
+		d, err := OpenDevice()
+		if err != nil {
+			return nil, fmt.Errorf("no SEV-SNP quote provider available: configfs-tsm reports are unsupported and the sev-guest device could not be opened: %v", err)
+		}
+		d.Close()
 	}
-	return preferred, nil
+	return &FallbackQuoteProvider{Providers: LinuxQuoteProviders()}, nil
+}
+
+// GetQuoteProvider returns a supported SEV-SNP QuoteProvider. It prefers configfs-tsm and falls back
+// to the /dev/sev-guest ioctl interface, e.g., when configfs-tsm reports come from another
+// provider. Errors if neither is available.
+func GetQuoteProvider() (QuoteProvider, error) {
+	return getLinuxQuoteProvider()
 }
 
-// GetLeveledQuoteProvider returns a supported SEV-SNP LeveledQuoteProvider.
+// GetLeveledQuoteProvider returns a supported SEV-SNP LeveledQuoteProvider like GetQuoteProvider.
 func GetLeveledQuoteProvider() (LeveledQuoteProvider, error) {
-	preferred := &LinuxConfigFsQuoteProvider{}
-	if !preferred.IsSupported() {
-		return &LinuxIoctlQuoteProvider{}, nil
-	}
-	return preferred, nil
+	return getLinuxQuoteProvider()
 }
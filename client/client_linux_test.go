@@ -0,0 +1,419 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/google/go-configfs-tsm/configfs/configfsi"
+	"github.com/google/go-configfs-tsm/configfs/faketsm"
+	"github.com/google/go-sev-guest/abi"
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	test "github.com/google/go-sev-guest/testing"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetQuoteProviderUnavailable(t *testing.T) {
+	if (&LinuxConfigFsQuoteProvider{}).IsSupported() {
+		t.Skip("configfs-tsm is available")
+	}
+	old := *sevGuestPath
+	*sevGuestPath = t.TempDir() + "/no-sev-guest"
+	defer func() { *sevGuestPath = old }()
+
+	qp, err := GetQuoteProvider()
+	if err == nil || !strings.Contains(err.Error(), "no SEV-SNP quote provider available") {
+		t.Errorf("GetQuoteProvider() = %v, %v. Want a no provider error", qp, err)
+	}
+	if qp != nil {
+		t.Errorf("GetQuoteProvider() = %v. Want nil", qp)
+	}
+	if lqp, err := GetLeveledQuoteProvider(); err == nil || lqp != nil {
+		t.Errorf("GetLeveledQuoteProvider() = %v, %v. Want nil, error", lqp, err)
+	}
+}
+
+// fakeSevGuestTSM returns a configfs-tsm client whose reports come from the given provider with the
+// given privlevel_floor, with a fake attestation report as outblob and no certificates.
+func fakeSevGuestTSM(provider string, floor uint) (configfsi.Client, []byte) {
+	raw := test.TestRawReport([64]byte{1, 2, 3})
+	outblob := raw[:abi.ReportSize]
+	subsystem := faketsm.ReportV7(floor)
+	readV7 := subsystem.ReadAttr
+	subsystem.ReadAttr = func(e *faketsm.ReportEntry, attr string) ([]byte, error) {
+		switch attr {
+		case "provider":
+			return []byte(provider + "\n"), nil
+		case "outblob":
+			return outblob, nil
+		case "auxblob":
+			return nil, nil
+		}
+		return readV7(e, attr)
+	}
+	fake := &faketsm.Client{Subsystems: map[string]configfsi.Client{"report": subsystem}}
+	return &floorClient{Client: fake, floor: floor}, outblob
+}
+
+// floorClient serves privlevel_floor itself, since the fake only renders read-only attributes
+// after an entry's first write.
+type floorClient struct {
+	configfsi.Client
+	floor uint
+}
+
+func (c *floorClient) ReadFile(name string) ([]byte, error) {
+	if path.Base(name) == "privlevel_floor" {
+		return []byte(fmt.Sprintf("%d\n", c.floor)), nil
+	}
+	return c.Client.ReadFile(name)
+}
+
+func TestConfigFsQuoteProvider(t *testing.T) {
+	client, outblob := fakeSevGuestTSM("sev_guest", 0)
+	qp := &LinuxConfigFsQuoteProvider{client: client}
+	if !qp.IsSupported() {
+		t.Fatal("IsSupported() = false. Want true")
+	}
+	for name, get := range map[string]func() ([]byte, error){
+		"GetRawQuote":        func() ([]byte, error) { return qp.GetRawQuote([64]byte{1, 2, 3}) },
+		"GetRawQuoteAtLevel": func() ([]byte, error) { return qp.GetRawQuoteAtLevel([64]byte{1, 2, 3}, 1) },
+	} {
+		quote, err := get()
+		if err != nil {
+			t.Fatalf("%s() = _, %v. Want nil", name, err)
+		}
+		if len(quote) < abi.ReportSize || !bytes.Equal(quote[:abi.ReportSize], outblob) {
+			t.Errorf("%s() report = %x. Want %x", name, quote, outblob)
+		}
+		certs := new(abi.CertTable)
+		if err := certs.Unmarshal(quote[abi.ReportSize:]); err != nil {
+			t.Errorf("%s() certificate table = %v. Want nil", name, err)
+		} else if _, err := certs.GetByGUIDString(abi.ExtraPlatformInfoGUID); err != nil {
+			t.Errorf("%s() certificate table has no platform info: %v", name, err)
+		}
+	}
+}
+
+func TestConfigFsQuoteProviderWrongProvider(t *testing.T) {
+	client, _ := fakeSevGuestTSM("tdx_guest", 0)
+	qp := &LinuxConfigFsQuoteProvider{client: client}
+	if _, err := qp.GetRawQuote([64]byte{}); !errors.Is(err, ErrUnexpectedTSMProvider) || !strings.Contains(err.Error(), `"tdx_guest"`) {
+		t.Errorf("GetRawQuote() = _, %v. Want %v naming tdx_guest", err, ErrUnexpectedTSMProvider)
+	}
+}
+
+func TestConfigFsQuoteProviderPrivilegeLevel(t *testing.T) {
+	client, outblob := fakeSevGuestTSM("sev_guest", 2)
+	qp := &LinuxConfigFsQuoteProvider{client: client}
+	if _, err := qp.GetRawQuoteAtLevel([64]byte{}, 1); !errors.Is(err, ErrPrivilegeLevelBelowFloor) ||
+		!strings.Contains(err.Error(), "requested 1, floor 2") {
+		t.Errorf("GetRawQuoteAtLevel(_, 1) = _, %v. Want %v", err, ErrPrivilegeLevelBelowFloor)
+	}
+	if _, err := qp.GetRawQuoteAtLevel([64]byte{}, 4); err == nil || err.Error() != "privilege level is 4. Expected 0-3" {
+		t.Errorf("GetRawQuoteAtLevel(_, 4) = _, %v. Want privilege level error", err)
+	}
+	quote, err := qp.GetRawQuoteAtLevel([64]byte{}, 2)
+	if err != nil {
+		t.Fatalf("GetRawQuoteAtLevel(_, 2) = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(quote[:abi.ReportSize], outblob) {
+		t.Errorf("GetRawQuoteAtLevel(_, 2) report = %x. Want %x", quote[:abi.ReportSize], outblob)
+	}
+}
+
+func TestLinuxDeviceThrottleContext(t *testing.T) {
+	d := &LinuxDevice{fd: -1, open: true, lastCmd: time.Now(), options: linuxDeviceOptions(nil)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.IoctlContext(ctx, 0, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("IoctlContext(expiring, _, _) = _, %v. Want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed >= *throttleDuration {
+		t.Errorf("IoctlContext(expiring, _, _) waited %v for the self-throttle. Want less than %v", elapsed, *throttleDuration)
+	}
+}
+
+func TestOpenDeviceSelfThrottle(t *testing.T) {
+	oldThrottle, oldBurst := *throttleDuration, *burstMax
+	t.Cleanup(func() { *throttleDuration, *burstMax = oldThrottle, oldBurst })
+	*throttleDuration, *burstMax = time.Hour, 3
+	d, err := OpenDevice(WithDevicePath(os.DevNull))
+	if err != nil {
+		t.Fatalf("OpenDevice(%q) = _, %v. Want nil", os.DevNull, err)
+	}
+	defer d.Close()
+	// The flags are read when the device is opened, not for each command.
+	*throttleDuration, *burstMax = 0, 1
+	if d.options.throttleDuration != time.Hour || d.options.throttleBurst != 3 {
+		t.Errorf("self-throttle = %v per %d commands. Want %v per 3 from the flags at OpenDevice", d.options.throttleDuration, d.options.throttleBurst, time.Hour)
+	}
+	o, err := OpenDevice(WithDevicePath(os.DevNull), WithSelfThrottle(time.Minute, 0))
+	if err != nil {
+		t.Fatalf("OpenDevice(%q, self-throttle) = _, %v. Want nil", os.DevNull, err)
+	}
+	defer o.Close()
+	if o.options.throttleDuration != time.Minute || o.options.throttleBurst != 1 {
+		t.Errorf("WithSelfThrottle(%v, 0) self-throttle = %v per %d commands. Want %v per 1", time.Minute, o.options.throttleDuration, o.options.throttleBurst, time.Minute)
+	}
+}
+
+// patchsetGuestRequest is the guest request layout of labi.ABIRevisionPatchset kernels.
+type patchsetGuestRequest struct {
+	ReqData  uint64
+	RespData uint64
+	FwErr    uint32
+	_        uint32
+}
+
+// addressPointer returns the pointer that a guest request's __u64 address holds. The low bytes of
+// the little-endian address are a pointer of any size.
+func addressPointer(addr *uint64) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(addr))
+}
+
+// fakeKernel emulates the sev-guest driver of a kernel with the given guest request layout, or of
+// one with none of the known layouts if the revision is unknown. Its GET_REPORT response carries
+// marker in the report. If not zero, fwErr is written back and fails the command with errno, or
+// EIO if errno is zero, and errno fails the command.
+type fakeKernel struct {
+	revision labi.ABIRevision
+	marker   byte
+	fwErr    uint64
+	errno    unix.Errno
+	commands []uintptr
+}
+
+func (k *fakeKernel) ioctl(_ int, command uintptr, arg unsafe.Pointer) (uintptr, unix.Errno) {
+	k.commands = append(k.commands, command)
+	var resp unsafe.Pointer
+	switch {
+	case k.revision == labi.ABIRevisionUnknown || command != k.revision.Command(labi.IocSnpGetReport):
+		return 0, unix.ENOTTY
+	case k.revision == labi.ABIRevisionMainline:
+		req := (*labi.SnpUserGuestRequestABI)(arg)
+		resp = addressPointer(&req.RespData)
+		if k.fwErr != 0 {
+			req.FwErr = k.fwErr
+		}
+	case k.revision == labi.ABIRevisionPatchset:
+		req := (*patchsetGuestRequest)(arg)
+		resp = addressPointer(&req.RespData)
+		if k.fwErr != 0 {
+			req.FwErr = uint32(k.fwErr)
+		}
+	}
+	switch {
+	case k.errno != 0:
+		return 0, k.errno
+	case k.fwErr != 0:
+		return 0, unix.EIO
+	}
+	page := unsafe.Slice((*byte)(resp), labi.SnpReportRespABISize)
+	page[0x20] = k.marker
+	return 0, 0
+}
+
+func fakeKernelDevice(t *testing.T, kernel *fakeKernel) *LinuxDevice {
+	t.Helper()
+	oldIoctl, oldThrottle := ioctlSyscall, *throttleDuration
+	ioctlSyscall, *throttleDuration = kernel.ioctl, 0
+	t.Cleanup(func() { ioctlSyscall, *throttleDuration = oldIoctl, oldThrottle })
+	return &LinuxDevice{fd: -1, open: true, options: linuxDeviceOptions(nil)}
+}
+
+func TestLinuxDeviceABIRevision(t *testing.T) {
+	for _, revision := range labi.ABIRevisions {
+		t.Run(revision.String(), func(t *testing.T) {
+			kernel := &fakeKernel{revision: revision, marker: 0xaa}
+			d := fakeKernelDevice(t, kernel)
+			if got := d.ABIRevision(); got != labi.ABIRevisionUnknown {
+				t.Errorf("ABIRevision() before any command = %v. Want %v", got, labi.ABIRevisionUnknown)
+			}
+			for i := 0; i < 2; i++ {
+				report, err := GetRawReport(d, [64]byte{})
+				if err != nil {
+					t.Fatalf("GetRawReport() = _, %v. Want nil", err)
+				}
+				if report[0] != 0xaa {
+					t.Errorf("GetRawReport() report starts with 0x%x. Want 0xaa", report[0])
+				}
+			}
+			if got := d.ABIRevision(); got != revision {
+				t.Errorf("ABIRevision() = %v. Want %v", got, revision)
+			}
+			// Only the first command probes the layouts before the kernel's.
+			probes := 0
+			for _, r := range labi.ABIRevisions {
+				probes++
+				if r == revision {
+					break
+				}
+			}
+			if len(kernel.commands) != probes+1 {
+				t.Errorf("issued commands %x. Want %d probes and 1 command", kernel.commands, probes)
+			}
+			if last := kernel.commands[len(kernel.commands)-1]; last != revision.Command(labi.IocSnpGetReport) {
+				t.Errorf("second command = 0x%x. Want 0x%x", last, revision.Command(labi.IocSnpGetReport))
+			}
+		})
+	}
+}
+
+func TestLinuxDeviceABIRevisionFirmwareErr(t *testing.T) {
+	for _, revision := range labi.ABIRevisions {
+		t.Run(revision.String(), func(t *testing.T) {
+			d := fakeKernelDevice(t, &fakeKernel{revision: revision, fwErr: abi.InvalidParam})
+			_, err := GetRawReport(d, [64]byte{})
+			var fwErr *FirmwareErr
+			if !errors.As(err, &fwErr) || fwErr.Status != abi.InvalidParam {
+				t.Errorf("GetRawReport() = _, %v. Want *FirmwareErr with %v", err, abi.SevFirmwareStatus(abi.InvalidParam))
+			}
+		})
+	}
+}
+
+func TestLinuxDeviceNoABIRevision(t *testing.T) {
+	kernel := &fakeKernel{revision: labi.ABIRevisionUnknown}
+	d := fakeKernelDevice(t, kernel)
+	log := &recordingLogger{}
+	d.options.commands = &Options{Logger: log}
+	if _, err := GetRawReport(d, [64]byte{}); err != unix.ENOTTY {
+		t.Errorf("GetRawReport() = _, %v. Want the first layout's %v unchanged", err, unix.ENOTTY)
+	}
+	if len(kernel.commands) != len(labi.ABIRevisions) {
+		t.Errorf("issued commands %x. Want one for each of %v", kernel.commands, labi.ABIRevisions)
+	}
+	if got := d.ABIRevision(); got != labi.ABIRevisionUnknown {
+		t.Errorf("ABIRevision() = %v. Want %v", got, labi.ABIRevisionUnknown)
+	}
+	if !strings.Contains(strings.Join(log.lines, "\n"), "rejected every known sev-guest ioctl layout") {
+		t.Errorf("trace = %q. Want a layout hint", log.lines)
+	}
+}
+
+func TestLinuxDeviceABIRevisionRequestErr(t *testing.T) {
+	// A kernel that knows the command answers for the request, e.g., EINVAL for a bad VMPL, which
+	// is no reason to try another layout.
+	kernel := &fakeKernel{revision: labi.ABIRevisionMainline, errno: unix.EINVAL}
+	d := fakeKernelDevice(t, kernel)
+	if _, err := GetRawReport(d, [64]byte{}); err != unix.EINVAL {
+		t.Errorf("GetRawReport() = _, %v. Want %v", err, unix.EINVAL)
+	}
+	if len(kernel.commands) != 1 {
+		t.Errorf("issued commands %x. Want 1", kernel.commands)
+	}
+	if got := d.ABIRevision(); got != labi.ABIRevisionMainline {
+		t.Errorf("ABIRevision() = %v. Want %v", got, labi.ABIRevisionMainline)
+	}
+}
+
+func TestLinuxDeviceVmpckDisabledBeforeABIRevision(t *testing.T) {
+	// The first command of a device finds the VMPCK disabled. The probe of the next layout must not
+	// lose the firmware error that the mainline kernel wrote back.
+	kernel := &fakeKernel{revision: labi.ABIRevisionMainline, fwErr: abi.GuestRequestNoFirmwareCallFwErr, errno: unix.ENOTTY}
+	d := fakeKernelDevice(t, kernel)
+	_, err := GetRawReport(d, [64]byte{})
+	if !errors.Is(err, ErrVMPCKDisabled) || !errors.Is(err, unix.ENOTTY) {
+		t.Errorf("GetRawReport() = _, %v. Want %v from %v", err, ErrVMPCKDisabled, unix.ENOTTY)
+	}
+	if len(kernel.commands) != len(labi.ABIRevisions) {
+		t.Errorf("issued commands %x. Want one for each of %v", kernel.commands, labi.ABIRevisions)
+	}
+}
+
+func TestLinuxDeviceVmpckDisabledAfterABIRevision(t *testing.T) {
+	kernel := &fakeKernel{revision: labi.ABIRevisionMainline}
+	d := fakeKernelDevice(t, kernel)
+	if _, err := GetRawReport(d, [64]byte{}); err != nil {
+		t.Fatalf("GetRawReport() = _, %v. Want nil", err)
+	}
+	// Kernels before 6.x refuse with ENOTTY before they write back a firmware error.
+	kernel.errno = unix.ENOTTY
+	kernel.commands = nil
+	_, err := GetRawReport(d, [64]byte{})
+	if !errors.Is(err, ErrVMPCKDisabled) || !errors.Is(err, unix.ENOTTY) {
+		t.Errorf("GetRawReport() after the VMPCK was disabled = _, %v. Want %v from %v", err, ErrVMPCKDisabled, unix.ENOTTY)
+	}
+	if len(kernel.commands) != 1 {
+		t.Errorf("issued commands %x. Want 1", kernel.commands)
+	}
+}
+
+func TestLinuxDeviceClosed(t *testing.T) {
+	fakeKernelDevice(t, &fakeKernel{revision: labi.ABIRevisionMainline})
+	d := &LinuxDevice{}
+	if err := d.Open(os.DevNull); err != nil {
+		t.Fatalf("Open(%q) = %v. Want nil", os.DevNull, err)
+	}
+	if err := d.Open(os.DevNull); err == nil || !strings.Contains(err.Error(), "already open") {
+		t.Errorf("Open(%q) while open = %v. Want an already open error", os.DevNull, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close() = %v. Want nil", err)
+	}
+	if err := d.Close(); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Close() after Close() = %v. Want %v", err, ErrDeviceClosed)
+	}
+	if _, err := GetRawReport(d, [64]byte{}); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("GetRawReport() after Close() = _, %v. Want %v", err, ErrDeviceClosed)
+	}
+	if err := d.Open(os.DevNull); err != nil {
+		t.Fatalf("Open(%q) after Close() = %v. Want nil", os.DevNull, err)
+	}
+	defer d.Close()
+	if _, err := GetRawReport(d, [64]byte{}); err != nil {
+		t.Errorf("GetRawReport() after reopening = _, %v. Want nil", err)
+	}
+}
+
+func TestOpenDeviceCommandOptions(t *testing.T) {
+	fakeKernelDevice(t, &fakeKernel{revision: labi.ABIRevisionMainline})
+	log := &recordingLogger{}
+	d, err := OpenDevice(WithDevicePath(os.DevNull), WithLogger(log), WithRetryPolicy(&RetryPolicy{}))
+	if err != nil {
+		t.Fatalf("OpenDevice(%q, logger, no retries) = _, %v. Want nil", os.DevNull, err)
+	}
+	defer d.Close()
+	if _, err := GetRawReport(d, [64]byte{}); err != nil {
+		t.Fatalf("GetRawReport() = _, %v. Want nil", err)
+	}
+	if len(log.lines) != 2 || !strings.HasPrefix(log.lines[0], "DEBUG SNP_GET_REPORT: issuing") {
+		t.Errorf("trace of the device's command = %q. Want the device's logger to trace it", log.lines)
+	}
+	if got := d.commandOptions().retryPolicy(); got.MaxRetries != 0 {
+		t.Errorf("device retry policy = %+v. Want no retries", got)
+	}
+	// Options passed to a call replace the device's.
+	log.lines = nil
+	if _, err := getRawReportAtVmpl(context.Background(), d, [64]byte{}, 0, &Options{}); err != nil {
+		t.Fatalf("getRawReportAtVmpl(_, _, _, 0, untraced) = _, %v. Want nil", err)
+	}
+	if len(log.lines) != 0 {
+		t.Errorf("trace with call options = %q. Want none", log.lines)
+	}
+}
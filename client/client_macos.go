@@ -22,6 +22,9 @@ import (
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
 )
 
+// errMacOS wraps ErrUnsupportedPlatform for every operation on MacOS.
+var errMacOS = fmt.Errorf("%w: running on MacOS", ErrUnsupportedPlatform)
+
 // DefaultSevGuestDevicePath is the platform's usual device path to the SEV guest.
 const DefaultSevGuestDevicePath = "unknown"
 
@@ -30,22 +33,22 @@ type MacOSDevice struct{}
 
 // Open is not supported on MacOS.
 func (*MacOSDevice) Open(_ string) error {
-	return fmt.Errorf("MacOS is unsupported")
+	return errMacOS
 }
 
 // OpenDevice fails on MacOS.
-func OpenDevice() (*MacOSDevice, error) {
-	return nil, fmt.Errorf("MacOS is unsupported")
+func OpenDevice(_ ...DeviceOption) (*MacOSDevice, error) {
+	return nil, errMacOS
 }
 
 // Close is not supported on MacOS.
 func (*MacOSDevice) Close() error {
-	return fmt.Errorf("MacOS is unsupported")
+	return errMacOS
 }
 
 // Ioctl is not supported on MacOS.
 func (*MacOSDevice) Ioctl(_ uintptr, _ any) (uintptr, error) {
-	return 0, fmt.Errorf("MacOS is unsupported")
+	return 0, errMacOS
 }
 
 // Product is not supported on MacOS.
@@ -63,20 +66,27 @@ func (*MacOSQuoteProvider) IsSupported() bool {
 
 // GetRawQuote returns byte format attestation plus certificate table via ConfigFS.
 func (*MacOSQuoteProvider) GetRawQuote(reportData [64]byte) ([]byte, error) {
-	return nil, fmt.Errorf("MacOS is unsupported")
+	return nil, errMacOS
 }
 
 // GetRawQuoteAtLevel returns byte format attestation plus certificate table via ConfigFS.
 func (*MacOSQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]byte, error) {
-	return nil, fmt.Errorf("MacOS is unsupported")
+	return nil, errMacOS
+}
+
+// Product is not supported on MacOS.
+//
+// Deprecated: Use abi.ExtraPlatformInfoGUID in the raw quote certificate table.
+func (*MacOSQuoteProvider) Product() *spb.SevProduct {
+	return &spb.SevProduct{}
 }
 
 // GetQuoteProvider returns a supported SEV-SNP QuoteProvider.
 func GetQuoteProvider() (QuoteProvider, error) {
-	return nil, fmt.Errorf("MacOS is unsupported")
+	return nil, errMacOS
 }
 
 // GetLeveledQuoteProvider returns a supported SEV-SNP LeveledQuoteProvider.
 func GetLeveledQuoteProvider() (LeveledQuoteProvider, error) {
-	return nil, fmt.Errorf("MacOS is unsupported")
+	return nil, errMacOS
 }
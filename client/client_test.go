@@ -16,11 +16,17 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
@@ -28,6 +34,7 @@ import (
 	labi "github.com/google/go-sev-guest/client/linuxabi"
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
 	test "github.com/google/go-sev-guest/testing"
+	"github.com/google/uuid"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/testing/protocmp"
 )
@@ -108,7 +115,7 @@ func fixReportWants(report *spb.Report) {
 }
 
 func modifyReportBytes(raw []byte, process func(report *spb.Report)) error {
-	report, err := abi.ReportToProto(raw)
+	report, err := abi.ReportToProto(raw[:abi.ReportSize])
 	if err != nil {
 		return err
 	}
@@ -243,6 +250,67 @@ func TestGetQuoteProto(t *testing.T) {
 	}
 }
 
+func TestGetQuoteProtoWithUserData(t *testing.T) {
+	devMu.Do(initDevice)
+	for _, tc := range tests {
+		if tc.WantErr != "" {
+			continue
+		}
+		t.Run(tc.Name, func(t *testing.T) {
+			userData := abi.TrimReportData(tc.Input[:])
+			attestation, err := GetQuoteProtoWithUserData(qp, userData)
+			if err != nil {
+				t.Fatalf("GetQuoteProtoWithUserData(qp, %v) = _, %v. Want nil", userData, err)
+			}
+			if got := attestation.GetReport().GetReportData(); !bytes.Equal(got, tc.Input[:]) {
+				t.Errorf("GetQuoteProtoWithUserData(qp, %v) report data = %v. Want %v", userData, got, tc.Input)
+			}
+		})
+	}
+	tooLong := make([]byte, abi.ReportDataSize+1)
+	if _, err := GetQuoteProtoWithUserData(qp, tooLong); !errors.Is(err, abi.ErrReportDataTooLong) {
+		t.Errorf("GetQuoteProtoWithUserData(qp, %d bytes) = _, %v. Want %v", len(tooLong), err, abi.ErrReportDataTooLong)
+	}
+}
+
+func TestFirmwareErrorType(t *testing.T) {
+	devMu.Do(initDevice)
+	if !UseDefaultSevGuest() {
+		t.Skip("firmware errors cannot be forced on real hardware")
+	}
+	for _, tc := range tests {
+		if tc.FwErr == abi.Success {
+			continue
+		}
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := GetRawReport(device, tc.Input)
+			var fwErr *abi.SevFirmwareErr
+			if !errors.As(err, &fwErr) {
+				t.Fatalf("GetRawReport(device, %v) = _, %v. Want *abi.SevFirmwareErr", tc.Input, err)
+			}
+			if fwErr.Status != tc.FwErr {
+				t.Errorf("GetRawReport(device, %v) firmware status = %v. Want %v", tc.Input, fwErr.Status, tc.FwErr)
+			}
+		})
+	}
+}
+
+func TestFirmwareErrFromAllCommands(t *testing.T) {
+	d, input := throttledDevice(t, 1)
+	noRetries := &Options{Retry: &RetryPolicy{}}
+	var fwErr *FirmwareErr
+	if _, err := GetReportWithOptions(context.Background(), d, input, noRetries); !errors.As(err, &fwErr) || fwErr.Status != abi.GuestRequestBusy {
+		t.Errorf("GetReportWithOptions(throttled) = _, %v. Want *FirmwareErr with %v", err, abi.GuestRequestBusy)
+	}
+	d.ThrottledResponses = 1
+	_, err := GetExtendedReportWithOptions(context.Background(), d, input, noRetries)
+	if !errors.As(err, &fwErr) || fwErr.Status != abi.GuestRequestBusy {
+		t.Errorf("GetExtendedReportWithOptions(throttled) = _, %v. Want *FirmwareErr with %v", err, abi.GuestRequestBusy)
+	} else if !strings.Contains(err.Error(), "GUEST_REQUEST_BUSY") {
+		t.Errorf("GetExtendedReportWithOptions(throttled) error %q does not name GUEST_REQUEST_BUSY", err)
+	}
+}
+
 func TestGetDerivedKey(t *testing.T) {
 	devMu.Do(initDevice)
 	key1, err := GetDerivedKeyAcknowledgingItsLimitations(device, &SnpDerivedKeyReq{
@@ -273,3 +341,782 @@ func TestGetDerivedKey(t *testing.T) {
 		t.Errorf("GetDerivedKey...(nothing) = %v and %v. Expected equality", key1.Data, key3.Data)
 	}
 }
+
+func TestGetDerivedKeyBytes(t *testing.T) {
+	devMu.Do(initDevice)
+	key, err := GetDerivedKey(device, &SnpDerivedKeyReq{UseVCEK: true})
+	if err != nil {
+		t.Fatalf("GetDerivedKey(device, VCEK) = _, %v. Want nil", err)
+	}
+	want, err := GetDerivedKeyAcknowledgingItsLimitations(device, &SnpDerivedKeyReq{UseVCEK: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, want.Data[:]) {
+		t.Errorf("GetDerivedKey(device, VCEK) = %x. Want %x", key, want.Data)
+	}
+}
+
+// statusDevice is a Device that answers derived key requests with a firmware status and
+// leftover key bytes.
+type statusDevice struct {
+	noIoctlDevice
+	status uint32
+}
+
+func (d *statusDevice) Ioctl(_ uintptr, req any) (uintptr, error) {
+	rsp := req.(*labi.SnpUserGuestRequest).RespData.(*labi.SnpDerivedKeyRespABI)
+	rsp.Status = d.status
+	rsp.Data[0] = 0xff
+	return 0, nil
+}
+
+func TestGetDerivedKeyFirmwareError(t *testing.T) {
+	d := &statusDevice{status: uint32(abi.InvalidParam)}
+	key, err := GetDerivedKey(d, &SnpDerivedKeyReq{})
+	var fwErr *abi.SevFirmwareErr
+	if !errors.As(err, &fwErr) || fwErr.Status != abi.InvalidParam {
+		t.Fatalf("GetDerivedKey() = _, %v. Want *abi.SevFirmwareErr with status %v", err, abi.InvalidParam)
+	}
+	if key != nil {
+		t.Errorf("GetDerivedKey() with firmware error = %x. Want nil", key)
+	}
+}
+
+func TestGetDerivedKeyRequestFields(t *testing.T) {
+	want := &labi.SnpDerivedKeyReqABI{
+		RootKeySelect:    labi.KeySelVCEK << labi.RootKeySelectKeySelShift,
+		GuestFieldSelect: GuestFieldSelect{GuestSVN: true, TCBVersion: true}.ABI(),
+		Vmpl:             2,
+		GuestSVN:         3,
+		TCBVersion:       0x1234,
+	}
+	wantKey := bytes.Repeat([]byte{7}, 32)
+	d := &test.Device{
+		Keys:                  map[string][]byte{test.DerivedKeyRequestToString(want): wantKey},
+		WantDerivedKeyRequest: want,
+	}
+	request := &SnpDerivedKeyReq{
+		UseVCEK:          true,
+		KeySel:           labi.KeySelVCEK,
+		GuestFieldSelect: GuestFieldSelect{GuestSVN: true, TCBVersion: true},
+		Vmpl:             2,
+		GuestSVN:         3,
+		TCBVersion:       0x1234,
+	}
+	key, err := GetDerivedKey(d, request)
+	if err != nil {
+		t.Fatalf("GetDerivedKey(d, %+v) = _, %v. Want nil", request, err)
+	}
+	if !bytes.Equal(key, wantKey) {
+		t.Errorf("GetDerivedKey(d, %+v) = %x. Want %x", request, key, wantKey)
+	}
+	request.Vmpl = 3
+	if _, err := GetDerivedKey(d, request); err == nil || !strings.Contains(err.Error(), "test error: derived key request") {
+		t.Errorf("GetDerivedKey(d, vmpl 3) = _, %v. Want a request mismatch", err)
+	}
+}
+
+func TestGetDerivedKeyNoVlek(t *testing.T) {
+	d := &test.Device{}
+	_, err := GetDerivedKey(d, &SnpDerivedKeyReq{UseVCEK: true, KeySel: labi.KeySelVLEK})
+	var fwErr *FirmwareErr
+	if !errors.As(err, &fwErr) || fwErr.Status != abi.InvalidKey {
+		t.Fatalf("GetDerivedKey(no VLEK, VLEK) = _, %v. Want *FirmwareErr with %v", err, abi.InvalidKey)
+	}
+	if !strings.Contains(err.Error(), "no VLEK installed") {
+		t.Errorf("GetDerivedKey(no VLEK, VLEK) = _, %v. Want it to explain the missing VLEK", err)
+	}
+}
+
+func TestGetDerivedKeyInvalidRequest(t *testing.T) {
+	tcs := []struct {
+		name    string
+		request *SnpDerivedKeyReq
+		wantErr string
+	}{
+		{name: "vmpl", request: &SnpDerivedKeyReq{Vmpl: 4}, wantErr: "vmpl is 4. Expected 0-3"},
+		{name: "key_sel", request: &SnpDerivedKeyReq{UseVCEK: true, KeySel: 3}, wantErr: "key_sel is 3. Expected 0-2"},
+		{name: "key_sel for VMRK", request: &SnpDerivedKeyReq{KeySel: labi.KeySelVLEK}, wantErr: "needs UseVCEK"},
+		{name: "unselected guest_svn", request: &SnpDerivedKeyReq{GuestSVN: 1}, wantErr: "without GuestFieldSelect.GuestSVN"},
+		{name: "unselected tcb_version", request: &SnpDerivedKeyReq{TCBVersion: 1}, wantErr: "without GuestFieldSelect.TCBVersion"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &noIoctlDevice{}
+			if _, err := GetDerivedKey(d, tc.request); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("GetDerivedKey(d, %+v) = _, %v. Want error containing %q", tc.request, err, tc.wantErr)
+			}
+			if d.ioctls != 0 {
+				t.Errorf("GetDerivedKey(d, %+v) issued %d ioctls. Want 0", tc.request, d.ioctls)
+			}
+		})
+	}
+}
+
+func TestMockDerivedKeyInvalidFieldSelect(t *testing.T) {
+	devMu.Do(initDevice)
+	if !UseDefaultSevGuest() {
+		t.Skip("the client API cannot send undefined guest field select bits to real hardware")
+	}
+	rsp := &labi.SnpDerivedKeyRespABI{}
+	req := &labi.SnpUserGuestRequest{
+		ReqData:  &labi.SnpDerivedKeyReqABI{GuestFieldSelect: 1 << 63},
+		RespData: rsp,
+	}
+	if _, err := device.Ioctl(labi.IocSnpGetDerivedKey, req); err != nil {
+		t.Fatalf("Ioctl(GET_DERIVED_KEY) = _, %v. Want nil", err)
+	}
+	if rsp.Status != uint32(abi.InvalidParam) {
+		t.Errorf("GET_DERIVED_KEY with undefined guest field select status = 0x%x. Want 0x%x", rsp.Status, abi.InvalidParam)
+	}
+}
+
+// noIoctlDevice is a Device that fails any ioctl, for checking that requests are rejected early.
+type noIoctlDevice struct{ ioctls int }
+
+func (d *noIoctlDevice) Open(string) error        { return nil }
+func (d *noIoctlDevice) Close() error             { return nil }
+func (d *noIoctlDevice) Product() *spb.SevProduct { return abi.DefaultSevProduct() }
+func (d *noIoctlDevice) Ioctl(uintptr, any) (uintptr, error) {
+	d.ioctls++
+	return 0, errors.New("unexpected ioctl")
+}
+
+func TestGetReportInvalidVmpl(t *testing.T) {
+	d := &noIoctlDevice{}
+	if _, err := GetRawReportAtVmpl(d, [64]byte{}, 4); err == nil || err.Error() != "vmpl is 4. Expected 0-3" {
+		t.Errorf("GetRawReportAtVmpl(d, _, 4) = _, %v. Want vmpl error", err)
+	}
+	if _, _, err := GetRawExtendedReportAtVmpl(d, [64]byte{}, -1); err == nil {
+		t.Error("GetRawExtendedReportAtVmpl(d, _, -1) = _, _, nil. Want vmpl error")
+	}
+	if d.ioctls != 0 {
+		t.Errorf("invalid report requests issued %d ioctls. Want 0", d.ioctls)
+	}
+}
+
+func TestReportContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := &noIoctlDevice{}
+	if _, err := GetReportContext(ctx, d, [64]byte{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetReportContext(canceled, d, _) = _, %v. Want %v", err, context.Canceled)
+	}
+	if _, err := GetExtendedReportContext(ctx, d, [64]byte{}); !errors.Is(err, context.Canceled) ||
+		!strings.Contains(err.Error(), "before it was sent") {
+		t.Errorf("GetExtendedReportContext(canceled, d, _) = _, %v. Want %v before it was sent", err, context.Canceled)
+	}
+	if d.ioctls != 0 {
+		t.Errorf("canceled report requests issued %d ioctls. Want 0", d.ioctls)
+	}
+	if _, err := GetQuoteContext(ctx, &test.QuoteProvider{}, [64]byte{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetQuoteContext(canceled, qp, _) = _, %v. Want %v", err, context.Canceled)
+	}
+}
+
+// cancelingDevice requires a larger certificate buffer for an extended report request and then
+// cancels the request's context.
+type cancelingDevice struct {
+	noIoctlDevice
+	cancel context.CancelFunc
+}
+
+func (d *cancelingDevice) Ioctl(_ uintptr, req any) (uintptr, error) {
+	d.ioctls++
+	greq := req.(*labi.SnpUserGuestRequest)
+	greq.ReqData.(*labi.SnpExtendedReportReq).CertsLength = 0x5000
+	greq.FwErr = uint64(abi.GuestRequestInvalidLength)
+	d.cancel()
+	return 0, errors.New("certificate buffer too small")
+}
+
+func TestExtendedReportContextCanceledBetweenCommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &cancelingDevice{cancel: cancel}
+	_, err := GetExtendedReportContext(ctx, d, [64]byte{})
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "after the host required a 20480 byte certificate buffer") {
+		t.Errorf("GetExtendedReportContext(ctx, d, _) = _, %v. Want %v after the host required a larger buffer", err, context.Canceled)
+	}
+	if d.ioctls != 1 {
+		t.Errorf("GetExtendedReportContext(ctx, d, _) issued %d ioctls. Want 1", d.ioctls)
+	}
+}
+
+var throttleMu sync.Once
+
+// throttleDevice is the device that throttledDevice copies, so that its certificates are only
+// generated once.
+var throttleDevice *test.Device
+var throttleInput [64]byte
+
+func initThrottleDevice() {
+	var tcs []test.TestCase
+	for _, tc := range test.TestCases() {
+		if tc.WantErr == "" {
+			tcs = append(tcs, tc)
+		}
+	}
+	d, err := test.TcDevice(tcs[:1], &test.DeviceOptions{Now: time.Date(2022, time.May, 3, 9, 0, 0, 0, time.UTC)})
+	if err != nil {
+		panic(fmt.Sprintf("failed to create test device: %v", err))
+	}
+	throttleDevice, throttleInput = d, tcs[0].Input
+}
+
+// throttledDevice returns a new mock device and a report data input it answers to. The device
+// answers the given number of requests as throttled first. Each device has its own responses and
+// throttle state, so a test that changes them does not affect any other.
+func throttledDevice(t *testing.T, throttled int) (*test.Device, [64]byte) {
+	t.Helper()
+	throttleMu.Do(initThrottleDevice)
+	responses := make(map[string]any, len(throttleDevice.ReportDataRsp))
+	for k, v := range throttleDevice.ReportDataRsp {
+		responses[k] = v
+	}
+	return &test.Device{
+		ReportDataRsp:      responses,
+		Keys:               throttleDevice.Keys,
+		Certs:              throttleDevice.Certs,
+		Signer:             throttleDevice.Signer,
+		SevProduct:         throttleDevice.SevProduct,
+		ThrottledResponses: throttled,
+	}, throttleInput
+}
+
+func fastRetries(n int) *Options {
+	return &Options{Retry: &RetryPolicy{MaxRetries: n, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}}
+}
+
+func TestReportRetriesThrottled(t *testing.T) {
+	d, input := throttledDevice(t, 2)
+	if _, err := GetReportWithOptions(context.Background(), d, input, fastRetries(2)); err != nil {
+		t.Errorf("GetReportWithOptions(_, d, _, 2 retries) = _, %v. Want success after 2 throttled responses", err)
+	}
+	d, input = throttledDevice(t, 2)
+	if _, err := GetExtendedReportWithOptions(context.Background(), d, input, fastRetries(2)); err != nil {
+		t.Errorf("GetExtendedReportWithOptions(_, d, _, 2 retries) = _, %v. Want success after 2 throttled responses", err)
+	}
+	if d.ThrottledResponses != 0 {
+		t.Errorf("ThrottledResponses = %d after retries. Want 0", d.ThrottledResponses)
+	}
+}
+
+func TestReportRetriesExhausted(t *testing.T) {
+	d, input := throttledDevice(t, 5)
+	_, err := GetReportWithOptions(context.Background(), d, input, fastRetries(2))
+	var fwErr *abi.SevFirmwareErr
+	if !errors.As(err, &fwErr) || fwErr.Status != abi.GuestRequestBusy || !strings.Contains(err.Error(), "giving up after 2 retries") {
+		t.Errorf("GetReportWithOptions(_, d, _, 2 retries) = _, %v. Want to give up with %v", err, abi.GuestRequestBusy)
+	}
+	if d.ThrottledResponses != 2 {
+		t.Errorf("ThrottledResponses = %d after giving up. Want 2", d.ThrottledResponses)
+	}
+	d, input = throttledDevice(t, 1)
+	if _, err := GetReportWithOptions(context.Background(), d, input, &Options{Retry: &RetryPolicy{}}); !errors.As(err, &fwErr) || fwErr.Status != abi.GuestRequestBusy {
+		t.Errorf("GetReportWithOptions(_, d, _, no retries) = _, %v. Want %v", err, abi.GuestRequestBusy)
+	}
+}
+
+// optionsTestDevice is a Device that carries Options, as a LinuxDevice does after OpenDevice.
+type optionsTestDevice struct {
+	*test.Device
+	options *Options
+}
+
+func (d *optionsTestDevice) commandOptions() *Options { return d.options }
+
+func TestDeviceCommandOptions(t *testing.T) {
+	base, input := throttledDevice(t, 1)
+	d := &optionsTestDevice{Device: base, options: &Options{Retry: &RetryPolicy{}}}
+	var fwErr *FirmwareErr
+	if _, err := GetReport(d, input); !errors.As(err, &fwErr) || fwErr.Status != abi.GuestRequestBusy {
+		t.Errorf("GetReport(no retries device, _) = _, %v. Want %v", err, abi.GuestRequestBusy)
+	}
+	base.ThrottledResponses = 1
+	if _, err := GetReportWithOptions(context.Background(), d, input, fastRetries(1)); err != nil {
+		t.Errorf("GetReportWithOptions(no retries device, _, 1 retry) = _, %v. Want the call's retries", err)
+	}
+}
+
+func TestReportVmpckDisabledNotRetried(t *testing.T) {
+	base, input := throttledDevice(t, 2)
+	d := &countingDevice{Device: base, commands: map[uintptr]int{}}
+	if _, err := GetReportWithOptions(context.Background(), d, input, fastRetries(3)); err != nil {
+		t.Fatalf("GetReportWithOptions(_, throttled, _, 3 retries) = _, %v. Want nil", err)
+	}
+	if got := d.commands[labi.IocSnpGetReport]; got != 3 {
+		t.Errorf("throttled report sent %d commands. Want 3", got)
+	}
+	base.VmpckDisabled = true
+	defer func() { base.VmpckDisabled = false }()
+	d.commands = map[uintptr]int{}
+	_, err := GetReportWithOptions(context.Background(), d, input, fastRetries(3))
+	if !errors.Is(err, ErrVMPCKDisabled) || !errors.Is(err, syscall.ENOTTY) {
+		t.Errorf("GetReportWithOptions(_, VMPCK disabled, _, 3 retries) = _, %v. Want %v from ENOTTY", err, ErrVMPCKDisabled)
+	}
+	if got := d.commands[labi.IocSnpGetReport]; got != 1 {
+		t.Errorf("report with the VMPCK disabled sent %d commands. Want 1", got)
+	}
+}
+
+// errnoDevice fails every command with an errno and firmware error.
+type errnoDevice struct {
+	noIoctlDevice
+	errno syscall.Errno
+	fwErr uint64
+}
+
+func (d *errnoDevice) Ioctl(_ uintptr, req any) (uintptr, error) {
+	req.(*labi.SnpUserGuestRequest).FwErr = d.fwErr
+	return 0, d.errno
+}
+
+// probingErrnoDevice is an errnoDevice that has not found the kernel's guest request layout.
+type probingErrnoDevice struct {
+	errnoDevice
+}
+
+func (*probingErrnoDevice) ABIRevision() labi.ABIRevision {
+	return labi.ABIRevisionUnknown
+}
+
+func TestVmpckSequenceExhausted(t *testing.T) {
+	// The driver's sequence number check and its disabled VMPCK fail without calling the firmware.
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.ENOTTY} {
+		_, err := GetRawReport(&errnoDevice{errno: errno, fwErr: abi.GuestRequestNoFirmwareCallFwErr}, [64]byte{})
+		if !errors.Is(err, ErrVMPCKDisabled) || !errors.Is(err, errno) {
+			t.Errorf("GetRawReport(%v without a firmware call) = _, %v. Want %v from %v", errno, err, ErrVMPCKDisabled, errno)
+		}
+	}
+	// The firmware's message sequence numbers ran out.
+	_, err := GetRawReport(&errnoDevice{errno: syscall.EIO, fwErr: uint64(abi.AeadOflow)}, [64]byte{})
+	var fwErr *FirmwareErr
+	if !errors.Is(err, ErrVMPCKDisabled) || !errors.As(err, &fwErr) || fwErr.Status != abi.AeadOflow {
+		t.Errorf("GetRawReport(EIO with AEAD_OFLOW) = _, %v. Want %v from the firmware error", err, ErrVMPCKDisabled)
+	}
+	_, err = GetRawReport(&errnoDevice{errno: syscall.EIO, fwErr: uint64(abi.InvalidParam)}, [64]byte{})
+	if errors.Is(err, ErrVMPCKDisabled) || !errors.As(err, &fwErr) {
+		t.Errorf("GetRawReport(EIO with firmware error) = _, %v. Want only the firmware error", err)
+	}
+	// Kernels before 6.x refuse every request with a bare ENOTTY once the key is disabled.
+	_, err = GetRawReport(&errnoDevice{errno: syscall.ENOTTY}, [64]byte{})
+	if !errors.Is(err, ErrVMPCKDisabled) || !errors.Is(err, syscall.ENOTTY) {
+		t.Errorf("GetRawReport(bare ENOTTY) = _, %v. Want %v from ENOTTY", err, ErrVMPCKDisabled)
+	}
+	// A bare ENOTTY before the device knows the kernel's layout may be a rejection of the layout,
+	// and a bare EIO says nothing about the VMPCK.
+	if _, err := GetRawReport(&probingErrnoDevice{errnoDevice{errno: syscall.ENOTTY}}, [64]byte{}); err != syscall.ENOTTY {
+		t.Errorf("GetRawReport(bare ENOTTY before the layout is known) = _, %v. Want the errno unchanged", err)
+	}
+	if _, err := GetRawReport(&errnoDevice{errno: syscall.EIO}, [64]byte{}); err != syscall.EIO {
+		t.Errorf("GetRawReport(bare EIO) = _, %v. Want the errno unchanged", err)
+	}
+	_, err = GetRawReport(&errnoDevice{errno: syscall.EINVAL, fwErr: abi.GuestRequestNoFirmwareCallFwErr}, [64]byte{})
+	if errors.Is(err, ErrVMPCKDisabled) || errors.As(err, &fwErr) || !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("GetRawReport(EINVAL without a firmware call) = _, %v. Want only EINVAL", err)
+	}
+}
+
+func TestReportRetryBackoffCanceled(t *testing.T) {
+	d, input := throttledDevice(t, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	opts := &Options{Retry: &RetryPolicy{MaxRetries: 1, InitialBackoff: time.Hour, MaxBackoff: time.Hour}}
+	if _, err := GetReportWithOptions(ctx, d, input, opts); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetReportWithOptions(expiring, d, _, hour backoff) = _, %v. Want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, want := range []time.Duration{100, 200, 400, 800, 1000, 1000, 1000} {
+		want *= time.Millisecond
+		if got := p.backoff(retry); got < want/2 || got > want {
+			t.Errorf("backoff(%d) = %v. Want between %v and %v", retry, got, want/2, want)
+		}
+	}
+}
+
+// growingDevice raises the certificate buffer size the mock device requires by a page after each
+// of its first grows extended report requests.
+type growingDevice struct {
+	*test.Device
+	grows    int
+	requests int
+}
+
+func (d *growingDevice) Ioctl(command uintptr, req any) (uintptr, error) {
+	result, err := d.Device.Ioctl(command, req)
+	if command == labi.IocSnpGetExtendedReport {
+		d.requests++
+		if d.grows > 0 {
+			d.grows--
+			d.Certs = append(d.Certs, make([]byte, 0x1000)...)
+		}
+	}
+	return result, err
+}
+
+// certsDevice returns a mock device whose certificates are replaced by the given number of bytes
+// of the original certificate table, zero-padded.
+func certsDevice(t *testing.T, length int) (*test.Device, [64]byte) {
+	t.Helper()
+	d, input := throttledDevice(t, 0)
+	original := d.Certs
+	t.Cleanup(func() { d.Certs = original })
+	d.Certs = nil
+	if length > 0 {
+		d.Certs = make([]byte, length)
+		copy(d.Certs, original)
+	}
+	return d, input
+}
+
+func TestExtendedReportCertsResize(t *testing.T) {
+	base, input := certsDevice(t, defaultCertsLength+0x1000)
+	want := new(abi.CertTable)
+	if err := want.Unmarshal(base.Certs); err != nil {
+		t.Fatal(err)
+	}
+	d := &growingDevice{Device: base, grows: 1}
+	attestation, err := GetExtendedReportWithOptions(context.Background(), d, input, nil)
+	if err != nil {
+		t.Fatalf("GetExtendedReportWithOptions(_, growing, _, _) = _, %v. Want nil", err)
+	}
+	if d.requests != 3 {
+		t.Errorf("GetExtendedReportWithOptions(_, growing, _, _) sent %d requests. Want 3", d.requests)
+	}
+	if diff := cmp.Diff(attestation.GetCertificateChain(), want.Proto(), protocmp.Transform()); diff != "" {
+		t.Errorf("GetExtendedReportWithOptions(_, growing, _, _) certificates differ: %s", diff)
+	}
+}
+
+func TestExtendedReportCertsResizeCapped(t *testing.T) {
+	base, input := certsDevice(t, defaultCertsLength+0x1000)
+	d := &growingDevice{Device: base, grows: maxCertsResizes + 1}
+	_, err := GetExtendedReportWithOptions(context.Background(), d, input, nil)
+	if err == nil || !strings.Contains(err.Error(), "after 3 resizes") {
+		t.Errorf("GetExtendedReportWithOptions(_, ever growing, _, _) = _, %v. Want resize limit error", err)
+	}
+	if d.requests != maxCertsResizes+1 {
+		t.Errorf("GetExtendedReportWithOptions(_, ever growing, _, _) sent %d requests. Want %d", d.requests, maxCertsResizes+1)
+	}
+}
+
+func TestExtendedReportNoCerts(t *testing.T) {
+	d, input := certsDevice(t, 0)
+	attestation, err := GetExtendedReportWithOptions(context.Background(), d, input, nil)
+	if err != nil {
+		t.Fatalf("GetExtendedReportWithOptions(_, no certs, _, _) = _, %v. Want nil", err)
+	}
+	if attestation.GetReport() == nil {
+		t.Error("GetExtendedReportWithOptions(_, no certs, _, _) has no report")
+	}
+	if diff := cmp.Diff(attestation.GetCertificateChain(), (&abi.CertTable{}).Proto(), protocmp.Transform()); diff != "" {
+		t.Errorf("GetExtendedReportWithOptions(_, no certs, _, _) certificates are not empty: %s", diff)
+	}
+}
+
+func TestGetRawExtendedReportAtVmplTrimsCerts(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	want := d.Certs
+	// Zero padding past the table's last certificate is not part of the table.
+	d, input = certsDevice(t, len(want)+0x1000)
+	report, certs, err := GetRawExtendedReportAtVmpl(d, input, 1)
+	if err != nil {
+		t.Fatalf("GetRawExtendedReportAtVmpl(d, _, 1) = _, _, %v. Want nil", err)
+	}
+	if len(report) != abi.ReportSize {
+		t.Errorf("GetRawExtendedReportAtVmpl(d, _, 1) report has %d bytes. Want %d", len(report), abi.ReportSize)
+	}
+	if !bytes.Equal(certs, want) {
+		t.Errorf("GetRawExtendedReportAtVmpl(d, _, 1) certs has %d bytes. Want the %d byte table", len(certs), len(want))
+	}
+
+	d, input = certsDevice(t, 0)
+	if _, certs, err := GetRawExtendedReportAtVmpl(d, input, 1); err != nil || certs != nil {
+		t.Errorf("GetRawExtendedReportAtVmpl(d, _, 1) = _, %v, %v. Want nil certs for an empty table", certs, err)
+	}
+}
+
+func TestGetParsedExtendedReport(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	extended, err := GetParsedExtendedReport(context.Background(), d, input, 0, nil)
+	if err != nil {
+		t.Fatalf("GetParsedExtendedReport(_, d, _, 0, nil) = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(extended.Report.GetReportData(), input[:]) {
+		t.Errorf("GetParsedExtendedReport(_, d, _, 0, nil) report data = %x. Want %x", extended.Report.GetReportData(), input)
+	}
+	if len(extended.RawReport()) != abi.ReportSize {
+		t.Errorf("RawReport() has %d bytes. Want %d", len(extended.RawReport()), abi.ReportSize)
+	}
+	if !bytes.Equal(extended.RawCertTable(), d.Certs) {
+		t.Errorf("RawCertTable() = %x. Want the device's table %x", extended.RawCertTable(), d.Certs)
+	}
+	for name, got := range map[string][]byte{
+		"ARK":  extended.CertificateChain.GetArkCert(),
+		"ASK":  extended.CertificateChain.GetAskCert(),
+		"VCEK": extended.CertificateChain.GetVcekCert(),
+	} {
+		if len(got) == 0 {
+			t.Errorf("CertificateChain has no %s certificate", name)
+		}
+	}
+	if got, err := extended.Certs.GetByGUIDString(abi.ArkGUID); err != nil || !bytes.Equal(got, d.Signer.Ark.Raw) {
+		t.Errorf("Certs.GetByGUIDString(ARK) = %x, %v. Want the ARK", got, err)
+	}
+}
+
+func TestGetParsedExtendedReportMalformedTable(t *testing.T) {
+	d, input := certsDevice(t, 0)
+	// The VCEK and ASK entries overlap.
+	table := make([]byte, 3*abi.CertTableEntrySize+16)
+	entries := []abi.CertTableHeaderEntry{
+		{GUID: uuid.MustParse(abi.VcekGUID), Offset: 72, Length: 8},
+		{GUID: uuid.MustParse(abi.AskGUID), Offset: 76, Length: 12},
+	}
+	for i := range entries {
+		if err := entries[i].Write(table[i*abi.CertTableEntrySize:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Certs = table
+	_, err := GetParsedExtendedReport(context.Background(), d, input, 0, nil)
+	if err == nil || !strings.Contains(err.Error(), "host certificate table is malformed") ||
+		!strings.Contains(err.Error(), "guids="+abi.VcekGUID+" and "+abi.AskGUID) {
+		t.Errorf("GetParsedExtendedReport(_, malformed, _, 0, nil) = _, %v. Want an error naming the entry", err)
+	}
+}
+
+func TestTrimCertTable(t *testing.T) {
+	table := (&abi.CertTable{Entries: []abi.CertTableEntry{
+		{GUID: uuid.MustParse(abi.ArkGUID), RawCert: []byte{1, 2, 3}},
+	}}).Marshal()
+	padded := append(append([]byte{}, table...), make([]byte, 0x1000)...)
+	tcs := []struct {
+		name     string
+		certs    []byte
+		reported uint32
+		want     []byte
+	}{
+		{name: "exact", certs: table, reported: uint32(len(table)), want: table},
+		{name: "unreported padding", certs: padded, reported: uint32(len(padded)), want: table},
+		{name: "reported length", certs: padded, reported: uint32(len(table) + 1), want: table},
+		{name: "all zeros", certs: make([]byte, 0x1000), reported: 0x1000},
+		{name: "empty", certs: []byte{}},
+		{name: "malformed", certs: []byte{1}, want: []byte{1}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trimCertTable(tc.certs, tc.reported)
+			if !bytes.Equal(got, tc.want) || (tc.want == nil) != (got == nil) {
+				t.Errorf("trimCertTable(_, %d) = %v. Want %v", tc.reported, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeviceConcurrentUse(t *testing.T) {
+	// Certificates beyond the default buffer make each extended report negotiate its buffer size.
+	d, input := certsDevice(t, defaultCertsLength+0x1000)
+	want, err := GetExtendedReport(d, input)
+	if err != nil {
+		t.Fatalf("GetExtendedReport(d, _) = _, %v. Want nil", err)
+	}
+	const goroutines = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			report, err := GetReport(d, input)
+			if err != nil {
+				errs <- fmt.Errorf("GetReport(d, _) = _, %v. Want nil", err)
+			} else if !bytes.Equal(report.GetReportData(), input[:]) {
+				errs <- fmt.Errorf("GetReport(d, _) report data = %x. Want %x", report.GetReportData(), input)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			attestation, err := GetExtendedReport(d, input)
+			if err != nil {
+				errs <- fmt.Errorf("GetExtendedReport(d, _) = _, %v. Want nil", err)
+			} else if !bytes.Equal(attestation.GetReport().GetReportData(), input[:]) {
+				errs <- fmt.Errorf("GetExtendedReport(d, _) report data = %x. Want %x", attestation.GetReport().GetReportData(), input)
+			} else if diff := cmp.Diff(attestation.GetCertificateChain(), want.GetCertificateChain(), protocmp.Transform()); diff != "" {
+				errs <- fmt.Errorf("GetExtendedReport(d, _) certificates differ: %s", diff)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestGetQuoteProtoFromDevice(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	attestation, err := GetQuoteProto(&DeviceQuoteProvider{Device: d}, input)
+	if err != nil {
+		t.Fatalf("GetQuoteProto(DeviceQuoteProvider, _) = _, %v. Want nil", err)
+	}
+	chain := attestation.GetCertificateChain()
+	for name, pair := range map[string][2][]byte{
+		"VCEK": {chain.GetVcekCert(), d.Signer.Vcek.Raw},
+		"ASK":  {chain.GetAskCert(), d.Signer.Ask.Raw},
+		"ARK":  {chain.GetArkCert(), d.Signer.Ark.Raw},
+	} {
+		if !bytes.Equal(pair[0], pair[1]) {
+			t.Errorf("GetQuoteProto(DeviceQuoteProvider, _) %s certificate = %x. Want %x", name, pair[0], pair[1])
+		}
+	}
+	if diff := cmp.Diff(attestation.GetProduct(), d.Product(), protocmp.Transform()); diff != "" {
+		t.Errorf("GetQuoteProto(DeviceQuoteProvider, _) product differs: %s", diff)
+	}
+	if attestation.GetReport() == nil {
+		t.Error("GetQuoteProto(DeviceQuoteProvider, _) has no report")
+	}
+}
+
+func TestGetQuoteProtoFromDeviceNoCerts(t *testing.T) {
+	d, input := certsDevice(t, 0)
+	attestation, err := GetQuoteProto(&DeviceQuoteProvider{Device: d}, input)
+	if err != nil {
+		t.Fatalf("GetQuoteProto(DeviceQuoteProvider without certs, _) = _, %v. Want nil", err)
+	}
+	chain := attestation.GetCertificateChain()
+	if len(chain.GetVcekCert()) != 0 || len(chain.GetAskCert()) != 0 || len(chain.GetArkCert()) != 0 {
+		t.Errorf("GetQuoteProto(DeviceQuoteProvider without certs, _) chain = %v. Want no VCEK, ASK, or ARK", chain)
+	}
+	// Without a VCEK, the verifier needs the platform info to fetch one from the KDS.
+	if _, ok := chain.GetExtras()[abi.ExtraPlatformInfoGUID]; !ok {
+		t.Errorf("GetQuoteProto(DeviceQuoteProvider without certs, _) extras = %v. Want platform info", chain.GetExtras())
+	}
+}
+
+// echoDevice answers report requests with a test report over the requested report data, or over
+// fixed data if it is set.
+type echoDevice struct {
+	*test.Device
+	fixed *[64]byte
+}
+
+func (d *echoDevice) Ioctl(command uintptr, req any) (uintptr, error) {
+	if greq, ok := req.(*labi.SnpUserGuestRequest); ok {
+		var reportData [64]byte
+		switch r := greq.ReqData.(type) {
+		case *labi.SnpReportReqABI:
+			reportData = r.ReportData
+		case *labi.SnpExtendedReportReq:
+			reportData = r.Data.ReportData
+		}
+		respData := reportData
+		if d.fixed != nil {
+			respData = *d.fixed
+		}
+		d.ReportDataRsp[hex.EncodeToString(reportData[:])] = &test.GetReportResponse{
+			Resp: labi.SnpReportRespABI{Data: test.TestRawReport(respData)},
+		}
+	}
+	return d.Device.Ioctl(command, req)
+}
+
+func TestGetQuoteWithNonce(t *testing.T) {
+	base, _ := throttledDevice(t, 0)
+	d := &echoDevice{Device: base}
+	nonce, attestation, err := GetQuoteWithNonce(d)
+	if err != nil {
+		t.Fatalf("GetQuoteWithNonce(d) = _, _, %v. Want nil", err)
+	}
+	if nonce == ([64]byte{}) {
+		t.Error("GetQuoteWithNonce(d) nonce is zero")
+	}
+	if !bytes.Equal(attestation.GetReport().GetReportData(), nonce[:]) {
+		t.Errorf("GetQuoteWithNonce(d) report_data = %x. Want nonce %x", attestation.GetReport().GetReportData(), nonce)
+	}
+	if again, _, err := GetQuoteWithNonce(d); err != nil || again == nonce {
+		t.Errorf("GetQuoteWithNonce(d) again = %x, _, %v. Want a new nonce", again, err)
+	}
+}
+
+func TestGetQuoteWithNonceErrors(t *testing.T) {
+	base, _ := throttledDevice(t, 0)
+	stale := &echoDevice{Device: base, fixed: &[64]byte{}}
+	if _, _, err := GetQuoteWithNonce(stale); err == nil || !strings.Contains(err.Error(), "is not the requested nonce") {
+		t.Errorf("GetQuoteWithNonce(stale report) = _, _, %v. Want nonce mismatch error", err)
+	}
+
+	old := nonceReader
+	defer func() { nonceReader = old }()
+	nonceReader = iotest.ErrReader(errors.New("no entropy"))
+	d := &noIoctlDevice{}
+	if _, _, err := GetQuoteWithNonce(d); err == nil || !strings.Contains(err.Error(), "no entropy") {
+		t.Errorf("GetQuoteWithNonce(d) with failing randomness = _, _, %v. Want the read error", err)
+	}
+	if d.ioctls != 0 {
+		t.Errorf("GetQuoteWithNonce(d) with failing randomness issued %d ioctls. Want 0", d.ioctls)
+	}
+}
+
+func TestGetRawReportInto(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	want, err := GetRawReport(d, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefix")
+	dst := make([]byte, len(prefix), len(prefix)+abi.ReportSize)
+	copy(dst, prefix)
+	got, err := GetRawReportInto(dst, d, input, 0)
+	if err != nil {
+		t.Fatalf("GetRawReportInto(dst, d, _, 0) = _, %v. Want nil", err)
+	}
+	if &got[0] != &dst[0] {
+		t.Error("GetRawReportInto(dst, d, _, 0) did not append into dst's spare capacity")
+	}
+	if !bytes.Equal(got[:len(prefix)], prefix) || !bytes.Equal(abi.SignedComponent(got[len(prefix):]), abi.SignedComponent(want)) {
+		t.Errorf("GetRawReportInto(dst, d, _, 0) = %x. Want %q followed by the report", got, prefix)
+	}
+	if _, err := GetRawReportInto(nil, d, input, 4); err == nil {
+		t.Error("GetRawReportInto(nil, d, _, 4) = _, nil. Want vmpl error")
+	}
+}
+
+func BenchmarkGetReport(b *testing.B) {
+	throttleMu.Do(initThrottleDevice)
+	d, input := throttleDevice, throttleInput
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetReport(d, input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetRawReportInto(b *testing.B) {
+	throttleMu.Do(initThrottleDevice)
+	d, input := throttleDevice, throttleInput
+	dst := make([]byte, 0, abi.ReportSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetRawReportInto(dst, d, input, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetRawExtendedReport(b *testing.B) {
+	throttleMu.Do(initThrottleDevice)
+	d, input := throttleDevice, throttleInput
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := GetRawExtendedReport(d, input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !windows
+
+package client
+
+import (
+	"fmt"
+	"runtime"
+
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+// errUnsupported wraps ErrUnsupportedPlatform for every operation on this operating system.
+var errUnsupported = fmt.Errorf("%w: running on %s", ErrUnsupportedPlatform, runtime.GOOS)
+
+// UnsupportedDevice implements the Device interface on operating systems without an SEV guest
+// device. Every command fails with ErrUnsupportedPlatform.
+type UnsupportedDevice struct{}
+
+// Open is not supported on this operating system.
+func (*UnsupportedDevice) Open(_ string) error {
+	return errUnsupported
+}
+
+// OpenDevice fails on this operating system.
+func OpenDevice(_ ...DeviceOption) (*UnsupportedDevice, error) {
+	return nil, errUnsupported
+}
+
+// Close is not supported on this operating system.
+func (*UnsupportedDevice) Close() error {
+	return errUnsupported
+}
+
+// Ioctl is not supported on this operating system.
+func (*UnsupportedDevice) Ioctl(_ uintptr, _ any) (uintptr, error) {
+	return 0, errUnsupported
+}
+
+// Product is not supported on this operating system.
+func (*UnsupportedDevice) Product() *spb.SevProduct {
+	return &spb.SevProduct{}
+}
+
+// UnsupportedQuoteProvider implements the QuoteProvider interface on operating systems without
+// configfs-tsm. Every quote fails with ErrUnsupportedPlatform.
+type UnsupportedQuoteProvider struct{}
+
+// IsSupported returns false.
+func (*UnsupportedQuoteProvider) IsSupported() bool {
+	return false
+}
+
+// GetRawQuote is not supported on this operating system.
+func (*UnsupportedQuoteProvider) GetRawQuote(_ [64]byte) ([]byte, error) {
+	return nil, errUnsupported
+}
+
+// GetRawQuoteAtLevel is not supported on this operating system.
+func (*UnsupportedQuoteProvider) GetRawQuoteAtLevel(_ [64]byte, _ uint) ([]byte, error) {
+	return nil, errUnsupported
+}
+
+// Product is not supported on this operating system.
+//
+// Deprecated: Use abi.ExtraPlatformInfoGUID in the raw quote certificate table.
+func (*UnsupportedQuoteProvider) Product() *spb.SevProduct {
+	return &spb.SevProduct{}
+}
+
+// GetQuoteProvider fails on this operating system.
+func GetQuoteProvider() (QuoteProvider, error) {
+	return nil, errUnsupported
+}
+
+// GetLeveledQuoteProvider fails on this operating system.
+func GetLeveledQuoteProvider() (LeveledQuoteProvider, error) {
+	return nil, errUnsupported
+}
@@ -22,28 +22,31 @@ import (
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
 )
 
+// errWindows wraps ErrUnsupportedPlatform for every operation on Windows.
+var errWindows = fmt.Errorf("%w: running on Windows", ErrUnsupportedPlatform)
+
 // WindowsDevice implements the Device interface with Linux ioctls.
 type WindowsDevice struct{}
 
 // Open is not supported on Windows.
 func (*WindowsDevice) Open(_ string) error {
-	return fmt.Errorf("Windows is unsupported")
+	return errWindows
 }
 
 // OpenDevice fails on Windows.
-func OpenDevice() (*WindowsDevice, error) {
-	return nil, fmt.Errorf("Windows is unsupported")
+func OpenDevice(_ ...DeviceOption) (*WindowsDevice, error) {
+	return nil, errWindows
 }
 
 // Close is not supported on Windows.
 func (*WindowsDevice) Close() error {
-	return fmt.Errorf("Windows is unsupported")
+	return errWindows
 }
 
 // Ioctl is not supported on Windows.
 func (*WindowsDevice) Ioctl(_ uintptr, _ any) (uintptr, error) {
 	// The GuestAttestation library on Windows is closed source.
-	return 0, fmt.Errorf("Windows is unsupported")
+	return 0, errWindows
 }
 
 // Product is not supported on Windows.
@@ -61,20 +64,27 @@ func (*WindowsQuoteProvider) IsSupported() bool {
 
 // GetRawQuote returns byte format attestation plus certificate table via ConfigFS.
 func (*WindowsQuoteProvider) GetRawQuote(reportData [64]byte) ([]byte, error) {
-	return nil, fmt.Errorf("Windows is unsupported")
+	return nil, errWindows
 }
 
 // GetRawQuoteAtLevel returns byte format attestation plus certificate table via ConfigFS.
 func (*WindowsQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]byte, error) {
-	return nil, fmt.Errorf("Windows is unsupported")
+	return nil, errWindows
+}
+
+// Product is not supported on Windows.
+//
+// Deprecated: Use abi.ExtraPlatformInfoGUID in the raw quote certificate table.
+func (*WindowsQuoteProvider) Product() *spb.SevProduct {
+	return &spb.SevProduct{}
 }
 
 // GetQuoteProvider returns a supported SEV-SNP QuoteProvider.
 func GetQuoteProvider() (QuoteProvider, error) {
-	return nil, fmt.Errorf("Windows is unsupported")
+	return nil, errWindows
 }
 
 // GetLeveledQuoteProvider returns a supported SEV-SNP LeveledQuoteProvider.
 func GetLeveledQuoteProvider() (LeveledQuoteProvider, error) {
-	return nil, fmt.Errorf("Windows is unsupported")
+	return nil, errWindows
 }
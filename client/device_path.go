@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SevGuestDevicePathEnv is the environment variable that OpenDevice reads the SEV guest device path
+// from when neither WithDevicePath nor --sev_guest_device_path gives one, e.g., for a container
+// that bind-mounts the device elsewhere.
+const SevGuestDevicePathEnv = "SEV_GUEST_DEVICE_PATH"
+
+// DeviceOption configures how OpenDevice finds the SEV guest device and the defaults for the
+// commands sent to it.
+type DeviceOption func(*deviceOptions)
+
+type deviceOptions struct {
+	// path is the explicit device path, if any.
+	path string
+	// commands is the Options for the device's commands when a call passes none. Nil if no option
+	// sets any.
+	commands *Options
+	// getenv reads the environment.
+	getenv func(string) string
+	// open opens the device file at a path and returns its file descriptor. It is replaced in tests
+	// to fake the filesystem.
+	open func(path string) (int, error)
+	// throttleDuration and throttleBurst rate-limit the device's commands to throttleBurst commands
+	// per throttleDuration. Unless throttleSet, they are defaulted when the device is opened.
+	throttleDuration time.Duration
+	throttleBurst    int
+	throttleSet      bool
+}
+
+// WithDevicePath makes OpenDevice open the SEV guest device at path without probing other paths.
+func WithDevicePath(path string) DeviceOption {
+	return func(o *deviceOptions) { o.path = path }
+}
+
+// WithRetryPolicy makes the device's commands resend throttled requests by policy unless a call
+// passes its own Options.
+func WithRetryPolicy(policy *RetryPolicy) DeviceOption {
+	return func(o *deviceOptions) { o.commandOptions().Retry = policy }
+}
+
+// WithLogger makes the device trace its commands to log unless a call passes its own Options.
+func WithLogger(log Logger) DeviceOption {
+	return func(o *deviceOptions) { o.commandOptions().Logger = log }
+}
+
+// WithSelfThrottle makes the device wait between its commands so that it sends at most burst
+// commands per duration, instead of the rate that --self_throttle_duration and
+// --self_throttle_burst give. A burst below 1 is 1, and a zero duration disables the self-throttle.
+func WithSelfThrottle(duration time.Duration, burst int) DeviceOption {
+	return func(o *deviceOptions) {
+		o.throttleDuration, o.throttleBurst, o.throttleSet = duration, burst, true
+	}
+}
+
+func (o *deviceOptions) commandOptions() *Options {
+	if o.commands == nil {
+		o.commands = new(Options)
+	}
+	return o.commands
+}
+
+func newDeviceOptions(opts []DeviceOption) *deviceOptions {
+	result := &deviceOptions{getenv: os.Getenv}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// candidatePaths returns the device paths to try in order. An explicit path, then a non-default
+// --sev_guest_device_path, is the only candidate. Otherwise the path from SevGuestDevicePathEnv, if
+// set, is tried before defaultPath.
+func (o *deviceOptions) candidatePaths(defaultPath string) []string {
+	if o.path != "" {
+		return []string{o.path}
+	}
+	if !UseDefaultSevGuest() {
+		return []string{*sevGuestPath}
+	}
+	if envPath := o.getenv(SevGuestDevicePathEnv); envPath != "" && envPath != defaultPath {
+		return []string{envPath, defaultPath}
+	}
+	return []string{defaultPath}
+}
+
+// describeOpenErr distinguishes a missing device from one the caller may not open.
+func describeOpenErr(path string, err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Sprintf("%s: not found", path)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Sprintf("%s: permission denied", path)
+	}
+	return fmt.Sprintf("%s: %v", path, err)
+}
+
+// openFirst returns the file descriptor of the first candidate path that opens, or an error that
+// describes why each path failed.
+func (o *deviceOptions) openFirst(defaultPath string) (int, error) {
+	var failures []string
+	for _, path := range o.candidatePaths(defaultPath) {
+		fd, err := o.open(path)
+		if err == nil {
+			return fd, nil
+		}
+		failures = append(failures, describeOpenErr(path, err))
+	}
+	return -1, fmt.Errorf("tried %s", strings.Join(failures, "; "))
+}
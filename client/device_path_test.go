@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeDeviceFiles returns device options whose filesystem has the given open results by path and
+// whose environment has the given device path. Paths without a result do not exist.
+func fakeDeviceFiles(files map[string]error, envPath string, opts ...DeviceOption) (*deviceOptions, *[]string) {
+	var opened []string
+	o := newDeviceOptions(opts)
+	o.getenv = func(key string) string {
+		if key == SevGuestDevicePathEnv {
+			return envPath
+		}
+		return ""
+	}
+	o.open = func(path string) (int, error) {
+		opened = append(opened, path)
+		err, ok := files[path]
+		if !ok {
+			return -1, fs.ErrNotExist
+		}
+		if err != nil {
+			return -1, err
+		}
+		return 3, nil
+	}
+	return o, &opened
+}
+
+func TestDeviceCandidatePaths(t *testing.T) {
+	if !UseDefaultSevGuest() {
+		t.Skip("--sev_guest_device_path overrides the candidates")
+	}
+	tcs := []struct {
+		name    string
+		envPath string
+		opts    []DeviceOption
+		want    []string
+	}{
+		{name: "default", want: []string{"/dev/sev-guest"}},
+		{name: "env", envPath: "/mnt/sev-guest", want: []string{"/mnt/sev-guest", "/dev/sev-guest"}},
+		{name: "env is default", envPath: "/dev/sev-guest", want: []string{"/dev/sev-guest"}},
+		{name: "explicit", envPath: "/mnt/sev-guest", opts: []DeviceOption{WithDevicePath("/dev/sev")}, want: []string{"/dev/sev"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			o, _ := fakeDeviceFiles(nil, tc.envPath, tc.opts...)
+			if diff := cmp.Diff(o.candidatePaths("/dev/sev-guest"), tc.want); diff != "" {
+				t.Errorf("candidatePaths() differs: %s", diff)
+			}
+		})
+	}
+}
+
+func TestDeviceOpenFirst(t *testing.T) {
+	if !UseDefaultSevGuest() {
+		t.Skip("--sev_guest_device_path overrides the candidates")
+	}
+	o, opened := fakeDeviceFiles(map[string]error{"/dev/sev-guest": nil}, "/mnt/sev-guest")
+	if fd, err := o.openFirst("/dev/sev-guest"); err != nil || fd != 3 {
+		t.Errorf("openFirst() = %d, %v. Want 3, nil", fd, err)
+	}
+	if diff := cmp.Diff(*opened, []string{"/mnt/sev-guest", "/dev/sev-guest"}); diff != "" {
+		t.Errorf("openFirst() opened paths differ: %s", diff)
+	}
+
+	o, _ = fakeDeviceFiles(map[string]error{"/dev/sev-guest": fs.ErrPermission}, "/mnt/sev-guest")
+	_, err := o.openFirst("/dev/sev-guest")
+	want := "tried /mnt/sev-guest: not found; /dev/sev-guest: permission denied"
+	if err == nil || err.Error() != want {
+		t.Errorf("openFirst() = _, %v. Want %q", err, want)
+	}
+
+	o, _ = fakeDeviceFiles(map[string]error{"/dev/sev": errors.New("busy")}, "", WithDevicePath("/dev/sev"))
+	if _, err := o.openFirst("/dev/sev-guest"); err == nil || err.Error() != "tried /dev/sev: busy" {
+		t.Errorf("openFirst() = _, %v. Want \"tried /dev/sev: busy\"", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// FallbackQuoteProvider implements QuoteProvider and LeveledQuoteProvider with the first of its
+// Providers that returns a quote. Once a provider has returned a quote, later quotes only use that
+// provider, and its errors are returned as they are. Until then, each quote tries the providers in
+// order, and fails with every provider's reason if none returns a quote. It is safe for concurrent
+// use if its providers are.
+type FallbackQuoteProvider struct {
+	// Providers are the backends to try, in order of preference. Backends that do not implement
+	// LeveledQuoteProvider fail GetRawQuoteAtLevel.
+	Providers []QuoteProvider
+
+	mu sync.Mutex
+	// chosen is the provider that returned the first quote, if any.
+	chosen QuoteProvider
+}
+
+// IsSupported returns whether the chosen provider, or until one is chosen, any provider is
+// supported.
+func (p *FallbackQuoteProvider) IsSupported() bool {
+	if chosen := p.chosenProvider(); chosen != nil {
+		return chosen.IsSupported()
+	}
+	for _, qp := range p.Providers {
+		if qp.IsSupported() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *FallbackQuoteProvider) chosenProvider() QuoteProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.chosen
+}
+
+// quote returns the quote of the chosen provider, or else of the first provider that returns one,
+// which then becomes the chosen provider.
+func (p *FallbackQuoteProvider) quote(ctx context.Context, get func(QuoteProvider) ([]uint8, error)) ([]uint8, error) {
+	if chosen := p.chosenProvider(); chosen != nil {
+		return get(chosen)
+	}
+	var errs []error
+	for _, qp := range p.Providers {
+		if !qp.IsSupported() {
+			errs = append(errs, fmt.Errorf("%T: not supported", qp))
+			continue
+		}
+		quote, err := get(qp)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", qp, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		p.mu.Lock()
+		if p.chosen == nil {
+			p.chosen = qp
+		}
+		p.mu.Unlock()
+		return quote, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no quote providers to try")
+	}
+	return nil, fmt.Errorf("no quote provider returned a quote: %w", multierr.Combine(errs...))
+}
+
+// GetRawQuote returns byte format attestation plus certificate table from the first provider that
+// returns one.
+func (p *FallbackQuoteProvider) GetRawQuote(reportData [64]byte) ([]uint8, error) {
+	return p.quote(context.Background(), func(qp QuoteProvider) ([]uint8, error) {
+		return qp.GetRawQuote(reportData)
+	})
+}
+
+// GetRawQuoteContext is like GetRawQuote, but abandons the quote if ctx is done before it is
+// complete. Providers that do not implement ContextQuoteProvider are only checked before they are
+// asked for a quote.
+func (p *FallbackQuoteProvider) GetRawQuoteContext(ctx context.Context, reportData [64]byte) ([]uint8, error) {
+	return p.quote(ctx, func(qp QuoteProvider) ([]uint8, error) {
+		if cqp, ok := qp.(ContextQuoteProvider); ok {
+			return cqp.GetRawQuoteContext(ctx, reportData)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("quote request abandoned before it was sent: %w", err)
+		}
+		return qp.GetRawQuote(reportData)
+	})
+}
+
+// GetRawQuoteAtLevel returns byte format attestation plus certificate table at the given privilege
+// level from the first provider that returns one.
+func (p *FallbackQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]uint8, error) {
+	return p.quote(context.Background(), func(qp QuoteProvider) ([]uint8, error) {
+		lqp, ok := qp.(LeveledQuoteProvider)
+		if !ok {
+			return nil, fmt.Errorf("privilege levels are not supported")
+		}
+		return lqp.GetRawQuoteAtLevel(reportData, level)
+	})
+}
+
+func (p *FallbackQuoteProvider) kdsOptions() *Options {
+	return quoteProviderOptions(p.chosenProvider())
+}
+
+// Product returns the chosen provider's AMD SEV product information, or the first provider's
+// until one is chosen.
+//
+// Deprecated: Use abi.ExtraPlatformInfoGUID in the raw quote certificate table.
+func (p *FallbackQuoteProvider) Product() *pb.SevProduct {
+	if chosen := p.chosenProvider(); chosen != nil {
+		return chosen.Product()
+	}
+	if len(p.Providers) == 0 {
+		return nil
+	}
+	return p.Providers[0].Product()
+}
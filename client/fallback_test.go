@@ -0,0 +1,142 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	test "github.com/google/go-sev-guest/testing"
+)
+
+// countingProvider counts the quotes it is asked for.
+type countingProvider struct {
+	QuoteProvider
+	quotes int
+}
+
+func (p *countingProvider) GetRawQuote(reportData [64]byte) ([]uint8, error) {
+	p.quotes++
+	return p.QuoteProvider.GetRawQuote(reportData)
+}
+
+// brokenProvider is a backend that is unsupported, or else fails every quote with err.
+type brokenProvider struct {
+	supported bool
+	err       error
+}
+
+func (p *brokenProvider) IsSupported() bool                     { return p.supported }
+func (p *brokenProvider) GetRawQuote([64]byte) ([]uint8, error) { return nil, p.err }
+func (p *brokenProvider) Product() *spb.SevProduct              { return nil }
+func (p *brokenProvider) GetRawQuoteAtLevel([64]byte, uint) ([]uint8, error) {
+	return nil, p.err
+}
+
+func TestFallbackQuoteProvider(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	errBroken := errors.New("broken backend")
+	tsm := func() QuoteProvider { return &test.QuoteProvider{Device: d} }
+	ioctl := func() QuoteProvider { return &DeviceQuoteProvider{Device: d} }
+	unsupported := func() QuoteProvider { return &brokenProvider{} }
+	broken := func() QuoteProvider { return &brokenProvider{supported: true, err: errBroken} }
+	tcs := []struct {
+		name      string
+		providers []func() QuoteProvider
+		// want is the index of the provider that serves the quotes.
+		want int
+	}{
+		{name: "configfs-tsm then ioctl", providers: []func() QuoteProvider{tsm, ioctl}, want: 0},
+		{name: "ioctl then configfs-tsm", providers: []func() QuoteProvider{ioctl, tsm}, want: 0},
+		{name: "unsupported configfs-tsm", providers: []func() QuoteProvider{unsupported, ioctl}, want: 1},
+		{name: "unsupported ioctl", providers: []func() QuoteProvider{unsupported, tsm}, want: 1},
+		{name: "failing configfs-tsm", providers: []func() QuoteProvider{broken, ioctl}, want: 1},
+		{name: "failing ioctl", providers: []func() QuoteProvider{broken, tsm}, want: 1},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var counters []*countingProvider
+			qp := &FallbackQuoteProvider{}
+			for _, makeProvider := range tc.providers {
+				counter := &countingProvider{QuoteProvider: makeProvider()}
+				counters = append(counters, counter)
+				qp.Providers = append(qp.Providers, counter)
+			}
+			if !qp.IsSupported() {
+				t.Fatal("IsSupported() = false. Want true")
+			}
+			for i := 0; i < 2; i++ {
+				attestation, err := GetQuoteProto(qp, input)
+				if err != nil {
+					t.Fatalf("GetQuoteProto(fallback, _) = _, %v. Want nil", err)
+				}
+				if attestation.GetReport() == nil {
+					t.Error("GetQuoteProto(fallback, _) has no report")
+				}
+			}
+			for i, counter := range counters {
+				want := 0
+				switch {
+				case i == tc.want:
+					want = 2
+				case i < tc.want && counter.IsSupported():
+					// A failing backend is only probed by the first quote.
+					want = 1
+				}
+				if counter.quotes != want {
+					t.Errorf("provider %d was asked for %d quotes. Want %d", i, counter.quotes, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackQuoteProviderNoneWork(t *testing.T) {
+	errBroken := errors.New("broken backend")
+	qp := &FallbackQuoteProvider{Providers: []QuoteProvider{
+		&brokenProvider{},
+		&brokenProvider{supported: true, err: errBroken},
+	}}
+	if !qp.IsSupported() {
+		t.Error("IsSupported() with a supported backend = false. Want true")
+	}
+	_, err := qp.GetRawQuote([64]byte{})
+	if !errors.Is(err, errBroken) {
+		t.Fatalf("GetRawQuote() = _, %v. Want %v", err, errBroken)
+	}
+	if !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("GetRawQuote() = _, %v. Want every backend's reason", err)
+	}
+	if got := qp.chosenProvider(); got != nil {
+		t.Errorf("chosen provider after failing quotes = %v. Want none", got)
+	}
+	if (&FallbackQuoteProvider{Providers: []QuoteProvider{&brokenProvider{}}}).IsSupported() {
+		t.Error("IsSupported() without a supported backend = true. Want false")
+	}
+}
+
+func TestFallbackQuoteProviderAtLevel(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	// The configfs-tsm mock has no privilege levels, so the quote falls back to the device.
+	qp := &FallbackQuoteProvider{Providers: []QuoteProvider{&test.QuoteProvider{Device: d}, &DeviceQuoteProvider{Device: d}}}
+	if _, err := GetQuoteProtoAtLevel(qp, input, 0); err != nil {
+		t.Fatalf("GetQuoteProtoAtLevel(fallback, _, 0) = _, %v. Want nil", err)
+	}
+	if _, ok := qp.chosenProvider().(*DeviceQuoteProvider); !ok {
+		t.Errorf("chosen provider = %T. Want *DeviceQuoteProvider", qp.chosenProvider())
+	}
+}
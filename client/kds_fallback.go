@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/pkg/errors"
+)
+
+// kdsFallbackWarning is why the AMD KDS cannot serve the VCEK for a report whose host supplied no
+// VCEK certificate, e.g., because the host masks the CHIP_ID (kds.ErrChipIDMasked). The
+// attestation is still usable, with its chain left as the host supplied it.
+type kdsFallbackWarning struct {
+	err error
+}
+
+func (w *kdsFallbackWarning) Error() string {
+	return fmt.Sprintf("the host supplied no VCEK certificate and the KDS cannot serve one: %v", w.err)
+}
+
+func (w *kdsFallbackWarning) Unwrap() error {
+	return w.err
+}
+
+func (o *Options) kdsGetter() kds.Getter {
+	if o == nil {
+		return nil
+	}
+	return o.KDSGetter
+}
+
+// kdsFallbackProvider is a QuoteProvider whose Options may name a KDSGetter.
+type kdsFallbackProvider interface {
+	kdsOptions() *Options
+}
+
+// quoteProviderOptions returns the Options of a QuoteProvider or LeveledQuoteProvider that configure
+// the KDS fallback, or nil if it has none.
+func quoteProviderOptions(qp any) *Options {
+	if kp, ok := qp.(kdsFallbackProvider); ok {
+		return kp.kdsOptions()
+	}
+	return nil
+}
+
+// applyKDSFallback fills in the certificates that the host did not supply from the KDS if opts
+// names a KDSGetter. If the KDS cannot serve the VCEK for the report, the attestation is kept as
+// the host supplied it, and the reason is a warning to opts' Logger, if any.
+func applyKDSFallback(ctx context.Context, attestation *pb.Attestation, opts *Options) error {
+	getter := opts.kdsGetter()
+	if getter == nil {
+		return nil
+	}
+	err := fillCertsFromKDS(ctx, attestation, getter)
+	var warning *kdsFallbackWarning
+	if errors.As(err, &warning) {
+		if log := opts.logger(); log != nil {
+			log.Warnf("KDS fallback: %v", warning)
+		}
+		return nil
+	}
+	return err
+}
+
+// attestationProduct returns the product that produced the attestation, from its Product field or
+// else the platform info in its certificate chain.
+func attestationProduct(attestation *pb.Attestation) (*pb.SevProduct, error) {
+	if product := attestation.GetProduct(); product != nil {
+		return product, nil
+	}
+	blob, ok := attestation.GetCertificateChain().GetExtras()[abi.ExtraPlatformInfoGUID]
+	if !ok {
+		return nil, errors.New("attestation has neither a product nor platform info")
+	}
+	info, err := abi.ParseExtraPlatformInfo(blob)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the platform info: %v", err)
+	}
+	return abi.SevProductFromCpuid1Eax(info.Cpuid1Eax), nil
+}
+
+// fillCertsFromKDS downloads the VCEK, ASK, and ARK for a VCEK-signed attestation whose host
+// supplied no VCEK certificate. Certificates that the host supplied are kept. Reports signed by a
+// VLEK are left alone, since only the host can supply a VLEK certificate. A download that fails
+// returns an error wrapping a *kds.FetchErr or *kds.MalformedErr.
+func fillCertsFromKDS(ctx context.Context, attestation *pb.Attestation, getter kds.Getter) error {
+	if attestation.CertificateChain == nil {
+		attestation.CertificateChain = &pb.CertificateChain{}
+	}
+	chain := attestation.CertificateChain
+	if len(chain.GetVcekCert()) != 0 || len(chain.GetVlekCert()) != 0 {
+		return nil
+	}
+	report := attestation.GetReport()
+	info, err := abi.ParseSignerInfo(report.GetSignerInfo())
+	if err != nil {
+		return err
+	}
+	if info.SigningKey != abi.VcekReportSigner {
+		return nil
+	}
+	product, err := attestationProduct(attestation)
+	if err != nil {
+		return fmt.Errorf("could not determine the product line to fetch the VCEK for: %v", err)
+	}
+	productLine, err := kds.ProductOf(product)
+	if err != nil {
+		return fmt.Errorf("could not determine the product line to fetch the VCEK for: %v", err)
+	}
+	vcekURL, err := kds.VCEKCertURLForChipID(productLine, report.GetChipId(), kds.TCBVersion(report.GetReportedTcb()))
+	if errors.Is(err, kds.ErrChipIDMasked) {
+		return &kdsFallbackWarning{err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("could not determine VCEK certificate URL: %w", err)
+	}
+	vcek, err := kds.GetCertContext(ctx, getter, vcekURL)
+	if err != nil {
+		return fmt.Errorf("could not download VCEK certificate: %w", err)
+	}
+	if len(chain.GetAskCert()) == 0 || len(chain.GetArkCert()) == 0 {
+		ask, ark, err := kds.GetProductChainContext(ctx, getter, abi.VcekReportSigner, productLine)
+		if err != nil {
+			return fmt.Errorf("could not download ASK and ARK certificates: %w", err)
+		}
+		if len(chain.GetAskCert()) == 0 {
+			chain.AskCert = ask.Raw
+		}
+		if len(chain.GetArkCert()) == 0 {
+			chain.ArkCert = ark.Raw
+		}
+	}
+	chain.VcekCert = vcek.Raw
+	return nil
+}
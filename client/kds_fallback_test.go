@@ -0,0 +1,174 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-sev-guest/abi"
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	"github.com/google/go-sev-guest/kds"
+	test "github.com/google/go-sev-guest/testing"
+)
+
+// chipIDDevice overwrites the CHIP_ID of the reports it returns. The report signatures no longer
+// verify, which the client does not check.
+type chipIDDevice struct {
+	*test.Device
+	chipID [64]byte
+}
+
+func (d *chipIDDevice) Ioctl(command uintptr, req any) (uintptr, error) {
+	result, err := d.Device.Ioctl(command, req)
+	if rsp, ok := req.(*labi.SnpUserGuestRequest).RespData.(*labi.SnpReportRespABI); ok && err == nil {
+		copy(rsp.Data[0x1A0:0x1E0], d.chipID[:])
+	}
+	return result, err
+}
+
+// fakeKDS serves a device's signer certificates for any VCEK and the Milan product chain, and
+// records the URLs it is asked for.
+type fakeKDS struct {
+	d    *test.Device
+	urls []string
+}
+
+func (k *fakeKDS) Get(url string) ([]byte, error) {
+	k.urls = append(k.urls, url)
+	if url == kds.ProductCertChainURL(abi.VcekReportSigner, "Milan") {
+		chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.d.Signer.Ask.Raw})
+		return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.d.Signer.Ark.Raw})...), nil
+	}
+	if _, err := kds.ParseVCEKCertURL(url); err == nil {
+		return k.d.Signer.Vcek.Raw, nil
+	}
+	return nil, fmt.Errorf("404: %s", url)
+}
+
+func kdsFallbackDevice(t *testing.T) (*chipIDDevice, [64]byte, *fakeKDS) {
+	t.Helper()
+	base, input := certsDevice(t, 0)
+	d := &chipIDDevice{Device: base}
+	for i := range d.chipID {
+		d.chipID[i] = byte(i + 1)
+	}
+	return d, input, &fakeKDS{d: base}
+}
+
+func TestExtendedReportKDSFallback(t *testing.T) {
+	d, input, getter := kdsFallbackDevice(t)
+	attestation, err := GetExtendedReportWithOptions(context.Background(), d, input, &Options{KDSGetter: getter})
+	if err != nil {
+		t.Fatalf("GetExtendedReportWithOptions(_, no certs, _, KDS fallback) = _, %v. Want nil", err)
+	}
+	chain := attestation.GetCertificateChain()
+	for name, pair := range map[string][2][]byte{
+		"VCEK": {chain.GetVcekCert(), d.Signer.Vcek.Raw},
+		"ASK":  {chain.GetAskCert(), d.Signer.Ask.Raw},
+		"ARK":  {chain.GetArkCert(), d.Signer.Ark.Raw},
+	} {
+		if !bytes.Equal(pair[0], pair[1]) {
+			t.Errorf("KDS fallback %s certificate = %x. Want %x", name, pair[0], pair[1])
+		}
+	}
+	report := attestation.GetReport()
+	wantURL := kds.VCEKCertURL("Milan", report.GetChipId(), kds.TCBVersion(report.GetReportedTcb()))
+	if len(getter.urls) == 0 || getter.urls[0] != wantURL {
+		t.Errorf("KDS fallback fetched %q. Want %q first", getter.urls, wantURL)
+	}
+}
+
+func TestGetQuoteProtoKDSFallback(t *testing.T) {
+	d, input, getter := kdsFallbackDevice(t)
+	qp := &DeviceQuoteProvider{Device: d, Options: &Options{KDSGetter: getter}}
+	attestation, err := GetQuoteProto(qp, input)
+	if err != nil {
+		t.Fatalf("GetQuoteProto(no certs, KDS fallback) = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(attestation.GetCertificateChain().GetVcekCert(), d.Signer.Vcek.Raw) {
+		t.Error("GetQuoteProto(no certs, KDS fallback) has no VCEK from the KDS")
+	}
+}
+
+func TestGetQuoteProtoAtLevelKDSFallback(t *testing.T) {
+	d, input, getter := kdsFallbackDevice(t)
+	qp := &DeviceQuoteProvider{Device: d, Options: &Options{KDSGetter: getter}}
+	attestation, err := GetQuoteProtoAtLevel(qp, input, 0)
+	if err != nil {
+		t.Fatalf("GetQuoteProtoAtLevel(no certs, KDS fallback, 0) = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(attestation.GetCertificateChain().GetVcekCert(), d.Signer.Vcek.Raw) {
+		t.Error("GetQuoteProtoAtLevel(no certs, KDS fallback, 0) has no VCEK from the KDS")
+	}
+}
+
+func TestKDSFallbackMaskedChipID(t *testing.T) {
+	d, input, getter := kdsFallbackDevice(t)
+	d.chipID = [64]byte{}
+	log := &recordingLogger{}
+	attestation, err := GetExtendedReportWithOptions(context.Background(), d, input, &Options{KDSGetter: getter, Logger: log})
+	if err != nil {
+		t.Fatalf("GetExtendedReportWithOptions(_, masked CHIP_ID, _, KDS fallback) = _, %v. Want nil", err)
+	}
+	if attestation.GetReport() == nil {
+		t.Error("GetExtendedReportWithOptions(_, masked CHIP_ID, _, KDS fallback) returned no report")
+	}
+	if len(attestation.GetCertificateChain().GetVcekCert()) != 0 {
+		t.Error("GetExtendedReportWithOptions(_, masked CHIP_ID, _, KDS fallback) has a VCEK")
+	}
+	if len(getter.urls) != 0 {
+		t.Errorf("KDS fallback fetched %q for a masked CHIP_ID. Want nothing", getter.urls)
+	}
+	var warned bool
+	for _, line := range log.lines {
+		if strings.HasPrefix(line, "WARN KDS fallback: ") && strings.Contains(line, kds.ErrChipIDMasked.Error()) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("KDS fallback logged %q for a masked CHIP_ID. Want a warning for %v", log.lines, kds.ErrChipIDMasked)
+	}
+}
+
+func TestKDSFallbackHostCerts(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	getter := &fakeKDS{d: d}
+	attestation, err := GetExtendedReportWithOptions(context.Background(), d, input, &Options{KDSGetter: getter})
+	if err != nil {
+		t.Fatalf("GetExtendedReportWithOptions(_, host certs, _, KDS fallback) = _, %v. Want nil", err)
+	}
+	if len(getter.urls) != 0 {
+		t.Errorf("KDS fallback fetched %q with the host's certificates. Want nothing", getter.urls)
+	}
+	if !bytes.Equal(attestation.GetCertificateChain().GetVcekCert(), d.Signer.Vcek.Raw) {
+		t.Error("GetExtendedReportWithOptions(_, host certs, _, KDS fallback) lost the host's VCEK")
+	}
+}
+
+func TestKDSFallbackGetterFails(t *testing.T) {
+	d, input, _ := kdsFallbackDevice(t)
+	getter := test.SimpleGetter(nil)
+	_, err := GetExtendedReportWithOptions(context.Background(), d, input, &Options{KDSGetter: getter})
+	var fetchErr *kds.FetchErr
+	if !errors.As(err, &fetchErr) {
+		t.Errorf("GetExtendedReportWithOptions(_, no certs, _, failing KDS) = _, %v. Want *kds.FetchErr", err)
+	}
+}
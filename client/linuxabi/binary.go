@@ -0,0 +1,128 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linuxabi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// SnpReportReqABISize is the byte size of the sev-guest GET_REPORT request.
+	SnpReportReqABISize = 0x60
+	// SnpReportRespABISize is the byte size of the sev-guest GET_REPORT response.
+	SnpReportRespABISize = snpResportRespSize
+	// SnpDerivedKeyReqABISize is the byte size of the sev-guest GET_DERIVED_KEY request.
+	SnpDerivedKeyReqABISize = 0x20
+	// SnpDerivedKeyRespABISize is the byte size of the sev-guest GET_DERIVED_KEY response.
+	SnpDerivedKeyRespABISize = 0x40
+)
+
+// binaryCodec is implemented by the request and response messages that cross the ioctl boundary
+// as a little-endian byte buffer.
+type binaryCodec interface {
+	BinaryConvertible
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+}
+
+func checkSize(name string, data []byte, size int) error {
+	if len(data) != size {
+		return fmt.Errorf("%s size is %d bytes. Expected %d bytes", name, len(data), size)
+	}
+	return nil
+}
+
+// MarshalBinary returns the little-endian ABI representation of the request.
+func (r *SnpReportReqABI) MarshalBinary() ([]byte, error) {
+	data := make([]byte, SnpReportReqABISize)
+	copy(data[0x00:0x40], r.ReportData[:])
+	binary.LittleEndian.PutUint32(data[0x40:0x44], r.Vmpl)
+	binary.LittleEndian.PutUint32(data[0x44:0x48], r.KeySel)
+	return data, nil
+}
+
+// UnmarshalBinary populates the request from its little-endian ABI representation.
+func (r *SnpReportReqABI) UnmarshalBinary(data []byte) error {
+	if err := checkSize("report request", data, SnpReportReqABISize); err != nil {
+		return err
+	}
+	copy(r.ReportData[:], data[0x00:0x40])
+	r.Vmpl = binary.LittleEndian.Uint32(data[0x40:0x44])
+	r.KeySel = binary.LittleEndian.Uint32(data[0x44:0x48])
+	return nil
+}
+
+// MarshalBinary returns the little-endian ABI representation of the response.
+func (r *SnpReportRespABI) MarshalBinary() ([]byte, error) {
+	data := make([]byte, SnpReportRespABISize)
+	binary.LittleEndian.PutUint32(data[0x00:0x04], r.Status)
+	binary.LittleEndian.PutUint32(data[0x04:0x08], r.ReportSize)
+	copy(data[msgReportReqHeaderSize:], r.Data[:])
+	return data, nil
+}
+
+// UnmarshalBinary populates the response from its little-endian ABI representation.
+func (r *SnpReportRespABI) UnmarshalBinary(data []byte) error {
+	if err := checkSize("report response", data, SnpReportRespABISize); err != nil {
+		return err
+	}
+	r.Status = binary.LittleEndian.Uint32(data[0x00:0x04])
+	r.ReportSize = binary.LittleEndian.Uint32(data[0x04:0x08])
+	copy(r.Data[:], data[msgReportReqHeaderSize:])
+	return nil
+}
+
+// MarshalBinary returns the little-endian ABI representation of the request.
+func (r *SnpDerivedKeyReqABI) MarshalBinary() ([]byte, error) {
+	data := make([]byte, SnpDerivedKeyReqABISize)
+	binary.LittleEndian.PutUint32(data[0x00:0x04], r.RootKeySelect)
+	binary.LittleEndian.PutUint64(data[0x08:0x10], r.GuestFieldSelect)
+	binary.LittleEndian.PutUint32(data[0x10:0x14], r.Vmpl)
+	binary.LittleEndian.PutUint32(data[0x14:0x18], r.GuestSVN)
+	binary.LittleEndian.PutUint64(data[0x18:0x20], r.TCBVersion)
+	return data, nil
+}
+
+// UnmarshalBinary populates the request from its little-endian ABI representation.
+func (r *SnpDerivedKeyReqABI) UnmarshalBinary(data []byte) error {
+	if err := checkSize("derived key request", data, SnpDerivedKeyReqABISize); err != nil {
+		return err
+	}
+	r.RootKeySelect = binary.LittleEndian.Uint32(data[0x00:0x04])
+	r.GuestFieldSelect = binary.LittleEndian.Uint64(data[0x08:0x10])
+	r.Vmpl = binary.LittleEndian.Uint32(data[0x10:0x14])
+	r.GuestSVN = binary.LittleEndian.Uint32(data[0x14:0x18])
+	r.TCBVersion = binary.LittleEndian.Uint64(data[0x18:0x20])
+	return nil
+}
+
+// MarshalBinary returns the little-endian ABI representation of the response.
+func (r *SnpDerivedKeyRespABI) MarshalBinary() ([]byte, error) {
+	data := make([]byte, SnpDerivedKeyRespABISize)
+	binary.LittleEndian.PutUint32(data[0x00:0x04], r.Status)
+	copy(data[0x20:0x40], r.Data[:])
+	return data, nil
+}
+
+// UnmarshalBinary populates the response from its little-endian ABI representation.
+func (r *SnpDerivedKeyRespABI) UnmarshalBinary(data []byte) error {
+	if err := checkSize("derived key response", data, SnpDerivedKeyRespABISize); err != nil {
+		return err
+	}
+	r.Status = binary.LittleEndian.Uint32(data[0x00:0x04])
+	copy(r.Data[:], data[0x20:0x40])
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linuxabi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatalf("test failure: %v", err)
+	}
+	return data
+}
+
+func TestSnpReportReqABIGolden(t *testing.T) {
+	req := &SnpReportReqABI{Vmpl: 0x01020304, KeySel: 2}
+	for i := range req.ReportData {
+		req.ReportData[i] = byte(i)
+	}
+	want := mustDecodeHex(t, `
+		000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+		202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f
+		04030201 02000000 000000000000000000000000000000000000000000000000`)
+	got, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalBinary() = %x, want %x", got, want)
+	}
+	var back SnpReportReqABI
+	if err := back.UnmarshalBinary(want); err != nil {
+		t.Fatalf("UnmarshalBinary() = %v. Want nil", err)
+	}
+	if back != *req {
+		t.Errorf("UnmarshalBinary(%x) = %+v, want %+v", want, back, *req)
+	}
+}
+
+func TestSnpReportRespABIGolden(t *testing.T) {
+	data := make([]byte, SnpReportRespABISize)
+	copy(data, mustDecodeHex(t, "16000000 a0040000"))
+	data[0x20] = 0x02
+	data[SnpReportRespABISize-1] = 0xff
+	var resp SnpReportRespABI
+	if err := resp.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() = %v. Want nil", err)
+	}
+	if resp.Status != 0x16 || resp.ReportSize != 0x4a0 || resp.Data[0] != 0x02 || resp.Data[len(resp.Data)-1] != 0xff {
+		t.Errorf("UnmarshalBinary() = {Status: 0x%x, ReportSize: 0x%x, Data[0]: 0x%x, Data[last]: 0x%x}, want {0x16, 0x4a0, 0x2, 0xff}",
+			resp.Status, resp.ReportSize, resp.Data[0], resp.Data[len(resp.Data)-1])
+	}
+	if err := resp.checkStatus(); err == nil || !strings.Contains(err.Error(), "invalid parameters") {
+		t.Errorf("checkStatus() = %v. Want invalid parameters error", err)
+	}
+	got, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("MarshalBinary(UnmarshalBinary(%x)) = %x. Want identity", data, got)
+	}
+}
+
+func TestSnpDerivedKeyReqABIGolden(t *testing.T) {
+	req := &SnpDerivedKeyReqABI{
+		RootKeySelect:    1,
+		GuestFieldSelect: 0x3f,
+		Vmpl:             2,
+		GuestSVN:         0x11223344,
+		TCBVersion:       0x7306000000000002,
+	}
+	want := mustDecodeHex(t, `
+		01000000 00000000 3f00000000000000
+		02000000 44332211 0200000000000673`)
+	got, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalBinary() = %x, want %x", got, want)
+	}
+	var back SnpDerivedKeyReqABI
+	if err := back.UnmarshalBinary(want); err != nil {
+		t.Fatalf("UnmarshalBinary() = %v. Want nil", err)
+	}
+	if back != *req {
+		t.Errorf("UnmarshalBinary(%x) = %+v, want %+v", want, back, *req)
+	}
+}
+
+func TestSnpDerivedKeyRespABIGolden(t *testing.T) {
+	data := mustDecodeHex(t, `
+		00000000 00000000000000000000000000000000000000000000000000000000
+		000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f`)
+	var resp SnpDerivedKeyRespABI
+	if err := resp.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() = %v. Want nil", err)
+	}
+	for i, b := range resp.Data {
+		if b != byte(i) {
+			t.Fatalf("UnmarshalBinary() Data = %x, want 000102...1f", resp.Data)
+		}
+	}
+	if err := resp.checkStatus(); err != nil {
+		t.Errorf("checkStatus() = %v. Want nil", err)
+	}
+	got, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("MarshalBinary(UnmarshalBinary(%x)) = %x. Want identity", data, got)
+	}
+}
+
+func TestUnmarshalBinarySizes(t *testing.T) {
+	for _, m := range []binaryCodec{
+		&SnpReportReqABI{},
+		&SnpReportRespABI{},
+		&SnpDerivedKeyReqABI{},
+		&SnpDerivedKeyRespABI{},
+	} {
+		if err := m.UnmarshalBinary(make([]byte, 1)); err == nil {
+			t.Errorf("%T.UnmarshalBinary(1 byte) = nil. Want error", m)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package linuxabi
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// The structures in this file cross the ioctl boundary. The kernel declares their addresses as
+// __u64, so they are uint64 here too, which keeps the x86-64 kernel's layout in 32-bit builds.
+// SEV-SNP guests are x86-64, so the little-endian messages they point to match the host.
+
+// snpExtendedReportReqABISize is the size of the x86-64 kernel's struct snp_ext_report_req.
+const snpExtendedReportReqABISize = SnpReportReqABISize + 16
+
+// SnpExtendedReportReqABI is Linux's sev-guest ioctl abi for sending a GET_EXTENDED_REPORT request.
+type SnpExtendedReportReqABI struct {
+	Data [SnpReportReqABISize]byte
+
+	// Where to copy the certificate blob.
+	CertsAddress uint64
+
+	// length of the certificate blob
+	CertsLength uint32
+	_           uint32
+}
+
+// Pointer returns a pointer so the object itself.
+func (r *SnpExtendedReportReqABI) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(r)
+}
+
+// Finish writes back the changed CertsLength value.
+func (r *SnpExtendedReportReqABI) Finish(b BinaryConvertible) error {
+	s, ok := b.(*SnpExtendedReportReq)
+	if !ok {
+		return fmt.Errorf("Finish argument is %v. Expects a *SnpExtendedReportReq", reflect.TypeOf(b))
+	}
+	s.CertsLength = r.CertsLength
+	return nil
+}
+
+// ABI returns an object that can cross the ABI boundary and copy back changes to the original
+// object.
+func (r *SnpExtendedReportReq) ABI() BinaryConversion {
+	// r keeps the certificate buffer alive until Finish.
+	var certsAddress uint64
+	if len(r.Certs) != 0 {
+		certsAddress = uint64(uintptr(unsafe.Pointer(&r.Certs[0])))
+	}
+	result := &SnpExtendedReportReqABI{
+		CertsAddress: certsAddress,
+		CertsLength:  r.CertsLength,
+	}
+	data, _ := r.Data.MarshalBinary()
+	copy(result.Data[:], data)
+	return result
+}
+
+// SnpUserGuestRequestABI is Linux's sev-guest ioctl abi for issuing a guest message.
+type SnpUserGuestRequestABI struct {
+	GuestMsgVersion uint32
+	_               uint32
+	// Request and response structure address.
+	ReqData  uint64
+	RespData uint64
+	// firmware error code on failure (see psp-sev.h in Linux kernel)
+	FwErr uint64
+}
+
+var _ [mainlineGuestRequestSize - unsafe.Sizeof(SnpUserGuestRequestABI{})]byte
+var _ [unsafe.Sizeof(SnpUserGuestRequestABI{}) - mainlineGuestRequestSize]byte
+var _ [snpExtendedReportReqABISize - unsafe.Sizeof(SnpExtendedReportReqABI{})]byte
+var _ [unsafe.Sizeof(SnpExtendedReportReqABI{}) - snpExtendedReportReqABISize]byte
+
+type snpUserGuestRequestConversion struct {
+	abi      SnpUserGuestRequestABI
+	reqConv  BinaryConversion
+	respConv BinaryConversion
+}
+
+// ABI returns an object that can cross the ABI boundary and copy back changes to the original
+// object.
+func (r *SnpUserGuestRequest) ABI() BinaryConversion {
+	result := &snpUserGuestRequestConversion{
+		reqConv:  r.ReqData.ABI(),
+		respConv: r.RespData.ABI(),
+	}
+	result.abi.GuestMsgVersion = guestMsgVersion
+	// The conversions keep the request and response objects alive until Finish.
+	result.abi.ReqData = uint64(uintptr(result.reqConv.Pointer()))
+	result.abi.RespData = uint64(uintptr(result.respConv.Pointer()))
+	return result
+}
+
+// snpUserGuestRequestPatchsetABI is the guest request of ABIRevisionPatchset kernels.
+type snpUserGuestRequestPatchsetABI struct {
+	ReqData  uint64
+	RespData uint64
+	FwErr    uint32
+	_        uint32
+}
+
+var _ [patchsetGuestRequestSize - unsafe.Sizeof(snpUserGuestRequestPatchsetABI{})]byte
+var _ [unsafe.Sizeof(snpUserGuestRequestPatchsetABI{}) - patchsetGuestRequestSize]byte
+
+type snpUserGuestRequestPatchsetConversion struct {
+	abi      snpUserGuestRequestPatchsetABI
+	reqConv  BinaryConversion
+	respConv BinaryConversion
+}
+
+// ABIForRevision is like ABI, but returns the layout of the given revision. An unknown revision
+// has the mainline layout.
+func (r *SnpUserGuestRequest) ABIForRevision(rev ABIRevision) BinaryConversion {
+	if rev != ABIRevisionPatchset {
+		return r.ABI()
+	}
+	result := &snpUserGuestRequestPatchsetConversion{
+		reqConv:  r.ReqData.ABI(),
+		respConv: r.RespData.ABI(),
+	}
+	// The conversions keep the request and response objects alive until Finish.
+	result.abi.ReqData = uint64(uintptr(result.reqConv.Pointer()))
+	result.abi.RespData = uint64(uintptr(result.respConv.Pointer()))
+	return result
+}
+
+// Pointer returns a pointer to the object that crosses the ABI boundary.
+func (r *snpUserGuestRequestPatchsetConversion) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(&r.abi)
+}
+
+// Finish writes back the 32-bit FwErr and any changes to the request or response objects.
+func (r *snpUserGuestRequestPatchsetConversion) Finish(b BinaryConvertible) error {
+	s, ok := b.(*SnpUserGuestRequest)
+	if !ok {
+		return fmt.Errorf("Finish argument is %v. Expects a *SnpUserGuestRequest", reflect.TypeOf(b))
+	}
+	if err := r.reqConv.Finish(s.ReqData); err != nil {
+		return fmt.Errorf("could not finalize request data: %v", err)
+	}
+	if err := r.respConv.Finish(s.RespData); err != nil {
+		return fmt.Errorf("could not finalize response data: %v", err)
+	}
+	s.FwErr = uint64(r.abi.FwErr)
+	return nil
+}
+
+// Pointer returns a pointer to the object that crosses the ABI boundary.
+func (r *snpUserGuestRequestConversion) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(&r.abi)
+}
+
+// Finish writes back the FwErr and any changes to the request or response objects.
+func (r *snpUserGuestRequestConversion) Finish(b BinaryConvertible) error {
+	s, ok := b.(*SnpUserGuestRequest)
+	if !ok {
+		return fmt.Errorf("Finish argument is %v. Expects a *SnpUserGuestRequestSafe", reflect.TypeOf(b))
+	}
+	if err := r.reqConv.Finish(s.ReqData); err != nil {
+		return fmt.Errorf("could not finalize request data: %v", err)
+	}
+	if err := r.respConv.Finish(s.RespData); err != nil {
+		return fmt.Errorf("could not finalize response data: %v", err)
+	}
+	s.FwErr = r.abi.FwErr
+	return nil
+}
+
+// bufferConversion passes a message through the ABI boundary as its little-endian byte
+// representation.
+type bufferConversion struct {
+	data []byte
+}
+
+func newBufferConversion(m binaryCodec) BinaryConversion {
+	data, _ := m.MarshalBinary()
+	return &bufferConversion{data: data}
+}
+
+// Pointer returns a pointer to the message's byte representation.
+func (c *bufferConversion) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(&c.data[0])
+}
+
+// Finish decodes the byte representation back into the message and checks its status.
+func (c *bufferConversion) Finish(b BinaryConvertible) error {
+	m, ok := b.(binaryCodec)
+	if !ok {
+		return fmt.Errorf("Finish argument is %v. Expects a binary message", reflect.TypeOf(b))
+	}
+	if err := m.UnmarshalBinary(c.data); err != nil {
+		return err
+	}
+	if s, ok := m.(interface{ checkStatus() error }); ok {
+		return s.checkStatus()
+	}
+	return nil
+}
+
+// ABI returns the message's byte representation for the ioctl.
+func (r *SnpReportReqABI) ABI() BinaryConversion { return newBufferConversion(r) }
+
+// reportRespConversion passes a report response through the ABI boundary in place. The response
+// is the largest message and the driver only writes it, so it skips the copies of a
+// bufferConversion.
+type reportRespConversion struct {
+	resp *SnpReportRespABI
+}
+
+// The response crosses the boundary in place, so its layout must be the ABI's.
+var _ [SnpReportRespABISize - unsafe.Sizeof(SnpReportRespABI{})]byte
+var _ [unsafe.Sizeof(SnpReportRespABI{}) - SnpReportRespABISize]byte
+var _ [msgReportReqHeaderSize - unsafe.Offsetof(SnpReportRespABI{}.Data)]byte
+var _ [unsafe.Offsetof(SnpReportRespABI{}.Data) - msgReportReqHeaderSize]byte
+
+// Pointer returns a pointer to the response itself.
+func (c reportRespConversion) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(c.resp)
+}
+
+// Finish checks the status the driver wrote into the response.
+func (c reportRespConversion) Finish(b BinaryConvertible) error {
+	if b != BinaryConvertible(c.resp) {
+		return fmt.Errorf("Finish argument is %v. Expects the converted *SnpReportRespABI", reflect.TypeOf(b))
+	}
+	return c.resp.checkStatus()
+}
+
+// ABI returns the response itself for the ioctl to write into.
+func (r *SnpReportRespABI) ABI() BinaryConversion { return reportRespConversion{resp: r} }
+
+// ABI returns the message's byte representation for the ioctl.
+func (r *SnpDerivedKeyReqABI) ABI() BinaryConversion { return newBufferConversion(r) }
+
+// ABI returns the message's byte representation for the ioctl.
+func (r *SnpDerivedKeyRespABI) ABI() BinaryConversion { return newBufferConversion(r) }
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package linuxabi
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestBufferConversion(t *testing.T) {
+	resp := &SnpDerivedKeyRespABI{}
+	conv := resp.ABI()
+	// Emulate the kernel writing the response into the buffer the ioctl points to.
+	buf := unsafe.Slice((*byte)(conv.Pointer()), SnpDerivedKeyRespABISize)
+	buf[0x20] = 0xaa
+	buf[0x3f] = 0xbb
+	if err := conv.Finish(resp); err != nil {
+		t.Fatalf("Finish() = %v. Want nil", err)
+	}
+	if resp.Data[0] != 0xaa || resp.Data[31] != 0xbb {
+		t.Errorf("Finish() data = %x. Want aa...bb", resp.Data)
+	}
+
+	buf[0] = 0x16
+	if err := conv.Finish(resp); err == nil {
+		t.Error("Finish() with status 0x16 = nil. Want error")
+	}
+	if resp.Data != [32]byte{} {
+		t.Errorf("Finish() with status 0x16 data = %x. Want zeros", resp.Data)
+	}
+}
+
+func TestReportRespConversion(t *testing.T) {
+	resp := &SnpReportRespABI{}
+	conv := resp.ABI()
+	// Emulate the kernel writing the response into the page the ioctl points to.
+	buf := unsafe.Slice((*byte)(conv.Pointer()), SnpReportRespABISize)
+	buf[msgReportReqHeaderSize] = 0xaa
+	buf[SnpReportRespABISize-1] = 0xbb
+	if err := conv.Finish(resp); err != nil {
+		t.Fatalf("Finish() = %v. Want nil", err)
+	}
+	if resp.Data[0] != 0xaa || resp.Data[SnpReportRespReportSize-1] != 0xbb {
+		t.Errorf("Finish() data = %x...%x. Want aa...bb", resp.Data[0], resp.Data[SnpReportRespReportSize-1])
+	}
+
+	buf[0] = 0x16
+	if err := conv.Finish(resp); err == nil {
+		t.Error("Finish() with status 0x16 = nil. Want error")
+	}
+	if err := conv.Finish(&SnpReportRespABI{}); err == nil {
+		t.Error("Finish(other response) = nil. Want error")
+	}
+}
+
+func TestExtendedReportReqABI(t *testing.T) {
+	req := &SnpExtendedReportReq{
+		Data:        SnpReportReqABI{Vmpl: 3},
+		Certs:       make([]byte, 0x1000),
+		CertsLength: 0x1000,
+	}
+	conv := req.ABI()
+	abi := (*SnpExtendedReportReqABI)(conv.Pointer())
+	if abi.Data[0x40] != 3 {
+		t.Errorf("extended report request VMPL byte = %d. Want 3", abi.Data[0x40])
+	}
+	if abi.CertsAddress != uint64(uintptr(unsafe.Pointer(&req.Certs[0]))) {
+		t.Error("extended report request does not point to the certificate buffer")
+	}
+	abi.CertsLength = 0x2000
+	if err := conv.Finish(req); err != nil {
+		t.Fatalf("Finish() = %v. Want nil", err)
+	}
+	if req.CertsLength != 0x2000 {
+		t.Errorf("Finish() CertsLength = 0x%x. Want 0x2000", req.CertsLength)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package linuxabi
+
+import (
+	"errors"
+	"unsafe"
+)
+
+var errUnsupportedIoctl = errors.New("the sev-guest ioctl is only supported on Linux")
+
+// unsupportedConversion stands in for ioctl messages on platforms without /dev/sev-guest.
+type unsupportedConversion struct{}
+
+// Pointer returns nil.
+func (unsupportedConversion) Pointer() unsafe.Pointer { return nil }
+
+// Finish returns an error since the message cannot have crossed an ioctl boundary.
+func (unsupportedConversion) Finish(BinaryConvertible) error { return errUnsupportedIoctl }
+
+// ABI returns an unsupported conversion.
+func (r *SnpReportReqABI) ABI() BinaryConversion { return unsupportedConversion{} }
+
+// ABI returns an unsupported conversion.
+func (r *SnpReportRespABI) ABI() BinaryConversion { return unsupportedConversion{} }
+
+// ABI returns an unsupported conversion.
+func (r *SnpDerivedKeyReqABI) ABI() BinaryConversion { return unsupportedConversion{} }
+
+// ABI returns an unsupported conversion.
+func (r *SnpDerivedKeyRespABI) ABI() BinaryConversion { return unsupportedConversion{} }
+
+// ABI returns an unsupported conversion.
+func (r *SnpExtendedReportReq) ABI() BinaryConversion { return unsupportedConversion{} }
+
+// ABI returns an unsupported conversion.
+func (r *SnpUserGuestRequest) ABI() BinaryConversion { return unsupportedConversion{} }
+
+// ABIForRevision returns an unsupported conversion.
+func (r *SnpUserGuestRequest) ABIForRevision(ABIRevision) BinaryConversion {
+	return unsupportedConversion{}
+}
@@ -18,7 +18,6 @@ package linuxabi
 import (
 	"errors"
 	"fmt"
-	"reflect"
 	"unsafe"
 )
 
@@ -42,8 +41,14 @@ const (
 	iocTypeSnpGuestReq = 'S'
 	iocSnpWithoutNr    = ((iocWrite | iocRead) << iocDirshift) |
 		(iocTypeSnpGuestReq << iocTypeshift) |
-		// unsafe.Sizeof(snpUserGuestRequest)
-		(32 << iocSizeshift)
+		(mainlineGuestRequestSize << iocSizeshift)
+	iocSizeMask = ((1 << iocSizebits) - 1) << iocSizeshift
+
+	// mainlineGuestRequestSize is unsafe.Sizeof(SnpUserGuestRequestABI).
+	mainlineGuestRequestSize = 32
+	// patchsetGuestRequestSize is the size of the guest request without msg_version in pre-mainline
+	// patchset kernels.
+	patchsetGuestRequestSize = 24
 
 	// IocSnpGetReport is the ioctl command for getting an attestation report
 	IocSnpGetReport = iocSnpWithoutNr | (0x0 << iocNrshift)
@@ -114,7 +119,50 @@ type SnpReportReqABI struct {
 	// The kernel must have access to the corresponding VMPCK.
 	Vmpl uint32
 
-	reserved [28]byte
+	// KeySel selects the key that signs the report. See the KeySel* constants. Firmware before
+	// SEV SNP API 1.56 ignores it.
+	KeySel uint32
+
+	reserved [24]byte
+}
+
+const (
+	// KeySelDefault requests a report signed with the VLEK if one is installed, else the VCEK.
+	KeySelDefault = 0
+	// KeySelVCEK requests a report signed with the VCEK.
+	KeySelVCEK = 1
+	// KeySelVLEK requests a report signed with the VLEK.
+	KeySelVLEK = 2
+
+	// MaxVmpl is the least privileged VMPL that a report can be requested at.
+	MaxVmpl = 3
+
+	maxKeySel = KeySelVLEK
+
+	// RootKeySelectVMRK is the RootKeySelect bit that roots a derived key in the VM root key.
+	RootKeySelectVMRK = 1
+	// RootKeySelectKeySelShift is the position of the KeySel bits in RootKeySelect.
+	RootKeySelectKeySelShift = 1
+)
+
+// NewSnpReportReq returns a GET_REPORT request for the given user data, VMPL, and key selection.
+// Invalid inputs are rejected here rather than by the firmware with INVALID_PARAM.
+func NewSnpReportReq(reportData []byte, vmpl int, keySel uint32) (*SnpReportReqABI, error) {
+	req := &SnpReportReqABI{}
+	if len(reportData) != len(req.ReportData) {
+		return nil, fmt.Errorf("report_data length is %d bytes. Expected %d bytes", len(reportData),
+			len(req.ReportData))
+	}
+	if vmpl < 0 || vmpl > MaxVmpl {
+		return nil, fmt.Errorf("vmpl is %d. Expected 0-%d", vmpl, MaxVmpl)
+	}
+	if keySel > maxKeySel {
+		return nil, fmt.Errorf("key_sel is %d. Expected 0-%d", keySel, maxKeySel)
+	}
+	copy(req.ReportData[:], reportData)
+	req.Vmpl = uint32(vmpl)
+	req.KeySel = keySel
+	return req, nil
 }
 
 // SnpReportRespABI is Linux's sev-guest ioctl abi for receiving a GET_REPORT response.
@@ -127,27 +175,8 @@ type SnpReportRespABI struct {
 	Data [SnpReportRespReportSize]uint8
 }
 
-// ABI returns the same object since it doesn't need a separate representation across the interface.
-func (r *SnpReportReqABI) ABI() BinaryConversion { return r }
-
-// Pointer returns a pointer to the object itself.
-func (r *SnpReportReqABI) Pointer() unsafe.Pointer {
-	return unsafe.Pointer(r)
-}
-
-// Finish is a no-op.
-func (r *SnpReportReqABI) Finish(_ BinaryConvertible) error { return nil }
-
-// ABI returns the same object since it doesn't need a separate representation across the interface.
-func (r *SnpReportRespABI) ABI() BinaryConversion { return r }
-
-// Pointer returns a pointer to the object itself.
-func (r *SnpReportRespABI) Pointer() unsafe.Pointer {
-	return unsafe.Pointer(r)
-}
-
-// Finish checks the status of the message and translates it to a Golang error.
-func (r *SnpReportRespABI) Finish(_ BinaryConvertible) error {
+// checkStatus translates the status of the message to a Golang error.
+func (r *SnpReportRespABI) checkStatus() error {
 	if r.Status != 0 {
 		switch r.Status {
 		case 0x16: // Value from MSG_REPORT_RSP specification in SNP API.
@@ -162,7 +191,9 @@ func (r *SnpReportRespABI) Finish(_ BinaryConvertible) error {
 // SnpDerivedKeyReqABI is the ABI representation of a request to the SEV guest device to derive a
 // key from specified information.
 type SnpDerivedKeyReqABI struct {
-	// RootKeySelect is all reserved bits except bit 0 for UseVMRK (1) or UseVCEK (0).
+	// RootKeySelect is all reserved bits except bit 0 for UseVMRK (1) or UseVCEK (0), and bits 2:1
+	// for the KeySel* choice between the VCEK and VLEK when bit 0 is 0. Firmware before SEV SNP API
+	// 1.56 ignores the KeySel bits.
 	RootKeySelect    uint32
 	reserved         uint32
 	GuestFieldSelect uint64
@@ -174,15 +205,6 @@ type SnpDerivedKeyReqABI struct {
 	TCBVersion uint64
 }
 
-// Pointer returns a pointer to the object.
-func (r *SnpDerivedKeyReqABI) Pointer() unsafe.Pointer { return unsafe.Pointer(r) }
-
-// Finish is a no-op.
-func (r *SnpDerivedKeyReqABI) Finish(BinaryConvertible) error { return nil }
-
-// ABI returns the ABI representation of this object.
-func (r *SnpDerivedKeyReqABI) ABI() BinaryConversion { return r }
-
 // SnpDerivedKeyRespABI represents the response to an SnpDerivedKeyReq.
 type SnpDerivedKeyRespABI struct {
 	Status   uint32
@@ -190,14 +212,12 @@ type SnpDerivedKeyRespABI struct {
 	Data     [32]byte
 }
 
-// ABI returns the object itself.
-func (r *SnpDerivedKeyRespABI) ABI() BinaryConversion { return r }
-
-// Pointer returns a pointer to the object itself.
-func (r *SnpDerivedKeyRespABI) Pointer() unsafe.Pointer { return unsafe.Pointer(r) }
-
-// Finish is a no-op.
-func (r *SnpDerivedKeyRespABI) Finish(BinaryConvertible) error {
+// checkStatus translates the status of the message to a Golang error. The key material is zeroed
+// on failure.
+func (r *SnpDerivedKeyRespABI) checkStatus() error {
+	if r.Status != 0 {
+		r.Data = [32]byte{}
+	}
 	switch r.Status {
 	case 0:
 		return nil
@@ -208,17 +228,6 @@ func (r *SnpDerivedKeyRespABI) Finish(BinaryConvertible) error {
 	}
 }
 
-// SnpExtendedReportReqABI is Linux's sev-guest ioctl abi for sending a GET_EXTENDED_REPORT request.
-type SnpExtendedReportReqABI struct {
-	Data SnpReportReqABI
-
-	// Where to copy the certificate blob.
-	CertsAddress unsafe.Pointer
-
-	// length of the certificate blob
-	CertsLength uint32
-}
-
 // SnpExtendedReportReq is close to Linux's sev-guest ioctl abi for sending a GET_EXTENDED_REPORT request,
 // but uses safer types for the Ioctl interface.
 type SnpExtendedReportReq struct {
@@ -231,49 +240,44 @@ type SnpExtendedReportReq struct {
 	CertsLength uint32
 }
 
-// Pointer returns a pointer so the object itself.
-func (r *SnpExtendedReportReqABI) Pointer() unsafe.Pointer {
-	return unsafe.Pointer(r)
-}
+// ABIRevision identifies a layout of the sev-guest guest request ioctl payload. The layout's size
+// is part of the ioctl command, so a kernel rejects another layout's commands with EINVAL.
+type ABIRevision int
 
-// Finish writes back the changed CertsLength value.
-func (r *SnpExtendedReportReqABI) Finish(b BinaryConvertible) error {
-	s, ok := b.(*SnpExtendedReportReq)
-	if !ok {
-		return fmt.Errorf("Finish argument is %v. Expects a *SnpExtendedReportReq", reflect.TypeOf(b))
-	}
-	s.CertsLength = r.CertsLength
-	return nil
-}
+const (
+	// ABIRevisionUnknown is the revision of a device before a command has identified its kernel's.
+	ABIRevisionUnknown ABIRevision = iota
+	// ABIRevisionMainline is the 32-byte request of the Linux 5.19+ driver, whose 64-bit exitinfo2
+	// carries the firmware error in its low half and the VMM error in its high half.
+	ABIRevisionMainline
+	// ABIRevisionPatchset is the 24-byte request of pre-mainline patchset kernels, which has no
+	// msg_version and a 32-bit fw_err.
+	ABIRevisionPatchset
+)
 
-// ABI returns an object that can cross the ABI boundary and copy back changes to the original
-// object.
-func (r *SnpExtendedReportReq) ABI() BinaryConversion {
-	var certsAddress unsafe.Pointer
-	if len(r.Certs) != 0 {
-		certsAddress = unsafe.Pointer(&r.Certs[0])
-	}
-	return &SnpExtendedReportReqABI{
-		Data:         r.Data,
-		CertsAddress: certsAddress,
-		CertsLength:  r.CertsLength,
+// ABIRevisions lists the known revisions in the order to try them.
+var ABIRevisions = []ABIRevision{ABIRevisionMainline, ABIRevisionPatchset}
+
+// String returns a name for the revision.
+func (r ABIRevision) String() string {
+	switch r {
+	case ABIRevisionUnknown:
+		return "unknown"
+	case ABIRevisionMainline:
+		return "mainline"
+	case ABIRevisionPatchset:
+		return "patchset"
 	}
+	return fmt.Sprintf("ABIRevision(%d)", int(r))
 }
 
-// SnpUserGuestRequestABI is Linux's sev-guest ioctl abi for issuing a guest message.
-type SnpUserGuestRequestABI struct {
-	GuestMsgVersion uint32
-	// Request and response structure address.
-	ReqData  unsafe.Pointer
-	RespData unsafe.Pointer
-	// firmware error code on failure (see psp-sev.h in Linux kernel)
-	FwErr uint64
-}
-
-type snpUserGuestRequestConversion struct {
-	abi      SnpUserGuestRequestABI
-	reqConv  BinaryConversion
-	respConv BinaryConversion
+// Command returns the ioctl command number of one of the Ioc* commands for revision r. Commands
+// are the mainline ones for an unknown revision.
+func (r ABIRevision) Command(command uintptr) uintptr {
+	if r != ABIRevisionPatchset {
+		return command
+	}
+	return command&^iocSizeMask | patchsetGuestRequestSize<<iocSizeshift
 }
 
 // SnpUserGuestRequest is Linux's sev-guest ioctl interface for issuing a guest message. The
@@ -286,40 +290,6 @@ type SnpUserGuestRequest struct {
 	FwErr uint64
 }
 
-// ABI returns an object that can cross the ABI boundary and copy back changes to the original
-// object.
-func (r *SnpUserGuestRequest) ABI() BinaryConversion {
-	result := &snpUserGuestRequestConversion{
-		reqConv:  r.ReqData.ABI(),
-		respConv: r.RespData.ABI(),
-	}
-	result.abi.GuestMsgVersion = guestMsgVersion
-	result.abi.ReqData = result.reqConv.Pointer()
-	result.abi.RespData = result.respConv.Pointer()
-	return result
-}
-
-// Pointer returns a pointer to the object that crosses the ABI boundary.
-func (r *snpUserGuestRequestConversion) Pointer() unsafe.Pointer {
-	return unsafe.Pointer(&r.abi)
-}
-
-// Finish writes back the FwErr and any changes to the request or response objects.
-func (r *snpUserGuestRequestConversion) Finish(b BinaryConvertible) error {
-	s, ok := b.(*SnpUserGuestRequest)
-	if !ok {
-		return fmt.Errorf("Finish argument is %v. Expects a *SnpUserGuestRequestSafe", reflect.TypeOf(b))
-	}
-	if err := r.reqConv.Finish(s.ReqData); err != nil {
-		return fmt.Errorf("could not finalize request data: %v", err)
-	}
-	if err := r.respConv.Finish(s.RespData); err != nil {
-		return fmt.Errorf("could not finalize response data: %v", err)
-	}
-	s.FwErr = r.abi.FwErr
-	return nil
-}
-
 // BinaryConversion is an interface that abstracts a "stand-in" object that passes through an ABI
 // boundary and can finalize changes to the original object.
 type BinaryConversion interface {
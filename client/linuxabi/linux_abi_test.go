@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linuxabi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewSnpReportReq(t *testing.T) {
+	reportData := bytes.Repeat([]byte{0xaa}, 64)
+	req, err := NewSnpReportReq(reportData, 3, KeySelVLEK)
+	if err != nil {
+		t.Fatalf("NewSnpReportReq(_, 3, KeySelVLEK) = _, %v. Want nil", err)
+	}
+	want := SnpReportReqABI{Vmpl: 3, KeySel: KeySelVLEK}
+	copy(want.ReportData[:], reportData)
+	if *req != want {
+		t.Errorf("NewSnpReportReq(_, 3, KeySelVLEK) = %+v. Want %+v", *req, want)
+	}
+
+	tcs := []struct {
+		name       string
+		reportData []byte
+		vmpl       int
+		keySel     uint32
+		wantErr    string
+	}{
+		{
+			name:       "short report data",
+			reportData: make([]byte, 63),
+			wantErr:    "report_data length is 63 bytes. Expected 64 bytes",
+		},
+		{
+			name:       "long report data",
+			reportData: make([]byte, 65),
+			wantErr:    "report_data length is 65 bytes. Expected 64 bytes",
+		},
+		{
+			name:       "vmpl too large",
+			reportData: reportData,
+			vmpl:       4,
+			wantErr:    "vmpl is 4. Expected 0-3",
+		},
+		{
+			name:       "negative vmpl",
+			reportData: reportData,
+			vmpl:       -1,
+			wantErr:    "vmpl is -1. Expected 0-3",
+		},
+		{
+			name:       "undefined key_sel",
+			reportData: reportData,
+			keySel:     3,
+			wantErr:    "key_sel is 3. Expected 0-2",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewSnpReportReq(tc.reportData, tc.vmpl, tc.keySel); err == nil || err.Error() != tc.wantErr {
+				t.Errorf("NewSnpReportReq() = _, %v. Want error %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestABIRevisionCommand(t *testing.T) {
+	tcs := []struct {
+		revision ABIRevision
+		command  uintptr
+		want     uintptr
+	}{
+		{revision: ABIRevisionUnknown, command: IocSnpGetReport, want: 0xc0205300},
+		{revision: ABIRevisionMainline, command: IocSnpGetExtendedReport, want: 0xc0205302},
+		{revision: ABIRevisionPatchset, command: IocSnpGetReport, want: 0xc0185300},
+		{revision: ABIRevisionPatchset, command: IocSnpGetDerivedKey, want: 0xc0185301},
+	}
+	for _, tc := range tcs {
+		if got := tc.revision.Command(tc.command); got != tc.want {
+			t.Errorf("%v.Command(0x%x) = 0x%x. Want 0x%x", tc.revision, tc.command, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	rpb "github.com/google/go-sev-guest/proto/remote"
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxRemoteMessageSize bounds the length prefix of a quote request or response, so that neither
+// end allocates whatever its peer claims to send.
+const maxRemoteMessageSize = 1 << 20
+
+// writeRemoteMessage writes m to w, preceded by its length as a 4-byte big-endian integer.
+func writeRemoteMessage(w io.Writer, m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxRemoteMessageSize {
+		return fmt.Errorf("quote message is %d bytes. Expected at most %d", len(b), maxRemoteMessageSize)
+	}
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readRemoteMessage reads a message written by writeRemoteMessage into m. It returns io.EOF if r
+// ends before the message starts.
+func readRemoteMessage(r io.Reader, m proto.Message) error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if length > maxRemoteMessageSize {
+		return fmt.Errorf("quote message is %d bytes. Expected at most %d", length, maxRemoteMessageSize)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return proto.Unmarshal(b, m)
+}
+
+// QuoteServer answers the quote requests of RemoteQuoteProviders with reports from its Device, so
+// that only the server's process needs access to /dev/sev-guest. The server does not authenticate
+// its clients. Restrict who may connect with the permissions of the socket that it listens on.
+type QuoteServer struct {
+	// Device is the open device to send commands to. The server does not close it.
+	Device Device
+	// Options configures the device commands. If nil, uses the defaults.
+	Options *Options
+}
+
+// Serve answers the quote requests on each connection that l accepts, one request at a time per
+// connection, until l fails to accept. It returns the error from Accept, e.g., net.ErrClosed once
+// l is closed.
+func (s *QuoteServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *QuoteServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req := new(rpb.QuoteRequest)
+		if err := readRemoteMessage(conn, req); err != nil {
+			// The client is gone or does not speak the protocol. Either way there is no one to answer.
+			return
+		}
+		if err := writeRemoteMessage(conn, s.quote(req)); err != nil {
+			return
+		}
+	}
+}
+
+// quote runs the device commands for a request. Failures are answered rather than returned, with
+// the firmware status if the firmware rejected a command.
+func (s *QuoteServer) quote(req *rpb.QuoteRequest) *rpb.QuoteResponse {
+	if len(req.GetReportData()) != abi.ReportDataSize {
+		return errorResponse(fmt.Errorf("report_data is %d bytes. Expected %d", len(req.GetReportData()), abi.ReportDataSize))
+	}
+	var reportData [64]byte
+	copy(reportData[:], req.GetReportData())
+	ctx := context.Background()
+	if !req.GetExtended() {
+		report, err := getRawReportAtVmpl(ctx, s.Device, reportData, int(req.GetVmpl()), s.Options)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &rpb.QuoteResponse{Report: report}
+	}
+	provider := &DeviceQuoteProvider{Device: s.Device, Options: s.Options}
+	quote, err := provider.getRawQuoteAtLevel(ctx, reportData, uint(req.GetVmpl()))
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &rpb.QuoteResponse{Report: quote[:abi.ReportSize], Certs: quote[abi.ReportSize:]}
+}
+
+func errorResponse(err error) *rpb.QuoteResponse {
+	resp := &rpb.QuoteResponse{Error: err.Error()}
+	var fwErr *FirmwareErr
+	if errors.As(err, &fwErr) {
+		resp.FirmwareStatus = uint64(fwErr.Status)
+	}
+	return resp
+}
+
+// remoteQuoteErr is a failure that the quote server answered with. It unwraps to the firmware
+// error, if any, so that errors.As recovers the firmware status as it does for a local Device.
+type remoteQuoteErr struct {
+	msg   string
+	fwErr *FirmwareErr
+}
+
+func (e *remoteQuoteErr) Error() string {
+	return "quote server: " + e.msg
+}
+
+func (e *remoteQuoteErr) Unwrap() error {
+	if e.fwErr == nil {
+		return nil
+	}
+	return e.fwErr
+}
+
+// RemoteQuoteProvider implements QuoteProvider and LeveledQuoteProvider by asking a QuoteServer
+// for each quote over a unix socket, for workloads that cannot open /dev/sev-guest themselves.
+type RemoteQuoteProvider struct {
+	// Path is the unix socket that the QuoteServer listens on.
+	Path string
+}
+
+// IsSupported returns whether the quote server accepts connections.
+func (p *RemoteQuoteProvider) IsSupported() bool {
+	conn, err := net.Dial("unix", p.Path)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// request sends req on a new connection to the quote server and returns its answer. The
+// connection is abandoned if ctx is done first.
+func (p *RemoteQuoteProvider) request(ctx context.Context, req *rpb.QuoteRequest) (*rpb.QuoteResponse, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the quote server: %w", err)
+	}
+	defer conn.Close()
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				// Unblock the pending read or write.
+				conn.SetDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+	}
+	resp := new(rpb.QuoteResponse)
+	err = writeRemoteMessage(conn, req)
+	if err == nil {
+		err = readRemoteMessage(conn, resp)
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("quote request abandoned: %w", ctxErr)
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("quote server did not answer: %w", err)
+	}
+	if resp.GetError() != "" {
+		rerr := &remoteQuoteErr{msg: resp.GetError()}
+		if status := resp.GetFirmwareStatus(); status != 0 {
+			rerr.fwErr = &FirmwareErr{Status: abi.SevFirmwareStatus(status)}
+		}
+		return nil, rerr
+	}
+	if len(resp.GetReport()) != abi.ReportSize {
+		return nil, fmt.Errorf("quote server answered with a %d byte report. Expected %d", len(resp.GetReport()), abi.ReportSize)
+	}
+	return resp, nil
+}
+
+func (p *RemoteQuoteProvider) getRawQuoteAtLevel(ctx context.Context, reportData [64]byte, level uint) ([]uint8, error) {
+	resp, err := p.request(ctx, &rpb.QuoteRequest{ReportData: reportData[:], Vmpl: uint32(level), Extended: true})
+	if err != nil {
+		return nil, err
+	}
+	return append(resp.GetReport(), resp.GetCerts()...), nil
+}
+
+// GetRawQuoteAtLevel returns byte format attestation plus certificate table from the quote server.
+func (p *RemoteQuoteProvider) GetRawQuoteAtLevel(reportData [64]byte, level uint) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(context.Background(), reportData, level)
+}
+
+// GetRawQuote returns byte format attestation plus certificate table from the quote server at
+// VMPL0.
+func (p *RemoteQuoteProvider) GetRawQuote(reportData [64]byte) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(context.Background(), reportData, 0)
+}
+
+// GetRawQuoteContext is like GetRawQuote, but abandons the quote if ctx is done before the quote
+// server answers.
+func (p *RemoteQuoteProvider) GetRawQuoteContext(ctx context.Context, reportData [64]byte) ([]uint8, error) {
+	return p.getRawQuoteAtLevel(ctx, reportData, 0)
+}
+
+// GetRawReportAtLevel returns just the byte format attestation report from the quote server,
+// without asking the host for its certificates.
+func (p *RemoteQuoteProvider) GetRawReportAtLevel(ctx context.Context, reportData [64]byte, level uint) ([]byte, error) {
+	resp, err := p.request(ctx, &rpb.QuoteRequest{ReportData: reportData[:], Vmpl: uint32(level)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetReport(), nil
+}
+
+// Product returns nil, since the quote server's product is only known from its platform info.
+//
+// Deprecated: Use abi.ExtraPlatformInfoGUID in the raw quote certificate table.
+func (*RemoteQuoteProvider) Product() *pb.SevProduct {
+	return nil
+}
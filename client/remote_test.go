@@ -0,0 +1,183 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-sev-guest/abi"
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	rpb "github.com/google/go-sev-guest/proto/remote"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// startQuoteServer serves quotes from d on a unix socket until the test ends, and returns a
+// provider that dials it.
+func startQuoteServer(t *testing.T, d Device) *RemoteQuoteProvider {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "quote.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &QuoteServer{Device: d}
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(l) }()
+	t.Cleanup(func() {
+		l.Close()
+		if err := <-done; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve() = %v. Want %v", err, net.ErrClosed)
+		}
+	})
+	return &RemoteQuoteProvider{Path: path}
+}
+
+func TestRemoteQuoteProvider(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	p := startQuoteServer(t, d)
+	if !p.IsSupported() {
+		t.Fatal("RemoteQuoteProvider.IsSupported() = false. Want true")
+	}
+	got, err := GetQuoteProto(p, input)
+	if err != nil {
+		t.Fatalf("GetQuoteProto(RemoteQuoteProvider, _) = _, %v. Want nil", err)
+	}
+	want, err := GetQuoteProto(&DeviceQuoteProvider{Device: d}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.GetReport().GetReportData(), input[:]) {
+		t.Errorf("GetQuoteProto(RemoteQuoteProvider, %x) report data = %x", input, got.GetReport().GetReportData())
+	}
+	// The certificate table includes the server's platform info, so the quote verifies as if it
+	// came from the device directly.
+	if diff := cmp.Diff(got.GetCertificateChain(), want.GetCertificateChain(), protocmp.Transform()); diff != "" {
+		t.Errorf("GetQuoteProto(RemoteQuoteProvider, _) certificates differ from the device's: %s", diff)
+	}
+}
+
+func TestRemoteQuoteProviderReportOnly(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	p := startQuoteServer(t, d)
+	raw, err := p.GetRawReportAtLevel(context.Background(), input, 0)
+	if err != nil {
+		t.Fatalf("GetRawReportAtLevel(_, _, 0) = _, %v. Want nil", err)
+	}
+	if len(raw) != abi.ReportSize {
+		t.Errorf("GetRawReportAtLevel(_, _, 0) = %d bytes. Want %d", len(raw), abi.ReportSize)
+	}
+	report, err := abi.ReportToProto(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(report.GetReportData(), input[:]) {
+		t.Errorf("GetRawReportAtLevel(_, %x, 0) report data = %x", input, report.GetReportData())
+	}
+}
+
+// fwErrDevice fails every command in the firmware with a status.
+type fwErrDevice struct {
+	noIoctlDevice
+	status abi.SevFirmwareStatus
+}
+
+func (d *fwErrDevice) Ioctl(_ uintptr, req any) (uintptr, error) {
+	req.(*labi.SnpUserGuestRequest).FwErr = uint64(d.status)
+	return 0, syscall.EIO
+}
+
+func TestRemoteQuoteProviderFirmwareErr(t *testing.T) {
+	p := startQuoteServer(t, &fwErrDevice{status: abi.InvalidParam})
+	_, err := p.GetRawReportAtLevel(context.Background(), [64]byte{}, 0)
+	var fwErr *FirmwareErr
+	if !errors.As(err, &fwErr) || fwErr.Status != abi.InvalidParam {
+		t.Fatalf("GetRawReportAtLevel() = _, %v. Want *FirmwareErr with status %v", err, abi.InvalidParam)
+	}
+	if !strings.HasPrefix(err.Error(), "quote server: ") {
+		t.Errorf("GetRawReportAtLevel() error = %q. Want it to name the quote server", err)
+	}
+}
+
+func TestRemoteQuoteProviderServerErr(t *testing.T) {
+	d, input := throttledDevice(t, 0)
+	p := startQuoteServer(t, d)
+	_, err := p.GetRawQuoteAtLevel(input, 4)
+	var fwErr *FirmwareErr
+	if err == nil || errors.As(err, &fwErr) {
+		t.Fatalf("GetRawQuoteAtLevel(_, 4) = _, %v. Want an error without a firmware status", err)
+	}
+	if !strings.Contains(err.Error(), "vmpl") {
+		t.Errorf("GetRawQuoteAtLevel(_, 4) error = %q. Want the server's reason", err)
+	}
+}
+
+func TestRemoteQuoteProviderNoServer(t *testing.T) {
+	p := &RemoteQuoteProvider{Path: filepath.Join(t.TempDir(), "missing.sock")}
+	if p.IsSupported() {
+		t.Error("RemoteQuoteProvider.IsSupported() without a server = true. Want false")
+	}
+	if _, err := p.GetRawQuote([64]byte{}); err == nil {
+		t.Error("RemoteQuoteProvider.GetRawQuote() without a server = _, nil. Want an error")
+	}
+}
+
+func TestQuoteServerRejectsReportDataSize(t *testing.T) {
+	resp := (&QuoteServer{Device: &noIoctlDevice{}}).quote(&rpb.QuoteRequest{ReportData: make([]byte, 63)})
+	if resp.GetError() != "report_data is 63 bytes. Expected 64" {
+		t.Errorf("quote(63 byte report_data) error = %q. Want the size mismatch", resp.GetError())
+	}
+}
+
+func TestReadRemoteMessageTooLong(t *testing.T) {
+	frame := []byte{0x00, 0x10, 0x00, 0x01}
+	err := readRemoteMessage(bytes.NewReader(frame), new(rpb.QuoteRequest))
+	if err == nil || !strings.Contains(err.Error(), "Expected at most") {
+		t.Errorf("readRemoteMessage(%d byte prefix) = %v. Want a size error", 0x100001, err)
+	}
+}
+
+func TestRemoteQuoteProviderContextCanceled(t *testing.T) {
+	// A listener that never answers stands in for a stuck server.
+	path := filepath.Join(t.TempDir(), "stuck.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		cancel()
+		<-stop
+	}()
+	p := &RemoteQuoteProvider{Path: path}
+	if _, err := p.GetRawQuoteContext(ctx, [64]byte{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetRawQuoteContext(canceled while waiting, _) = _, %v. Want %v", err, context.Canceled)
+	}
+}
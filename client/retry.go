@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy determines how a device command is resent while the host throttles guest requests.
+type RetryPolicy struct {
+	// MaxRetries is how many times a throttled command is resent before giving up. Zero disables
+	// retries.
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry. Each following retry waits twice as long as
+	// the one before it.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait before any one retry. If zero, throttled commands are resent
+	// immediately.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns the RetryPolicy that report requests use unless told otherwise.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries:     5,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     8 * time.Second,
+	}
+}
+
+// Options configures how the client issues device commands.
+type Options struct {
+	// Retry is the policy for resending throttled commands. If nil, uses DefaultRetryPolicy.
+	Retry *RetryPolicy
+	// CertCache keeps the host's certificates between extended reports. If nil, every extended
+	// report fetches the host's current certificates.
+	CertCache *CertCache
+	// Logger receives trace lines for each device command. If nil, commands are not traced.
+	Logger Logger
+	// KDSGetter, if not nil, fetches the VCEK, ASK, and ARK from the AMD KDS for an attestation whose
+	// host supplied no VCEK certificate, based on the report's CHIP_ID and REPORTED_TCB. If the KDS
+	// cannot serve the VCEK, e.g., for a masked CHIP_ID, the attestation is returned as the host
+	// supplied it, and the reason is a warning to the Logger. Nil by default, since guests often
+	// cannot reach the KDS.
+	KDSGetter kds.Getter
+}
+
+// optionsDevice is a Device that carries the Options for the calls that pass none, e.g., a
+// LinuxDevice opened with WithRetryPolicy or WithLogger.
+type optionsDevice interface {
+	commandOptions() *Options
+}
+
+// commandOptions returns opts, or if opts is nil, the Options that d carries, if any. Options
+// passed to a call replace the device's rather than merge with them.
+func commandOptions(d Device, opts *Options) *Options {
+	if opts != nil {
+		return opts
+	}
+	if od, ok := d.(optionsDevice); ok {
+		return od.commandOptions()
+	}
+	return nil
+}
+
+func (o *Options) retryPolicy() *RetryPolicy {
+	if o == nil || o.Retry == nil {
+		return DefaultRetryPolicy()
+	}
+	return o.Retry
+}
+
+func (o *Options) certCache() *CertCache {
+	if o == nil {
+		return nil
+	}
+	return o.CertCache
+}
+
+// backoff returns the wait before the given retry, counting from 0. The upper half of the
+// exponential delay is jittered so that throttled guests do not retry in lockstep.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 0; i < retry && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if half := int64(delay / 2); half > 0 {
+		return time.Duration(half + rand.Int63n(half+1))
+	}
+	return delay
+}
+
+// isThrottled returns whether err is the firmware status of a request the host refused to handle
+// for now.
+func isThrottled(err error) bool {
+	if err == nil {
+		// Skip errors.As, whose target escapes, to keep successful commands allocation-free.
+		return false
+	}
+	var fwErr *FirmwareErr
+	return errors.As(err, &fwErr) && fwErr.Status == abi.GuestRequestBusy
+}
+
+// sleepContext waits for the given duration, or returns ctx.Err() as soon as ctx is done.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	"golang.org/x/crypto/hkdf"
+)
+
+// sealingKeyFields are the launch fields that SealingKey mixes into its firmware key.
+var sealingKeyFields = GuestFieldSelect{
+	Measurement: true,
+	GuestPolicy: true,
+	GuestSVN:    true,
+	TCBVersion:  true,
+}
+
+// SealingKey returns a 32-byte key for sealing data to this guest on this platform at VMPL0. It is
+// SealingKeyAtVmpl at VMPL0, so a guest that runs at another VMPL, e.g., an OS under an SVSM,
+// must use SealingKeyAtVmpl with its VMPL instead.
+func SealingKey(d Device, info []byte) ([32]byte, error) {
+	return SealingKeyAtVmpl(d, 0, info)
+}
+
+// SealingKeyAtVmpl returns a 32-byte key for sealing data to this guest on this platform at vmpl,
+// which must not be more privileged than the guest's current VMPL. The firmware derives its key
+// from the VCEK, which is specific to the chip, and mixes in the guest's launch measurement and
+// policy, its GUEST_SVN, vmpl, and the platform's COMMITTED_TCB, all as the guest's attestation
+// report at vmpl states them. The result is HKDF-SHA256 of the firmware key with no salt and the
+// given info, so that different uses of the key get independent keys from distinct info.
+//
+// The key changes if any of its inputs do, so data sealed before a guest update, a guest SVN or
+// policy change, or a committed TCB update cannot be unsealed after it, and data sealed at one
+// VMPL cannot be unsealed at another. A firmware rejection of the request is returned as a
+// *FirmwareErr. Security limitations of derived keys are described in LIMITATIONS.md.
+func SealingKeyAtVmpl(d Device, vmpl int, info []byte) ([32]byte, error) {
+	var key [32]byte
+	report, err := GetReportAtVmpl(d, [64]byte{}, vmpl)
+	if err != nil {
+		return key, fmt.Errorf("could not get the guest's launch state for a sealing key: %w", err)
+	}
+	response, err := getDerivedKey(d, &SnpDerivedKeyReq{
+		UseVCEK:          true,
+		KeySel:           labi.KeySelVCEK,
+		GuestFieldSelect: sealingKeyFields,
+		Vmpl:             report.GetVmpl(),
+		GuestSVN:         report.GetGuestSvn(),
+		TCBVersion:       report.GetCommittedTcb(),
+	})
+	if err != nil {
+		return key, err
+	}
+	defer func() { response.Data = [32]byte{} }()
+	if _, err := io.ReadFull(hkdf.New(sha256.New, response.Data[:], nil, info), key[:]); err != nil {
+		return [32]byte{}, fmt.Errorf("could not expand the sealing key: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	test "github.com/google/go-sev-guest/testing"
+)
+
+// sealingDevice returns a mock device whose firmware derives keys from its launch state.
+func sealingDevice(t *testing.T) *test.Device {
+	t.Helper()
+	d, err := test.TcDevice(test.TestCases()[:1], &test.DeviceOptions{Now: time.Date(2022, time.May, 3, 9, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Launch = &test.Launch{
+		Policy:       abi.SnpPolicyToBytes(abi.SnpPolicy{SMT: true}),
+		GuestSVN:     2,
+		Measurement:  [abi.MeasurementSize]byte{1, 2, 3},
+		CommittedTcb: 0x1234,
+	}
+	return d
+}
+
+func TestSealingKey(t *testing.T) {
+	d := sealingDevice(t)
+	d.WantDerivedKeyRequest = &labi.SnpDerivedKeyReqABI{
+		RootKeySelect:    labi.KeySelVCEK << labi.RootKeySelectKeySelShift,
+		GuestFieldSelect: sealingKeyFields.ABI(),
+		GuestSVN:         2,
+		TCBVersion:       0x1234,
+	}
+	key, err := SealingKey(d, []byte("disk"))
+	if err != nil {
+		t.Fatalf("SealingKey(d, \"disk\") = _, %v. Want nil", err)
+	}
+	again, err := SealingKey(d, []byte("disk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != again {
+		t.Errorf("SealingKey(d, \"disk\") = %x, then %x. Want a stable key", key, again)
+	}
+	other, err := SealingKey(d, []byte("secrets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == other {
+		t.Errorf("SealingKey(d, \"secrets\") = SealingKey(d, \"disk\") = %x. Want domain-separated keys", key)
+	}
+	if key == [32]byte{} {
+		t.Error("SealingKey(d, \"disk\") is all zeros")
+	}
+}
+
+func TestSealingKeyAtVmpl(t *testing.T) {
+	d := sealingDevice(t)
+	// An OS that runs at VMPL2 under an SVSM cannot get a report or a key at VMPL0.
+	d.Launch.Vmpl = 2
+	if key, err := SealingKey(d, []byte("disk")); err == nil {
+		t.Errorf("SealingKey(d at VMPL2, \"disk\") = %x, nil. Want an error", key)
+	}
+	d.WantDerivedKeyRequest = &labi.SnpDerivedKeyReqABI{
+		RootKeySelect:    labi.KeySelVCEK << labi.RootKeySelectKeySelShift,
+		GuestFieldSelect: sealingKeyFields.ABI(),
+		Vmpl:             2,
+		GuestSVN:         2,
+		TCBVersion:       0x1234,
+	}
+	key, err := SealingKeyAtVmpl(d, 2, []byte("disk"))
+	if err != nil {
+		t.Fatalf("SealingKeyAtVmpl(d, 2, \"disk\") = _, %v. Want nil", err)
+	}
+	d.WantDerivedKeyRequest = nil
+	less, err := SealingKeyAtVmpl(d, 3, []byte("disk"))
+	if err != nil {
+		t.Fatalf("SealingKeyAtVmpl(d, 3, \"disk\") = _, %v. Want nil", err)
+	}
+	if key == less {
+		t.Errorf("SealingKeyAtVmpl(d, 3, \"disk\") = SealingKeyAtVmpl(d, 2, \"disk\") = %x. Want keys bound to the VMPL", key)
+	}
+}
+
+func TestSealingKeyLaunchState(t *testing.T) {
+	d := sealingDevice(t)
+	key, err := SealingKey(d, nil)
+	if err != nil {
+		t.Fatalf("SealingKey(d, nil) = _, %v. Want nil", err)
+	}
+	tcs := []struct {
+		name   string
+		update func(*test.Launch)
+	}{
+		{name: "guest svn", update: func(l *test.Launch) { l.GuestSVN++ }},
+		{name: "policy", update: func(l *test.Launch) { l.Policy = abi.SnpPolicyToBytes(abi.SnpPolicy{SMT: true, SingleSocket: true}) }},
+		{name: "measurement", update: func(l *test.Launch) { l.Measurement[0]++ }},
+		{name: "committed tcb", update: func(l *test.Launch) { l.CommittedTcb++ }},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			launch := *d.Launch
+			defer func() { d.Launch = &launch }()
+			changed := launch
+			tc.update(&changed)
+			d.Launch = &changed
+			got, err := SealingKey(d, nil)
+			if err != nil {
+				t.Fatalf("SealingKey(d, nil) = _, %v. Want nil", err)
+			}
+			if got == key {
+				t.Errorf("SealingKey(d, nil) after a %s change = %x. Want a different key", tc.name, got)
+			}
+		})
+	}
+}
+
+// rejectKeyDevice fails every derived key request in the firmware with a status.
+type rejectKeyDevice struct {
+	*test.Device
+	status abi.SevFirmwareStatus
+}
+
+func (d *rejectKeyDevice) Ioctl(command uintptr, req any) (uintptr, error) {
+	if command == labi.IocSnpGetDerivedKey {
+		req.(*labi.SnpUserGuestRequest).RespData.(*labi.SnpDerivedKeyRespABI).Status = uint32(d.status)
+		return 0, nil
+	}
+	return d.Device.Ioctl(command, req)
+}
+
+func TestSealingKeyRejected(t *testing.T) {
+	d := &rejectKeyDevice{Device: sealingDevice(t), status: abi.InvalidKey}
+	key, err := SealingKey(d, nil)
+	var fwErr *FirmwareErr
+	if !errors.As(err, &fwErr) || fwErr.Status != abi.InvalidKey {
+		t.Fatalf("SealingKey(rejecting device, nil) = _, %v. Want *FirmwareErr with status %v", err, abi.InvalidKey)
+	}
+	if key != [32]byte{} {
+		t.Errorf("SealingKey(rejecting device, nil) = %x. Want zeros", key)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	"github.com/pkg/errors"
+)
+
+// Logger receives trace lines about the device commands that the client issues: each command's
+// start and end with its firmware status, SEV-ES result, and duration, and each retry decision.
+// The lines never contain report data or key material.
+type Logger interface {
+	// Debugf receives a line about a command's progress.
+	Debugf(format string, args ...any)
+	// Warnf receives a line about a command the host throttled, or about a KDS fallback that
+	// could not get the VCEK.
+	Warnf(format string, args ...any)
+}
+
+// logger returns the Logger to trace commands to, or nil if commands are not traced.
+func (o *Options) logger() Logger {
+	if o == nil {
+		return nil
+	}
+	return o.Logger
+}
+
+// commandName returns the sev-guest driver's name for a device command.
+func commandName(command uintptr) string {
+	switch command {
+	case labi.IocSnpGetReport:
+		return "SNP_GET_REPORT"
+	case labi.IocSnpGetDerivedKey:
+		return "SNP_GET_DERIVED_KEY"
+	case labi.IocSnpGetExtendedReport:
+		return "SNP_GET_EXT_REPORT"
+	}
+	return fmt.Sprintf("ioctl 0x%x", command)
+}
+
+// traceErr describes a command's error without any test or driver message that could quote the
+// request.
+func traceErr(err error) string {
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return "none"
+	case errors.As(err, &errno):
+		return errno.Error()
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err.Error()
+	}
+	return fmt.Sprintf("%T", err)
+}
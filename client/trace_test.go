@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	labi "github.com/google/go-sev-guest/client/linuxabi"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+// recordingLogger keeps every trace line with its level.
+type recordingLogger struct{ lines []string }
+
+func (l *recordingLogger) Debugf(format string, args ...any) {
+	l.lines = append(l.lines, "DEBUG "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...any) {
+	l.lines = append(l.lines, "WARN "+fmt.Sprintf(format, args...))
+}
+
+func TestMessageTrace(t *testing.T) {
+	d, input := throttledDevice(t, 1)
+	log := &recordingLogger{}
+	opts := fastRetries(2)
+	opts.Logger = log
+	if _, err := GetReportWithOptions(context.Background(), d, input, opts); err != nil {
+		t.Fatalf("GetReportWithOptions(_, d, _, traced) = _, %v. Want nil", err)
+	}
+	wantPrefixes := []string{
+		"DEBUG SNP_GET_REPORT: issuing",
+		"DEBUG SNP_GET_REPORT: done in ",
+		"WARN SNP_GET_REPORT: throttled by the host, retry 1 of 2 in ",
+		"DEBUG SNP_GET_REPORT: issuing",
+		"DEBUG SNP_GET_REPORT: done in ",
+	}
+	if len(log.lines) != len(wantPrefixes) {
+		t.Fatalf("trace = %q. Want %d lines", log.lines, len(wantPrefixes))
+	}
+	for i, prefix := range wantPrefixes {
+		if !strings.HasPrefix(log.lines[i], prefix) {
+			t.Errorf("trace line %d = %q. Want prefix %q", i, log.lines[i], prefix)
+		}
+	}
+	if !strings.Contains(log.lines[1], "fw_err=GUEST_REQUEST_BUSY") || !strings.Contains(log.lines[1], "err=resource temporarily unavailable") {
+		t.Errorf("throttled trace line = %q. Want the firmware status and errno", log.lines[1])
+	}
+	if !strings.Contains(log.lines[4], "fw_err=SUCCESS es_result=0 err=none") {
+		t.Errorf("final trace line = %q. Want success", log.lines[4])
+	}
+	reportData := hex.EncodeToString(input[:])
+	for _, line := range log.lines {
+		if strings.Contains(line, reportData) {
+			t.Errorf("trace line %q contains the report data", line)
+		}
+	}
+}
+
+func TestMessageTraceGivesUp(t *testing.T) {
+	d, input := throttledDevice(t, 2)
+	log := &recordingLogger{}
+	opts := fastRetries(1)
+	opts.Logger = log
+	if _, err := GetReportWithOptions(context.Background(), d, input, opts); err == nil {
+		t.Fatal("GetReportWithOptions(_, d, _, 1 retry) = _, nil. Want an error")
+	}
+	if last := log.lines[len(log.lines)-1]; last != "WARN SNP_GET_REPORT: still throttled after 1 retries, giving up" {
+		t.Errorf("last trace line = %q. Want the give up decision", last)
+	}
+}
+
+// okDevice answers every command with success and no data.
+type okDevice struct{}
+
+func (okDevice) Open(string) error                   { return nil }
+func (okDevice) Close() error                        { return nil }
+func (okDevice) Product() *spb.SevProduct            { return nil }
+func (okDevice) Ioctl(uintptr, any) (uintptr, error) { return 0, nil }
+
+func TestMessageUntracedAllocations(t *testing.T) {
+	req := &labi.SnpUserGuestRequest{}
+	ctx := context.Background()
+	for _, opts := range []*Options{nil, fastRetries(2)} {
+		allocs := testing.AllocsPerRun(100, func() {
+			if err := message(ctx, okDevice{}, labi.IocSnpGetReport, req, opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("message(_, _, _, _, %v) allocates %v times. Want 0", opts, allocs)
+		}
+	}
+}
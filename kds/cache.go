@@ -0,0 +1,229 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"container/list"
+	"context"
+	"crypto/x509"
+	"sync"
+	"time"
+)
+
+// CacheStats counts the lookups of a CachingGetter.
+type CacheStats struct {
+	// Hits is the number of lookups answered from the cache.
+	Hits uint64
+	// Misses is the number of lookups that were fetched with the underlying Getter.
+	Misses uint64
+}
+
+type cacheEntry struct {
+	url  string
+	body []byte
+	// expires is when the entry goes stale, or zero if it never does.
+	expires time.Time
+	// elem is the entry's place in the CachingGetter's recency list.
+	elem *list.Element
+}
+
+// CachingGetter is a Getter that keeps the KDS responses it fetches in memory, keyed by URL. A
+// CRL is kept until its NextUpdate less CRLSafetyMargin, since AMD reissues it by then.
+// Certificates and certificate chains are kept until they are invalidated, since the KDS never
+// changes them for a given URL. Other responses, e.g., error pages, are not cached. The cache may
+// be bounded with MaxEntries and MaxBytes, in which case the least recently used responses are
+// dropped first, e.g., for a short-lived verifier that cannot use a disk cache but sees the same
+// chips repeatedly.
+//
+// To pace and retry the fetches as well, the CachingGetter should be outermost so that cache hits
+// neither wait for the rate limiter nor count against it, and the rate limiter should be inside the
+// retries so that each retry waits for it:
+//
+//	&CachingGetter{Getter: &trust.RetryHTTPSGetter{Getter: &RateLimitedGetter{...}}}
+//
+// A CachingGetter is safe for concurrent use.
+type CachingGetter struct {
+	// Getter fetches the URLs that are not cached.
+	Getter Getter
+	// CRLSafetyMargin is how long before its NextUpdate a cached CRL is fetched again.
+	CRLSafetyMargin time.Duration
+	// Now returns the current time. If nil, time.Now.
+	Now func() time.Time
+	// Hooks, if set, are told of each URL answered from the cache.
+	Hooks *Hooks
+	// MaxEntries caps the number of cached responses. If zero, there is no cap.
+	MaxEntries int
+	// MaxBytes caps the total size of the cached responses. A response larger than MaxBytes is not
+	// cached. If zero, there is no cap.
+	MaxBytes int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	// recency orders the entries from most to least recently used.
+	recency list.List
+	bytes   int
+	stats   CacheStats
+}
+
+func (g *CachingGetter) now() time.Time {
+	if g.Now == nil {
+		return time.Now()
+	}
+	return g.Now()
+}
+
+// hit returns the fresh cached body of url and counts the hit, or drops a stale entry. g.mu must
+// be held.
+func (g *CachingGetter) hit(url string) ([]byte, bool) {
+	entry, ok := g.entries[url]
+	if ok && (entry.expires.IsZero() || g.now().Before(entry.expires)) {
+		g.stats.Hits++
+		g.recency.MoveToFront(entry.elem)
+		return entry.body, true
+	}
+	if ok {
+		g.remove(entry)
+	}
+	return nil, false
+}
+
+// lookup returns the fresh cached body of url, and counts the hit or miss.
+func (g *CachingGetter) lookup(url string) ([]byte, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body, ok := g.hit(url); ok {
+		return body, true
+	}
+	g.stats.Misses++
+	return nil, false
+}
+
+// Cached returns the fresh cached body of url without fetching it. Only a hit is counted, since a
+// miss is counted when the URL is fetched.
+func (g *CachingGetter) Cached(url string) ([]byte, bool) {
+	g.mu.Lock()
+	body, ok := g.hit(url)
+	g.mu.Unlock()
+	if ok {
+		g.Hooks.CacheHit(url)
+	}
+	return body, ok
+}
+
+// store caches body as the response for url, and drops the least recently used entries until the
+// cache is within its bounds. g.mu must be held.
+func (g *CachingGetter) store(url string, body []byte, expires time.Time) {
+	if g.MaxBytes > 0 && len(body) > g.MaxBytes {
+		return
+	}
+	if old, ok := g.entries[url]; ok {
+		g.remove(old)
+	}
+	if g.entries == nil {
+		g.entries = make(map[string]*cacheEntry)
+	}
+	entry := &cacheEntry{url: url, body: body, expires: expires}
+	entry.elem = g.recency.PushFront(entry)
+	g.entries[url] = entry
+	g.bytes += len(body)
+	for (g.MaxEntries > 0 && len(g.entries) > g.MaxEntries) || (g.MaxBytes > 0 && g.bytes > g.MaxBytes) {
+		g.remove(g.recency.Back().Value.(*cacheEntry))
+	}
+}
+
+// remove drops entry from the cache. g.mu must be held.
+func (g *CachingGetter) remove(entry *cacheEntry) {
+	g.recency.Remove(entry.elem)
+	delete(g.entries, entry.url)
+	g.bytes -= len(entry.body)
+}
+
+// expiry returns when body goes stale in the cache, or zero if never, and whether it may be cached
+// at all.
+func (g *CachingGetter) expiry(body []byte) (time.Time, bool) {
+	if _, err := x509.ParseCertificate(body); err == nil {
+		return time.Time{}, true
+	}
+	if _, _, err := ParseProductCertChainCerts(body); err == nil {
+		return time.Time{}, true
+	}
+	crl, err := ParseCRL(body)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expires := crl.NextUpdate.Add(-g.CRLSafetyMargin)
+	return expires, g.now().Before(expires)
+}
+
+// Get returns the cached body of the URL, or else fetches it and caches it if it is a
+// certificate, a KDS certificate chain, or a CRL that is not yet due for reissue.
+func (g *CachingGetter) Get(url string) ([]byte, error) {
+	return g.GetContext(context.Background(), url)
+}
+
+// GetContext is like Get, but abandons a fetch when ctx is done.
+func (g *CachingGetter) GetContext(ctx context.Context, url string) ([]byte, error) {
+	if body, ok := g.lookup(url); ok {
+		g.Hooks.CacheHit(url)
+		return body, nil
+	}
+	return g.fetch(ctx, g.Getter, url)
+}
+
+// fetch fetches url with getter, which is g.Getter or wraps it, and caches the response if it may
+// be cached.
+func (g *CachingGetter) fetch(ctx context.Context, getter Getter, url string) ([]byte, error) {
+	body, err := getContext(ctx, getter, url)
+	if err != nil {
+		return nil, err
+	}
+	if expires, ok := g.expiry(body); ok {
+		g.mu.Lock()
+		g.store(url, body, expires)
+		g.mu.Unlock()
+	}
+	return body, nil
+}
+
+// Invalidate drops the cached body of url, if any, so that the next Get fetches it again, e.g.,
+// after an AMD security bulletin.
+func (g *CachingGetter) Invalidate(url string) {
+	g.mu.Lock()
+	if entry, ok := g.entries[url]; ok {
+		g.remove(entry)
+	}
+	g.mu.Unlock()
+}
+
+// Len returns the number of cached responses.
+func (g *CachingGetter) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.entries)
+}
+
+// Bytes returns the total size of the cached responses.
+func (g *CachingGetter) Bytes() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.bytes
+}
+
+// Stats returns the cache's hit and miss counts so far.
+func (g *CachingGetter) Stats() CacheStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
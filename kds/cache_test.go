@@ -0,0 +1,195 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+)
+
+// countingGetter is a mapGetter that counts the fetches of each URL.
+type countingGetter struct {
+	mapGetter
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (g *countingGetter) Get(url string) ([]byte, error) {
+	g.mu.Lock()
+	if g.gets == nil {
+		g.gets = make(map[string]int)
+	}
+	g.gets[url]++
+	g.mu.Unlock()
+	return g.mapGetter.Get(url)
+}
+
+func TestCachingGetter(t *testing.T) {
+	ask, ark := testProductChain(t)
+	thisUpdate := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	chainURL := ProductCertChainURL(abi.VcekReportSigner, "Milan")
+	crlURL := CrlURL("Milan")
+	vcekURL := "https://kdsintf.amd.com/vcek/v1/Milan/vcek"
+	network := &countingGetter{mapGetter: mapGetter{
+		chainURL: append(pemCert(ask), pemCert(ark)...),
+		crlURL:   testCRL(t, thisUpdate, thisUpdate.Add(24*time.Hour)),
+		vcekURL:  ask,
+	}}
+	now := thisUpdate
+	g := &CachingGetter{Getter: network, CRLSafetyMargin: time.Hour, Now: func() time.Time { return now }}
+	get := func(url string) {
+		t.Helper()
+		body, err := g.Get(url)
+		if err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+		if !bytes.Equal(body, network.mapGetter[url]) {
+			t.Errorf("Get(%q) = %x. Want %x", url, body, network.mapGetter[url])
+		}
+	}
+	for _, url := range []string{chainURL, crlURL, vcekURL, chainURL, crlURL, vcekURL} {
+		get(url)
+	}
+	for _, url := range []string{chainURL, crlURL, vcekURL} {
+		if network.gets[url] != 1 {
+			t.Errorf("network fetched %q %d times. Want 1", url, network.gets[url])
+		}
+	}
+	if got, want := g.Stats(), (CacheStats{Hits: 3, Misses: 3}); got != want {
+		t.Errorf("Stats() = %+v. Want %+v", got, want)
+	}
+
+	// The CRL goes stale a safety margin before its NextUpdate, but certificates never do.
+	now = thisUpdate.Add(23 * time.Hour)
+	get(crlURL)
+	get(chainURL)
+	if network.gets[crlURL] != 2 {
+		t.Errorf("network fetched %q %d times after its safety margin. Want 2", crlURL, network.gets[crlURL])
+	}
+	if network.gets[chainURL] != 1 {
+		t.Errorf("network fetched %q %d times. Want 1", chainURL, network.gets[chainURL])
+	}
+
+	g.Invalidate(chainURL)
+	get(chainURL)
+	if network.gets[chainURL] != 2 {
+		t.Errorf("network fetched %q %d times after Invalidate. Want 2", chainURL, network.gets[chainURL])
+	}
+}
+
+func TestCachingGetterUncacheable(t *testing.T) {
+	url := ProductCertChainURL(abi.VcekReportSigner, "Milan")
+	network := &countingGetter{mapGetter: mapGetter{url: []byte("<html>Too many requests</html>")}}
+	g := &CachingGetter{Getter: network}
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(url); err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+	}
+	if network.gets[url] != 2 {
+		t.Errorf("network fetched %q %d times. Want every time for a response that is not a certificate", url, network.gets[url])
+	}
+	if _, err := g.Get(CrlURL("Milan")); err == nil {
+		t.Errorf("Get(missing CRL) = nil. Want the network's error")
+	}
+	if got, want := g.Stats(), (CacheStats{Misses: 3}); got != want {
+		t.Errorf("Stats() = %+v. Want %+v", got, want)
+	}
+}
+
+func TestCachingGetterConcurrent(t *testing.T) {
+	ask, ark := testProductChain(t)
+	url := ProductCertChainURL(abi.VcekReportSigner, "Milan")
+	g := &CachingGetter{Getter: &countingGetter{mapGetter: mapGetter{url: append(pemCert(ask), pemCert(ark)...)}}}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Get(url); err != nil {
+				t.Errorf("Get(%q) = _, %v. Want nil", url, err)
+			}
+			g.Stats()
+		}()
+	}
+	wg.Wait()
+	if stats := g.Stats(); stats.Hits+stats.Misses != 16 {
+		t.Errorf("Stats() = %+v. Want 16 lookups", stats)
+	}
+}
+
+func TestCachingGetterHooks(t *testing.T) {
+	ask, ark := testProductChain(t)
+	url := ProductCertChainURL(abi.VcekReportSigner, "Milan")
+	var hits []string
+	g := &CachingGetter{
+		Getter: mapGetter{url: append(pemCert(ask), pemCert(ark)...)},
+		Hooks:  &Hooks{OnCacheHit: func(url string) { hits = append(hits, url) }},
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(url); err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+	}
+	if len(hits) != 2 || hits[0] != url || hits[1] != url {
+		t.Errorf("OnCacheHit calls = %q. Want %q twice", hits, url)
+	}
+}
+
+func TestCachingGetterBounded(t *testing.T) {
+	ask, ark := testProductChain(t)
+	chain := append(pemCert(ask), pemCert(ark)...)
+	network := &countingGetter{mapGetter: mapGetter{"a": ask, "b": ark, "c": ask, "chain": chain}}
+	g := &CachingGetter{Getter: network, MaxEntries: 2}
+	for _, url := range []string{"a", "b", "a", "c"} {
+		if _, err := g.Get(url); err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+	}
+	// "b" was the least recently used when "c" was cached.
+	if got, want := g.Len(), 2; got != want {
+		t.Errorf("Len() = %d. Want %d", got, want)
+	}
+	if got, want := g.Bytes(), 2*len(ask); got != want {
+		t.Errorf("Bytes() = %d. Want %d", got, want)
+	}
+	for _, url := range []string{"a", "c", "b"} {
+		if _, err := g.Get(url); err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+	}
+	if network.gets["a"] != 1 || network.gets["c"] != 1 || network.gets["b"] != 2 {
+		t.Errorf("network fetches = %v. Want b fetched again after its eviction", network.gets)
+	}
+
+	// A response that does not fit is not cached, and evicts nothing.
+	g = &CachingGetter{Getter: network, MaxBytes: len(ask) + len(ark)}
+	for _, url := range []string{"a", "b", "chain"} {
+		if _, err := g.Get(url); err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+	}
+	if g.Len() != 2 || g.Bytes() != len(ask)+len(ark) {
+		t.Errorf("Len(), Bytes() = %d, %d. Want 2, %d", g.Len(), g.Bytes(), len(ask)+len(ark))
+	}
+	g.Invalidate("a")
+	if g.Len() != 1 || g.Bytes() != len(ark) {
+		t.Errorf("Len(), Bytes() after Invalidate = %d, %d. Want 1, %d", g.Len(), g.Bytes(), len(ark))
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+
+	"github.com/google/go-sev-guest/abi"
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+	"go.uber.org/multierr"
+)
+
+// GetAttestationCerts downloads the certificate chain that verifies report from the KDS: the VCEK
+// or VLEK certificate that the report's SIGNING_KEY says signed it, and the ASK or ASVK and ARK
+// of the product line. The two are fetched in parallel. If either fetch fails, the other is
+// abandoned, e.g., when the KDS does not know the product line, and the error names the fetch
+// that failed. A failed fetch is a *FetchErr, and a response that does not parse is a
+// *MalformedErr.
+func GetAttestationCerts(getter Getter, product *pb.SevProduct, report *pb.Report) (*pb.CertificateChain, error) {
+	return GetAttestationCertsContext(context.Background(), getter, product, report)
+}
+
+// GetAttestationCertsContext is like GetAttestationCerts, but abandons the downloads when ctx is
+// done.
+func GetAttestationCertsContext(parent context.Context, getter Getter, product *pb.SevProduct, report *pb.Report) (*pb.CertificateChain, error) {
+	productLine, err := ProductOf(product)
+	if err != nil {
+		return nil, err
+	}
+	info, err := abi.ParseSignerInfo(report.GetSignerInfo())
+	if err != nil {
+		return nil, err
+	}
+	key := info.SigningKey
+	ekURL, err := ReportCertURL(productLine, key, report.GetChipId(), TCBVersion(report.GetReportedTcb()))
+	if err != nil {
+		return nil, err
+	}
+	return getChain(parent, getter, productLine, key, ekURL)
+}
+
+// CertsForChip downloads the VCEK certificate of the chip with the given CHIP_ID at the given TCB
+// from the KDS, with the ASK and ARK of its product line, e.g., to stage a machine's certificates
+// from inventory before any guest runs on it. The product is a product line, e.g., "Milan", or a
+// product name, e.g., "Milan-B1". The two fetches are in parallel, and fail as GetAttestationCerts
+// does.
+func CertsForChip(getter Getter, product string, hwid []byte, tcb TCBParts) (*pb.CertificateChain, error) {
+	return CertsForChipContext(context.Background(), getter, product, hwid, tcb)
+}
+
+// CertsForChipContext is like CertsForChip, but abandons the downloads when ctx is done.
+func CertsForChipContext(ctx context.Context, getter Getter, product string, hwid []byte, tcb TCBParts) (*pb.CertificateChain, error) {
+	productLine, err := ParseProductType(product)
+	if err != nil {
+		return nil, err
+	}
+	tcbVersion, err := ComposeTCBParts(tcb)
+	if err != nil {
+		return nil, err
+	}
+	ekURL, err := VCEKCertURLForChipID(productLine, hwid, tcbVersion)
+	if err != nil {
+		return nil, err
+	}
+	return getChain(ctx, getter, productLine, abi.VcekReportSigner, ekURL)
+}
+
+// getChain fetches the key's endorsement key certificate at ekURL and the product line's chain in
+// parallel, and abandons the other fetch as soon as one fails.
+func getChain(parent context.Context, getter Getter, productLine Product, key abi.ReportSigner, ekURL string) (*pb.CertificateChain, error) {
+	ica := "ASK"
+	if key == abi.VlekReportSigner {
+		ica = "ASVK"
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	// abandoned returns whether a fetch failed only because the other one failed first.
+	abandoned := func() bool { return ctx.Err() != nil && parent.Err() == nil }
+	var wg sync.WaitGroup
+	var ek []byte
+	var ekErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cert, err := GetCertContext(ctx, getter, ekURL)
+		if err != nil {
+			if !abandoned() {
+				ekErr = fmt.Errorf("could not fetch the %v certificate: %w", key, err)
+				cancel()
+			}
+			return
+		}
+		ek = cert.Raw
+	}()
+	var askCert, arkCert *x509.Certificate
+	var chainErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		askCert, arkCert, err = GetProductChainContext(ctx, getter, key, productLine)
+		if err != nil && !abandoned() {
+			chainErr = fmt.Errorf("could not fetch the %s and ARK certificates: %w", ica, err)
+			cancel()
+		}
+	}()
+	wg.Wait()
+
+	if err := parent.Err(); err != nil {
+		return nil, fmt.Errorf("attestation certificate download abandoned: %w", err)
+	}
+	if err := multierr.Append(ekErr, chainErr); err != nil {
+		return nil, err
+	}
+	chain := &pb.CertificateChain{AskCert: askCert.Raw, ArkCert: arkCert.Raw}
+	if key == abi.VlekReportSigner {
+		chain.VlekCert = ek
+	} else {
+		chain.VcekCert = ek
+	}
+	return chain, nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+func testReport() *pb.Report {
+	chipID := make([]byte, abi.ChipIDSize)
+	chipID[0] = 0x0a
+	return &pb.Report{ChipId: chipID, ReportedTcb: 0x0b000000000018db}
+}
+
+// barrierGetter serves its bodies only once n fetches are in flight at the same time, to show that
+// they are concurrent. A fetch whose body is missing fails at once, and a fetch for a URL in block
+// waits until its context is done.
+type barrierGetter struct {
+	bodies  map[string][]byte
+	block   map[string]bool
+	n       int
+	timeout time.Duration
+
+	mu        sync.Mutex
+	arrived   int
+	all       chan struct{}
+	cancelled []string
+}
+
+func (g *barrierGetter) Get(url string) ([]byte, error) {
+	return g.GetContext(context.Background(), url)
+}
+
+func (g *barrierGetter) GetContext(ctx context.Context, url string) ([]byte, error) {
+	body, ok := g.bodies[url]
+	if !ok {
+		return nil, fmt.Errorf("404: %s", url)
+	}
+	if g.block[url] {
+		<-ctx.Done()
+		g.mu.Lock()
+		g.cancelled = append(g.cancelled, url)
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	g.mu.Lock()
+	g.arrived++
+	if g.arrived == g.n {
+		close(g.all)
+	}
+	g.mu.Unlock()
+	select {
+	case <-g.all:
+		return body, nil
+	case <-time.After(g.timeout):
+		return nil, fmt.Errorf("%s was fetched alone", url)
+	}
+}
+
+func TestGetAttestationCerts(t *testing.T) {
+	ask, ark := testProductChain(t)
+	report := testReport()
+	vcekURL := VCEKCertURL("Milan", report.GetChipId(), TCBVersion(report.GetReportedTcb()))
+	getter := &barrierGetter{
+		bodies: map[string][]byte{
+			vcekURL: ask,
+			ProductCertChainURL(abi.VcekReportSigner, "Milan"): append(pemCert(ask), pemCert(ark)...),
+		},
+		n:       2,
+		timeout: 5 * time.Second,
+		all:     make(chan struct{}),
+	}
+	chain, err := GetAttestationCerts(getter, abi.DefaultSevProduct(), report)
+	if err != nil {
+		t.Fatalf("GetAttestationCerts() = _, %v. Want nil", err)
+	}
+	if !bytes.Equal(chain.GetVcekCert(), ask) || !bytes.Equal(chain.GetAskCert(), ask) || !bytes.Equal(chain.GetArkCert(), ark) {
+		t.Errorf("GetAttestationCerts() = %v. Want the VCEK, ASK, and ARK", chain)
+	}
+	if len(chain.GetVlekCert()) != 0 {
+		t.Errorf("GetAttestationCerts() of a VCEK-signed report has a VLEK certificate")
+	}
+}
+
+func TestGetAttestationCertsShortCircuit(t *testing.T) {
+	ask, _ := testProductChain(t)
+	report := testReport()
+	vcekURL := VCEKCertURL("Milan", report.GetChipId(), TCBVersion(report.GetReportedTcb()))
+	chainURL := ProductCertChainURL(abi.VcekReportSigner, "Milan")
+	// The KDS does not have the product line's chain, and would never answer for the VCEK.
+	getter := &barrierGetter{bodies: map[string][]byte{vcekURL: ask}, block: map[string]bool{vcekURL: true}}
+	_, err := GetAttestationCerts(getter, abi.DefaultSevProduct(), report)
+	var fetchErr *FetchErr
+	if !errors.As(err, &fetchErr) || fetchErr.URL != chainURL {
+		t.Fatalf("GetAttestationCerts() = _, %v. Want a *FetchErr for %q", err, chainURL)
+	}
+	if !strings.Contains(err.Error(), "ASK and ARK") || strings.Contains(err.Error(), "VCEK certificate") {
+		t.Errorf("GetAttestationCerts() = _, %v. Want an error that only names the ASK and ARK fetch", err)
+	}
+	if len(getter.cancelled) != 1 || getter.cancelled[0] != vcekURL {
+		t.Errorf("abandoned fetches = %v. Want the VCEK fetch", getter.cancelled)
+	}
+}
+
+func TestGetAttestationCertsErrors(t *testing.T) {
+	report := testReport()
+	if _, err := GetAttestationCerts(mapGetter{}, &pb.SevProduct{}, report); !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("GetAttestationCerts(unknown product) = _, %v. Want %v", err, ErrUnknownProduct)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GetAttestationCertsContext(ctx, mapGetter{}, abi.DefaultSevProduct(), report); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAttestationCertsContext(cancelled) = _, %v. Want %v", err, context.Canceled)
+	}
+}
+
+func TestCertsForChip(t *testing.T) {
+	ask, ark := testProductChain(t)
+	hwid := testReport().GetChipId()
+	parts := TCBParts{BlSpl: 2, TeeSpl: 0, SnpSpl: 5, UcodeSpl: 68}
+	tcb, err := ComposeTCBParts(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getter := mapGetter{
+		VCEKCertURL("Milan", hwid, tcb):                    ask,
+		ProductCertChainURL(abi.VcekReportSigner, "Milan"): append(pemCert(ask), pemCert(ark)...),
+	}
+	for _, product := range []string{"Milan", "Milan-B1"} {
+		chain, err := CertsForChip(getter, product, hwid, parts)
+		if err != nil {
+			t.Fatalf("CertsForChip(%q) = _, %v. Want nil", product, err)
+		}
+		if !bytes.Equal(chain.GetVcekCert(), ask) || !bytes.Equal(chain.GetAskCert(), ask) || !bytes.Equal(chain.GetArkCert(), ark) {
+			t.Errorf("CertsForChip(%q) = %v. Want the VCEK, ASK, and ARK", product, chain)
+		}
+	}
+}
+
+func TestCertsForChipErrors(t *testing.T) {
+	hwid := testReport().GetChipId()
+	tcs := []struct {
+		name    string
+		product string
+		hwid    []byte
+		tcb     TCBParts
+		wantErr string
+	}{
+		{name: "unknown product", product: "Rome", hwid: hwid, wantErr: ErrUnknownProduct.Error()},
+		{name: "short hwid", product: "Milan", hwid: hwid[:32], wantErr: "CHIP_ID length is 32, want 64"},
+		{name: "masked hwid", product: "Milan", hwid: make([]byte, abi.ChipIDSize), wantErr: ErrChipIDMasked.Error()},
+		{name: "bad tcb", product: "Milan", hwid: hwid, tcb: TCBParts{BlSpl: 200}, wantErr: "BlSpl"},
+		{name: "no certificates", product: "Milan", hwid: hwid, wantErr: "could not fetch"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CertsForChip(mapGetter{}, tc.product, tc.hwid, tc.tcb); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("CertsForChip() = _, %v. Want error %q", err, tc.wantErr)
+			}
+		})
+	}
+}
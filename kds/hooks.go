@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import "time"
+
+// Hooks are callbacks that getters and caches of KDS responses call as they work, e.g., to export
+// request counts, latencies, and error rates to Prometheus or OpenTelemetry. Any of the callbacks,
+// and the Hooks themselves, may be nil. Callbacks are never called while holding a lock, but may
+// be called concurrently.
+type Hooks struct {
+	// OnRequest is called before each request to a URL, including each retry.
+	OnRequest func(url string)
+	// OnResponse is called after each request to a URL with the HTTP status of its response, or 0
+	// if there was none, how long the request took, and the error it failed with, if any.
+	OnResponse func(url string, status int, duration time.Duration, err error)
+	// OnCacheHit is called when a URL is answered from a cache without a request.
+	OnCacheHit func(url string)
+}
+
+// Request calls OnRequest if it is set.
+func (h *Hooks) Request(url string) {
+	if h != nil && h.OnRequest != nil {
+		h.OnRequest(url)
+	}
+}
+
+// Response calls OnResponse if it is set.
+func (h *Hooks) Response(url string, status int, duration time.Duration, err error) {
+	if h != nil && h.OnResponse != nil {
+		h.OnResponse(url, status, duration, err)
+	}
+}
+
+// CacheHit calls OnCacheHit if it is set.
+func (h *Hooks) CacheHit(url string) {
+	if h != nil && h.OnCacheHit != nil {
+		h.OnCacheHit(url)
+	}
+}
@@ -16,15 +16,22 @@
 package kds
 
 import (
+	"bytes"
+	"context"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-sev-guest/abi"
 	pb "github.com/google/go-sev-guest/proto/sevsnp"
@@ -82,7 +89,6 @@ var (
 	kdsCspID         = kdsOID{major: 5}
 
 	kdsHostname = "kdsintf.amd.com"
-	kdsBaseURL  = "https://" + kdsHostname
 	kdsVcekPath = "/vcek/v1/"
 	kdsVlekPath = "/vlek/v1/"
 
@@ -201,7 +207,8 @@ type TCBParts struct {
 }
 
 // ComposeTCBParts returns an SEV-SNP TCB_VERSION from OID mapping values. The spl4-spl7 fields are
-// reserved, but the KDS specification designates them as 4 byte-sized fields.
+// reserved, but the KDS specification designates them as 4 byte-sized fields. Since they are
+// reserved, they must be zero.
 func ComposeTCBParts(parts TCBParts) (TCBVersion, error) {
 	// Only UcodeSpl may be 0-255. All others must be 0-127.
 	check127 := func(name string, value uint8) error {
@@ -210,11 +217,17 @@ func ComposeTCBParts(parts TCBParts) (TCBVersion, error) {
 		}
 		return nil
 	}
+	checkReserved := func(name string, value uint8) error {
+		if value != 0 {
+			return fmt.Errorf("%s TCB part is reserved, but is %d. Expect 0", name, value)
+		}
+		return nil
+	}
 	if err := multierr.Combine(check127("SnpSpl", parts.SnpSpl),
-		check127("Spl7", parts.Spl7),
-		check127("Spl6", parts.Spl6),
-		check127("Spl5", parts.Spl5),
-		check127("Spl4", parts.Spl4),
+		checkReserved("Spl7", parts.Spl7),
+		checkReserved("Spl6", parts.Spl6),
+		checkReserved("Spl5", parts.Spl5),
+		checkReserved("Spl4", parts.Spl4),
 		check127("TeeSpl", parts.TeeSpl),
 		check127("BlSpl", parts.BlSpl),
 	); err != nil {
@@ -246,6 +259,157 @@ func DecomposeTCBVersion(tcb TCBVersion) TCBParts {
 	}
 }
 
+// String returns the TCB parts as space-separated name=value pairs. The reserved parts are only
+// included when non-zero.
+func (p TCBParts) String() string {
+	result := fmt.Sprintf("blSpl=%d teeSpl=%d", p.BlSpl, p.TeeSpl)
+	for i, spl := range []uint8{p.Spl4, p.Spl5, p.Spl6, p.Spl7} {
+		if spl != 0 {
+			result += fmt.Sprintf(" spl%d=%d", i+4, spl)
+		}
+	}
+	return result + fmt.Sprintf(" snpSpl=%d ucodeSpl=%d", p.SnpSpl, p.UcodeSpl)
+}
+
+// tcbPartsJSON is the JSON object representation of TCBParts.
+type tcbPartsJSON struct {
+	BlSpl    uint8 `json:"blSpl"`
+	TeeSpl   uint8 `json:"teeSpl"`
+	Spl4     uint8 `json:"spl4,omitempty"`
+	Spl5     uint8 `json:"spl5,omitempty"`
+	Spl6     uint8 `json:"spl6,omitempty"`
+	Spl7     uint8 `json:"spl7,omitempty"`
+	SnpSpl   uint8 `json:"snpSpl"`
+	UcodeSpl uint8 `json:"ucodeSpl"`
+}
+
+// MarshalJSON returns the TCB parts as a JSON object with blSpl, teeSpl, snpSpl, and ucodeSpl
+// fields.
+func (p TCBParts) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tcbPartsJSON(p))
+}
+
+// UnmarshalJSON populates the TCB parts from either a JSON object of parts or a JSON number of
+// the packed TCB_VERSION.
+func (p *TCBParts) UnmarshalJSON(data []byte) error {
+	var packed uint64
+	if err := json.Unmarshal(data, &packed); err == nil {
+		*p = DecomposeTCBVersion(TCBVersion(packed))
+		return nil
+	}
+	var obj tcbPartsJSON
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&obj); err != nil {
+		return fmt.Errorf("TCB must be a TCB_VERSION number or an object of TCB parts: %v", err)
+	}
+	*p = TCBParts(obj)
+	return nil
+}
+
+// String returns the decomposed TCB parts of the TCB version.
+func (tcb TCBVersion) String() string {
+	return DecomposeTCBVersion(tcb).String()
+}
+
+// MarshalJSON returns the TCB version as a JSON object of its decomposed parts.
+func (tcb TCBVersion) MarshalJSON() ([]byte, error) {
+	return DecomposeTCBVersion(tcb).MarshalJSON()
+}
+
+// UnmarshalJSON populates the TCB version from either a JSON object of parts or a JSON number of
+// the packed TCB_VERSION. The parts must be valid for ComposeTCBParts.
+func (tcb *TCBVersion) UnmarshalJSON(data []byte) error {
+	var parts TCBParts
+	if err := parts.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	result, err := ComposeTCBParts(parts)
+	if err != nil {
+		return err
+	}
+	*tcb = result
+	return nil
+}
+
+// TCBOrder is the result of a component-wise comparison of two TCB versions. TCB versions are
+// only partially ordered, so a numerical comparison of their uint64 representations is wrong.
+type TCBOrder int
+
+const (
+	// TCBLessOrEqual means all components of the left TCB are <= the corresponding components of
+	// the right TCB.
+	TCBLessOrEqual TCBOrder = iota
+	// TCBGreater means all components of the left TCB are >= the corresponding components of the
+	// right TCB, and at least one is strictly greater.
+	TCBGreater
+	// TCBIncomparable means some components of the left TCB are lower and some components are
+	// higher than the corresponding components of the right TCB.
+	TCBIncomparable
+)
+
+func (o TCBOrder) String() string {
+	switch o {
+	case TCBLessOrEqual:
+		return "less-or-equal"
+	case TCBGreater:
+		return "greater"
+	case TCBIncomparable:
+		return "incomparable"
+	}
+	return fmt.Sprintf("TCBOrder(%d)", int(o))
+}
+
+type tcbComponent struct {
+	name        string
+	left, right uint8
+}
+
+func tcbComponents(tcb0, tcb1 TCBParts) []tcbComponent {
+	return []tcbComponent{
+		{name: "BlSpl", left: tcb0.BlSpl, right: tcb1.BlSpl},
+		{name: "TeeSpl", left: tcb0.TeeSpl, right: tcb1.TeeSpl},
+		{name: "Spl4", left: tcb0.Spl4, right: tcb1.Spl4},
+		{name: "Spl5", left: tcb0.Spl5, right: tcb1.Spl5},
+		{name: "Spl6", left: tcb0.Spl6, right: tcb1.Spl6},
+		{name: "Spl7", left: tcb0.Spl7, right: tcb1.Spl7},
+		{name: "SnpSpl", left: tcb0.SnpSpl, right: tcb1.SnpSpl},
+		{name: "UcodeSpl", left: tcb0.UcodeSpl, right: tcb1.UcodeSpl},
+	}
+}
+
+// CompareTCBParts returns how tcb0 relates to tcb1 when compared component-wise.
+func CompareTCBParts(tcb0, tcb1 TCBParts) TCBOrder {
+	var anyLess, anyGreater bool
+	for _, c := range tcbComponents(tcb0, tcb1) {
+		if c.left < c.right {
+			anyLess = true
+		}
+		if c.left > c.right {
+			anyGreater = true
+		}
+	}
+	if !anyGreater {
+		return TCBLessOrEqual
+	}
+	if anyLess {
+		return TCBIncomparable
+	}
+	return TCBGreater
+}
+
+// TCBPartsGreaterComponents returns the names of the TCB components of tcb0 that are greater than the
+// corresponding tcb1 components, in the order they appear in TCBParts.
+func TCBPartsGreaterComponents(tcb0, tcb1 TCBParts) []string {
+	var result []string
+	for _, c := range tcbComponents(tcb0, tcb1) {
+		if c.left > c.right {
+			result = append(result, c.name)
+		}
+	}
+	return result
+}
+
 // TCBPartsLE returns true iff all TCB components of tcb0 are <= the corresponding tcb1 components.
 func TCBPartsLE(tcb0, tcb1 TCBParts) bool {
 	return (tcb0.UcodeSpl <= tcb1.UcodeSpl) &&
@@ -262,6 +426,15 @@ func asn1U8(ext *pkix.Extension, field string, out *uint8) error {
 	if ext == nil {
 		return fmt.Errorf("no extension for field %s", field)
 	}
+	// Some KDS certificates encode the value as a one-byte OCTET STRING rather than an INTEGER.
+	if len(ext.Value) > 0 && ext.Value[0] == asn1.TagOctetString {
+		octet, err := asn1OctetString(ext, field, 1)
+		if err != nil {
+			return err
+		}
+		*out = octet[0]
+		return nil
+	}
 	var i int
 	rest, err := asn1.Unmarshal(ext.Value, &i)
 	if err != nil {
@@ -456,34 +629,283 @@ func CertificateExtensions(cert *x509.Certificate, key abi.ReportSigner) (*Exten
 }
 
 // ParseProductCertChain returns the DER-formatted certificates represented by the body
-// of the ProductCertChain (cert_chain) endpoint, ASK and ARK in that order.
+// of the ProductCertChain (cert_chain) endpoint, ASK and ARK in that order. It accepts the same
+// bodies as ParseProductCertChainCerts.
 func ParseProductCertChain(pems []byte) ([]byte, []byte, error) {
-	checkForm := func(name string, b *pem.Block) error {
-		if b == nil {
-			return fmt.Errorf("could not find %s PEM block", name)
+	ask, ark, err := ParseProductCertChainCerts(pems)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ask.Raw, ark.Raw, nil
+}
+
+// ParseProductCertChainCerts returns the certificates represented by the body of the
+// ProductCertChain (cert_chain) endpoint: the AS[V]K and the ARK. The KDS sends the AS[V]K first,
+// but a body with the self-signed ARK first is also accepted, as is whitespace around the PEM
+// blocks. It does not check the signatures.
+func ParseProductCertChainCerts(pems []byte) (*x509.Certificate, *x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := bytes.TrimSpace(pems)
+	for len(rest) != 0 {
+		block, next := pem.Decode(rest)
+		if block == nil {
+			return nil, nil, fmt.Errorf("unexpected trailing bytes: %d bytes", len(rest))
+		}
+		rest = bytes.TrimSpace(next)
+		name := "ASK or ASVK"
+		if len(certs) > 0 {
+			name = "ARK"
 		}
-		if b.Type != "CERTIFICATE" {
-			return fmt.Errorf("the %s PEM block type is %s. Expect CERTIFICATE", name, b.Type)
+		if block.Type != "CERTIFICATE" {
+			return nil, nil, fmt.Errorf("the %s PEM block type is %s. Expect CERTIFICATE", name, block.Type)
+		}
+		if len(block.Headers) != 0 {
+			return nil, nil, fmt.Errorf("the %s PEM block has non-empty headers: %v", name, block.Headers)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse the %s certificate: %v", name, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) != 2 {
+		return nil, nil, fmt.Errorf("cert_chain has %d certificates. Expected 2, the ASK or ASVK and the ARK", len(certs))
+	}
+	ask, ark := certs[0], certs[1]
+	if selfIssued(ask) && !selfIssued(ark) {
+		ask, ark = ark, ask
+	}
+	return ask, ark, nil
+}
+
+func selfIssued(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer)
+}
+
+// FetchErr is returned when a KDS resource could not be downloaded, e.g., because the network or
+// the KDS is unavailable. The request may succeed later.
+type FetchErr struct {
+	URL string
+	Err error
+}
+
+func (e *FetchErr) Error() string {
+	return fmt.Sprintf("could not download %s: %v", e.URL, e.Err)
+}
+
+func (e *FetchErr) Unwrap() error {
+	return e.Err
+}
+
+// HTTPError is returned by the HTTP getters, e.g., trust.HTTPClientGetter, when a request got a
+// failing status or no response at all, so that operators and retry logic can tell a 404 Not
+// Found for an unknown chip from a 403 Forbidden by an egress proxy, a 429 Too Many Requests, a
+// 5xx, or a TLS failure.
+type HTTPError struct {
+	URL string
+	// StatusCode is the status of the response, or 0 if there was no response.
+	StatusCode int
+	// Body is the start of the response body, e.g., a proxy's explanation of a 403.
+	Body []byte
+	// RetryAfter is the wait that the response's Retry-After header asks for, or 0 if none.
+	RetryAfter time.Duration
+	// Err is the transport error if there was no response, e.g., a TLS certificate error.
+	Err error
+}
+
+func (e *HTTPError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("failed to retrieve '%s': %v", e.URL, e.Err)
+	}
+	msg := fmt.Sprintf("failed to retrieve '%s' status %d", e.URL, e.StatusCode)
+	if body := bytes.TrimSpace(e.Body); len(body) > 0 {
+		suffix := ""
+		if len(body) > maxHTTPErrorBody {
+			body, suffix = body[:maxHTTPErrorBody], "..."
 		}
-		if len(b.Headers) != 0 {
-			return fmt.Errorf("the %s PEM block has non-empty headers: %v", name, b.Headers)
+		msg += fmt.Sprintf(": %q%s", body, suffix)
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// maxHTTPErrorBody is the most bytes of an HTTPError's body that its message shows.
+const maxHTTPErrorBody = 256
+
+// Transient returns whether the request may succeed if retried: the KDS was overloaded (429 Too
+// Many Requests) or failing (5xx), or there was no response for a reason other than the server's
+// TLS certificate not verifying.
+func (e *HTTPError) Transient() bool {
+	if e.StatusCode == 0 {
+		var unknownAuthority x509.UnknownAuthorityError
+		var invalid x509.CertificateInvalidError
+		var hostname x509.HostnameError
+		return !errors.As(e.Err, &unknownAuthority) && !errors.As(e.Err, &invalid) && !errors.As(e.Err, &hostname)
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// MalformedErr is returned when a downloaded KDS resource could not be parsed. Unlike a FetchErr,
+// this is not expected to go away by retrying, unless the download was truncated.
+type MalformedErr struct {
+	URL string
+	Err error
+	// ContentType is the sniffed content type of the body, e.g., "text/html; charset=utf-8" for
+	// the error page of a captive portal.
+	ContentType string
+	// Size is the length of the body.
+	Size int
+	// Prefix is the start of the body, at most maxMalformedPrefix bytes of it.
+	Prefix []byte
+}
+
+// maxMalformedPrefix is how much of a malformed body a MalformedErr keeps.
+const maxMalformedPrefix = 32
+
+// malformed returns a MalformedErr for the body of url that failed to parse with err.
+func malformed(url string, body []byte, err error) *MalformedErr {
+	prefix := body
+	if len(prefix) > maxMalformedPrefix {
+		prefix = prefix[:maxMalformedPrefix]
+	}
+	return &MalformedErr{
+		URL:         url,
+		Err:         err,
+		ContentType: http.DetectContentType(body),
+		Size:        len(body),
+		Prefix:      append([]byte(nil), prefix...),
+	}
+}
+
+func (e *MalformedErr) Error() string {
+	if e.ContentType == "" {
+		return fmt.Sprintf("%s: %v", e.URL, e.Err)
+	}
+	ellipsis := ""
+	if e.Size > len(e.Prefix) {
+		ellipsis = "..."
+	}
+	return fmt.Sprintf("%s: %v (got %d bytes of %s: %x%s)", e.URL, e.Err, e.Size, e.ContentType, e.Prefix, ellipsis)
+}
+
+func (e *MalformedErr) Unwrap() error {
+	return e.Err
+}
+
+// GetProductChain downloads the cert_chain of the given product line for the given signing key
+// through getter and returns its AS[V]K and ARK certificates. A failed download is a *FetchErr and
+// an unparsable body is a *MalformedErr.
+func GetProductChain(getter Getter, key abi.ReportSigner, productLine Product) (*x509.Certificate, *x509.Certificate, error) {
+	return GetProductChainContext(context.Background(), getter, key, productLine)
+}
+
+// GetProductChainContext is like GetProductChain, but abandons the download when ctx is done.
+func GetProductChainContext(ctx context.Context, getter Getter, key abi.ReportSigner, productLine Product) (*x509.Certificate, *x509.Certificate, error) {
+	url := ProductCertChainURL(key, productLine)
+	body, err := getContext(ctx, getter, url)
+	if err != nil {
+		return nil, nil, &FetchErr{URL: url, Err: err}
+	}
+	ask, ark, err := ParseProductCertChainCerts(body)
+	if err != nil {
+		return nil, nil, malformed(url, body, err)
+	}
+	return ask, ark, nil
+}
+
+// GetCert downloads the certificate at url through getter, e.g., a VCEK certificate at a
+// VCEKCertURL or a VLEK certificate at a VLEKCertURL. The KDS serves them DER-encoded, but a single
+// PEM CERTIFICATE block is also accepted. A failed download is a *FetchErr and a body that is not
+// a certificate is a *MalformedErr.
+func GetCert(getter Getter, url string) (*x509.Certificate, error) {
+	return GetCertContext(context.Background(), getter, url)
+}
+
+// GetCertContext is like GetCert, but abandons the download when ctx is done.
+func GetCertContext(ctx context.Context, getter Getter, url string) (*x509.Certificate, error) {
+	body, err := getContext(ctx, getter, url)
+	if err != nil {
+		return nil, &FetchErr{URL: url, Err: err}
+	}
+	der := body
+	if block, rest := pem.Decode(body); block != nil {
+		if block.Type != "CERTIFICATE" || len(bytes.TrimSpace(rest)) != 0 {
+			return nil, malformed(url, body, fmt.Errorf("PEM block type is %s with %d trailing bytes. Expected a single CERTIFICATE", block.Type, len(rest)))
 		}
+		der = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, malformed(url, body, fmt.Errorf("not a certificate: %v", err))
+	}
+	return cert, nil
+}
+
+// DefaultBaseURL is the scheme and host of the AMD KDS that the URL builders use unless SetBaseURL
+// names a mirror.
+const DefaultBaseURL = "https://kdsintf.amd.com"
+
+var (
+	mirrorMu sync.RWMutex
+	// mirror is the scheme and host that SetBaseURL set, or nil for DefaultBaseURL.
+	mirror *url.URL
+)
+
+// SetBaseURL makes every KDS URL builder in this package, e.g., VCEKCertURL, VLEKCertURL,
+// ProductCertChainURL, and CrlURL, use base in place of DefaultBaseURL, so that certificates and
+// CRLs come from a private mirror of the KDS. The mirror must serve the KDS paths and queries
+// unchanged, e.g., as a reverse proxy, so base is only a scheme and host, as in
+// "https://kds.example.com:8443". The URL parsers accept URLs on either host. An empty base
+// restores DefaultBaseURL.
+//
+// The base URL is shared by the whole process, so set it once before any fetches.
+func SetBaseURL(base string) error {
+	if base == "" {
+		mirrorMu.Lock()
+		mirror = nil
+		mirrorMu.Unlock()
 		return nil
 	}
-	askBlock, arkRest := pem.Decode(pems)
-	arkBlock, noRest := pem.Decode(arkRest)
-	if err := multierr.Combine(checkForm("ASK or ASVK", askBlock), checkForm("ARK", arkBlock)); err != nil {
-		return nil, nil, err
+	u, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid KDS base URL %q: %v", base, err)
 	}
-	if len(noRest) != 0 {
-		return nil, nil, fmt.Errorf("unexpected trailing bytes: %d bytes", len(noRest))
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("KDS base URL scheme is %q. Expected \"https\" or \"http\"", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("KDS base URL %q has no host", base)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("KDS base URL %q is not only a scheme and host. Mirrors must serve the KDS paths unchanged", base)
+	}
+	mirrorMu.Lock()
+	mirror = &url.URL{Scheme: u.Scheme, Host: u.Host}
+	mirrorMu.Unlock()
+	return nil
+}
+
+// BaseURL returns the scheme and host that the KDS URL builders use: DefaultBaseURL, or the
+// mirror that SetBaseURL set.
+func BaseURL() string {
+	if m := mirrorURL(); m != nil {
+		return m.String()
 	}
-	return askBlock.Bytes, arkBlock.Bytes, nil
+	return DefaultBaseURL
+}
+
+func mirrorURL() *url.URL {
+	mirrorMu.RLock()
+	defer mirrorMu.RUnlock()
+	return mirror
 }
 
 // productBaseURL returns the base URL for all certificate queries within a particular product for the
 // given report signer kind.
-func productBaseURL(s abi.ReportSigner, name string) string {
+func productBaseURL(s abi.ReportSigner, name Product) string {
 	path := "unknown"
 	if s == abi.VcekReportSigner {
 		path = kdsVcekPath
@@ -491,22 +913,22 @@ func productBaseURL(s abi.ReportSigner, name string) string {
 	if s == abi.VlekReportSigner {
 		path = kdsVlekPath
 	}
-	return fmt.Sprintf("%s%s%s", kdsBaseURL, path, name)
+	return fmt.Sprintf("%s%s%s", BaseURL(), path, name)
 }
 
 // ProductCertChainURL returns the AMD KDS URL for retrieving the ARK and AS(V)K
 // certificates on the given product in ??? format.
-func ProductCertChainURL(s abi.ReportSigner, productLine string) string {
+func ProductCertChainURL(s abi.ReportSigner, productLine Product) string {
 	return fmt.Sprintf("%s/cert_chain", productBaseURL(s, productLine))
 }
 
 // VCEKCertURL returns the AMD KDS URL for retrieving the VCEK on a given product
 // at a given TCB version. The hwid is the CHIP_ID field in an attestation report.
-func VCEKCertURL(productLine string, hwid []byte, tcb TCBVersion) string {
+func VCEKCertURL(productLine Product, hwid []byte, tcb TCBVersion) string {
 	parts := DecomposeTCBVersion(tcb)
 	return fmt.Sprintf("%s/%s?blSPL=%d&teeSPL=%d&snpSPL=%d&ucodeSPL=%d",
 		productBaseURL(abi.VcekReportSigner, productLine),
-		hex.EncodeToString(hwid),
+		abi.ChipIDString(hwid),
 		parts.BlSpl,
 		parts.TeeSpl,
 		parts.SnpSpl,
@@ -514,9 +936,26 @@ func VCEKCertURL(productLine string, hwid []byte, tcb TCBVersion) string {
 	)
 }
 
+// ErrChipIDMasked is returned when a VCEK certificate URL is requested for a CHIP_ID of all zeros,
+// which the firmware reports when the host enables MaskChipId. The KDS cannot serve a VCEK for it.
+var ErrChipIDMasked = errors.New("CHIP_ID is masked by the host")
+
+// VCEKCertURLForChipID returns the AMD KDS URL for retrieving the VCEK on a given product at a
+// given TCB version like VCEKCertURL, but errors if chipID cannot identify a chip. A masked
+// CHIP_ID results in ErrChipIDMasked.
+func VCEKCertURLForChipID(productLine Product, chipID []byte, tcb TCBVersion) (string, error) {
+	if len(chipID) != abi.ChipIDSize {
+		return "", fmt.Errorf("CHIP_ID length is %d, want %d", len(chipID), abi.ChipIDSize)
+	}
+	if abi.IsChipIDMasked(chipID) {
+		return "", ErrChipIDMasked
+	}
+	return VCEKCertURL(productLine, chipID, tcb), nil
+}
+
 // VLEKCertURL returns the GET URL for retrieving a VLEK certificate, but without the necessary
 // CSP secret in the HTTP headers that makes the request validate to the KDS.
-func VLEKCertURL(productLine string, tcb TCBVersion) string {
+func VLEKCertURL(productLine Product, tcb TCBVersion) string {
 	parts := DecomposeTCBVersion(tcb)
 	return fmt.Sprintf("%s/cert?blSPL=%d&teeSPL=%d&snpSPL=%d&ucodeSPL=%d",
 		productBaseURL(abi.VlekReportSigner, productLine),
@@ -527,6 +966,59 @@ func VLEKCertURL(productLine string, tcb TCBVersion) string {
 	)
 }
 
+// VCEKCertURLForReport returns the AMD KDS URL for retrieving the VCEK that signed a raw
+// attestation report, from its CHIP_ID and REPORTED_TCB. Bytes after the report, e.g., a
+// certificate table, are ignored. Errors if the report is too short or was not signed by the VCEK,
+// and with ErrChipIDMasked if the host masks the CHIP_ID.
+func VCEKCertURLForReport(productLine Product, report []byte) (string, error) {
+	if len(report) < abi.ReportSize {
+		return "", fmt.Errorf("report is %d bytes. Expected %d", len(report), abi.ReportSize)
+	}
+	signerInfo, err := abi.ReportSignerInfo(report)
+	if err != nil {
+		return "", err
+	}
+	chipID, err := abi.ReportChipID(report)
+	if err != nil {
+		return "", err
+	}
+	reportedTcb, err := abi.ReportReportedTcb(report)
+	if err != nil {
+		return "", err
+	}
+	return vcekCertURLForSigner(productLine, signerInfo, chipID, TCBVersion(reportedTcb))
+}
+
+// VCEKCertURLForReportProto is like VCEKCertURLForReport for a parsed attestation report.
+func VCEKCertURLForReportProto(productLine Product, report *pb.Report) (string, error) {
+	return vcekCertURLForSigner(productLine, report.GetSignerInfo(), report.GetChipId(), TCBVersion(report.GetReportedTcb()))
+}
+
+func vcekCertURLForSigner(productLine Product, signerInfo uint32, chipID []byte, reportedTcb TCBVersion) (string, error) {
+	info, err := abi.ParseSignerInfo(signerInfo)
+	if err != nil {
+		return "", err
+	}
+	if info.SigningKey != abi.VcekReportSigner {
+		return "", fmt.Errorf("report is signed by %v. Expected VCEK", info.SigningKey)
+	}
+	return VCEKCertURLForChipID(productLine, chipID, reportedTcb)
+}
+
+// ReportCertURL returns the AMD KDS URL for retrieving the certificate of the key that signed a
+// report: the VCEKCertURLForChipID for its CHIP_ID and REPORTED_TCB if the VCEK signed it, or the
+// VLEKCertURL for its REPORTED_TCB if a VLEK signed it. A VLEK-signed report's CHIP_ID never
+// selects its certificate, so it does not get a VCEK URL. Errors for any other signer.
+func ReportCertURL(productLine Product, key abi.ReportSigner, chipID []byte, reportedTcb TCBVersion) (string, error) {
+	switch key {
+	case abi.VcekReportSigner:
+		return VCEKCertURLForChipID(productLine, chipID, reportedTcb)
+	case abi.VlekReportSigner:
+		return VLEKCertURL(productLine, reportedTcb), nil
+	}
+	return "", fmt.Errorf("the KDS serves no certificate for a report signed by %v", key)
+}
+
 // VCEKCert represents the attestation report components represented in a KDS VCEK certificate
 // request URL.
 type VCEKCert struct {
@@ -539,6 +1031,11 @@ type VCEKCert struct {
 	TCB         uint64
 }
 
+// TCBParts returns the SPLs of the TCB version in the URL.
+func (c VCEKCert) TCBParts() TCBParts {
+	return DecomposeTCBVersion(TCBVersion(c.TCB))
+}
+
 // VCEKCertProduct returns a VCEKCert with the product line set to productLine.
 func VCEKCertProduct(productLine string) VCEKCert {
 	return VCEKCert{
@@ -583,11 +1080,17 @@ func parseBaseProductURL(kdsurl string) (*parsedURL, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid AMD KDS URL %q: %v", kdsurl, err)
 	}
-	if u.Scheme != "https" {
-		return nil, fmt.Errorf("unexpected AMD KDS URL scheme %q, want \"https\"", u.Scheme)
-	}
-	if u.Host != kdsHostname {
-		return nil, fmt.Errorf("unexpected AMD KDS URL host %q, want %q", u.Host, kdsHostname)
+	// A URL may be on the AMD KDS itself or on the mirror that SetBaseURL set.
+	if m := mirrorURL(); m == nil || u.Scheme != m.Scheme || u.Host != m.Host {
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("unexpected AMD KDS URL scheme %q, want \"https\"", u.Scheme)
+		}
+		if u.Host != kdsHostname {
+			if m != nil {
+				return nil, fmt.Errorf("unexpected AMD KDS URL host %q, want %q or mirror %q", u.Host, kdsHostname, m.Host)
+			}
+			return nil, fmt.Errorf("unexpected AMD KDS URL host %q, want %q", u.Host, kdsHostname)
+		}
 	}
 	result := &parsedURL{}
 	vcekFunc := strings.HasPrefix(u.Path, kdsVcekPath)
@@ -629,6 +1132,25 @@ func ParseProductCertChainURL(kdsurl string) (string, CertFunction, error) {
 	return parsed.productLine, parsed.function, nil
 }
 
+// ParseCRLURL returns the product line and the report signer whose certificate chain a KDS crl
+// url, e.g., from CrlLinkByKey, is for, or an error if the input is not a KDS crl url.
+func ParseCRLURL(kdsurl string) (string, abi.ReportSigner, error) {
+	parsed, err := parseBaseProductURL(kdsurl)
+	if err != nil {
+		return "", abi.NoneReportSigner, err
+	}
+	if parsed.simpleURL.Path != "crl" {
+		return "", abi.NoneReportSigner, fmt.Errorf("unexpected AMD KDS URL path %q, want \"crl\"", parsed.simpleURL.Path)
+	}
+	if parsed.simpleURL.RawQuery != "" {
+		return "", abi.NoneReportSigner, fmt.Errorf("unexpected AMD KDS crl URL query %q, want none", parsed.simpleURL.RawQuery)
+	}
+	if parsed.function == VlekCertFunction {
+		return parsed.productLine, abi.VlekReportSigner, nil
+	}
+	return parsed.productLine, abi.VcekReportSigner, nil
+}
+
 func parseTCBURL(u *url.URL) (uint64, error) {
 	values, err := url.ParseQuery(u.RawQuery)
 	if err != nil {
@@ -657,6 +1179,12 @@ func parseTCBURL(u *url.URL) (uint64, error) {
 			setter(uint8(number))
 		}
 	}
+	// The KDS URL builders give each SPL exactly once, so anything else is not a KDS URL.
+	for _, key := range []string{"blSPL", "teeSPL", "snpSPL", "ucodeSPL"} {
+		if n := len(values[key]); n != 1 {
+			return 0, fmt.Errorf("KDS TCB version URL argument %q appears %d times, want 1", key, n)
+		}
+	}
 	tcb, err := ComposeTCBParts(parts)
 	if err != nil {
 		return 0, fmt.Errorf("invalid AMD KDS TCB arguments: %v", err)
@@ -721,7 +1249,7 @@ func ProductString(product *pb.SevProduct) string {
 }
 
 // ProductLine returns the KDS product argument to use for the product associated with
-// an attestation report proto.
+// an attestation report proto. A nil product is the default product.
 func ProductLine(product *pb.SevProduct) string {
 	if product == nil {
 		product = abi.DefaultSevProduct()
@@ -731,11 +1259,31 @@ func ProductLine(product *pb.SevProduct) string {
 		return "Milan"
 	case pb.SevProduct_SEV_PRODUCT_GENOA:
 		return "Genoa"
+	case pb.SevProduct_SEV_PRODUCT_TURIN:
+		return "Turin"
 	default:
 		return "Unknown"
 	}
 }
 
+// ErrUnknownProduct is returned when a product, product line, or productName extension value does
+// not identify an AMD SEV product that the KDS serves certificates for.
+var ErrUnknownProduct = errors.New("unknown AMD SEV product")
+
+// KDSProductLine returns the KDS product argument to use for the product like ProductLine, but
+// errors with ErrUnknownProduct instead of returning "Unknown", and for a nil product instead of
+// assuming the default product.
+func KDSProductLine(product *pb.SevProduct) (string, error) {
+	if product == nil {
+		return "", fmt.Errorf("%w: no product information", ErrUnknownProduct)
+	}
+	productLine := ProductLine(product)
+	if productLine == "Unknown" {
+		return "", fmt.Errorf("%w: %v", ErrUnknownProduct, product.GetName())
+	}
+	return productLine, nil
+}
+
 // ProductLineOfProductName returns the product represented by productNameOrProductLine, i.e.,
 // without the stepping suffix.
 func ProductLineOfProductName(productNameOrProductLine string) string {
@@ -762,7 +1310,7 @@ func DefaultProductLine() string {
 }
 
 // ProductName returns the expected productName extension value for the product associated
-// with an attestation report proto.
+// with an attestation report proto. A nil product is the default product.
 func ProductName(product *pb.SevProduct) string {
 	if product == nil {
 		product = abi.DefaultSevProduct()
@@ -785,7 +1333,7 @@ func ProductName(product *pb.SevProduct) string {
 		if int(stepping) >= len(genoaSteppingVersions) {
 			return "unmappedGenoaStepping"
 		}
-		return fmt.Sprintf("Milan-%s", genoaSteppingVersions[stepping])
+		return fmt.Sprintf("Genoa-%s", genoaSteppingVersions[stepping])
 	default:
 		return "Unknown"
 	}
@@ -803,14 +1351,17 @@ func ParseProduct(productLine string) (pb.SevProduct_SevProductName, error) {
 }
 
 // ParseProductLine returns the SevProductName for a product name without the stepping suffix.
+// Bergamo and Siena share Genoa's KDS certificate hierarchy, so they parse as Genoa.
 func ParseProductLine(productLine string) (*pb.SevProduct, error) {
 	switch productLine {
 	case "Milan":
 		return &pb.SevProduct{Name: pb.SevProduct_SEV_PRODUCT_MILAN}, nil
-	case "Genoa":
+	case "Genoa", "Bergamo", "Siena":
 		return &pb.SevProduct{Name: pb.SevProduct_SEV_PRODUCT_GENOA}, nil
+	case "Turin":
+		return &pb.SevProduct{Name: pb.SevProduct_SEV_PRODUCT_TURIN}, nil
 	default:
-		return nil, fmt.Errorf("unknown AMD SEV product: %q", productLine)
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProduct, productLine)
 	}
 }
 
@@ -821,7 +1372,7 @@ func ParseProductName(productName string, key abi.ReportSigner) (*pb.SevProduct,
 	case abi.VcekReportSigner:
 		product, ok := steppingDecoder[productName]
 		if !ok {
-			return nil, fmt.Errorf("unknown product name (new stepping published?): %q", productName)
+			return nil, fmt.Errorf("%w: unknown product name (new stepping published?): %q", ErrUnknownProduct, productName)
 		}
 		return product, nil
 	case abi.VlekReportSigner:
@@ -834,17 +1385,84 @@ func ParseProductName(productName string, key abi.ReportSigner) (*pb.SevProduct,
 // CrlLinkByKey returns the CRL distribution point for the given key type's
 // product. If key is VlekReportSigner, then we use the vlek endpoint. The ASK
 // and ARK are both on the vcek endpoint.
-func CrlLinkByKey(productLine string, key abi.ReportSigner) string {
+func CrlLinkByKey(productLine Product, key abi.ReportSigner) string {
 	return fmt.Sprintf("%s/crl", productBaseURL(key, productLine))
 }
 
 // CrlLinkByRole returns the CRL distribution point for the given key role's
 // product. If role is "ASVK", then we use the vlek endpoint. The ASK and ARK
 // are both on the vcek endpoint.
-func CrlLinkByRole(productLine, role string) string {
+func CrlLinkByRole(productLine Product, role string) string {
 	key := abi.VcekReportSigner
 	if role == "ASVK" {
 		key = abi.VlekReportSigner
 	}
 	return CrlLinkByKey(productLine, key)
 }
+
+// CrlURL returns the AMD KDS URL for retrieving the CRL that the ARK of the given product line
+// issues for its ASK and VCEKs. The VLEK chain's CRL is at CrlLinkByKey with VlekReportSigner.
+func CrlURL(productLine Product) string {
+	return CrlLinkByKey(productLine, abi.VcekReportSigner)
+}
+
+// Getter fetches the body of a URL. Any trust.HTTPSGetter is a Getter.
+type Getter interface {
+	Get(url string) ([]byte, error)
+}
+
+// ContextGetter is a Getter whose fetches can be abandoned. Any trust.ContextHTTPSGetter is a
+// ContextGetter.
+type ContextGetter interface {
+	Getter
+	// GetContext is like Get, but returns an error wrapping ctx.Err() if ctx is done before the
+	// body is fetched.
+	GetContext(ctx context.Context, url string) ([]byte, error)
+}
+
+// getContext fetches url through getter unless ctx is done first. Getters that do not implement
+// ContextGetter are only checked before the fetch.
+func getContext(ctx context.Context, getter Getter, url string) ([]byte, error) {
+	if cg, ok := getter.(ContextGetter); ok {
+		return cg.GetContext(ctx, url)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return getter.Get(url)
+}
+
+// ParseCRL parses a DER-encoded KDS certificate revocation list. The list's ThisUpdate and
+// NextUpdate say when it was issued and when the next one is due. It is an error if the list has
+// no NextUpdate, since then there is no telling when it goes stale. ParseCRL does not check the
+// list's signature.
+func ParseCRL(der []byte) (*x509.RevocationList, error) {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid CRL: %v", err)
+	}
+	if crl.NextUpdate.IsZero() {
+		return nil, errors.New("CRL has no nextUpdate")
+	}
+	return crl, nil
+}
+
+// GetCRL downloads the CRL at url, e.g., CrlURL(productLine), through getter and parses it with
+// ParseCRL. A failed download is a *FetchErr and an invalid CRL is a *MalformedErr. It does not
+// check the list's signature.
+func GetCRL(getter Getter, url string) (*x509.RevocationList, error) {
+	return GetCRLContext(context.Background(), getter, url)
+}
+
+// GetCRLContext is like GetCRL, but abandons the download when ctx is done.
+func GetCRLContext(ctx context.Context, getter Getter, url string) (*x509.RevocationList, error) {
+	der, err := getContext(ctx, getter, url)
+	if err != nil {
+		return nil, &FetchErr{URL: url, Err: err}
+	}
+	crl, err := ParseCRL(der)
+	if err != nil {
+		return nil, malformed(url, der, err)
+	}
+	return crl, nil
+}
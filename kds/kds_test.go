@@ -15,11 +15,26 @@
 package kds
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
+	"net/http"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-sev-guest/abi"
@@ -47,6 +62,193 @@ func TestVCEKCertURL(t *testing.T) {
 	}
 }
 
+func TestVCEKCertURLForChipID(t *testing.T) {
+	hwid := make([]byte, abi.ChipIDSize)
+	hwid[0] = 0xfe
+	got, err := VCEKCertURLForChipID("Milan", hwid, TCBVersion(0))
+	if err != nil {
+		t.Fatalf("VCEKCertURLForChipID(\"Milan\", %v, 0) = _, %v. Expected success", hwid, err)
+	}
+	if want := VCEKCertURL("Milan", hwid, TCBVersion(0)); got != want {
+		t.Errorf("VCEKCertURLForChipID(\"Milan\", %v, 0) = %q, want %q", hwid, got, want)
+	}
+	if _, err := VCEKCertURLForChipID("Milan", make([]byte, abi.ChipIDSize), TCBVersion(0)); !errors.Is(err, ErrChipIDMasked) {
+		t.Errorf("VCEKCertURLForChipID(\"Milan\", zeros, 0) = _, %v, want %v", err, ErrChipIDMasked)
+	}
+	wantErr := "CHIP_ID length is 3, want 64"
+	if _, err := VCEKCertURLForChipID("Milan", []byte{1, 2, 3}, TCBVersion(0)); err == nil || err.Error() != wantErr {
+		t.Errorf("VCEKCertURLForChipID(\"Milan\", short, 0) = _, %v, want %q", err, wantErr)
+	}
+}
+
+func TestComposeTCBParts(t *testing.T) {
+	tcs := []struct {
+		name    string
+		parts   TCBParts
+		want    TCBVersion
+		wantErr string
+	}{
+		{
+			name: "zero",
+		},
+		{
+			name:  "all components",
+			parts: TCBParts{BlSpl: 1, TeeSpl: 2, SnpSpl: 0x7f, UcodeSpl: 0xff},
+			want:  TCBVersion(0xff7f000000000201),
+		},
+		{
+			name:    "BlSpl out of range",
+			parts:   TCBParts{BlSpl: 128},
+			wantErr: "BlSpl TCB part is 128. Expect 0-127",
+		},
+		{
+			name:    "SnpSpl out of range",
+			parts:   TCBParts{SnpSpl: 200},
+			wantErr: "SnpSpl TCB part is 200. Expect 0-127",
+		},
+		{
+			name:    "reserved Spl4",
+			parts:   TCBParts{Spl4: 1},
+			wantErr: "Spl4 TCB part is reserved, but is 1. Expect 0",
+		},
+		{
+			name:    "reserved Spl7",
+			parts:   TCBParts{Spl7: 5},
+			wantErr: "Spl7 TCB part is reserved, but is 5. Expect 0",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ComposeTCBParts(tc.parts)
+			if (err == nil && tc.wantErr != "") || (err != nil && (tc.wantErr == "" || !strings.Contains(err.Error(), tc.wantErr))) {
+				t.Fatalf("ComposeTCBParts(%v) = %v, %v. Want error %q", tc.parts, got, err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tc.want {
+				t.Errorf("ComposeTCBParts(%v) = 0x%x, want 0x%x", tc.parts, got, tc.want)
+			}
+			if parts := DecomposeTCBVersion(got); parts != tc.parts {
+				t.Errorf("DecomposeTCBVersion(0x%x) = %v, want %v", got, parts, tc.parts)
+			}
+		})
+	}
+}
+
+func TestTCBString(t *testing.T) {
+	tcb := TCBVersion(0x7306000000000002)
+	want := "blSpl=2 teeSpl=0 snpSpl=6 ucodeSpl=115"
+	if got := tcb.String(); got != want {
+		t.Errorf("TCBVersion(0x%x).String() = %q, want %q", uint64(tcb), got, want)
+	}
+	if got := fmt.Sprintf("%v", tcb); got != want {
+		t.Errorf("fmt %%v of TCBVersion(0x%x) = %q, want %q", uint64(tcb), got, want)
+	}
+	withReserved := TCBParts{BlSpl: 1, Spl5: 3, SnpSpl: 2, UcodeSpl: 4}
+	want = "blSpl=1 teeSpl=0 spl5=3 snpSpl=2 ucodeSpl=4"
+	if got := withReserved.String(); got != want {
+		t.Errorf("%#v.String() = %q, want %q", withReserved, got, want)
+	}
+}
+
+func TestTCBJSON(t *testing.T) {
+	tcb := TCBVersion(0x7306000000000002)
+	data, err := json.Marshal(tcb)
+	if err != nil {
+		t.Fatalf("json.Marshal(%v) = _, %v. Want nil", tcb, err)
+	}
+	want := `{"blSpl":2,"teeSpl":0,"snpSpl":6,"ucodeSpl":115}`
+	if string(data) != want {
+		t.Errorf("json.Marshal(%v) = %s, want %s", tcb, data, want)
+	}
+
+	tcs := []struct {
+		name    string
+		input   string
+		want    TCBVersion
+		wantErr string
+	}{
+		{name: "object", input: want, want: tcb},
+		{name: "packed", input: "8288312164221976578", want: tcb},
+		{name: "partial object", input: `{"ucodeSpl":115}`, want: TCBVersion(0x7300000000000000)},
+		{name: "unknown field", input: `{"bootloader":2}`, wantErr: "TCB must be a TCB_VERSION number or an object"},
+		{name: "string", input: `"0x7306000000000002"`, wantErr: "TCB must be a TCB_VERSION number or an object"},
+		{name: "reserved", input: `{"spl4":1}`, wantErr: "Spl4 TCB part is reserved"},
+		{name: "out of range", input: `{"snpSpl":200}`, wantErr: "SnpSpl TCB part is 200"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var got TCBVersion
+			err := json.Unmarshal([]byte(tc.input), &got)
+			if (err == nil) != (tc.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("json.Unmarshal(%s) = %v. Want error %q", tc.input, err, tc.wantErr)
+			}
+			if err == nil && got != tc.want {
+				t.Errorf("json.Unmarshal(%s) = 0x%x, want 0x%x", tc.input, uint64(got), uint64(tc.want))
+			}
+		})
+	}
+
+	type policy struct {
+		MinimumTCB TCBParts `json:"minimumTcb"`
+	}
+	var p policy
+	if err := json.Unmarshal([]byte(`{"minimumTcb":{"blSpl":2,"snpSpl":6,"ucodeSpl":115}}`), &p); err != nil {
+		t.Fatalf("json.Unmarshal(policy) = %v. Want nil", err)
+	}
+	if wantParts := DecomposeTCBVersion(tcb); p.MinimumTCB != wantParts {
+		t.Errorf("json.Unmarshal(policy) minimum TCB = %v, want %v", p.MinimumTCB, wantParts)
+	}
+}
+
+func TestCompareTCBParts(t *testing.T) {
+	tcs := []struct {
+		name        string
+		left, right TCBParts
+		want        TCBOrder
+		wantGreater []string
+	}{
+		{
+			name: "equal",
+			want: TCBLessOrEqual,
+		},
+		{
+			name:  "less",
+			left:  TCBParts{SnpSpl: 1},
+			right: TCBParts{SnpSpl: 2, UcodeSpl: 1},
+			want:  TCBLessOrEqual,
+		},
+		{
+			name:        "greater",
+			left:        TCBParts{BlSpl: 3, UcodeSpl: 9},
+			right:       TCBParts{BlSpl: 2, UcodeSpl: 9},
+			want:        TCBGreater,
+			wantGreater: []string{"BlSpl"},
+		},
+		{
+			name:        "incomparable",
+			left:        TCBParts{SnpSpl: 1, UcodeSpl: 0x44},
+			right:       TCBParts{SnpSpl: 2, UcodeSpl: 0x40},
+			want:        TCBIncomparable,
+			wantGreater: []string{"UcodeSpl"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CompareTCBParts(tc.left, tc.right); got != tc.want {
+				t.Errorf("CompareTCBParts(%v, %v) = %v, want %v", tc.left, tc.right, got, tc.want)
+			}
+			if got := TCBPartsLE(tc.left, tc.right); got != (tc.want == TCBLessOrEqual) {
+				t.Errorf("TCBPartsLE(%v, %v) = %v, want %v", tc.left, tc.right, got, tc.want == TCBLessOrEqual)
+			}
+			if diff := cmp.Diff(TCBPartsGreaterComponents(tc.left, tc.right), tc.wantGreater); diff != "" {
+				t.Errorf("TCBPartsGreaterComponents(%v, %v) diff (-got, +want): %s", tc.left, tc.right, diff)
+			}
+		})
+	}
+}
+
 func TestParseProductBaseURL(t *testing.T) {
 	tcs := []struct {
 		name        string
@@ -117,7 +319,7 @@ func TestParseProductCertChainURL(t *testing.T) {
 		},
 	}
 	for _, tc := range tests {
-		url := ProductCertChainURL(tc.key, tc.product)
+		url := ProductCertChainURL(tc.key, Product(tc.product))
 		got, key, err := ParseProductCertChainURL(url)
 		if err != nil {
 			t.Fatalf("ParseProductCertChainURL(%q) = _, _, %v, want nil", tc.product, err)
@@ -167,6 +369,36 @@ func TestParseVCEKCertURL(t *testing.T) {
 			url:     fmt.Sprintf("https://kdsintf.amd.com/vcek/v1/Milan/%s?blSPL=alpha", hwidhex),
 			wantErr: "invalid KDS TCB version URL argument value \"alpha\", want a value 0-255",
 		},
+		{
+			name:    "wrong host",
+			url:     fmt.Sprintf("https://kds.example.com/vcek/v1/Milan/%s?blSPL=0&teeSPL=0&snpSPL=0&ucodeSPL=0", hwidhex),
+			wantErr: "unexpected AMD KDS URL host \"kds.example.com\", want \"kdsintf.amd.com\"",
+		},
+		{
+			name:    "short hwid",
+			url:     "https://kdsintf.amd.com/vcek/v1/Milan/0102?blSPL=0&teeSPL=0&snpSPL=0&ucodeSPL=0",
+			wantErr: "hwid component of KDS URL has size 2, want 64",
+		},
+		{
+			name:    "hwid not hex",
+			url:     "https://kdsintf.amd.com/vcek/v1/Milan/xyz?blSPL=0&teeSPL=0&snpSPL=0&ucodeSPL=0",
+			wantErr: "hwid component of KDS URL is not a hex string: \"xyz\"",
+		},
+		{
+			name:    "SPL out of range",
+			url:     fmt.Sprintf("https://kdsintf.amd.com/vcek/v1/Milan/%s?blSPL=256&teeSPL=0&snpSPL=0&ucodeSPL=0", hwidhex),
+			wantErr: "invalid KDS TCB version URL argument value \"256\", want a value 0-255",
+		},
+		{
+			name:    "missing SPL",
+			url:     fmt.Sprintf("https://kdsintf.amd.com/vcek/v1/Milan/%s?blSPL=0&teeSPL=0&snpSPL=0", hwidhex),
+			wantErr: "KDS TCB version URL argument \"ucodeSPL\" appears 0 times, want 1",
+		},
+		{
+			name:    "repeated SPL",
+			url:     fmt.Sprintf("https://kdsintf.amd.com/vcek/v1/Milan/%s?blSPL=1&blSPL=2&teeSPL=0&snpSPL=0&ucodeSPL=0", hwidhex),
+			wantErr: "KDS TCB version URL argument \"blSPL\" appears 2 times, want 1",
+		},
 	}
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
@@ -183,6 +415,153 @@ func TestParseVCEKCertURL(t *testing.T) {
 	}
 }
 
+func TestParseVCEKCertURLRoundTrip(t *testing.T) {
+	hwid := make([]byte, abi.ChipIDSize)
+	for i := range hwid {
+		hwid[i] = byte(i)
+	}
+	for _, productLine := range []string{"Milan", "Genoa"} {
+		for _, parts := range []TCBParts{
+			{},
+			{BlSpl: 2, TeeSpl: 0, SnpSpl: 5, UcodeSpl: 68},
+			{BlSpl: 127, TeeSpl: 127, SnpSpl: 127, UcodeSpl: 255},
+		} {
+			tcb, err := ComposeTCBParts(parts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			url := VCEKCertURL(Product(productLine), hwid, tcb)
+			got, err := ParseVCEKCertURL(url)
+			if err != nil {
+				t.Fatalf("ParseVCEKCertURL(%q) = _, %v. Want nil", url, err)
+			}
+			if got.ProductLine != productLine || !bytes.Equal(got.HWID, hwid) || got.TCBParts() != parts {
+				t.Errorf("ParseVCEKCertURL(%q) = %q, %x, %+v. Want %q, %x, %+v", url,
+					got.ProductLine, got.HWID, got.TCBParts(), productLine, hwid, parts)
+			}
+		}
+	}
+}
+
+func TestSetBaseURLErrors(t *testing.T) {
+	defer SetBaseURL("")
+	for _, base := range []string{
+		"kds.example.com",
+		"ftp://kds.example.com",
+		"https://",
+		"https://kds.example.com/amd",
+		"https://kds.example.com?x=1",
+		"https://user@kds.example.com",
+	} {
+		if err := SetBaseURL(base); err == nil {
+			t.Errorf("SetBaseURL(%q) = nil. Want an error", base)
+		}
+		if got := BaseURL(); got != DefaultBaseURL {
+			t.Errorf("BaseURL() after SetBaseURL(%q) = %q. Want %q", base, got, DefaultBaseURL)
+		}
+	}
+}
+
+func TestBaseURLBuilders(t *testing.T) {
+	const base = "http://kds.example.com:8080"
+	if err := SetBaseURL(base + "/"); err != nil {
+		t.Fatalf("SetBaseURL(%q) = %v. Want nil", base+"/", err)
+	}
+	defer SetBaseURL("")
+	if got := BaseURL(); got != base {
+		t.Fatalf("BaseURL() = %q. Want %q", got, base)
+	}
+	hwid := make([]byte, abi.ChipIDSize)
+	hwid[0] = 1
+	tcb := TCBVersion(0x0b000000000018db)
+	chipURL, err := VCEKCertURLForChipID("Milan", hwid, tcb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportURL, err := ReportCertURL("Milan", abi.VlekReportSigner, hwid, tcb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builders := map[string]string{
+		"ProductCertChainURL(VCEK)": ProductCertChainURL(abi.VcekReportSigner, "Milan"),
+		"ProductCertChainURL(VLEK)": ProductCertChainURL(abi.VlekReportSigner, "Milan"),
+		"VCEKCertURL":               VCEKCertURL("Milan", hwid, tcb),
+		"VCEKCertURLForChipID":      chipURL,
+		"VLEKCertURL":               VLEKCertURL("Milan", tcb),
+		"ReportCertURL":             reportURL,
+		"CrlLinkByKey":              CrlLinkByKey("Milan", abi.VlekReportSigner),
+		"CrlLinkByRole":             CrlLinkByRole("Milan", "ASK"),
+		"CrlURL":                    CrlURL("Milan"),
+	}
+	for name, got := range builders {
+		if !strings.HasPrefix(got, base+"/v") {
+			t.Errorf("%s = %q. Want a URL on %q", name, got, base)
+		}
+		// The path and query are the same as on the AMD KDS.
+		amd := DefaultBaseURL + strings.TrimPrefix(got, base)
+		if err := SetBaseURL(""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseBaseProductURL(amd); err != nil {
+			t.Errorf("%s on the AMD KDS, %q, does not parse: %v", name, amd, err)
+		}
+		if err := SetBaseURL(base); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := CrlURL("Milan"); got != base+"/vcek/v1/Milan/crl" {
+		t.Errorf("CrlURL(Milan) = %q. Want %q", got, base+"/vcek/v1/Milan/crl")
+	}
+
+	// The parsers accept both the mirror and the AMD KDS, but no other host.
+	for _, host := range []string{base, DefaultBaseURL} {
+		if _, err := ParseVCEKCertURL(host + "/vcek/v1/Milan/" + hex.EncodeToString(hwid) + "?blSPL=0&teeSPL=0&snpSPL=0&ucodeSPL=0"); err != nil {
+			t.Errorf("ParseVCEKCertURL on %q = _, %v. Want nil", host, err)
+		}
+		if _, err := ParseVLEKCertURL(host + "/vlek/v1/Milan/cert?blSPL=0&teeSPL=0&snpSPL=0&ucodeSPL=0"); err != nil {
+			t.Errorf("ParseVLEKCertURL on %q = _, %v. Want nil", host, err)
+		}
+		if _, _, err := ParseProductCertChainURL(host + "/vcek/v1/Milan/cert_chain"); err != nil {
+			t.Errorf("ParseProductCertChainURL on %q = _, _, %v. Want nil", host, err)
+		}
+		if _, _, err := ParseCRLURL(host + "/vlek/v1/Milan/crl"); err != nil {
+			t.Errorf("ParseCRLURL on %q = _, _, %v. Want nil", host, err)
+		}
+	}
+	for _, other := range []string{"https://kds.example.com:8080", "https://other.example.com"} {
+		if _, _, err := ParseProductCertChainURL(other + "/vcek/v1/Milan/cert_chain"); err == nil {
+			t.Errorf("ParseProductCertChainURL on %q = nil. Want an error", other)
+		}
+	}
+}
+
+func TestParseCRLURL(t *testing.T) {
+	tcs := []struct {
+		url         string
+		productLine string
+		key         abi.ReportSigner
+		wantErr     string
+	}{
+		{url: CrlURL("Milan"), productLine: "Milan", key: abi.VcekReportSigner},
+		{url: CrlLinkByKey("Genoa", abi.VlekReportSigner), productLine: "Genoa", key: abi.VlekReportSigner},
+		{url: ProductCertChainURL(abi.VcekReportSigner, "Milan"), wantErr: `want "crl"`},
+		{url: CrlURL("Milan") + "?x=1", wantErr: "want none"},
+		{url: "https://kds.example.com/vcek/v1/Milan/crl", wantErr: "unexpected AMD KDS URL host"},
+	}
+	for _, tc := range tcs {
+		productLine, key, err := ParseCRLURL(tc.url)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ParseCRLURL(%q) = _, _, %v. Want error %q", tc.url, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || productLine != tc.productLine || key != tc.key {
+			t.Errorf("ParseCRLURL(%q) = %q, %v, %v. Want %q, %v, nil", tc.url, productLine, key, err, tc.productLine, tc.key)
+		}
+	}
+}
+
 func TestProductName(t *testing.T) {
 	tcs := []struct {
 		name  string
@@ -231,6 +610,14 @@ func TestProductName(t *testing.T) {
 			},
 			want: "unmappedMilanStepping",
 		},
+		{
+			name: "Genoa-B1",
+			input: &pb.SevProduct{
+				Name:            pb.SevProduct_SEV_PRODUCT_GENOA,
+				MachineStepping: &wrapperspb.UInt32Value{Value: 1},
+			},
+			want: "Genoa-B1",
+		},
 		{
 			name: "unknown genoa stepping",
 			input: &pb.SevProduct{
@@ -257,6 +644,58 @@ func TestProductName(t *testing.T) {
 	}
 }
 
+func TestKDSProductLine(t *testing.T) {
+	tcs := []struct {
+		input *pb.SevProduct
+		want  string
+	}{
+		{input: &pb.SevProduct{Name: pb.SevProduct_SEV_PRODUCT_MILAN}, want: "Milan"},
+		{input: &pb.SevProduct{Name: pb.SevProduct_SEV_PRODUCT_GENOA}, want: "Genoa"},
+		{input: &pb.SevProduct{Name: pb.SevProduct_SEV_PRODUCT_TURIN}, want: "Turin"},
+	}
+	for _, tc := range tcs {
+		got, err := KDSProductLine(tc.input)
+		if err != nil || got != tc.want {
+			t.Errorf("KDSProductLine(%v) = %q, %v. Want %q, nil", tc.input, got, err, tc.want)
+		}
+	}
+	unknown := &pb.SevProduct{Name: pb.SevProduct_SEV_PRODUCT_UNKNOWN}
+	if got, err := KDSProductLine(unknown); !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("KDSProductLine(%v) = %q, %v. Want %v", unknown, got, err, ErrUnknownProduct)
+	}
+	// Only the deprecated string helpers assume the default product.
+	if got, err := KDSProductLine(nil); !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("KDSProductLine(nil) = %q, %v. Want %v", got, err, ErrUnknownProduct)
+	}
+}
+
+func TestParseProductLine(t *testing.T) {
+	tcs := []struct {
+		input string
+		want  pb.SevProduct_SevProductName
+	}{
+		{input: "Milan", want: pb.SevProduct_SEV_PRODUCT_MILAN},
+		{input: "Genoa", want: pb.SevProduct_SEV_PRODUCT_GENOA},
+		{input: "Bergamo", want: pb.SevProduct_SEV_PRODUCT_GENOA},
+		{input: "Siena", want: pb.SevProduct_SEV_PRODUCT_GENOA},
+		{input: "Turin", want: pb.SevProduct_SEV_PRODUCT_TURIN},
+	}
+	for _, tc := range tcs {
+		got, err := ParseProductLine(tc.input)
+		if err != nil || got.GetName() != tc.want {
+			t.Errorf("ParseProductLine(%q) = %v, %v. Want %v", tc.input, got, err, tc.want)
+		}
+	}
+	for _, input := range []string{"", "milan", "Naples", "Milan-B0"} {
+		if got, err := ParseProductLine(input); !errors.Is(err, ErrUnknownProduct) {
+			t.Errorf("ParseProductLine(%q) = %v, %v. Want %v", input, got, err, ErrUnknownProduct)
+		}
+	}
+	if got, err := ParseProductName("Naples-B0", abi.VcekReportSigner); !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("ParseProductName(\"Naples-B0\", VCEK) = %v, %v. Want %v", got, err, ErrUnknownProduct)
+	}
+}
+
 func TestParseProductName(t *testing.T) {
 	tcs := []struct {
 		name    string
@@ -316,3 +755,516 @@ func TestParseProductName(t *testing.T) {
 		})
 	}
 }
+
+// mapGetter serves fixed bodies by URL.
+type mapGetter map[string][]byte
+
+func (g mapGetter) Get(url string) ([]byte, error) {
+	body, ok := g[url]
+	if !ok {
+		return nil, fmt.Errorf("404: %s", url)
+	}
+	return body, nil
+}
+
+func testCRL(t *testing.T, thisUpdate, nextUpdate time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ARK-Milan"},
+		NotBefore:             thisUpdate,
+		NotAfter:              nextUpdate.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate},
+		},
+	}, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+func TestCrlURL(t *testing.T) {
+	if got, want := CrlURL("Milan"), "https://kdsintf.amd.com/vcek/v1/Milan/crl"; got != want {
+		t.Errorf("CrlURL(\"Milan\") = %q, want %q", got, want)
+	}
+}
+
+func TestGetCRL(t *testing.T) {
+	thisUpdate := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := thisUpdate.Add(7 * 24 * time.Hour)
+	url := CrlURL("Milan")
+	getter := mapGetter{url: testCRL(t, thisUpdate, nextUpdate)}
+	crl, err := GetCRL(getter, url)
+	if err != nil {
+		t.Fatalf("GetCRL(_, %q) = _, %v. Want nil", url, err)
+	}
+	if !crl.ThisUpdate.Equal(thisUpdate) || !crl.NextUpdate.Equal(nextUpdate) {
+		t.Errorf("GetCRL(_, %q) updates = %v, %v. Want %v, %v", url, crl.ThisUpdate, crl.NextUpdate, thisUpdate, nextUpdate)
+	}
+	if len(crl.RevokedCertificates) != 1 || crl.RevokedCertificates[0].SerialNumber.Cmp(big.NewInt(7)) != 0 {
+		t.Errorf("GetCRL(_, %q) revoked %v. Want serial 7", url, crl.RevokedCertificates)
+	}
+}
+
+func TestGetCRLErrors(t *testing.T) {
+	url := CrlURL("Milan")
+	tcs := []struct {
+		name    string
+		getter  mapGetter
+		wantErr string
+	}{
+		{name: "not found", getter: mapGetter{}, wantErr: "could not download"},
+		{name: "not a CRL", getter: mapGetter{url: []byte("<html>Service unavailable</html>")}, wantErr: "not a valid CRL"},
+		{
+			name:    "error page",
+			getter:  mapGetter{url: []byte("<html>Service unavailable</html>")},
+			wantErr: "got 32 bytes of text/html; charset=utf-8: 3c68746d6c3e",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := GetCRL(tc.getter, url); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("GetCRL(_, %q) = _, %v. Want error %q", url, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetCert(t *testing.T) {
+	ask, _ := testProductChain(t)
+	url := VCEKCertURL("Milan", make([]byte, abi.ChipIDSize), 0)
+	for name, body := range map[string][]byte{"DER": ask, "PEM": pemCert(ask)} {
+		cert, err := GetCert(mapGetter{url: body}, url)
+		if err != nil {
+			t.Fatalf("GetCert(%s) = _, %v. Want nil", name, err)
+		}
+		if !bytes.Equal(cert.Raw, ask) {
+			t.Errorf("GetCert(%s) = %x. Want the DER certificate", name, cert.Raw)
+		}
+	}
+
+	page := []byte("<!DOCTYPE html><html><body>Please sign in to the network to continue.</body></html>")
+	_, err := GetCert(mapGetter{url: page}, url)
+	var malformedErr *MalformedErr
+	if !errors.As(err, &malformedErr) {
+		t.Fatalf("GetCert(error page) = _, %v. Want *MalformedErr", err)
+	}
+	if malformedErr.URL != url || malformedErr.ContentType != "text/html; charset=utf-8" || malformedErr.Size != len(page) ||
+		!bytes.Equal(malformedErr.Prefix, page[:maxMalformedPrefix]) {
+		t.Errorf("GetCert(error page) = _, %+v. Want the URL, HTML content type, size, and first %d bytes", malformedErr, maxMalformedPrefix)
+	}
+	if want := fmt.Sprintf("%x...", page[:maxMalformedPrefix]); !strings.Contains(err.Error(), want) || !strings.Contains(err.Error(), url) {
+		t.Errorf("GetCert(error page) = _, %v. Want an error naming %s and %s", err, url, want)
+	}
+
+	var fetchErr *FetchErr
+	if _, err := GetCert(mapGetter{}, url); !errors.As(err, &fetchErr) || fetchErr.URL != url {
+		t.Errorf("GetCert(not found) = _, %v. Want *FetchErr for %q", err, url)
+	}
+}
+
+func TestReportCertURL(t *testing.T) {
+	chipID := make([]byte, abi.ChipIDSize)
+	chipID[0] = 0xc0
+	tcb := TCBVersion(0x0a00000000000102)
+	tcs := []struct {
+		name    string
+		key     abi.ReportSigner
+		chipID  []byte
+		want    string
+		wantErr string
+	}{
+		{name: "VCEK", key: abi.VcekReportSigner, chipID: chipID, want: VCEKCertURL("Milan", chipID, tcb)},
+		{name: "VLEK", key: abi.VlekReportSigner, chipID: chipID, want: VLEKCertURL("Milan", tcb)},
+		{name: "VLEK with masked CHIP_ID", key: abi.VlekReportSigner, chipID: make([]byte, abi.ChipIDSize), want: VLEKCertURL("Milan", tcb)},
+		{name: "VCEK with masked CHIP_ID", key: abi.VcekReportSigner, chipID: make([]byte, abi.ChipIDSize), wantErr: "CHIP_ID is masked"},
+		{name: "unsigned", key: abi.NoneReportSigner, chipID: chipID, wantErr: "the KDS serves no certificate"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ReportCertURL("Milan", tc.key, tc.chipID, tcb)
+			if (err == nil && tc.wantErr != "") || (err != nil && (tc.wantErr == "" || !strings.Contains(err.Error(), tc.wantErr))) {
+				t.Fatalf("ReportCertURL(\"Milan\", %v, _, _) = _, %v. Want error %q", tc.key, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("ReportCertURL(\"Milan\", %v, _, _) = %q. Want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+// testProductChain returns a DER-encoded ASK and the self-signed ARK that issued it.
+func testProductChain(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	arkKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	askKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	arkTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ARK-Milan"},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ark, err := x509.CreateCertificate(rand.Reader, arkTemplate, arkTemplate, arkKey.Public(), arkKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	askTemplate := *arkTemplate
+	askTemplate.SerialNumber = big.NewInt(2)
+	askTemplate.Subject = pkix.Name{CommonName: "SEV-Milan"}
+	ask, err := x509.CreateCertificate(rand.Reader, &askTemplate, arkTemplate, askKey.Public(), arkKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ask, ark
+}
+
+func pemCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseProductCertChainCerts(t *testing.T) {
+	ask, ark := testProductChain(t)
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	tcs := []struct {
+		name    string
+		pems    []byte
+		wantErr string
+	}{
+		{name: "KDS order", pems: join(pemCert(ask), pemCert(ark))},
+		{name: "whitespace", pems: join([]byte("\n  "), pemCert(ask), []byte("\r\n\n"), pemCert(ark), []byte("\n\t "))},
+		{name: "reversed", pems: join(pemCert(ark), pemCert(ask))},
+		{name: "missing ARK", pems: pemCert(ask), wantErr: "cert_chain has 1 certificates"},
+		{name: "empty", pems: []byte("\n"), wantErr: "cert_chain has 0 certificates"},
+		{name: "three certificates", pems: join(pemCert(ask), pemCert(ark), pemCert(ark)), wantErr: "cert_chain has 3 certificates"},
+		{name: "trailing bytes", pems: join(pemCert(ask), pemCert(ark), []byte("garbage")), wantErr: "unexpected trailing bytes"},
+		{name: "wrong type", pems: join(pemCert(ask), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ark})), wantErr: "the ARK PEM block type is PRIVATE KEY"},
+		{name: "not a certificate", pems: join(pemCert(ask), pemCert([]byte("ark"))), wantErr: "could not parse the ARK certificate"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			gotAsk, gotArk, err := ParseProductCertChainCerts(tc.pems)
+			if (err == nil && tc.wantErr != "") || (err != nil && (tc.wantErr == "" || !strings.Contains(err.Error(), tc.wantErr))) {
+				t.Fatalf("ParseProductCertChainCerts(%q) = _, _, %v. Want error %q", tc.pems, err, tc.wantErr)
+			}
+			if tc.wantErr != "" {
+				return
+			}
+			if !bytes.Equal(gotAsk.Raw, ask) || !bytes.Equal(gotArk.Raw, ark) {
+				t.Errorf("ParseProductCertChainCerts(%q) = %v, %v. Want the ASK, then the ARK", tc.pems, gotAsk.Subject, gotArk.Subject)
+			}
+			askDER, arkDER, err := ParseProductCertChain(tc.pems)
+			if err != nil || !bytes.Equal(askDER, ask) || !bytes.Equal(arkDER, ark) {
+				t.Errorf("ParseProductCertChain(%q) = _, _, %v. Want the ASK, then the ARK", tc.pems, err)
+			}
+		})
+	}
+}
+
+func TestGetProductChain(t *testing.T) {
+	ask, ark := testProductChain(t)
+	url := ProductCertChainURL(abi.VcekReportSigner, "Milan")
+	gotAsk, gotArk, err := GetProductChain(mapGetter{url: append(pemCert(ask), pemCert(ark)...)}, abi.VcekReportSigner, "Milan")
+	if err != nil {
+		t.Fatalf("GetProductChain(_, VCEK, \"Milan\") = _, _, %v. Want nil", err)
+	}
+	if !bytes.Equal(gotAsk.Raw, ask) || !bytes.Equal(gotArk.Raw, ark) {
+		t.Errorf("GetProductChain(_, VCEK, \"Milan\") = %v, %v. Want the ASK, then the ARK", gotAsk.Subject, gotArk.Subject)
+	}
+
+	var fetchErr *FetchErr
+	if _, _, err := GetProductChain(mapGetter{}, abi.VcekReportSigner, "Milan"); !errors.As(err, &fetchErr) || fetchErr.URL != url {
+		t.Errorf("GetProductChain(unavailable, VCEK, \"Milan\") = _, _, %v. Want *FetchErr for %q", err, url)
+	}
+	var malformedErr *MalformedErr
+	if _, _, err := GetProductChain(mapGetter{url: pemCert(ask)}, abi.VcekReportSigner, "Milan"); !errors.As(err, &malformedErr) || errors.As(err, &fetchErr) {
+		t.Errorf("GetProductChain(truncated, VCEK, \"Milan\") = _, _, %v. Want only *MalformedErr", err)
+	}
+}
+
+func TestVCEKCertURLForReport(t *testing.T) {
+	chipID := make([]byte, abi.ChipIDSize)
+	for i := range chipID {
+		chipID[i] = byte(i)
+	}
+	tcb := TCBVersion(0x0a00000000000102)
+	rawReport := func(key abi.ReportSigner, chipID []byte) []byte {
+		raw := make([]byte, abi.ReportSize)
+		binary.LittleEndian.PutUint32(raw[0x48:0x4C], abi.ComposeSignerInfo(abi.SignerInfo{SigningKey: key}))
+		binary.LittleEndian.PutUint64(raw[0x180:0x188], uint64(tcb))
+		copy(raw[0x1A0:0x1E0], chipID)
+		return raw
+	}
+	want := "https://kdsintf.amd.com/vcek/v1/Milan/" + hex.EncodeToString(chipID) + "?blSPL=2&teeSPL=1&snpSPL=0&ucodeSPL=10"
+	tcs := []struct {
+		name    string
+		raw     []byte
+		want    string
+		wantErr string
+	}{
+		{name: "VCEK", raw: rawReport(abi.VcekReportSigner, chipID), want: want},
+		{name: "with certificates", raw: append(rawReport(abi.VcekReportSigner, chipID), 1, 2, 3), want: want},
+		{name: "too short", raw: rawReport(abi.VcekReportSigner, chipID)[:0x1E0], wantErr: "report is 480 bytes. Expected 1184"},
+		{name: "masked CHIP_ID", raw: rawReport(abi.VcekReportSigner, nil), wantErr: ErrChipIDMasked.Error()},
+		{name: "VLEK", raw: rawReport(abi.VlekReportSigner, chipID), wantErr: "report is signed by VLEK. Expected VCEK"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := VCEKCertURLForReport("Milan", tc.raw)
+			if (err == nil && tc.wantErr != "") || (err != nil && (tc.wantErr == "" || !strings.Contains(err.Error(), tc.wantErr))) {
+				t.Fatalf("VCEKCertURLForReport(\"Milan\", _) = _, %v. Want error %q", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("VCEKCertURLForReport(\"Milan\", _) = %q. Want %q", got, tc.want)
+			}
+		})
+	}
+	if _, err := VCEKCertURLForReport("Milan", rawReport(abi.VcekReportSigner, nil)); !errors.Is(err, ErrChipIDMasked) {
+		t.Errorf("VCEKCertURLForReport(\"Milan\", masked CHIP_ID) = _, %v. Want %v", err, ErrChipIDMasked)
+	}
+}
+
+func TestVCEKCertURLForReportProto(t *testing.T) {
+	chipID := make([]byte, abi.ChipIDSize)
+	chipID[0] = 0xc0
+	tcb := TCBVersion(0x0a00000000000102)
+	report := &pb.Report{ChipId: chipID, ReportedTcb: uint64(tcb)}
+	got, err := VCEKCertURLForReportProto("Milan", report)
+	if err != nil {
+		t.Fatalf("VCEKCertURLForReportProto(\"Milan\", _) = _, %v. Want nil", err)
+	}
+	if want := VCEKCertURL("Milan", chipID, tcb); got != want {
+		t.Errorf("VCEKCertURLForReportProto(\"Milan\", _) = %q. Want %q", got, want)
+	}
+	report.ChipId = chipID[:10]
+	if _, err := VCEKCertURLForReportProto("Milan", report); err == nil || !strings.Contains(err.Error(), "CHIP_ID length is 10") {
+		t.Errorf("VCEKCertURLForReportProto(\"Milan\", short CHIP_ID) = _, %v. Want a length error", err)
+	}
+	report.ChipId = make([]byte, abi.ChipIDSize)
+	if _, err := VCEKCertURLForReportProto("Milan", report); !errors.Is(err, ErrChipIDMasked) {
+		t.Errorf("VCEKCertURLForReportProto(\"Milan\", masked CHIP_ID) = _, %v. Want %v", err, ErrChipIDMasked)
+	}
+}
+
+// vcekExtensions returns the KDS extensions of a VCEK certificate, with each U8 field encoded by
+// u8.
+func vcekExtensions(u8 func(uint8) []byte, chipID []byte) []pkix.Extension {
+	productName, _ := asn1.MarshalWithParams("Milan-B0", "ia5")
+	hwid, _ := asn1.Marshal(chipID)
+	return []pkix.Extension{
+		{Id: OidStructVersion, Value: u8(1)},
+		{Id: OidProductName1, Value: productName},
+		{Id: OidBlSpl, Value: u8(2)},
+		{Id: OidTeeSpl, Value: u8(3)},
+		{Id: OidSnpSpl, Value: u8(4)},
+		{Id: OidSpl4, Value: u8(0)},
+		{Id: OidSpl5, Value: u8(0)},
+		{Id: OidSpl6, Value: u8(0)},
+		{Id: OidSpl7, Value: u8(0)},
+		{Id: OidUcodeSpl, Value: u8(9)},
+		{Id: OidHwid, Value: hwid},
+	}
+}
+
+func TestVcekCertificateExtensions(t *testing.T) {
+	chipID := make([]byte, abi.ChipIDSize)
+	chipID[0] = 0xc0
+	tcb, err := ComposeTCBParts(TCBParts{BlSpl: 2, TeeSpl: 3, SnpSpl: 4, UcodeSpl: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Extensions{StructVersion: 1, ProductName: "Milan-B0", HWID: chipID, TCBVersion: tcb}
+	tcs := []struct {
+		name string
+		u8   func(uint8) []byte
+	}{
+		{
+			name: "integer",
+			u8: func(v uint8) []byte {
+				b, _ := asn1.Marshal(int(v))
+				return b
+			},
+		},
+		{
+			name: "octet string",
+			u8: func(v uint8) []byte {
+				b, _ := asn1.Marshal([]byte{v})
+				return b
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			cert := &x509.Certificate{Extensions: vcekExtensions(tc.u8, chipID)}
+			got, err := VcekCertificateExtensions(cert)
+			if err != nil {
+				t.Fatalf("VcekCertificateExtensions(_) = _, %v. Want nil", err)
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("VcekCertificateExtensions(_) = %v. Want %v: %s", got, want, diff)
+			}
+		})
+	}
+}
+
+func TestVcekCertificateExtensionsErrors(t *testing.T) {
+	chipID := make([]byte, abi.ChipIDSize)
+	integer := func(v uint8) []byte {
+		b, _ := asn1.Marshal(int(v))
+		return b
+	}
+	without := func(id asn1.ObjectIdentifier) []pkix.Extension {
+		var exts []pkix.Extension
+		for _, ext := range vcekExtensions(integer, chipID) {
+			if !ext.Id.Equal(id) {
+				exts = append(exts, ext)
+			}
+		}
+		return exts
+	}
+	wideOctet := vcekExtensions(integer, chipID)
+	wideOctet[2].Value, _ = asn1.Marshal([]byte{1, 2})
+	tcs := []struct {
+		name    string
+		exts    []pkix.Extension
+		wantErr string
+	}{
+		{name: "no blSPL", exts: without(OidBlSpl), wantErr: "no extension for field BlSpl"},
+		{name: "no ucodeSPL", exts: without(OidUcodeSpl), wantErr: "no extension for field UcodeSpl"},
+		{name: "no productName", exts: without(OidProductName1), wantErr: "no extension for field ProductName1"},
+		{name: "no hwID", exts: without(OidHwid), wantErr: "missing HWID extension"},
+		{name: "two-byte octet string", exts: wideOctet, wantErr: "size is 2, expected 1"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			cert := &x509.Certificate{Extensions: tc.exts}
+			if _, err := VcekCertificateExtensions(cert); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("VcekCertificateExtensions(_) = _, %v. Want error %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// contextGetter is a mapGetter that records the contexts it is given.
+type contextGetter struct {
+	mapGetter
+	ctxs []context.Context
+}
+
+func (g *contextGetter) GetContext(ctx context.Context, url string) ([]byte, error) {
+	g.ctxs = append(g.ctxs, ctx)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.Get(url)
+}
+
+func TestGetCRLContext(t *testing.T) {
+	thisUpdate := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	url := CrlURL("Milan")
+	body := testCRL(t, thisUpdate, thisUpdate.Add(time.Hour))
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+	getter := &contextGetter{mapGetter: mapGetter{url: body}}
+	if _, err := GetCRLContext(ctx, getter, url); err != nil {
+		t.Fatalf("GetCRLContext(_, _, %q) = _, %v. Want nil", url, err)
+	}
+	if len(getter.ctxs) != 1 || getter.ctxs[0].Value(ctxKey{}) != "caller" {
+		t.Errorf("GetCRLContext(ctx, _, %q) passed contexts %v. Want the caller's", url, getter.ctxs)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, g := range []Getter{getter, mapGetter{url: body}} {
+		if _, err := GetCRLContext(cancelled, g, url); !errors.Is(err, context.Canceled) {
+			t.Errorf("GetCRLContext(cancelled, %T, %q) = _, %v. Want %v", g, url, err, context.Canceled)
+		}
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	tcs := []struct {
+		name      string
+		err       *HTTPError
+		want      string
+		transient bool
+	}{
+		{
+			name: "not found",
+			err:  &HTTPError{URL: "u", StatusCode: http.StatusNotFound},
+			want: "failed to retrieve 'u' status 404",
+		},
+		{
+			name: "forbidden",
+			err:  &HTTPError{URL: "u", StatusCode: http.StatusForbidden, Body: []byte("blocked\n")},
+			want: `failed to retrieve 'u' status 403: "blocked"`,
+		},
+		{
+			name:      "long body",
+			err:       &HTTPError{URL: "u", StatusCode: http.StatusInternalServerError, Body: long},
+			want:      fmt.Sprintf("failed to retrieve 'u' status 500: %q...", long[:maxHTTPErrorBody]),
+			transient: true,
+		},
+		{
+			name:      "too many requests",
+			err:       &HTTPError{URL: "u", StatusCode: http.StatusTooManyRequests},
+			want:      "failed to retrieve 'u' status 429",
+			transient: true,
+		},
+		{
+			name:      "no response",
+			err:       &HTTPError{URL: "u", Err: context.Canceled},
+			want:      "failed to retrieve 'u': context canceled",
+			transient: true,
+		},
+		{
+			name: "bad certificate",
+			err:  &HTTPError{URL: "u", Err: fmt.Errorf("tls: %w", x509.UnknownAuthorityError{})},
+			want: "failed to retrieve 'u': tls: x509: certificate signed by unknown authority",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.err.Error(); got != tc.want {
+				t.Errorf("Error() = %q. Want %q", got, tc.want)
+			}
+			if got := tc.err.Transient(); got != tc.transient {
+				t.Errorf("Transient() = %v. Want %v", got, tc.transient)
+			}
+		})
+	}
+	if err := (&FetchErr{URL: "u", Err: tcs[4].err}); !errors.Is(err, context.Canceled) {
+		t.Errorf("errors.Is(%v, context.Canceled) = false. Want true", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Chip identifies a VCEK certificate to fetch with CertsForChip.
+type Chip struct {
+	// Product is the chip's product line, e.g., "Milan", or product name, e.g., "Milan-B1".
+	Product string
+	// HWID is the chip's CHIP_ID.
+	HWID []byte
+	// TCB is the TCB of the VCEK certificate.
+	TCB TCBParts
+}
+
+// PrefetchStatus is the outcome of prefetching one chip's certificates.
+type PrefetchStatus int
+
+const (
+	// PrefetchFailed means the certificates could not be fetched, or the prefetch was abandoned
+	// before they were.
+	PrefetchFailed PrefetchStatus = iota
+	// PrefetchFetched means at least one of the certificates was fetched from the KDS.
+	PrefetchFetched
+	// PrefetchCached means all of the certificates were already cached. It is never the status of a
+	// prefetch whose getter is not a CachedGetter.
+	PrefetchCached
+)
+
+func (s PrefetchStatus) String() string {
+	switch s {
+	case PrefetchFailed:
+		return "failed"
+	case PrefetchFetched:
+		return "fetched"
+	case PrefetchCached:
+		return "cached"
+	}
+	return fmt.Sprintf("PrefetchStatus(%d)", int(s))
+}
+
+// PrefetchResult is the outcome of prefetching one chip's certificates.
+type PrefetchResult struct {
+	Chip   Chip
+	Status PrefetchStatus
+	// Err is why the certificates could not be fetched if Status is PrefetchFailed.
+	Err error
+}
+
+// CachedGetter is a Getter that keeps the responses it fetches, and can answer from them without
+// fetching, e.g., a CachingGetter or a trust.DiskCacheHTTPSGetter.
+type CachedGetter interface {
+	Getter
+	// Cached returns the cached body of url, if any, without fetching it.
+	Cached(url string) ([]byte, bool)
+}
+
+// prefetchGetter answers from the cache, if any, and fetches misses through the rate limiter. It
+// records whether any URL missed the cache.
+type prefetchGetter struct {
+	// cache is nil if the prefetch's getter is not a CachedGetter.
+	cache   CachedGetter
+	fetcher Getter
+	fetched atomic.Bool
+}
+
+func (g *prefetchGetter) Get(url string) ([]byte, error) {
+	return g.GetContext(context.Background(), url)
+}
+
+func (g *prefetchGetter) GetContext(ctx context.Context, url string) ([]byte, error) {
+	if g.cache != nil {
+		if body, ok := g.cache.Cached(url); ok {
+			return body, nil
+		}
+	}
+	g.fetched.Store(true)
+	return getContext(ctx, g.fetcher, url)
+}
+
+// Prefetch fetches the VCEK certificates of chips and the certificate chains of their product
+// lines with getter, e.g., to warm a cache for a fleet overnight. Any Getter, including a
+// trust.HTTPSGetter, will do, but only one that keeps what it fetches makes the prefetch useful. At
+// most concurrency chips are fetched at once, and if limiter is not nil, each fetch that misses the
+// cache waits for it. If getter is a CachedGetter, e.g., a CachingGetter or a
+// trust.DiskCacheHTTPSGetter, the certificates that it already has are not fetched again, so a
+// Prefetch that was abandoned or that partly failed, even in a process that since restarted, can be
+// run again on the same chips to resume it. The results are in the order of chips.
+func Prefetch(getter Getter, chips []Chip, concurrency int, limiter *RateLimiter) []PrefetchResult {
+	return PrefetchContext(context.Background(), getter, chips, concurrency, limiter)
+}
+
+// PrefetchContext is like Prefetch, but stops when ctx is done. The chips whose certificates were
+// not fetched by then fail with an error wrapping ctx.Err().
+func PrefetchContext(ctx context.Context, getter Getter, chips []Chip, concurrency int, limiter *RateLimiter) []PrefetchResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	cache, _ := getter.(CachedGetter)
+	fetcher := getter
+	if limiter != nil {
+		fetcher = &RateLimitedGetter{Getter: getter, Limiter: limiter}
+	}
+	results := make([]PrefetchResult, len(chips))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = prefetchChip(ctx, cache, fetcher, chips[i])
+			}
+		}()
+	}
+	for i := range chips {
+		if err := ctx.Err(); err != nil {
+			results[i] = PrefetchResult{Chip: chips[i], Err: fmt.Errorf("prefetch abandoned: %w", err)}
+			continue
+		}
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			results[i] = PrefetchResult{Chip: chips[i], Err: fmt.Errorf("prefetch abandoned: %w", ctx.Err())}
+		}
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func prefetchChip(ctx context.Context, cache CachedGetter, fetcher Getter, chip Chip) PrefetchResult {
+	result := PrefetchResult{Chip: chip}
+	getter := &prefetchGetter{cache: cache, fetcher: fetcher}
+	if _, err := CertsForChipContext(ctx, getter, chip.Product, chip.HWID, chip.TCB); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Status = PrefetchCached
+	if getter.fetched.Load() {
+		result.Status = PrefetchFetched
+	}
+	return result
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+)
+
+// inFlightGetter records the most fetches that were in flight at once.
+type inFlightGetter struct {
+	countingGetter
+	mu       sync.Mutex
+	inFlight int
+	most     int
+}
+
+func (g *inFlightGetter) Get(url string) ([]byte, error) {
+	g.mu.Lock()
+	g.inFlight++
+	if g.inFlight > g.most {
+		g.most = g.inFlight
+	}
+	g.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	defer func() {
+		g.mu.Lock()
+		g.inFlight--
+		g.mu.Unlock()
+	}()
+	return g.countingGetter.Get(url)
+}
+
+// testFleet returns n chips on Milan and a network that serves their certificates.
+func testFleet(t *testing.T, n int) ([]Chip, mapGetter) {
+	ask, ark := testProductChain(t)
+	network := mapGetter{ProductCertChainURL(abi.VcekReportSigner, "Milan"): append(pemCert(ask), pemCert(ark)...)}
+	var chips []Chip
+	for i := 0; i < n; i++ {
+		hwid := make([]byte, abi.ChipIDSize)
+		hwid[0] = byte(i + 1)
+		chip := Chip{Product: "Milan", HWID: hwid, TCB: TCBParts{SnpSpl: 5}}
+		tcb, err := ComposeTCBParts(chip.TCB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network[VCEKCertURL("Milan", hwid, tcb)] = ask
+		chips = append(chips, chip)
+	}
+	return chips, network
+}
+
+func TestPrefetch(t *testing.T) {
+	chips, network := testFleet(t, 2)
+	chips = append(chips, Chip{Product: "Milan", HWID: make([]byte, 10)})
+	upstream := &countingGetter{mapGetter: network}
+	cache := &CachingGetter{Getter: upstream}
+	statuses := func(results []PrefetchResult) []PrefetchStatus {
+		var got []PrefetchStatus
+		for _, r := range results {
+			got = append(got, r.Status)
+		}
+		return got
+	}
+
+	results := Prefetch(cache, chips, 2, NewRateLimiter(1000, 10))
+	want := []PrefetchStatus{PrefetchFetched, PrefetchFetched, PrefetchFailed}
+	for i, got := range statuses(results) {
+		if got != want[i] {
+			t.Errorf("first Prefetch() chip %d = %v (%v). Want %v", i, got, results[i].Err, want[i])
+		}
+	}
+	if results[2].Err == nil {
+		t.Error("Prefetch() of a short CHIP_ID has no error")
+	}
+	gets := len(upstream.gets)
+
+	// A second run finds everything that was fetched in the cache.
+	results = Prefetch(cache, chips, 2, nil)
+	want = []PrefetchStatus{PrefetchCached, PrefetchCached, PrefetchFailed}
+	for i, got := range statuses(results) {
+		if got != want[i] {
+			t.Errorf("second Prefetch() chip %d = %v (%v). Want %v", i, got, results[i].Err, want[i])
+		}
+	}
+	if len(upstream.gets) != gets {
+		t.Errorf("second Prefetch() fetched %d URLs. Want none", len(upstream.gets)-gets)
+	}
+}
+
+func TestPrefetchConcurrency(t *testing.T) {
+	chips, network := testFleet(t, 8)
+	upstream := &inFlightGetter{countingGetter: countingGetter{mapGetter: network}}
+	for i, r := range Prefetch(&CachingGetter{Getter: upstream}, chips, 3, nil) {
+		if r.Status != PrefetchFetched {
+			t.Errorf("Prefetch() chip %d = %v (%v). Want %v", i, r.Status, r.Err, PrefetchFetched)
+		}
+	}
+	// Each chip fetches its VCEK and chain in parallel.
+	if upstream.most > 6 {
+		t.Errorf("Prefetch() with concurrency 3 had %d fetches in flight. Want at most 6", upstream.most)
+	}
+}
+
+func TestPrefetchCancelled(t *testing.T) {
+	chips, network := testFleet(t, 4)
+	upstream := &countingGetter{mapGetter: network}
+	cache := &CachingGetter{Getter: upstream}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, r := range PrefetchContext(ctx, cache, chips, 2, nil) {
+		if r.Status != PrefetchFailed || !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("PrefetchContext(cancelled) chip %d = %v, %v. Want %v, %v", i, r.Status, r.Err, PrefetchFailed, context.Canceled)
+		}
+	}
+	if len(upstream.gets) != 0 {
+		t.Errorf("PrefetchContext(cancelled) fetched %v. Want nothing", upstream.gets)
+	}
+
+	// Resuming after the first chip was fetched only fetches the rest.
+	if r := Prefetch(cache, chips[:1], 1, nil); r[0].Status != PrefetchFetched {
+		t.Fatalf("Prefetch(first chip) = %v (%v). Want %v", r[0].Status, r[0].Err, PrefetchFetched)
+	}
+	results := Prefetch(cache, chips, 2, nil)
+	if results[0].Status != PrefetchCached {
+		t.Errorf("resumed Prefetch() chip 0 = %v. Want %v", results[0].Status, PrefetchCached)
+	}
+	for i, r := range results[1:] {
+		if r.Status != PrefetchFetched {
+			t.Errorf("resumed Prefetch() chip %d = %v (%v). Want %v", i+1, r.Status, r.Err, PrefetchFetched)
+		}
+	}
+}
+
+func TestPrefetchUncached(t *testing.T) {
+	chips, network := testFleet(t, 2)
+	upstream := &countingGetter{mapGetter: network}
+	// A getter that is not a CachedGetter is used as is, so every run fetches everything.
+	for run := 0; run < 2; run++ {
+		for i, r := range Prefetch(upstream, chips, 2, nil) {
+			if r.Status != PrefetchFetched {
+				t.Errorf("Prefetch() run %d chip %d = %v (%v). Want %v", run, i, r.Status, r.Err, PrefetchFetched)
+			}
+		}
+	}
+	for _, chip := range chips {
+		tcb, err := ComposeTCBParts(chip.TCB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url := VCEKCertURL("Milan", chip.HWID, tcb); upstream.gets[url] != 2 {
+			t.Errorf("Prefetch() twice fetched %q %d times. Want 2", url, upstream.gets[url])
+		}
+	}
+}
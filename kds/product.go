@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	pb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+// Product is a product line as the KDS names it in its URL paths, and as the common names of its
+// ARK, ASK, and ASVK certificates do, e.g., "ARK-Milan". Bergamo and Siena are under the Genoa
+// product line.
+type Product string
+
+// The product lines that the KDS serves certificates for.
+const (
+	Milan Product = "Milan"
+	Genoa Product = "Genoa"
+	Turin Product = "Turin"
+)
+
+// String returns the KDS path segment of the product line.
+func (p Product) String() string {
+	return string(p)
+}
+
+// Check returns an error wrapping ErrUnknownProduct if p is not a product line that the KDS serves.
+func (p Product) Check() error {
+	switch p {
+	case Milan, Genoa, Turin:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownProduct, string(p))
+}
+
+// ProductOf returns the product line of product, or an error wrapping ErrUnknownProduct, including
+// for a nil product.
+func ProductOf(product *pb.SevProduct) (Product, error) {
+	productLine, err := KDSProductLine(product)
+	if err != nil {
+		return "", err
+	}
+	return Product(productLine), nil
+}
+
+// ParseProductType returns the product line that name names: either a product line, including
+// Bergamo and Siena, or a VCEK productName extension value with a stepping suffix, e.g.,
+// "Milan-B0". Errors wrap ErrUnknownProduct.
+func ParseProductType(name string) (Product, error) {
+	product, err := ParseProductLine(name)
+	if err != nil {
+		product, err = ParseProductName(name, abi.VcekReportSigner)
+	}
+	if err != nil {
+		return "", err
+	}
+	return ProductOf(product)
+}
+
+// ProductOfCpuid1Eax returns the product line of the CPU whose CPUID[EAX=1].EAX value is eax, by
+// its family and model. Errors wrap ErrUnknownProduct.
+func ProductOfCpuid1Eax(eax uint32) (Product, error) {
+	product := abi.SevProductFromCpuid1Eax(eax)
+	if product.GetName() == pb.SevProduct_SEV_PRODUCT_UNKNOWN {
+		return "", fmt.Errorf("%w: CPUID[EAX=1].EAX is 0x%x", ErrUnknownProduct, eax)
+	}
+	return ProductOf(product)
+}
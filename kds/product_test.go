@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseProductType(t *testing.T) {
+	tcs := []struct {
+		input string
+		want  Product
+	}{
+		{input: "Milan", want: Milan},
+		{input: "Milan-B0", want: Milan},
+		{input: "Milan-B1", want: Milan},
+		{input: "Genoa", want: Genoa},
+		{input: "Genoa-B1", want: Genoa},
+		{input: "Bergamo", want: Genoa},
+		{input: "Siena", want: Genoa},
+		{input: "Turin", want: Turin},
+	}
+	for _, tc := range tcs {
+		got, err := ParseProductType(tc.input)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseProductType(%q) = %q, %v. Want %q, nil", tc.input, got, err, tc.want)
+		}
+		if err := got.Check(); err != nil {
+			t.Errorf("%q.Check() = %v. Want nil", got, err)
+		}
+	}
+	for _, input := range []string{"", "Naples", "Milan-Z9", "milan"} {
+		if got, err := ParseProductType(input); !errors.Is(err, ErrUnknownProduct) {
+			t.Errorf("ParseProductType(%q) = %q, %v. Want %v", input, got, err, ErrUnknownProduct)
+		}
+	}
+	if got, err := ProductOf(nil); !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("ProductOf(nil) = %q, %v. Want %v", got, err, ErrUnknownProduct)
+	}
+	if err := Product("Unknown").Check(); !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("Product(%q).Check() = %v. Want %v", "Unknown", err, ErrUnknownProduct)
+	}
+}
+
+func TestProductOfCpuid1Eax(t *testing.T) {
+	tcs := []struct {
+		eax  uint32
+		want Product
+	}{
+		{eax: 0x00a00f11, want: Milan},
+		{eax: 0x00a10f11, want: Genoa},
+		{eax: 0x00aa0f01, want: Genoa}, // Bergamo
+		{eax: 0x00b00f21, want: Turin},
+	}
+	for _, tc := range tcs {
+		got, err := ProductOfCpuid1Eax(tc.eax)
+		if err != nil || got != tc.want {
+			t.Errorf("ProductOfCpuid1Eax(0x%x) = %q, %v. Want %q, nil", tc.eax, got, err, tc.want)
+		}
+	}
+	// Family 17h, i.e., Rome, has no SEV-SNP KDS product line.
+	if got, err := ProductOfCpuid1Eax(0x00830f10); !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("ProductOfCpuid1Eax(0x830f10) = %q, %v. Want %v", got, err, ErrUnknownProduct)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that paces requests to the KDS, which throttles a source that
+// sends more than a few requests per second. One RateLimiter may be shared by any number of
+// RateLimitedGetters, e.g., those for VCEK certificates and for CRLs, so that together they stay
+// within one budget.
+//
+// A RateLimiter is safe for concurrent use.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate requests per second on average, and bursts
+// of up to burst requests at once. It starts full. A burst less than 1 is 1, and a rate of 0 allows
+// only the first burst.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take takes a token at now if there is one, or else returns how long until there is.
+func (l *RateLimiter) take(now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	if l.last.IsZero() || now.After(l.last) {
+		l.last = now
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	if l.rate <= 0 {
+		return 0, false
+	}
+	wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if l.last.After(now) {
+		// The bucket is held empty until last by throttled.
+		wait += l.last.Sub(now)
+	}
+	return wait, false
+}
+
+// throttled empties the bucket at now, and keeps it from refilling for another d, since the KDS
+// answered that it is getting too many requests.
+func (l *RateLimiter) throttled(now time.Time, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = 0
+	if until := now.Add(d); until.After(l.last) {
+		l.last = until
+	}
+}
+
+// Wait blocks until a request is allowed, or returns an error wrapping ctx.Err() if ctx is done
+// first or its deadline is too soon to wait for one. A Wait that returns an error does not use up
+// a request.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		wait, ok := l.take(time.Now())
+		if ok {
+			return nil
+		}
+		if wait == 0 {
+			return fmt.Errorf("rate limiter allows no requests")
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return fmt.Errorf("rate limit wait of %v is past the deadline: %w", wait, context.DeadlineExceeded)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RateLimitedGetter is a Getter that waits for its Limiter before each fetch. When a fetch fails
+// with an *HTTPError of status 429 Too Many Requests, it empties the Limiter's bucket and holds it
+// empty for the error's RetryAfter, so that the other getters that share the Limiter slow down
+// too.
+type RateLimitedGetter struct {
+	// Getter fetches the URLs.
+	Getter Getter
+	// Limiter paces the fetches. It may be shared with other getters.
+	Limiter *RateLimiter
+}
+
+// Get waits for the limiter, then fetches the URL with Getter.
+func (g *RateLimitedGetter) Get(url string) ([]byte, error) {
+	return g.GetContext(context.Background(), url)
+}
+
+// GetContext is like Get, but gives up waiting for the limiter, and passes ctx to Getter.
+func (g *RateLimitedGetter) GetContext(ctx context.Context, url string) ([]byte, error) {
+	if err := g.Limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("rate limited fetch of %s abandoned: %w", url, err)
+	}
+	body, err := getContext(ctx, g.Getter, url)
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		g.Limiter.throttled(time.Now(), httpErr.RetryAfter)
+	}
+	return body, err
+}
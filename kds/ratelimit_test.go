@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	l := NewRateLimiter(2, 3)
+	start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if _, ok := l.take(start); !ok {
+			t.Fatalf("take %d of a burst of 3 = false. Want true", i+1)
+		}
+	}
+	wait, ok := l.take(start)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("take past the burst = %v, %v. Want 500ms, false", wait, ok)
+	}
+	if _, ok := l.take(start.Add(500 * time.Millisecond)); !ok {
+		t.Error("take after 500ms at 2/s = false. Want true")
+	}
+	// A long idle time refills only up to the burst.
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, ok := l.take(later); !ok {
+			t.Fatalf("take %d after an hour = false. Want true", i+1)
+		}
+	}
+	if _, ok := l.take(later); ok {
+		t.Error("take 4 after an hour = true. Want the burst of 3 to cap refills")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := NewRateLimiter(50, 1)
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("Wait() = %v. Want nil", err)
+		}
+	}
+	// The first request is free, and the others wait 20ms each.
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 Waits at 50/s with a burst of 1 took %v. Want at least 40ms", elapsed)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() = %v. Want nil", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait(cancelled) = %v. Want %v", err, context.Canceled)
+	}
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait(10ms deadline) = %v. Want %v", err, context.DeadlineExceeded)
+	}
+	// The abandoned Waits took no tokens, so the next one is available a second after the first.
+	if _, ok := l.take(time.Now().Add(time.Second)); !ok {
+		t.Error("take a second after the only successful Wait = false. Want true")
+	}
+}
+
+func TestRateLimitedGetterShared(t *testing.T) {
+	limiter := NewRateLimiter(1, 2)
+	vcek := &RateLimitedGetter{Getter: mapGetter{"vcek": []byte("vcek")}, Limiter: limiter}
+	crl := &RateLimitedGetter{Getter: mapGetter{"crl": []byte("crl")}, Limiter: limiter}
+	if _, err := vcek.Get("vcek"); err != nil {
+		t.Fatalf("Get(vcek) = _, %v. Want nil", err)
+	}
+	if _, err := crl.Get("crl"); err != nil {
+		t.Fatalf("Get(crl) = _, %v. Want nil", err)
+	}
+	// The burst of 2 is spent across both getters.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := vcek.GetContext(ctx, "vcek"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetContext(vcek) past the shared burst = _, %v. Want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// throttlingGetter answers every fetch with 429 Too Many Requests.
+type throttlingGetter struct{ retryAfter time.Duration }
+
+func (g throttlingGetter) Get(url string) ([]byte, error) {
+	return nil, &HTTPError{URL: url, StatusCode: http.StatusTooManyRequests, RetryAfter: g.retryAfter}
+}
+
+func TestRateLimitedGetterThrottled(t *testing.T) {
+	limiter := NewRateLimiter(100, 5)
+	throttled := &RateLimitedGetter{Getter: throttlingGetter{retryAfter: time.Hour}, Limiter: limiter}
+	if _, err := throttled.Get("vcek"); err == nil {
+		t.Fatal("Get() of a 429 = nil. Want an error")
+	}
+	// The rest of the burst is gone, and the bucket stays empty for the hour that the KDS asked for.
+	wait, ok := limiter.take(time.Now())
+	if ok || wait < 59*time.Minute {
+		t.Errorf("take after a 429 with Retry-After 1h = %v, %v. Want about 1h, false", wait, ok)
+	}
+	if _, ok := limiter.take(time.Now().Add(time.Hour + time.Second)); !ok {
+		t.Error("take after the Retry-After = false. Want true")
+	}
+}
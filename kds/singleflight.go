@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is a fetch in progress that other callers of the same URL wait for.
+type flight struct {
+	done chan struct{}
+	body []byte
+	err  error
+	// abandoned is true if the fetch failed because its caller's context was done, which says
+	// nothing about whether the URL can be fetched.
+	abandoned bool
+}
+
+// SingleflightGetter is a Getter that shares one fetch of a URL among all its concurrent callers,
+// e.g., the verifications for many VMs on the same host that all need the host's VCEK, so that a
+// burst of them sends one request to the KDS rather than one each and gets rate limited.
+//
+// A SingleflightGetter is safe for concurrent use.
+type SingleflightGetter struct {
+	// Getter fetches the URLs.
+	Getter Getter
+	// IsolateErrors, if true, has callers that shared a failed fetch each fetch the URL again
+	// themselves, so that one transient failure does not fail all of them. Callers always fetch
+	// again if the shared fetch failed because its caller gave up on it.
+	IsolateErrors bool
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// Get returns the body of the URL, fetching it with Getter unless another caller is already doing
+// so, and else sharing that caller's result.
+func (g *SingleflightGetter) Get(url string) ([]byte, error) {
+	return g.GetContext(context.Background(), url)
+}
+
+// GetContext is like Get, but returns ctx.Err() if ctx is done while waiting for another caller's
+// fetch, and passes ctx to Getter for its own.
+func (g *SingleflightGetter) GetContext(ctx context.Context, url string) ([]byte, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[url]; ok {
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if f.err != nil && (f.abandoned || g.IsolateErrors) {
+			return getContext(ctx, g.Getter, url)
+		}
+		return f.body, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	g.flights[url] = f
+	g.mu.Unlock()
+
+	f.body, f.err = getContext(ctx, g.Getter, url)
+	f.abandoned = f.err != nil && ctx.Err() != nil
+	g.mu.Lock()
+	delete(g.flights, url)
+	g.mu.Unlock()
+	close(f.done)
+	return f.body, f.err
+}
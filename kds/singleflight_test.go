@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowGetter blocks every fetch until release is closed, then returns body or err.
+type slowGetter struct {
+	release chan struct{}
+	body    []byte
+	err     error
+	gets    int32
+}
+
+func (g *slowGetter) Get(string) ([]byte, error) {
+	atomic.AddInt32(&g.gets, 1)
+	<-g.release
+	return g.body, g.err
+}
+
+// getConcurrently calls g.Get(url) from n goroutines, releases the underlying fetch once they are
+// all waiting on it, and returns their errors.
+func getConcurrently(t *testing.T, g *SingleflightGetter, slow *slowGetter, url string, n int) []error {
+	t.Helper()
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, err := g.Get(url)
+			if err == nil && !bytes.Equal(body, slow.body) {
+				t.Errorf("Get(%q) = %q. Want %q", url, body, slow.body)
+			}
+			errs[i] = err
+		}(i)
+	}
+	// Wait for the leader's fetch to start, and give the others time to join it.
+	for atomic.LoadInt32(&slow.gets) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(slow.release)
+	wg.Wait()
+	return errs
+}
+
+func TestSingleflightGetter(t *testing.T) {
+	url := CrlURL("Milan")
+	slow := &slowGetter{release: make(chan struct{}), body: []byte("crl")}
+	g := &SingleflightGetter{Getter: slow}
+	for _, err := range getConcurrently(t, g, slow, url, 16) {
+		if err != nil {
+			t.Errorf("Get(%q) = _, %v. Want nil", url, err)
+		}
+	}
+	if gets := atomic.LoadInt32(&slow.gets); gets != 1 {
+		t.Errorf("16 concurrent Gets fetched %q %d times. Want 1", url, gets)
+	}
+
+	// Once the fetch is done, the next Get fetches again.
+	if _, err := g.Get(url); err != nil {
+		t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+	}
+	if gets := atomic.LoadInt32(&slow.gets); gets != 2 {
+		t.Errorf("Get after the shared fetch fetched %q %d times in all. Want 2", url, gets)
+	}
+}
+
+func TestSingleflightGetterErrors(t *testing.T) {
+	url := CrlURL("Milan")
+	errTransient := errors.New("connection reset")
+	tcs := []struct {
+		name          string
+		isolateErrors bool
+		wantGets      int32
+	}{
+		{name: "shared", wantGets: 1},
+		{name: "isolated", isolateErrors: true, wantGets: 4},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			slow := &slowGetter{release: make(chan struct{}), err: errTransient}
+			g := &SingleflightGetter{Getter: slow, IsolateErrors: tc.isolateErrors}
+			for _, err := range getConcurrently(t, g, slow, url, 4) {
+				if !errors.Is(err, errTransient) {
+					t.Errorf("Get(%q) = _, %v. Want %v", url, err, errTransient)
+				}
+			}
+			if gets := atomic.LoadInt32(&slow.gets); gets != tc.wantGets {
+				t.Errorf("4 concurrent Gets fetched %q %d times. Want %d", url, gets, tc.wantGets)
+			}
+		})
+	}
+}
+
+func TestSingleflightGetterCancelled(t *testing.T) {
+	url := CrlURL("Milan")
+	slow := &slowGetter{release: make(chan struct{}), body: []byte("crl")}
+	g := &SingleflightGetter{Getter: slow}
+	leader := make(chan error)
+	go func() {
+		_, err := g.Get(url)
+		leader <- err
+	}()
+	for atomic.LoadInt32(&slow.gets) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// A waiter that gives up returns without waiting for the shared fetch.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.GetContext(ctx, url); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext(cancelled, %q) = _, %v. Want %v", url, err, context.Canceled)
+	}
+	close(slow.release)
+	if err := <-leader; err != nil {
+		t.Errorf("Get(%q) = _, %v. Want nil", url, err)
+	}
+}
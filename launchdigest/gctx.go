@@ -0,0 +1,124 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package launchdigest computes the expected SEV-SNP launch digest, i.e., the MEASUREMENT field of
+// an attestation report, from the guest's initial memory contents.
+package launchdigest
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+)
+
+// PageType is the PAGE_TYPE of a SNP_LAUNCH_UPDATE command as defined in the SNP API
+// specification.
+type PageType uint8
+
+const (
+	// PageTypeNormal is a page whose contents are measured.
+	PageTypeNormal PageType = 0x01
+	// PageTypeVMSA is a page holding a vCPU's VM save area.
+	PageTypeVMSA PageType = 0x02
+	// PageTypeZero is a page that is initialized to zeros.
+	PageTypeZero PageType = 0x03
+	// PageTypeUnmeasured is a page whose contents are not measured.
+	PageTypeUnmeasured PageType = 0x04
+	// PageTypeSecrets is the page the firmware populates with the guest's secrets.
+	PageTypeSecrets PageType = 0x05
+	// PageTypeCpuid is the page the firmware populates with validated CPUID values.
+	PageTypeCpuid PageType = 0x06
+
+	// PageSize is the size of a guest page in bytes.
+	PageSize = 0x1000
+	// VMSAGpa is the guest physical address that the VMSA pages are measured at.
+	VMSAGpa = 0xFFFFFFFFF000
+
+	pageInfoSize = 0x70
+)
+
+// Context holds the launch digest as it is extended page by page with the PAGE_INFO structure of
+// the SNP API's SNP_LAUNCH_UPDATE command.
+type Context struct {
+	digest [abi.MeasurementSize]byte
+}
+
+// NewContext returns a Context whose launch digest is all zeros, as at SNP_LAUNCH_START.
+func NewContext() *Context {
+	return &Context{}
+}
+
+// Digest returns the current launch digest.
+func (c *Context) Digest() [abi.MeasurementSize]byte {
+	return c.digest
+}
+
+// Update extends the launch digest with a page of the given type at the given guest physical
+// address. The contents are the SHA-384 digest of the page for normal and VMSA pages, and zeros
+// otherwise.
+func (c *Context) Update(pageType PageType, gpa uint64, contents [sha512.Size384]byte) {
+	var pageInfo [pageInfoSize]byte
+	copy(pageInfo[0x00:0x30], c.digest[:])
+	copy(pageInfo[0x30:0x60], contents[:])
+	binary.LittleEndian.PutUint16(pageInfo[0x60:0x62], pageInfoSize)
+	pageInfo[0x62] = byte(pageType)
+	// IMI_PAGE and the VMPL permissions at 0x63-0x66 are zero for a non-migrated guest launch.
+	binary.LittleEndian.PutUint64(pageInfo[0x68:0x70], gpa)
+	c.digest = sha512.Sum384(pageInfo[:])
+}
+
+// UpdateNormalPages extends the launch digest with the pages of data starting at gpa. The length
+// of data must be a multiple of PageSize.
+func (c *Context) UpdateNormalPages(gpa uint64, data []byte) error {
+	if len(data)%PageSize != 0 {
+		return fmt.Errorf("data length %d is not a multiple of the page size %d", len(data), PageSize)
+	}
+	for offset := 0; offset < len(data); offset += PageSize {
+		c.Update(PageTypeNormal, gpa+uint64(offset), sha512.Sum384(data[offset:offset+PageSize]))
+	}
+	return nil
+}
+
+// UpdateZeroPages extends the launch digest with length bytes of zero pages starting at gpa. The
+// length must be a multiple of PageSize.
+func (c *Context) UpdateZeroPages(gpa uint64, length uint32) error {
+	if length%PageSize != 0 {
+		return fmt.Errorf("zero pages length %d is not a multiple of the page size %d", length, PageSize)
+	}
+	for offset := uint32(0); offset < length; offset += PageSize {
+		c.Update(PageTypeZero, gpa+uint64(offset), [sha512.Size384]byte{})
+	}
+	return nil
+}
+
+// UpdateSecretsPage extends the launch digest with the secrets page at gpa.
+func (c *Context) UpdateSecretsPage(gpa uint64) {
+	c.Update(PageTypeSecrets, gpa, [sha512.Size384]byte{})
+}
+
+// UpdateCpuidPage extends the launch digest with the CPUID page at gpa.
+func (c *Context) UpdateCpuidPage(gpa uint64) {
+	c.Update(PageTypeCpuid, gpa, [sha512.Size384]byte{})
+}
+
+// UpdateVMSAPage extends the launch digest with a vCPU's VMSA page.
+func (c *Context) UpdateVMSAPage(vmsa []byte) error {
+	if len(vmsa) != PageSize {
+		return fmt.Errorf("VMSA page is %d bytes. Expected %d bytes", len(vmsa), PageSize)
+	}
+	c.Update(PageTypeVMSA, VMSAGpa, sha512.Sum384(vmsa))
+	return nil
+}
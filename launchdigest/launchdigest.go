@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launchdigest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/uuid"
+)
+
+const (
+	// SevHashTableHeaderGUID identifies the SEV hash table for measured direct boot.
+	SevHashTableHeaderGUID = "9438d606-4f22-4cc9-b479-a793d411fd21"
+	// SevKernelEntryGUID identifies the kernel hash entry of the SEV hash table.
+	SevKernelEntryGUID = "4de79437-abd2-427f-b835-d5b172d2045b"
+	// SevInitrdEntryGUID identifies the initrd hash entry of the SEV hash table.
+	SevInitrdEntryGUID = "44baf731-3a2f-4bd7-9af1-41e29169781d"
+	// SevCmdlineEntryGUID identifies the kernel command line hash entry of the SEV hash table.
+	SevCmdlineEntryGUID = "97d02dd8-bd20-4c94-aa78-e7714d36ab2a"
+
+	sevHashEntrySize = 16 + 2 + sha256.Size
+	sevHashTableSize = 16 + 2 + 3*sevHashEntrySize
+	// The table is padded to a 16 byte boundary in the hashes page.
+	paddedSevHashTableSize = (sevHashTableSize + 15) &^ 15
+)
+
+// KernelHashes are the SHA-256 digests of the kernel, initrd, and kernel command line that the VMM
+// places in the SEV hash table for measured direct boot.
+type KernelHashes struct {
+	Kernel  [sha256.Size]byte
+	Initrd  [sha256.Size]byte
+	Cmdline [sha256.Size]byte
+}
+
+// HashKernelInputs returns the KernelHashes for a direct boot of the given kernel, initrd, and
+// command line as QEMU computes them. The command line is hashed with its NUL terminator, and a
+// missing initrd is hashed as empty.
+func HashKernelInputs(kernel, initrd []byte, cmdline string) *KernelHashes {
+	return &KernelHashes{
+		Kernel:  sha256.Sum256(kernel),
+		Initrd:  sha256.Sum256(initrd),
+		Cmdline: sha256.Sum256(append([]byte(cmdline), 0)),
+	}
+}
+
+func putHashEntry(data []byte, guid string, hash [sha256.Size]byte) {
+	g := guidBytesLE(uuid.MustParse(guid))
+	copy(data[0:16], g[:])
+	binary.LittleEndian.PutUint16(data[16:18], sevHashEntrySize)
+	copy(data[18:18+sha256.Size], hash[:])
+}
+
+// Page returns the page holding the SEV hash table at the given offset, as measured at launch.
+func (h *KernelHashes) Page(offset uint32) ([]byte, error) {
+	if uint64(offset)+paddedSevHashTableSize > PageSize {
+		return nil, fmt.Errorf("SEV hash table at page offset 0x%x does not fit in a page", offset)
+	}
+	page := make([]byte, PageSize)
+	table := page[offset : offset+sevHashTableSize]
+	g := guidBytesLE(uuid.MustParse(SevHashTableHeaderGUID))
+	copy(table[0:16], g[:])
+	binary.LittleEndian.PutUint16(table[16:18], sevHashTableSize)
+	putHashEntry(table[18:], SevCmdlineEntryGUID, h.Cmdline)
+	putHashEntry(table[18+sevHashEntrySize:], SevInitrdEntryGUID, h.Initrd)
+	putHashEntry(table[18+2*sevHashEntrySize:], SevKernelEntryGUID, h.Kernel)
+	return page, nil
+}
+
+// Options are the inputs to the launch digest computation.
+type Options struct {
+	// OVMF is the firmware image.
+	OVMF []byte
+	// VCPUs is the number of vCPUs the guest launches with. Ignored if VMSAs is set.
+	VCPUs int
+	// VCPUSignature is the CPUID Fn0000_0001 EAX value of the guest's vCPU model. See CPUSignature.
+	// Ignored if VMSAs is set.
+	VCPUSignature uint32
+	// VMMType is the virtual machine monitor that creates the guest. Ignored if VMSAs is set.
+	VMMType VMMType
+	// GuestFeatures is the SEV_FEATURES value in the guest's VMSAs. If zero, it defaults to
+	// SevFeatureSNPActive. Ignored if VMSAs is set.
+	GuestFeatures uint64
+	// KernelHashes are the hashes of a measured direct boot. Nil if the guest does not boot a kernel
+	// directly.
+	KernelHashes *KernelHashes
+	// VMSAs are the precomputed VMSA pages of all vCPUs in order, starting with the bootstrap
+	// processor, e.g., for CPU models whose initial register state VMSAPage does not produce. If set,
+	// VCPUs, VCPUSignature, VMMType, and GuestFeatures are not used to create them.
+	VMSAs [][]byte
+}
+
+func updateMetadataSection(ctx *Context, ovmf *OVMF, section MetadataSection, opts *Options) error {
+	gpa := uint64(section.GPA)
+	switch section.Type {
+	case SectionSnpSecMem, SectionSvsmCaa:
+		return ctx.UpdateZeroPages(gpa, section.Size)
+	case SectionSnpSecrets:
+		ctx.UpdateSecretsPage(gpa)
+	case SectionCpuid:
+		// EC2 measures the CPUID page after all other metadata sections.
+		if opts.VMMType != VMMTypeEC2 {
+			ctx.UpdateCpuidPage(gpa)
+		}
+	case SectionSnpKernelHashes:
+		if opts.KernelHashes == nil {
+			return ctx.UpdateZeroPages(gpa, section.Size)
+		}
+		if section.Size != PageSize {
+			return fmt.Errorf("OVMF kernel hashes section size is 0x%x. Expected 0x%x", section.Size, PageSize)
+		}
+		tableGPA, err := ovmf.SevHashTableGPA()
+		if err != nil {
+			return err
+		}
+		page, err := opts.KernelHashes.Page(tableGPA & (PageSize - 1))
+		if err != nil {
+			return err
+		}
+		return ctx.UpdateNormalPages(gpa, page)
+	default:
+		return fmt.Errorf("unknown OVMF SEV metadata section type %d", section.Type)
+	}
+	return nil
+}
+
+func vmsaPages(ovmf *OVMF, opts *Options) ([][]byte, error) {
+	if len(opts.VMSAs) != 0 {
+		return opts.VMSAs, nil
+	}
+	if opts.VCPUs <= 0 {
+		return nil, fmt.Errorf("vCPU count %d must be positive", opts.VCPUs)
+	}
+	apEIP, err := ovmf.SevEsResetEIP()
+	if err != nil {
+		return nil, err
+	}
+	features := opts.GuestFeatures
+	if features == 0 {
+		features = SevFeatureSNPActive
+	}
+	bsp, err := VMSAPage(bspEIP, features, opts.VCPUSignature, opts.VMMType)
+	if err != nil {
+		return nil, err
+	}
+	ap, err := VMSAPage(apEIP, features, opts.VCPUSignature, opts.VMMType)
+	if err != nil {
+		return nil, err
+	}
+	result := [][]byte{bsp}
+	for i := 1; i < opts.VCPUs; i++ {
+		result = append(result, ap)
+	}
+	return result, nil
+}
+
+// SnpLaunchDigest returns the expected MEASUREMENT of an SEV-SNP guest launched with the given
+// options. The OVMF image is measured first, then its SEV metadata sections, then the VMSA of each
+// vCPU.
+func SnpLaunchDigest(opts *Options) ([abi.MeasurementSize]byte, error) {
+	var result [abi.MeasurementSize]byte
+	if opts == nil {
+		return result, fmt.Errorf("options are nil")
+	}
+	ovmf, err := ParseOVMF(opts.OVMF)
+	if err != nil {
+		return result, err
+	}
+	ctx := NewContext()
+	if err := ctx.UpdateNormalPages(ovmf.GPA(), ovmf.Data()); err != nil {
+		return result, err
+	}
+	hasKernelHashes := false
+	for _, section := range ovmf.MetadataSections() {
+		if err := updateMetadataSection(ctx, ovmf, section, opts); err != nil {
+			return result, err
+		}
+		hasKernelHashes = hasKernelHashes || section.Type == SectionSnpKernelHashes
+	}
+	if opts.KernelHashes != nil && !hasKernelHashes {
+		return result, fmt.Errorf("kernel hashes given, but OVMF has no kernel hashes section")
+	}
+	if opts.VMMType == VMMTypeEC2 {
+		for _, section := range ovmf.MetadataSections() {
+			if section.Type == SectionCpuid {
+				ctx.UpdateCpuidPage(uint64(section.GPA))
+			}
+		}
+	}
+	vmsas, err := vmsaPages(ovmf, opts)
+	if err != nil {
+		return result, err
+	}
+	for i, vmsa := range vmsas {
+		if err := ctx.UpdateVMSAPage(vmsa); err != nil {
+			return result, fmt.Errorf("vCPU %d: %v", i, err)
+		}
+	}
+	return ctx.Digest(), nil
+}
@@ -0,0 +1,370 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launchdigest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ovmfImage = flag.String("ovmf", "",
+		"Path to an OVMF build (e.g., OVMF.fd of AmdSev) for a launch digest known-answer test")
+	ovmfDigest = flag.String("ovmf_digest", "",
+		"Expected hex launch digest of -ovmf, e.g., from sev-snp-measure or an attestation report")
+	ovmfVCPUs         = flag.Int("ovmf_vcpus", 1, "Number of vCPUs of the -ovmf known-answer test")
+	ovmfVCPUSignature = flag.Uint("ovmf_vcpu_sig", uint(CPUSignature(25, 1, 1)),
+		"CPUID Fn0000_0001 EAX of the -ovmf known-answer test's vCPU model")
+)
+
+const (
+	fakeImageSize      = 0x8000
+	fakeMetadataOffset = 0x1000
+	fakeResetEIP       = 0x80b004
+	fakeHashTableGPA   = 0x80fc00
+)
+
+var fakeSections = []MetadataSection{
+	{GPA: 0x800000, Size: 0x9000, Type: SectionSnpSecMem},
+	{GPA: 0x809000, Size: 0x1000, Type: SectionSnpSecrets},
+	{GPA: 0x80a000, Size: 0x1000, Type: SectionCpuid},
+	{GPA: 0x80b000, Size: 0x4000, Type: SectionSnpSecMem},
+	{GPA: 0x80f000, Size: 0x1000, Type: SectionSnpKernelHashes},
+	{GPA: 0x810000, Size: 0x1000, Type: SectionSvsmCaa},
+}
+
+type tableEntry struct {
+	guid string
+	data []byte
+}
+
+func le32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// fakeOVMF returns a firmware image with the GUIDed table and SEV metadata layout of OVMF. The
+// published OVMF builds are too large to check in, so the known answers below are for this image.
+func fakeOVMF(sections []MetadataSection, entries []tableEntry) []byte {
+	image := make([]byte, fakeImageSize)
+	for i := range image {
+		image[i] = byte(i * 7)
+	}
+	metadata := image[fakeMetadataOffset:]
+	copy(metadata[0:4], sevMetadataSignature)
+	binary.LittleEndian.PutUint32(metadata[4:8], uint32(sevMetadataHeaderSize+sevMetadataItemSize*len(sections)))
+	binary.LittleEndian.PutUint32(metadata[8:12], 1)
+	binary.LittleEndian.PutUint32(metadata[12:16], uint32(len(sections)))
+	for i, s := range sections {
+		item := metadata[sevMetadataHeaderSize+i*sevMetadataItemSize:]
+		binary.LittleEndian.PutUint32(item[0:4], s.GPA)
+		binary.LittleEndian.PutUint32(item[4:8], s.Size)
+		binary.LittleEndian.PutUint32(item[8:12], uint32(s.Type))
+	}
+	var table []byte
+	for _, e := range entries {
+		table = append(table, e.data...)
+		table = binary.LittleEndian.AppendUint16(table, uint16(len(e.data)+ovmfEntryHeaderSize))
+		guid := guidBytesLE(uuid.MustParse(e.guid))
+		table = append(table, guid[:]...)
+	}
+	table = binary.LittleEndian.AppendUint16(table, uint16(len(table)+ovmfEntryHeaderSize))
+	footer := guidBytesLE(uuid.MustParse(OvmfTableFooterGUID))
+	table = append(table, footer[:]...)
+	copy(image[fakeImageSize-ovmfTableEndOffset-len(table):], table)
+	return image
+}
+
+func defaultFakeOVMF() []byte {
+	return fakeOVMF(fakeSections, []tableEntry{
+		{guid: OvmfSevMetadataGUID, data: le32(fakeImageSize - fakeMetadataOffset)},
+		{guid: SevHashTableRVGUID, data: append(le32(fakeHashTableGPA), le32(0x400)...)},
+		{guid: SevEsResetBlockGUID, data: le32(fakeResetEIP)},
+	})
+}
+
+func TestCPUSignature(t *testing.T) {
+	tcs := []struct {
+		name                    string
+		family, model, stepping uint32
+		want                    uint32
+	}{
+		{name: "EPYC", family: 23, model: 1, stepping: 2, want: 0x800f12},
+		{name: "EPYC-Milan", family: 25, model: 1, stepping: 1, want: 0xa00f11},
+		{name: "EPYC-Genoa", family: 25, model: 17, stepping: 0, want: 0xa10f10},
+		{name: "family below 0xf", family: 6, model: 0x3a, stepping: 9, want: 0x306a9},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CPUSignature(tc.family, tc.model, tc.stepping); got != tc.want {
+				t.Errorf("CPUSignature(%d, %d, %d) = 0x%x. Want 0x%x", tc.family, tc.model, tc.stepping, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKernelHashesPage(t *testing.T) {
+	h := HashKernelInputs([]byte("kernel"), nil, "console=ttyS0")
+	if want := sha256.Sum256([]byte("console=ttyS0\x00")); h.Cmdline != want {
+		t.Errorf("Cmdline hash = %x. Want %x", h.Cmdline, want)
+	}
+	if want := sha256.Sum256(nil); h.Initrd != want {
+		t.Errorf("Initrd hash = %x. Want %x", h.Initrd, want)
+	}
+	page, err := h.Page(0xc00)
+	if err != nil {
+		t.Fatalf("Page(0xc00) = %v. Want nil", err)
+	}
+	if len(page) != PageSize {
+		t.Fatalf("Page(0xc00) length = %d. Want %d", len(page), PageSize)
+	}
+	table := page[0xc00:]
+	header := guidBytesLE(uuid.MustParse(SevHashTableHeaderGUID))
+	if !bytes.Equal(table[0:16], header[:]) {
+		t.Errorf("hash table GUID = %x. Want %x", table[0:16], header)
+	}
+	if got := binary.LittleEndian.Uint16(table[16:18]); got != sevHashTableSize {
+		t.Errorf("hash table length = %d. Want %d", got, sevHashTableSize)
+	}
+	entries := []struct {
+		guid string
+		hash [sha256.Size]byte
+	}{
+		{SevCmdlineEntryGUID, h.Cmdline},
+		{SevInitrdEntryGUID, h.Initrd},
+		{SevKernelEntryGUID, h.Kernel},
+	}
+	for i, e := range entries {
+		entry := table[18+i*sevHashEntrySize:]
+		g := guidBytesLE(uuid.MustParse(e.guid))
+		if !bytes.Equal(entry[0:16], g[:]) {
+			t.Errorf("entry %d GUID = %x. Want %x", i, entry[0:16], g)
+		}
+		if !bytes.Equal(entry[18:18+sha256.Size], e.hash[:]) {
+			t.Errorf("entry %d hash = %x. Want %x", i, entry[18:18+sha256.Size], e.hash)
+		}
+	}
+	if !isZero(page[:0xc00]) || !isZero(table[sevHashTableSize:]) {
+		t.Error("Page(0xc00) has non-zero bytes outside the hash table")
+	}
+	if _, err := h.Page(PageSize - 0x10); err == nil {
+		t.Error("Page(0xff0) = nil. Want an error")
+	}
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseOVMF(t *testing.T) {
+	ovmf, err := ParseOVMF(defaultFakeOVMF())
+	if err != nil {
+		t.Fatalf("ParseOVMF() = %v. Want nil", err)
+	}
+	if got, want := ovmf.GPA(), uint64(1<<32-fakeImageSize); got != want {
+		t.Errorf("GPA() = 0x%x. Want 0x%x", got, want)
+	}
+	if got := ovmf.MetadataSections(); len(got) != len(fakeSections) {
+		t.Errorf("MetadataSections() = %v. Want %v", got, fakeSections)
+	} else {
+		for i := range got {
+			if got[i] != fakeSections[i] {
+				t.Errorf("MetadataSections()[%d] = %v. Want %v", i, got[i], fakeSections[i])
+			}
+		}
+	}
+	if eip, err := ovmf.SevEsResetEIP(); err != nil || eip != fakeResetEIP {
+		t.Errorf("SevEsResetEIP() = 0x%x, %v. Want 0x%x, nil", eip, err, fakeResetEIP)
+	}
+	if gpa, err := ovmf.SevHashTableGPA(); err != nil || gpa != fakeHashTableGPA {
+		t.Errorf("SevHashTableGPA() = 0x%x, %v. Want 0x%x, nil", gpa, err, fakeHashTableGPA)
+	}
+}
+
+func TestSnpLaunchDigest(t *testing.T) {
+	milan := CPUSignature(25, 1, 1)
+	// The expected digests were computed for the fake image by a separate Python implementation
+	// that follows virtee/sev-snp-measure and lays out PAGE_INFO and the VMSA from the SEV-SNP ABI
+	// specification and Linux's struct sev_es_save_area, not from this package's constants. Run
+	// TestSnpLaunchDigestOVMF for a published OVMF build.
+	tcs := []struct {
+		name string
+		opts *Options
+		want string
+	}{
+		{
+			name: "1 vCPU",
+			opts: &Options{VCPUs: 1, VCPUSignature: milan},
+			want: "49e14a72ac72fe539f2e970c40025364cf635d58853aca9a0193784906f150b40ebf34526fff741c63cade6acb876e7d",
+		},
+		{
+			name: "4 vCPUs",
+			opts: &Options{VCPUs: 4, VCPUSignature: milan},
+			want: "1b5bd7189918b9d24a2c6a9d9a9cb9731996bfaf68a26108fd6f8d744dff0b3d05482fe9807eb566193060120860d1dd",
+		},
+		{
+			name: "EC2",
+			opts: &Options{VCPUs: 2, VCPUSignature: milan, VMMType: VMMTypeEC2},
+			want: "f729de9467e6b11339cd757c4905036ebcbf2279b7b3b0e097ce94b2ca7211e1f81fdd986a76b72559ff6fd6e79a50ca",
+		},
+		{
+			name: "kernel hashes",
+			opts: &Options{
+				VCPUs:         2,
+				VCPUSignature: milan,
+				KernelHashes:  HashKernelInputs([]byte("kernel"), []byte("initrd"), "console=ttyS0"),
+			},
+			want: "28b206137897160f63dc0c78e0b0a0d9e3bc01d6639778b2f7e31d784ba5b2e13faa8485478ba3c0e575a3c1d7cd4c5d",
+		},
+		{
+			name: "guest features",
+			opts: &Options{VCPUs: 1, VCPUSignature: CPUSignature(25, 17, 0), GuestFeatures: 0x21},
+			want: "86b9a3b603a5d9e65bb5af019fb30b01b43ceb4da307c5351390ebc3c6d34b70255edc4aa7a7e668d5f7f5d73381bd70",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.OVMF = defaultFakeOVMF()
+			got, err := SnpLaunchDigest(tc.opts)
+			if err != nil {
+				t.Fatalf("SnpLaunchDigest() = %v. Want nil", err)
+			}
+			if hex.EncodeToString(got[:]) != tc.want {
+				t.Errorf("SnpLaunchDigest() = %x. Want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSnpLaunchDigestPrecomputedVMSAs(t *testing.T) {
+	sig := CPUSignature(25, 1, 1)
+	bsp, err := VMSAPage(bspEIP, SevFeatureSNPActive, sig, VMMTypeQEMU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap, err := VMSAPage(fakeResetEIP, SevFeatureSNPActive, sig, VMMTypeQEMU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := SnpLaunchDigest(&Options{OVMF: defaultFakeOVMF(), VCPUs: 2, VCPUSignature: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := SnpLaunchDigest(&Options{OVMF: defaultFakeOVMF(), VMSAs: [][]byte{bsp, ap}})
+	if err != nil {
+		t.Fatalf("SnpLaunchDigest() = %v. Want nil", err)
+	}
+	if got != want {
+		t.Errorf("SnpLaunchDigest() with precomputed VMSAs = %x. Want %x", got, want)
+	}
+}
+
+func TestSnpLaunchDigestErrors(t *testing.T) {
+	badSignature := defaultFakeOVMF()
+	copy(badSignature[fakeMetadataOffset:], "XXXX")
+	noHashTable := fakeOVMF(fakeSections[:4], []tableEntry{
+		{guid: OvmfSevMetadataGUID, data: le32(fakeImageSize - fakeMetadataOffset)},
+		{guid: SevEsResetBlockGUID, data: le32(fakeResetEIP)},
+	})
+	tcs := []struct {
+		name    string
+		opts    *Options
+		wantErr string
+	}{
+		{
+			name:    "nil options",
+			wantErr: "options are nil",
+		},
+		{
+			name:    "image size",
+			opts:    &Options{OVMF: make([]byte, 0x1234), VCPUs: 1},
+			wantErr: "not a non-zero multiple of the page size",
+		},
+		{
+			name:    "no footer",
+			opts:    &Options{OVMF: make([]byte, fakeImageSize), VCPUs: 1},
+			wantErr: "does not have a GUIDed table footer",
+		},
+		{
+			name:    "metadata signature",
+			opts:    &Options{OVMF: badSignature, VCPUs: 1},
+			wantErr: "SEV metadata signature",
+		},
+		{
+			name:    "no kernel hashes section",
+			opts:    &Options{OVMF: noHashTable, VCPUs: 1, KernelHashes: &KernelHashes{}},
+			wantErr: "no kernel hashes section",
+		},
+		{
+			name:    "no vCPUs",
+			opts:    &Options{OVMF: defaultFakeOVMF()},
+			wantErr: "vCPU count 0 must be positive",
+		},
+		{
+			name:    "bad VMSA",
+			opts:    &Options{OVMF: defaultFakeOVMF(), VMSAs: [][]byte{make([]byte, 16)}},
+			wantErr: "VMSA page is 16 bytes",
+		},
+		{
+			name:    "VMM type",
+			opts:    &Options{OVMF: defaultFakeOVMF(), VCPUs: 1, VMMType: 7},
+			wantErr: "unknown VMM type 7",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := SnpLaunchDigest(tc.opts); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("SnpLaunchDigest() = %v. Want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestSnpLaunchDigestOVMF checks the launch digest of a real OVMF build, which is too large to keep
+// in the repository, e.g.,
+//
+//	go test ./launchdigest -run OVMF -args -ovmf=OVMF.fd -ovmf_vcpus=4 -ovmf_digest=<hex>
+func TestSnpLaunchDigestOVMF(t *testing.T) {
+	if *ovmfImage == "" {
+		t.Skip("no -ovmf image")
+	}
+	data, err := os.ReadFile(*ovmfImage)
+	if err != nil {
+		t.Fatalf("could not read -ovmf image: %v", err)
+	}
+	want, err := hex.DecodeString(*ovmfDigest)
+	if err != nil || len(want) != 48 {
+		t.Fatalf("-ovmf_digest %q is not a hex SHA-384 digest", *ovmfDigest)
+	}
+	got, err := SnpLaunchDigest(&Options{OVMF: data, VCPUs: *ovmfVCPUs, VCPUSignature: uint32(*ovmfVCPUSignature)})
+	if err != nil {
+		t.Fatalf("SnpLaunchDigest(%q) = %v. Want nil", *ovmfImage, err)
+	}
+	if !bytes.Equal(got[:], want) {
+		t.Errorf("SnpLaunchDigest(%q) = %x. Want %x", *ovmfImage, got, want)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launchdigest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// OvmfTableFooterGUID identifies the footer of the GUIDed table at the end of an OVMF image.
+	OvmfTableFooterGUID = "96b582de-1fb2-45f7-baea-a366c55a082d"
+	// SevHashTableRVGUID identifies the OVMF table entry for the GPA of the SEV hash table.
+	SevHashTableRVGUID = "7255371f-3a3b-4b04-927b-1da6efa8d454"
+	// SevEsResetBlockGUID identifies the OVMF table entry for the application processors' reset
+	// vector.
+	SevEsResetBlockGUID = "00f771de-1a7e-4fcb-890e-68c77e2fb44e"
+	// OvmfSevMetadataGUID identifies the OVMF table entry for the offset of the SEV metadata.
+	OvmfSevMetadataGUID = "dc886566-984a-4798-a75e-5585a7bf67cc"
+
+	fourGiB = 0x100000000
+	// The GUIDed table ends this many bytes before the end of the OVMF image.
+	ovmfTableEndOffset = 0x20
+	// Each table entry ends with its uint16 size and GUID.
+	ovmfEntryHeaderSize = 2 + 16
+
+	sevMetadataSignature  = "ASEV"
+	sevMetadataHeaderSize = 16
+	sevMetadataItemSize   = 12
+)
+
+// SectionType is the type of a section in OVMF's SEV metadata.
+type SectionType uint32
+
+const (
+	// SectionSnpSecMem is memory that is pre-validated as zero pages.
+	SectionSnpSecMem SectionType = 1
+	// SectionSnpSecrets is the secrets page.
+	SectionSnpSecrets SectionType = 2
+	// SectionCpuid is the CPUID page.
+	SectionCpuid SectionType = 3
+	// SectionSvsmCaa is the SVSM calling area, which is pre-validated as zero pages.
+	SectionSvsmCaa SectionType = 4
+	// SectionSnpKernelHashes is the page that holds the SEV hash table for measured direct boot.
+	SectionSnpKernelHashes SectionType = 0x10
+)
+
+// MetadataSection describes a range of guest memory that OVMF expects to be initialized at launch.
+type MetadataSection struct {
+	GPA  uint32
+	Size uint32
+	Type SectionType
+}
+
+// OVMF represents the launch-relevant contents of an OVMF firmware image.
+type OVMF struct {
+	data     []byte
+	table    map[uuid.UUID][]byte
+	metadata []MetadataSection
+}
+
+// guidBytesLE returns the mixed-endian byte encoding of a GUID that OVMF uses.
+func guidBytesLE(g uuid.UUID) [16]byte {
+	var result [16]byte
+	copy(result[:], g[:])
+	result[0], result[1], result[2], result[3] = g[3], g[2], g[1], g[0]
+	result[4], result[5] = g[5], g[4]
+	result[6], result[7] = g[7], g[6]
+	return result
+}
+
+// guidFromBytesLE is the inverse of guidBytesLE.
+func guidFromBytesLE(b []byte) uuid.UUID {
+	var le [16]byte
+	copy(le[:], b)
+	// The byte swaps are their own inverse.
+	return uuid.UUID(guidBytesLE(uuid.UUID(le)))
+}
+
+// ParseOVMF returns the OVMF representation of a firmware image, or an error if the image does not
+// have the GUIDed table and SEV metadata needed for an SEV-SNP launch.
+func ParseOVMF(data []byte) (*OVMF, error) {
+	if len(data) == 0 || len(data)%PageSize != 0 {
+		return nil, fmt.Errorf("OVMF image size %d is not a non-zero multiple of the page size %d", len(data), PageSize)
+	}
+	if uint64(len(data)) > fourGiB {
+		return nil, fmt.Errorf("OVMF image size %d does not fit below 4GiB", len(data))
+	}
+	o := &OVMF{data: data}
+	if err := o.parseTable(); err != nil {
+		return nil, err
+	}
+	if err := o.parseMetadata(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *OVMF) parseTable() error {
+	footerStart := len(o.data) - ovmfTableEndOffset - ovmfEntryHeaderSize
+	if footerStart < 0 {
+		return fmt.Errorf("OVMF image too small for a GUIDed table footer")
+	}
+	footer := o.data[footerStart : footerStart+ovmfEntryHeaderSize]
+	footerGUID := guidBytesLE(uuid.MustParse(OvmfTableFooterGUID))
+	if !bytes.Equal(footer[2:], footerGUID[:]) {
+		return fmt.Errorf("OVMF image does not have a GUIDed table footer")
+	}
+	tableSize := int(binary.LittleEndian.Uint16(footer[0:2])) - ovmfEntryHeaderSize
+	if tableSize < 0 || tableSize > footerStart {
+		return fmt.Errorf("OVMF GUIDed table size %d is invalid", tableSize+ovmfEntryHeaderSize)
+	}
+	table := o.data[footerStart-tableSize : footerStart]
+	o.table = make(map[uuid.UUID][]byte)
+	// Entries are parsed from the end, since each entry's header follows its data.
+	for len(table) >= ovmfEntryHeaderSize {
+		header := table[len(table)-ovmfEntryHeaderSize:]
+		entrySize := int(binary.LittleEndian.Uint16(header[0:2]))
+		if entrySize < ovmfEntryHeaderSize || entrySize > len(table) {
+			return fmt.Errorf("OVMF GUIDed table entry size %d is invalid", entrySize)
+		}
+		o.table[guidFromBytesLE(header[2:])] = table[len(table)-entrySize : len(table)-ovmfEntryHeaderSize]
+		table = table[:len(table)-entrySize]
+	}
+	return nil
+}
+
+// tableUint32 returns the first 4 bytes of the GUIDed table entry as a little endian uint32.
+func (o *OVMF) tableUint32(guid string) (uint32, error) {
+	entry, ok := o.table[uuid.MustParse(guid)]
+	if !ok {
+		return 0, fmt.Errorf("OVMF GUIDed table has no entry %s", guid)
+	}
+	if len(entry) < 4 {
+		return 0, fmt.Errorf("OVMF GUIDed table entry %s is too small: %d bytes", guid, len(entry))
+	}
+	return binary.LittleEndian.Uint32(entry[0:4]), nil
+}
+
+func (o *OVMF) parseMetadata() error {
+	offsetFromEnd, err := o.tableUint32(OvmfSevMetadataGUID)
+	if err != nil {
+		return err
+	}
+	start := len(o.data) - int(offsetFromEnd)
+	if offsetFromEnd > uint32(len(o.data)) || start+sevMetadataHeaderSize > len(o.data) {
+		return fmt.Errorf("OVMF SEV metadata offset 0x%x is outside the image", offsetFromEnd)
+	}
+	header := o.data[start : start+sevMetadataHeaderSize]
+	if string(header[0:4]) != sevMetadataSignature {
+		return fmt.Errorf("OVMF SEV metadata signature is %q. Expected %q", header[0:4], sevMetadataSignature)
+	}
+	numItems := int(binary.LittleEndian.Uint32(header[12:16]))
+	items := o.data[start+sevMetadataHeaderSize:]
+	if numItems > len(items)/sevMetadataItemSize {
+		return fmt.Errorf("OVMF SEV metadata has %d sections, which do not fit in the image", numItems)
+	}
+	for i := 0; i < numItems; i++ {
+		item := items[i*sevMetadataItemSize : (i+1)*sevMetadataItemSize]
+		o.metadata = append(o.metadata, MetadataSection{
+			GPA:  binary.LittleEndian.Uint32(item[0:4]),
+			Size: binary.LittleEndian.Uint32(item[4:8]),
+			Type: SectionType(binary.LittleEndian.Uint32(item[8:12])),
+		})
+	}
+	return nil
+}
+
+// GPA returns the guest physical address that the image is loaded at, right below 4GiB.
+func (o *OVMF) GPA() uint64 {
+	return fourGiB - uint64(len(o.data))
+}
+
+// Data returns the firmware image.
+func (o *OVMF) Data() []byte {
+	return o.data
+}
+
+// MetadataSections returns the sections of OVMF's SEV metadata in image order.
+func (o *OVMF) MetadataSections() []MetadataSection {
+	return o.metadata
+}
+
+// SevEsResetEIP returns the reset vector of the application processors.
+func (o *OVMF) SevEsResetEIP() (uint32, error) {
+	return o.tableUint32(SevEsResetBlockGUID)
+}
+
+// SevHashTableGPA returns the GPA of the SEV hash table used for measured direct boot.
+func (o *OVMF) SevHashTableGPA() (uint32, error) {
+	return o.tableUint32(SevHashTableRVGUID)
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launchdigest
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// VMMType identifies the virtual machine monitor, which determines some initial vCPU register
+// values.
+type VMMType int
+
+const (
+	// VMMTypeQEMU is QEMU/KVM.
+	VMMTypeQEMU VMMType = iota
+	// VMMTypeEC2 is Amazon EC2.
+	VMMTypeEC2
+)
+
+const (
+	// bspEIP is the reset vector of the bootstrap processor.
+	bspEIP = 0xfffffff0
+	// SevFeatureSNPActive is the SEV_FEATURES bit that all SEV-SNP guests have set.
+	SevFeatureSNPActive = 1 << 0
+
+	// Offsets into the VMSA, i.e., the SEV-ES save area.
+	vmsaEsOffset          = 0x000
+	vmsaCsOffset          = 0x010
+	vmsaSsOffset          = 0x020
+	vmsaDsOffset          = 0x030
+	vmsaFsOffset          = 0x040
+	vmsaGsOffset          = 0x050
+	vmsaGdtrOffset        = 0x060
+	vmsaLdtrOffset        = 0x070
+	vmsaIdtrOffset        = 0x080
+	vmsaTrOffset          = 0x090
+	vmsaEferOffset        = 0x0d0
+	vmsaCr4Offset         = 0x148
+	vmsaCr0Offset         = 0x158
+	vmsaDr7Offset         = 0x160
+	vmsaDr6Offset         = 0x168
+	vmsaRflagsOffset      = 0x170
+	vmsaRipOffset         = 0x178
+	vmsaGPatOffset        = 0x268
+	vmsaRdxOffset         = 0x310
+	vmsaSevFeaturesOffset = 0x3b0
+	vmsaXcr0Offset        = 0x3e8
+	vmsaMxcsrOffset       = 0x408
+	vmsaX87FcwOffset      = 0x410
+)
+
+// CPUSignature returns the CPUID Fn0000_0001 EAX value, i.e., the vCPU signature, for the given
+// family, model, and stepping.
+func CPUSignature(family, model, stepping uint32) uint32 {
+	familyLow, familyHigh := family, uint32(0)
+	if family > 0xf {
+		familyLow, familyHigh = 0xf, (family-0xf)&0xff
+	}
+	return familyHigh<<20 | ((model>>4)&0xf)<<16 | familyLow<<8 | (model&0xf)<<4 | stepping&0xf
+}
+
+// putSegment writes a VMCB segment register at the given VMSA offset.
+func putSegment(vmsa []byte, offset int, selector, attrib uint16, limit uint32, base uint64) {
+	binary.LittleEndian.PutUint16(vmsa[offset:offset+2], selector)
+	binary.LittleEndian.PutUint16(vmsa[offset+2:offset+4], attrib)
+	binary.LittleEndian.PutUint32(vmsa[offset+4:offset+8], limit)
+	binary.LittleEndian.PutUint64(vmsa[offset+8:offset+16], base)
+}
+
+// VMSAPage returns the initial VMSA page of a vCPU that starts executing at eip as the given VMM
+// creates it.
+func VMSAPage(eip uint32, sevFeatures uint64, vcpuSignature uint32, vmmType VMMType) ([]byte, error) {
+	var csAttrib, ssAttrib, trAttrib uint16
+	var rdx uint64
+	var mxcsr uint32
+	var fcw uint16
+	switch vmmType {
+	case VMMTypeQEMU:
+		csAttrib, ssAttrib, trAttrib = 0x9b, 0x93, 0x8b
+		rdx = uint64(vcpuSignature)
+		mxcsr, fcw = 0x1f80, 0x37f
+	case VMMTypeEC2:
+		csAttrib, ssAttrib, trAttrib = 0x9b, 0x92, 0x83
+		if eip == bspEIP {
+			csAttrib = 0x9a
+		}
+	default:
+		return nil, fmt.Errorf("unknown VMM type %d", vmmType)
+	}
+	vmsa := make([]byte, PageSize)
+	putSegment(vmsa, vmsaEsOffset, 0, 0x93, 0xffff, 0)
+	putSegment(vmsa, vmsaCsOffset, 0xf000, csAttrib, 0xffff, uint64(eip&0xffff0000))
+	putSegment(vmsa, vmsaSsOffset, 0, ssAttrib, 0xffff, 0)
+	putSegment(vmsa, vmsaDsOffset, 0, 0x93, 0xffff, 0)
+	putSegment(vmsa, vmsaFsOffset, 0, 0x93, 0xffff, 0)
+	putSegment(vmsa, vmsaGsOffset, 0, 0x93, 0xffff, 0)
+	putSegment(vmsa, vmsaGdtrOffset, 0, 0, 0xffff, 0)
+	putSegment(vmsa, vmsaLdtrOffset, 0, 0x82, 0xffff, 0)
+	putSegment(vmsa, vmsaIdtrOffset, 0, 0, 0xffff, 0)
+	putSegment(vmsa, vmsaTrOffset, 0, trAttrib, 0xffff, 0)
+	// KVM enables EFER.SVME and CR4.MCE.
+	binary.LittleEndian.PutUint64(vmsa[vmsaEferOffset:], 0x1000)
+	binary.LittleEndian.PutUint64(vmsa[vmsaCr4Offset:], 0x40)
+	binary.LittleEndian.PutUint64(vmsa[vmsaCr0Offset:], 0x10)
+	binary.LittleEndian.PutUint64(vmsa[vmsaDr7Offset:], 0x400)
+	binary.LittleEndian.PutUint64(vmsa[vmsaDr6Offset:], 0xffff0ff0)
+	binary.LittleEndian.PutUint64(vmsa[vmsaRflagsOffset:], 0x2)
+	binary.LittleEndian.PutUint64(vmsa[vmsaRipOffset:], uint64(eip&0xffff))
+	// The PAT MSR reset value, see AMD APM Vol 2, Section A.3.
+	binary.LittleEndian.PutUint64(vmsa[vmsaGPatOffset:], 0x0007040600070406)
+	binary.LittleEndian.PutUint64(vmsa[vmsaRdxOffset:], rdx)
+	binary.LittleEndian.PutUint64(vmsa[vmsaSevFeaturesOffset:], sevFeatures)
+	binary.LittleEndian.PutUint64(vmsa[vmsaXcr0Offset:], 0x1)
+	binary.LittleEndian.PutUint32(vmsa[vmsaMxcsrOffset:], mxcsr)
+	binary.LittleEndian.PutUint16(vmsa[vmsaX87FcwOffset:], fcw)
+	return vmsa, nil
+}
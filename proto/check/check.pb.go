@@ -48,7 +48,8 @@ type Policy struct {
 
 	MinimumGuestSvn uint32 `protobuf:"varint,1,opt,name=minimum_guest_svn,json=minimumGuestSvn,proto3" json:"minimum_guest_svn,omitempty"`
 	// The component-wise maximum permissible guest policy, except
-	// API version values, and SingleSocket are the minimum permissible.
+	// API version values, SingleSocket, CXLAllow, MemAES256XTS, RAPLDis, and
+	// CiphertextHidingDRAM are the minimum permissible.
 	Policy                    uint64                `protobuf:"varint,2,opt,name=policy,proto3" json:"policy,omitempty"`
 	FamilyId                  []byte                `protobuf:"bytes,3,opt,name=family_id,json=familyId,proto3" json:"family_id,omitempty"` // Should be 16 bytes long
 	ImageId                   []byte                `protobuf:"bytes,4,opt,name=image_id,json=imageId,proto3" json:"image_id,omitempty"`    // Should be 16 bytes long
@@ -289,6 +290,8 @@ type RootOfTrust struct {
 	Product string `protobuf:"bytes,1,opt,name=product,proto3" json:"product,omitempty"`
 	// Paths to CA bundles for the AMD product.
 	// Must be in PEM format, AS[V]K, then ARK certificates.
+	// A path may also be a directory of PEM or DER certificate files, which are
+	// loaded for the product lines that the certificates name.
 	// This is for verifing a report's signature, as opposed to validating trust
 	// in the report's ID key or author key.
 	// If empty, uses the verification library's embedded certificates from AMD.
@@ -48,4 +48,5 @@ package proto
 
 //go:generate protoc -I$PROTOC_INSTALL_DIR/include -I=. --go_out=. --go_opt=module=github.com/google/go-sev-guest/proto check.proto
 //go:generate protoc --go_out=. --go_opt=module=github.com/google/go-sev-guest/proto fakekds.proto
+//go:generate protoc --go_out=. --go_opt=module=github.com/google/go-sev-guest/proto remote.proto
 //go:generate protoc --go_out=. --go_opt=module=github.com/google/go-sev-guest/proto sevsnp.proto
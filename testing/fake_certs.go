@@ -237,7 +237,7 @@ func (b *AmdSignerBuilder) unsignedRoot(arkName pkix.Name, key abi.ReportSigner,
 	var subject pkix.Name
 	issuer := arkName
 	cert := &x509.Certificate{}
-	crl := kds.CrlLinkByKey(b.productLine(), key)
+	crl := kds.CrlLinkByKey(kds.Product(b.productLine()), key)
 	sn := fmt.Sprintf("%x", subjectSerial)
 	switch key {
 	case abi.VcekReportSigner:
@@ -245,7 +245,7 @@ func (b *AmdSignerBuilder) unsignedRoot(arkName pkix.Name, key abi.ReportSigner,
 	case abi.VlekReportSigner:
 		subject = asvkName(b.productLine(), sn)
 	case abi.NoneReportSigner:
-		crl = kds.CrlLinkByKey(b.productLine(), abi.VcekReportSigner)
+		crl = kds.CrlLinkByKey(kds.Product(b.productLine()), abi.VcekReportSigner)
 		subject = arkName
 	}
 	cert.NotBefore = creationTime
@@ -565,48 +565,15 @@ func DefaultTestOnlyCertChain(productName string, creationTime time.Time) (*AmdS
 
 // CertTableBytes outputs the certificates in AMD's ABI format.
 func (s *AmdSigner) CertTableBytes() ([]byte, error) {
-	// Calculate the output size and the offset at which to copy each certificate.
-	const baseEntries = 6 // ARK, ASK, VCEK, VLEK, ASVK, NULL
-	entries := baseEntries + len(s.Extras)
-	headers := make([]abi.CertTableHeaderEntry, entries)
-	headers[0].GUID = uuid.MustParse(abi.ArkGUID)
-	headers[0].Offset = uint32(len(headers) * abi.CertTableEntrySize)
-	headers[0].Length = uint32(len(s.Ark.Raw))
-
-	headers[1].GUID = uuid.MustParse(abi.AskGUID)
-	headers[1].Offset = headers[0].Offset + headers[0].Length
-	headers[1].Length = uint32(len(s.Ask.Raw))
-
-	headers[2].GUID = uuid.MustParse(abi.VcekGUID)
-	headers[2].Offset = headers[1].Offset + headers[1].Length
-	headers[2].Length = uint32(len(s.Vcek.Raw))
-
-	headers[3].GUID = uuid.MustParse(abi.VlekGUID)
-	headers[3].Offset = headers[2].Offset + headers[2].Length
-	headers[3].Length = uint32(len(s.Vlek.Raw))
-
-	headers[4].GUID = uuid.MustParse(abi.AsvkGUID)
-	headers[4].Offset = headers[3].Offset + headers[3].Length
-	headers[4].Length = uint32(len(s.Asvk.Raw))
-
-	index := 4
-	blobs := [][]byte{s.Ark.Raw, s.Ask.Raw, s.Vcek.Raw, s.Vlek.Raw, s.Asvk.Raw}
+	table := &abi.CertTable{Entries: []abi.CertTableEntry{
+		{GUID: uuid.MustParse(abi.ArkGUID), RawCert: s.Ark.Raw},
+		{GUID: uuid.MustParse(abi.AskGUID), RawCert: s.Ask.Raw},
+		{GUID: uuid.MustParse(abi.VcekGUID), RawCert: s.Vcek.Raw},
+		{GUID: uuid.MustParse(abi.VlekGUID), RawCert: s.Vlek.Raw},
+		{GUID: uuid.MustParse(abi.AsvkGUID), RawCert: s.Asvk.Raw},
+	}}
 	for guid, data := range s.Extras {
-		prior := index
-		index++
-		headers[index].GUID = uuid.MustParse(guid)
-		headers[index].Offset = headers[prior].Offset + headers[prior].Length
-		headers[index].Length = uint32(len(data))
-		blobs = append(blobs, data)
-	}
-
-	// Write out the headers and the certificates at the appropriate offsets.
-	result := make([]byte, headers[index].Offset+headers[index].Length)
-	for i, cert := range blobs {
-		if err := (&headers[i]).Write(result[i*abi.CertTableEntrySize:]); err != nil {
-			return nil, err
-		}
-		copy(result[headers[i].Offset:], cert)
+		table.Entries = append(table.Entries, abi.CertTableEntry{GUID: uuid.MustParse(guid), RawCert: data})
 	}
-	return result, nil
+	return table.Marshal(), nil
 }
@@ -15,16 +15,20 @@
 package testing
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"net/http"
+	"sync"
 	"syscall"
 	"testing"
 
 	"github.com/google/go-sev-guest/abi"
 	labi "github.com/google/go-sev-guest/client/linuxabi"
+	"github.com/google/go-sev-guest/kds"
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
 	"github.com/pkg/errors"
-	"golang.org/x/sys/unix"
 )
 
 // GetReportResponse represents a mocked response to a command request.
@@ -35,17 +39,80 @@ type GetReportResponse struct {
 }
 
 // Device represents a sev-guest driver implementation with pre-programmed responses to commands.
+// Like LinuxDevice, it handles one command at a time, so it is safe for concurrent use.
 type Device struct {
+	mu            sync.Mutex
 	isOpen        bool
 	ReportDataRsp map[string]any
 	Keys          map[string][]byte
 	Certs         []byte
 	Signer        *AmdSigner
 	SevProduct    *spb.SevProduct
+	// WantDerivedKeyRequest, if not nil, is the only derived key request the device accepts. Others
+	// fail with a test error that describes the difference.
+	WantDerivedKeyRequest *labi.SnpDerivedKeyReqABI
+	// ThrottledResponses is the number of guest requests the device answers as throttled by the host
+	// before it handles any.
+	ThrottledResponses int
+	// VmpckDisabled makes the device refuse guest requests as the sev-guest driver does once it has
+	// disabled the VM communication key, e.g., after the key's message sequence numbers ran out.
+	VmpckDisabled bool
+	// Launch, if not nil, is the guest's launch state. The device reports it in place of the
+	// corresponding fields of its report responses, and derives keys from it as the firmware does
+	// instead of looking them up in Keys.
+	Launch *Launch
+}
+
+// Launch represents the launch state of a guest that the firmware mixes into derived keys.
+type Launch struct {
+	Policy       uint64
+	GuestSVN     uint32
+	FamilyID     [abi.FamilyIDSize]byte
+	ImageID      [abi.ImageIDSize]byte
+	Measurement  [abi.MeasurementSize]byte
+	CommittedTcb uint64
+	// Vmpl is the guest's current VMPL. Reports and keys at a more privileged VMPL are rejected.
+	Vmpl uint32
+}
+
+// report writes the launch state and the requested vmpl into a raw report.
+func (l *Launch) report(report []byte, vmpl uint32) {
+	binary.LittleEndian.PutUint32(report[0x30:0x34], vmpl)
+	binary.LittleEndian.PutUint32(report[0x04:0x08], l.GuestSVN)
+	binary.LittleEndian.PutUint64(report[0x08:0x10], l.Policy)
+	copy(report[0x10:0x20], l.FamilyID[:])
+	copy(report[0x20:0x30], l.ImageID[:])
+	copy(report[0x90:0xC0], l.Measurement[:])
+	binary.LittleEndian.PutUint64(report[0x1E0:0x1E8], l.CommittedTcb)
+}
+
+// derivedKey returns a key that depends on the request and the launch state it selects, or the
+// firmware status that rejects the request.
+func (l *Launch) derivedKey(req *labi.SnpDerivedKeyReqABI, fields abi.GuestFieldSelect) ([]byte, abi.SevFirmwareStatus) {
+	if req.GuestSVN > l.GuestSVN || req.TCBVersion > l.CommittedTcb || req.Vmpl < l.Vmpl {
+		return nil, abi.InvalidParam
+	}
+	h := sha256.New()
+	h.Write([]byte(DerivedKeyRequestToString(req)))
+	if fields.GuestPolicy {
+		binary.Write(h, binary.LittleEndian, l.Policy)
+	}
+	if fields.ImageID {
+		h.Write(l.ImageID[:])
+	}
+	if fields.FamilyID {
+		h.Write(l.FamilyID[:])
+	}
+	if fields.Measurement {
+		h.Write(l.Measurement[:])
+	}
+	return h.Sum(nil), abi.Success
 }
 
 // Open changes the mock device's state to open.
 func (d *Device) Open(_ string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.isOpen {
 		return errors.New("device already open")
 	}
@@ -55,6 +122,8 @@ func (d *Device) Open(_ string) error {
 
 // Close changes the mock device's state to closed.
 func (d *Device) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if !d.isOpen {
 		return errors.New("device already closed")
 	}
@@ -74,9 +143,17 @@ func (d *Device) getReport(req *labi.SnpReportReqABI, rsp *labi.SnpReportRespABI
 	esResult := uintptr(mockRsp.EsResult)
 	if mockRsp.FwErr != 0 {
 		*fwErr = uint64(mockRsp.FwErr)
-		return esResult, syscall.Errno(unix.EIO)
+		return esResult, syscall.Errno(syscall.EIO)
 	}
 	report := mockRsp.Resp.Data[:abi.ReportSize]
+	if d.Launch != nil {
+		if req.Vmpl < d.Launch.Vmpl {
+			rsp.Status = uint32(abi.InvalidParam)
+			return esResult, nil
+		}
+		report = append([]byte(nil), report...)
+		d.Launch.report(report, req.Vmpl)
+	}
 	r, s, err := d.Signer.Sign(abi.SignedComponent(report))
 	if err != nil {
 		return 0, fmt.Errorf("test error: could not sign report: %v", err)
@@ -89,18 +166,16 @@ func (d *Device) getReport(req *labi.SnpReportReqABI, rsp *labi.SnpReportRespABI
 }
 
 func (d *Device) getExtReport(req *labi.SnpExtendedReportReq, rsp *labi.SnpReportRespABI, fwErr *uint64) (uintptr, error) {
-	if req.CertsLength == 0 {
+	// Like the driver, a too small certificate buffer fails with the required length.
+	if req.CertsLength < uint32(len(d.Certs)) {
 		*fwErr = uint64(abi.GuestRequestInvalidLength)
 		req.CertsLength = uint32(len(d.Certs))
-		return 0, syscall.Errno(unix.EIO)
+		return 0, syscall.Errno(syscall.EIO)
 	}
 	ret, err := d.getReport(&req.Data, rsp, fwErr)
 	if err != nil {
 		return ret, err
 	}
-	if req.CertsLength < uint32(len(d.Certs)) {
-		return 0, fmt.Errorf("test failure: cert buffer too small: %d < %d", req.CertsLength, len(d.Certs))
-	}
 	copy(req.Certs, d.Certs)
 	return ret, nil
 }
@@ -111,6 +186,26 @@ func DerivedKeyRequestToString(req *labi.SnpDerivedKeyReqABI) string {
 }
 
 func (d *Device) getDerivedKey(req *labi.SnpDerivedKeyReqABI, rsp *labi.SnpDerivedKeyRespABI, _ *uint64) (uintptr, error) {
+	if want := d.WantDerivedKeyRequest; want != nil && *req != *want {
+		return 0, fmt.Errorf("test error: derived key request %s. Want %s", DerivedKeyRequestToString(req), DerivedKeyRequestToString(want))
+	}
+	// The firmware rejects undefined root key and guest field selections in the MSG_KEY_RSP status.
+	keySel := req.RootKeySelect >> labi.RootKeySelectKeySelShift
+	fields, err := abi.ParseGuestFieldSelect(req.GuestFieldSelect)
+	if err != nil || keySel > labi.KeySelVLEK {
+		rsp.Status = abi.InvalidParam
+		return 0, nil
+	}
+	if req.RootKeySelect&labi.RootKeySelectVMRK == 0 && keySel == labi.KeySelVLEK && (d.Signer == nil || d.Signer.Vlek == nil) {
+		rsp.Status = uint32(abi.InvalidKey)
+		return 0, nil
+	}
+	if d.Launch != nil {
+		key, status := d.Launch.derivedKey(req, fields)
+		rsp.Status = uint32(status)
+		copy(rsp.Data[:], key)
+		return 0, nil
+	}
 	if len(d.Keys) == 0 {
 		return 0, errors.New("test error: no keys")
 	}
@@ -124,8 +219,19 @@ func (d *Device) getDerivedKey(req *labi.SnpDerivedKeyReqABI, rsp *labi.SnpDeriv
 
 // Ioctl mocks commands with pre-specified responses for a finite number of requests.
 func (d *Device) Ioctl(command uintptr, req any) (uintptr, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	switch sreq := req.(type) {
 	case *labi.SnpUserGuestRequest:
+		if d.VmpckDisabled {
+			// The driver refuses the request before it would write back a firmware error.
+			return 0, syscall.Errno(syscall.ENOTTY)
+		}
+		if d.ThrottledResponses > 0 {
+			d.ThrottledResponses--
+			sreq.FwErr = uint64(abi.GuestRequestBusy)
+			return 0, syscall.Errno(syscall.EAGAIN)
+		}
 		switch command {
 		case labi.IocSnpGetReport:
 			return d.getReport(sreq.ReqData.(*labi.SnpReportReqABI), sreq.RespData.(*labi.SnpReportRespABI), &sreq.FwErr)
@@ -174,7 +280,7 @@ func (p *QuoteProvider) GetRawQuote(reportData [64]byte) ([]uint8, error) {
 		return nil, fmt.Errorf("test error: incorrect response type %v", mockRspI)
 	}
 	if mockRsp.FwErr != 0 {
-		return nil, syscall.Errno(unix.EIO)
+		return nil, syscall.Errno(syscall.EIO)
 	}
 	report := mockRsp.Resp.Data[:abi.ReportSize]
 	r, s, err := p.Device.Signer.Sign(abi.SignedComponent(report))
@@ -235,7 +341,7 @@ func SimpleGetter(responses map[string][]byte) *Getter {
 func (g *Getter) Get(url string) ([]byte, error) {
 	resp, ok := g.Responses[url]
 	if !ok || len(resp) == 0 {
-		return nil, fmt.Errorf("404: %s", url)
+		return nil, &kds.HTTPError{URL: url, StatusCode: http.StatusNotFound}
 	}
 	body := resp[0].Body
 	err := resp[0].Error
@@ -107,6 +107,7 @@ version: 2
 policy: 0xa0000
 signature_algo: 1
 signer_info: 4
+signing_key: 1
 report_data: '\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x02'
 family_id: '\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00'
 image_id: '\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00'
@@ -124,6 +125,8 @@ chip_id: '\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x
 type TestReportOptions struct {
 	ReportData []byte
 	SignerInfo abi.SignerInfo
+	// SignatureAlgo is the report's SIGNATURE_ALGO. Defaults to abi.SignEcdsaP384Sha384 if zero.
+	SignatureAlgo uint32
 }
 
 // TestRawReport creates simple raw attestation report with the given REPORT_DATA.
@@ -143,8 +146,11 @@ func CreateRawReport(opts *TestReportOptions) [labi.SnpReportRespReportSize]byte
 	// Set Version to 2
 	binary.LittleEndian.PutUint32(r[0x00:0x04], 2)
 	binary.LittleEndian.PutUint64(r[0x08:0x10], abi.SnpPolicyToBytes(abi.SnpPolicy{Debug: true}))
-	// Signature algorithm ECC P-384 with SHA-384 is encoded as 1.
-	binary.LittleEndian.PutUint32(r[0x34:0x38], 1)
+	algo := opts.SignatureAlgo
+	if algo == 0 {
+		algo = abi.SignEcdsaP384Sha384
+	}
+	binary.LittleEndian.PutUint32(r[0x34:0x38], algo)
 	binary.LittleEndian.PutUint32(r[0x48:0x4C], abi.ComposeSignerInfo(opts.SignerInfo))
 	// Place user data in its report location.
 	copy(r[0x50:0x90], opts.ReportData)
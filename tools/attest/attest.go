@@ -225,8 +225,10 @@ func main() {
 		}
 	}()
 
-	var reportData64 [abi.ReportDataSize]byte
-	copy(reportData64[:], reportData)
+	reportData64, err := abi.PadReportData(reportData)
+	if err != nil {
+		logger.Fatal(err)
+	}
 	if *extended {
 		if err := outputExtendedReport(reportData64, outwriter); err != nil {
 			logger.Fatal(err)
@@ -126,7 +126,7 @@ var (
 
 	stepping  = flag.String("stepping", "", "The machine stepping for the chip that generated the attestation report. Default unchecked.")
 	cabundles = flag.String("product_key_path", "",
-		"Colon-separated paths to CA bundles for the AMD product. Must be in PEM format, ASK, then ARK certificates. If unset, uses embedded root certificates.")
+		"Colon-separated paths to CA bundles for the AMD product. Must be in PEM format, ASK, then ARK certificates, or directories of PEM or DER certificates for the product lines they name. If unset, uses the embedded Milan root certificates, so other product lines fail.")
 	verbose     = flag.Bool("v", false, "Enable verbose logging.")
 	testKdsFile = flag.String("kdsdatabase", "", "Path to a fakekds.Certificates binary cache of AMD KDS")
 
@@ -154,6 +154,11 @@ func parseHashes(s string) ([][]byte, error) {
 }
 
 func parsePaths(s string) ([]string, error) {
+	return parsePathsAllowingDirs(s, false)
+}
+
+// parsePathsAllowingDirs is like parsePaths, but also accepts directories if allowDirs is true.
+func parsePathsAllowingDirs(s string, allowDirs bool) ([]string, error) {
 	paths := strings.Split(s, ":")
 	if len(paths) == 1 && paths[0] == "" {
 		return nil, nil
@@ -165,7 +170,7 @@ func parsePaths(s string) ([]string, error) {
 		if err != nil {
 			return nil, fmt.Errorf("path error for %q: %v", p, err)
 		}
-		if stat.IsDir() {
+		if stat.IsDir() && !allowDirs {
 			return nil, fmt.Errorf("path is not a file: %q", p)
 		}
 		result = append(result, p)
@@ -379,7 +384,7 @@ func populateRootOfTrust() error {
 	rot.DisallowNetwork = !networkValue
 	rot.ProductLine = kds.ProductLine(product)
 
-	paths, err := parsePaths(*cabundles)
+	paths, err := parsePathsAllowingDirs(*cabundles, true)
 	if err != nil {
 		return err
 	}
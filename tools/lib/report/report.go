@@ -117,8 +117,11 @@ func asBin(report *spb.Attestation) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	certs := abi.CertsFromProto(report.CertificateChain).Marshal()
-	return append(r, certs...), nil
+	certs, err := abi.CertTableFromProto(report.CertificateChain)
+	if err != nil {
+		return nil, err
+	}
+	return append(r, certs.Marshal()...), nil
 }
 
 func tcbBreakdown(tcb uint64) string {
@@ -17,7 +17,6 @@ package validate
 
 import (
 	"bytes"
-	"crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/hex"
@@ -266,33 +265,12 @@ func compareByteVersions(major0, minor0, major1, minor1 uint8) int64 {
 	return int64(version0) - int64(version1)
 }
 
-func comparePolicyVersions(p0 abi.SnpPolicy, p1 abi.SnpPolicy) int64 {
-	return compareByteVersions(p0.ABIMajor, p0.ABIMinor, p1.ABIMajor, p1.ABIMinor)
-}
-
 func validatePolicy(reportPolicy uint64, required abi.SnpPolicy) error {
 	policy, err := abi.ParseSnpPolicy(reportPolicy)
 	if err != nil {
 		return fmt.Errorf("could not parse SNP policy: %v", err)
 	}
-	if comparePolicyVersions(required, policy) > 0 {
-		return fmt.Errorf(
-			"required policy ABI version (%d.%d) is greater than the report's ABI version (%d.%d)",
-			required.ABIMajor, required.ABIMinor, policy.ABIMajor, policy.ABIMinor)
-	}
-	if !required.MigrateMA && policy.MigrateMA {
-		return errors.New("found unauthorized migration agent capability")
-	}
-	if !required.Debug && policy.Debug {
-		return errors.New("found unauthorized debug capability")
-	}
-	if !required.SMT && policy.SMT {
-		return errors.New("found unauthorized symmetric multithreading (SMT) capability")
-	}
-	if required.SingleSocket && !policy.SingleSocket {
-		return errors.New("required single socket restriction not present")
-	}
-	return nil
+	return required.SatisfiedBy(policy)
 }
 
 func validateByteField(option, field string, size int, given, required []byte) error {
@@ -395,22 +373,27 @@ func getPolicyTcbs(options *Options) *policyTcbDescriptions {
 
 // tcbNeError return an error if the two TCBs are not equal
 func tcbNeError(left, right partDescription) error {
-	ltcb, _ := kds.ComposeTCBParts(left.parts)
-	rtcb, _ := kds.ComposeTCBParts(right.parts)
-	if ltcb == rtcb {
+	if left.parts == right.parts {
 		return nil
 	}
-	return fmt.Errorf("the %s 0x%x does not match the %s 0x%x", left.desc, ltcb, right.desc, rtcb)
+	ltcb, lerr := kds.ComposeTCBParts(left.parts)
+	rtcb, rerr := kds.ComposeTCBParts(right.parts)
+	if lerr != nil || rerr != nil {
+		return fmt.Errorf("the %s %+v does not match the %s %+v", left.desc, left.parts, right.desc, right.parts)
+	}
+	return fmt.Errorf("the %s 0x%x does not match the %s 0x%x", left.desc, uint64(ltcb), right.desc, uint64(rtcb))
 }
 
 // tcbGtError returns an error if wantLower is greater than (in part) wantHigher. It enforces
-// the property wantLower <= wantHigher.
+// the property wantLower <= wantHigher, so incomparable TCBs fail closed.
 func tcbGtError(wantLower, wantHigher partDescription) error {
-	if kds.TCBPartsLE(wantLower.parts, wantHigher.parts) {
+	order := kds.CompareTCBParts(wantLower.parts, wantHigher.parts)
+	if order == kds.TCBLessOrEqual {
 		return nil
 	}
-	return fmt.Errorf("the %s %+v is lower than the %s %+v in at least one component",
-		wantHigher.desc, wantHigher.parts, wantLower.desc, wantLower.parts)
+	return fmt.Errorf("the %s %+v is lower than the %s %+v in at least one component (%s): %s",
+		wantHigher.desc, wantHigher.parts, wantLower.desc, wantLower.parts, order,
+		strings.Join(kds.TCBPartsGreaterComponents(wantLower.parts, wantHigher.parts), ", "))
 }
 
 // validateTcb returns an error if the TCB values present in the report and V[CL]EK certificate do not
@@ -496,21 +479,12 @@ func validateVersion(report *spb.Report, options *Options) error {
 		if versionCmp > 0 {
 			return fmt.Errorf("committed API version (%d.%d) is greater than the current API version (%d.%d)",
 				report.GetCommittedMajor(), report.GetCommittedMinor(),
-				report.GetCurrentMinor(), report.GetCurrentMinor())
+				report.GetCurrentMajor(), report.GetCurrentMinor())
 		}
 	}
 	return nil
 }
 
-func allZero(buf []byte) bool {
-	for _, b := range buf {
-		if b != 0 {
-			return false
-		}
-	}
-	return true
-}
-
 func validatePlatformInfo(platformInfo uint64, required *abi.SnpPlatformInfo) error {
 	if required == nil {
 		return nil
@@ -533,14 +507,12 @@ func addKeyHashesFromCerts(hashes [][]byte, certs []*x509.Certificate) [][]byte
 		// Only add ECDSA P-384 keys
 		switch key := c.PublicKey.(type) {
 		case *ecdsa.PublicKey:
-			pubkey, err := abi.EcdsaPublicKeyToBytes(key)
+			digest, err := abi.EcdsaPublicKeyDigest(key)
 			if err != nil {
 				// Wrong key type.
 				continue
 			}
-			h := crypto.SHA384.New()
-			h.Write(pubkey)
-			hashes = append(hashes, h.Sum(nil))
+			hashes = append(hashes, digest[:])
 		}
 	}
 	return hashes
@@ -699,9 +671,14 @@ func SnpAttestation(attestation *spb.Attestation, options *Options) error {
 	}
 
 	// MaskChipId might be 1 for the host, so only check if the the CHIP_ID is not all zeros.
-	if info.SigningKey == abi.VcekReportSigner && !allZero(report.GetChipId()) && !bytes.Equal(report.GetChipId(), exts.HWID[:]) {
-		return fmt.Errorf("report field CHIP_ID %s is not the same as the VCEK certificate's HWID %s",
-			hex.EncodeToString(report.GetChipId()), hex.EncodeToString(exts.HWID[:]))
+	if info.SigningKey == abi.VcekReportSigner {
+		if abi.IsChipIDMasked(report.GetChipId()) {
+			logger.Warningf("Report CHIP_ID is masked. Skipping comparison with the VCEK certificate's HWID %s",
+				abi.ChipIDString(exts.HWID))
+		} else if !bytes.Equal(report.GetChipId(), exts.HWID[:]) {
+			return fmt.Errorf("report field CHIP_ID %s is not the same as the VCEK certificate's HWID %s",
+				abi.ChipIDString(report.GetChipId()), abi.ChipIDString(exts.HWID))
+		}
 	}
 
 	return certTableOptions(attestation, options.CertTableOptions)
@@ -332,7 +332,18 @@ func TestValidateSnpAttestation(t *testing.T) {
 				PlatformInfo: &abi.SnpPlatformInfo{SMTEnabled: true},
 				MinimumTCB:   kds.TCBParts{UcodeSpl: 0xff, SnpSpl: 0x05, BlSpl: 0x02},
 			},
-			wantErr: "the report's REPORTED_TCB {BlSpl:31 TeeSpl:127 Spl4:0 Spl5:0 Spl6:0 Spl7:0 SnpSpl:112 UcodeSpl:146} is lower than the policy minimum TCB {BlSpl:2 TeeSpl:0 Spl4:0 Spl5:0 Spl6:0 Spl7:0 SnpSpl:5 UcodeSpl:255} in at least one component",
+			wantErr: "the report's REPORTED_TCB blSpl=31 teeSpl=127 snpSpl=112 ucodeSpl=146 is lower than the policy minimum TCB blSpl=2 teeSpl=0 snpSpl=5 ucodeSpl=255 in at least one component (incomparable): UcodeSpl",
+		},
+		{
+			name:        "Minimum TCB greater in every component",
+			attestation: attestation12345,
+			opts: &Options{
+				ReportData:   nonce12345[:],
+				GuestPolicy:  abi.SnpPolicy{Debug: true, SMT: true},
+				PlatformInfo: &abi.SnpPlatformInfo{SMTEnabled: true},
+				MinimumTCB:   kds.TCBParts{UcodeSpl: 0xff, SnpSpl: 0x7f, TeeSpl: 0x7f, BlSpl: 0x7f},
+			},
+			wantErr: "in at least one component (greater): BlSpl, SnpSpl, UcodeSpl",
 		},
 		{
 			name:        "Minimum build checked",
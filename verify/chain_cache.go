@@ -0,0 +1,164 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+// DefaultChainCacheTTL is how long a ChainCache remembers a verified chain if its TTL is zero.
+const DefaultChainCacheTTL = time.Hour
+
+// ChainCacheStats counts the lookups of a ChainCache.
+type ChainCacheStats struct {
+	// Hits is the number of verifications whose chain of trust was already verified.
+	Hits uint64
+	// Misses is the number of verifications that verified the chain of trust.
+	Misses uint64
+}
+
+// chainKey identifies an endorsement key certificate verified for the reports of one chip at one
+// TCB, against one expected product.
+type chainKey struct {
+	productLine string
+	product     string
+	chipID      string
+	reportedTCB uint64
+	fingerprint [sha256.Size]byte
+}
+
+type chainEntry struct {
+	cert *x509.Certificate
+	root *trust.AMDRootCerts
+	// notBefore and expires bound the times at which the entry answers lookups: the chain must
+	// have been valid, and the entry not be older than the TTL.
+	notBefore time.Time
+	expires   time.Time
+}
+
+// ChainCache remembers the endorsement key certificates whose chains of trust from the ARK have
+// verified, so that verifying another report of the same chip at the same TCB only checks the
+// report's signature, e.g., for a verifier that sees many reports from few hosts. A chain is
+// remembered for TTL, but never past the expiry of one of its certificates, and a cached chain
+// still has its revocations checked if CheckRevocations is set. When the CRL of a product line
+// revokes a certificate, the product line's cached chains are dropped.
+//
+// A ChainCache may only be shared by verifications with the same TrustedRoots and RootPins. It is
+// safe for concurrent use.
+type ChainCache struct {
+	// TTL is how long a verified chain is remembered. If zero, uses DefaultChainCacheTTL.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[chainKey]*chainEntry
+	// swept is the number of entries after the last sweep of expired entries.
+	swept int
+	stats ChainCacheStats
+}
+
+func (c *ChainCache) ttl() time.Duration {
+	if c.TTL == 0 {
+		return DefaultChainCacheTTL
+	}
+	return c.TTL
+}
+
+// lookup returns the verified chain of key at now, and counts the hit or miss.
+func (c *ChainCache) lookup(key chainKey, now time.Time) (*chainEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if ok && !now.Before(entry.notBefore) && now.Before(entry.expires) {
+		c.stats.Hits++
+		return entry, true
+	}
+	if ok && !now.Before(entry.expires) {
+		delete(c.entries, key)
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+// store remembers that cert verified at now through the intermediate ica and root.
+func (c *ChainCache) store(key chainKey, cert, ica *x509.Certificate, root *trust.AMDRootCerts, now time.Time) {
+	entry := &chainEntry{cert: cert, root: root, expires: now.Add(c.ttl())}
+	for _, link := range []*x509.Certificate{cert, ica, root.ProductCerts.Ark} {
+		if entry.notBefore.Before(link.NotBefore) {
+			entry.notBefore = link.NotBefore
+		}
+		if link.NotAfter.Before(entry.expires) {
+			entry.expires = link.NotAfter
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[chainKey]*chainEntry)
+	}
+	c.entries[key] = entry
+	// Drop the entries of chips that are gone once the cache has doubled since the last sweep.
+	if len(c.entries) > 2*c.swept {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.swept = len(c.entries)
+	}
+}
+
+// Invalidate drops the cached chains of productLine, e.g., "Milan", so that they are verified
+// again.
+func (c *ChainCache) Invalidate(productLine string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if k.productLine == productLine {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Len returns the number of cached chains.
+func (c *ChainCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Stats returns the cache's hit and miss counts so far.
+func (c *ChainCache) Stats() ChainCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// newChainKey returns the key of cert's chain for reports of chipID at reportedTCB, verified
+// against the expected product.
+func newChainKey(productLine string, product string, chipID []byte, reportedTCB kds.TCBVersion, cert *x509.Certificate) chainKey {
+	return chainKey{
+		productLine: productLine,
+		product:     product,
+		chipID:      string(chipID),
+		reportedTCB: uint64(reportedTCB),
+		fingerprint: sha256.Sum256(cert.Raw),
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"github.com/google/go-sev-guest/kds"
+	"github.com/pkg/errors"
+)
+
+// A failed verification returns an error that is one of the following kinds, which callers can
+// distinguish with errors.Is, e.g., to retry an ErrCertFetch but not an ErrReportSignature. The
+// kind does not change the error's message, and errors.Is and errors.As still find the error's
+// causes, such as a *ChainLinkErr or ErrMissingVlek. Only errors in the use of the package, e.g., nil
+// options, are of no kind.
+var (
+	// ErrUnknownProduct is the kind of error for a product, product line, or productName extension
+	// that does not identify an AMD SEV product. It is kds.ErrUnknownProduct.
+	ErrUnknownProduct = kds.ErrUnknownProduct
+	// ErrCertFetch is the kind of error for a certificate or CRL that is missing and could not be
+	// fetched, e.g., because of a network failure, a masked CHIP_ID, or a CRL that is unavailable or
+	// past its NextUpdate. Such a verification may succeed if retried, unless the error is ErrNoCRL:
+	// options that forbid getting any CRL.
+	ErrCertFetch = errors.New("could not get the certificates to verify the report")
+	// ErrChainVerification is the kind of error for a certificate chain that does not verify from a
+	// trusted root: a malformed or expired certificate, whether it came with the attestation or was
+	// downloaded, a signature from the ARK down to the VCEK or VLEK that does not verify, a chain
+	// that deviates from the ChainPolicy or RootPins, RootPins that do not parse, or a CRL that AMD
+	// did not issue for the product line.
+	ErrChainVerification = errors.New("certificate chain does not verify")
+	// ErrReportSignature is the kind of error for a report that is malformed, or whose signature
+	// does not verify under the VCEK or VLEK that its SIGNER_INFO names.
+	ErrReportSignature = errors.New("report signature does not verify")
+	// ErrCertReportMismatch is the kind of error for a verified certificate that was not issued for
+	// the report, or for a product other than the expected one, e.g., a *ProductMismatchErr,
+	// *HWIDMismatchErr, or *TCBMismatchErr.
+	ErrCertReportMismatch = errors.New("certificate does not match the report")
+)
+
+// failureKinds are the kinds of verification errors. ErrRevoked is the kind of error for a
+// certificate that the product line's CRL revokes.
+var failureKinds = []error{
+	ErrUnknownProduct,
+	ErrCertFetch,
+	ErrChainVerification,
+	ErrReportSignature,
+	ErrRevoked,
+	ErrCertReportMismatch,
+}
+
+// kindErr is err as an error of one of the failureKinds.
+type kindErr struct {
+	kind error
+	err  error
+}
+
+func (e *kindErr) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindErr) Unwrap() error {
+	return e.err
+}
+
+func (e *kindErr) Is(target error) bool {
+	return target == e.kind
+}
+
+// withKind returns err as an error of kind, unless err is nil or already of one of the failureKinds.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, k := range failureKinds {
+		if errors.Is(err, k) {
+			return err
+		}
+	}
+	return &kindErr{kind: kind, err: err}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/verify/trust"
+	"github.com/pkg/errors"
+)
+
+// ErrRootPinMismatch is returned when a certificate at the top of the chain of trust does not have
+// one of the SHA-256 fingerprints that the options' RootPins allow for its position.
+var ErrRootPinMismatch = errors.New("certificate does not match the pinned fingerprints")
+
+// RootPins are the SHA-256 fingerprints of the DER certificates that a product line's chain of
+// trust must use, as hex strings that may separate bytes with colons, e.g., as
+// `openssl x509 -fingerprint -sha256` prints them. Each position allows any of its fingerprints,
+// e.g., during a rotation, and a position without fingerprints is not pinned.
+type RootPins struct {
+	// ARK are the allowed fingerprints of the ARK.
+	ARK []string
+	// ASK are the allowed fingerprints of the ASK, which certifies VCEKs.
+	ASK []string
+	// ASVK are the allowed fingerprints of the ASVK, which certifies VLEKs.
+	ASVK []string
+}
+
+// parseFingerprint returns the SHA-256 digest that fingerprint is the hex encoding of.
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	digest, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint %q is not hex: %v", fingerprint, err)
+	}
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("fingerprint %q is %d bytes. Expected %d", fingerprint, len(digest), sha256.Size)
+	}
+	return digest, nil
+}
+
+// check returns an error if a fingerprint of the pins cannot be parsed.
+func (p *RootPins) check() error {
+	for _, fingerprints := range [][]string{p.ARK, p.ASK, p.ASVK} {
+		for _, fingerprint := range fingerprints {
+			if _, err := parseFingerprint(fingerprint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPin returns an error wrapping ErrRootPinMismatch if cert, the certificate of role, does not
+// have one of fingerprints.
+func checkPin(cert *x509.Certificate, role string, fingerprints []string) error {
+	if len(fingerprints) == 0 {
+		return nil
+	}
+	if cert == nil {
+		return fmt.Errorf("%w: no %s certificate", ErrRootPinMismatch, role)
+	}
+	sum := sha256.Sum256(cert.Raw)
+	for _, fingerprint := range fingerprints {
+		// The fingerprints were parsed when the options were checked.
+		if digest, _ := parseFingerprint(fingerprint); string(digest) == string(sum[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s SHA-256 fingerprint is %s", ErrRootPinMismatch, role, hex.EncodeToString(sum[:]))
+}
+
+// checkRootPins checks the ARK of root and the intermediate that certifies key's certificates
+// against the pins of productLine, if any.
+func (o *Options) checkRootPins(productLine string, root *trust.AMDRootCerts, key abi.ReportSigner) error {
+	pins := o.RootPins[productLine]
+	if pins == nil {
+		return nil
+	}
+	certs := root.ProductCerts
+	if certs == nil {
+		certs = &trust.ProductCerts{}
+	}
+	if err := checkPin(certs.Ark, "ARK", pins.ARK); err != nil {
+		return err
+	}
+	if key == abi.VlekReportSigner {
+		return checkPin(certs.Asvk, "ASVK", pins.ASVK)
+	}
+	return checkPin(certs.Ask, "ASK", pins.ASK)
+}
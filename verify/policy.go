@@ -0,0 +1,210 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/logger"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrPolicySignatureAlgorithm is wrapped by a ChainPolicyErr for a certificate that is signed
+	// with an algorithm that the ChainPolicy does not allow.
+	ErrPolicySignatureAlgorithm = errors.New("signature algorithm is not allowed")
+	// ErrPolicyPublicKey is wrapped by a ChainPolicyErr for a certificate whose public key is not of
+	// the type or size that the ChainPolicy requires.
+	ErrPolicyPublicKey = errors.New("public key is not allowed")
+	// ErrPolicyNotSelfSigned is wrapped by a ChainPolicyErr for an ARK that is not self-issued.
+	ErrPolicyNotSelfSigned = errors.New("root is not self-signed")
+	// ErrPolicyCommonName is wrapped by a ChainPolicyErr for a certificate whose subject or issuer
+	// common name is not the one AMD gives it for the product line.
+	ErrPolicyCommonName = errors.New("common name is not AMD's")
+	// ErrPolicyKeyUsage is wrapped by a ChainPolicyErr for an ARK, ASK, or ASVK that may not sign
+	// certificates.
+	ErrPolicyKeyUsage = errors.New("key usage does not allow certificate signing")
+)
+
+// ChainPolicyErr is returned when a certificate of the chain of trust does not have the structure
+// of AMD's chain, even if its signature verifies. A chain that verifies but deviates from AMD's is
+// what a downgrade to weaker keys, or a confusion of one product line's certificates with
+// another's, looks like.
+type ChainPolicyErr struct {
+	// Role is the certificate that deviates, e.g., "ARK" or "VCEK".
+	Role string
+	// Err wraps one of the ErrPolicy errors.
+	Err error
+}
+
+func (e *ChainPolicyErr) Error() string {
+	return fmt.Sprintf("%s certificate deviates from the AMD chain of trust: %v", e.Role, e.Err)
+}
+
+func (e *ChainPolicyErr) Unwrap() error {
+	return e.Err
+}
+
+// Is reports the error as an ErrChainVerification.
+func (e *ChainPolicyErr) Is(target error) bool {
+	return target == ErrChainVerification
+}
+
+// ChainPolicy is the structure that the certificates of the chain of trust must have besides
+// verifying signatures. The zero value requires AMD's current chain: an RSA ARK, ASK, and ASVK of
+// at least 4096 bits that sign with SHA-384 and RSASSA-PSS, a self-signed ARK, certificate signing
+// key usages for the ARK, ASK, and ASVK, AMD's common names for the product line, and a VCEK or
+// VLEK on ECDSA P-384. The fields allow what a future AMD chain may change.
+type ChainPolicy struct {
+	// SignatureAlgorithms are the algorithms that the ARK, ASK, and ASVK may sign certificates
+	// with. If empty, only x509.SHA384WithRSAPSS.
+	SignatureAlgorithms []x509.SignatureAlgorithm
+	// MinRSABits is the smallest RSA modulus of the ARK, ASK, and ASVK keys. If zero, 4096.
+	MinRSABits int
+	// EndorsementKeyCurves are the names of the elliptic curves that the VCEK and VLEK keys may be
+	// on. If empty, only "P-384".
+	EndorsementKeyCurves []string
+	// DisableCommonNameCheck skips the check of the ARK's, ASK's, and ASVK's common names.
+	DisableCommonNameCheck bool
+	// DisableKeyUsageCheck skips the check of the ARK's, ASK's, and ASVK's key usages.
+	DisableKeyUsageCheck bool
+	// Permissive logs the chain's deviations from the policy instead of failing verification.
+	Permissive bool
+}
+
+func (p *ChainPolicy) signatureAlgorithms() []x509.SignatureAlgorithm {
+	if len(p.SignatureAlgorithms) == 0 {
+		return []x509.SignatureAlgorithm{x509.SHA384WithRSAPSS}
+	}
+	return p.SignatureAlgorithms
+}
+
+func (p *ChainPolicy) minRSABits() int {
+	if p.MinRSABits == 0 {
+		return 4096
+	}
+	return p.MinRSABits
+}
+
+func (p *ChainPolicy) endorsementKeyCurves() []string {
+	if len(p.EndorsementKeyCurves) == 0 {
+		return []string{"P-384"}
+	}
+	return p.EndorsementKeyCurves
+}
+
+func (p *ChainPolicy) checkSignatureAlgorithm(x *x509.Certificate) error {
+	for _, algo := range p.signatureAlgorithms() {
+		if x.SignatureAlgorithm == algo {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v. Expected one of %v", ErrPolicySignatureAlgorithm, x.SignatureAlgorithm, p.signatureAlgorithms())
+}
+
+// checkAuthority checks the certificate of the ARK, ASK, or ASVK, named role, whose issuer is the
+// ARK named arkCN, and whose common name must be cn unless cn is empty.
+func (p *ChainPolicy) checkAuthority(x *x509.Certificate, role, cn, arkCN string) error {
+	if err := p.checkSignatureAlgorithm(x); err != nil {
+		return err
+	}
+	pub, ok := x.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: public key type is %v. Expected RSA", ErrPolicyPublicKey, x.PublicKeyAlgorithm)
+	}
+	if bits := pub.N.BitLen(); bits < p.minRSABits() {
+		return fmt.Errorf("%w: RSA key is %d bits. Expected at least %d", ErrPolicyPublicKey, bits, p.minRSABits())
+	}
+	if !p.DisableKeyUsageCheck {
+		if !x.BasicConstraintsValid || !x.IsCA {
+			return fmt.Errorf("%w: %s is not a certificate authority", ErrPolicyKeyUsage, role)
+		}
+		if x.KeyUsage&x509.KeyUsageCertSign == 0 {
+			return fmt.Errorf("%w: key usage is 0x%x. Expected certSign (0x%x)", ErrPolicyKeyUsage, x.KeyUsage, x509.KeyUsageCertSign)
+		}
+	}
+	if !p.DisableCommonNameCheck && cn != "" {
+		if x.Subject.CommonName != cn {
+			return fmt.Errorf("%w: subject common name is %s. Expected %s", ErrPolicyCommonName, x.Subject.CommonName, cn)
+		}
+		if x.Issuer.CommonName != arkCN {
+			return fmt.Errorf("%w: issuer common name is %s. Expected %s", ErrPolicyCommonName, x.Issuer.CommonName, arkCN)
+		}
+	}
+	return nil
+}
+
+func (p *ChainPolicy) checkEndorsementKey(x *x509.Certificate) error {
+	if err := p.checkSignatureAlgorithm(x); err != nil {
+		return err
+	}
+	pub, ok := x.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: public key type is %v. Expected ECDSA", ErrPolicyPublicKey, x.PublicKeyAlgorithm)
+	}
+	curve := pub.Curve.Params().Name
+	for _, allowed := range p.endorsementKeyCurves() {
+		if curve == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: public key curve is %s. Expected one of %v", ErrPolicyPublicKey, curve, p.endorsementKeyCurves())
+}
+
+// deviations returns the ways that the chain from ark through ica to key's certificate cert
+// deviates from the policy for productLine.
+func (p *ChainPolicy) deviations(productLine string, ark, ica, cert *x509.Certificate, key abi.ReportSigner) []error {
+	var arkCN string
+	if productLine != "" {
+		arkCN = fmt.Sprintf("ARK-%s", productLine)
+	}
+	var errs []error
+	if err := p.checkAuthority(ark, "ARK", arkCN, arkCN); err != nil {
+		errs = append(errs, &ChainPolicyErr{Role: "ARK", Err: err})
+	}
+	if !bytes.Equal(ark.RawIssuer, ark.RawSubject) ||
+		(len(ark.AuthorityKeyId) != 0 && !bytes.Equal(ark.AuthorityKeyId, ark.SubjectKeyId)) {
+		errs = append(errs, &ChainPolicyErr{Role: "ARK", Err: ErrPolicyNotSelfSigned})
+	}
+	role := intermediateRole(key)
+	if err := p.checkAuthority(ica, role, intermediateKeyCommonName(productLine, key), arkCN); err != nil {
+		errs = append(errs, &ChainPolicyErr{Role: role, Err: err})
+	}
+	if err := p.checkEndorsementKey(cert); err != nil {
+		errs = append(errs, &ChainPolicyErr{Role: key.String(), Err: err})
+	}
+	return errs
+}
+
+// check returns the first deviation of the chain from the policy, or logs all of them and returns
+// nil if the policy is permissive.
+func (p *ChainPolicy) check(productLine string, ark, ica, cert *x509.Certificate, key abi.ReportSigner) error {
+	errs := p.deviations(productLine, ark, ica, cert, key)
+	if len(errs) == 0 {
+		return nil
+	}
+	if !p.Permissive {
+		return errs[0]
+	}
+	for _, err := range errs {
+		logger.Warningf("Permitting a chain of trust that deviates from AMD's: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+// CertProvider provides the certificates that an attestation lacks to verify its report, e.g.,
+// from AMD's Key Distribution Service (KDS), or from an endorsement database keyed by CHIP_ID.
+// Certificates are always verified after they are provided, so a CertProvider need not be trusted.
+type CertProvider interface {
+	// VCEK returns the VCEK certificate of the chip of productLine, e.g., "Milan", with the given
+	// hwID at the given TCB version.
+	VCEK(ctx context.Context, productLine string, hwid []byte, tcb kds.TCBVersion) (*x509.Certificate, error)
+	// ProductChain returns the ARK of productLine, and the intermediate that certifies key's
+	// certificates: the ASK for the VCEK, and the ASVK for the VLEK.
+	ProductChain(ctx context.Context, productLine string, key abi.ReportSigner) (*trust.ProductCerts, error)
+}
+
+// VLEKCertProvider is a CertProvider that also provides VLEK certificates, which only the cloud
+// service provider that a VLEK is provisioned for can get from the KDS.
+type VLEKCertProvider interface {
+	CertProvider
+	// VLEK returns the VLEK certificate of productLine at the given TCB version.
+	VLEK(ctx context.Context, productLine string, tcb kds.TCBVersion) (*x509.Certificate, error)
+}
+
+// KDSCertProvider is the default CertProvider, which downloads certificates from the KDS.
+type KDSCertProvider struct {
+	// Getter fetches the KDS URLs. If nil, uses trust.DefaultHTTPSGetter(). VLEK certificates are
+	// only served to a Getter that has the cloud service provider's KDS credentials.
+	Getter trust.HTTPSGetter
+}
+
+func (p *KDSCertProvider) getter() trust.HTTPSGetter {
+	if p.Getter == nil {
+		return trust.DefaultHTTPSGetter()
+	}
+	return p.Getter
+}
+
+// VCEK downloads the VCEK certificate of the chip with hwid at tcb.
+func (p *KDSCertProvider) VCEK(ctx context.Context, productLine string, hwid []byte, tcb kds.TCBVersion) (*x509.Certificate, error) {
+	vcekURL, err := kds.VCEKCertURLForChipID(kds.Product(productLine), hwid, tcb)
+	if err != nil {
+		return nil, fmt.Errorf("could not determine VCEK certificate URL: %w", err)
+	}
+	return kds.GetCertContext(ctx, p.getter(), vcekURL)
+}
+
+// VLEK downloads the VLEK certificate of productLine at tcb.
+func (p *KDSCertProvider) VLEK(ctx context.Context, productLine string, tcb kds.TCBVersion) (*x509.Certificate, error) {
+	return kds.GetCertContext(ctx, p.getter(), kds.VLEKCertURL(kds.Product(productLine), tcb))
+}
+
+// ProductChain downloads the ARK and key's intermediate of productLine, or returns them from the
+// trust package's cache.
+func (p *KDSCertProvider) ProductChain(ctx context.Context, productLine string, key abi.ReportSigner) (*trust.ProductCerts, error) {
+	return trust.GetProductChainContext(ctx, productLine, key, p.getter())
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+)
+
+// ErrNotInBundle is returned by a BundleHTTPSGetter for a URL whose response the bundle lacks.
+var ErrNotInBundle = errors.New("not in bundle")
+
+// A certificate bundle holds KDS responses for verifiers that cannot reach the KDS, in files at
+// these slash-separated paths relative to the bundle's root:
+//
+//	<product line>/cert_chain              the ASK and ARK, as served by the KDS
+//	<product line>/crl                     the CRL of the ARK
+//	<product line>/<hwid>/<tcb>.der        a VCEK certificate
+//	<product line>/vlek/cert_chain         the ASVK and ARK
+//	<product line>/vlek/crl                the CRL of the ARK for the ASVK
+//	<product line>/vlek/<tcb>.der          a VLEK certificate
+//
+// where <hwid> is the chip's lowercase hex CHIP_ID and <tcb> is the 16-digit lowercase hex TCB
+// version, e.g., Milan/cert_chain or Genoa/4a3b...c0/0b000000000018db.der. VCEK and VLEK
+// certificates may also be PEM-encoded in files ending in .pem.
+
+// tcbFileName returns the bundle's file name for a VCEK or VLEK certificate at the given TCB.
+func tcbFileName(tcb kds.TCBVersion) string {
+	return fmt.Sprintf("%016x.der", uint64(tcb))
+}
+
+// crlURLProduct returns the product line and signer of a KDS CRL URL.
+func crlURLProduct(kdsurl string) (string, abi.ReportSigner, bool) {
+	productLine, key, err := kds.ParseCRLURL(kdsurl)
+	if err != nil {
+		return "", 0, false
+	}
+	return productLine, key, true
+}
+
+// bundlePath returns the path in a bundle of the KDS response at kdsurl, and a description of the
+// response for operators.
+func bundlePath(kdsurl string) (string, string, error) {
+	if productLine, function, err := kds.ParseProductCertChainURL(kdsurl); err == nil {
+		if function == kds.VlekCertFunction {
+			return path.Join(productLine, "vlek", "cert_chain"), fmt.Sprintf("the %s ASVK and ARK certificates", productLine), nil
+		}
+		return path.Join(productLine, "cert_chain"), fmt.Sprintf("the %s ASK and ARK certificates", productLine), nil
+	}
+	if vcek, err := kds.ParseVCEKCertURL(kdsurl); err == nil {
+		tcb := kds.TCBVersion(vcek.TCB)
+		return path.Join(vcek.ProductLine, hex.EncodeToString(vcek.HWID), tcbFileName(tcb)),
+			fmt.Sprintf("the %s VCEK certificate of hwid %s at TCB %v", vcek.ProductLine, hex.EncodeToString(vcek.HWID), tcb), nil
+	}
+	if vlek, err := kds.ParseVLEKCertURL(kdsurl); err == nil {
+		tcb := kds.TCBVersion(vlek.TCB)
+		return path.Join(vlek.ProductLine, "vlek", tcbFileName(tcb)),
+			fmt.Sprintf("the %s VLEK certificate at TCB %v", vlek.ProductLine, tcb), nil
+	}
+	if productLine, key, ok := crlURLProduct(kdsurl); ok {
+		if key == abi.VlekReportSigner {
+			return path.Join(productLine, "vlek", "crl"), fmt.Sprintf("the %s VLEK CRL", productLine), nil
+		}
+		return path.Join(productLine, "crl"), fmt.Sprintf("the %s CRL", productLine), nil
+	}
+	return "", "", fmt.Errorf("%s is not a KDS certificate or CRL URL", kdsurl)
+}
+
+// BundleHTTPSGetter implements the HTTPSGetter interface with the KDS responses of a certificate
+// bundle, for verifiers that cannot reach the KDS. It answers the URLs that the library would
+// request from the KDS, and never uses the network.
+type BundleHTTPSGetter struct {
+	entries map[string][]byte
+}
+
+// LoadBundle reads the certificate bundle at path, which is either a directory or a tarball,
+// optionally gzip-compressed, of the bundle's files. It fails on any file that is not a
+// certificate, a KDS certificate chain, or a CRL. Files whose names start with "." are skipped.
+func LoadBundle(path string) (*BundleHTTPSGetter, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &BundleHTTPSGetter{entries: make(map[string][]byte)}
+	if info.IsDir() {
+		err = b.loadDir(path)
+	} else {
+		err = b.loadTarball(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load certificate bundle %s: %v", path, err)
+	}
+	return b, nil
+}
+
+func (b *BundleHTTPSGetter) loadDir(dir string) error {
+	return filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		body, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return b.add(filepath.ToSlash(rel), body)
+	})
+}
+
+func (b *BundleHTTPSGetter) loadTarball(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buffered := bufio.NewReader(f)
+	var r io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if err := b.add(path.Clean(strings.TrimPrefix(hdr.Name, "./")), body); err != nil {
+			return err
+		}
+	}
+}
+
+// add indexes the file at the given bundle path, converting a PEM-encoded VCEK or VLEK
+// certificate to DER as the KDS serves it.
+func (b *BundleHTTPSGetter) add(name string, body []byte) error {
+	if strings.HasPrefix(path.Base(name), ".") {
+		return nil
+	}
+	if !cacheable(body) {
+		return fmt.Errorf("%s is not a certificate, KDS certificate chain, or CRL", name)
+	}
+	if strings.HasSuffix(name, ".pem") {
+		cert, err := ParseCert(body)
+		if err != nil {
+			return fmt.Errorf("%s is not a single certificate: %v", name, err)
+		}
+		name = strings.TrimSuffix(name, ".pem") + ".der"
+		body = cert.Raw
+	}
+	b.entries[name] = body
+	return nil
+}
+
+// Get returns the bundle's response for the KDS URL, or an error wrapping ErrNotInBundle that
+// names the missing response and where in the bundle it belongs.
+func (b *BundleHTTPSGetter) Get(kdsurl string) ([]byte, error) {
+	name, description, err := bundlePath(kdsurl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotInBundle, err)
+	}
+	body, ok := b.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s, expected at %s", ErrNotInBundle, description, name)
+	}
+	return body, nil
+}
+
+// BundleWriter is a meta-HTTPS getter that populates a certificate bundle directory with the KDS
+// responses that it fetches, so that a machine that can reach the KDS can prepare a bundle for
+// one that cannot, e.g., by verifying attestations with it as the Getter.
+type BundleWriter struct {
+	// Dir is the root of the bundle. It is created when the first response is written.
+	Dir string
+	// Getter fetches the KDS responses.
+	Getter HTTPSGetter
+}
+
+// Get fetches the URL with Getter, and writes the response to the bundle if it is a certificate,
+// a KDS certificate chain, or a CRL. Responses for URLs that a bundle does not hold are returned
+// without being written.
+func (w *BundleWriter) Get(kdsurl string) ([]byte, error) {
+	return w.GetContext(context.Background(), kdsurl)
+}
+
+// GetContext is like Get, but passes ctx to Getter.
+func (w *BundleWriter) GetContext(ctx context.Context, kdsurl string) ([]byte, error) {
+	body, err := AsContextHTTPSGetter(w.Getter).GetContext(ctx, kdsurl)
+	if err != nil {
+		return nil, err
+	}
+	name, _, err := bundlePath(kdsurl)
+	if err != nil || !cacheable(body) {
+		return body, nil
+	}
+	if err := writeFileAtomic(filepath.Join(w.Dir, filepath.FromSlash(name)), body); err != nil {
+		return nil, fmt.Errorf("could not write %s to the certificate bundle: %v", kdsurl, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+func testCRL(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ARK-Milan"},
+		NotBefore:             now,
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+	}, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+// kdsResponses returns the KDS responses that a Milan verifier of the test signer's VCEK needs.
+func kdsResponses(t *testing.T) map[string][]byte {
+	t.Helper()
+	signer, vcekURL := testSigner(t)
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Ask.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Ark.Raw})...)
+	return map[string][]byte{
+		vcekURL: signer.Vcek.Raw,
+		kds.ProductCertChainURL(abi.VcekReportSigner, "Milan"): chain,
+		kds.CrlURL("Milan"): testCRL(t),
+	}
+}
+
+func checkBundle(t *testing.T, b *trust.BundleHTTPSGetter, responses map[string][]byte) {
+	t.Helper()
+	for url, want := range responses {
+		got, err := b.Get(url)
+		if err != nil {
+			t.Errorf("Get(%q) = _, %v. Want nil", url, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Get(%q) = %x. Want %x", url, got, want)
+		}
+	}
+}
+
+func TestBundle(t *testing.T) {
+	responses := kdsResponses(t)
+	dir := t.TempDir()
+	w := &trust.BundleWriter{Dir: dir, Getter: &countingGetter{bodies: responses}}
+	for url := range responses {
+		if _, err := w.Get(url); err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Milan", "cert_chain")); err != nil {
+		t.Errorf("bundle has no Milan/cert_chain: %v", err)
+	}
+	b, err := trust.LoadBundle(dir)
+	if err != nil {
+		t.Fatalf("LoadBundle(%q) = _, %v. Want nil", dir, err)
+	}
+	checkBundle(t, b, responses)
+
+	// The same bundle as a gzipped tarball.
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, name)
+		body, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: "./" + filepath.ToSlash(rel), Mode: 0644, Size: int64(len(body))}); err != nil {
+			return err
+		}
+		_, err = tw.Write(body)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gz.Close()
+	tarball := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := os.WriteFile(tarball, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err = trust.LoadBundle(tarball)
+	if err != nil {
+		t.Fatalf("LoadBundle(%q) = _, %v. Want nil", tarball, err)
+	}
+	checkBundle(t, b, responses)
+}
+
+func TestBundlePEM(t *testing.T) {
+	signer, vcekURL := testSigner(t)
+	dir := t.TempDir()
+	name := filepath.Join(dir, "Milan", hex.EncodeToString(signer.HWID[:]), strings.TrimSuffix(bundleFile(signer.TCB), ".der")+".pem")
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Vcek.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := trust.LoadBundle(dir)
+	if err != nil {
+		t.Fatalf("LoadBundle(%q) = _, %v. Want nil", dir, err)
+	}
+	checkBundle(t, b, map[string][]byte{vcekURL: signer.Vcek.Raw})
+}
+
+// bundleFile returns the file name of a VCEK certificate in a bundle.
+func bundleFile(tcb kds.TCBVersion) string {
+	return fmt.Sprintf("%016x.der", uint64(tcb))
+}
+
+func TestBundleMissing(t *testing.T) {
+	signer, vcekURL := testSigner(t)
+	b, err := trust.LoadBundle(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Get(vcekURL)
+	if !errors.Is(err, trust.ErrNotInBundle) {
+		t.Fatalf("Get(%q) = _, %v. Want %v", vcekURL, err, trust.ErrNotInBundle)
+	}
+	hwid := hex.EncodeToString(signer.HWID[:])
+	for _, want := range []string{hwid, signer.TCB.String(), "Milan/" + hwid + "/" + bundleFile(signer.TCB)} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Get(%q) = _, %v. Want the error to name %q", vcekURL, err, want)
+		}
+	}
+	if _, err := b.Get("https://example.com/cert"); !errors.Is(err, trust.ErrNotInBundle) {
+		t.Errorf("Get(non-KDS URL) = _, %v. Want %v", err, trust.ErrNotInBundle)
+	}
+}
+
+func TestLoadBundleBadFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "Milan"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Milan", "cert_chain"), []byte("<html>Too many requests</html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trust.LoadBundle(dir); err == nil || !strings.Contains(err.Error(), "Milan/cert_chain") {
+		t.Errorf("LoadBundle(%q) = _, %v. Want an error naming Milan/cert_chain", dir, err)
+	}
+}
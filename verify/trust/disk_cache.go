@@ -0,0 +1,208 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/logger"
+)
+
+// DefaultDiskCacheBypass are the URL suffixes that a DiskCacheHTTPSGetter never caches unless told
+// otherwise: CRLs, which AMD reissues.
+var DefaultDiskCacheBypass = []string{"/crl"}
+
+// DiskCacheHTTPSGetter is a meta-HTTPS getter that keeps the certificates it fetches in a directory,
+// so that they survive process restarts. The KDS never changes a VCEK certificate for a given chip
+// and TCB, or a product's certificate chain, and rate limits repeat requests for them.
+//
+// Entries are files named by the SHA-256 of their URL, and are written atomically. Only responses
+// that parse as a certificate, a KDS certificate chain, or a CRL are cached, and an entry that no
+// longer parses is fetched again.
+type DiskCacheHTTPSGetter struct {
+	// Dir is the cache directory. It is created when the first entry is written.
+	Dir string
+	// Getter fetches the URLs that are not cached.
+	Getter HTTPSGetter
+	// Bypass are the URL suffixes that are never cached. If nil, DefaultDiskCacheBypass.
+	Bypass []string
+	// Hooks, if set, are told of each URL answered from the cache.
+	Hooks *kds.Hooks
+}
+
+func (g *DiskCacheHTTPSGetter) bypass(url string) bool {
+	bypass := g.Bypass
+	if bypass == nil {
+		bypass = DefaultDiskCacheBypass
+	}
+	for _, suffix := range bypass {
+		if strings.HasSuffix(url, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *DiskCacheHTTPSGetter) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(g.Dir, hex.EncodeToString(sum[:]))
+}
+
+// cacheable returns whether body is a certificate, a KDS certificate chain, or a CRL.
+func cacheable(body []byte) bool {
+	if _, err := ParseCert(body); err == nil {
+		return true
+	}
+	if _, _, err := kds.ParseProductCertChain(body); err == nil {
+		return true
+	}
+	_, err := kds.ParseCRL(body)
+	return err == nil
+}
+
+// Get returns the cached body of the URL, or else fetches and caches it. A failure to write the
+// cache is logged rather than returned, since the body was still fetched.
+func (g *DiskCacheHTTPSGetter) Get(url string) ([]byte, error) {
+	return g.GetContext(context.Background(), url)
+}
+
+// GetContext is like Get, but passes ctx to Getter for the URLs that are not cached.
+func (g *DiskCacheHTTPSGetter) GetContext(ctx context.Context, url string) ([]byte, error) {
+	if body, ok := g.Cached(url); ok {
+		return body, nil
+	}
+	body, err := AsContextHTTPSGetter(g.Getter).GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if !g.bypass(url) && cacheable(body) {
+		if err := g.store(g.path(url), body); err != nil {
+			logger.Warningf("Could not cache %s: %v", url, err)
+		}
+	}
+	return body, nil
+}
+
+// Cached returns the cached body of the URL without fetching it, e.g., for kds.Prefetch to skip
+// the URLs that an earlier process already fetched.
+func (g *DiskCacheHTTPSGetter) Cached(url string) ([]byte, bool) {
+	if g.bypass(url) {
+		return nil, false
+	}
+	path := g.path(url)
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	if !cacheable(body) {
+		logger.Warningf("Ignoring corrupt cache entry %s for %s", path, url)
+		return nil, false
+	}
+	g.Hooks.CacheHit(url)
+	return body, true
+}
+
+// store atomically writes body to path.
+func (g *DiskCacheHTTPSGetter) store(path string, body []byte) error {
+	return writeFileAtomic(path, body)
+}
+
+// writeFileAtomic writes body to path through a temporary file in the same directory, which it
+// creates if needed, so that readers never see a partial file.
+func writeFileAtomic(path string, body []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Seed caches each DER-encoded VCEK certificate in dir under its KDS URL, which it determines from
+// the certificate's extensions. It fails on the first file that is not a VCEK certificate.
+func (g *DiskCacheHTTPSGetter) Seed(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := filepath.Join(dir, entry.Name())
+		der, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		url, err := vcekURL(der)
+		if err != nil {
+			return fmt.Errorf("could not seed the cache with %s: %v", name, err)
+		}
+		if err := g.store(g.path(url), der); err != nil {
+			return fmt.Errorf("could not seed the cache with %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// vcekURL returns the KDS URL that serves the given VCEK certificate.
+func vcekURL(der []byte) (string, error) {
+	cert, err := ParseCert(der)
+	if err != nil {
+		return "", err
+	}
+	exts, err := kds.VcekCertificateExtensions(cert)
+	if err != nil {
+		return "", err
+	}
+	if len(exts.HWID) == 0 {
+		return "", errors.New("certificate has no hwID")
+	}
+	product, err := kds.ParseProductName(exts.ProductName, abi.VcekReportSigner)
+	if err != nil {
+		return "", err
+	}
+	productLine, err := kds.ProductOf(product)
+	if err != nil {
+		return "", err
+	}
+	return kds.VCEKCertURL(productLine, exts.HWID, exts.TCBVersion), nil
+}
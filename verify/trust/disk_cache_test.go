@@ -0,0 +1,212 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust_test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	test "github.com/google/go-sev-guest/testing"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+// countingGetter serves fixed bodies by URL and counts the requests for each.
+type countingGetter struct {
+	bodies map[string][]byte
+	mu     sync.Mutex
+	gets   map[string]int
+}
+
+func (g *countingGetter) Get(url string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.gets == nil {
+		g.gets = make(map[string]int)
+	}
+	g.gets[url]++
+	body, ok := g.bodies[url]
+	if !ok {
+		return nil, fmt.Errorf("404: %s", url)
+	}
+	return body, nil
+}
+
+var (
+	// Generating a certificate chain is expensive. Just do it once for the test suite.
+	signerOnce sync.Once
+	signer     *test.AmdSigner
+	signerErr  error
+)
+
+func testSigner(t *testing.T) (*test.AmdSigner, string) {
+	t.Helper()
+	signerOnce.Do(func() {
+		signer, signerErr = test.DefaultTestOnlyCertChain("Milan-B1", time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC))
+	})
+	if signerErr != nil {
+		t.Fatal(signerErr)
+	}
+	return signer, kds.VCEKCertURL("Milan", signer.HWID[:], signer.TCB)
+}
+
+func cacheEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, filepath.Join(dir, entry.Name()))
+	}
+	return names
+}
+
+func TestDiskCacheHTTPSGetter(t *testing.T) {
+	signer, url := testSigner(t)
+	dir := filepath.Join(t.TempDir(), "cache")
+	network := &countingGetter{bodies: map[string][]byte{url: signer.Vcek.Raw}}
+	g := &trust.DiskCacheHTTPSGetter{Dir: dir, Getter: network}
+	for i := 0; i < 2; i++ {
+		body, err := g.Get(url)
+		if err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+		}
+		if !bytes.Equal(body, signer.Vcek.Raw) {
+			t.Errorf("Get(%q) = %x. Want the VCEK", url, body)
+		}
+	}
+	if network.gets[url] != 1 {
+		t.Errorf("network fetched %q %d times. Want 1", url, network.gets[url])
+	}
+	// A new process with the same directory does not need the network.
+	restarted := &trust.DiskCacheHTTPSGetter{Dir: dir, Getter: &countingGetter{}}
+	if body, err := restarted.Get(url); err != nil || !bytes.Equal(body, signer.Vcek.Raw) {
+		t.Errorf("Get(%q) after restart = %x, %v. Want the cached VCEK", url, body, err)
+	}
+}
+
+func TestPrefetchDiskCache(t *testing.T) {
+	signer, _ := testSigner(t)
+	// The test signer's CHIP_ID is zero, which is how a host masks it.
+	hwid := make([]byte, abi.ChipIDSize)
+	hwid[0] = 1
+	url := kds.VCEKCertURL("Milan", hwid, signer.TCB)
+	chainURL := kds.ProductCertChainURL(abi.VcekReportSigner, "Milan")
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Ask.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Ark.Raw})...)
+	network := &countingGetter{bodies: map[string][]byte{url: signer.Vcek.Raw, chainURL: chain}}
+	chips := []kds.Chip{{Product: "Milan", HWID: hwid, TCB: kds.DecomposeTCBVersion(signer.TCB)}}
+	dir := t.TempDir()
+	if r := kds.Prefetch(&trust.DiskCacheHTTPSGetter{Dir: dir, Getter: network}, chips, 1, nil); r[0].Status != kds.PrefetchFetched {
+		t.Fatalf("Prefetch() = %v (%v). Want %v", r[0].Status, r[0].Err, kds.PrefetchFetched)
+	}
+	// A new process with the same directory finds the chip's certificates on disk.
+	restarted := &countingGetter{}
+	if r := kds.Prefetch(&trust.DiskCacheHTTPSGetter{Dir: dir, Getter: restarted}, chips, 1, nil); r[0].Status != kds.PrefetchCached {
+		t.Errorf("Prefetch() after restart = %v (%v). Want %v", r[0].Status, r[0].Err, kds.PrefetchCached)
+	}
+	if len(restarted.gets) != 0 {
+		t.Errorf("Prefetch() after restart fetched %v. Want nothing", restarted.gets)
+	}
+}
+
+func TestDiskCacheHTTPSGetterBypass(t *testing.T) {
+	crlURL := kds.CrlURL("Milan")
+	dir := t.TempDir()
+	network := &countingGetter{bodies: map[string][]byte{crlURL: []byte("crl")}}
+	g := &trust.DiskCacheHTTPSGetter{Dir: dir, Getter: network}
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(crlURL); err != nil {
+			t.Fatalf("Get(%q) = _, %v. Want nil", crlURL, err)
+		}
+	}
+	if network.gets[crlURL] != 2 {
+		t.Errorf("network fetched %q %d times. Want every time", crlURL, network.gets[crlURL])
+	}
+	if entries := cacheEntries(t, dir); len(entries) != 0 {
+		t.Errorf("cache has %v after CRL fetches. Want nothing", entries)
+	}
+}
+
+func TestDiskCacheHTTPSGetterUncacheable(t *testing.T) {
+	url := "https://kdsintf.amd.com/vcek/v1/Milan/cert_chain"
+	dir := t.TempDir()
+	network := &countingGetter{bodies: map[string][]byte{url: []byte("<html>Too many requests</html>")}}
+	g := &trust.DiskCacheHTTPSGetter{Dir: dir, Getter: network}
+	if _, err := g.Get(url); err != nil {
+		t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+	}
+	if entries := cacheEntries(t, dir); len(entries) != 0 {
+		t.Errorf("cache has %v after a response that is not a certificate. Want nothing", entries)
+	}
+}
+
+func TestDiskCacheHTTPSGetterCorrupt(t *testing.T) {
+	signer, url := testSigner(t)
+	dir := t.TempDir()
+	network := &countingGetter{bodies: map[string][]byte{url: signer.Vcek.Raw}}
+	g := &trust.DiskCacheHTTPSGetter{Dir: dir, Getter: network}
+	if _, err := g.Get(url); err != nil {
+		t.Fatal(err)
+	}
+	entries := cacheEntries(t, dir)
+	if len(entries) != 1 {
+		t.Fatalf("cache has %v. Want one entry", entries)
+	}
+	if err := os.WriteFile(entries[0], signer.Vcek.Raw[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	body, err := g.Get(url)
+	if err != nil || !bytes.Equal(body, signer.Vcek.Raw) {
+		t.Fatalf("Get(%q) with a corrupt entry = %x, %v. Want the VCEK", url, body, err)
+	}
+	if network.gets[url] != 2 {
+		t.Errorf("network fetched %q %d times. Want a refetch for the corrupt entry", url, network.gets[url])
+	}
+	if stored, err := os.ReadFile(entries[0]); err != nil || !bytes.Equal(stored, signer.Vcek.Raw) {
+		t.Errorf("corrupt cache entry = %x, %v after refetch. Want the VCEK", stored, err)
+	}
+}
+
+func TestDiskCacheHTTPSGetterSeed(t *testing.T) {
+	signer, url := testSigner(t)
+	seed := t.TempDir()
+	if err := os.WriteFile(filepath.Join(seed, "vcek.der"), signer.Vcek.Raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	g := &trust.DiskCacheHTTPSGetter{Dir: t.TempDir(), Getter: &countingGetter{}}
+	if err := g.Seed(seed); err != nil {
+		t.Fatalf("Seed(%q) = %v. Want nil", seed, err)
+	}
+	if body, err := g.Get(url); err != nil || !bytes.Equal(body, signer.Vcek.Raw) {
+		t.Errorf("Get(%q) after Seed = %x, %v. Want the seeded VCEK", url, body, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(seed, "ark.der"), signer.Ark.Raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Seed(seed); err == nil {
+		t.Errorf("Seed(%q) with an ARK = nil. Want an error", seed)
+	}
+}
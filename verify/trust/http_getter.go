@@ -0,0 +1,242 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/go-sev-guest/kds"
+	"go.uber.org/multierr"
+)
+
+// HTTPClientGetter implements the HTTPSGetter interface with an http.Client, retrying the statuses
+// that the KDS answers while it is overloaded or under maintenance: 429 Too Many Requests and 5xx.
+// Other failing statuses, such as 404 Not Found for a VCEK that does not exist, are not retried.
+// Retries back off exponentially with jitter, or wait as long as a Retry-After header says.
+type HTTPClientGetter struct {
+	// Client sends the requests. If nil, uses http.DefaultClient.
+	Client *http.Client
+	// Timeout caps the time spent on a URL, including retries. If zero, 2 minutes.
+	Timeout time.Duration
+	// InitialBackoff is the wait before the first retry. It doubles for each retry thereafter.
+	// If zero, 10 seconds, since the KDS throttles repeat requests to once per 10 seconds.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries, other than one that Retry-After asks for. If zero,
+	// 30 seconds.
+	MaxBackoff time.Duration
+	// UserAgent is the User-Agent header of each request. If empty, DefaultUserAgent.
+	UserAgent string
+	// MaxResponseSize caps the bytes read from a response body. A larger response is an error that
+	// is not retried. If zero, DefaultMaxResponseSize.
+	MaxResponseSize int64
+	// Hooks, if set, are told of each request, including each retry, and its response.
+	Hooks *kds.Hooks
+}
+
+const (
+	// DefaultUserAgent is the User-Agent that an HTTPClientGetter sends unless told otherwise.
+	DefaultUserAgent = "go-sev-guest"
+	// DefaultMaxResponseSize is the response size limit of an HTTPClientGetter unless told
+	// otherwise. KDS certificates, certificate chains, and CRLs are a few kilobytes.
+	DefaultMaxResponseSize = 1 << 20
+)
+
+// HTTPClientOptions configures the http.Client that NewHTTPClientGetter creates.
+type HTTPClientOptions struct {
+	// Proxy is the URL of the proxy for all requests. If nil, the proxy is taken from the
+	// HTTPS_PROXY and NO_PROXY environment variables as with http.ProxyFromEnvironment.
+	Proxy *url.URL
+	// RootCAs are the certificate authorities to trust for TLS, e.g., a proxy's private CA. If
+	// nil, the system's certificate authorities.
+	RootCAs *x509.CertPool
+	// RequestTimeout caps the time of a single request, while HTTPClientGetter.Timeout caps the
+	// time of all retries. If zero, 30 seconds.
+	RequestTimeout time.Duration
+	// Timeout caps the time spent on a URL, including retries. If zero, 2 minutes.
+	Timeout time.Duration
+	// UserAgent is the User-Agent header of each request. If empty, DefaultUserAgent.
+	UserAgent string
+	// MaxResponseSize caps the bytes read from a response body. If zero, DefaultMaxResponseSize.
+	MaxResponseSize int64
+	// Hooks, if set, are told of each request and its response.
+	Hooks *kds.Hooks
+}
+
+// NewHTTPClientGetter returns an HTTPClientGetter whose http.Client uses the given proxy and TLS
+// configuration. A nil opts is the same as empty options.
+func NewHTTPClientGetter(opts *HTTPClientOptions) *HTTPClientGetter {
+	if opts == nil {
+		opts = &HTTPClientOptions{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+	if opts.RootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: opts.RootCAs, MinVersion: tls.VersionTLS12}
+	}
+	return &HTTPClientGetter{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   durationOr(opts.RequestTimeout, 30*time.Second),
+		},
+		Timeout:         opts.Timeout,
+		UserAgent:       opts.UserAgent,
+		MaxResponseSize: opts.MaxResponseSize,
+		Hooks:           opts.Hooks,
+	}
+}
+
+func (g *HTTPClientGetter) client() *http.Client {
+	if g.Client == nil {
+		return http.DefaultClient
+	}
+	return g.Client
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// maxErrorBody is how much of a failing response's body an HTTPError keeps.
+const maxErrorBody = 512
+
+// statusError returns the error for a response with a failing status, and whether it has a
+// Retry-After header.
+func statusError(url string, resp *http.Response, now time.Time) (*kds.HTTPError, bool) {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	wait, ok := retryAfter(resp.Header.Get("Retry-After"), now)
+	return &kds.HTTPError{URL: url, StatusCode: resp.StatusCode, Body: body, RetryAfter: wait}, ok
+}
+
+// retryAfter returns the wait that a Retry-After header value asks for, either as seconds or as
+// an HTTP date, or false if there is none.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// noRetryAfter is the wait that getOnce returns when the server did not ask for one.
+const noRetryAfter = time.Duration(-1)
+
+// getOnce sends one GET request. It returns the wait that the server asked for before a retry, or
+// noRetryAfter, and whether a retry may succeed.
+func (g *HTTPClientGetter) getOnce(ctx context.Context, url string) (body []byte, wait time.Duration, transient bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, noRetryAfter, false, err
+	}
+	userAgent := g.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	status := 0
+	start := time.Now()
+	g.Hooks.Request(url)
+	defer func() { g.Hooks.Response(url, status, time.Since(start), err) }()
+	resp, err := g.client().Do(req)
+	if err != nil {
+		httpErr := &kds.HTTPError{URL: url, Err: err}
+		return nil, noRetryAfter, httpErr.Transient(), httpErr
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		httpErr, ok := statusError(url, resp, time.Now())
+		wait := httpErr.RetryAfter
+		if !ok {
+			wait = noRetryAfter
+		}
+		return nil, wait, httpErr.Transient(), httpErr
+	}
+	limit := g.MaxResponseSize
+	if limit == 0 {
+		limit = DefaultMaxResponseSize
+	}
+	// Read one byte past the limit to tell a response of exactly the limit from a larger one.
+	body, err = io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, noRetryAfter, true, err
+	}
+	if int64(len(body)) > limit {
+		return nil, noRetryAfter, false, fmt.Errorf("response from '%s' is larger than %d bytes", url, limit)
+	}
+	return body, noRetryAfter, false, nil
+}
+
+// Get fetches the body of the URL, retrying transient failures until the timeout.
+func (g *HTTPClientGetter) Get(url string) ([]byte, error) {
+	return g.GetContext(context.Background(), url)
+}
+
+// GetContext is like Get, but abandons the request and its retries when ctx is done.
+func (g *HTTPClientGetter) GetContext(parent context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(parent, durationOr(g.Timeout, 2*time.Minute))
+	defer cancel()
+	backoff := durationOr(g.InitialBackoff, initialDelay)
+	maxBackoff := durationOr(g.MaxBackoff, 30*time.Second)
+	var errs error
+	for {
+		body, wait, transient, err := g.getOnce(ctx, url)
+		if err == nil {
+			return body, nil
+		}
+		errs = multierr.Append(errs, err)
+		if !transient {
+			return nil, errs
+		}
+		if wait == noRetryAfter {
+			// Wait between half and all of the backoff, so that clients throttled together do not
+			// retry together.
+			wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, multierr.Append(errs, fmt.Errorf("timeout: the next retry of '%s' is due after %v", url, wait))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, multierr.Append(errs, fmt.Errorf("timeout: %w", ctx.Err()))
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
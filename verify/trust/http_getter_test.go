@@ -0,0 +1,327 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+// scriptedResponse is one answer of a scriptedServer.
+type scriptedResponse struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+// scriptedServer answers requests with its script in order, then with 200 and "content".
+func scriptedServer(t *testing.T, script ...scriptedResponse) (*httptest.Server, *int) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		*requests++
+		if *requests <= len(script) {
+			resp := script[*requests-1]
+			if resp.retryAfter != "" {
+				w.Header().Set("Retry-After", resp.retryAfter)
+			}
+			w.WriteHeader(resp.status)
+			w.Write([]byte(resp.body))
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func fastGetter(server *httptest.Server) *trust.HTTPClientGetter {
+	return &trust.HTTPClientGetter{
+		Client:         server.Client(),
+		Timeout:        5 * time.Second,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	}
+}
+
+func TestHTTPClientGetterRetries(t *testing.T) {
+	tcs := []struct {
+		name   string
+		script []scriptedResponse
+	}{
+		{name: "too many requests", script: []scriptedResponse{{status: http.StatusTooManyRequests, retryAfter: "0"}}},
+		{name: "maintenance", script: []scriptedResponse{{status: http.StatusServiceUnavailable}, {status: http.StatusBadGateway}}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := scriptedServer(t, tc.script...)
+			body, err := fastGetter(server).Get(server.URL)
+			if err != nil {
+				t.Fatalf("Get(%q) = _, %v. Want nil", server.URL, err)
+			}
+			if string(body) != "content" {
+				t.Errorf("Get(%q) = %q. Want %q", server.URL, body, "content")
+			}
+			if want := len(tc.script) + 1; *requests != want {
+				t.Errorf("Get(%q) sent %d requests. Want %d", server.URL, *requests, want)
+			}
+		})
+	}
+}
+
+func TestHTTPClientGetterRetryAfter(t *testing.T) {
+	server, requests := scriptedServer(t, scriptedResponse{status: http.StatusTooManyRequests, retryAfter: "1"})
+	start := time.Now()
+	if _, err := fastGetter(server).Get(server.URL); err != nil {
+		t.Fatalf("Get(%q) = _, %v. Want nil", server.URL, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Get(%q) retried after %v. Want Retry-After's 1s", server.URL, elapsed)
+	}
+	if *requests != 2 {
+		t.Errorf("Get(%q) sent %d requests. Want 2", server.URL, *requests)
+	}
+}
+
+func TestHTTPClientGetterNotFound(t *testing.T) {
+	server, requests := scriptedServer(t, scriptedResponse{status: http.StatusNotFound})
+	if _, err := fastGetter(server).Get(server.URL); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("Get(%q) = _, %v. Want a status 404 error", server.URL, err)
+	}
+	if *requests != 1 {
+		t.Errorf("Get(%q) sent %d requests for a 404. Want 1", server.URL, *requests)
+	}
+}
+
+func TestHTTPClientGetterHTTPError(t *testing.T) {
+	server, requests := scriptedServer(t, scriptedResponse{status: http.StatusForbidden, body: "blocked by egress policy"})
+	_, err := fastGetter(server).Get(server.URL)
+	var httpErr *kds.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("Get(%q) = _, %v. Want a *kds.HTTPError", server.URL, err)
+	}
+	if httpErr.URL != server.URL || httpErr.StatusCode != http.StatusForbidden || string(httpErr.Body) != "blocked by egress policy" {
+		t.Errorf("Get(%q) = _, %+v. Want a 403 with the proxy's body", server.URL, httpErr)
+	}
+	if !strings.Contains(err.Error(), "blocked by egress policy") {
+		t.Errorf("Get(%q) = _, %v. Want the body in the message", server.URL, err)
+	}
+	if *requests != 1 {
+		t.Errorf("Get(%q) sent %d requests for a 403. Want 1", server.URL, *requests)
+	}
+}
+
+func TestSimpleHTTPSGetterHTTPError(t *testing.T) {
+	server, _ := scriptedServer(t, scriptedResponse{status: http.StatusTooManyRequests, retryAfter: "7"})
+	_, err := (&trust.SimpleHTTPSGetter{}).Get(server.URL)
+	var httpErr *kds.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests || httpErr.RetryAfter != 7*time.Second || !httpErr.Transient() {
+		t.Errorf("Get(%q) = _, %v. Want a transient 429 *kds.HTTPError with RetryAfter 7s", server.URL, err)
+	}
+
+	// A TLS failure has no status, and retrying does not fix it.
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer tlsServer.Close()
+	_, err = (&trust.SimpleHTTPSGetter{}).Get(tlsServer.URL)
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 0 || httpErr.Err == nil || httpErr.Transient() {
+		t.Errorf("Get(%q) = _, %v. Want a permanent *kds.HTTPError with a transport error", tlsServer.URL, err)
+	}
+
+	// A closed connection may work on a retry.
+	closed := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	closed.Close()
+	_, err = (&trust.SimpleHTTPSGetter{}).Get(closed.URL)
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 0 || !httpErr.Transient() {
+		t.Errorf("Get(%q) = _, %v. Want a transient *kds.HTTPError", closed.URL, err)
+	}
+}
+
+func TestHTTPClientGetterTimeout(t *testing.T) {
+	var script []scriptedResponse
+	for i := 0; i < 1000; i++ {
+		script = append(script, scriptedResponse{status: http.StatusInternalServerError})
+	}
+	server, _ := scriptedServer(t, script...)
+	getter := fastGetter(server)
+	getter.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	if _, err := getter.Get(server.URL); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Get(%q) = _, %v. Want a timeout", server.URL, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Get(%q) took %v. Want about the 50ms timeout", server.URL, elapsed)
+	}
+}
+
+func TestHTTPClientGetterRetryAfterPastTimeout(t *testing.T) {
+	server, requests := scriptedServer(t, scriptedResponse{status: http.StatusTooManyRequests, retryAfter: "3600"})
+	start := time.Now()
+	if _, err := fastGetter(server).Get(server.URL); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Get(%q) = _, %v. Want a timeout", server.URL, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Get(%q) waited %v for a retry past its timeout. Want to give up at once", server.URL, elapsed)
+	}
+	if *requests != 1 {
+		t.Errorf("Get(%q) sent %d requests. Want 1", server.URL, *requests)
+	}
+}
+
+func TestHTTPClientGetterUserAgent(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+	getter := fastGetter(server)
+	if _, err := getter.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got != trust.DefaultUserAgent {
+		t.Errorf("Get(%q) sent User-Agent %q. Want %q", server.URL, got, trust.DefaultUserAgent)
+	}
+	getter.UserAgent = "verifier/1.0"
+	if _, err := getter.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got != "verifier/1.0" {
+		t.Errorf("Get(%q) sent User-Agent %q. Want %q", server.URL, got, "verifier/1.0")
+	}
+}
+
+func TestHTTPClientGetterMaxResponseSize(t *testing.T) {
+	server, requests := scriptedServer(t)
+	getter := fastGetter(server)
+	getter.MaxResponseSize = int64(len("content"))
+	if body, err := getter.Get(server.URL); err != nil || string(body) != "content" {
+		t.Errorf("Get(%q) = %q, %v with a limit of its size. Want %q, nil", server.URL, body, err, "content")
+	}
+	getter.MaxResponseSize--
+	if _, err := getter.Get(server.URL); err == nil || !strings.Contains(err.Error(), "larger than 6 bytes") {
+		t.Errorf("Get(%q) = _, %v. Want a size limit error", server.URL, err)
+	}
+	if *requests != 2 {
+		t.Errorf("Get(%q) sent %d requests. Want no retry of an oversized response", server.URL, *requests)
+	}
+}
+
+func TestNewHTTPClientGetter(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+	start := time.Now()
+	if _, err := trust.NewHTTPClientGetter(nil).Get(server.URL); err == nil {
+		t.Errorf("Get(%q) without the server's CA = nil. Want a TLS error", server.URL)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Get(%q) without the server's CA took %v. Want no retries", server.URL, elapsed)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	getter := trust.NewHTTPClientGetter(&trust.HTTPClientOptions{RootCAs: roots})
+	if body, err := getter.Get(server.URL); err != nil || string(body) != "content" {
+		t.Errorf("Get(%q) with the server's CA = %q, %v. Want %q, nil", server.URL, body, err, "content")
+	}
+}
+
+func TestNewHTTPClientGetterProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("content"))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getter := trust.NewHTTPClientGetter(&trust.HTTPClientOptions{Proxy: proxyURL})
+	const target = "http://kds.example/vcek/v1/Milan/cert_chain"
+	if body, err := getter.Get(target); err != nil || string(body) != "content" {
+		t.Fatalf("Get(%q) through a proxy = %q, %v. Want %q, nil", target, body, err, "content")
+	}
+	if proxied != target {
+		t.Errorf("proxy received a request for %q. Want %q", proxied, target)
+	}
+}
+
+func TestHTTPClientGetterContext(t *testing.T) {
+	var script []scriptedResponse
+	for i := 0; i < 1000; i++ {
+		script = append(script, scriptedResponse{status: http.StatusServiceUnavailable})
+	}
+	server, _ := scriptedServer(t, script...)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if _, err := fastGetter(server).GetContext(ctx, server.URL); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext(ctx, %q) = _, %v. Want %v", server.URL, err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetContext(ctx, %q) took %v. Want to stop when ctx is cancelled", server.URL, elapsed)
+	}
+}
+
+func TestHTTPClientGetterHooks(t *testing.T) {
+	server, _ := scriptedServer(t, scriptedResponse{status: http.StatusServiceUnavailable})
+	var mu sync.Mutex
+	var requests int
+	var statuses []int
+	var errs []error
+	g := fastGetter(server)
+	g.Hooks = &kds.Hooks{
+		OnRequest: func(string) {
+			mu.Lock()
+			requests++
+			mu.Unlock()
+		},
+		OnResponse: func(_ string, status int, _ time.Duration, err error) {
+			mu.Lock()
+			statuses = append(statuses, status)
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	}
+	if _, err := g.Get(server.URL); err != nil {
+		t.Fatalf("Get(%q) = _, %v. Want nil", server.URL, err)
+	}
+	if requests != 2 {
+		t.Errorf("OnRequest called %d times. Want 2", requests)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusServiceUnavailable || statuses[1] != http.StatusOK {
+		t.Errorf("OnResponse statuses = %v. Want [503 200]", statuses)
+	}
+	if len(errs) != 2 || errs[0] == nil || errs[1] != nil {
+		t.Errorf("OnResponse errors = %v. Want [error <nil>]", errs)
+	}
+}
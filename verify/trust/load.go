@@ -0,0 +1,291 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/logger"
+)
+
+// ErrNoRootCerts is returned when the files to load trusted roots from hold no certificates.
+var ErrNoRootCerts = errors.New("no certificates to load trusted roots from")
+
+// certRole is the position of a loaded certificate in a product line's chain of trust.
+type certRole int
+
+const (
+	roleARK certRole = iota
+	roleASK
+	roleASVK
+	// roleEndorsementKey is a VCEK or VLEK, which is never a trusted root.
+	roleEndorsementKey
+)
+
+// loadedCert is a certificate with the name of the file it was loaded from, for messages.
+type loadedCert struct {
+	cert   *x509.Certificate
+	source string
+}
+
+// certsOf returns the certificates in data, which is either PEM with any number of CERTIFICATE
+// blocks or a single DER certificate, and whether data holds certificates at all.
+func certsOf(data []byte, source string) ([]loadedCert, bool, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		var certs []loadedCert
+		for rest := data; ; {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, true, fmt.Errorf("could not parse a certificate in %s: %v", source, err)
+			}
+			certs = append(certs, loadedCert{cert: cert, source: source})
+		}
+		return certs, len(certs) != 0, nil
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, false, nil
+	}
+	return []loadedCert{{cert: cert, source: source}}, true, nil
+}
+
+// classify returns the role of cert, and the product line that its subject common name names.
+func classify(cert *x509.Certificate) (certRole, string, error) {
+	cn := cert.Subject.CommonName
+	var role certRole
+	var productLine string
+	switch {
+	case cn == "SEV-VCEK" || cn == "SEV-VLEK":
+		return roleEndorsementKey, "", nil
+	case strings.HasPrefix(cn, "ARK-"):
+		role, productLine = roleARK, strings.TrimPrefix(cn, "ARK-")
+	case strings.HasPrefix(cn, "SEV-VLEK-"):
+		role, productLine = roleASVK, strings.TrimPrefix(cn, "SEV-VLEK-")
+	case strings.HasPrefix(cn, "SEV-"):
+		role, productLine = roleASK, strings.TrimPrefix(cn, "SEV-")
+	default:
+		return 0, "", fmt.Errorf("%w: certificate common name %q is not an AMD ARK, ASK, or ASVK", kds.ErrUnknownProduct, cn)
+	}
+	product, err := kds.ParseProductLine(productLine)
+	if err != nil {
+		return 0, "", fmt.Errorf("certificate common name %q: %w", cn, err)
+	}
+	// The map keys are the product lines that the KDS serves, so a name that only maps to another
+	// product line's chain is ambiguous.
+	if kds.ProductLine(product) != productLine {
+		return 0, "", fmt.Errorf("certificate common name %q names %s, which is under the %s product line",
+			cn, productLine, kds.ProductLine(product))
+	}
+	return role, productLine, nil
+}
+
+// trustedRoots combines the loaded certificates into roots of trust by product line. Each ARK
+// certifies the ASKs and ASVKs of its product line that it signs, in as few roots as they fit.
+func trustedRoots(certs []loadedCert) (map[string][]*AMDRootCerts, error) {
+	if len(certs) == 0 {
+		return nil, ErrNoRootCerts
+	}
+	type productCerts struct {
+		arks, asks, asvks []loadedCert
+	}
+	lines := map[string]*productCerts{}
+	seen := map[string]bool{}
+	for _, c := range certs {
+		// The same certificate in several files, e.g., in a cert_chain and on its own, is loaded once.
+		if seen[string(c.cert.Raw)] {
+			continue
+		}
+		seen[string(c.cert.Raw)] = true
+		role, productLine, err := classify(c.cert)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.source, err)
+		}
+		if role == roleEndorsementKey {
+			logger.Warningf("Skipping %s certificate in %s: a VCEK or VLEK is not a trusted root", c.cert.Subject.CommonName, c.source)
+			continue
+		}
+		line := lines[productLine]
+		if line == nil {
+			line = &productCerts{}
+			lines[productLine] = line
+		}
+		switch role {
+		case roleARK:
+			line.arks = append(line.arks, c)
+		case roleASK:
+			line.asks = append(line.asks, c)
+		case roleASVK:
+			line.asvks = append(line.asvks, c)
+		}
+	}
+	productLines := make([]string, 0, len(lines))
+	for productLine := range lines {
+		productLines = append(productLines, productLine)
+	}
+	sort.Strings(productLines)
+
+	result := map[string][]*AMDRootCerts{}
+	for _, productLine := range productLines {
+		line := lines[productLine]
+		issued := map[*x509.Certificate][2][]*x509.Certificate{}
+		for _, ark := range line.arks {
+			if !bytes.Equal(ark.cert.RawIssuer, ark.cert.RawSubject) || ark.cert.CheckSignatureFrom(ark.cert) != nil {
+				return nil, fmt.Errorf("%s: %s ARK is not self-signed", ark.source, productLine)
+			}
+			issued[ark.cert] = [2][]*x509.Certificate{}
+		}
+		for i, intermediates := range [][]loadedCert{line.asks, line.asvks} {
+			for _, ica := range intermediates {
+				var signer *x509.Certificate
+				for _, ark := range line.arks {
+					if ica.cert.CheckSignatureFrom(ark.cert) == nil {
+						signer = ark.cert
+						break
+					}
+				}
+				if signer == nil {
+					return nil, fmt.Errorf("%s: %s is not signed by a loaded %s ARK", ica.source, ica.cert.Subject.CommonName, productLine)
+				}
+				signed := issued[signer]
+				signed[i] = append(signed[i], ica.cert)
+				issued[signer] = signed
+			}
+		}
+		for _, ark := range line.arks {
+			signed := issued[ark.cert]
+			asks, asvks := signed[0], signed[1]
+			if len(asks) == 0 && len(asvks) == 0 {
+				return nil, fmt.Errorf("%s: %s ARK signs no loaded ASK or ASVK", ark.source, productLine)
+			}
+			for j := 0; j < len(asks) || j < len(asvks); j++ {
+				root := AMDRootCertsProduct(productLine)
+				root.ProductCerts = &ProductCerts{Ark: ark.cert}
+				if j < len(asks) {
+					root.ProductCerts.Ask = asks[j]
+				}
+				if j < len(asvks) {
+					root.ProductCerts.Asvk = asvks[j]
+				}
+				result[productLine] = append(result[productLine], root)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: only VCEK or VLEK certificates", ErrNoRootCerts)
+	}
+	return result, nil
+}
+
+// ParseTrustedRoots returns the roots of trust in data, which is a PEM bundle of any number of
+// certificates, e.g., a KDS cert_chain, or a DER certificate, keyed by the product line that the
+// certificates' common names name, for verify.Options' TrustedRoots. Each ARK must be self-signed
+// and sign at least one of the ASKs or ASVKs, which must each have their product line's ARK. A
+// VCEK or VLEK is skipped with a warning, and any other certificate is an error.
+func ParseTrustedRoots(data []byte) (map[string][]*AMDRootCerts, error) {
+	certs, _, err := certsOf(data, "data")
+	if err != nil {
+		return nil, err
+	}
+	return trustedRoots(certs)
+}
+
+// LoadTrustedRoots is like ParseTrustedRoots, but for the certificates of the file at path, or
+// for those of all files under path if it is a directory, e.g., /etc/amd/certs. The files in a
+// directory that hold no certificates are skipped, e.g., a README. Symbolic links to files are
+// followed, and any other entry that is not a file, e.g., a dangling link or a link to a
+// directory, is skipped with a warning.
+func LoadTrustedRoots(path string) (map[string][]*AMDRootCerts, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		certs, ok, err := certsOf(data, path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a PEM or DER certificate file", ErrNoRootCerts, path)
+		}
+		return trustedRoots(certs)
+	}
+	var certs []loadedCert
+	err = filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			// A symbolic link to a certificate file, e.g., as c_rehash creates, is followed. Links to
+			// directories are not, so that a cycle cannot loop forever.
+			info, err := os.Stat(name)
+			if err != nil {
+				logger.Warningf("Skipping %s: %v", name, err)
+				return nil
+			}
+			if info.IsDir() {
+				logger.Warningf("Skipping %s: symbolic links to directories are not followed", name)
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				logger.Warningf("Skipping %s: it is not a regular file", name)
+				return nil
+			}
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		found, ok, err := certsOf(data, name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Infof("Skipping %s: it holds no certificates", name)
+		}
+		certs = append(certs, found...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: no certificate files under %s", ErrNoRootCerts, path)
+	}
+	return trustedRoots(certs)
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/kds"
+	test "github.com/google/go-sev-guest/testing"
+	"github.com/google/go-sev-guest/verify/testdata"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pemOf(certs ...*x509.Certificate) []byte {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+func TestLoadTrustedRoots(t *testing.T) {
+	signer, err := test.DefaultTestOnlyCertChain("Genoa", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	// AMD's Milan cert_chain, the fake Genoa chain spread over DER and PEM files in subdirectories,
+	// a duplicate of the Milan ARK, a VCEK, and a file that is not a certificate.
+	milan := &trust.ProductCerts{}
+	if err := milan.FromKDSCertBytes(testdata.MilanVcekBytes); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "milan", "cert_chain"), testdata.MilanVcekBytes)
+	writeFile(t, filepath.Join(dir, "milan", "ark.der"), milan.Ark.Raw)
+	writeFile(t, filepath.Join(dir, "genoa", "ark.der"), signer.Ark.Raw)
+	writeFile(t, filepath.Join(dir, "genoa", "intermediates", "ask_asvk.pem"), pemOf(signer.Ask, signer.Asvk))
+	writeFile(t, filepath.Join(dir, "vcek.der"), testdata.VcekBytes)
+	writeFile(t, filepath.Join(dir, "README"), []byte("AMD root certificates\n"))
+
+	roots, err := trust.LoadTrustedRoots(dir)
+	if err != nil {
+		t.Fatalf("LoadTrustedRoots(%q) = _, %v. Want nil", dir, err)
+	}
+	if len(roots) != 2 || len(roots["Milan"]) != 1 || len(roots["Genoa"]) != 1 {
+		t.Fatalf("LoadTrustedRoots(%q) = %v. Want one Milan and one Genoa root", dir, roots)
+	}
+	m := roots["Milan"][0]
+	if m.ProductLine != "Milan" || !m.ProductCerts.Ark.Equal(milan.Ark) || !m.ProductCerts.Ask.Equal(milan.Ask) || m.ProductCerts.Asvk != nil {
+		t.Errorf("Milan root = %+v. Want the Milan ARK and ASK", m.ProductCerts)
+	}
+	g := roots["Genoa"][0]
+	if g.ProductLine != "Genoa" || !g.ProductCerts.Ark.Equal(signer.Ark) || !g.ProductCerts.Ask.Equal(signer.Ask) || !g.ProductCerts.Asvk.Equal(signer.Asvk) {
+		t.Errorf("Genoa root = %+v. Want the fake Genoa ARK, ASK, and ASVK", g.ProductCerts)
+	}
+
+	// A single file is loaded like a directory of one.
+	roots, err = trust.LoadTrustedRoots(filepath.Join(dir, "milan", "cert_chain"))
+	if err != nil || len(roots["Milan"]) != 1 {
+		t.Errorf("LoadTrustedRoots(cert_chain) = %v, %v. Want one Milan root", roots, err)
+	}
+	roots, err = trust.ParseTrustedRoots(testdata.MilanVcekBytes)
+	if err != nil || len(roots["Milan"]) != 1 {
+		t.Errorf("ParseTrustedRoots(cert_chain) = %v, %v. Want one Milan root", roots, err)
+	}
+}
+
+func TestLoadTrustedRootsSymlinks(t *testing.T) {
+	signer, err := test.DefaultTestOnlyCertChain("Milan", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := t.TempDir()
+	writeFile(t, filepath.Join(store, "ark.der"), signer.Ark.Raw)
+	writeFile(t, filepath.Join(store, "ask.pem"), pemOf(signer.Ask))
+	// A directory of links into a certificate store, with a dangling link and a link that would
+	// loop back to the directory.
+	dir := t.TempDir()
+	links := map[string]string{
+		"ark":     filepath.Join(store, "ark.der"),
+		"ask":     filepath.Join(store, "ask.pem"),
+		"missing": filepath.Join(store, "missing.pem"),
+		"loop":    dir,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+	roots, err := trust.LoadTrustedRoots(dir)
+	if err != nil {
+		t.Fatalf("LoadTrustedRoots(%q) = _, %v. Want nil", dir, err)
+	}
+	if len(roots["Milan"]) != 1 || !roots["Milan"][0].ProductCerts.Ark.Equal(signer.Ark) || !roots["Milan"][0].ProductCerts.Ask.Equal(signer.Ask) {
+		t.Errorf("LoadTrustedRoots(%q) = %v. Want the linked Milan ARK and ASK", dir, roots)
+	}
+}
+
+func TestLoadTrustedRootsErrors(t *testing.T) {
+	signer, err := test.DefaultTestOnlyCertChain("Milan", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfSigned := func(cn string) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	tcs := []struct {
+		name    string
+		data    []byte
+		want    error
+		wantErr string
+	}{
+		{name: "unknown product line", data: pemOf(selfSigned("ARK-Naples")), want: kds.ErrUnknownProduct},
+		{name: "not an AMD certificate", data: pemOf(selfSigned("Example Root CA")), want: kds.ErrUnknownProduct},
+		{name: "product of another line", data: pemOf(selfSigned("ARK-Bergamo")), wantErr: "under the Genoa product line"},
+		{name: "ASK without its ARK", data: pemOf(signer.Ask), wantErr: "is not signed by a loaded Milan ARK"},
+		{name: "ARK without intermediates", data: pemOf(signer.Ark), wantErr: "signs no loaded ASK or ASVK"},
+		{name: "VCEK only", data: pemOf(signer.Vcek), want: trust.ErrNoRootCerts},
+		{name: "no certificates", data: []byte("not a certificate"), want: trust.ErrNoRootCerts},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := trust.ParseTrustedRoots(tc.data)
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("ParseTrustedRoots() = _, %v. Want %v", err, tc.want)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("ParseTrustedRoots() = _, %v. Want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "README"), []byte("no certificates here\n"))
+	if _, err := trust.LoadTrustedRoots(dir); !errors.Is(err, trust.ErrNoRootCerts) {
+		t.Errorf("LoadTrustedRoots(directory without certificates) = _, %v. Want %v", err, trust.ErrNoRootCerts)
+	}
+	if _, err := trust.LoadTrustedRoots(filepath.Join(dir, "README")); !errors.Is(err, trust.ErrNoRootCerts) {
+		t.Errorf("LoadTrustedRoots(README) = _, %v. Want %v", err, trust.ErrNoRootCerts)
+	}
+}
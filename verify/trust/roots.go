@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/logger"
+)
+
+// The X.509 ARK and ASK certificates of a product line, as the KDS serves them at
+// https://kdsintf.amd.com/vcek/v1/<product line>/cert_chain. They are valid for 25 years, so
+// embedding them lets a verifier trust them without first downloading them from the service it is
+// checking.
+var (
+	//go:embed ark_milan.der
+	arkMilanDER []byte
+	//go:embed ask_milan.der
+	askMilanDER []byte
+)
+
+// embeddedChain is a product line's embedded ARK and ASK, with the SHA-256 digests that AMD's
+// published certificates have, so that a careless update of the embedded files cannot go unnoticed.
+type embeddedChain struct {
+	ark       []byte
+	arkSHA256 string
+	ask       []byte
+	askSHA256 string
+}
+
+// embeddedChains are the embedded chains by product line. Only Milan's are embedded, so other
+// product lines, e.g., Genoa, are only trusted through the verifier's TrustedRoots.
+var embeddedChains = map[string]embeddedChain{
+	"Milan": {
+		ark:       arkMilanDER,
+		arkSHA256: "69d063b45344d26a2e94e1f4210de49ef555308287d4c174445c95639a540bcd",
+		ask:       askMilanDER,
+		askSHA256: "67d303bd3905fd38db8b20e0793699870e7fa612eaad5dec358293fd8c0bac1b",
+	},
+}
+
+// ErrEmbeddedDigest is returned for a product line whose embedded ARK or ASK does not have the
+// SHA-256 digest of the certificate that AMD publishes.
+var ErrEmbeddedDigest = errors.New("embedded certificate is not AMD's")
+
+// embeddedErrs are the errors that the embedded chains failed to decode with, by product line.
+var embeddedErrs map[string]error
+
+// checkDigest returns an error if der's SHA-256 digest is not want.
+func checkDigest(role, productLine string, der []byte, want string) error {
+	sum := sha256.Sum256(der)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("%w: embedded %s %s SHA-256 is %s. Expected %s", ErrEmbeddedDigest, productLine, role, got, want)
+	}
+	return nil
+}
+
+// decode returns the chain as ProductCerts if its certificates have the expected digests.
+func (c embeddedChain) decode(productLine string) (*ProductCerts, error) {
+	if err := checkDigest("ARK", productLine, c.ark, c.arkSHA256); err != nil {
+		return nil, err
+	}
+	if err := checkDigest("ASK", productLine, c.ask, c.askSHA256); err != nil {
+		return nil, err
+	}
+	certs := &ProductCerts{}
+	if err := certs.Decode(c.ask, c.ark); err != nil {
+		return nil, err
+	}
+	if certs.Ark == nil || certs.Ask == nil {
+		return nil, fmt.Errorf("embedded %s chain is not an ARK and an ASK", productLine)
+	}
+	return certs, nil
+}
+
+// embedChains sets the ProductCerts of roots to the decoded chains, and returns the errors of the
+// chains that do not decode by product line.
+func embedChains(chains map[string]embeddedChain, roots map[string]*AMDRootCerts) map[string]error {
+	errs := map[string]error{}
+	for productLine, chain := range chains {
+		certs, err := chain.decode(productLine)
+		if err != nil {
+			logger.Errorf("not trusting the embedded certificates: %v", err)
+			errs[productLine] = err
+			continue
+		}
+		root, ok := roots[productLine]
+		if !ok {
+			root = AMDRootCertsProduct(productLine)
+			roots[productLine] = root
+		}
+		root.ProductCerts = certs
+	}
+	return errs
+}
+
+// ErrNoEmbeddedRoots is returned for a product line whose ARK and ASK are not embedded in this
+// package.
+var ErrNoEmbeddedRoots = errors.New("no embedded ARK and ASK")
+
+// EmbeddedProductCerts returns the ARK and ASK of productLine that are embedded in this package, or
+// an error wrapping ErrNoEmbeddedRoots if none are. Embedded certificates without AMD's digests
+// are an error wrapping ErrEmbeddedDigest instead. Verification uses them as the trusted roots of
+// productLine unless the caller provides its own, and fails for a product line without them.
+func EmbeddedProductCerts(productLine kds.Product) (*ProductCerts, error) {
+	if err, ok := embeddedErrs[string(productLine)]; ok {
+		return nil, fmt.Errorf("could not trust the embedded %s ARK and ASK: %w", productLine, err)
+	}
+	if r, ok := DefaultRootCerts[string(productLine)]; ok && r.ProductCerts != nil {
+		return &ProductCerts{Ark: r.ProductCerts.Ark, Ask: r.ProductCerts.Ask}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoEmbeddedRoots, productLine)
+}
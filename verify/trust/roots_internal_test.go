@@ -0,0 +1,47 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-sev-guest/kds"
+)
+
+func TestEmbeddedDigestMismatch(t *testing.T) {
+	milan := embeddedChains["Milan"]
+	// A careless update that swaps in another certificate.
+	corrupt := milan
+	corrupt.ask = milan.ark
+	roots := map[string]*AMDRootCerts{}
+	errs := embedChains(map[string]embeddedChain{"Milan": corrupt}, roots)
+	if !errors.Is(errs["Milan"], ErrEmbeddedDigest) {
+		t.Errorf("embedChains(corrupt Milan chain) errors = %v. Want a Milan error wrapping %v", errs, ErrEmbeddedDigest)
+	}
+	if roots["Milan"] != nil {
+		t.Errorf("embedChains(corrupt Milan chain) roots = %v. Want no Milan root", roots)
+	}
+
+	oldErrs := embeddedErrs
+	defer func() { embeddedErrs = oldErrs }()
+	embeddedErrs = errs
+	// Verification of the product line fails with the corrupt chain's error, not with a claim that
+	// nothing is embedded.
+	certs, err := EmbeddedProductCerts(kds.Milan)
+	if !errors.Is(err, ErrEmbeddedDigest) || errors.Is(err, ErrNoEmbeddedRoots) {
+		t.Errorf("EmbeddedProductCerts(corrupt Milan chain) = %v, %v. Want an error wrapping %v", certs, err, ErrEmbeddedDigest)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-sev-guest/verify/testdata"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+func TestEmbeddedProductCerts(t *testing.T) {
+	// The SHA-256 fingerprints of the certificates that AMD publishes. The package checks the same
+	// digests before it trusts its embedded copies, so a mismatch leaves no embedded certificates.
+	fingerprints := map[kds.Product]struct{ ark, ask string }{
+		kds.Milan: {
+			ark: "69d063b45344d26a2e94e1f4210de49ef555308287d4c174445c95639a540bcd",
+			ask: "67d303bd3905fd38db8b20e0793699870e7fa612eaad5dec358293fd8c0bac1b",
+		},
+	}
+	for productLine, want := range fingerprints {
+		certs, err := trust.EmbeddedProductCerts(productLine)
+		if err != nil {
+			t.Fatalf("EmbeddedProductCerts(%q) = _, %v. Want the embedded ARK and ASK", productLine, err)
+		}
+		if sum := sha256.Sum256(certs.Ark.Raw); hex.EncodeToString(sum[:]) != want.ark {
+			t.Errorf("%s ARK SHA-256 = %x. Want %s", productLine, sum, want.ark)
+		}
+		if sum := sha256.Sum256(certs.Ask.Raw); hex.EncodeToString(sum[:]) != want.ask {
+			t.Errorf("%s ASK SHA-256 = %x. Want %s", productLine, sum, want.ask)
+		}
+		if err := certs.Ark.CheckSignatureFrom(certs.Ark); err != nil {
+			t.Errorf("%s ARK is not self-signed: %v", productLine, err)
+		}
+		if err := certs.Ask.CheckSignatureFrom(certs.Ark); err != nil {
+			t.Errorf("%s ASK is not signed by the ARK: %v", productLine, err)
+		}
+	}
+	// Genoa, which Bergamo and Siena are under, and Turin have no embedded roots yet.
+	for _, productLine := range []kds.Product{kds.Genoa, kds.Turin} {
+		if certs, err := trust.EmbeddedProductCerts(productLine); !errors.Is(err, trust.ErrNoEmbeddedRoots) {
+			t.Errorf("EmbeddedProductCerts(%q) = %v, %v. Want %v", productLine, certs, err, trust.ErrNoEmbeddedRoots)
+		}
+	}
+}
+
+func TestEmbeddedProductCertsMatchKDS(t *testing.T) {
+	kdsCerts := &trust.ProductCerts{}
+	if err := kdsCerts.FromKDSCertBytes(testdata.MilanVcekBytes); err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := trust.EmbeddedProductCerts(kds.Milan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !embedded.Ark.Equal(kdsCerts.Ark) || !embedded.Ask.Equal(kdsCerts.Ask) {
+		t.Error("embedded Milan ARK and ASK are not the certificates of the KDS cert_chain")
+	}
+}
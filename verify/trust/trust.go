@@ -20,6 +20,7 @@ import (
 	"crypto/x509"
 	_ "embed"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -36,7 +37,8 @@ import (
 
 var (
 	// DefaultRootCerts holds AMD's SEV API certificate format for ASK and ARK keys as published here
-	// https://download.amd.com/developer/eula/sev/ask_ark_milan.cert
+	// https://download.amd.com/developer/eula/sev/ask_ark_milan.cert, and the X.509 ARK and ASK
+	// certificates that EmbeddedProductCerts has, which are only Milan's.
 	DefaultRootCerts map[string]*AMDRootCerts
 
 	// The ASK and ARK certificates are embedded since they do not have an expiration date. The KDS
@@ -46,20 +48,70 @@ var (
 	//go:embed ask_ark_milan.sevcert
 	askArkMilanVcekBytes []byte
 
-	// A cache of product certificate KDS results per product.
+	// A cache of product certificate KDS results per product line and report signer.
 	prodCacheMu          sync.Mutex
-	productLineCertCache map[string]*ProductCerts
+	productLineCertCache map[productChainKey]*ProductCerts
+
+	// ErrIntermediateMismatch is returned when a report's signer chains through the intermediate
+	// certificate that the product certificates lack, e.g., a VLEK-signed report verified
+	// against an ASK rather than an ASVK.
+	ErrIntermediateMismatch = errors.New("intermediate certificate does not match the report signer")
 )
 
+// productChainKey distinguishes the VCEK and VLEK chains of a product line, which share an ARK but
+// not an intermediate.
+type productChainKey struct {
+	productLine string
+	signer      abi.ReportSigner
+}
+
 // Communication with AMD suggests repeat requests of the same arguments will
 // be throttled to once per 10 seconds.
 const initialDelay = 10 * time.Second
 
 // ProductCerts contains the root key and signing key devoted to a given product line.
 type ProductCerts struct {
-	Ask  *x509.Certificate
+	// Ask is the AMD SEV signing key, which certifies VCEKs.
+	Ask *x509.Certificate
+	// Asvk is the AMD SEV VLEK signing key, which certifies VLEKs.
 	Asvk *x509.Certificate
-	Ark  *x509.Certificate
+	// Ark is the AMD root key, which certifies both the ASK and the ASVK.
+	Ark *x509.Certificate
+}
+
+// intermediateName returns the name of the intermediate key that certifies key's certificates.
+func intermediateName(key abi.ReportSigner) string {
+	if key == abi.VlekReportSigner {
+		return "ASVK"
+	}
+	return "ASK"
+}
+
+// Intermediate returns the intermediate certificate that certifies the endorsement key of reports
+// signed by key: the ASK for the VCEK, and the ASVK for the VLEK. If r only has the other
+// intermediate, the error wraps ErrIntermediateMismatch.
+func (r *ProductCerts) Intermediate(key abi.ReportSigner) (*x509.Certificate, error) {
+	var ica, other *x509.Certificate
+	switch key {
+	case abi.VcekReportSigner:
+		ica, other = r.Ask, r.Asvk
+	case abi.VlekReportSigner:
+		ica, other = r.Asvk, r.Ask
+	default:
+		return nil, fmt.Errorf("the KDS certifies no intermediate key for reports signed by %v", key)
+	}
+	if ica != nil {
+		return ica, nil
+	}
+	if other != nil {
+		otherKey := abi.VlekReportSigner
+		if key == abi.VlekReportSigner {
+			otherKey = abi.VcekReportSigner
+		}
+		return nil, fmt.Errorf("%w: %v certificates are signed by the %s, but the only intermediate is the %s %q",
+			ErrIntermediateMismatch, key, intermediateName(key), intermediateName(otherKey), other.Subject.CommonName)
+	}
+	return nil, fmt.Errorf("missing the %s certificate that signs %v certificates", intermediateName(key), key)
 }
 
 // AMDRootCerts encapsulates the certificates that represent root of trust in AMD.
@@ -107,37 +159,92 @@ type HTTPSGetter interface {
 	Get(url string) ([]byte, error)
 }
 
+// ContextHTTPSGetter is an HTTPSGetter whose fetches can be abandoned, e.g., when the request that
+// needs the certificate is cancelled.
+type ContextHTTPSGetter interface {
+	HTTPSGetter
+	// GetContext is like Get, but returns an error wrapping ctx.Err() if ctx is done before the
+	// body is fetched.
+	GetContext(ctx context.Context, url string) ([]byte, error)
+}
+
+// contextShim adapts an HTTPSGetter to a ContextHTTPSGetter that is only checked before the fetch.
+type contextShim struct {
+	HTTPSGetter
+}
+
+// GetContext fetches the URL with Get unless ctx is done first.
+func (s contextShim) GetContext(ctx context.Context, url string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Get(url)
+}
+
+// AsContextHTTPSGetter returns getter if it is a ContextHTTPSGetter, or else a ContextHTTPSGetter
+// that checks its context before, but not during, each of getter's fetches.
+func AsContextHTTPSGetter(getter HTTPSGetter) ContextHTTPSGetter {
+	if cg, ok := getter.(ContextHTTPSGetter); ok {
+		return cg
+	}
+	return contextShim{getter}
+}
+
 // AttestationRecreationErr represents a problem with fetching or interpreting associated
 // certificates for a given attestation report. This is typically due to network unreliability.
 type AttestationRecreationErr struct {
 	Msg string
+	// Err is the cause, if any, e.g., a *kds.MalformedErr for a downloaded certificate that does not
+	// parse. Msg already describes it.
+	Err error
 }
 
 func (e *AttestationRecreationErr) Error() string {
 	return e.Msg
 }
 
+// Unwrap returns the cause of the problem.
+func (e *AttestationRecreationErr) Unwrap() error {
+	return e.Err
+}
+
 // SimpleHTTPSGetter implements the HTTPSGetter interface with http.Get.
-type SimpleHTTPSGetter struct{}
+type SimpleHTTPSGetter struct {
+	// Hooks, if set, are told of each request and its response.
+	Hooks *kds.Hooks
+}
 
 // Get uses http.Get to return the HTTPS response body as a byte array.
 func (n *SimpleHTTPSGetter) Get(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+	return n.GetContext(context.Background(), url)
+}
+
+// GetContext is like Get, but abandons the request when ctx is done.
+func (n *SimpleHTTPSGetter) GetContext(ctx context.Context, url string) (body []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
-	} else if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("failed to retrieve '%s' status %d", url, resp.StatusCode)
 	}
-
-	body, err := io.ReadAll(resp.Body)
+	status := 0
+	start := time.Now()
+	n.Hooks.Request(url)
+	defer func() { n.Hooks.Response(url, status, time.Since(start), err) }()
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &kds.HTTPError{URL: url, Err: err}
 	}
-	resp.Body.Close()
-	return body, nil
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		httpErr, _ := statusError(url, resp, time.Now())
+		return nil, httpErr
+	}
+	return io.ReadAll(resp.Body)
 }
 
-// RetryHTTPSGetter is a meta-HTTPS getter that will retry on failure a given number of times.
+// RetryHTTPSGetter is a meta-HTTPS getter that will retry on failure a given number of times. A
+// *kds.HTTPError that is not Transient, e.g., a 404 Not Found, is not retried, and a retry waits
+// at least as long as its RetryAfter.
 type RetryHTTPSGetter struct {
 	// Timeout is how long to retry before failure.
 	Timeout time.Duration
@@ -149,11 +256,18 @@ type RetryHTTPSGetter struct {
 
 // Get fetches the body of the URL, retrying a given amount of times on failure.
 func (n *RetryHTTPSGetter) Get(url string) ([]byte, error) {
+	return n.GetContext(context.Background(), url)
+}
+
+// GetContext is like Get, but stops retrying when ctx is done, and passes ctx to Getter if it is a
+// ContextHTTPSGetter.
+func (n *RetryHTTPSGetter) GetContext(parent context.Context, url string) ([]byte, error) {
 	delay := initialDelay
-	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	ctx, cancel := context.WithTimeout(parent, n.Timeout)
+	getter := AsContextHTTPSGetter(n.Getter)
 	var returnedError error
 	for {
-		body, err := n.Getter.Get(url)
+		body, err := getter.GetContext(ctx, url)
 		if err == nil {
 			cancel()
 			return body, nil
@@ -163,11 +277,22 @@ func (n *RetryHTTPSGetter) Get(url string) ([]byte, error) {
 		if delay > n.MaxRetryDelay {
 			delay = n.MaxRetryDelay
 		}
+		wait := delay
+		var httpErr *kds.HTTPError
+		if errors.As(err, &httpErr) {
+			if !httpErr.Transient() {
+				cancel()
+				return nil, returnedError
+			}
+			if httpErr.RetryAfter > wait {
+				wait = httpErr.RetryAfter
+			}
+		}
 		select {
 		case <-ctx.Done():
 			cancel()
-			return nil, multierr.Append(returnedError, fmt.Errorf("timeout")) // context cancelled
-		case <-time.After(delay): // wait to retry
+			return nil, multierr.Append(returnedError, fmt.Errorf("timeout: %w", ctx.Err())) // context cancelled
+		case <-time.After(wait): // wait to retry
 		}
 	}
 }
@@ -261,19 +386,12 @@ func (r *ProductCerts) X509Options(now time.Time, key abi.ReportSigner) *x509.Ve
 	}
 	roots := x509.NewCertPool()
 	roots.AddCert(r.Ark)
-	intermediates := x509.NewCertPool()
-	switch key {
-	case abi.VcekReportSigner:
-		if r.Ask == nil {
-			return nil
-		}
-		intermediates.AddCert(r.Ask)
-	case abi.VlekReportSigner:
-		if r.Asvk == nil {
-			return nil
-		}
-		intermediates.AddCert(r.Asvk)
+	ica, err := r.Intermediate(key)
+	if err != nil {
+		return nil
 	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(ica)
 	return &x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: now}
 }
 
@@ -285,42 +403,86 @@ func ClearProductCertCache() {
 	prodCacheMu.Unlock()
 }
 
-// GetProductChain returns the ASK and ARK certificates of the given product line, either from getter
-// or from a cache of the results from the last successful call.
+// GetProductChain returns the ASK and ARK certificates of the given product line, or the ASVK and
+// ARK certificates if s is VlekReportSigner, either from getter or from a cache of the results from
+// the last successful call.
 func GetProductChain(productLine string, s abi.ReportSigner, getter HTTPSGetter) (*ProductCerts, error) {
-	if productLineCertCache == nil {
-		prodCacheMu.Lock()
-		productLineCertCache = make(map[string]*ProductCerts)
-		prodCacheMu.Unlock()
-	}
-	result, ok := productLineCertCache[productLine]
-	if !ok {
-		askark, err := getter.Get(kds.ProductCertChainURL(s, productLine))
-		if err != nil {
-			return nil, &AttestationRecreationErr{
-				Msg: fmt.Sprintf("could not download ASK and ARK certificates: %v", err),
-			}
-		}
+	return GetProductChainContext(context.Background(), productLine, s, getter)
+}
 
-		ask, ark, err := kds.ParseProductCertChain(askark)
-		if err != nil {
-			// Treat a bad parse as a network error since it's likely due to an incomplete transfer.
-			return nil, &AttestationRecreationErr{Msg: fmt.Sprintf("could not parse root cert_chain: %v", err)}
+// GetProductChainContext is like GetProductChain, but abandons the download when ctx is done.
+func GetProductChainContext(ctx context.Context, productLine string, s abi.ReportSigner, getter HTTPSGetter) (*ProductCerts, error) {
+	key := productChainKey{productLine: productLine, signer: s}
+	prodCacheMu.Lock()
+	result, ok := productLineCertCache[key]
+	prodCacheMu.Unlock()
+	if ok {
+		return result, nil
+	}
+	ica := intermediateName(s)
+	icaCert, arkCert, err := kds.GetProductChainContext(ctx, getter, s, kds.Product(productLine))
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("%s and ARK download abandoned: %w", ica, ctx.Err())
+	}
+	var fetchErr *kds.FetchErr
+	if errors.As(err, &fetchErr) {
+		return nil, &AttestationRecreationErr{
+			Msg: fmt.Sprintf("could not download %s and ARK certificates: %v", ica, fetchErr.Err),
 		}
-		askCert, err := x509.ParseCertificate(ask)
-		if err != nil {
-			return nil, &AttestationRecreationErr{Msg: fmt.Sprintf("could not parse ASK cert: %v", err)}
+	}
+	if err != nil {
+		// Treat a bad parse as a network error since it's likely due to an incomplete transfer.
+		return nil, &AttestationRecreationErr{Msg: fmt.Sprintf("could not parse root cert_chain: %v", err), Err: err}
+	}
+	result = &ProductCerts{Ark: arkCert}
+	if s == abi.VlekReportSigner {
+		result.Asvk = icaCert
+	} else {
+		result.Ask = icaCert
+	}
+	prodCacheMu.Lock()
+	if productLineCertCache == nil {
+		productLineCertCache = make(map[productChainKey]*ProductCerts)
+	}
+	productLineCertCache[key] = result
+	prodCacheMu.Unlock()
+	return result, nil
+}
+
+// GetVLEKCert downloads the VLEK certificate of the given product line at the given TCB version,
+// along with the ASVK and ARK certificates that sign it. The KDS only serves a VLEK certificate to
+// the cloud service provider that it was provisioned for, so getter must add the provider's
+// credentials to its requests.
+func GetVLEKCert(productLine string, tcb kds.TCBVersion, getter HTTPSGetter) (*x509.Certificate, *ProductCerts, error) {
+	return GetVLEKCertContext(context.Background(), productLine, tcb, getter)
+}
+
+// GetVLEKCertContext is like GetVLEKCert, but abandons the downloads when ctx is done.
+func GetVLEKCertContext(ctx context.Context, productLine string, tcb kds.TCBVersion, getter HTTPSGetter) (*x509.Certificate, *ProductCerts, error) {
+	vlek, err := kds.GetCertContext(ctx, getter, kds.VLEKCertURL(kds.Product(productLine), tcb))
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("VLEK download abandoned: %w", ctx.Err())
+	}
+	var malformedErr *kds.MalformedErr
+	if errors.As(err, &malformedErr) {
+		return nil, nil, &AttestationRecreationErr{Msg: fmt.Sprintf("could not parse VLEK cert: %v", err), Err: err}
+	}
+	if err != nil {
+		return nil, nil, &AttestationRecreationErr{
+			Msg: fmt.Sprintf("could not download VLEK certificate: %v", err),
 		}
-		arkCert, err := x509.ParseCertificate(ark)
-		if err != nil {
-			return nil, &AttestationRecreationErr{Msg: fmt.Sprintf("could not parse ARK cert: %v", err)}
+	}
+	asvkCert, arkCert, err := kds.GetProductChainContext(ctx, getter, abi.VlekReportSigner, kds.Product(productLine))
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("ASVK and ARK download abandoned: %w", ctx.Err())
+	}
+	if err != nil {
+		return nil, nil, &AttestationRecreationErr{
+			Msg: fmt.Sprintf("could not get ASVK and ARK certificates: %v", err),
+			Err: err,
 		}
-		result = &ProductCerts{Ask: askCert, Ark: arkCert}
-		prodCacheMu.Lock()
-		productLineCertCache[productLine] = result
-		prodCacheMu.Unlock()
 	}
-	return result, nil
+	return vlek, &ProductCerts{Asvk: asvkCert, Ark: arkCert}, nil
 }
 
 // Forward all the ProductCerts operations from the AMDRootCerts struct to follow the
@@ -357,11 +519,12 @@ func (r *AMDRootCerts) X509Options(now time.Time, key abi.ReportSigner) *x509.Ve
 	return r.ProductCerts.X509Options(now, key)
 }
 
-// Parse ASK, ARK certificates from the embedded AMD certificate file.
+// Parse ASK, ARK certificates from the embedded AMD certificate files.
 func init() {
-	milanCerts := new(AMDRootCerts)
+	milanCerts := AMDRootCertsProduct("Milan")
 	milanCerts.Unmarshal(askArkMilanVcekBytes)
 	DefaultRootCerts = map[string]*AMDRootCerts{
 		"Milan": milanCerts,
 	}
+	embeddedErrs = embedChains(embeddedChains, DefaultRootCerts)
 }
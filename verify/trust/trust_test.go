@@ -16,10 +16,17 @@ package trust_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
 	test "github.com/google/go-sev-guest/testing"
 	"github.com/google/go-sev-guest/verify/trust"
 )
@@ -134,3 +141,170 @@ func TestRetryHTTPSGetterAllFail(t *testing.T) {
 	}
 	testGetter.Done(t)
 }
+
+func TestRetryHTTPSGetterPermanent(t *testing.T) {
+	testGetter := &test.Getter{Responses: map[string][]test.GetResponse{}}
+	r := &trust.RetryHTTPSGetter{
+		Timeout:       time.Minute,
+		MaxRetryDelay: time.Minute,
+		Getter:        testGetter,
+	}
+	start := time.Now()
+	_, err := r.Get("https://fetch.me")
+	var httpErr *kds.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Get() = _, %v. Want a 404 *kds.HTTPError", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Get() retried a 404 for %v", elapsed)
+	}
+}
+
+func TestAsContextHTTPSGetter(t *testing.T) {
+	getter := &test.Getter{
+		Responses: map[string][]test.GetResponse{
+			"https://fetch.me": {{Occurrences: 1, Body: []byte("content")}},
+		},
+	}
+	cg := trust.AsContextHTTPSGetter(getter)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cg.GetContext(cancelled, "https://fetch.me"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext(cancelled, _) = _, %v. Want %v", err, context.Canceled)
+	}
+	body, err := cg.GetContext(context.Background(), "https://fetch.me")
+	if err != nil || !bytes.Equal(body, []byte("content")) {
+		t.Errorf("GetContext(_, _) = %q, %v. Want %q, nil", body, err, "content")
+	}
+	getter.Done(t)
+
+	retry := &trust.RetryHTTPSGetter{Getter: getter}
+	if got := trust.AsContextHTTPSGetter(retry); got != trust.ContextHTTPSGetter(retry) {
+		t.Errorf("AsContextHTTPSGetter(%v) = %v. Want the getter itself", retry, got)
+	}
+}
+
+func TestRetryHTTPSGetterCancelled(t *testing.T) {
+	testGetter := &test.Getter{
+		Responses: map[string][]test.GetResponse{
+			"https://fetch.me": {{Occurrences: 1, Error: errors.New("fail")}},
+		},
+	}
+	r := &trust.RetryHTTPSGetter{
+		Timeout:       time.Minute,
+		MaxRetryDelay: time.Minute,
+		Getter:        testGetter,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.GetContext(ctx, "https://fetch.me"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetContext(ctx, _) = _, %v. Want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetContext(ctx, _) retried for %v after ctx was done", elapsed)
+	}
+	testGetter.Done(t)
+}
+
+func TestProductCertsIntermediate(t *testing.T) {
+	signer, _ := testSigner(t)
+	both := &trust.ProductCerts{Ask: signer.Ask, Asvk: signer.Asvk, Ark: signer.Ark}
+	for key, want := range map[abi.ReportSigner]*x509.Certificate{abi.VcekReportSigner: signer.Ask, abi.VlekReportSigner: signer.Asvk} {
+		if got, err := both.Intermediate(key); err != nil || got != want {
+			t.Errorf("Intermediate(%v) = %v, %v. Want %v, nil", key, got.Subject, err, want.Subject)
+		}
+	}
+	askOnly := &trust.ProductCerts{Ask: signer.Ask, Ark: signer.Ark}
+	if _, err := askOnly.Intermediate(abi.VlekReportSigner); !errors.Is(err, trust.ErrIntermediateMismatch) {
+		t.Errorf("Intermediate(VLEK) with only the ASK = _, %v. Want %v", err, trust.ErrIntermediateMismatch)
+	}
+	if opts := askOnly.X509Options(time.Now(), abi.VlekReportSigner); opts != nil {
+		t.Errorf("X509Options(_, VLEK) with only the ASK = %v. Want nil", opts)
+	}
+	asvkOnly := &trust.ProductCerts{Asvk: signer.Asvk, Ark: signer.Ark}
+	if _, err := asvkOnly.Intermediate(abi.VcekReportSigner); !errors.Is(err, trust.ErrIntermediateMismatch) {
+		t.Errorf("Intermediate(VCEK) with only the ASVK = _, %v. Want %v", err, trust.ErrIntermediateMismatch)
+	}
+}
+
+func TestGetProductChainVLEK(t *testing.T) {
+	trust.ClearProductCertCache()
+	defer trust.ClearProductCertCache()
+	signer, _ := testSigner(t)
+	chain := func(ica *x509.Certificate) []byte {
+		return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ica.Raw}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Ark.Raw})...)
+	}
+	getter := &countingGetter{bodies: map[string][]byte{
+		kds.ProductCertChainURL(abi.VcekReportSigner, "Milan"): chain(signer.Ask),
+		kds.ProductCertChainURL(abi.VlekReportSigner, "Milan"): chain(signer.Asvk),
+	}}
+	vcek, err := trust.GetProductChain("Milan", abi.VcekReportSigner, getter)
+	if err != nil {
+		t.Fatalf("GetProductChain(Milan, VCEK) = _, %v. Want nil", err)
+	}
+	// The VCEK chain is cached, but must not answer for the VLEK chain.
+	vlek, err := trust.GetProductChain("Milan", abi.VlekReportSigner, getter)
+	if err != nil {
+		t.Fatalf("GetProductChain(Milan, VLEK) = _, %v. Want nil", err)
+	}
+	if vcek.Ask == nil || !vcek.Ask.Equal(signer.Ask) || vcek.Asvk != nil {
+		t.Errorf("GetProductChain(Milan, VCEK) = %+v. Want only the ASK and ARK", vcek)
+	}
+	if vlek.Asvk == nil || !vlek.Asvk.Equal(signer.Asvk) || vlek.Ask != nil {
+		t.Errorf("GetProductChain(Milan, VLEK) = %+v. Want only the ASVK and ARK", vlek)
+	}
+}
+
+// flakyGetter fails its first fetch, then serves body.
+type flakyGetter struct {
+	body []byte
+	gets int32
+}
+
+func (g *flakyGetter) Get(string) ([]byte, error) {
+	if atomic.AddInt32(&g.gets, 1) == 1 {
+		return nil, errors.New("connection reset")
+	}
+	return g.body, nil
+}
+
+func TestGetterStack(t *testing.T) {
+	signer, url := testSigner(t)
+	network := &flakyGetter{body: signer.Vcek.Raw}
+	g := &kds.CachingGetter{
+		Getter: &trust.RetryHTTPSGetter{
+			Timeout:       5 * time.Second,
+			MaxRetryDelay: time.Millisecond,
+			Getter:        &kds.RateLimitedGetter{Getter: network, Limiter: kds.NewRateLimiter(20, 1)},
+		},
+		MaxEntries: 8,
+	}
+	start := time.Now()
+	body, err := g.Get(url)
+	if err != nil {
+		t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+	}
+	if !bytes.Equal(body, signer.Vcek.Raw) {
+		t.Errorf("Get(%q) = %x. Want the VCEK", url, body)
+	}
+	// The retry waited for the rate limiter.
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Get(%q) with a retry at 20/s took %v. Want at least 40ms", url, elapsed)
+	}
+	// A cache hit neither fetches nor waits for the spent rate limiter.
+	start = time.Now()
+	if _, err := g.Get(url); err != nil {
+		t.Fatalf("Get(%q) = _, %v. Want nil", url, err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("cached Get(%q) took %v. Want no rate limiter wait", url, elapsed)
+	}
+	if gets := atomic.LoadInt32(&network.gets); gets != 2 {
+		t.Errorf("network fetched %q %d times. Want 2", url, gets)
+	}
+	if g.Len() != 1 || g.Bytes() != len(signer.Vcek.Raw) {
+		t.Errorf("Len(), Bytes() = %d, %d. Want 1, %d", g.Len(), g.Bytes(), len(signer.Vcek.Raw))
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust_test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	test "github.com/google/go-sev-guest/testing"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+func TestGetVLEKCert(t *testing.T) {
+	signer, _ := testSigner(t)
+	tcb := kds.TCBVersion(0x0a00000000000102)
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Asvk.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Ark.Raw})...)
+	getter := test.SimpleGetter(map[string][]byte{
+		kds.VLEKCertURL("Milan", tcb):                          signer.Vlek.Raw,
+		kds.ProductCertChainURL(abi.VlekReportSigner, "Milan"): chain,
+	})
+	vlek, certs, err := trust.GetVLEKCert("Milan", tcb, getter)
+	if err != nil {
+		t.Fatalf("GetVLEKCert(\"Milan\", %v, _) = _, _, %v. Want nil", tcb, err)
+	}
+	if !bytes.Equal(vlek.Raw, signer.Vlek.Raw) {
+		t.Error("GetVLEKCert() returned the wrong VLEK certificate")
+	}
+	if certs.Asvk == nil || !bytes.Equal(certs.Asvk.Raw, signer.Asvk.Raw) || certs.Ark == nil || !bytes.Equal(certs.Ark.Raw, signer.Ark.Raw) {
+		t.Errorf("GetVLEKCert() chain = %v. Want the test ASVK and ARK", certs)
+	}
+	if err := vlek.CheckSignatureFrom(certs.Asvk); err != nil {
+		t.Errorf("GetVLEKCert() VLEK is not signed by its ASVK: %v", err)
+	}
+
+	_, _, err = trust.GetVLEKCert("Milan", tcb+1, getter)
+	var recreation *trust.AttestationRecreationErr
+	if !errors.As(err, &recreation) {
+		t.Errorf("GetVLEKCert(\"Milan\", unknown TCB, _) = _, _, %v. Want *trust.AttestationRecreationErr", err)
+	}
+}
@@ -16,12 +16,18 @@
 package verify
 
 import (
+	"bytes"
+	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/sha512"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"flag"
 	"fmt"
+	"math/big"
+	"os"
 	"time"
 
 	"github.com/google/go-sev-guest/abi"
@@ -47,7 +53,10 @@ const (
 var (
 	// ErrMissingVlek is returned when attempting to verify a VLEK-signed report that doesn't also
 	// have its VLEK certificate attached.
-	ErrMissingVlek     = errors.New("report signed with VLEK, but VLEK certificate is missing")
+	ErrMissingVlek = errors.New("report signed with VLEK, but VLEK certificate is missing")
+	// ErrSignerMismatch is returned when a report's signature does not verify under the certificate
+	// of the key that its SIGNER_INFO names, but does under the other endorsement key's certificate.
+	ErrSignerMismatch  = errors.New("report is not signed by the key that SIGNER_INFO names")
 	workaroundStepping = flag.Bool("workaround_kds_productname", false, "If true, don't compare "+
 		"stepping values from the VCEK certificate and the attestation's or options' Product")
 )
@@ -240,11 +249,8 @@ func validateX509(r *trust.AMDRootCerts, key abi.ReportSigner) error {
 	if err := validateArkX509(r); err != nil {
 		return fmt.Errorf("ARK validation error: %v", err)
 	}
-	if r.ProductCerts.Ask == nil && key == abi.VcekReportSigner {
-		return fmt.Errorf("trusted root must have ASK certificate for VCEK chain")
-	}
-	if r.ProductCerts.Asvk == nil && key == abi.VlekReportSigner {
-		return fmt.Errorf("trusted root must have ASVK certificate for VLEK chain")
+	if _, err := r.ProductCerts.Intermediate(key); err != nil {
+		return fmt.Errorf("trusted root cannot certify the %v: %w", key, err)
 	}
 	if r.ProductCerts.Ask != nil {
 		if err := validateAskX509(r); err != nil {
@@ -291,79 +297,185 @@ type CRLUnavailableErr struct {
 	error
 }
 
-// GetCrlAndCheckRoot downloads the given cert's CRL from one of the distribution points and
-// verifies that the CRL is valid and doesn't revoke an intermediate key.
+// Is reports the error as an ErrCertFetch.
+func (e CRLUnavailableErr) Is(target error) bool {
+	return target == ErrCertFetch
+}
+
+// ErrCRLIssuerMismatch is returned for a CRL that was not issued for the product line being
+// verified, e.g., Genoa's CRL for a Milan VCEK. Unlike a CRLUnavailableErr, it is never grounds to
+// fail open, since revocation would have been checked against the wrong list.
+var ErrCRLIssuerMismatch = errors.New("CRL was not issued for the product line")
+
+// ErrRevoked is returned when the CRL of the product line revokes the ASK or the VCEK.
+var ErrRevoked = errors.New("certificate is revoked")
+
+// ErrCRLStale is returned for a CRL whose NextUpdate is not after the verification time, since AMD
+// would have reissued it by then and it may be missing revocations.
+var ErrCRLStale = errors.New("CRL is past its NextUpdate")
+
+// CRLStatus describes the CRL that a verification checked revocations against, e.g., to log how
+// current it was.
+type CRLStatus struct {
+	// Issuer is the CRL's issuer, the ARK of the product line.
+	Issuer pkix.Name
+	// Number is the CRL's sequence number.
+	Number *big.Int
+	// ThisUpdate is when the CRL was issued.
+	ThisUpdate time.Time
+	// NextUpdate is when the next CRL is due, or zero if the CRL does not say.
+	NextUpdate time.Time
+	// Revoked is how many certificates the CRL revokes.
+	Revoked int
+}
+
+func crlStatus(crl *x509.RevocationList) *CRLStatus {
+	return &CRLStatus{
+		Issuer:     crl.Issuer,
+		Number:     crl.Number,
+		ThisUpdate: crl.ThisUpdate,
+		NextUpdate: crl.NextUpdate,
+		Revoked:    len(crl.RevokedCertificates),
+	}
+}
+
+// CheckCRLIssuer returns an error wrapping ErrCRLIssuerMismatch unless crl was issued by the ARK of
+// r's product line, which issues the CRL that covers the product line's ASK. It does not check the
+// CRL's signature.
+func CheckCRLIssuer(crl *x509.RevocationList, r *trust.AMDRootCerts) error {
+	if productLine := r.GetProductLine(); productLine != "" {
+		if want := fmt.Sprintf("ARK-%s", productLine); crl.Issuer.CommonName != want {
+			return fmt.Errorf("%w: CRL issuer common-name is %s. Expected %s", ErrCRLIssuerMismatch, crl.Issuer.CommonName, want)
+		}
+	}
+	if r.ProductCerts == nil || r.ProductCerts.Ark == nil {
+		return errors.New("missing ARK x509 certificate to check the CRL issuer")
+	}
+	if !bytes.Equal(crl.RawIssuer, r.ProductCerts.Ark.RawSubject) {
+		return fmt.Errorf("%w: CRL issuer %q is not the ARK %q", ErrCRLIssuerMismatch, crl.Issuer, r.ProductCerts.Ark.Subject)
+	}
+	return nil
+}
+
+// crlURLs returns where to fetch the CRL for r: the KDS CRL URL of r's product line, or if r has no
+// product line, the ASK's CRL distribution points.
+func crlURLs(r *trust.AMDRootCerts) ([]string, error) {
+	if productLine := r.GetProductLine(); productLine != "" {
+		return []string{kds.CrlURL(kds.Product(productLine))}, nil
+	}
+	if r.ProductCerts == nil || r.ProductCerts.Ask == nil {
+		return nil, errors.New("missing ASK x509 certificate to find the CRL")
+	}
+	return r.ProductCerts.Ask.CRLDistributionPoints, nil
+}
+
+// GetCrlAndCheckRoot downloads the CRL of r's product line and verifies that the CRL is valid, was
+// issued for the product line, is current, and doesn't revoke an intermediate key. A CRL that was
+// issued for another product line is an error wrapping ErrCRLIssuerMismatch, one that is past its
+// NextUpdate wraps ErrCRLStale, and a revoked ASK wraps ErrRevoked.
 func GetCrlAndCheckRoot(r *trust.AMDRootCerts, opts *Options) (*x509.RevocationList, error) {
+	return GetCrlAndCheckRootContext(context.Background(), r, opts)
+}
+
+// GetCrlAndCheckRootContext is like GetCrlAndCheckRoot, but abandons the download when ctx is done.
+func GetCrlAndCheckRootContext(ctx context.Context, r *trust.AMDRootCerts, opts *Options) (*x509.RevocationList, error) {
 	r.Mu.Lock()
 	defer r.Mu.Unlock()
 	getter := opts.Getter
 	if getter == nil {
 		getter = trust.DefaultHTTPSGetter()
 	}
-	if r.CRL != nil && opts.Now.Before(r.CRL.NextUpdate) {
+	if r.CRL != nil && opts.now().Before(r.CRL.NextUpdate) {
 		return r.CRL, nil
 	}
+	if opts.DisableCertFetching {
+		return nil, CRLUnavailableErr{errors.New("no current CRL is cached, and CRL downloads are disabled")}
+	}
+	urls, err := crlURLs(r)
+	if err != nil {
+		return nil, err
+	}
 	var errs error
-	for _, url := range r.ProductCerts.Ask.CRLDistributionPoints {
-		bytes, err := getter.Get(url)
+	for _, url := range urls {
+		body, err := trust.AsContextHTTPSGetter(getter).GetContext(ctx, url)
 		if err != nil {
 			errs = multierr.Append(errs, err)
 			continue
 		}
-		crl, err := x509.ParseRevocationList(bytes)
+		crl, err := x509.ParseRevocationList(body)
 		if err != nil {
 			errs = multierr.Append(errs, err)
 			continue
 		}
-		r.CRL = crl
-		if err := verifyCRL(r); err != nil {
+		if err := CheckCRLIssuer(crl, r); err != nil {
 			return nil, err
 		}
+		if err := verifyCRL(r, crl); err != nil {
+			return nil, err
+		}
+		if now := opts.now(); !crl.NextUpdate.IsZero() && !now.Before(crl.NextUpdate) {
+			return nil, withKind(ErrCertFetch, fmt.Errorf("%w: CRL NextUpdate %v is not after %v", ErrCRLStale, crl.NextUpdate, now))
+		}
+		r.CRL = crl
 		return r.CRL, nil
 	}
 	return nil, CRLUnavailableErr{multierr.Append(errs, errors.New("could not fetch product CRL"))}
 }
 
-// verifyCRL checks that the VCEK CRL is signed by the ARK. Must be called after r.CRL is set and while
-// r.Mu is held.
-func verifyCRL(r *trust.AMDRootCerts) error {
-	if r.CRL == nil {
-		return errors.New("internal error: CRL not set")
-	}
+// verifyCRL checks that the VCEK CRL is signed by the ARK and does not revoke the ASK. Must be
+// called while r.Mu is held.
+func verifyCRL(r *trust.AMDRootCerts, crl *x509.RevocationList) error {
 	if r.ProductCerts.Ark == nil {
 		return errors.New("missing ARK x509 certificate to check CRL validity")
 	}
 	if r.ProductCerts.Ask == nil {
 		return errors.New("missing ASK x509 certificate to check intermediate key validity")
 	}
-	if err := r.CRL.CheckSignatureFrom(r.ProductCerts.Ark); err != nil {
+	if err := crl.CheckSignatureFrom(r.ProductCerts.Ark); err != nil {
 		return fmt.Errorf("CRL is not signed by ARK: %v", err)
 	}
-	for _, bad := range r.CRL.RevokedCertificates {
+	for _, bad := range crl.RevokedCertificates {
 		if r.ProductCerts.Ask.SerialNumber.Cmp(bad.SerialNumber) == 0 {
-			return fmt.Errorf("ASK was revoked at %v", bad.RevocationTime)
+			return fmt.Errorf("%w: ASK was revoked at %v", ErrRevoked, bad.RevocationTime)
 		}
-		// From offline discussions with AMD, we don't expect them to ever explicitly revoke a VCEK
-		// since TCB numbers serve the purpose of superceding previous certificates.
 	}
 	return nil
 }
 
 // VcekNotRevoked will consult the online CRL listed in the VCEK certificate for whether this cert
 // has been revoked. Returns nil if not revoked, error on any problem.
-func VcekNotRevoked(r *trust.AMDRootCerts, _ *x509.Certificate, options *Options) error {
-	_, err := GetCrlAndCheckRoot(r, options)
+func VcekNotRevoked(r *trust.AMDRootCerts, cert *x509.Certificate, options *Options) error {
+	_, err := vcekNotRevoked(context.Background(), r, cert, options)
 	return err
 }
 
+// vcekNotRevoked returns the CRL of r's product line if it revokes neither the ASK nor cert.
+func vcekNotRevoked(ctx context.Context, r *trust.AMDRootCerts, cert *x509.Certificate, options *Options) (*x509.RevocationList, error) {
+	crl, err := GetCrlAndCheckRootContext(ctx, r, options)
+	if err != nil {
+		return nil, err
+	}
+	// AMD does not expect to revoke a VCEK, since newer TCB versions supersede older certificates,
+	// but the CRL covers them. A VLEK is certified by the ASVK, whose CRL this is not.
+	if cert != nil && r.ProductCerts.Ask != nil && bytes.Equal(cert.RawIssuer, r.ProductCerts.Ask.RawSubject) {
+		for _, bad := range crl.RevokedCertificates {
+			if cert.SerialNumber.Cmp(bad.SerialNumber) == 0 {
+				return nil, fmt.Errorf("%w: VCEK was revoked at %v", ErrRevoked, bad.RevocationTime)
+			}
+		}
+	}
+	return crl, nil
+}
+
 // product is expected to be of form "Milan" or "Genoa".
 // role is expected to be one of "ARK", "ASK", "ASVK".
 func validateCRLlink(x *x509.Certificate, productLine, role string) error {
-	url := kds.CrlLinkByRole(productLine, role)
+	url := kds.CrlLinkByRole(kds.Product(productLine), role)
 	if len(x.CRLDistributionPoints) != 1 {
 		return fmt.Errorf("%s has %d CRL distribution points, want 1", role, len(x.CRLDistributionPoints))
 	}
-	if x.CRLDistributionPoints[0] != url {
+	// The distribution point may be on a KDS mirror that kds.SetBaseURL set.
+	if dpLine, dpKey, err := kds.ParseCRLURL(x.CRLDistributionPoints[0]); err != nil || dpLine != productLine || kds.CrlLinkByKey(kds.Product(dpLine), dpKey) != url {
 		return fmt.Errorf("%s CRL distribution point is '%s', want '%s'", role, x.CRLDistributionPoints[0], url)
 	}
 	return nil
@@ -386,25 +498,8 @@ func validateKDSCertificateProductNonspecific(cert *x509.Certificate, key abi.Re
 	if cert.Version != 3 {
 		return nil, fmt.Errorf("%v certificate version is %v, expected 3", key, cert.Version)
 	}
-	// Signature algorithm: RSASSA-PSS
-	// Signature hash algorithm sha384
-	if cert.SignatureAlgorithm != x509.SHA384WithRSAPSS {
-		return nil, fmt.Errorf("%v certificate signature algorithm is %v, expected SHA-384 with RSASSA-PSS", key, cert.SignatureAlgorithm)
-	}
-	// Subject Public Key Info ECDSA on curve P-384
-	if cert.PublicKeyAlgorithm != x509.ECDSA {
-		return nil, fmt.Errorf("%v certificate public key type is %v, expected ECDSA", key, cert.PublicKeyAlgorithm)
-	}
-	// Locally bind the public key any type to allow for occurrence typing in the switch statement.
-	switch pub := cert.PublicKey.(type) {
-	case *ecdsa.PublicKey:
-		if pub.Curve.Params().Name != "P-384" {
-			return nil, fmt.Errorf("%v certificate public key curve is %s, expected P-384", key, pub.Curve.Params().Name)
-		}
-	default:
-		return nil, fmt.Errorf("%v certificate public key not ecdsa PublicKey type %v", key, pub)
-	}
-
+	// The signature algorithm and the key type are checked against the ChainPolicy with the rest of
+	// the chain.
 	if err := validateKDSCertSubject(cert.Subject, key); err != nil {
 		return nil, err
 	}
@@ -418,22 +513,78 @@ func validateKDSCertificateProductNonspecific(cert *x509.Certificate, key abi.Re
 	return exts, nil
 }
 
+// ChainLinkErr is returned when one link of the chain of trust from the ARK to an attestation
+// report does not verify: the ARK's signature on itself, the ARK's on the ASK or ASVK, the ASK's or
+// ASVK's on the VCEK or VLEK, or the VCEK's or VLEK's on the report.
+type ChainLinkErr struct {
+	// Signer is the key whose signature does not verify, e.g., "ARK" or "VCEK".
+	Signer string
+	// Signee is what the signature is on, e.g., "ASK" or "report".
+	Signee string
+	Err    error
+}
+
+func (e *ChainLinkErr) Error() string {
+	return fmt.Sprintf("%s signature on the %s does not verify: %v", e.Signer, e.Signee, e.Err)
+}
+
+func (e *ChainLinkErr) Unwrap() error {
+	return e.Err
+}
+
+// Is reports a broken link to the report as an ErrReportSignature, and any other as an
+// ErrChainVerification.
+func (e *ChainLinkErr) Is(target error) bool {
+	if e.Signee == "report" {
+		return target == ErrReportSignature
+	}
+	return target == ErrChainVerification
+}
+
+// intermediateRole returns the name of the certificate authority that signs key's certificates.
+func intermediateRole(key abi.ReportSigner) string {
+	if key == abi.VlekReportSigner {
+		return "ASVK"
+	}
+	return "ASK"
+}
+
+// verifyChainLinks checks each signature from the ARK down to cert, so that a failure names the
+// link that does not verify.
+func verifyChainLinks(ark, ica, cert *x509.Certificate, key abi.ReportSigner) error {
+	if err := ark.CheckSignatureFrom(ark); err != nil {
+		return &ChainLinkErr{Signer: "ARK", Signee: "ARK", Err: err}
+	}
+	if err := ica.CheckSignatureFrom(ark); err != nil {
+		return &ChainLinkErr{Signer: "ARK", Signee: intermediateRole(key), Err: err}
+	}
+	if err := cert.CheckSignatureFrom(ica); err != nil {
+		return &ChainLinkErr{Signer: intermediateRole(key), Signee: key.String(), Err: err}
+	}
+	return nil
+}
+
 func validateKDSCertificateProductSpecifics(r *trust.AMDRootCerts, cert *x509.Certificate, key abi.ReportSigner, opts *Options) error {
 	if err := validateKDSCertIssuer(r, cert.Issuer, key); err != nil {
 		return err
 	}
 	// ica: Intermediate Certificate Authority.
-	ica := r.ProductCerts.Ask
-	if key == abi.VlekReportSigner {
-		ica = r.ProductCerts.Asvk
-	}
-	if ica == nil {
-		return fmt.Errorf("root of trust missing intermediate certificate authority certificate for key %v", key)
+	ica, err := r.ProductCerts.Intermediate(key)
+	if err != nil {
+		return fmt.Errorf("root of trust cannot certify the %v: %w", key, err)
 	}
-	verifyOpts := r.X509Options(opts.Now, key)
+	verifyOpts := r.X509Options(opts.now(), key)
 	if verifyOpts == nil {
 		return fmt.Errorf("internal error: could not get X509 options for %v (missing ARK cert or ICA cert)", key)
 	}
+	if err := verifyChainLinks(r.ProductCerts.Ark, ica, cert, key); err != nil {
+		return fmt.Errorf("error verifying %v certificate: %w", key, err)
+	}
+	// A chain whose signatures verify must still look like AMD's.
+	if err := opts.chainPolicy().check(r.GetProductLine(), r.ProductCerts.Ark, ica, cert, key); err != nil {
+		return fmt.Errorf("error verifying %v certificate: %w", key, err)
+	}
+	// The signatures all verify, so this checks the validity periods and usages.
 	if _, err := cert.Verify(*verifyOpts); err != nil {
 		return fmt.Errorf("error verifying %v certificate: %v (%v)", key, err, ica.IsCA)
 	}
@@ -441,6 +592,30 @@ func validateKDSCertificateProductSpecifics(r *trust.AMDRootCerts, cert *x509.Ce
 	return nil
 }
 
+// ProductMismatchErr is returned when the product of an endorsement key certificate is not the
+// expected product, unless Options.DisableProductCheck is set.
+type ProductMismatchErr struct {
+	// Key is the endorsement key whose certificate names the product.
+	Key abi.ReportSigner
+	// Got is the product that the certificate's productName extension names.
+	Got *spb.SevProduct
+	// Want is the expected product.
+	Want *spb.SevProduct
+}
+
+func (e *ProductMismatchErr) Error() string {
+	if e.Got.GetName() != e.Want.GetName() {
+		return fmt.Sprintf("%v cert product name %v is not %v", e.Key, e.Got, e.Want)
+	}
+	return fmt.Sprintf("%v cert product stepping number 0x%X is not 0x%X",
+		e.Key, e.Got.GetMachineStepping().GetValue(), e.Want.GetMachineStepping().GetValue())
+}
+
+// Is reports the error as an ErrCertReportMismatch.
+func (e *ProductMismatchErr) Is(target error) bool {
+	return target == ErrCertReportMismatch
+}
+
 func checkProductName(got, want *spb.SevProduct, key abi.ReportSigner) error {
 	// No constraint
 	if want == nil {
@@ -450,7 +625,7 @@ func checkProductName(got, want *spb.SevProduct, key abi.ReportSigner) error {
 		return fmt.Errorf("internal error: no product name")
 	}
 	if got.Name != want.Name {
-		return fmt.Errorf("%v cert product name %v is not %v", key, got, want)
+		return &ProductMismatchErr{Key: key, Got: got, Want: want}
 	}
 	// The model stepping number is only part of the VLEK product name, not VLEK's.
 	if key == abi.VcekReportSigner && want.MachineStepping != nil {
@@ -458,17 +633,169 @@ func checkProductName(got, want *spb.SevProduct, key abi.ReportSigner) error {
 			return fmt.Errorf("stepping value in VCEK certificate should not be nil")
 		}
 		if got.MachineStepping.Value != want.MachineStepping.Value && !*workaroundStepping {
-			return fmt.Errorf("%v cert product stepping number 0x%X is not 0x%X",
-				key, got.MachineStepping.Value, want.MachineStepping.Value)
+			return &ProductMismatchErr{Key: key, Got: got, Want: want}
 		}
 	}
 	return nil
 }
 
+// HWIDMismatchErr is returned when the VCEK certificate's hwID extension is not the report's
+// CHIP_ID, unless Options.DisableHWIDCheck is set.
+type HWIDMismatchErr struct {
+	// ChipID is the report's CHIP_ID.
+	ChipID []byte
+	// HWID is the VCEK certificate's hwID.
+	HWID []byte
+}
+
+func (e *HWIDMismatchErr) Error() string {
+	return fmt.Sprintf("report CHIP_ID %s is not the VCEK certificate's hwID %s",
+		abi.ChipIDString(e.ChipID), abi.ChipIDString(e.HWID))
+}
+
+// Is reports the error as an ErrCertReportMismatch.
+func (e *HWIDMismatchErr) Is(target error) bool {
+	return target == ErrCertReportMismatch
+}
+
+// TCBMismatchErr is returned when the TCB extensions of the endorsement key certificate are not the
+// report's REPORTED_TCB, unless Options.DisableTCBCheck is set.
+type TCBMismatchErr struct {
+	// Key is the endorsement key whose certificate has the TCB extensions.
+	Key abi.ReportSigner
+	// ReportedTCB is the report's REPORTED_TCB.
+	ReportedTCB kds.TCBVersion
+	// CertTCB is the TCB version that the certificate's extensions compose.
+	CertTCB kds.TCBVersion
+}
+
+func (e *TCBMismatchErr) Error() string {
+	return fmt.Sprintf("report REPORTED_TCB 0x%x is not the %v certificate's TCB 0x%x", uint64(e.ReportedTCB), e.Key, uint64(e.CertTCB))
+}
+
+// Is reports the error as an ErrCertReportMismatch.
+func (e *TCBMismatchErr) Is(target error) bool {
+	return target == ErrCertReportMismatch
+}
+
+// checkCertReport checks that the endorsement key certificate was issued for the chip and TCB that
+// the report is from. A VLEK is not bound to a chip, so only a VCEK's hwID is compared, and not if
+// the report's CHIP_ID is masked, which details records.
+func checkCertReport(report *spb.Report, cert *x509.Certificate, key abi.ReportSigner, options *Options, details *Details) error {
+	exts, err := kds.CertificateExtensions(cert, key)
+	if err != nil {
+		return fmt.Errorf("could not get %v certificate extensions: %v", key, err)
+	}
+	if key == abi.VcekReportSigner && !options.DisableHWIDCheck {
+		if abi.IsChipIDMasked(report.GetChipId()) {
+			details.ChipIDMasked = true
+		} else if !bytes.Equal(report.GetChipId(), exts.HWID) {
+			return &HWIDMismatchErr{ChipID: report.GetChipId(), HWID: exts.HWID}
+		}
+	}
+	if reported := kds.TCBVersion(report.GetReportedTcb()); !options.DisableTCBCheck && reported != exts.TCBVersion {
+		return &TCBMismatchErr{Key: key, ReportedTCB: reported, CertTCB: exts.TCBVersion}
+	}
+	return nil
+}
+
+// otherEndorsementKey returns the endorsement key that did not sign a report signed by key, and the
+// chain's certificate for it.
+func otherEndorsementKey(chain *spb.CertificateChain, key abi.ReportSigner) (abi.ReportSigner, []byte) {
+	if key == abi.VlekReportSigner {
+		return abi.VcekReportSigner, chain.GetVcekCert()
+	}
+	return abi.VlekReportSigner, chain.GetVlekCert()
+}
+
+// missingCertErr returns the error for a chain without the certificate of key, which signed the
+// report. The error names the other endorsement key if the chain only has its certificate, since a
+// VLEK-signed report cannot be verified with the chip's VCEK, nor the other way around.
+func missingCertErr(chain *spb.CertificateChain, key abi.ReportSigner) error {
+	err := fmt.Errorf("missing %v certificate", key)
+	if key == abi.VlekReportSigner {
+		err = ErrMissingVlek
+	}
+	if other, cert := otherEndorsementKey(chain, key); len(cert) != 0 {
+		return withKind(ErrCertFetch, fmt.Errorf("%w: the certificate table only has a %v certificate", err, other))
+	}
+	return withKind(ErrCertFetch, err)
+}
+
+// signerMismatch returns an error wrapping ErrSignerMismatch if report, whose signature does not
+// verify under the certificate of the key that its SIGNER_INFO names, verifies under the chain's
+// certificate for the other endorsement key. Otherwise it returns err.
+func signerMismatch(report *spb.Report, chain *spb.CertificateChain, key abi.ReportSigner, opts *abi.ParseOptions, err error) error {
+	other, der := otherEndorsementKey(chain, key)
+	if len(der) == 0 {
+		return err
+	}
+	cert, parseErr := trust.ParseCert(der)
+	if parseErr != nil || SnpProtoReportSignatureWithOptions(report, cert, opts) != nil {
+		return err
+	}
+	return fmt.Errorf("%w: SIGNER_INFO names the %v, but the signature verifies under the %v certificate", ErrSignerMismatch, key, other)
+}
+
+// embeddedRoots returns the ARK and ASK of productLine that the trust package embeds, or an error
+// if there are none to trust without the options' TrustedRoots. An attestation's own chain is never
+// trusted in their stead.
+func embeddedRoots(productLine kds.Product) (*trust.ProductCerts, error) {
+	embedded, err := trust.EmbeddedProductCerts(productLine)
+	if err != nil {
+		return nil, fmt.Errorf("no trusted roots for %s; set Options.TrustedRoots: %w", productLine, err)
+	}
+	return embedded, nil
+}
+
+// defaultRoot returns the root of trust for productLine when the options have no TrustedRoots: the
+// chain's intermediate and root certificates, which must be the ones embedded in the trust package.
+// A chain without them is certified by the embedded ones alone.
+func defaultRoot(chain *spb.CertificateChain, productLine kds.Product, key abi.ReportSigner) (*trust.AMDRootCerts, error) {
+	embedded, err := embeddedRoots(productLine)
+	if err != nil {
+		return nil, err
+	}
+	root := trust.AMDRootCertsProduct(productLine.String())
+	// Require that the root matches the embedded SEV format root certs, if there are any.
+	if sev := trust.DefaultRootCerts[productLine.String()]; sev != nil {
+		root.AskSev = sev.AskSev
+		root.ArkSev = sev.ArkSev
+	}
+	if key == abi.VcekReportSigner && len(chain.GetAskCert()) == 0 && len(chain.GetArkCert()) == 0 {
+		root.ProductCerts = embedded
+	} else if err := root.Decode(chain.GetAskCert(), chain.GetArkCert()); err != nil {
+		return nil, err
+	}
+	if err := validateX509(root, key); err != nil {
+		return nil, err
+	}
+	if !root.ProductCerts.Ark.Equal(embedded.Ark) {
+		return nil, fmt.Errorf("ARK certificate is not the embedded %s ARK", productLine)
+	}
+	if key == abi.VcekReportSigner && !root.ProductCerts.Ask.Equal(embedded.Ask) {
+		return nil, fmt.Errorf("ASK certificate is not the embedded %s ASK", productLine)
+	}
+	return root, nil
+}
+
 // decodeCerts checks that the V[CL]EK certificate matches expected fields
 // from the KDS specification and also that its certificate chain matches
 // hardcoded trusted root certificates from AMD.
-func decodeCerts(chain *spb.CertificateChain, key abi.ReportSigner, options *Options) (*x509.Certificate, *trust.AMDRootCerts, error) {
+func decodeCerts(chain *spb.CertificateChain, key abi.ReportSigner, product *spb.SevProduct, options *Options) (*x509.Certificate, *trust.AMDRootCerts, error) {
+	endorsementKeyCert, err := parseEndorsementKeyCert(chain, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := verifyEndorsementKeyCert(endorsementKeyCert, chain, key, product, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return endorsementKeyCert, root, nil
+}
+
+// parseEndorsementKeyCert returns the chain's certificate for key.
+func parseEndorsementKeyCert(chain *spb.CertificateChain, key abi.ReportSigner) (*x509.Certificate, error) {
 	var ek []byte
 	switch key {
 	case abi.VcekReportSigner:
@@ -477,38 +804,43 @@ func decodeCerts(chain *spb.CertificateChain, key abi.ReportSigner, options *Opt
 		ek = chain.GetVlekCert()
 	}
 	if len(ek) == 0 {
-		return nil, nil, fmt.Errorf("missing %v certificate", key)
+		return nil, missingCertErr(chain, key)
 	}
 	endorsementKeyCert, err := trust.ParseCert(ek)
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not interpret %v DER bytes %v: %v", key, ek, err)
+		return nil, fmt.Errorf("could not interpret %v DER bytes %v: %v", key, ek, err)
 	}
+	return endorsementKeyCert, nil
+}
+
+// verifyEndorsementKeyCert checks endorsementKeyCert like decodeCerts, and returns the trusted root
+// that certifies it.
+func verifyEndorsementKeyCert(endorsementKeyCert *x509.Certificate, chain *spb.CertificateChain, key abi.ReportSigner, want *spb.SevProduct, options *Options) (*trust.AMDRootCerts, error) {
 	exts, err := validateKDSCertificateProductNonspecific(endorsementKeyCert, key)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	roots := options.TrustedRoots
 
 	product, err := kds.ParseProductName(exts.ProductName, key)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	productLine := kds.ProductLine(product)
+	productLine, err := kds.KDSProductLine(product)
+	if err != nil {
+		return nil, err
+	}
 	// Ensure the extension product info matches expectations.
-	if err := checkProductName(product, options.Product, key); err != nil {
-		return nil, nil, err
+	if !options.DisableProductCheck {
+		if err := checkProductName(product, want, key); err != nil {
+			return nil, err
+		}
 	}
 	if len(roots) == 0 {
-		root := trust.AMDRootCertsProduct(productLine)
-		// Require that the root matches embedded root certs.
-		root.AskSev = trust.DefaultRootCerts[productLine].AskSev
-		root.ArkSev = trust.DefaultRootCerts[productLine].ArkSev
-		if err := root.Decode(chain.GetAskCert(), chain.GetArkCert()); err != nil {
-			return nil, nil, err
-		}
-		if err := validateX509(root, key); err != nil {
-			return nil, nil, err
+		root, err := defaultRoot(chain, kds.Product(productLine), key)
+		if err != nil {
+			return nil, err
 		}
 		roots = map[string][]*trust.AMDRootCerts{
 			productLine: {root},
@@ -516,66 +848,287 @@ func decodeCerts(chain *spb.CertificateChain, key abi.ReportSigner, options *Opt
 	}
 	var lastErr error
 	for _, productRoot := range roots[productLine] {
+		// A root that does not have the pinned fingerprints is not used, whether or not it verifies.
+		if err := options.checkRootPins(productLine, productRoot, key); err != nil {
+			lastErr = err
+			continue
+		}
 		if err := validateKDSCertificateProductSpecifics(productRoot, endorsementKeyCert, key, options); err != nil {
 			lastErr = err
 			continue
 		}
-		return endorsementKeyCert, productRoot, nil
+		return productRoot, nil
+	}
+	return nil, fmt.Errorf("%v could not be verified by any trusted roots. Last error: %w", key, lastErr)
+}
+
+// endorsementKeyProductLine returns the product line that the endorsement key certificate's
+// productName extension names.
+func endorsementKeyProductLine(cert *x509.Certificate, key abi.ReportSigner) (kds.Product, error) {
+	exts, err := kds.CertificateExtensions(cert, key)
+	if err != nil {
+		return "", err
+	}
+	product, err := kds.ParseProductName(exts.ProductName, key)
+	if err != nil {
+		return "", err
 	}
-	return nil, nil, fmt.Errorf("%v could not be verified by any trusted roots. Last error: %v", key, lastErr)
+	return kds.ProductOf(product)
 }
 
+// cachedDecodeCerts is like decodeCerts, but returns the certificate's chain of trust from the
+// options' ChainCache if it has already verified for the report's chip and TCB, and otherwise
+// remembers it there.
+func cachedDecodeCerts(report *spb.Report, chain *spb.CertificateChain, key abi.ReportSigner, product *spb.SevProduct, options *Options, details *Details) (*x509.Certificate, *trust.AMDRootCerts, error) {
+	cache := options.ChainCache
+	if cache == nil {
+		return decodeCerts(chain, key, product, options)
+	}
+	endorsementKeyCert, err := parseEndorsementKeyCert(chain, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	var productName string
+	if !options.DisableProductCheck && product != nil {
+		productName = kds.ProductName(product)
+	}
+	// The entry is keyed by the product line that the certificate is for, since the expected product
+	// may be unset. A certificate without one fails verification.
+	productLine, err := endorsementKeyProductLine(endorsementKeyCert, key)
+	if err != nil {
+		return decodeCerts(chain, key, product, options)
+	}
+	cacheKey := newChainKey(string(productLine), productName, report.GetChipId(),
+		kds.TCBVersion(report.GetReportedTcb()), endorsementKeyCert)
+	now := options.now()
+	if entry, ok := cache.lookup(cacheKey, now); ok {
+		details.ChainCached = true
+		return entry.cert, entry.root, nil
+	}
+	root, err := verifyEndorsementKeyCert(endorsementKeyCert, chain, key, product, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	ica := root.ProductCerts.Ask
+	if key == abi.VlekReportSigner {
+		ica = root.ProductCerts.Asvk
+	}
+	cache.store(cacheKey, endorsementKeyCert, ica, root, now)
+	return endorsementKeyCert, root, nil
+}
+
+// ErrSignatureKeyType is returned when a report's signature is checked with a key of a type that
+// the report's SignatureAlgo does not sign with.
+var ErrSignatureKeyType = errors.New("key type does not match the report's signature algorithm")
+
 // SnpReportSignature verifies the attestation report's signature based on the report's
 // SignatureAlgo.
 func SnpReportSignature(report []byte, vcek *x509.Certificate) error {
-	if err := abi.ValidateReportFormat(report); err != nil {
-		return fmt.Errorf("attestation report format error: %v", err)
+	return snpReportSignature(report, vcek, nil)
+}
+
+// SnpReportSignatureECDSA is like SnpReportSignature, but verifies the signature under the
+// endorsement key pub, e.g., of a VCEK whose certificate chain was verified out of band. Like
+// SnpReportSignature, it only checks the report's format and its signature over the signed
+// component, and does not fetch or verify any certificates.
+func SnpReportSignatureECDSA(report []byte, pub *ecdsa.PublicKey) error {
+	if pub == nil {
+		return fmt.Errorf("%w: no public key", ErrSignatureKeyType)
+	}
+	return snpReportSignatureKey(report, pub, nil)
+}
+
+func snpReportSignature(report []byte, vcek *x509.Certificate, opts *abi.ParseOptions) error {
+	if vcek == nil {
+		return fmt.Errorf("%w: no endorsement key certificate", ErrSignatureKeyType)
+	}
+	return snpReportSignatureKey(report, vcek.PublicKey, opts)
+}
+
+func snpReportSignatureKey(report []byte, pub crypto.PublicKey, opts *abi.ParseOptions) error {
+	return withKind(ErrReportSignature, checkReportSignature(report, pub, opts))
+}
+
+func checkReportSignature(report []byte, pub crypto.PublicKey, opts *abi.ParseOptions) error {
+	if err := abi.ValidateReportFormatWithOptions(report, opts); err != nil {
+		return fmt.Errorf("attestation report format error: %w", err)
+	}
+	if algo := abi.SignatureAlgo(report); algo != abi.SignEcdsaP384Sha384 {
+		return &abi.ErrUnsupportedSignatureAlgo{Algo: algo}
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: ECDSA P-384 with SHA-384 signatures do not verify under a %T key", ErrSignatureKeyType, pub)
+	}
+	if curve := key.Curve.Params().Name; curve != "P-384" {
+		return fmt.Errorf("%w: ECDSA P-384 with SHA-384 signatures do not verify under a key on %s", ErrSignatureKeyType, curve)
 	}
 	der, err := abi.ReportToSignatureDER(report)
 	if err != nil {
 		return fmt.Errorf("could not interpret report signature: %v", err)
 	}
-	if abi.SignatureAlgo(report) == abi.SignEcdsaP384Sha384 {
-		if err := vcek.CheckSignature(x509.ECDSAWithSHA384, abi.SignedComponent(report), der); err != nil {
-			return fmt.Errorf("report signature verification error: %v", err)
+	digest := sha512.Sum384(abi.SignedComponent(report))
+	if !ecdsa.VerifyASN1(key, digest[:], der) {
+		signer := "endorsement key"
+		if raw, err := abi.ReportSignerInfo(report); err == nil {
+			if info, err := abi.ParseSignerInfo(raw); err == nil {
+				signer = info.SigningKey.String()
+			}
 		}
-		return nil
+		return &ChainLinkErr{Signer: signer, Signee: "report", Err: errors.New("ECDSA verification failure")}
 	}
-
-	return fmt.Errorf("unknown SignatureAlgo: %d", abi.SignatureAlgo(report))
+	return nil
 }
 
 // SnpProtoReportSignature verifies the protobuf representation of an attestation report's signature
 // based on the report's SignatureAlgo.
 func SnpProtoReportSignature(report *spb.Report, vcek *x509.Certificate) error {
+	return SnpProtoReportSignatureWithOptions(report, vcek, nil)
+}
+
+// SnpProtoReportSignatureWithOptions is like SnpProtoReportSignature, but checks the report's format
+// according to opts. Only opts, never the report itself, can allow a report version newer than
+// abi.LatestReportVersion.
+func SnpProtoReportSignatureWithOptions(report *spb.Report, vcek *x509.Certificate, opts *abi.ParseOptions) error {
 	raw, err := abi.ReportToAbiBytes(report)
 	if err != nil {
 		return fmt.Errorf("could not interpret report: %v", err)
 	}
-	return SnpReportSignature(raw, vcek)
+	return snpReportSignature(raw, vcek, opts)
 }
 
 // Options represents verification options for an SEV-SNP attestation report.
 type Options struct {
 	// CheckRevocations set to true if the verifier should retrieve the CRL from the network and check
-	// if the VCEK or ASK have been revoked according to the ARK.
+	// if the VCEK or ASK have been revoked according to the ARK. With DisableCertFetching, a trusted
+	// root must already have a current CRL.
 	CheckRevocations bool
 	// DisableCertFetching set to true if SnpAttestation should not connect to the AMD KDS to fill in
-	// any missing certificates in an attestation's certificate chain. Uses Getter if false.
+	// any missing certificates in an attestation's certificate chain, or to download CRLs. Uses
+	// Getter if false.
 	DisableCertFetching bool
 	// Getter takes a URL and returns the body of its contents. By default uses http.Get and returns
-	// the body.
+	// the body. A VLEK certificate is only downloaded with a Getter that has the CSP's KDS
+	// credentials, so without a Getter, a VLEK-signed attestation must include its certificate.
+	// Use trust.NewHTTPClientGetter to reach the KDS through a proxy or with private CAs.
 	Getter trust.HTTPSGetter
-	// Now is the time at which to verify the validity of certificates. If unset, uses time.Now().
+	// CertProvider provides the certificates that an attestation lacks. If nil, uses a
+	// KDSCertProvider with Getter. A CertProvider that is a VLEKCertProvider also provides missing
+	// VLEK certificates. CRLs are always downloaded with Getter.
+	CertProvider CertProvider
+	// ChainCache, if not nil, remembers the endorsement key certificates whose chains of trust have
+	// verified, so that verifications of further reports from the same chip at the same TCB only
+	// check the report signature and the certificate's revocation.
+	ChainCache *ChainCache
+	// ChainPolicy is the structure that the chain of trust must have besides verifying signatures.
+	// If nil, requires the structure of AMD's current chain.
+	ChainPolicy *ChainPolicy
+	// Now is the time at which to verify the validity of certificates and CRLs. If unset, uses
+	// Clock. To audit an archived attestation, set Now to when it was collected: certificates that
+	// have expired since then still verify, but those that had already expired at that time, or a
+	// CRL whose NextUpdate had passed, still fail.
 	Now time.Time
+	// Clock returns the time at which to verify the validity of certificates and CRLs if Now is
+	// unset, e.g., for a long-running verifier with one Options. If nil, uses time.Now().
+	Clock func() time.Time
 	// TrustedRoots specifies the ARK and ASK certificates to trust when checking the VCEK. If nil,
-	// then verification will fall back on embedded AMD-published root certificates.
+	// then verification will fall back on the AMD-published root certificates that the trust package
+	// embeds, which are only Milan's. Other product lines, e.g., Genoa, need TrustedRoots.
 	// Maps the product name to an array of allowed roots.
 	TrustedRoots map[string][]*trust.AMDRootCerts
+	// RootPins maps a product line, e.g., "Milan", to the SHA-256 fingerprints that the ARK and the
+	// intermediate of its chain of trust must have, in addition to being one of the TrustedRoots,
+	// or the embedded roots if there are none. Product lines without pins are not pinned.
+	RootPins map[string]*RootPins
 	// Product is a forced value for the attestation product name when verifying or retrieving
 	// VCEK certificates. An attestation should carry the product of the reporting
-	// machine.
+	// machine. Verification completes a copy of it with the attestation's product information, and
+	// does not modify it.
 	Product *spb.SevProduct
+	// DisableProductCheck set to true if the endorsement key certificate's productName need not be
+	// the expected Product. A mismatch is otherwise a *ProductMismatchErr.
+	DisableProductCheck bool
+	// DisableHWIDCheck set to true if the VCEK certificate's hwID need not be the report's CHIP_ID. A
+	// mismatch is otherwise a *HWIDMismatchErr. A masked CHIP_ID is never compared.
+	DisableHWIDCheck bool
+	// DisableTCBCheck set to true if the endorsement key certificate's TCB need not be the report's
+	// REPORTED_TCB. A mismatch is otherwise a *TCBMismatchErr.
+	DisableTCBCheck bool
+	// AllowUnknownVersion set to true if attestation reports with a version newer than
+	// abi.LatestReportVersion should verify. See abi.ParseOptions. By default, they fail with
+	// ErrReportSignature.
+	AllowUnknownVersion bool
+}
+
+// ErrNoCRL is returned as an ErrCertFetch before any verification when CheckRevocations is set but
+// there is no way to get a CRL: DisableCertFetching forbids downloading one, and no trusted root has
+// a current one.
+var ErrNoCRL = errors.New("revocations cannot be checked: CRL downloads are disabled and no trusted root has a current CRL")
+
+// certProvider returns the CertProvider that fills in missing certificates.
+func (o *Options) certProvider() CertProvider {
+	if o.CertProvider != nil {
+		return o.CertProvider
+	}
+	return &KDSCertProvider{Getter: o.Getter}
+}
+
+// now returns the time at which to verify certificates and CRLs.
+func (o *Options) chainPolicy() *ChainPolicy {
+	if o.ChainPolicy == nil {
+		return &ChainPolicy{}
+	}
+	return o.ChainPolicy
+}
+
+// parseOptions returns the options to check report formats with. A nil o is strict.
+func (o *Options) parseOptions() *abi.ParseOptions {
+	if o == nil || !o.AllowUnknownVersion {
+		return nil
+	}
+	return &abi.ParseOptions{AllowUnknownVersion: true}
+}
+
+func (o *Options) now() time.Time {
+	if !o.Now.IsZero() {
+		return o.Now
+	}
+	if o.Clock != nil {
+		return o.Clock()
+	}
+	return time.Now()
+}
+
+// check returns an error for options that cannot verify any attestation.
+func (o *Options) check() error {
+	if o.CheckRevocations && o.DisableCertFetching && !o.hasCurrentCRL() {
+		return withKind(ErrCertFetch, ErrNoCRL)
+	}
+	for productLine, pins := range o.RootPins {
+		if pins == nil {
+			continue
+		}
+		if err := pins.check(); err != nil {
+			return withKind(ErrChainVerification, fmt.Errorf("%s root pins: %w", productLine, err))
+		}
+	}
+	return nil
+}
+
+// hasCurrentCRL returns whether any trusted root has a CRL that is not due for reissue.
+func (o *Options) hasCurrentCRL() bool {
+	now := o.now()
+	for _, roots := range o.TrustedRoots {
+		for _, r := range roots {
+			r.Mu.Lock()
+			current := r.CRL != nil && now.Before(r.CRL.NextUpdate)
+			r.Mu.Unlock()
+			if current {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // DefaultOptions returns a useful default verification option setting
@@ -589,6 +1142,17 @@ func DefaultOptions() *Options {
 func getTrustedRoots(rot *cpb.RootOfTrust) (map[string][]*trust.AMDRootCerts, error) {
 	result := map[string][]*trust.AMDRootCerts{}
 	for _, path := range rot.CabundlePaths {
+		// A directory of certificates has roots for the product lines that they name.
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			roots, err := trust.LoadTrustedRoots(path)
+			if err != nil {
+				return nil, fmt.Errorf("could not load CA bundles in %q: %v", path, err)
+			}
+			for productLine, productRoots := range roots {
+				result[productLine] = append(result[productLine], productRoots...)
+			}
+			continue
+		}
 		root := trust.AMDRootCertsProduct(rot.ProductLine)
 		if err := root.FromKDSCert(path); err != nil {
 			return nil, fmt.Errorf("could not parse CA bundle %q: %v", path, err)
@@ -637,7 +1201,8 @@ func updateProductExpectation(product **spb.SevProduct, reportProduct *spb.SevPr
 	reportStepping := reportProduct.GetMachineStepping()
 	if expectStepping == nil {
 		if !*workaroundStepping {
-			(*product).MachineStepping = reportStepping
+			// A copy, since the expectation may be the options' Product.
+			*product = &spb.SevProduct{Name: (*product).GetName(), MachineStepping: reportStepping}
 		}
 		return nil
 	}
@@ -653,40 +1218,99 @@ func updateProductExpectation(product **spb.SevProduct, reportProduct *spb.SevPr
 }
 
 // SnpAttestation verifies the protobuf representation of an attestation report's signature based
-// on the report's SignatureAlgo, provided the certificate chain is valid.
+// on the report's SignatureAlgo, provided the certificate chain is valid. It checks each link of
+// the chain of trust: the ARK's self-signature, the ARK's signature on the ASK (or ASVK), the ASK's
+// on the VCEK (or VLEK), and the VCEK's on the report's ABI bytes, and a link that does not verify
+// is a *ChainLinkErr. Only the certificates that the attestation lacks are fetched with the
+// options' Getter, so a complete attestation verifies without any fetches unless
+// CheckRevocations is set.
 func SnpAttestation(attestation *spb.Attestation, options *Options) error {
+	return SnpAttestationContext(context.Background(), attestation, options)
+}
+
+// SnpAttestationContext is like SnpAttestation, but abandons the downloads of missing
+// certificates and CRLs when ctx is done.
+func SnpAttestationContext(ctx context.Context, attestation *spb.Attestation, options *Options) error {
+	_, err := SnpAttestationDetailsContext(ctx, attestation, options)
+	return err
+}
+
+// Details describes what a successful verification checked.
+type Details struct {
+	// CRL is the CRL that revocations were checked against, or nil if CheckRevocations was not set.
+	CRL *CRLStatus
+	// ChipIDMasked is true if the report's CHIP_ID is masked, so the VCEK certificate's hwID could
+	// not be compared with it.
+	ChipIDMasked bool
+	// ChainCached is true if the endorsement key certificate's chain of trust was verified by an
+	// earlier verification and remembered in the ChainCache.
+	ChainCached bool
+}
+
+// SnpAttestationDetails is like SnpAttestation, but also returns the details of a successful
+// verification, e.g., the CRL's ThisUpdate and NextUpdate to log.
+func SnpAttestationDetails(attestation *spb.Attestation, options *Options) (*Details, error) {
+	return SnpAttestationDetailsContext(context.Background(), attestation, options)
+}
+
+// SnpAttestationDetailsContext is like SnpAttestationDetails, but abandons the downloads of missing
+// certificates and CRLs when ctx is done.
+func SnpAttestationDetailsContext(ctx context.Context, attestation *spb.Attestation, options *Options) (*Details, error) {
 	if options == nil {
-		return fmt.Errorf("options cannot be nil")
+		return nil, fmt.Errorf("options cannot be nil")
 	}
 	if attestation == nil {
-		return fmt.Errorf("attestation cannot be nil")
+		return nil, fmt.Errorf("attestation cannot be nil")
+	}
+	if err := options.check(); err != nil {
+		return nil, err
 	}
 	// Make sure we have the whole certificate chain, or at least the product
-	// info.
-	if err := fillInAttestation(attestation, options); err != nil {
-		return err
+	// info. The expected product is resolved for this verification alone, since the options may be
+	// shared by concurrent ones.
+	product, err := fillInAttestation(ctx, attestation, options)
+	if err != nil {
+		return nil, err
 	}
 
 	report := attestation.GetReport()
 	info, err := abi.ParseSignerInfo(report.GetSignerInfo())
 	if err != nil {
-		return err
+		return nil, withKind(ErrReportSignature, err)
 	}
 	chain := attestation.GetCertificateChain()
-	endorsementKeyCert, root, err := decodeCerts(chain, info.SigningKey, options)
+	details := &Details{}
+	endorsementKeyCert, root, err := cachedDecodeCerts(report, chain, info.SigningKey, product, options, details)
 	if err != nil {
-		return err
+		return nil, withKind(ErrChainVerification, err)
 	}
-	if options != nil && options.CheckRevocations {
-		if err := VcekNotRevoked(root, endorsementKeyCert, options); err != nil {
-			return err
+	if err := checkCertReport(report, endorsementKeyCert, info.SigningKey, options, details); err != nil {
+		return nil, withKind(ErrCertReportMismatch, err)
+	}
+	if options.CheckRevocations {
+		crl, err := vcekNotRevoked(ctx, root, endorsementKeyCert, options)
+		if err != nil {
+			if errors.Is(err, ErrRevoked) && options.ChainCache != nil {
+				if productLine, err := endorsementKeyProductLine(endorsementKeyCert, info.SigningKey); err == nil {
+					options.ChainCache.Invalidate(string(productLine))
+				}
+			}
+			return nil, withKind(ErrChainVerification, err)
 		}
+		details.CRL = crlStatus(crl)
+	}
+	parseOpts := options.parseOptions()
+	if err := SnpProtoReportSignatureWithOptions(report, endorsementKeyCert, parseOpts); err != nil {
+		return nil, withKind(ErrReportSignature, signerMismatch(report, chain, info.SigningKey, parseOpts, err))
 	}
-	return SnpProtoReportSignature(report, endorsementKeyCert)
+	return details, nil
 }
 
 func getProductFromCerts(attestation *spb.Attestation) *spb.SevProduct {
-	certs := abi.CertsFromProto(attestation.CertificateChain)
+	certs, err := abi.CertTableFromProto(attestation.CertificateChain)
+	if err != nil {
+		return nil
+	}
 	blob, err := certs.GetByGUIDString(abi.ExtraPlatformInfoGUID)
 	if err != nil {
 		return nil
@@ -719,46 +1343,88 @@ func setProduct(attestation *spb.Attestation, product *spb.SevProduct) {
 	attestation.Product = product
 }
 
+// fetchedCertErr returns err, the error for certificates that could not be provided, as an
+// ErrChainVerification if they were fetched but are malformed. Otherwise they are missing.
+func fetchedCertErr(err error) error {
+	var malformedErr *kds.MalformedErr
+	if errors.As(err, &malformedErr) {
+		return withKind(ErrChainVerification, err)
+	}
+	return err
+}
+
 // fillInAttestation uses AMD's KDS to populate any empty certificate field in the attestation's
-// certificate chain.
-func fillInAttestation(attestation *spb.Attestation, options *Options) error {
+// certificate chain, and returns the product that the endorsement key certificate must be for: the
+// options' Product, completed with the attestation's product information. The options are not
+// modified.
+func fillInAttestation(ctx context.Context, attestation *spb.Attestation, options *Options) (*spb.SevProduct, error) {
+	expected := options.Product
+	if err := fillInCerts(ctx, attestation, options, &expected); err != nil {
+		return nil, withKind(ErrCertFetch, err)
+	}
+	return expected, nil
+}
+
+func fillInCerts(ctx context.Context, attestation *spb.Attestation, options *Options, expected **spb.SevProduct) error {
 	var productOverridden bool
 	product := getProduct(attestation)
 	if product == nil {
-		if options.Product != nil {
-			product = options.Product
+		if *expected != nil {
+			product = *expected
 		} else {
 			logger.Warning("Attestation missing product information. KDS certificate may be invalid. Using default Milan-B1")
 			attestation.Product = abi.DefaultSevProduct()
+			product = attestation.Product
 		}
 		productOverridden = true
 	}
 	if options.DisableCertFetching {
 		return nil
 	}
-	productLine := kds.ProductLine(product)
-	getter := options.Getter
-	if getter == nil {
-		getter = trust.DefaultHTTPSGetter()
+	productLine, err := kds.KDSProductLine(product)
+	if err != nil {
+		return err
 	}
+	provider := options.certProvider()
 	report := attestation.GetReport()
 	info, err := abi.ParseSignerInfo(report.GetSignerInfo())
 	if err != nil {
-		return err
+		return withKind(ErrReportSignature, err)
 	}
+	tcb := kds.TCBVersion(report.GetReportedTcb())
 	chain := attestation.GetCertificateChain()
 	if chain == nil {
 		chain = &spb.CertificateChain{}
 		attestation.CertificateChain = chain
 	}
+	// The chip's VCEK cannot stand in for the VLEK that signed the report, so there is nothing to
+	// fetch a chain for.
+	if info.SigningKey == abi.VlekReportSigner && len(chain.GetVlekCert()) == 0 && len(chain.GetVcekCert()) != 0 {
+		return missingCertErr(chain, info.SigningKey)
+	}
+	var askark *trust.ProductCerts
+	if len(options.TrustedRoots) == 0 {
+		// Without TrustedRoots, only the embedded roots are trusted, so there is no need to download
+		// any, and nothing to download certificates for if there are none.
+		askark, err = embeddedRoots(kds.Product(productLine))
+		if err != nil {
+			return withKind(ErrChainVerification, err)
+		}
+	}
 	if len(chain.GetAskCert()) == 0 || len(chain.GetArkCert()) == 0 {
-		askark, err := trust.GetProductChain(productLine, info.SigningKey, getter)
+		if askark == nil || info.SigningKey != abi.VcekReportSigner {
+			askark, err = provider.ProductChain(ctx, productLine, info.SigningKey)
+			if err != nil {
+				return fetchedCertErr(err)
+			}
+		}
+		// The ask_cert field holds the ASVK for a VLEK-signed report.
+		ica, err := askark.Intermediate(info.SigningKey)
 		if err != nil {
-			return err
+			return withKind(ErrChainVerification, err)
 		}
-
 		if len(chain.GetAskCert()) == 0 {
-			chain.AskCert = askark.Ask.Raw
+			chain.AskCert = ica.Raw
 		}
 		if len(chain.GetArkCert()) == 0 {
 			chain.ArkCert = askark.Ark.Raw
@@ -767,53 +1433,74 @@ func fillInAttestation(attestation *spb.Attestation, options *Options) error {
 	switch info.SigningKey {
 	case abi.VcekReportSigner:
 		if len(chain.GetVcekCert()) == 0 {
-			vcekURL := kds.VCEKCertURL(productLine, report.GetChipId(), kds.TCBVersion(report.GetReportedTcb()))
-			vcek, err := getter.Get(vcekURL)
+			// No VCEK certificate can be found for a chip that does not say which it is.
+			if abi.IsChipIDMasked(report.GetChipId()) {
+				return fmt.Errorf("could not determine the VCEK certificate: %w", kds.ErrChipIDMasked)
+			}
+			cert, err := provider.VCEK(ctx, productLine, report.GetChipId(), tcb)
+			if err != nil && ctx.Err() != nil {
+				return fmt.Errorf("VCEK certificate download abandoned: %w", ctx.Err())
+			}
 			if err != nil {
-				return &trust.AttestationRecreationErr{
+				return fetchedCertErr(&trust.AttestationRecreationErr{
 					Msg: fmt.Sprintf("could not download VCEK certificate: %v", err),
-				}
+					Err: err,
+				})
 			}
-			chain.VcekCert = vcek
+			chain.VcekCert = cert.Raw
 			// An attempt was made with defaults or the option's product, so now use
 			// the VCEK cert to determine the real product info.
 			if productOverridden {
-				cert, err := x509.ParseCertificate(vcek)
-				if err != nil {
-					return err
-				}
 				exts, err := kds.VcekCertificateExtensions(cert)
 				if err != nil {
-					return err
+					return withKind(ErrChainVerification, err)
 				}
 				product, err = kds.ParseProductName(exts.ProductName, abi.VcekReportSigner)
 				if err != nil {
-					return err
+					return withKind(ErrChainVerification, err)
 				}
 			}
 		}
 	case abi.VlekReportSigner:
-		// We can't lazily ask KDS for the certificate as a user. The CSP must cache their provisioned
-		// certificates and provide them in GET_EXT_REPORT.
+		// Only the CSP can ask KDS for the certificate, so the default getter cannot fetch it. The CSP
+		// should cache their provisioned certificates and provide them in GET_EXT_REPORT.
 		if len(chain.GetVlekCert()) == 0 {
-			return ErrMissingVlek
+			vleks, ok := provider.(VLEKCertProvider)
+			if !ok || (options.CertProvider == nil && options.Getter == nil) {
+				return missingCertErr(chain, info.SigningKey)
+			}
+			vlek, err := vleks.VLEK(ctx, productLine, tcb)
+			var malformedErr *kds.MalformedErr
+			if errors.As(err, &malformedErr) {
+				return withKind(ErrChainVerification, fmt.Errorf("downloaded VLEK certificate is malformed: %w", err))
+			}
+			if err != nil {
+				return fmt.Errorf("%w, and it could not be downloaded: %v", ErrMissingVlek, err)
+			}
+			chain.VlekCert = vlek.Raw
 		}
 	}
 
 	// Pass along the expected product information for VcekDER. fillInAttestation will ensure
 	// that this is a noop if options.Product began as non-nil.
-	return updateProductExpectation(&options.Product, product)
+	return withKind(ErrCertReportMismatch, updateProductExpectation(expected, product))
 }
 
 // GetAttestationFromReport uses AMD's Key Distribution Service (KDS) to download the certificate
 // chain for the VCEK that supposedly signed the given report, and returns the Attestation
 // representation of their combination. If getter is nil, uses Golang's http.Get.
 func GetAttestationFromReport(report *spb.Report, options *Options) (*spb.Attestation, error) {
+	return GetAttestationFromReportContext(context.Background(), report, options)
+}
+
+// GetAttestationFromReportContext is like GetAttestationFromReport, but abandons the downloads when
+// ctx is done.
+func GetAttestationFromReportContext(ctx context.Context, report *spb.Report, options *Options) (*spb.Attestation, error) {
 	result := &spb.Attestation{
 		Report:           report,
 		CertificateChain: &spb.CertificateChain{Extras: map[string][]byte{}},
 	}
-	if err := fillInAttestation(result, options); err != nil {
+	if _, err := fillInAttestation(ctx, result, options); err != nil {
 		return nil, err
 	}
 	// Attempt to fill in the product field of the attestation. Don't error at this
@@ -841,23 +1528,75 @@ func GetAttestationFromReport(report *spb.Report, options *Options) (*spb.Attest
 // on the report's SignatureAlgo and uses the AMD Key Distribution Service to download the
 // report's corresponding VCEK certificate.
 func SnpReport(report *spb.Report, options *Options) error {
+	return SnpReportContext(context.Background(), report, options)
+}
+
+// SnpReportContext is like SnpReport, but abandons the downloads when ctx is done.
+func SnpReportContext(ctx context.Context, report *spb.Report, options *Options) error {
+	if options == nil {
+		return fmt.Errorf("options cannot be nil")
+	}
 	if options.DisableCertFetching {
 		return errors.New("cannot verify attestation report without fetching certificates")
 	}
-	attestation, err := GetAttestationFromReport(report, options)
+	attestation, err := GetAttestationFromReportContext(ctx, report, options)
 	if err != nil {
 		return fmt.Errorf("could not recreate attestation from report: %w", err)
 	}
-	return SnpAttestation(attestation, options)
+	return SnpAttestationContext(ctx, attestation, options)
 }
 
 // RawSnpReport verifies the raw bytes representation of an attestation report's signature
 // based on the report's SignatureAlgo and uses the AMD Key Distribution Service to download
 // the report's corresponding VCEK certificate.
 func RawSnpReport(rawReport []byte, options *Options) error {
-	report, err := abi.ReportToProto(rawReport)
+	return RawSnpReportContext(context.Background(), rawReport, options)
+}
+
+// RawSnpReportContext is like RawSnpReport, but abandons the downloads when ctx is done.
+func RawSnpReportContext(ctx context.Context, rawReport []byte, options *Options) error {
+	report, err := rawReportProto(rawReport, options)
+	if err != nil {
+		return err
+	}
+	return SnpReportContext(ctx, report, options)
+}
+
+// rawReportProto returns the protobuf representation of rawReport, or an error wrapping the abi
+// package's error for the first malformed field.
+func rawReportProto(rawReport []byte, options *Options) (*spb.Report, error) {
+	opts := options.parseOptions()
+	if err := abi.ValidateReportFormatWithOptions(rawReport, opts); err != nil {
+		return nil, withKind(ErrReportSignature, fmt.Errorf("attestation report format error: %w", err))
+	}
+	report, err := abi.ReportToProtoWithOptions(rawReport, opts)
 	if err != nil {
-		return fmt.Errorf("could not interpret report bytes: %v", err)
+		return nil, withKind(ErrReportSignature, fmt.Errorf("could not interpret report bytes: %w", err))
+	}
+	return report, nil
+}
+
+// RawSnpAttestation verifies an attestation report as the device returned it: the report's raw
+// bytes, and the host's certificate table of GUID-keyed certificates, which may be empty. It fills
+// in missing certificates and verifies as SnpAttestation does. A malformed report fails with an
+// error that wraps the abi package's error, e.g., abi.ErrReportSize.
+func RawSnpAttestation(report []byte, certTable []byte, options *Options) error {
+	return RawSnpAttestationContext(context.Background(), report, certTable, options)
+}
+
+// RawSnpAttestationContext is like RawSnpAttestation, but abandons the downloads when ctx is done.
+func RawSnpAttestationContext(ctx context.Context, report []byte, certTable []byte, options *Options) error {
+	proto, err := rawReportProto(report, options)
+	if err != nil {
+		return err
+	}
+	chain := &spb.CertificateChain{}
+	if len(certTable) != 0 {
+		certs := new(abi.CertTable)
+		if err := certs.Unmarshal(certTable); err != nil {
+			return withKind(ErrChainVerification, fmt.Errorf("could not unmarshal SNP certificate table: %w", err))
+		}
+		chain = certs.Proto()
 	}
-	return SnpReport(report, options)
+	return SnpAttestationContext(ctx, &spb.Attestation{Report: proto, CertificateChain: chain}, options)
 }
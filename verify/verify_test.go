@@ -16,16 +16,27 @@ package verify
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	_ "embed"
 	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
 	"math/big"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -33,12 +44,14 @@ import (
 	"github.com/google/go-sev-guest/abi"
 	sg "github.com/google/go-sev-guest/client"
 	"github.com/google/go-sev-guest/kds"
+	cpb "github.com/google/go-sev-guest/proto/check"
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
 	test "github.com/google/go-sev-guest/testing"
 	testclient "github.com/google/go-sev-guest/testing/client"
 	"github.com/google/go-sev-guest/verify/testdata"
 	"github.com/google/go-sev-guest/verify/trust"
 	"github.com/google/logger"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -176,6 +189,158 @@ func TestSnpReportSignature(t *testing.T) {
 	}
 }
 
+func TestSnpProtoReportSignatureUnknownVersion(t *testing.T) {
+	signer, err := test.DefaultTestOnlyCertChain(test.GetProductName(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, abi.ReportSize)
+	raw[0x00] = abi.LatestReportVersion + 1
+	binary.LittleEndian.PutUint64(raw[0x08:0x10], abi.SnpPolicyToBytes(abi.SnpPolicy{}))
+	binary.LittleEndian.PutUint32(raw[0x34:0x38], abi.SignEcdsaP384Sha384)
+	// A field the newer report version defines in formerly reserved bytes.
+	raw[0x1F8] = 0xfe
+	r, s, err := signer.Sign(abi.SignedComponent(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := abi.SetSignature(r, s, raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := SnpReportSignature(raw, signer.Vcek); err == nil {
+		t.Error("SnpReportSignature(unknown version) = nil. Expected an error")
+	}
+	report, err := abi.ReportToProtoWithOptions(raw, &abi.ParseOptions{AllowUnknownVersion: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The report's Raw field does not make verification lenient; only the caller's options do.
+	if err := SnpProtoReportSignature(report, signer.Vcek); err == nil {
+		t.Error("SnpProtoReportSignature(unknown version) = nil. Expected an error")
+	}
+	opts := &abi.ParseOptions{AllowUnknownVersion: true}
+	if err := SnpProtoReportSignatureWithOptions(report, signer.Vcek, opts); err != nil {
+		t.Errorf("SnpProtoReportSignatureWithOptions(unknown version, %+v) = %v. Want nil", opts, err)
+	}
+	// Raw reports are parsed as strictly as the verification options say.
+	if _, err := rawReportProto(raw, &Options{}); !errors.Is(err, ErrReportSignature) {
+		t.Errorf("rawReportProto(unknown version, default options) = %v. Want %v", err, ErrReportSignature)
+	}
+	parsed, err := rawReportProto(raw, &Options{AllowUnknownVersion: true})
+	if err != nil {
+		t.Fatalf("rawReportProto(unknown version, AllowUnknownVersion) = %v. Want nil", err)
+	}
+	if err := SnpProtoReportSignatureWithOptions(parsed, signer.Vcek, opts); err != nil {
+		t.Errorf("SnpProtoReportSignatureWithOptions(parsed unknown version) = %v. Want nil", err)
+	}
+}
+
+func TestSnpReportSignatureUnsupportedAlgo(t *testing.T) {
+	signer, err := test.DefaultTestOnlyCertChain(test.GetProductName(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algo := range []uint32{2, 0xffffffff} {
+		report := test.CreateRawReport(&test.TestReportOptions{SignatureAlgo: algo})
+		raw := report[:abi.ReportSize]
+		r, s, err := signer.Sign(abi.SignedComponent(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := abi.SetSignature(r, s, raw); err != nil {
+			t.Fatal(err)
+		}
+		err = SnpReportSignature(raw, signer.Vcek)
+		var algoErr *abi.ErrUnsupportedSignatureAlgo
+		if !errors.As(err, &algoErr) || algoErr.Algo != algo {
+			t.Errorf("SnpReportSignature(algo %d) = %v. Want *abi.ErrUnsupportedSignatureAlgo{Algo: %d}", algo, err, algo)
+		}
+	}
+}
+
+// vcekSignedReport returns a raw report that the test signer's VCEK signs.
+func vcekSignedReport(t *testing.T) []byte {
+	t.Helper()
+	signMu.Do(initSigner)
+	resp := test.CreateRawReport(&test.TestReportOptions{ReportData: make([]byte, abi.ReportDataSize)})
+	raw := resp[:abi.ReportSize]
+	digest := sha512.Sum384(abi.SignedComponent(raw))
+	r, s, err := ecdsa.Sign(rand.New(rand.NewSource(0xc0de)), signer.Keys.Vcek, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := abi.SetSignature(r, s, raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestSnpReportSignatureECDSA(t *testing.T) {
+	signMu.Do(initSigner)
+	raw := vcekSignedReport(t)
+	insecureRandomness := rand.New(rand.NewSource(0xc0de))
+	if err := SnpReportSignatureECDSA(raw, &signer.Keys.Vcek.PublicKey); err != nil {
+		t.Errorf("SnpReportSignatureECDSA(VCEK key) = %v. Want nil", err)
+	}
+	var linkErr *ChainLinkErr
+	if err := SnpReportSignatureECDSA(raw, &signer.Keys.Vlek.PublicKey); !errors.As(err, &linkErr) || linkErr.Signee != "report" {
+		t.Errorf("SnpReportSignatureECDSA(another key) = %v. Want a *ChainLinkErr for the report", err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), insecureRandomness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SnpReportSignatureECDSA(raw, &p256Key.PublicKey); !errors.Is(err, ErrSignatureKeyType) {
+		t.Errorf("SnpReportSignatureECDSA(P-256 key) = %v. Want %v", err, ErrSignatureKeyType)
+	}
+	// The ASK certificate has an RSA key, which cannot verify an ECDSA signature.
+	if err := SnpReportSignature(raw, signer.Ask); !errors.Is(err, ErrSignatureKeyType) {
+		t.Errorf("SnpReportSignature(RSA certificate) = %v. Want %v", err, ErrSignatureKeyType)
+	}
+	badVersion := append([]byte{}, raw...)
+	badVersion[0x00] = abi.LatestReportVersion + 1
+	if err := SnpReportSignatureECDSA(badVersion, &signer.Keys.Vcek.PublicKey); err == nil || !strings.Contains(err.Error(), "attestation report format error") {
+		t.Errorf("SnpReportSignatureECDSA(unknown version) = %v. Want a report format error", err)
+	}
+}
+
+func TestSignedComponentDigest(t *testing.T) {
+	signer, err := test.DefaultTestOnlyCertChain(test.GetProductName(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, abi.ReportSize)
+	raw[0x00] = abi.ReportVersion3
+	binary.LittleEndian.PutUint64(raw[0x08:0x10], abi.SnpPolicyToBytes(abi.SnpPolicy{}))
+	binary.LittleEndian.PutUint32(raw[0x34:0x38], abi.SignEcdsaP384Sha384)
+	raw[0x50] = 0xaa
+	r, s, err := signer.Sign(abi.SignedComponent(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := abi.SetSignature(r, s, raw); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := abi.SignedComponentDigest(raw)
+	if err != nil {
+		t.Fatalf("abi.SignedComponentDigest(report) = _, %v. Want nil", err)
+	}
+	gotR, gotS, err := abi.ReportSignatureRS(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, ok := signer.Vcek.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("VCEK public key is %T, want *ecdsa.PublicKey", signer.Vcek.PublicKey)
+	}
+	if !ecdsa.Verify(pub, digest[:], gotR, gotS) {
+		t.Error("report signature does not verify against abi.SignedComponentDigest(report)")
+	}
+	if _, err := abi.SignedComponentDigest(raw[:abi.ReportSize-1]); !errors.Is(err, abi.ErrReportSize) {
+		t.Errorf("abi.SignedComponentDigest(short report) = _, %v, want %v", err, abi.ErrReportSize)
+	}
+}
+
 func TestKdsMetadataLogic(t *testing.T) {
 	signMu.Do(initSigner)
 	trust.ClearProductCertCache()
@@ -320,13 +485,175 @@ func TestKdsMetadataLogic(t *testing.T) {
 			options = &Options{Product: abi.DefaultSevProduct()}
 		}
 		vcekPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: newSigner.Vcek.Raw})
-		vcek, _, err := decodeCerts(&spb.CertificateChain{VcekCert: vcekPem, AskCert: newSigner.Ask.Raw, ArkCert: newSigner.Ark.Raw}, abi.VcekReportSigner, options)
+		vcek, _, err := decodeCerts(&spb.CertificateChain{VcekCert: vcekPem, AskCert: newSigner.Ask.Raw, ArkCert: newSigner.Ark.Raw}, abi.VcekReportSigner, options.Product, options)
 		if !test.Match(err, tc.wantErr) {
 			t.Errorf("%s: decodeCerts(...) = %+v, %v did not error as expected. Want %q", tc.name, vcek, err, tc.wantErr)
 		}
 	}
 }
 
+func TestDefaultRootEmbedded(t *testing.T) {
+	signMu.Do(initSigner)
+	embedded, err := trust.EmbeddedProductCerts(kds.Milan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask, ark, err := kds.ParseProductCertChain(testdata.MilanVcekBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, chain := range map[string]*spb.CertificateChain{
+		"KDS chain": {AskCert: ask, ArkCert: ark},
+		"no chain":  {},
+	} {
+		root, err := defaultRoot(chain, kds.Milan, abi.VcekReportSigner)
+		if err != nil {
+			t.Fatalf("defaultRoot(%s) = _, %v. Want nil", name, err)
+		}
+		if !root.ProductCerts.Ark.Equal(embedded.Ark) || !root.ProductCerts.Ask.Equal(embedded.Ask) {
+			t.Errorf("defaultRoot(%s) does not trust the embedded Milan ARK and ASK", name)
+		}
+	}
+	// A chain that is not AMD's is not trusted by default, however well-formed.
+	fake := &spb.CertificateChain{AskCert: signer.Ask.Raw, ArkCert: signer.Ark.Raw}
+	if _, err := defaultRoot(fake, kds.Milan, abi.VcekReportSigner); err == nil {
+		t.Error("defaultRoot(test-only chain) = _, nil. Want an error")
+	}
+
+	// The embedded certificates fill in a chain without downloading them.
+	attestation := &spb.Attestation{
+		Report:           &spb.Report{},
+		CertificateChain: &spb.CertificateChain{VcekCert: testdata.VcekBytes},
+		Product:          abi.DefaultSevProduct(),
+	}
+	if _, err := fillInAttestation(context.Background(), attestation, &Options{Getter: noNetwork{t}}); err != nil {
+		t.Fatalf("fillInAttestation() = %v. Want nil", err)
+	}
+	if chain := attestation.GetCertificateChain(); !bytes.Equal(chain.GetAskCert(), embedded.Ask.Raw) || !bytes.Equal(chain.GetArkCert(), embedded.Ark.Raw) {
+		t.Error("fillInAttestation() did not fill in the embedded Milan ASK and ARK")
+	}
+}
+
+func TestDefaultRootNotEmbedded(t *testing.T) {
+	// A self-made Genoa chain is consistent, but the trust package embeds no Genoa roots to check it
+	// against, so it is only trusted through TrustedRoots.
+	genoa, err := test.DefaultTestOnlyCertChain("Genoa-B1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := test.CreateRawReport(&test.TestReportOptions{ReportData: make([]byte, abi.ReportDataSize)})
+	raw := resp[:abi.ReportSize]
+	digest := sha512.Sum384(abi.SignedComponent(raw))
+	r, s, err := ecdsa.Sign(rand.New(rand.NewSource(0xc0de)), genoa.Keys.Vcek, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := abi.SetSignature(r, s, raw); err != nil {
+		t.Fatal(err)
+	}
+	report, err := abi.ReportToProto(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	product, err := kds.ParseProductName("Genoa-B1", abi.VcekReportSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newAttestation := func() *spb.Attestation {
+		return &spb.Attestation{
+			Report:           report,
+			CertificateChain: &spb.CertificateChain{VcekCert: genoa.Vcek.Raw, AskCert: genoa.Ask.Raw, ArkCert: genoa.Ark.Raw},
+		}
+	}
+	for name, options := range map[string]*Options{
+		"fetching":     {Product: product, Getter: noNetwork{t}},
+		"not fetching": {Product: product, DisableCertFetching: true},
+	} {
+		err := SnpAttestation(newAttestation(), options)
+		if !errors.Is(err, trust.ErrNoEmbeddedRoots) || !errors.Is(err, ErrChainVerification) || !strings.Contains(err.Error(), "set Options.TrustedRoots") {
+			t.Errorf("SnpAttestation(self-made Genoa chain, %s) = %v. Want an ErrChainVerification wrapping %v", name, err, trust.ErrNoEmbeddedRoots)
+		}
+	}
+	root := trust.AMDRootCertsProduct("Genoa")
+	root.ProductCerts = &trust.ProductCerts{Ark: genoa.Ark, Ask: genoa.Ask}
+	options := &Options{Product: product, Getter: noNetwork{t}, TrustedRoots: map[string][]*trust.AMDRootCerts{"Genoa": {root}}}
+	if err := SnpAttestation(newAttestation(), options); err != nil {
+		t.Errorf("SnpAttestation(self-made Genoa chain, TrustedRoots) = %v. Want nil", err)
+	}
+}
+
+func TestHistoricalVerification(t *testing.T) {
+	signMu.Do(initSigner)
+	now := time.Now()
+	years := func(n int) time.Time { return now.AddDate(n, 0, 0) }
+	// Each chain is valid when its attestation was collected two years ago, and has a certificate
+	// that has expired since.
+	tcs := []struct {
+		name         string
+		rootsCreated time.Time
+		vcekCreated  time.Time
+		expired      time.Time
+	}{
+		// The ARK and ASK are valid for 25 years.
+		{name: "expired ASK", rootsCreated: years(-26), vcekCreated: years(-3), expired: years(-1)},
+		// The VCEK is valid for 7 years.
+		{name: "expired VCEK", rootsCreated: years(-8), vcekCreated: years(-8), expired: years(-1)},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &test.AmdSignerBuilder{
+				Keys:            signer.Keys,
+				ArkCreationTime: tc.rootsCreated,
+				AskCreationTime: tc.rootsCreated,
+				// The ASVK is not in the chain.
+				AsvkCreationTime: now,
+				VcekCreationTime: tc.vcekCreated,
+			}
+			s, err := b.TestOnlyCertChain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := trust.AMDRootCertsProduct(test.GetProductLine())
+			root.ProductCerts = &trust.ProductCerts{Ark: s.Ark, Ask: s.Ask}
+			chain := &spb.CertificateChain{VcekCert: s.Vcek.Raw, AskCert: s.Ask.Raw, ArkCert: s.Ark.Raw}
+			verifyAt := func(opts *Options) error {
+				opts.TrustedRoots = map[string][]*trust.AMDRootCerts{test.GetProductLine(): {root}}
+				opts.Product = abi.DefaultSevProduct()
+				_, _, err := decodeCerts(chain, abi.VcekReportSigner, opts.Product, opts)
+				return err
+			}
+			if err := verifyAt(&Options{}); err == nil || !strings.Contains(err.Error(), "expired") {
+				t.Errorf("decodeCerts(_, now) = _, _, %v. Want an expired certificate error", err)
+			}
+			collected := years(-2)
+			if err := verifyAt(&Options{Now: collected}); err != nil {
+				t.Errorf("decodeCerts(_, Now: collection time) = _, _, %v. Want nil", err)
+			}
+			if err := verifyAt(&Options{Clock: func() time.Time { return collected }}); err != nil {
+				t.Errorf("decodeCerts(_, Clock: collection time) = _, _, %v. Want nil", err)
+			}
+			// A certificate that had already expired when the attestation was collected still fails.
+			if err := verifyAt(&Options{Now: tc.expired}); err == nil || !strings.Contains(err.Error(), "expired") {
+				t.Errorf("decodeCerts(_, Now: after expiry) = _, _, %v. Want an expired certificate error", err)
+			}
+		})
+	}
+
+	// A CRL that was current at collection is current as of the collection time.
+	crl := testCRL(t, years(-2).Add(-time.Hour), years(-2).AddDate(0, 0, 7))
+	getter := test.SimpleGetter(map[string][]byte{kds.CrlURL(kds.Product(test.GetProductLine())): crl})
+	for _, tc := range []struct {
+		at      time.Time
+		wantErr error
+	}{{at: now, wantErr: ErrCRLStale}, {at: years(-2)}} {
+		root := trust.AMDRootCertsProduct(test.GetProductLine())
+		root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: signer.Ask}
+		if err := VcekNotRevoked(root, signer.Vcek, &Options{Getter: getter, Now: tc.at}); !errors.Is(err, tc.wantErr) {
+			t.Errorf("VcekNotRevoked(_, Now: %v) = %v. Want %v", tc.at, err, tc.wantErr)
+		}
+	}
+}
+
 func TestCRLRootValidity(t *testing.T) {
 	// Tests that the CRL is signed by the ARK.
 	signMu.Do(initSigner)
@@ -406,6 +733,103 @@ func TestCRLRootValidity(t *testing.T) {
 	}
 }
 
+func TestCRLIssuerMismatch(t *testing.T) {
+	signMu.Do(initSigner)
+	productLine := test.GetProductLine()
+	otherName := "Genoa-B1"
+	if productLine == "Genoa" {
+		otherName = "Milan-B1"
+	}
+	other, err := test.DefaultTestOnlyCertChain(otherName, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherLine := kds.ProductLineOfProductName(otherName)
+	insecureRandomness := rand.New(rand.NewSource(0xc0de))
+	crlFrom := func(s *test.AmdSigner) []byte {
+		crl, err := x509.CreateRevocationList(insecureRandomness, &x509.RevocationList{
+			SignatureAlgorithm: x509.SHA384WithRSAPSS,
+			Number:             big.NewInt(1),
+		}, s.Ark, s.Keys.Ark)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crl
+	}
+	// The ASK points at the other product line's CRL, but the CRL is found by product line.
+	ask := *signer.Ask
+	ask.CRLDistributionPoints = []string{kds.CrlURL(kds.Product(otherLine))}
+	root := trust.AMDRootCertsProduct(productLine)
+	root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: &ask}
+
+	wrongList := test.SimpleGetter(map[string][]byte{
+		kds.CrlURL(kds.Product(productLine)): crlFrom(other),
+		kds.CrlURL(kds.Product(otherLine)):   crlFrom(signer),
+	})
+	err = VcekNotRevoked(root, signer.Vcek, &Options{Getter: wrongList})
+	if !errors.Is(err, ErrCRLIssuerMismatch) {
+		t.Fatalf("VcekNotRevoked(%s CRL for a %s VCEK) = %v. Want %v", otherLine, productLine, err, ErrCRLIssuerMismatch)
+	}
+	var unavailable CRLUnavailableErr
+	if errors.As(err, &unavailable) {
+		t.Errorf("VcekNotRevoked(%s CRL for a %s VCEK) = %v. Want an error that is not a CRLUnavailableErr", otherLine, productLine, err)
+	}
+	if root.CRL != nil {
+		t.Errorf("VcekNotRevoked(%s CRL for a %s VCEK) kept the CRL", otherLine, productLine)
+	}
+
+	rightList := test.SimpleGetter(map[string][]byte{kds.CrlURL(kds.Product(productLine)): crlFrom(signer)})
+	if err := VcekNotRevoked(root, signer.Vcek, &Options{Getter: rightList}); err != nil {
+		t.Fatalf("VcekNotRevoked(%s CRL) = %v. Want nil", productLine, err)
+	}
+	if err := CheckCRLIssuer(root.CRL, root); err != nil {
+		t.Errorf("CheckCRLIssuer(%s CRL, %s root) = %v. Want nil", productLine, productLine, err)
+	}
+}
+
+func TestCRLRevocationStatus(t *testing.T) {
+	signMu.Do(initSigner)
+	productLine := test.GetProductLine()
+	now := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	thisUpdate := now.Add(-24 * time.Hour)
+	nextUpdate := now.Add(6 * 24 * time.Hour)
+	rootWith := func(crl []byte) (*trust.AMDRootCerts, *Options) {
+		root := trust.AMDRootCertsProduct(productLine)
+		root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: signer.Ask}
+		getter := test.SimpleGetter(map[string][]byte{kds.CrlURL(kds.Product(productLine)): crl})
+		return root, &Options{Getter: getter, Now: now}
+	}
+
+	root, opts := rootWith(testCRL(t, thisUpdate, nextUpdate, big.NewInt(0x5ca1ab1e)))
+	crl, err := vcekNotRevoked(context.Background(), root, signer.Vcek, opts)
+	if err != nil {
+		t.Fatalf("vcekNotRevoked(CRL that revokes another serial) = _, %v. Want nil", err)
+	}
+	status := crlStatus(crl)
+	if !status.ThisUpdate.Equal(thisUpdate) || !status.NextUpdate.Equal(nextUpdate) || status.Number.Cmp(big.NewInt(1)) != 0 || status.Revoked != 1 {
+		t.Errorf("crlStatus() = %+v. Want ThisUpdate %v, NextUpdate %v, Number 1, and 1 revocation", status, thisUpdate, nextUpdate)
+	}
+
+	root, opts = rootWith(testCRL(t, thisUpdate, nextUpdate, signer.Vcek.SerialNumber))
+	if err := VcekNotRevoked(root, signer.Vcek, opts); !errors.Is(err, ErrRevoked) || !strings.Contains(err.Error(), "VCEK was revoked") {
+		t.Errorf("VcekNotRevoked(CRL that revokes the VCEK) = %v. Want a VCEK error wrapping %v", err, ErrRevoked)
+	}
+
+	root, opts = rootWith(testCRL(t, thisUpdate, nextUpdate, signer.Ask.SerialNumber))
+	if err := VcekNotRevoked(root, signer.Vcek, opts); !errors.Is(err, ErrRevoked) || !strings.Contains(err.Error(), "ASK was revoked") {
+		t.Errorf("VcekNotRevoked(CRL that revokes the ASK) = %v. Want an ASK error wrapping %v", err, ErrRevoked)
+	}
+
+	root, opts = rootWith(testCRL(t, thisUpdate, nextUpdate))
+	opts.Now = nextUpdate
+	if err := VcekNotRevoked(root, signer.Vcek, opts); !errors.Is(err, ErrCRLStale) {
+		t.Errorf("VcekNotRevoked(CRL at its NextUpdate) = %v. Want %v", err, ErrCRLStale)
+	}
+	if root.CRL != nil {
+		t.Error("VcekNotRevoked(CRL at its NextUpdate) kept the CRL")
+	}
+}
+
 // TestOpenGetExtendedReportVerifyClose tests the SnpAttestation function for the deprecated ioctl
 // API.
 func TestOpenGetExtendedReportVerifyClose(t *testing.T) {
@@ -525,127 +949,824 @@ func TestOpenGetExtendedReportVerifyClose(t *testing.T) {
 	}
 }
 
-// TestGetQuoteProviderVerify tests the SnpAttestation function for the configfs-tsm report API.
-func TestGetQuoteProviderVerify(t *testing.T) {
+func TestMissingVlekCert(t *testing.T) {
+	if !sg.UseDefaultSevGuest() {
+		t.Skip("VLEK-signed reports are only available from the fake device")
+	}
 	trust.ClearProductCertCache()
 	tests := test.TestCases()
-	qp, goodRoots, badRoots, kds := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{Now: time.Now()}, t)
-	// Trust the test device's root certs.
-	options := &Options{
-		TrustedRoots:        goodRoots,
-		Getter:              kds,
-		Product:             test.GetProduct(t),
-		DisableCertFetching: *requireCache && !sg.UseDefaultSevGuest(),
-	}
-	badOptions := &Options{TrustedRoots: badRoots, Getter: kds, Product: test.GetProduct(t)}
+	qp, goodRoots, _, kds := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{Now: time.Now()}, t)
 	for _, tc := range tests {
-		// configfs-tsm doesn't support the key choice parameter for getting an attestation report, and
-		// it doesn't return firmware error codes.
-		if testclient.SkipUnmockableTestCase(&tc) || tc.EK == test.KeyChoiceVlek {
-			t.Run(tc.Name, func(t *testing.T) { t.Skip() })
+		if tc.EK != test.KeyChoiceVlek || tc.WantErr != "" {
 			continue
 		}
-		t.Run(tc.Name+"_", func(t *testing.T) {
-			reportcerts, err := qp.GetRawQuote(tc.Input)
-			ereport, _ := abi.ReportCertsToProto(reportcerts)
-			if tc.FwErr != abi.Success {
-				if err == nil {
-					t.Fatalf("(d, %v) = %v. Unexpected success given firmware error: %v", tc.Input, ereport, tc.FwErr)
-				}
-			} else if !test.Match(err, tc.WantErr) {
-				t.Fatalf("(d, %v) = %v, %v. Want err: %v", tc.Input, ereport, err, tc.WantErr)
+		t.Run(tc.Name, func(t *testing.T) {
+			attestation, err := sg.GetQuoteProto(qp, tc.Input)
+			if err != nil {
+				t.Fatalf("GetQuoteProto(qp, %v) = _, %v. Want nil", tc.Input, err)
 			}
-			if tc.WantErr == "" {
-				var wantAttestationErr string
-				if err := SnpAttestation(ereport, options); !test.Match(err, wantAttestationErr) {
-					t.Errorf("SnpAttestation(%v) = %v. Want err: %q", ereport, err, wantAttestationErr)
-				}
-
-				wantBad := "error verifying VCEK certificate"
-				if err := SnpAttestation(ereport, badOptions); !test.Match(err, wantBad) {
-					t.Errorf("SnpAttestation(_) bad root test errored unexpectedly: %v, want %s",
-						err, wantBad)
-				}
+			// Ship only the VCEK certificate.
+			attestation.CertificateChain.VlekCert = nil
+			if len(attestation.CertificateChain.VcekCert) == 0 {
+				t.Fatal("test device did not provide a VCEK certificate")
+			}
+			options := &Options{
+				TrustedRoots:        goodRoots,
+				Getter:              kds,
+				Product:             test.GetProduct(t),
+				DisableCertFetching: true,
+			}
+			err = SnpAttestation(attestation, options)
+			if !errors.Is(err, ErrMissingVlek) || !strings.Contains(err.Error(), "only has a VCEK certificate") {
+				t.Errorf("SnpAttestation(%v) = %v. Want %v naming the VCEK certificate", attestation, err, ErrMissingVlek)
 			}
 		})
 	}
 }
 
-func TestGetQuoteProviderVerifyProductNameSteppingMismatch(t *testing.T) {
-	if !sg.UseDefaultSevGuest() {
-		t.Skip("Cannot override true cpuid in hardware for negative testing")
-		return
-	}
-	trust.ClearProductCertCache()
-	tests := test.TestCases()
-	signerMilan0, err := test.DefaultTestOnlyCertChain("Milan-B0", time.Now())
+func TestCheckCertReport(t *testing.T) {
+	// The test signer's VCEK has a masked hwID, unlike this one.
+	vcek, err := trust.ParseCert(testdata.VcekBytes)
 	if err != nil {
 		t.Fatal(err)
 	}
-	qp, goodRoots, _, kds := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{
-		Now:    time.Now(),
-		Signer: signerMilan0,
-		// Mismatch cpuid product with certs.
-		Product: &spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(1)},
-	}, t)
-	tc := tests[0]
-	options := &Options{
-		TrustedRoots:        goodRoots,
-		Getter:              kds,
-		DisableCertFetching: *requireCache && !sg.UseDefaultSevGuest(),
-	}
-	withProduct := func(p *spb.SevProduct) *Options {
-		op := *options
-		op.Product = p
-		return &op
-	}
-	reportcerts, err := qp.GetRawQuote(tc.Input)
+	exts, err := kds.VcekCertificateExtensions(vcek)
 	if err != nil {
 		t.Fatal(err)
 	}
-	ereport, _ := abi.ReportCertsToProto(reportcerts)
-
-	ops := []struct {
-		name          string
-		options       *Options
-		workaround115 bool
-		wantErr       string
+	otherChip := bytes.Repeat([]byte{0xee}, abi.ChipIDSize)
+	tcs := []struct {
+		name       string
+		report     *spb.Report
+		opts       *Options
+		wantErr    any
+		wantMasked bool
 	}{
 		{
-			name:          "no product expectation with workaround",
-			options:       withProduct(nil),
-			workaround115: true,
+			name:   "consistent",
+			report: &spb.Report{ChipId: exts.HWID, ReportedTcb: uint64(exts.TCBVersion)},
+			opts:   &Options{},
 		},
 		{
-			name:    "no product expectation without workaround",
-			options: withProduct(nil),
-			wantErr: "0x0 is not 0x1", // decodeCerts error
+			name:    "other chip",
+			report:  &spb.Report{ChipId: otherChip, ReportedTcb: uint64(exts.TCBVersion)},
+			opts:    &Options{},
+			wantErr: &HWIDMismatchErr{},
 		},
 		{
-			name:          "Milan expectation without stepping, with workaround",
-			options:       withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN}),
-			workaround115: true,
+			name:   "other chip unchecked",
+			report: &spb.Report{ChipId: otherChip, ReportedTcb: uint64(exts.TCBVersion)},
+			opts:   &Options{DisableHWIDCheck: true},
 		},
 		{
-			name:    "Milan expectation without stepping without workaround",
-			options: withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN}),
-			wantErr: "0x0 is not 0x1", // decodeCerts error
+			name:       "masked chip",
+			report:     &spb.Report{ChipId: make([]byte, abi.ChipIDSize), ReportedTcb: uint64(exts.TCBVersion)},
+			opts:       &Options{},
+			wantMasked: true,
 		},
 		{
-			name:    "Milan-B1 expectation without workaround",
-			options: withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(1)}),
-			wantErr: "0x0 is not 0x1",
+			name:    "other TCB",
+			report:  &spb.Report{ChipId: exts.HWID, ReportedTcb: uint64(exts.TCBVersion) + 1},
+			opts:    &Options{},
+			wantErr: &TCBMismatchErr{},
 		},
 		{
-			name:          "Milan-B0 expectation with workaround",
-			options:       withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(0)}),
-			workaround115: true,
-			// an explicit expectation should not step this check against CPUID.
-			wantErr: "expected product stepping 0, got 1",
+			name:   "other TCB unchecked",
+			report: &spb.Report{ChipId: exts.HWID, ReportedTcb: uint64(exts.TCBVersion) + 1},
+			opts:   &Options{DisableTCBCheck: true},
 		},
-		{
-			name:    "Milan-B0 expectation without workaround",
-			options: withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(0)}),
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			details := &Details{}
+			err := checkCertReport(tc.report, vcek, abi.VcekReportSigner, tc.opts, details)
+			switch want := tc.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("checkCertReport() = %v. Want nil", err)
+				}
+			case *HWIDMismatchErr:
+				if !errors.As(err, &want) || !bytes.Equal(want.ChipID, otherChip) {
+					t.Errorf("checkCertReport() = %v. Want a *HWIDMismatchErr for CHIP_ID %x", err, otherChip)
+				}
+			case *TCBMismatchErr:
+				if !errors.As(err, &want) || want.CertTCB != exts.TCBVersion {
+					t.Errorf("checkCertReport() = %v. Want a *TCBMismatchErr for certificate TCB %x", err, exts.TCBVersion)
+				}
+			}
+			if details.ChipIDMasked != tc.wantMasked {
+				t.Errorf("checkCertReport() ChipIDMasked = %v. Want %v", details.ChipIDMasked, tc.wantMasked)
+			}
+		})
+	}
+
+	milan := &spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN}
+	genoa := &spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_GENOA}
+	var productErr *ProductMismatchErr
+	if err := checkProductName(milan, genoa, abi.VcekReportSigner); !errors.As(err, &productErr) || productErr.Got != milan {
+		t.Errorf("checkProductName(Milan, Genoa) = %v. Want a *ProductMismatchErr", err)
+	}
+}
+
+func TestSignerMismatch(t *testing.T) {
+	signMu.Do(initSigner)
+	if signer.Keys.Vlek == nil || signer.Vlek == nil {
+		t.Skip("the test signer has no VLEK")
+	}
+	// SIGNER_INFO names the VCEK, but the VLEK signs the report.
+	resp := test.CreateRawReport(&test.TestReportOptions{ReportData: make([]byte, abi.ReportDataSize)})
+	raw := resp[:abi.ReportSize]
+	digest := sha512.Sum384(abi.SignedComponent(raw))
+	r, s, err := ecdsa.Sign(rand.New(rand.NewSource(0xc0de)), signer.Keys.Vlek, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := abi.SetSignature(r, s, raw); err != nil {
+		t.Fatal(err)
+	}
+	report, err := abi.ReportToProto(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigErr := SnpProtoReportSignature(report, signer.Vcek)
+	if sigErr == nil {
+		t.Fatal("SnpProtoReportSignature(VLEK-signed report, VCEK) = nil. Want an error")
+	}
+	both := &spb.CertificateChain{VcekCert: signer.Vcek.Raw, VlekCert: signer.Vlek.Raw}
+	err = signerMismatch(report, both, abi.VcekReportSigner, nil, sigErr)
+	if !errors.Is(err, ErrSignerMismatch) || !strings.Contains(err.Error(), "verifies under the VLEK certificate") {
+		t.Errorf("signerMismatch(VLEK-signed report named VCEK) = %v. Want %v naming the VLEK", err, ErrSignerMismatch)
+	}
+	// Without the VLEK certificate there is nothing to name, so the signature error stands.
+	if err := signerMismatch(report, &spb.CertificateChain{VcekCert: signer.Vcek.Raw}, abi.VcekReportSigner, nil, sigErr); err != sigErr {
+		t.Errorf("signerMismatch(no VLEK certificate) = %v. Want %v", err, sigErr)
+	}
+
+	if err := missingCertErr(&spb.CertificateChain{VlekCert: signer.Vlek.Raw}, abi.VcekReportSigner); !strings.Contains(err.Error(), "only has a VLEK certificate") {
+		t.Errorf("missingCertErr(VLEK only, VCEK) = %v. Want an error naming the VLEK certificate", err)
+	}
+}
+
+func TestChainCache(t *testing.T) {
+	signMu.Do(initSigner)
+	productLine := test.GetProductLine()
+	raw := vcekSignedReport(t)
+	report, err := abi.ReportToProto(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation := &spb.Attestation{
+		Report:           report,
+		CertificateChain: &spb.CertificateChain{VcekCert: signer.Vcek.Raw, AskCert: signer.Ask.Raw, ArkCert: signer.Ark.Raw},
+	}
+	now := time.Now()
+	root := trust.AMDRootCertsProduct(productLine)
+	root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: signer.Ask}
+	cache := &ChainCache{TTL: 10 * time.Minute}
+	var mu sync.Mutex
+	clock := now
+	options := &Options{
+		TrustedRoots:     map[string][]*trust.AMDRootCerts{productLine: {root}},
+		Product:          test.GetProduct(t),
+		CheckRevocations: true,
+		Getter:           test.SimpleGetter(map[string][]byte{kds.CrlURL(kds.Product(productLine)): testCRL(t, now, now.Add(time.Hour))}),
+		Clock: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return clock
+		},
+		ChainCache: cache,
+	}
+	verify := func(wantCached bool) {
+		t.Helper()
+		details, err := SnpAttestationDetails(attestation, options)
+		if err != nil {
+			t.Fatalf("SnpAttestationDetails() = _, %v. Want nil", err)
+		}
+		if details.ChainCached != wantCached {
+			t.Errorf("SnpAttestationDetails() ChainCached = %v. Want %v", details.ChainCached, wantCached)
+		}
+	}
+	wantProduct := proto.Clone(options.Product)
+	verify(false)
+	verify(true)
+	if got, want := cache.Stats(), (ChainCacheStats{Hits: 1, Misses: 1}); got != want {
+		t.Errorf("Stats() = %+v. Want %+v", got, want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := SnpAttestation(attestation, options); err != nil {
+				t.Errorf("concurrent SnpAttestation() = %v. Want nil", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := cache.Stats(), (ChainCacheStats{Hits: 9, Misses: 1}); got != want {
+		t.Errorf("Stats() after concurrent verifications = %+v. Want %+v", got, want)
+	}
+	// Verifications that share the options do not write to them.
+	if !proto.Equal(options.Product, wantProduct) {
+		t.Errorf("Options.Product after verifications = %v. Want %v", options.Product, wantProduct)
+	}
+
+	// A cached chain is only remembered for the TTL.
+	mu.Lock()
+	clock = now.Add(11 * time.Minute)
+	mu.Unlock()
+	verify(false)
+
+	// Once the CRL's next update revokes the VCEK, the cached chains of the product line are dropped.
+	mu.Lock()
+	clock = now.Add(2 * time.Hour)
+	mu.Unlock()
+	options.Getter = test.SimpleGetter(map[string][]byte{kds.CrlURL(kds.Product(productLine)): testCRL(t, clock, clock.Add(time.Hour), signer.Vcek.SerialNumber)})
+	if err := SnpAttestation(attestation, options); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("SnpAttestation(revoked VCEK) = %v. Want %v", err, ErrRevoked)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len() after a revocation = %d. Want 0", cache.Len())
+	}
+}
+
+func TestChainPolicy(t *testing.T) {
+	signMu.Do(initSigner)
+	productLine := test.GetProductLine()
+	if errs := (&ChainPolicy{}).deviations(productLine, signer.Ark, signer.Ask, signer.Vcek, abi.VcekReportSigner); len(errs) != 0 {
+		t.Errorf("deviations(fake chain) = %v. Want none", errs)
+	}
+	amd, err := trust.EmbeddedProductCerts(kds.Milan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vcek, err := x509.ParseCertificate(testdata.VcekBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := (&ChainPolicy{}).deviations("Milan", amd.Ark, amd.Ask, vcek, abi.VcekReportSigner); len(errs) != 0 {
+		t.Errorf("deviations(AMD's Milan chain) = %v. Want none", errs)
+	}
+
+	weakKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0xc0de)), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.New(rand.NewSource(0xc0de)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcs := []struct {
+		name    string
+		ark     func(*x509.Certificate)
+		ask     func(*x509.Certificate)
+		vcek    func(*x509.Certificate)
+		role    string
+		want    error
+		relaxed *ChainPolicy
+	}{
+		{
+			name:    "ASK signs with SHA-256",
+			ask:     func(c *x509.Certificate) { c.SignatureAlgorithm = x509.SHA256WithRSA },
+			role:    "ASK",
+			want:    ErrPolicySignatureAlgorithm,
+			relaxed: &ChainPolicy{SignatureAlgorithms: []x509.SignatureAlgorithm{x509.SHA384WithRSAPSS, x509.SHA256WithRSA}},
+		},
+		{
+			name:    "ASK has a 1024-bit key",
+			ask:     func(c *x509.Certificate) { c.PublicKey = &weakKey.PublicKey },
+			role:    "ASK",
+			want:    ErrPolicyPublicKey,
+			relaxed: &ChainPolicy{MinRSABits: 1024},
+		},
+		{
+			name: "ARK is issued by another",
+			ark:  func(c *x509.Certificate) { c.RawIssuer = signer.Vcek.RawIssuer },
+			role: "ARK",
+			want: ErrPolicyNotSelfSigned,
+		},
+		{
+			name:    "ASK cannot sign certificates",
+			ask:     func(c *x509.Certificate) { c.KeyUsage = x509.KeyUsageDigitalSignature },
+			role:    "ASK",
+			want:    ErrPolicyKeyUsage,
+			relaxed: &ChainPolicy{DisableKeyUsageCheck: true},
+		},
+		{
+			name:    "ASK of another product line",
+			ask:     func(c *x509.Certificate) { c.Subject.CommonName = "SEV-Unknown" },
+			role:    "ASK",
+			want:    ErrPolicyCommonName,
+			relaxed: &ChainPolicy{DisableCommonNameCheck: true},
+		},
+		{
+			name:    "VCEK on P-256",
+			vcek:    func(c *x509.Certificate) { c.PublicKey = &p256Key.PublicKey },
+			role:    "VCEK",
+			want:    ErrPolicyPublicKey,
+			relaxed: &ChainPolicy{EndorsementKeyCurves: []string{"P-384", "P-256"}},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ark, ask, vcek := *signer.Ark, *signer.Ask, *signer.Vcek
+			for _, mod := range []struct {
+				cert *x509.Certificate
+				f    func(*x509.Certificate)
+			}{{&ark, tc.ark}, {&ask, tc.ask}, {&vcek, tc.vcek}} {
+				if mod.f != nil {
+					mod.f(mod.cert)
+				}
+			}
+			var policyErr *ChainPolicyErr
+			err := (&ChainPolicy{}).check(productLine, &ark, &ask, &vcek, abi.VcekReportSigner)
+			if !errors.As(err, &policyErr) || policyErr.Role != tc.role || !errors.Is(err, tc.want) {
+				t.Errorf("check() = %v. Want a *ChainPolicyErr for the %s wrapping %v", err, tc.role, tc.want)
+			}
+			if err := (&ChainPolicy{Permissive: true}).check(productLine, &ark, &ask, &vcek, abi.VcekReportSigner); err != nil {
+				t.Errorf("check(Permissive) = %v. Want nil", err)
+			}
+			if tc.relaxed != nil {
+				if err := tc.relaxed.check(productLine, &ark, &ask, &vcek, abi.VcekReportSigner); err != nil {
+					t.Errorf("check(%+v) = %v. Want nil", tc.relaxed, err)
+				}
+			}
+		})
+	}
+
+	// The policy applies to a chain whose signatures all verify.
+	ask := *signer.Ask
+	ask.Subject.CommonName = "SEV-Unknown"
+	root := trust.AMDRootCertsProduct(productLine)
+	root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: &ask}
+	var policyErr *ChainPolicyErr
+	if err := validateKDSCertificateProductSpecifics(root, signer.Vcek, abi.VcekReportSigner, &Options{}); !errors.As(err, &policyErr) || policyErr.Role != "ASK" {
+		t.Errorf("validateKDSCertificateProductSpecifics(ASK of another product line) = %v. Want a *ChainPolicyErr for the ASK", err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	signMu.Do(initSigner)
+	productLine := test.GetProductLine()
+	now := time.Now()
+	rootOf := func(ask *x509.Certificate) map[string][]*trust.AMDRootCerts {
+		root := trust.AMDRootCertsProduct(productLine)
+		root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: ask}
+		return map[string][]*trust.AMDRootCerts{productLine: {root}}
+	}
+	fullChain := func() *spb.CertificateChain {
+		return &spb.CertificateChain{VcekCert: signer.Vcek.Raw, AskCert: signer.Ask.Raw, ArkCert: signer.Ark.Raw}
+	}
+	reportProto := func(mod func(*spb.Report)) *spb.Report {
+		report, err := abi.ReportToProto(vcekSignedReport(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mod != nil {
+			mod(report)
+		}
+		return report
+	}
+	otherChip := func(r *spb.Report) { r.ChipId[0] = 1 }
+	vcekURL, err := kds.VCEKCertURLForReportProto(kds.Product(productLine), reportProto(otherChip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcs := []struct {
+		name        string
+		attestation *spb.Attestation
+		options     *Options
+		want        error
+	}{
+		{
+			name:        "unknown product",
+			attestation: &spb.Attestation{Report: reportProto(nil)},
+			options:     &Options{Product: &spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_UNKNOWN}, Getter: noNetwork{t}},
+			want:        ErrUnknownProduct,
+		},
+		{
+			name:        "VCEK cannot be fetched",
+			attestation: &spb.Attestation{Report: reportProto(func(r *spb.Report) { r.ChipId[0] = 1 })},
+			options:     &Options{Product: test.GetProduct(t), Getter: test.SimpleGetter(nil)},
+			want:        ErrCertFetch,
+		},
+		{
+			name:        "CRL cannot be fetched",
+			attestation: &spb.Attestation{Report: reportProto(nil), CertificateChain: fullChain()},
+			options: &Options{TrustedRoots: rootOf(signer.Ask), Product: test.GetProduct(t),
+				CheckRevocations: true, Getter: test.SimpleGetter(nil)},
+			want: ErrCertFetch,
+		},
+		{
+			name:        "no CRL source",
+			attestation: &spb.Attestation{Report: reportProto(nil), CertificateChain: fullChain()},
+			options: &Options{TrustedRoots: rootOf(signer.Ask), Product: test.GetProduct(t),
+				CheckRevocations: true, DisableCertFetching: true, Getter: noNetwork{t}},
+			want: ErrCertFetch,
+		},
+		{
+			name:        "malformed root pins",
+			attestation: &spb.Attestation{Report: reportProto(nil), CertificateChain: fullChain()},
+			options: &Options{TrustedRoots: rootOf(signer.Ask), Product: test.GetProduct(t), Getter: noNetwork{t},
+				RootPins: map[string]*RootPins{productLine: {ARK: []string{"not hex"}}}},
+			want: ErrChainVerification,
+		},
+		{
+			name: "malformed fetched VCEK",
+			attestation: &spb.Attestation{Report: reportProto(otherChip),
+				CertificateChain: &spb.CertificateChain{AskCert: signer.Ask.Raw, ArkCert: signer.Ark.Raw}},
+			options: &Options{TrustedRoots: rootOf(signer.Ask), Product: test.GetProduct(t),
+				Getter: test.SimpleGetter(map[string][]byte{vcekURL: []byte("not a certificate")})},
+			want: ErrChainVerification,
+		},
+		{
+			name:        "ASK does not sign the VCEK",
+			attestation: &spb.Attestation{Report: reportProto(nil), CertificateChain: fullChain()},
+			options:     &Options{TrustedRoots: rootOf(signer.Ark), Product: test.GetProduct(t), Getter: noNetwork{t}},
+			want:        ErrChainVerification,
+		},
+		{
+			name:        "tampered report",
+			attestation: &spb.Attestation{Report: reportProto(func(r *spb.Report) { r.ReportData[0] ^= 0xff }), CertificateChain: fullChain()},
+			options:     &Options{TrustedRoots: rootOf(signer.Ask), Product: test.GetProduct(t), Getter: noNetwork{t}},
+			want:        ErrReportSignature,
+		},
+		{
+			name:        "revoked VCEK",
+			attestation: &spb.Attestation{Report: reportProto(nil), CertificateChain: fullChain()},
+			options: &Options{TrustedRoots: rootOf(signer.Ask), Product: test.GetProduct(t), CheckRevocations: true,
+				Getter: test.SimpleGetter(map[string][]byte{kds.CrlURL(kds.Product(productLine)): testCRL(t, now, now.Add(time.Hour), signer.Vcek.SerialNumber)})},
+			want: ErrRevoked,
+		},
+		{
+			name:        "VCEK of another TCB",
+			attestation: &spb.Attestation{Report: reportProto(func(r *spb.Report) { r.ReportedTcb ^= 1 }), CertificateChain: fullChain()},
+			options:     &Options{TrustedRoots: rootOf(signer.Ask), Product: test.GetProduct(t), Getter: noNetwork{t}},
+			want:        ErrCertReportMismatch,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := SnpAttestation(tc.attestation, tc.options)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("SnpAttestation() = %v. Want an error of kind %v", err, tc.want)
+			}
+			for _, kind := range failureKinds {
+				if kind != tc.want && errors.Is(err, kind) {
+					t.Errorf("SnpAttestation() = %v is also of kind %v", err, kind)
+				}
+			}
+		})
+	}
+
+	if err := RawSnpReport(make([]byte, abi.ReportSize-1), &Options{Getter: noNetwork{t}}); !errors.Is(err, ErrReportSignature) {
+		t.Errorf("RawSnpReport(short report) = %v. Want an error of kind %v", err, ErrReportSignature)
+	}
+	// The kind is kept through the error's causes, so the cause is still found.
+	err = SnpAttestation(&spb.Attestation{Report: reportProto(nil), CertificateChain: fullChain()},
+		&Options{TrustedRoots: rootOf(signer.Ark), Product: test.GetProduct(t), Getter: noNetwork{t}})
+	var linkErr *ChainLinkErr
+	if !errors.As(err, &linkErr) || linkErr.Signee != "VCEK" {
+		t.Errorf("SnpAttestation(bad ASK) = %v. Want a *ChainLinkErr for the VCEK", err)
+	}
+}
+
+func TestRootPins(t *testing.T) {
+	signMu.Do(initSigner)
+	productLine := test.GetProductLine()
+	report, err := abi.ReportToProto(vcekSignedReport(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation := &spb.Attestation{
+		Report:           report,
+		CertificateChain: &spb.CertificateChain{VcekCert: signer.Vcek.Raw, AskCert: signer.Ask.Raw, ArkCert: signer.Ark.Raw},
+	}
+	fingerprint := func(cert *x509.Certificate) string {
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:])
+	}
+	// The form that openssl x509 -fingerprint prints.
+	opensslFingerprint := func(cert *x509.Certificate) string {
+		sum := sha256.Sum256(cert.Raw)
+		var parts []string
+		for _, b := range sum {
+			parts = append(parts, fmt.Sprintf("%02X", b))
+		}
+		return strings.Join(parts, ":")
+	}
+	rootOf := func(ask *x509.Certificate) *trust.AMDRootCerts {
+		root := trust.AMDRootCertsProduct(productLine)
+		root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: ask}
+		return root
+	}
+	// The first root's ASK is not the one that signs the VCEK.
+	roots := map[string][]*trust.AMDRootCerts{productLine: {rootOf(signer.Ark), rootOf(signer.Ask)}}
+	tcs := []struct {
+		name    string
+		pins    *RootPins
+		wantErr error
+	}{
+		{name: "no pins"},
+		{name: "ARK", pins: &RootPins{ARK: []string{opensslFingerprint(signer.Ark)}}},
+		{name: "ARK and ASK", pins: &RootPins{ARK: []string{fingerprint(signer.Ark)}, ASK: []string{fingerprint(signer.Vcek), fingerprint(signer.Ask)}}},
+		{name: "another ARK", pins: &RootPins{ARK: []string{fingerprint(signer.Ask)}}, wantErr: ErrRootPinMismatch},
+		{name: "the first root's ASK", pins: &RootPins{ASK: []string{fingerprint(signer.Ark)}}, wantErr: ErrRootPinMismatch},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			options := &Options{TrustedRoots: roots, Product: test.GetProduct(t), Getter: noNetwork{t}}
+			if tc.pins != nil {
+				options.RootPins = map[string]*RootPins{productLine: tc.pins}
+			}
+			err := SnpAttestation(attestation, options)
+			if tc.wantErr == nil && err != nil {
+				t.Errorf("SnpAttestation() = %v. Want nil", err)
+			}
+			if tc.wantErr != nil && (!errors.Is(err, tc.wantErr) || !errors.Is(err, ErrChainVerification)) {
+				t.Errorf("SnpAttestation() = %v. Want an ErrChainVerification wrapping %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	// Pins of another product line do not apply.
+	options := &Options{TrustedRoots: roots, Product: test.GetProduct(t), Getter: noNetwork{t},
+		RootPins: map[string]*RootPins{"Turin": {ARK: []string{fingerprint(signer.Ask)}}}}
+	if err := SnpAttestation(attestation, options); err != nil {
+		t.Errorf("SnpAttestation(Turin pins) = %v. Want nil", err)
+	}
+	options.RootPins = map[string]*RootPins{productLine: {ARK: []string{"not a fingerprint"}}}
+	if err := SnpAttestation(attestation, options); err == nil || !strings.Contains(err.Error(), "is not hex") {
+		t.Errorf("SnpAttestation(bad pin) = %v. Want an error for the fingerprint", err)
+	}
+}
+
+func TestRootOfTrustCabundleDirectory(t *testing.T) {
+	signMu.Do(initSigner)
+	productLine := test.GetProductLine()
+	report, err := abi.ReportToProto(vcekSignedReport(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation := &spb.Attestation{
+		Report:           report,
+		CertificateChain: &spb.CertificateChain{VcekCert: signer.Vcek.Raw},
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"ark.der": signer.Ark.Raw,
+		"ask.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Ask.Raw}),
+		"README":  []byte("fake AMD root certificates\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The directory's roots are for the product line that the certificates name, whatever the
+	// RootOfTrust's product line.
+	options, err := RootOfTrustToOptions(&cpb.RootOfTrust{ProductLine: "Turin", CabundlePaths: []string{dir}, DisallowNetwork: true})
+	if err != nil {
+		t.Fatalf("RootOfTrustToOptions(directory) = _, %v. Want nil", err)
+	}
+	if len(options.TrustedRoots[productLine]) != 1 {
+		t.Fatalf("RootOfTrustToOptions(directory) TrustedRoots = %v. Want one %s root", options.TrustedRoots, productLine)
+	}
+	options.Product = test.GetProduct(t)
+	options.Getter = noNetwork{t}
+	if err := SnpAttestation(attestation, options); err != nil {
+		t.Errorf("SnpAttestation() = %v. Want nil", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "ark.der")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RootOfTrustToOptions(&cpb.RootOfTrust{CabundlePaths: []string{dir}}); err == nil {
+		t.Error("RootOfTrustToOptions(directory without an ARK) = _, nil. Want an error")
+	}
+}
+
+// vlekKDS serves a VLEK certificate as the KDS does for the CSP it was provisioned for.
+type vlekKDS struct {
+	trust.HTTPSGetter
+	vlekURL string
+	vlek    []byte
+}
+
+func (k *vlekKDS) Get(url string) ([]byte, error) {
+	if url == k.vlekURL {
+		return k.vlek, nil
+	}
+	return k.HTTPSGetter.Get(url)
+}
+
+func TestFetchVlekCert(t *testing.T) {
+	if !sg.UseDefaultSevGuest() {
+		t.Skip("VLEK-signed reports are only available from the fake device")
+	}
+	trust.ClearProductCertCache()
+	tests := test.TestCases()
+	qp, goodRoots, _, getter := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{Now: time.Now()}, t)
+	for _, tc := range tests {
+		if tc.EK != test.KeyChoiceVlek || tc.WantErr != "" {
+			continue
+		}
+		t.Run(tc.Name, func(t *testing.T) {
+			attestation, err := sg.GetQuoteProto(qp, tc.Input)
+			if err != nil {
+				t.Fatalf("GetQuoteProto(qp, %v) = _, %v. Want nil", tc.Input, err)
+			}
+			vlek := attestation.CertificateChain.VlekCert
+			attestation.CertificateChain.VlekCert = nil
+			// A table with the chip's VCEK but no VLEK is rejected without fetching, see
+			// TestVlekSignedWithOnlyVcek.
+			attestation.CertificateChain.VcekCert = nil
+			report := attestation.GetReport()
+			vlekURL := kds.VLEKCertURL("Milan", kds.TCBVersion(report.GetReportedTcb()))
+			options := &Options{
+				TrustedRoots: goodRoots,
+				Getter:       &vlekKDS{HTTPSGetter: getter, vlekURL: vlekURL, vlek: vlek},
+				Product:      test.GetProduct(t),
+			}
+			if err := SnpAttestation(attestation, options); err != nil {
+				t.Errorf("SnpAttestation(no VLEK, CSP getter) = %v. Want nil", err)
+			}
+			if !bytes.Equal(attestation.GetCertificateChain().GetVlekCert(), vlek) {
+				t.Error("SnpAttestation(no VLEK, CSP getter) did not fill in the downloaded VLEK")
+			}
+
+			attestation.CertificateChain.VlekCert = nil
+			options.Getter = getter
+			if err := SnpAttestation(attestation, options); !errors.Is(err, ErrMissingVlek) {
+				t.Errorf("SnpAttestation(no VLEK, getter without it) = %v. Want %v", err, ErrMissingVlek)
+			}
+		})
+	}
+}
+
+func TestVlekAgainstAsk(t *testing.T) {
+	if !sg.UseDefaultSevGuest() {
+		t.Skip("VLEK-signed reports are only available from the fake device")
+	}
+	trust.ClearProductCertCache()
+	tests := test.TestCases()
+	qp, goodRoots, _, getter := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{Now: time.Now()}, t)
+	// Trust roots that only have the VCEK chain's intermediate.
+	askRoots := make(map[string][]*trust.AMDRootCerts)
+	for productLine, roots := range goodRoots {
+		for _, root := range roots {
+			askRoot := trust.AMDRootCertsProduct(root.GetProductLine())
+			askRoot.ProductCerts = &trust.ProductCerts{Ask: root.ProductCerts.Ask, Ark: root.ProductCerts.Ark}
+			askRoots[productLine] = append(askRoots[productLine], askRoot)
+		}
+	}
+	for _, tc := range tests {
+		if tc.EK != test.KeyChoiceVlek || tc.WantErr != "" {
+			continue
+		}
+		t.Run(tc.Name, func(t *testing.T) {
+			attestation, err := sg.GetQuoteProto(qp, tc.Input)
+			if err != nil {
+				t.Fatalf("GetQuoteProto(qp, %v) = _, %v. Want nil", tc.Input, err)
+			}
+			options := &Options{
+				TrustedRoots:        askRoots,
+				Getter:              getter,
+				Product:             test.GetProduct(t),
+				DisableCertFetching: true,
+			}
+			err = SnpAttestation(attestation, options)
+			if !errors.Is(err, trust.ErrIntermediateMismatch) || !strings.Contains(err.Error(), "ASVK") {
+				t.Errorf("SnpAttestation(VLEK report, ASK-only roots) = %v. Want an error naming the ASVK that wraps %v",
+					err, trust.ErrIntermediateMismatch)
+			}
+		})
+	}
+}
+
+// TestGetQuoteProviderVerify tests the SnpAttestation function for the configfs-tsm report API.
+func TestGetQuoteProviderVerify(t *testing.T) {
+	trust.ClearProductCertCache()
+	tests := test.TestCases()
+	qp, goodRoots, badRoots, kds := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{Now: time.Now()}, t)
+	// Trust the test device's root certs.
+	options := &Options{
+		TrustedRoots:        goodRoots,
+		Getter:              kds,
+		Product:             test.GetProduct(t),
+		DisableCertFetching: *requireCache && !sg.UseDefaultSevGuest(),
+	}
+	badOptions := &Options{TrustedRoots: badRoots, Getter: kds, Product: test.GetProduct(t)}
+	for _, tc := range tests {
+		// configfs-tsm doesn't support the key choice parameter for getting an attestation report, and
+		// it doesn't return firmware error codes.
+		if testclient.SkipUnmockableTestCase(&tc) || tc.EK == test.KeyChoiceVlek {
+			t.Run(tc.Name, func(t *testing.T) { t.Skip() })
+			continue
+		}
+		t.Run(tc.Name+"_", func(t *testing.T) {
+			reportcerts, err := qp.GetRawQuote(tc.Input)
+			ereport, _ := abi.ReportCertsToProto(reportcerts)
+			if tc.FwErr != abi.Success {
+				if err == nil {
+					t.Fatalf("(d, %v) = %v. Unexpected success given firmware error: %v", tc.Input, ereport, tc.FwErr)
+				}
+			} else if !test.Match(err, tc.WantErr) {
+				t.Fatalf("(d, %v) = %v, %v. Want err: %v", tc.Input, ereport, err, tc.WantErr)
+			}
+			if tc.WantErr == "" {
+				var wantAttestationErr string
+				if err := SnpAttestation(ereport, options); !test.Match(err, wantAttestationErr) {
+					t.Errorf("SnpAttestation(%v) = %v. Want err: %q", ereport, err, wantAttestationErr)
+				}
+
+				wantBad := "error verifying VCEK certificate"
+				if err := SnpAttestation(ereport, badOptions); !test.Match(err, wantBad) {
+					t.Errorf("SnpAttestation(_) bad root test errored unexpectedly: %v, want %s",
+						err, wantBad)
+				}
+			}
+		})
+	}
+}
+
+func TestGetQuoteProviderVerifyProductNameSteppingMismatch(t *testing.T) {
+	if !sg.UseDefaultSevGuest() {
+		t.Skip("Cannot override true cpuid in hardware for negative testing")
+		return
+	}
+	trust.ClearProductCertCache()
+	tests := test.TestCases()
+	signerMilan0, err := test.DefaultTestOnlyCertChain("Milan-B0", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	qp, goodRoots, _, kds := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{
+		Now:    time.Now(),
+		Signer: signerMilan0,
+		// Mismatch cpuid product with certs.
+		Product: &spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(1)},
+	}, t)
+	tc := tests[0]
+	options := &Options{
+		TrustedRoots:        goodRoots,
+		Getter:              kds,
+		DisableCertFetching: *requireCache && !sg.UseDefaultSevGuest(),
+	}
+	withProduct := func(p *spb.SevProduct) *Options {
+		op := *options
+		op.Product = p
+		return &op
+	}
+	reportcerts, err := qp.GetRawQuote(tc.Input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ereport, _ := abi.ReportCertsToProto(reportcerts)
+
+	ops := []struct {
+		name          string
+		options       *Options
+		workaround115 bool
+		wantErr       string
+	}{
+		{
+			name:          "no product expectation with workaround",
+			options:       withProduct(nil),
+			workaround115: true,
+		},
+		{
+			name:    "no product expectation without workaround",
+			options: withProduct(nil),
+			wantErr: "0x0 is not 0x1", // decodeCerts error
+		},
+		{
+			name:          "Milan expectation without stepping, with workaround",
+			options:       withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN}),
+			workaround115: true,
+		},
+		{
+			name:    "Milan expectation without stepping without workaround",
+			options: withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN}),
+			wantErr: "0x0 is not 0x1", // decodeCerts error
+		},
+		{
+			name:    "Milan-B1 expectation without workaround",
+			options: withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(1)}),
+			wantErr: "0x0 is not 0x1",
+		},
+		{
+			name:          "Milan-B0 expectation with workaround",
+			options:       withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(0)}),
+			workaround115: true,
+			// an explicit expectation should not step this check against CPUID.
+			wantErr: "expected product stepping 0, got 1",
+		},
+		{
+			name:    "Milan-B0 expectation without workaround",
+			options: withProduct(&spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_MILAN, MachineStepping: wrapperspb.UInt32(0)}),
 			wantErr: "expected product stepping 0, got 1",
 		},
 	}
@@ -702,6 +1823,63 @@ func TestRealAttestationVerification(t *testing.T) {
 	}
 }
 
+func TestRawSnpAttestation(t *testing.T) {
+	trust.ClearProductCertCache()
+	ask, ark, err := kds.ParseProductCertChain(testdata.MilanVcekBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := abi.CertTableFromProto(&spb.CertificateChain{
+		VcekCert: testdata.VcekBytes,
+		AskCert:  ask,
+		ArkCert:  ark,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certTable := certs.Marshal()
+	opts := func() *Options {
+		return &Options{
+			Getter: noNetwork{t},
+			Product: &spb.SevProduct{
+				Name:            spb.SevProduct_SEV_PRODUCT_MILAN,
+				MachineStepping: &wrapperspb.UInt32Value{Value: 0},
+			},
+		}
+	}
+	if err := RawSnpAttestation(testdata.AttestationBytes, certTable, opts()); err != nil {
+		t.Fatalf("RawSnpAttestation(real report, its certificates) = %v. Want nil", err)
+	}
+
+	badVersion := append([]byte{}, testdata.AttestationBytes...)
+	badVersion[0] = 0xff
+	tcs := []struct {
+		name      string
+		report    []byte
+		certTable []byte
+		wantErr   error
+		wantMsg   string
+	}{
+		{name: "short report", report: testdata.AttestationBytes[:abi.ReportSize-1], certTable: certTable, wantErr: abi.ErrReportSize},
+		{name: "bad version", report: badVersion, certTable: certTable, wantErr: abi.ErrReportVersion},
+		{name: "bad certificate table", report: testdata.AttestationBytes, certTable: []byte{1, 2, 3}, wantMsg: "certificate table"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := RawSnpAttestation(tc.report, tc.certTable, opts())
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("RawSnpAttestation() = %v. Want %v", err, tc.wantErr)
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantMsg) {
+				t.Errorf("RawSnpAttestation() = %v. Want an error containing %q", err, tc.wantMsg)
+			}
+		})
+	}
+	if err := RawSnpReport(testdata.AttestationBytes[:10], opts()); !errors.Is(err, abi.ErrReportSize) {
+		t.Errorf("RawSnpReport(short report) = %v. Want %v", err, abi.ErrReportSize)
+	}
+}
+
 func TestKDSCertBackdated(t *testing.T) {
 	if !test.TestUseKDS() {
 		t.Skip()
@@ -723,3 +1901,298 @@ func TestKDSCertBackdated(t *testing.T) {
 			cert.NotBefore.Format(time.RFC3339), now.Format(time.RFC3339))
 	}
 }
+
+func TestGetAttestationFromReportUnknownProduct(t *testing.T) {
+	options := &Options{
+		Getter:  test.SimpleGetter(nil),
+		Product: &spb.SevProduct{Name: spb.SevProduct_SEV_PRODUCT_UNKNOWN},
+	}
+	if _, err := GetAttestationFromReport(&spb.Report{}, options); !errors.Is(err, kds.ErrUnknownProduct) {
+		t.Errorf("GetAttestationFromReport(_, unknown product) = _, %v. Want %v", err, kds.ErrUnknownProduct)
+	}
+}
+
+func TestGetAttestationFromReportContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := &spb.Report{ChipId: bytes.Repeat([]byte{1}, abi.ChipIDSize)}
+	options := &Options{Getter: test.SimpleGetter(nil), Product: abi.DefaultSevProduct()}
+	if _, err := GetAttestationFromReportContext(ctx, report, options); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAttestationFromReportContext(cancelled, _, _) = _, %v. Want %v", err, context.Canceled)
+	}
+}
+
+func TestValidateCRLlinkMirror(t *testing.T) {
+	amd := &x509.Certificate{CRLDistributionPoints: []string{kds.DefaultBaseURL + "/vcek/v1/Milan/crl"}}
+	if err := kds.SetBaseURL("https://kds.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer kds.SetBaseURL("")
+	mirrored := &x509.Certificate{CRLDistributionPoints: []string{"https://kds.example.com/vcek/v1/Milan/crl"}}
+	for _, cert := range []*x509.Certificate{amd, mirrored} {
+		if err := validateCRLlink(cert, "Milan", "ASK"); err != nil {
+			t.Errorf("validateCRLlink(%v, Milan, ASK) = %v. Want nil", cert.CRLDistributionPoints, err)
+		}
+	}
+	if err := validateCRLlink(amd, "Milan", "ASVK"); err == nil {
+		t.Errorf("validateCRLlink(%v, Milan, ASVK) = nil. Want an error for the VCEK chain's CRL", amd.CRLDistributionPoints)
+	}
+	if err := validateCRLlink(amd, "Genoa", "ASK"); err == nil {
+		t.Errorf("validateCRLlink(%v, Genoa, ASK) = nil. Want an error for Milan's CRL", amd.CRLDistributionPoints)
+	}
+}
+
+// noNetwork fails the test on any fetch.
+// testCRL returns a CRL that the test signer's ARK issued at thisUpdate, due at nextUpdate, and that
+// revokes serials as of thisUpdate.
+func testCRL(t *testing.T, thisUpdate, nextUpdate time.Time, serials ...*big.Int) []byte {
+	t.Helper()
+	var revoked []pkix.RevokedCertificate
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: thisUpdate})
+	}
+	crl, err := x509.CreateRevocationList(rand.New(rand.NewSource(0xc0de)), &x509.RevocationList{
+		SignatureAlgorithm:  x509.SHA384WithRSAPSS,
+		Number:              big.NewInt(1),
+		ThisUpdate:          thisUpdate,
+		NextUpdate:          nextUpdate,
+		RevokedCertificates: revoked,
+	}, signer.Ark, signer.Keys.Ark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+type noNetwork struct{ t *testing.T }
+
+func (n noNetwork) Get(url string) ([]byte, error) {
+	n.t.Errorf("unexpected fetch of %s", url)
+	return nil, fmt.Errorf("no network: %s", url)
+}
+
+// endorsementDB is a CertProvider of the test signer's certificates that records what it is asked
+// for, like an endorsement database keyed by CHIP_ID.
+type endorsementDB struct {
+	vceks map[string]*x509.Certificate
+	asked []string
+}
+
+func (db *endorsementDB) VCEK(_ context.Context, productLine string, hwid []byte, tcb kds.TCBVersion) (*x509.Certificate, error) {
+	db.asked = append(db.asked, fmt.Sprintf("VCEK %s %x %x", productLine, hwid, uint64(tcb)))
+	if cert, ok := db.vceks[string(hwid)]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no VCEK for chip %x", hwid)
+}
+
+func (db *endorsementDB) ProductChain(_ context.Context, productLine string, key abi.ReportSigner) (*trust.ProductCerts, error) {
+	db.asked = append(db.asked, fmt.Sprintf("chain %s %v", productLine, key))
+	return &trust.ProductCerts{Ask: signer.Ask, Asvk: signer.Asvk, Ark: signer.Ark}, nil
+}
+
+func TestCertProvider(t *testing.T) {
+	signMu.Do(initSigner)
+	chipID := bytes.Repeat([]byte{0x42}, abi.ChipIDSize)
+	db := &endorsementDB{vceks: map[string]*x509.Certificate{string(chipID): signer.Vcek}}
+	options := &Options{
+		CertProvider: db,
+		Getter:       noNetwork{t},
+		TrustedRoots: map[string][]*trust.AMDRootCerts{"Milan": {trust.AMDRootCertsProduct("Milan")}},
+		Product:      abi.DefaultSevProduct(),
+	}
+	attestation := &spb.Attestation{Report: &spb.Report{ChipId: chipID, ReportedTcb: 0x1234}}
+	if _, err := fillInAttestation(context.Background(), attestation, options); err != nil {
+		t.Fatalf("fillInAttestation() = %v. Want nil", err)
+	}
+	chain := attestation.GetCertificateChain()
+	if !bytes.Equal(chain.GetVcekCert(), signer.Vcek.Raw) || !bytes.Equal(chain.GetAskCert(), signer.Ask.Raw) || !bytes.Equal(chain.GetArkCert(), signer.Ark.Raw) {
+		t.Error("fillInAttestation() did not fill in the provided VCEK, ASK, and ARK")
+	}
+	want := []string{"chain Milan VCEK", fmt.Sprintf("VCEK Milan %x 1234", chipID)}
+	if len(db.asked) != len(want) || db.asked[0] != want[0] || db.asked[1] != want[1] {
+		t.Errorf("CertProvider was asked for %v. Want %v", db.asked, want)
+	}
+
+	// The provider does not provide VLEK certificates, so a VLEK-signed report must include its own.
+	vlekSigned := &spb.Attestation{Report: &spb.Report{SignerInfo: abi.ComposeSignerInfo(abi.SignerInfo{SigningKey: abi.VlekReportSigner})}}
+	if _, err := fillInAttestation(context.Background(), vlekSigned, options); !errors.Is(err, ErrMissingVlek) {
+		t.Errorf("fillInAttestation(VLEK-signed, no VLEKCertProvider) = %v. Want %v", err, ErrMissingVlek)
+	}
+}
+
+// vlekDB is an endorsementDB that also provides VLEK certificates.
+type vlekDB struct{ endorsementDB }
+
+func (db *vlekDB) VLEK(_ context.Context, productLine string, tcb kds.TCBVersion) (*x509.Certificate, error) {
+	db.asked = append(db.asked, fmt.Sprintf("VLEK %s %x", productLine, uint64(tcb)))
+	return signer.Vlek, nil
+}
+
+func TestVlekSignedWithOnlyVcek(t *testing.T) {
+	signMu.Do(initSigner)
+	vlekSignerInfo := abi.ComposeSignerInfo(abi.SignerInfo{SigningKey: abi.VlekReportSigner})
+	db := &vlekDB{}
+	for _, tc := range []struct {
+		name    string
+		options *Options
+	}{
+		{name: "Getter", options: &Options{Getter: noNetwork{t}}},
+		{name: "VLEKCertProvider", options: &Options{CertProvider: db, Getter: noNetwork{t}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.options.Product = abi.DefaultSevProduct()
+			tc.options.TrustedRoots = map[string][]*trust.AMDRootCerts{"Milan": {trust.AMDRootCertsProduct("Milan")}}
+			attestation := &spb.Attestation{
+				Report:           &spb.Report{SignerInfo: vlekSignerInfo},
+				CertificateChain: &spb.CertificateChain{VcekCert: signer.Vcek.Raw},
+			}
+			_, err := fillInAttestation(context.Background(), attestation, tc.options)
+			if !errors.Is(err, ErrMissingVlek) || !errors.Is(err, ErrCertFetch) || !strings.Contains(err.Error(), "only has a VCEK certificate") {
+				t.Errorf("fillInAttestation(VLEK-signed, only a VCEK) = %v. Want %v naming the VCEK certificate", err, ErrMissingVlek)
+			}
+			if len(db.asked) != 0 {
+				t.Errorf("CertProvider was asked for %v. Want nothing", db.asked)
+			}
+		})
+	}
+}
+
+func TestSnpAttestationFetchesOnlyMissingCerts(t *testing.T) {
+	trust.ClearProductCertCache()
+	tests := test.TestCases()
+	qp, goodRoots, badRoots, kds := testclient.GetSevQuoteProvider(tests, &test.DeviceOptions{Now: time.Now()}, t)
+	for _, tc := range tests {
+		if testclient.SkipUnmockableTestCase(&tc) || tc.EK == test.KeyChoiceVlek || tc.WantErr != "" {
+			continue
+		}
+		t.Run(tc.Name, func(t *testing.T) {
+			attestation, err := sg.GetQuoteProto(qp, tc.Input)
+			if err != nil {
+				t.Fatalf("GetQuoteProto(qp, %v) = _, %v. Want nil", tc.Input, err)
+			}
+			chain := attestation.GetCertificateChain()
+			if len(chain.GetVcekCert()) == 0 || len(chain.GetAskCert()) == 0 || len(chain.GetArkCert()) == 0 {
+				t.Skip("the quote provider does not supply the whole certificate chain")
+			}
+			options := &Options{TrustedRoots: goodRoots, Getter: noNetwork{t}, Product: test.GetProduct(t)}
+			if err := SnpAttestation(attestation, options); err != nil {
+				t.Errorf("SnpAttestation(full chain) = %v. Want nil", err)
+			}
+
+			// A certificate that the trusted ASK did not sign names that link.
+			var linkErr *ChainLinkErr
+			badOptions := &Options{TrustedRoots: badRoots, Getter: noNetwork{t}, Product: test.GetProduct(t)}
+			if err := SnpAttestation(attestation, badOptions); !errors.As(err, &linkErr) || linkErr.Signer != "ASK" || linkErr.Signee != "VCEK" {
+				t.Errorf("SnpAttestation(bad roots) = %v. Want a *ChainLinkErr for the ASK's signature on the VCEK", err)
+			}
+
+			// The signature is over the report's bytes, so any change to them breaks the last link.
+			attestation.Report.ReportData[0] ^= 0xff
+			if err := SnpAttestation(attestation, options); !errors.As(err, &linkErr) || linkErr.Signer != "VCEK" || linkErr.Signee != "report" {
+				t.Errorf("SnpAttestation(altered report) = %v. Want a *ChainLinkErr for the VCEK's signature on the report", err)
+			}
+			attestation.Report.ReportData[0] ^= 0xff
+
+			// The chain's missing links are fetched with the getter.
+			if abi.IsChipIDMasked(attestation.GetReport().GetChipId()) {
+				return
+			}
+			chain.VcekCert = nil
+			options = &Options{TrustedRoots: goodRoots, Getter: kds, Product: test.GetProduct(t)}
+			if err := SnpAttestation(attestation, options); err != nil {
+				t.Errorf("SnpAttestation(no VCEK) = %v. Want nil", err)
+			}
+		})
+	}
+}
+
+func TestVerifyChainLinks(t *testing.T) {
+	now := time.Now()
+	s1, err := test.DefaultTestOnlyCertChain("Milan-B1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := test.DefaultTestOnlyCertChain("Milan-B1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyChainLinks(s1.Ark, s1.Ask, s1.Vcek, abi.VcekReportSigner); err != nil {
+		t.Fatalf("verifyChainLinks(good chain) = %v. Want nil", err)
+	}
+	tcs := []struct {
+		name           string
+		ark, ica, cert *x509.Certificate
+		signer, signee string
+	}{
+		{name: "ARK not self-signed", ark: s1.Ask, ica: s1.Ask, cert: s1.Vcek, signer: "ARK", signee: "ARK"},
+		{name: "ASK from another ARK", ark: s1.Ark, ica: s2.Ask, cert: s2.Vcek, signer: "ARK", signee: "ASK"},
+		{name: "VCEK from another ASK", ark: s1.Ark, ica: s1.Ask, cert: s2.Vcek, signer: "ASK", signee: "VCEK"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyChainLinks(tc.ark, tc.ica, tc.cert, abi.VcekReportSigner)
+			var linkErr *ChainLinkErr
+			if !errors.As(err, &linkErr) || linkErr.Signer != tc.signer || linkErr.Signee != tc.signee {
+				t.Errorf("verifyChainLinks() = %v. Want the %s's signature on the %s to fail", err, tc.signer, tc.signee)
+			}
+		})
+	}
+}
+
+func TestOptionsNow(t *testing.T) {
+	fixed := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return fixed.Add(time.Hour) }
+	if got := (&Options{}).now(); time.Since(got) > time.Minute {
+		t.Errorf("(&Options{}).now() = %v. Want about time.Now()", got)
+	}
+	if got := (&Options{Clock: clock}).now(); !got.Equal(fixed.Add(time.Hour)) {
+		t.Errorf("(&Options{Clock}).now() = %v. Want %v", got, fixed.Add(time.Hour))
+	}
+	if got := (&Options{Now: fixed, Clock: clock}).now(); !got.Equal(fixed) {
+		t.Errorf("(&Options{Now, Clock}).now() = %v. Want Now, %v", got, fixed)
+	}
+}
+
+// unreachable is a getter for a verifier that cannot reach the KDS.
+type unreachable struct{}
+
+func (unreachable) Get(url string) ([]byte, error) {
+	return nil, fmt.Errorf("unreachable: %s", url)
+}
+
+func TestCheckRevocationsWithoutCRL(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	root := trust.AMDRootCertsProduct("Milan")
+	root.CRL = &x509.RevocationList{NextUpdate: now.Add(24 * time.Hour)}
+	roots := map[string][]*trust.AMDRootCerts{"Milan": {root}}
+	attestation := &spb.Attestation{Report: &spb.Report{}}
+
+	offline := &Options{CheckRevocations: true, DisableCertFetching: true, Getter: noNetwork{t}}
+	if err := SnpAttestation(attestation, offline); !errors.Is(err, ErrNoCRL) {
+		t.Errorf("SnpAttestation(no CRL source) = %v. Want %v", err, ErrNoCRL)
+	}
+	offline.TrustedRoots = roots
+	offline.Clock = func() time.Time { return now }
+	if err := offline.check(); err != nil {
+		t.Errorf("check() with a current cached CRL = %v. Want nil", err)
+	}
+	offline.Clock = func() time.Time { return now.Add(48 * time.Hour) }
+	if err := SnpAttestation(attestation, offline); !errors.Is(err, ErrNoCRL) {
+		t.Errorf("SnpAttestation(stale cached CRL) = %v. Want %v", err, ErrNoCRL)
+	}
+	var unavailable CRLUnavailableErr
+	if _, err := GetCrlAndCheckRoot(root, offline); !errors.As(err, &unavailable) {
+		t.Errorf("GetCrlAndCheckRoot(stale cached CRL, no downloads) = %v. Want a CRLUnavailableErr", err)
+	}
+
+	// A stale cached CRL is downloaded again rather than used.
+	online := &Options{CheckRevocations: true, Getter: unreachable{}, Now: now.Add(48 * time.Hour)}
+	if _, err := GetCrlAndCheckRoot(root, online); !errors.As(err, &unavailable) {
+		t.Errorf("GetCrlAndCheckRoot(stale cached CRL, unreachable KDS) = %v. Want a CRLUnavailableErr", err)
+	}
+	online.Now = now
+	if crl, err := GetCrlAndCheckRoot(root, online); err != nil || crl != root.CRL {
+		t.Errorf("GetCrlAndCheckRoot(current cached CRL) = %v, %v. Want the cached CRL", crl, err)
+	}
+}